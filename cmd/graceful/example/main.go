@@ -1,3 +1,6 @@
+// +build !windows
+
+// 通过fork子进程并传递监听socket实现平滑重启, 依赖syscall.ForkExec, 不支持windows
 package main
 
 import (
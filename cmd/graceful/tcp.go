@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/weibocom/wqs/log"
@@ -30,7 +31,7 @@ var (
 
 	// Be happy for test
 	defaultHandle   = mockHandleConn
-	defaultUnixSock = "/tmp/wqs_unix_sock_restart.sock"
+	defaultUnixSock = filepath.Join(os.TempDir(), "wqs_unix_sock_restart.sock")
 )
 
 type ServerOption func(opt *Option)
@@ -0,0 +1,106 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// 每个2的幂区间分为16个桶, 相对误差不超过1/16
+const (
+	subBuckets = 16
+	subBits    = 4
+	numBuckets = (64 - subBits) * subBuckets
+)
+
+// 记录微秒级的延迟, 可以并发使用
+type histogram struct {
+	counts [numBuckets]int64
+	total  int64
+	sum    int64
+	max    int64
+}
+
+func bucketOf(v int64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBits - 1
+	return (shift+1)*subBuckets + int(v>>uint(shift)) - subBuckets
+}
+
+// 桶中的最大值
+func bucketMax(i int) int64 {
+	if i < subBuckets {
+		return int64(i)
+	}
+	shift := uint(i/subBuckets - 1)
+	return (int64(i%subBuckets+subBuckets+1) << shift) - 1
+}
+
+func (h *histogram) observe(d time.Duration) {
+	v := int64(d / time.Microsecond)
+	if v < 0 {
+		v = 0
+	}
+	atomic.AddInt64(&h.counts[bucketOf(v)], 1)
+	atomic.AddInt64(&h.total, 1)
+	atomic.AddInt64(&h.sum, v)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if v <= max || atomic.CompareAndSwapInt64(&h.max, max, v) {
+			return
+		}
+	}
+}
+
+func (h *histogram) count() int64 {
+	return atomic.LoadInt64(&h.total)
+}
+
+func (h *histogram) mean() time.Duration {
+	total := atomic.LoadInt64(&h.total)
+	if total == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&h.sum)/total) * time.Microsecond
+}
+
+// p为0~1, 返回所在桶的最大值, 不超过记录的最大值
+func (h *histogram) percentile(p float64) time.Duration {
+	total := atomic.LoadInt64(&h.total)
+	if total == 0 {
+		return 0
+	}
+	target := int64(float64(total)*p + 0.5)
+	if target < 1 {
+		target = 1
+	}
+	max := atomic.LoadInt64(&h.max)
+	var n int64
+	for i := range h.counts {
+		if n += atomic.LoadInt64(&h.counts[i]); n >= target {
+			if v := bucketMax(i); v < max {
+				return time.Duration(v) * time.Microsecond
+			}
+			break
+		}
+	}
+	return time.Duration(max) * time.Microsecond
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	docopt "github.com/docopt/docopt-go"
+	"github.com/juju/errors"
+	"github.com/weibocom/wqs/utils"
+)
+
+var usage = `usage: wqs-bench [options] <queue> <group>
+
+options:
+	--host=<ADDR>              proxy http address (default 127.0.0.1:8080)
+	--token=<TOKEN>            auth token, required when proxy enable auth
+	--producers=<NUM>          concurrent producers (default 10)
+	--consumers=<NUM>          concurrent consumers (default 10)
+	--rate=<NUM>               total send rate per second, 0 for unlimited (default 0)
+	--size=<BYTES>             message size (default 500)
+	--time=<SECONDS>           benchmark duration (default 60)
+	--interval=<SECONDS>       progress report interval (default 5)
+
+Producers send messages through /msg with the send time in the message,
+consumers receive them through /msg, so the end to end latency is measured
+when both producers and consumers are running. Set --producers=0 or
+--consumers=0 to benchmark only one side.
+`
+
+func fatal(msg interface{}) {
+
+	switch msg.(type) {
+	case string:
+		log.Print(msg)
+	case error:
+		log.Print(errors.ErrorStack(msg.(error)))
+	}
+	os.Exit(-1)
+}
+
+func argString(args map[string]interface{}, key string) string {
+	if v, ok := args[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+func main() {
+
+	args, err := docopt.Parse(usage, nil, true, "wqs-bench v0.1", false)
+	if err != nil {
+		fatal(err)
+	}
+
+	b := &bench{
+		host:  "127.0.0.1:8080",
+		token: argString(args, "--token"),
+		queue: argString(args, "<queue>"),
+		group: argString(args, "<group>"),
+	}
+	if v := argString(args, "--host"); v != "" {
+		b.host = v
+	}
+
+	ints := []struct {
+		key   string
+		value *int
+		dft   int
+	}{
+		{"--producers", &b.producers, 10},
+		{"--consumers", &b.consumers, 10},
+		{"--rate", &b.rate, 0},
+		{"--size", &b.size, 500},
+	}
+	for _, i := range ints {
+		if *i.value, err = utils.GetIntFromArgs(args, i.key, i.dft); err != nil {
+			fatal(err)
+		}
+		if *i.value < 0 {
+			fatal(i.key + " should not be negative")
+		}
+	}
+	if b.producers == 0 && b.consumers == 0 {
+		fatal("no producer or consumer")
+	}
+
+	seconds, err := utils.GetIntFromArgs(args, "--time", 60)
+	if err != nil || seconds <= 0 {
+		fatal("--time should be a positive number")
+	}
+	interval, err := utils.GetIntFromArgs(args, "--interval", 5)
+	if err != nil || interval <= 0 {
+		fatal("--interval should be a positive number")
+	}
+	b.duration = time.Duration(seconds) * time.Second
+	b.interval = time.Duration(interval) * time.Second
+
+	if err := b.run(); err != nil {
+		fatal(err)
+	}
+}
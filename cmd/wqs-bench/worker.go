@@ -0,0 +1,275 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/weibocom/wqs/utils"
+)
+
+// 兼容接口/msg的返回结果
+type actionResult struct {
+	Action    string `json:"action"`
+	Result    bool   `json:"result"`
+	Msg       string `json:"msg"`
+	ErrorCode string `json:"error_code"`
+	Error     string `json:"error"`
+}
+
+type bench struct {
+	host      string
+	token     string
+	queue     string
+	group     string
+	producers int
+	consumers int
+	rate      int
+	size      int
+	duration  time.Duration
+	interval  time.Duration
+
+	client   *http.Client
+	stopped  int32
+	sent     int64
+	received int64
+	empty    int64
+	failures int64
+	lastErr  atomic.Value
+
+	sendLatency histogram
+	recvLatency histogram
+	endToEnd    histogram
+}
+
+func (b *bench) running() bool {
+	return atomic.LoadInt32(&b.stopped) == 0
+}
+
+func (b *bench) fail(err error) {
+	atomic.AddInt64(&b.failures, 1)
+	b.lastErr.Store(err.Error())
+}
+
+func (b *bench) call(req *http.Request) (*actionResult, error) {
+	if b.token != "" {
+		req.Header.Set("X-Wqs-Token", b.token)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("http code %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	result := &actionResult{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, errors.Errorf("bad response %q", data)
+	}
+	return result, nil
+}
+
+// 消息的格式为"发送时间(纳秒)|填充", 消费时计算端到端延迟
+func (b *bench) message() string {
+	prefix := strconv.FormatInt(time.Now().UnixNano(), 10) + "|"
+	if pad := b.size - len(prefix); pad > 0 {
+		return prefix + utils.GenTestMessage(pad)
+	}
+	return prefix
+}
+
+func (b *bench) send() error {
+	values := url.Values{
+		"action": {"send"},
+		"queue":  {b.queue},
+		"group":  {b.group},
+		"msg":    {b.message()},
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/msg", b.host), strings.NewReader(values.Encode()))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	result, err := b.call(req)
+	if err != nil {
+		return err
+	}
+	if !result.Result {
+		return errors.Errorf("send: %s %s", result.ErrorCode, result.Error)
+	}
+	return nil
+}
+
+// 没有消息时返回false, 通过/msg接收的消息会被自动ACK
+func (b *bench) receive() (bool, error) {
+	values := url.Values{
+		"action": {"receive"},
+		"queue":  {b.queue},
+		"group":  {b.group},
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/msg?%s", b.host, values.Encode()), nil)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	result, err := b.call(req)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case result.ErrorCode == "NO_MESSAGE":
+		return false, nil
+	case result.ErrorCode != "":
+		return false, errors.Errorf("receive: %s %s", result.ErrorCode, result.Error)
+	case result.Msg == "":
+		// group暂停或者本proxy没有分配到partition
+		return false, nil
+	}
+	if i := strings.IndexByte(result.Msg, '|'); i > 0 {
+		if sent, err := strconv.ParseInt(result.Msg[:i], 10, 64); err == nil {
+			b.endToEnd.observe(time.Duration(time.Now().UnixNano() - sent))
+		}
+	}
+	return true, nil
+}
+
+// 限速时每个producer平分总速率, 按固定间隔发送
+func (b *bench) produce(wg *sync.WaitGroup) {
+	defer wg.Done()
+	var interval time.Duration
+	if b.rate > 0 {
+		interval = time.Duration(b.producers) * time.Second / time.Duration(b.rate)
+	}
+	next := time.Now()
+	for b.running() {
+		if interval > 0 {
+			if d := next.Sub(time.Now()); d > 0 {
+				time.Sleep(d)
+			}
+			next = next.Add(interval)
+		}
+		start := time.Now()
+		if err := b.send(); err != nil {
+			b.fail(err)
+			continue
+		}
+		b.sendLatency.observe(time.Since(start))
+		atomic.AddInt64(&b.sent, 1)
+	}
+}
+
+func (b *bench) consume(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for b.running() {
+		start := time.Now()
+		ok, err := b.receive()
+		switch {
+		case err != nil:
+			b.fail(err)
+		case !ok:
+			atomic.AddInt64(&b.empty, 1)
+		default:
+			b.recvLatency.observe(time.Since(start))
+			atomic.AddInt64(&b.received, 1)
+		}
+	}
+}
+
+func (b *bench) run() error {
+
+	conns := b.producers + b.consumers
+	b.client = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{MaxIdleConns: conns, MaxIdleConnsPerHost: conns},
+	}
+	fmt.Printf("Benchmark %s queue %s group %s: %d producers, %d consumers, rate %d/s, size %d bytes, %s\n",
+		b.host, b.queue, b.group, b.producers, b.consumers, b.rate, b.size, b.duration)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < b.producers; i++ {
+		wg.Add(1)
+		go b.produce(wg)
+	}
+	for i := 0; i < b.consumers; i++ {
+		wg.Add(1)
+		go b.consume(wg)
+	}
+
+	start := time.Now()
+	ticker := time.NewTicker(b.interval)
+	timer := time.NewTimer(b.duration)
+	lastSent, lastReceived, last := int64(0), int64(0), start
+	for done := false; !done; {
+		select {
+		case now := <-ticker.C:
+			sent, received := atomic.LoadInt64(&b.sent), atomic.LoadInt64(&b.received)
+			seconds := now.Sub(last).Seconds()
+			fmt.Printf("[%4.0fs] send %8.1f/s, receive %8.1f/s, failures %d\n", now.Sub(start).Seconds(),
+				float64(sent-lastSent)/seconds, float64(received-lastReceived)/seconds, atomic.LoadInt64(&b.failures))
+			lastSent, lastReceived, last = sent, received, now
+		case <-timer.C:
+			done = true
+		}
+	}
+	ticker.Stop()
+	atomic.StoreInt32(&b.stopped, 1)
+	wg.Wait()
+
+	b.report(time.Since(start))
+	return nil
+}
+
+func (b *bench) report(elapsed time.Duration) {
+	seconds := elapsed.Seconds()
+	fmt.Printf("\nBenchmark Result (%.1fs):\n", seconds)
+	fmt.Printf("\tsent     %d messages, %.1f msg/s, %.2f MB/s\n", b.sent, float64(b.sent)/seconds,
+		float64(b.sent)*float64(b.size)/seconds/(1<<20))
+	fmt.Printf("\treceived %d messages, %.1f msg/s, %d empty receives\n", b.received, float64(b.received)/seconds, b.empty)
+	fmt.Printf("\tfailures %d\n", b.failures)
+	if err, ok := b.lastErr.Load().(string); ok {
+		fmt.Printf("\tlast error: %s\n", err)
+	}
+	fmt.Printf("\n\t%-12s %10s %10s %10s %10s %10s %10s\n", "latency", "mean", "p50", "p90", "p99", "p999", "max")
+	for _, l := range []struct {
+		name string
+		h    *histogram
+	}{
+		{"send", &b.sendLatency},
+		{"receive", &b.recvLatency},
+		{"end to end", &b.endToEnd},
+	} {
+		if l.h.count() == 0 {
+			continue
+		}
+		fmt.Printf("\t%-12s %10s %10s %10s %10s %10s %10s\n", l.name, l.h.mean(), l.h.percentile(0.5),
+			l.h.percentile(0.9), l.h.percentile(0.99), l.h.percentile(0.999), l.h.percentile(1))
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// 兼容接口(/queue, /group, /msg)的返回结果
+type actionResult struct {
+	Action string `json:"action"`
+	Result bool   `json:"result"`
+	Msg    string `json:"msg"`
+}
+
+// REST接口的返回结果, 与service.ResponseMessage一致
+type restResult struct {
+	Code    int    `json:"code"`
+	Message string `json:"msg"`
+}
+
+func do(req *http.Request) ([]byte, error) {
+	if globalToken != "" {
+		req.Header.Set("X-Wqs-Token", globalToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return data, nil
+}
+
+// 调用兼容接口, 接口返回失败时返回错误
+func callAction(path string, values url.Values) (*actionResult, error) {
+	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s%s", globalHost, path),
+		strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	data, err := do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &actionResult{}
+	if err := json.Unmarshal(data, result); err != nil {
+		// 发送或接收失败时接口直接返回错误信息
+		return nil, errors.Errorf("%s %s: %s", path, values.Get("action"), bytes.TrimSpace(data))
+	}
+	if result.Action != "receive" && !result.Result {
+		return nil, errors.Errorf("%s %s failed", path, values.Get("action"))
+	}
+	return result, nil
+}
+
+func lookup(path string, values url.Values) ([]byte, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s%s?%s", globalHost, path, values.Encode()), nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return do(req)
+}
+
+func callREST(method string, path string, body interface{}) (string, error) {
+	buff := &bytes.Buffer{}
+	if body != nil {
+		if err := json.NewEncoder(buff).Encode(body); err != nil {
+			return "", errors.Trace(err)
+		}
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%s%s", globalHost, path), buff)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	data, err := do(req)
+	if err != nil {
+		return "", err
+	}
+
+	result := &restResult{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return "", errors.Annotatef(err, "bad response %q", data)
+	}
+	if result.Code >= http.StatusBadRequest {
+		return "", errors.Errorf("%s %s: %d %s", method, path, result.Code, result.Message)
+	}
+	return result.Message, nil
+}
+
+func printJSON(data []byte) {
+	buff := &bytes.Buffer{}
+	if err := json.Indent(buff, bytes.TrimSpace(data), "", "  "); err != nil {
+		os.Stdout.Write(data)
+		return
+	}
+	buff.WriteByte('\n')
+	buff.WriteTo(os.Stdout)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"log"
+	"os"
+
+	docopt "github.com/docopt/docopt-go"
+	"github.com/juju/errors"
+)
+
+var usage = `usage: wqs-cli [options] <command> [<args>...]
+
+options:
+	--host=<ADDR>              proxy http address (default 127.0.0.1:8080)
+	--token=<TOKEN>            auth token, required when proxy enable auth
+
+commands:
+	queue                      create, remove, update or list queues
+	group                      add, update, remove or list groups
+	offset                     reset group's offset
+	lag                        show accumulation of groups
+	send                       send a message
+	recv                       receive and ack a message
+`
+
+var (
+	globalHost  = "127.0.0.1:8080"
+	globalToken = ""
+)
+
+func fatal(msg interface{}) {
+
+	switch msg.(type) {
+	case string:
+		log.Print(msg)
+	case error:
+		log.Print(errors.ErrorStack(msg.(error)))
+	}
+	os.Exit(-1)
+}
+
+func main() {
+
+	args, err := docopt.Parse(usage, nil, true, "wqs-cli v0.1", true)
+	if err != nil {
+		fatal(err)
+	}
+
+	if v := args["--host"]; v != nil {
+		globalHost = v.(string)
+	}
+
+	if v := args["--token"]; v != nil {
+		globalToken = v.(string)
+	}
+
+	cmd := args["<command>"].(string)
+	cmdArgs := args["<args>"].([]string)
+
+	err = runCommand(cmd, cmdArgs)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func runCommand(cmd string, args []string) (err error) {
+	argv := make([]string, 1)
+	argv[0] = cmd
+	argv = append(argv, args...)
+	switch cmd {
+	case "queue":
+		return errors.Trace(cmdQueue(argv))
+	case "group":
+		return errors.Trace(cmdGroup(argv))
+	case "offset":
+		return errors.Trace(cmdOffset(argv))
+	case "lag":
+		return errors.Trace(cmdLag(argv))
+	case "send":
+		return errors.Trace(cmdSend(argv))
+	case "recv":
+		return errors.Trace(cmdRecv(argv))
+	}
+	return errors.NotSupportedf("See 'wqs-cli -h', command %s", cmd)
+}
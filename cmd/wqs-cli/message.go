@@ -0,0 +1,93 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	docopt "github.com/docopt/docopt-go"
+	"github.com/juju/errors"
+	"github.com/weibocom/wqs/utils"
+)
+
+func cmdSend(argv []string) error {
+	usage := `usage: wqs-cli send <queue> <group> <message> [options]
+
+options:
+	--count=<NUM>             send message count times (default 1)
+	--id=<ID>                 message id, duplicates in the dedup window are sent only once
+`
+	args, err := docopt.Parse(usage, argv, true, "", false)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	count, err := utils.GetIntFromArgs(args, "--count", 1)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	values := url.Values{
+		"action": {"send"},
+		"queue":  {argString(args, "<queue>")},
+		"group":  {argString(args, "<group>")},
+		"msg":    {argString(args, "<message>")},
+	}
+	if id := argString(args, "--id"); id != "" {
+		values.Set("msgid", id)
+	}
+	for i := 0; i < count; i++ {
+		if _, err := callAction("/msg", values); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	fmt.Printf("send %d messages OK\n", count)
+	return nil
+}
+
+// 通过/msg接口接收的消息会被自动ACK
+func cmdRecv(argv []string) error {
+	usage := `usage: wqs-cli recv <queue> <group> [options]
+
+options:
+	--count=<NUM>             receive count messages (default 1)
+`
+	args, err := docopt.Parse(usage, argv, true, "", false)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	count, err := utils.GetIntFromArgs(args, "--count", 1)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	values := url.Values{
+		"action": {"receive"},
+		"queue":  {argString(args, "<queue>")},
+		"group":  {argString(args, "<group>")},
+	}
+	for i := 0; i < count; i++ {
+		result, err := callAction("/msg", values)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		fmt.Println(result.Msg)
+	}
+	return nil
+}
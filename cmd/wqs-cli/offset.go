@@ -0,0 +1,139 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Shopify/sarama"
+	docopt "github.com/docopt/docopt-go"
+	"github.com/juju/errors"
+)
+
+type accumulationInfo struct {
+	Group    string `json:"group"`
+	Queue    string `json:"queue"`
+	Total    int64  `json:"total"`
+	Consumed int64  `json:"consumed"`
+}
+
+// 支持newest, oldest, RFC3339格式的时间以及毫秒时间戳
+func parseOffsetTime(s string) (int64, error) {
+	switch s {
+	case "newest":
+		return sarama.OffsetNewest, nil
+	case "oldest":
+		return sarama.OffsetOldest, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UnixNano() / 1e6, nil
+	}
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ms < 0 {
+		return 0, errors.NotValidf("time %q", s)
+	}
+	return ms, nil
+}
+
+func cmdOffset(argv []string) error {
+	usage := `usage: wqs-cli offset reset <queue> <group> [options]
+
+options:
+	--time=<TIME>             newest, oldest, RFC3339 time or unix milliseconds (default newest)
+`
+	args, err := docopt.Parse(usage, argv, true, "", false)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	t := argString(args, "--time")
+	if t == "" {
+		t = "newest"
+	}
+	offsetTime, err := parseOffsetTime(t)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	queue := argString(args, "<queue>")
+	group := argString(args, "<group>")
+	path := fmt.Sprintf("/queue/%s/%s/offset", queue, group)
+	if _, err := callREST("POST", path, map[string]int64{"time": offsetTime}); err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Printf("reset offset of queue %q group %q to %s OK\n", queue, group, t)
+	return nil
+}
+
+func cmdLag(argv []string) error {
+	usage := `usage: wqs-cli lag [<queue>] [options]
+
+options:
+	--group=<GROUP>           only show the given group
+`
+	args, err := docopt.Parse(usage, argv, true, "", false)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	msg, err := callREST("GET", "/accumulation", nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	infos := make([]accumulationInfo, 0)
+	if err := json.Unmarshal([]byte(msg), &infos); err != nil {
+		return errors.Trace(err)
+	}
+	sort.Sort(accumulationSlice(infos))
+
+	queue := argString(args, "<queue>")
+	group := argString(args, "--group")
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "QUEUE\tGROUP\tTOTAL\tCONSUMED\tLAG")
+	for _, info := range infos {
+		if (queue != "" && queue != info.Queue) || (group != "" && group != info.Group) {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n",
+			info.Queue, info.Group, info.Total, info.Consumed, info.Total-info.Consumed)
+	}
+	return w.Flush()
+}
+
+type accumulationSlice []accumulationInfo
+
+func (s accumulationSlice) Len() int {
+	return len(s)
+}
+
+func (s accumulationSlice) Less(i, j int) bool {
+	if s[i].Queue != s[j].Queue {
+		return s[i].Queue < s[j].Queue
+	}
+	return s[i].Group < s[j].Group
+}
+
+func (s accumulationSlice) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	docopt "github.com/docopt/docopt-go"
+	"github.com/juju/errors"
+)
+
+func argString(args map[string]interface{}, key string) string {
+	if v, ok := args[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+func cmdQueue(argv []string) error {
+	usage := `usage: wqs-cli queue (create|remove|update) <queue>
+       wqs-cli queue purge <queue>
+       wqs-cli queue list [<queue>] [--group=<GROUP>]
+
+options:
+	--group=<GROUP>           only show the given group
+`
+	args, err := docopt.Parse(usage, argv, true, "", false)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	queue := argString(args, "<queue>")
+	if args["list"].(bool) {
+		data, err := lookup("/queue", url.Values{
+			"action": {"lookup"},
+			"queue":  {queue},
+			"biz":    {argString(args, "--group")},
+		})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		printJSON(data)
+		return nil
+	}
+
+	// 清空消息, 保留queue和group的配置
+	if args["purge"].(bool) {
+		if _, err := callREST("DELETE", fmt.Sprintf("/queues/%s/messages", queue), nil); err != nil {
+			return errors.Trace(err)
+		}
+		fmt.Printf("purge queue %q OK\n", queue)
+		return nil
+	}
+
+	var action string
+	switch {
+	case args["create"].(bool):
+		action = "create"
+	case args["remove"].(bool):
+		action = "remove"
+	case args["update"].(bool):
+		action = "update"
+	}
+
+	if _, err := callAction("/queue", url.Values{"action": {action}, "queue": {queue}}); err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Printf("%s queue %q OK\n", action, queue)
+	return nil
+}
+
+func cmdGroup(argv []string) error {
+	usage := `usage: wqs-cli group (add|update) <group> <queue> [options]
+       wqs-cli group remove <group> <queue>
+       wqs-cli group list [<group>]
+
+options:
+	--write=<BOOL>            group can write to queue
+	--read=<BOOL>             group can read from queue
+	--url=<URL>               group's domain
+	--ips=<IPS>               ips of domain, split by ","
+`
+	args, err := docopt.Parse(usage, argv, true, "", false)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	group := argString(args, "<group>")
+	if args["list"].(bool) {
+		data, err := lookup("/group", url.Values{"action": {"lookup"}, "group": {group}})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		printJSON(data)
+		return nil
+	}
+
+	values := url.Values{
+		"group": {group},
+		"queue": {argString(args, "<queue>")},
+	}
+	switch {
+	case args["add"].(bool):
+		values.Set("action", "add")
+	case args["update"].(bool):
+		values.Set("action", "update")
+	case args["remove"].(bool):
+		values.Set("action", "remove")
+	}
+	// 未指定的参数不发送, update时保持原值
+	for _, key := range []string{"write", "read", "url", "ips"} {
+		if v := argString(args, "--"+key); v != "" {
+			values.Set(key, v)
+		}
+	}
+
+	if _, err := callAction("/group", values); err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Printf("%s group %q of queue %q OK\n", values.Get("action"), group, values.Get("queue"))
+	return nil
+}
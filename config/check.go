@@ -0,0 +1,548 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// 检查配置中的地址格式、zookeeper路径、数值范围和相互依赖的配置项, 一次返回全部问题.
+// 只检查启动时就能发现的错误, 避免在连接kafka或zookeeper时才失败
+func (c *Config) Check() error {
+	k := &checker{config: c}
+
+	k.integer("proxy", "id", 0, 1<<31-1)
+	k.port("protocol", "http.port")
+	k.port("protocol", "mc.port")
+	k.port("protocol", "motan.port")
+	k.integer("protocol", "mc.socket.buffer.recv", 1, 1<<31-1)
+	k.integer("protocol", "mc.socket.buffer.send", 1, 1<<31-1)
+
+	k.zookeeper("metadata", "zookeeper.connect", true)
+	k.root("metadata", "zookeeper.root", true)
+	if auth, ok := k.value("metadata", "zookeeper.auth"); ok && strings.Index(auth, ":") <= 0 {
+		k.errorf("metadata.zookeeper.auth: is not user:password")
+	}
+	k.boolean("metadata", "zookeeper.acl.world.read")
+	k.zookeeper("kafka", "zookeeper.connect", true)
+	k.root("kafka", "zookeeper.root", false)
+	k.integer("kafka", "topic.partitions", 1, 1<<31-1)
+	k.integer("kafka", "topic.replications", 1, 1<<15-1)
+	k.remotes()
+	k.sasls()
+	k.isrGuard()
+	k.integer("kafka", "producer.buffer.size", 0, -1)
+	if value, ok := k.value("kafka", "producer.overflow"); ok && value != "block" && value != "reject" && value != "drop" {
+		k.errorf("kafka.producer.overflow: %q is not block, reject or drop", value)
+	}
+	if k.boolean("kafka", "transaction.enable") {
+		if value, ok := k.value("kafka", "transaction.timeout"); ok {
+			if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+				k.errorf("kafka.transaction.timeout: %q is not a positive duration, eg: 10s", value)
+			}
+		}
+	}
+
+	if value, ok := k.value("log", "expire"); ok {
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			k.errorf("log.expire: %q is not a positive duration, eg: 72h", value)
+		}
+	}
+
+	k.metrics()
+
+	if k.boolean("alert", "enable") {
+		k.integer("alert", "lag.threshold", 0, -1)
+		k.integer("alert", "depth.threshold", 0, -1)
+		k.float("alert", "send.error.rate.threshold", 0, -1)
+		k.integer("alert", "stale.commit.minutes", 0, -1)
+		k.url("alert", "webhook.url")
+		k.boolean("alert", "zookeeper.record")
+	}
+
+	k.integer("dedup", "window", 1, -1)
+	k.integer("dedup", "size", 1, -1)
+	k.address("dedup", "redis.addr", false)
+	k.integer("dedup", "redis.db", 0, -1)
+
+	failures := k.integer("breaker", "failures", 0, -1)
+	if failures > 0 {
+		k.integer("breaker", "timeout", 1, -1)
+	}
+	if k.boolean("failover", "enable") {
+		k.addresses("failover", "brokers", true)
+		k.integer("failover", "failures", 1, -1)
+		k.duration("failover", "sustain")
+		k.duration("failover", "recover")
+	}
+
+	k.integer("reload", "interval", 0, -1)
+	k.boolean("auth", "enable")
+	k.boolean("client", "required")
+	k.boolean("debug", "enable")
+	k.boolean("mirror", "enable")
+	k.boolean("leader", "candidate")
+	k.boolean("group", "url.verify")
+	k.url("schema", "registry.url")
+	k.address("console", "addr", false)
+	k.address("redis", "addr", false)
+	k.tls()
+	for _, key := range []string{"read.header.timeout", "read.timeout", "write.timeout", "idle.timeout"} {
+		k.duration("http", key)
+	}
+	k.integer("http", "max.body.bytes", 0, -1)
+	k.integer("http", "max.conns", 0, -1)
+	k.duration("shutdown", "timeout")
+	k.integer("cors", "max.age", 0, -1)
+	k.boolean("cors", "credentials")
+	k.duration("slow", "threshold")
+	k.duration("slow", "window")
+	k.integer("slow", "top", 1, -1)
+	if value, ok := k.value("http", "shed.status"); ok && value != "429" && value != "503" {
+		k.errorf("http.shed.status: %q is not 429 or 503", value)
+	}
+
+	if k.boolean("trace", "enable") {
+		k.address("trace", "endpoint", false)
+		k.boolean("trace", "insecure")
+		k.float("trace", "sample.ratio", 0, 1)
+	}
+	k.cryptoKeys()
+	k.integer("msgtrace", "buffer", 1, -1)
+	k.integer("msgtrace", "scan.limit", 1, -1)
+	k.duration("msgtrace", "lookback")
+	if k.boolean("orphan", "enable") {
+		if value, ok := k.value("orphan", "interval"); ok {
+			if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+				k.errorf("orphan.interval: %q is not a positive duration, eg: 1h", value)
+			}
+		}
+		k.integer("orphan", "group.idle.days", 0, -1)
+		if k.boolean("orphan", "clean") {
+			k.require("orphan", "topic.prefix")
+		}
+	}
+
+	if k.boolean("fault", "enable") {
+		k.float("fault", "produce.error.rate", 0, 1)
+		k.integer("fault", "latency.ms", 0, -1)
+		k.float("fault", "latency.rate", 0, 1)
+		k.float("fault", "ack.drop.rate", 0, 1)
+		k.integer("fault", "zk.expire.seconds", 0, -1)
+	}
+
+	if len(k.problems) == 0 {
+		return nil
+	}
+	return errors.NewNotValid(nil, fmt.Sprintf("config has %d problem(s):\n\t%s",
+		len(k.problems), strings.Join(k.problems, "\n\t")))
+}
+
+type checker struct {
+	config   *Config
+	problems []string
+}
+
+func (k *checker) errorf(format string, args ...interface{}) {
+	k.problems = append(k.problems, fmt.Sprintf(format, args...))
+}
+
+// 配置项不存在时返回false, 空字符串视为未配置
+func (k *checker) value(section, key string) (string, bool) {
+	s, err := k.config.GetSection(section)
+	if err != nil {
+		return "", false
+	}
+	value := s.GetStringMust(key, "")
+	return value, value != ""
+}
+
+func (k *checker) require(section, key string) (string, bool) {
+	value, ok := k.value(section, key)
+	if !ok {
+		k.errorf("%s.%s: is required", section, key)
+	}
+	return value, ok
+}
+
+// max小于min时不限制上限, 返回配置的值, 未配置或出错时返回0
+func (k *checker) integer(section, key string, min, max int64) int64 {
+	value, ok := k.value(section, key)
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	switch {
+	case err != nil:
+		k.errorf("%s.%s: %q is not an integer", section, key, value)
+	case n < min:
+		k.errorf("%s.%s: %d is less than %d", section, key, n, min)
+	case max >= min && n > max:
+		k.errorf("%s.%s: %d is greater than %d", section, key, n, max)
+	default:
+		return n
+	}
+	return 0
+}
+
+func (k *checker) float(section, key string, min, max float64) {
+	value, ok := k.value(section, key)
+	if !ok {
+		return
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	switch {
+	case err != nil:
+		k.errorf("%s.%s: %q is not a number", section, key, value)
+	case f < min:
+		k.errorf("%s.%s: %v is less than %v", section, key, f, min)
+	case max >= min && f > max:
+		k.errorf("%s.%s: %v is greater than %v", section, key, f, max)
+	}
+}
+
+func (k *checker) boolean(section, key string) bool {
+	value, ok := k.value(section, key)
+	if !ok {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		k.errorf("%s.%s: %q is not a bool, use true or false", section, key, value)
+	}
+	return b
+}
+
+// 非负的时间, eg: 30s
+func (k *checker) duration(section, key string) {
+	value, ok := k.value(section, key)
+	if !ok {
+		return
+	}
+	if d, err := time.ParseDuration(value); err != nil || d < 0 {
+		k.errorf("%s.%s: %q is not a duration, eg: 30s", section, key, value)
+	}
+}
+
+func (k *checker) port(section, key string) {
+	value, ok := k.require(section, key)
+	if !ok {
+		return
+	}
+	if n, err := strconv.Atoi(value); err != nil || n <= 0 || n > 65535 {
+		k.errorf("%s.%s: %q is not a port number", section, key, value)
+	}
+}
+
+// host:port格式的地址
+func (k *checker) address(section, key string, required bool) {
+	value, ok := k.value(section, key)
+	if !ok {
+		if required {
+			k.errorf("%s.%s: is required", section, key)
+		}
+		return
+	}
+	if err := checkAddr(value); err != nil {
+		k.errorf("%s.%s: %s", section, key, err)
+	}
+}
+
+// 逗号分隔的host:port列表
+func (k *checker) addresses(section, key string, required bool) {
+	value, ok := k.value(section, key)
+	if !ok {
+		if required {
+			k.errorf("%s.%s: is required", section, key)
+		}
+		return
+	}
+	for _, addr := range strings.Split(value, ",") {
+		if err := checkAddr(addr); err != nil {
+			k.errorf("%s.%s: %s", section, key, err)
+		}
+	}
+}
+
+func (k *checker) zookeeper(section, key string, required bool) {
+	value, _ := k.value(section, key)
+	if strings.Contains(value, "/") {
+		k.errorf("%s.%s: %q should not contain a path, set %s.zookeeper.root instead",
+			section, key, value, section)
+		return
+	}
+	k.addresses(section, key, required)
+}
+
+// zookeeper路径必须以/开头, 不能以/结尾(根路径除外)
+func (k *checker) root(section, key string, required bool) {
+	value, ok := k.value(section, key)
+	if !ok {
+		if required {
+			k.errorf("%s.%s: is required", section, key)
+		}
+		return
+	}
+	if err := checkPath(value); err != nil {
+		k.errorf("%s.%s: %s", section, key, err)
+	}
+}
+
+func (k *checker) url(section, key string) {
+	value, ok := k.value(section, key)
+	if !ok {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		k.errorf("%s.%s: %q is not a http(s) url", section, key, value)
+	}
+}
+
+var (
+	remoteKey       = regexp.MustCompile(`^remote\.(\w+)\.zookeeper\.connect$`)
+	remoteSASLKey   = regexp.MustCompile(`^(remote\.\w+\.)sasl\.mechanism$`)
+	remoteZKSASLKey = regexp.MustCompile(`^(remote\.\w+\.)zookeeper\.sasl\.mechanism$`)
+)
+
+// 远端机房的配置格式为host:port[,host:port][/root]
+func (k *checker) remotes() {
+	section, err := k.config.GetSection("kafka")
+	if err != nil {
+		return
+	}
+	local := section.GetStringMust("idc", "")
+	keys := make([]string, 0, len(section))
+	for key := range section {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := section[key]
+		match := remoteKey.FindStringSubmatch(key)
+		if match == nil || value == "" {
+			continue
+		}
+		if match[1] == local {
+			k.errorf("kafka.%s: idc %q is the same as kafka.idc", key, match[1])
+		}
+		tokens := strings.SplitN(value, "/", 2)
+		for _, addr := range strings.Split(tokens[0], ",") {
+			if err := checkAddr(addr); err != nil {
+				k.errorf("kafka.%s: %s", key, err)
+			}
+		}
+		if len(tokens) == 2 {
+			if err := checkPath("/" + tokens[1]); err != nil {
+				k.errorf("kafka.%s: %s", key, err)
+			}
+		}
+	}
+}
+
+// kafka.sasl.*用于本机房集群, kafka.remote.<idc>.sasl.*和failover.sasl.*覆盖其他集群的认证.
+// zookeeper的认证为metadata.zookeeper.sasl.*、kafka.zookeeper.sasl.*和kafka.remote.<idc>.zookeeper.sasl.*.
+// keytab和krb5.conf在创建sarama配置时检查, 这里只检查必填项
+func (k *checker) sasls() {
+	k.sasl("kafka", "")
+	k.zookeeperSASL("metadata", "")
+	k.zookeeperSASL("kafka", "")
+	if section, err := k.config.GetSection("kafka"); err == nil {
+		prefixes, zkPrefixes := make([]string, 0), make([]string, 0)
+		for key := range section {
+			if match := remoteSASLKey.FindStringSubmatch(key); match != nil {
+				prefixes = append(prefixes, match[1])
+			}
+			if match := remoteZKSASLKey.FindStringSubmatch(key); match != nil {
+				zkPrefixes = append(zkPrefixes, match[1])
+			}
+		}
+		sort.Strings(prefixes)
+		for _, prefix := range prefixes {
+			k.sasl("kafka", prefix)
+		}
+		sort.Strings(zkPrefixes)
+		for _, prefix := range zkPrefixes {
+			k.zookeeperSASL("kafka", prefix)
+		}
+	}
+	k.sasl("failover", "")
+}
+
+// zookeeper只支持GSSAPI
+func (k *checker) zookeeperSASL(section, prefix string) {
+	if value, ok := k.value(section, prefix+"zookeeper.sasl.mechanism"); ok && value != "GSSAPI" {
+		k.errorf("%s.%szookeeper.sasl.mechanism: %q is not GSSAPI", section, prefix, value)
+		return
+	}
+	k.sasl(section, prefix+"zookeeper.")
+}
+
+func (k *checker) sasl(section, prefix string) {
+	value, ok := k.value(section, prefix+"sasl.mechanism")
+	if !ok {
+		return
+	}
+	switch value {
+	case "GSSAPI":
+		if principal, ok := k.require(section, prefix+"sasl.kerberos.principal"); ok && !strings.Contains(principal, "@") {
+			k.errorf("%s.%ssasl.kerberos.principal: %q has no realm, eg: wqs/host@EXAMPLE.COM", section, prefix, principal)
+		}
+		k.require(section, prefix+"sasl.kerberos.keytab")
+		k.boolean(section, prefix+"sasl.kerberos.disable.pafxfast")
+	case "SCRAM-SHA-256", "SCRAM-SHA-512":
+		k.require(section, prefix+"sasl.username")
+		k.require(section, prefix+"sasl.password")
+	default:
+		k.errorf("%s.%ssasl.mechanism: %q is not GSSAPI, SCRAM-SHA-256 or SCRAM-SHA-512", section, prefix, value)
+	}
+}
+
+// isr检查只在acks=all时有意义, kafka只有这时才按min.insync.replicas拒绝写入
+func (k *checker) isrGuard() {
+	k.integer("kafka", "producer.min.isr", 0, 1<<15-1)
+	k.integer("kafka", "producer.durable.min.isr", 0, 1<<15-1)
+	value, ok := k.value("kafka", "producer.isr.guard")
+	if !ok {
+		return
+	}
+	switch value {
+	case "off":
+	case "warn", "reject":
+		if acks, _ := k.value("kafka", "producer.required.acks"); acks != "-1" {
+			k.errorf("kafka.producer.isr.guard: %q requires kafka.producer.required.acks=-1", value)
+		}
+	default:
+		k.errorf("kafka.producer.isr.guard: %q is not off, warn or reject", value)
+	}
+}
+
+// crypto.key.<名字>为base64编码的AES密钥
+func (k *checker) cryptoKeys() {
+	section, err := k.config.GetSection("crypto")
+	if err != nil {
+		return
+	}
+	keys := make([]string, 0, len(section))
+	for key := range section {
+		if strings.HasPrefix(key, "key.") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		secret, err := base64.StdEncoding.DecodeString(section[key])
+		if err != nil {
+			k.errorf("crypto.%s: not base64", key)
+			continue
+		}
+		if n := len(secret); n != 16 && n != 24 && n != 32 {
+			k.errorf("crypto.%s: key of %d bytes, use 16, 24 or 32", key, n)
+		}
+	}
+}
+
+// 证书文件在启动时加载, 这里只检查配置项之间的依赖
+func (k *checker) tls() {
+	if _, ok := k.value("tls", "cert.file"); !ok {
+		return
+	}
+	k.require("tls", "key.file")
+	if value, ok := k.value("tls", "min.version"); ok {
+		switch value {
+		case "1.0", "1.1", "1.2", "1.3":
+		default:
+			k.errorf("tls.min.version: %q is not one of 1.0, 1.1, 1.2, 1.3", value)
+		}
+	}
+	_, ca := k.value("tls", "client.ca.file")
+	if value, ok := k.value("tls", "client.auth"); ok {
+		switch value {
+		case "none":
+		case "request", "require":
+			if !ca {
+				k.errorf("tls.client.auth: %q requires tls.client.ca.file", value)
+			}
+		default:
+			k.errorf("tls.client.auth: %q is not one of none, request, require", value)
+		}
+	}
+	k.boolean("tls", "http2")
+}
+
+func (k *checker) metrics() {
+	section, err := k.config.GetSection("metrics")
+	if err != nil {
+		return
+	}
+	names := make(map[string]bool)
+	for _, name := range strings.Split(section.GetStringMust("transport.writers", ""), ",") {
+		names[name] = true
+	}
+	names[section.GetStringMust("transport.reader", "")] = true
+	if names["graphite"] {
+		k.address("metrics", "graphite.report.addr.udp", true)
+		k.require("metrics", "graphite.service.pool")
+	}
+	if names["kafka"] {
+		k.addresses("metrics", "kafka.brokers", true)
+	}
+	if names["redis"] {
+		k.address("metrics", "redis.addr", true)
+		k.integer("metrics", "redis.db", 0, -1)
+		k.duration("metrics", "redis.retention.raw")
+		k.duration("metrics", "redis.retention.minute")
+		k.duration("metrics", "redis.retention.hour")
+	}
+}
+
+func checkAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return errors.Errorf("%q is not host:port", addr)
+	}
+	if host == "" {
+		return errors.Errorf("%q has no host", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return errors.Errorf("%q has invalid port", addr)
+	}
+	return nil
+}
+
+func checkPath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return errors.Errorf("%q should start with /", path)
+	}
+	if len(path) > 1 && strings.HasSuffix(path, "/") {
+		return errors.Errorf("%q should not end with /", path)
+	}
+	if strings.Contains(path, "//") {
+		return errors.Errorf("%q contains empty node", path)
+	}
+	return nil
+}
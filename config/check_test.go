@@ -0,0 +1,166 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+const testCheckConfig = `proxy.id=1
+ui.dir=./ui
+protocol.http.port=8080
+protocol.mc.port=11211
+protocol.motan.port=8881
+metadata.zookeeper.connect=localhost:2181
+metadata.zookeeper.root=/
+kafka.zookeeper.connect=localhost:2181,localhost:2182
+kafka.zookeeper.root=/kafka
+kafka.topic.partitions=8
+kafka.topic.replications=1
+kafka.idc=bj
+log.info=info.log
+log.debug=debug.log
+log.profile=profile.log
+`
+
+func TestCheckDefaultConfig(t *testing.T) {
+	config, err := NewConfigFromFile("../config.properties")
+	if err != nil {
+		t.Fatalf("NewConfigFromFile err : %s", err)
+	}
+	if err = config.Check(); err != nil {
+		t.Fatalf("check default config err : %s", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	config, err := NewConfigFromBytes([]byte(testCheckConfig + "kafka.remote.tj.zookeeper.connect=tj:2181/kafka\n"))
+	if err != nil {
+		t.Fatalf("NewConfigFromBytes err : %s", err)
+	}
+	if err = config.Check(); err != nil {
+		t.Fatalf("check config err : %s", err)
+	}
+
+	config, err = NewConfigFromBytes([]byte(testCheckConfig +
+		"protocol.http.port=http\n" +
+		"metadata.zookeeper.root=wqs/\n" +
+		"metadata.zookeeper.auth=wqs\n" +
+		"kafka.topic.partitions=0\n" +
+		"kafka.remote.bj.zookeeper.connect=localhost\n" +
+		"kafka.producer.isr.guard=reject\n" +
+		"kafka.producer.overflow=wait\n" +
+		"kafka.producer.durable.min.isr=-1\n" +
+		"kafka.transaction.enable=true\n" +
+		"kafka.transaction.timeout=0s\n" +
+		"kafka.sasl.mechanism=GSSAPI\n" +
+		"kafka.sasl.kerberos.principal=wqs\n" +
+		"kafka.remote.th.sasl.mechanism=SCRAM-SHA-256\n" +
+		"metadata.zookeeper.sasl.mechanism=SCRAM-SHA-256\n" +
+		"kafka.remote.th.zookeeper.sasl.mechanism=GSSAPI\n" +
+		"kafka.remote.th.zookeeper.sasl.kerberos.principal=wqs/host@EXAMPLE.COM\n" +
+		"metrics.transport.writers=graphite,kafka,redis\n" +
+		"metrics.redis.retention.hour=90d\n" +
+		"alert.enable=true\n" +
+		"alert.webhook.url=localhost/alert\n" +
+		"breaker.failures=10\n" +
+		"breaker.timeout=0\n" +
+		"failover.enable=true\n" +
+		"failover.failures=0\n" +
+		"trace.enable=true\n" +
+		"trace.sample.ratio=2\n" +
+		"crypto.key.short=MTIzNA==\n" +
+		"tls.cert.file=server.crt\n" +
+		"tls.client.auth=require\n" +
+		"http.write.timeout=60\n" +
+		"http.shed.status=500\n" +
+		"slow.top=0\n" +
+		"msgtrace.buffer=0\n" +
+		"orphan.enable=true\n" +
+		"orphan.clean=true\n" +
+		"fault.enable=true\n" +
+		"fault.ack.drop.rate=2\n"))
+	if err != nil {
+		t.Fatalf("NewConfigFromBytes err : %s", err)
+	}
+	err = config.Check()
+	if !errors.IsNotValid(err) {
+		t.Fatalf("check config err : %v, want not valid", err)
+	}
+	for _, key := range []string{
+		"protocol.http.port",
+		"metadata.zookeeper.root",
+		"metadata.zookeeper.auth",
+		"kafka.topic.partitions",
+		"kafka.remote.bj.zookeeper.connect: idc",
+		"kafka.remote.bj.zookeeper.connect: \"localhost\"",
+		"kafka.producer.isr.guard",
+		"kafka.producer.overflow",
+		"kafka.producer.durable.min.isr",
+		"kafka.transaction.timeout",
+		"kafka.sasl.kerberos.principal",
+		"kafka.sasl.kerberos.keytab",
+		"kafka.remote.th.sasl.username",
+		"kafka.remote.th.sasl.password",
+		"metadata.zookeeper.sasl.mechanism",
+		"kafka.remote.th.zookeeper.sasl.kerberos.keytab",
+		"metrics.graphite.report.addr.udp",
+		"metrics.graphite.service.pool",
+		"metrics.kafka.brokers",
+		"metrics.redis.addr",
+		"metrics.redis.retention.hour",
+		"alert.webhook.url",
+		"breaker.timeout",
+		"failover.brokers",
+		"failover.failures",
+		"trace.sample.ratio",
+		"crypto.key.short",
+		"tls.key.file",
+		"tls.client.auth",
+		"http.write.timeout",
+		"http.shed.status",
+		"slow.top",
+		"msgtrace.buffer",
+		"orphan.topic.prefix",
+		"fault.ack.drop.rate",
+	} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("problem of %s not reported: %s", key, err)
+		}
+	}
+	if strings.Contains(err.Error(), "kafka.zookeeper") {
+		t.Errorf("valid kafka.zookeeper reported: %s", err)
+	}
+}
+
+func TestCheckAddr(t *testing.T) {
+	for addr, valid := range map[string]bool{
+		"localhost:2181": true,
+		"[::1]:2181":     true,
+		"localhost":      false,
+		":2181":          false,
+		"localhost:0":    false,
+		"localhost:zk":   false,
+	} {
+		if err := checkAddr(addr); (err == nil) != valid {
+			t.Errorf("checkAddr(%q) = %v, want valid %v", addr, err, valid)
+		}
+	}
+}
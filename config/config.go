@@ -42,6 +42,7 @@ type Config struct {
 	LogInfo            string
 	LogDebug           string
 	LogProfile         string
+	LogAccess          string
 	LogExpire          string
 
 	sections map[string]Section
@@ -104,6 +105,33 @@ func (c *Config) String() string {
 	return buffer.String()
 }
 
+// 与String相同, 密码、token和密钥等配置项的值替换为******, 用于发布到zookeeper等其他客户端可见的地方
+func (c *Config) RedactedString() string {
+
+	if c == nil {
+		return ""
+	}
+	buffer := &bytes.Buffer{}
+	for name, section := range c.sections {
+		for key, value := range section {
+			if value != "" && IsSecret(name+"."+key) {
+				value = "******"
+			}
+			fmt.Fprintf(buffer, "%s.%s=%s\n", name, key, value)
+		}
+	}
+	return buffer.String()
+}
+
+// 以token或password结尾的配置项, crypto.key.*以及zookeeper的digest认证
+func IsSecret(key string) bool {
+	if strings.HasPrefix(key, "crypto.key.") || strings.HasSuffix(key, "zookeeper.auth") {
+		return true
+	}
+	name := key[strings.LastIndex(key, ".")+1:]
+	return name == "token" || name == "password"
+}
+
 func (c *Config) GetSection(name string) (Section, error) {
 	if section, ok := c.sections[name]; ok {
 		return section, nil
@@ -187,6 +215,8 @@ func (c *Config) validate() (*Config, error) {
 	if err != nil {
 		return nil, errors.NotFoundf("log.profile")
 	}
+	// 为空时access log写入info日志
+	c.LogAccess = log.GetStringMust("access", "")
 	c.LogExpire, err = log.GetString("expire")
 	if err != nil {
 		c.LogExpire = "72h"
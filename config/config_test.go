@@ -17,6 +17,7 @@ limitations under the License.
 package config
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -55,3 +56,24 @@ func TestGetSections(t *testing.T) {
 		t.Fatalf("len(sections) != len(config.sections)")
 	}
 }
+
+func TestRedactedString(t *testing.T) {
+	config, err := NewConfigFromBytes([]byte(testCheckConfig +
+		"auth.admin.token=admin-secret\n" +
+		"kafka.sasl.password=sasl-secret\n" +
+		"metadata.zookeeper.auth=wqs:zk-secret\n" +
+		"crypto.key.k1=key-secret\n" +
+		"console.password=\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := config.RedactedString()
+	if strings.Contains(s, "secret") {
+		t.Errorf("secret not redacted:\n%s", s)
+	}
+	for _, line := range []string{"auth.admin.token=******", "kafka.zookeeper.connect=localhost:2181,localhost:2182", "console.password=\n"} {
+		if !strings.Contains(s, line) {
+			t.Errorf("%q not found:\n%s", line, s)
+		}
+	}
+}
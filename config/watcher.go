@@ -0,0 +1,114 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// 返回两份配置中新增、删除或值不同的配置项, 格式为section.key, 按字母排序
+func Diff(old, new *Config) []string {
+	changed := make(map[string]bool)
+	for name, section := range old.sections {
+		for key, value := range section {
+			if v, ok := new.sections[name][key]; !ok || v != value {
+				changed[name+"."+key] = true
+			}
+		}
+	}
+	for name, section := range new.sections {
+		for key := range section {
+			if _, ok := old.sections[name][key]; !ok {
+				changed[name+"."+key] = true
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(changed))
+	for key := range changed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 配置的来源, 启动时调用Load, 之后定期调用Changed检查配置是否变化
+type Source interface {
+	Load() (*Config, error)
+	// 配置变化时返回新的配置, 没有变化时返回nil
+	Changed() (*Config, error)
+	String() string
+}
+
+// 在base之后追加local中指定section的配置项, 同名的配置项以local为准.
+// 用于在共享的配置上使用每个实例自己的配置, 如proxy.id
+func Overlay(base []byte, local *Config, sections ...string) (*Config, error) {
+	buffer := bytes.NewBuffer(base)
+	buffer.WriteString("\n")
+	for _, name := range sections {
+		for key, value := range local.sections[name] {
+			fmt.Fprintf(buffer, "%s.%s=%s\n", name, key, value)
+		}
+	}
+	return NewConfigFromBytes(buffer.Bytes())
+}
+
+// 通过修改时间检查配置文件是否变化, 由调用方定期调用Changed
+type Watcher struct {
+	file  string
+	mtime time.Time
+}
+
+func NewWatcher(file string) (*Watcher, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &Watcher{file: file, mtime: info.ModTime()}, nil
+}
+
+func (w *Watcher) Load() (*Config, error) {
+	return NewConfigFromFile(w.file)
+}
+
+func (w *Watcher) String() string {
+	return w.file
+}
+
+// 文件修改后重新加载并返回新的配置, 没有修改时返回nil.
+// 加载失败时返回错误, 修改时间仍然会更新, 避免每次检查都重复报错
+func (w *Watcher) Changed() (*Config, error) {
+	info, err := os.Stat(w.file)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if info.ModTime().Equal(w.mtime) {
+		return nil, nil
+	}
+	w.mtime = info.ModTime()
+	conf, err := NewConfigFromFile(w.file)
+	if err != nil {
+		return nil, errors.NewNotValid(err, w.file)
+	}
+	return conf, nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+const testWatcherConfig = `proxy.id=1
+ui.dir=./ui
+protocol.http.port=8080
+protocol.mc.port=11211
+protocol.motan.port=8881
+metadata.zookeeper.connect=localhost:2181
+metadata.zookeeper.root=/
+log.info=info.log
+log.debug=debug.log
+log.profile=profile.log
+`
+
+func TestDiff(t *testing.T) {
+	old, err := NewConfigFromBytes([]byte(testWatcherConfig + "alert.lag.threshold=100\nbreaker.timeout=10\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	new, err := NewConfigFromBytes([]byte(testWatcherConfig + "alert.lag.threshold=200\nlog.level=debug\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(Diff(old, new)); got != "[alert.lag.threshold breaker.timeout log.level]" {
+		t.Errorf("unexpected changed keys %s", got)
+	}
+	if changed := Diff(old, old); len(changed) != 0 {
+		t.Errorf("expect no change, got %v", changed)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	f, err := ioutil.TempFile("", "wqs-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(testWatcherConfig)
+	f.Close()
+
+	w, err := NewWatcher(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf, err := w.Changed(); conf != nil || err != nil {
+		t.Fatalf("expect no change, got %v %v", conf, err)
+	}
+
+	if err = ioutil.WriteFile(f.Name(), []byte(testWatcherConfig+"log.level=debug\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 部分文件系统的修改时间精度为秒
+	mtime := time.Now().Add(time.Second)
+	os.Chtimes(f.Name(), mtime, mtime)
+	conf, err := w.Changed()
+	if err != nil || conf == nil {
+		t.Fatalf("expect new config, got %v", err)
+	}
+	if section, _ := conf.GetSection("log"); section.GetStringMust("level", "") != "debug" {
+		t.Errorf("expect log.level debug, got %s", conf)
+	}
+	if conf, _ = w.Changed(); conf != nil {
+		t.Error("expect no change after reload")
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	local, err := NewConfigFromBytes([]byte(testWatcherConfig + "proxy.id=2\nalert.enable=true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := Overlay([]byte(testWatcherConfig+"ui.dir=./central"), local, "proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.ProxyId != 2 || conf.UiDir != "./central" {
+		t.Errorf("unexpected proxy.id %d ui.dir %s", conf.ProxyId, conf.UiDir)
+	}
+	if _, err = conf.GetSection("alert"); err == nil {
+		t.Error("only proxy section should be overlaid")
+	}
+}
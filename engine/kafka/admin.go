@@ -0,0 +1,169 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+const adminTimeout = 10 * time.Second
+
+// kafka 1.0以上通过admin协议(CreateTopics, CreatePartitions, DeleteTopics)发送给controller管理topic,
+// 由broker校验并写入zookeeper. 低版本直接修改zookeeper中的节点
+func (m *Manager) adminProtocol() bool {
+	return m.kClient.Config().Version.IsAtLeast(sarama.V1_0_0_0)
+}
+
+func (m *Manager) createTopicByAdmin(topic string, assignment partitonAssignment, configs map[string]string) error {
+
+	detail := &sarama.TopicDetail{
+		NumPartitions:     -1,
+		ReplicationFactor: -1,
+		ReplicaAssignment: make(map[int32][]int32, len(assignment)),
+		ConfigEntries:     make(map[string]*string, len(configs)),
+	}
+	for partition, replicas := range assignment {
+		id, err := strconv.ParseInt(partition, 10, 32)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		detail.ReplicaAssignment[int32(id)] = replicas
+	}
+	for key, value := range configs {
+		value := value
+		detail.ConfigEntries[key] = &value
+	}
+
+	controller, err := m.kClient.Controller()
+	if err != nil {
+		return errors.Annotatef(err, "controller of kafka")
+	}
+	response, err := controller.CreateTopics(&sarama.CreateTopicsRequest{
+		TopicDetails: map[string]*sarama.TopicDetail{topic: detail},
+		Timeout:      adminTimeout,
+	})
+	if err != nil {
+		return errors.Annotatef(err, "create topic %s at controller %s", topic, controller.Addr())
+	}
+	result, ok := response.TopicErrors[topic]
+	if !ok {
+		return sarama.ErrIncompleteResponse
+	}
+	return adminError(topic, result.Err, result.ErrMsg)
+}
+
+// 新增partition的副本分布与zookeeper方式相同, 副本数与partition 0一致
+func (m *Manager) updateTopicByAdmin(topic string, partitions int) error {
+
+	if err := m.kClient.RefreshMetadata(topic); err != nil {
+		return errors.Trace(err)
+	}
+	current, err := m.kClient.Partitions(topic)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	partitionsToAdd := partitions - len(current)
+	if partitionsToAdd <= 0 {
+		return errors.Errorf("partition can only be increased")
+	}
+
+	replicationList, err := m.kClient.Replicas(topic, 0)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(replicationList) == 0 {
+		return errors.Errorf("exist replication is 0")
+	}
+
+	newAssignment, err := assignReplicasToBrokers(m.BrokersList(), int32(partitionsToAdd),
+		int32(len(replicationList)), replicationList[0], int32(len(current)))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	assignment := make([][]int32, partitionsToAdd)
+	for i := range assignment {
+		assignment[i] = newAssignment[strconv.Itoa(len(current)+i)]
+	}
+	return m.createPartitionsByAdmin(topic, int32(partitions), assignment)
+}
+
+// assignment为新增partition的副本分布, 按partition id排列
+func (m *Manager) createPartitionsByAdmin(topic string, count int32, assignment [][]int32) error {
+
+	controller, err := m.kClient.Controller()
+	if err != nil {
+		return errors.Annotatef(err, "controller of kafka")
+	}
+	response, err := controller.CreatePartitions(&sarama.CreatePartitionsRequest{
+		TopicPartitions: map[string]*sarama.TopicPartition{
+			topic: {Count: count, Assignment: assignment},
+		},
+		Timeout: adminTimeout,
+	})
+	if err != nil {
+		return errors.Annotatef(err, "create partitions of topic %s at controller %s", topic, controller.Addr())
+	}
+	result, ok := response.TopicPartitionErrors[topic]
+	if !ok {
+		return sarama.ErrIncompleteResponse
+	}
+	return adminError(topic, result.Err, result.ErrMsg)
+}
+
+func (m *Manager) deleteTopicByAdmin(topic string) error {
+
+	controller, err := m.kClient.Controller()
+	if err != nil {
+		return errors.Annotatef(err, "controller of kafka")
+	}
+	response, err := controller.DeleteTopics(&sarama.DeleteTopicsRequest{
+		Topics:  []string{topic},
+		Timeout: adminTimeout,
+	})
+	if err != nil {
+		return errors.Annotatef(err, "delete topic %s at controller %s", topic, controller.Addr())
+	}
+	kerr, ok := response.TopicErrorCodes[topic]
+	if !ok {
+		return sarama.ErrIncompleteResponse
+	}
+	return adminError(topic, kerr, nil)
+}
+
+// 转换为与zookeeper方式一致的错误类型
+func adminError(topic string, kerr sarama.KError, msg *string) error {
+	var err error = kerr
+	if msg != nil && *msg != "" {
+		err = errors.Errorf("%s: %s", kerr, *msg)
+	}
+	switch kerr {
+	case sarama.ErrNoError:
+		return nil
+	case sarama.ErrTopicAlreadyExists:
+		return errors.AlreadyExistsf("topic : %q", topic)
+	case sarama.ErrUnknownTopicOrPartition:
+		return errors.NotFoundf("topic : %q", topic)
+	case sarama.ErrInvalidPartitions, sarama.ErrInvalidReplicationFactor,
+		sarama.ErrInvalidReplicaAssignment, sarama.ErrInvalidConfig, sarama.ErrInvalidTopic:
+		return errors.NewNotValid(err, "topic "+topic)
+	}
+	return errors.Annotatef(err, "topic %s", topic)
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+func TestAdminError(t *testing.T) {
+	if err := adminError("t", sarama.ErrNoError, nil); err != nil {
+		t.Fatalf("no error: %v", err)
+	}
+	if err := adminError("t", sarama.ErrTopicAlreadyExists, nil); !errors.IsAlreadyExists(err) {
+		t.Errorf("topic exists: %v, want already exists", err)
+	}
+	if err := adminError("t", sarama.ErrUnknownTopicOrPartition, nil); !errors.IsNotFound(err) {
+		t.Errorf("unknown topic: %v, want not found", err)
+	}
+	msg := "replication factor: 3 larger than available brokers: 1"
+	err := adminError("t", sarama.ErrInvalidReplicationFactor, &msg)
+	if !errors.IsNotValid(err) {
+		t.Errorf("invalid replication: %v, want not valid", err)
+	}
+	if err := adminError("t", sarama.ErrNotController, nil); errors.Cause(err) != sarama.ErrNotController {
+		t.Errorf("not controller: %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"sort"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// broker的状态, Registered为在zookeeper中注册, Alive为出现在kafka返回的metadata中
+type BrokerStatus struct {
+	ID         int32  `json:"id"`
+	Addr       string `json:"addr"`
+	Registered bool   `json:"registered"`
+	Alive      bool   `json:"alive"`
+	Controller bool   `json:"controller"`
+}
+
+// 副本不完整或者没有leader的partition, Offline为不可用的副本
+type PartitionStatus struct {
+	Topic     string  `json:"topic"`
+	Partition int32   `json:"partition"`
+	Leader    int32   `json:"leader"`
+	Replicas  []int32 `json:"replicas"`
+	Isr       []int32 `json:"isr"`
+	Offline   []int32 `json:"offline,omitempty"`
+}
+
+// kafka集群的状态, Controller未知时为-1. 只检查指定的topic, 不存在的topic在Missing中返回
+type ClusterStatus struct {
+	Controller      int32              `json:"controller"`
+	Brokers         []*BrokerStatus    `json:"brokers"`
+	Partitions      int                `json:"partitions"`
+	UnderReplicated []*PartitionStatus `json:"under_replicated"`
+	Offline         []*PartitionStatus `json:"offline"`
+	Missing         []string           `json:"missing,omitempty"`
+}
+
+// 从kafka查询broker、controller和topic的副本状态
+func (m *Manager) ClusterStatus(topics []string) (*ClusterStatus, error) {
+
+	registered, err := getBrokers(m.zkConn, m.kafkaRoot)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// controller id需要v1, 不可用的副本需要v5
+	request := &sarama.MetadataRequest{Topics: topics}
+	version := m.kClient.Config().Version
+	switch {
+	case version.IsAtLeast(sarama.V1_0_0_0):
+		request.Version = 5
+	case version.IsAtLeast(sarama.V0_10_0_0):
+		request.Version = 1
+	}
+
+	var response *sarama.MetadataResponse
+	for _, broker := range m.kClient.Brokers() {
+		if ok, _ := broker.Connected(); !ok {
+			if err = broker.Open(m.kClient.Config()); err != nil && err != sarama.ErrAlreadyConnected {
+				continue
+			}
+		}
+		if response, err = broker.GetMetadata(request); err == nil {
+			break
+		}
+		broker.Close()
+	}
+	if response == nil {
+		if err == nil {
+			err = sarama.ErrOutOfBrokers
+		}
+		return nil, errors.Annotatef(err, "metadata of kafka")
+	}
+
+	controller := int32(-1)
+	if request.Version > 0 {
+		controller = response.ControllerID
+	}
+	alive := make(map[int32]string, len(response.Brokers))
+	for _, broker := range response.Brokers {
+		alive[broker.ID()] = broker.Addr()
+	}
+	// topics为空时kafka返回所有topic
+	wanted := make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		wanted[topic] = true
+	}
+	metadata := make([]*sarama.TopicMetadata, 0, len(topics))
+	for _, topic := range response.Topics {
+		if wanted[topic.Name] {
+			metadata = append(metadata, topic)
+		}
+	}
+	return newClusterStatus(registered, alive, controller, metadata), nil
+}
+
+// 副本中出现的broker即使已经不在zookeeper和metadata中也会返回
+func newClusterStatus(registered map[int32]string, alive map[int32]string,
+	controller int32, topics []*sarama.TopicMetadata) *ClusterStatus {
+
+	status := &ClusterStatus{
+		Controller:      controller,
+		Brokers:         make([]*BrokerStatus, 0, len(registered)),
+		UnderReplicated: make([]*PartitionStatus, 0),
+		Offline:         make([]*PartitionStatus, 0),
+	}
+
+	brokers := make(map[int32]*BrokerStatus)
+	broker := func(id int32) *BrokerStatus {
+		b, ok := brokers[id]
+		if !ok {
+			b = &BrokerStatus{ID: id, Controller: id == controller}
+			brokers[id] = b
+			status.Brokers = append(status.Brokers, b)
+		}
+		return b
+	}
+	for id, addr := range registered {
+		b := broker(id)
+		b.Addr, b.Registered = addr, true
+	}
+	for id, addr := range alive {
+		b := broker(id)
+		b.Addr, b.Alive = addr, true
+	}
+
+	for _, topic := range topics {
+		if topic.Err == sarama.ErrUnknownTopicOrPartition {
+			status.Missing = append(status.Missing, topic.Name)
+			continue
+		}
+		for _, p := range topic.Partitions {
+			status.Partitions++
+			for _, id := range p.Replicas {
+				broker(id)
+			}
+			info := &PartitionStatus{
+				Topic:     topic.Name,
+				Partition: p.ID,
+				Leader:    p.Leader,
+				Replicas:  p.Replicas,
+				Isr:       p.Isr,
+				Offline:   p.OfflineReplicas,
+			}
+			if p.Leader < 0 || p.Err == sarama.ErrLeaderNotAvailable {
+				status.Offline = append(status.Offline, info)
+			} else if len(p.Isr) < len(p.Replicas) {
+				status.UnderReplicated = append(status.UnderReplicated, info)
+			}
+		}
+	}
+
+	sort.Slice(status.Brokers, func(i, j int) bool { return status.Brokers[i].ID < status.Brokers[j].ID })
+	sortPartitions := func(ps []*PartitionStatus) {
+		sort.Slice(ps, func(i, j int) bool {
+			if ps[i].Topic != ps[j].Topic {
+				return ps[i].Topic < ps[j].Topic
+			}
+			return ps[i].Partition < ps[j].Partition
+		})
+	}
+	sortPartitions(status.UnderReplicated)
+	sortPartitions(status.Offline)
+	sort.Strings(status.Missing)
+	return status
+}
+
+// isr少于minISR的partition, 使用client缓存的metadata, 不发送请求
+func (m *Manager) UnderMinISR(topic string, minISR int) ([]int32, error) {
+	partitions, err := m.kClient.Partitions(topic)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	under := make([]int32, 0)
+	for _, partition := range partitions {
+		isr, err := m.kClient.InSyncReplicas(topic, partition)
+		if err != nil && err != sarama.ErrReplicaNotAvailable {
+			return nil, errors.Trace(err)
+		}
+		if len(isr) < minISR {
+			under = append(under, partition)
+		}
+	}
+	return under, nil
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestNewClusterStatus(t *testing.T) {
+	registered := map[int32]string{1: "k1:9092", 2: "k2:9092"}
+	alive := map[int32]string{1: "k1:9092"}
+	topics := []*sarama.TopicMetadata{
+		{Name: "q1", Partitions: []*sarama.PartitionMetadata{
+			{ID: 1, Leader: 1, Replicas: []int32{1, 2}, Isr: []int32{1}},
+			{ID: 0, Leader: 1, Replicas: []int32{1, 2}, Isr: []int32{1, 2}},
+		}},
+		{Name: "q2", Partitions: []*sarama.PartitionMetadata{
+			{ID: 0, Err: sarama.ErrLeaderNotAvailable, Leader: -1, Replicas: []int32{3}, Isr: []int32{}, OfflineReplicas: []int32{3}},
+		}},
+		{Name: "q3", Err: sarama.ErrUnknownTopicOrPartition},
+	}
+
+	status := newClusterStatus(registered, alive, 1, topics)
+	if status.Controller != 1 || status.Partitions != 3 {
+		t.Errorf("unexpected status %+v", status)
+	}
+	wantBrokers := []*BrokerStatus{
+		{ID: 1, Addr: "k1:9092", Registered: true, Alive: true, Controller: true},
+		{ID: 2, Addr: "k2:9092", Registered: true},
+		{ID: 3},
+	}
+	if !reflect.DeepEqual(status.Brokers, wantBrokers) {
+		t.Errorf("unexpected brokers %+v", status.Brokers)
+	}
+	if len(status.UnderReplicated) != 1 || status.UnderReplicated[0].Topic != "q1" || status.UnderReplicated[0].Partition != 1 {
+		t.Errorf("unexpected under replicated partitions %+v", status.UnderReplicated)
+	}
+	if len(status.Offline) != 1 || status.Offline[0].Topic != "q2" || !reflect.DeepEqual(status.Offline[0].Offline, []int32{3}) {
+		t.Errorf("unexpected offline partitions %+v", status.Offline)
+	}
+	if !reflect.DeepEqual(status.Missing, []string{"q3"}) {
+		t.Errorf("unexpected missing topics %v", status.Missing)
+	}
+}
@@ -18,15 +18,19 @@ package kafka
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
 
 	"github.com/Shopify/sarama"
-	"github.com/bsm/sarama-cluster"
 	"github.com/weibocom/wqs/log"
 	"github.com/weibocom/wqs/metrics"
+	"github.com/weibocom/wqs/utils"
 	"github.com/weibocom/wqs/utils/list"
 )
 
@@ -45,6 +49,7 @@ var (
 	ErrInvaildPartition = errors.New("invaild partition")
 	ErrInvaildOffset    = errors.New("invaild offset")
 	ErrEmptyAddr        = errors.New("empty addrs")
+	ErrNoPartition      = errors.New("no partition assigned")
 )
 
 type ackHead struct {
@@ -112,9 +117,10 @@ func newAckNode(msg *sarama.ConsumerMessage) *ackNode {
 
 type none struct{}
 
+// member为接收到消息的group成员
 type message struct {
-	idc string
-	msg *sarama.ConsumerMessage
+	member string
+	msg    *sarama.ConsumerMessage
 }
 
 type ackGroup struct {
@@ -123,33 +129,187 @@ type ackGroup struct {
 	sync.Mutex
 }
 
+// 每个机房有workers个kafka group成员, partition在成员之间分配, 各自并行fetch.
+// consumers、ackGroups和assigned的key为成员名, 第一个成员为机房名, 其余为"机房名#序号"
 type Consumer struct {
 	topic     string
 	group     string
 	padding   int32
-	consumers map[string]*cluster.Consumer
+	workers   int
+	commit    CommitPolicy
+	consumers map[string]*GroupConsumer
 	ackGroups map[string]*ackGroup
 	messages  chan *message
 	dying     chan none
 	mu        sync.Mutex
+	orderMu   sync.Mutex
 	dead      sync.WaitGroup
+	onError   atomic.Value
+	// 各个成员rebalance后分配到的partition, 收到第一次通知前为nil
+	assigned map[string]map[int32]bool
 }
 
-func (c *Consumer) receiveNotification(idc string, notification <-chan *cluster.Notification) {
-	for _ = range notification {
-		metrics.AddMeter(c.topic+"."+c.group+"."+metrics.Rebalance+"."+metrics.Qps, 1)
-		log.Infof("idc %q topic %q group %q consumer occur rebalance", idc, c.topic, c.group)
+func memberName(idc string, worker int) string {
+	if worker == 0 {
+		return idc
 	}
+	return fmt.Sprintf("%s#%d", idc, worker)
 }
 
-func (c *Consumer) dispatch(idc string, in <-chan *sarama.ConsumerMessage, errors <-chan error) {
+func memberIdc(member string) string {
+	if i := strings.IndexByte(member, '#'); i >= 0 {
+		return member[:i]
+	}
+	return member
+}
+
+// 创建Consumer的选项, 零值为每个机房一个group成员, 使用默认的提交策略
+type ConsumerOptions struct {
+	Workers int
+	Commit  CommitPolicy
+}
+
+func (c *Consumer) Options() ConsumerOptions {
+	return ConsumerOptions{Workers: c.workers, Commit: c.commit}
+}
+
+// 设置fetch出错时的回调, 回调在dispatch goroutine中执行, 不能阻塞
+func (c *Consumer) OnError(f func(error)) {
+	c.onError.Store(f)
+}
+
+func (c *Consumer) receiveRebalance(member string, rebalances <-chan []int32) {
+	for {
+		select {
+		case partitions := <-rebalances:
+			metrics.AddMeter(c.topic+"."+c.group+"."+metrics.Rebalance+"."+metrics.Qps, 1)
+			log.Infof("member %q topic %q group %q consumer occur rebalance, current partitions %v",
+				member, c.topic, c.group, partitions)
+			c.assign(member, partitions)
+		case <-c.dying:
+			return
+		}
+	}
+}
+
+// 多个proxy的consumer属于同一个kafka group, partition由rebalance分配给其中一个成员.
+// 记录该成员分配到的partition, 并丢弃已经分配给其他成员的partition上未ACK的消息,
+// 这些消息由新的owner从已提交的offset重新投递, 本成员不再超时重发, 避免重复投递
+func (c *Consumer) assign(member string, partitions []int32) {
+	owned := make(map[int32]bool, len(partitions))
+	for _, partition := range partitions {
+		owned[partition] = true
+	}
+
+	c.mu.Lock()
+	if c.assigned == nil {
+		c.assigned = make(map[string]map[int32]bool)
+	}
+	c.assigned[member] = owned
+	g, ok := c.ackGroups[member]
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	released := 0
+	g.Lock()
+	for partition, messages := range g.ackMessages {
+		if owned[partition] {
+			continue
+		}
+		released += len(messages)
+		delete(g.ackMessages, partition)
+		delete(g.partitionHeads, partition)
+	}
+	g.Unlock()
+	if released > 0 {
+		atomic.AddInt32(&c.padding, -int32(released))
+		log.Infof("member %q topic %q group %q release %d unacked messages of revoked partitions",
+			member, c.topic, c.group, released)
+	}
+}
+
+// 返回各机房分配给本consumer的partition, 还没有收到rebalance通知时返回nil
+func (c *Consumer) Assigned() map[string][]int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.assigned == nil {
+		return nil
+	}
+	assigned := make(map[string][]int32, len(c.assigned))
+	for member, owned := range c.assigned {
+		idc := memberIdc(member)
+		partitions := assigned[idc]
+		for partition := range owned {
+			partitions = append(partitions, partition)
+		}
+		assigned[idc] = partitions
+	}
+	for _, partitions := range assigned {
+		sort.Sort(utils.Int32Slice(partitions))
+	}
+	return assigned
+}
+
+// 所有成员都已经完成rebalance且本consumer没有分配到任何partition.
+// 消费者多于partition时会出现这种情况, 客户端应该换到其他proxy接收
+func (c *Consumer) unassigned() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.assigned) < len(c.consumers) {
+		return false
+	}
+	for _, owned := range c.assigned {
+		if len(owned) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// 调用方持有c.mu
+func (c *Consumer) revoked(member string, partition int32) bool {
+	owned, ok := c.assigned[member]
+	return ok && !owned[partition]
+}
+
+// 调用方持有c.mu. 有多个worker时消息在接收它的成员的ackGroup中, 优先返回包含该消息的ackGroup
+func (c *Consumer) ackGroupOf(idc string, partition int32, offset int64) (string, *ackGroup) {
+	var member string
+	var found *ackGroup
+	for worker := 0; worker == 0 || worker < c.workers; worker++ {
+		m := memberName(idc, worker)
+		g, ok := c.ackGroups[m]
+		if !ok {
+			continue
+		}
+		g.Lock()
+		_, has := g.ackMessages[partition][offset]
+		g.Unlock()
+		if has {
+			return m, g
+		}
+		if found == nil {
+			member, found = m, g
+		}
+	}
+	return member, found
+}
+
+func (c *Consumer) dispatch(member string, worker int, in <-chan *sarama.ConsumerMessage, errors <-chan error) {
 	defer func() {
 		if x := recover(); x != nil {
-			log.Errorf("idc %q topic %q group %q dispatch painc: %v", idc, c.topic, c.group, x)
+			log.Errorf("member %q topic %q group %q dispatch painc: %v", member, c.topic, c.group, x)
 		}
 		c.dead.Done()
 	}()
 
+	// 多个worker时按序号统计各个worker fetch的消息数, 各机房相同序号的worker合并统计
+	var workerMeter string
+	if c.workers > 1 {
+		workerMeter = c.topic + "." + c.group + "." + metrics.Worker + "." + strconv.Itoa(worker) + "." + metrics.Qps
+	}
 	for {
 		select {
 		case msg := <-in:
@@ -157,60 +317,80 @@ func (c *Consumer) dispatch(idc string, in <-chan *sarama.ConsumerMessage, error
 				// in channel closed, it means consumer closed.
 				return
 			}
+			if workerMeter != "" {
+				metrics.AddMeter(workerMeter, 1)
+			}
 			select {
-			case c.messages <- &message{idc: idc, msg: msg}:
+			case c.messages <- &message{member: member, msg: msg}:
 			case <-c.dying:
 				return
 			}
 		case err := <-errors:
 			metrics.AddMeter(c.topic+"."+c.group+"."+metrics.RecvError+"."+metrics.Qps, 1)
-			log.Errorf("idc %q topic %q group %q consumer occur error: %v", idc, c.topic, c.group, err)
+			log.Errorf("member %q topic %q group %q consumer occur error: %v", member, c.topic, c.group, err)
+			if f, ok := c.onError.Load().(func(error)); ok {
+				f(err)
+			}
 		case <-c.dying:
 			return
 		}
 	}
 }
 
-func NewConsumer(brokerAddrs map[string][]string, config *cluster.Config, topic, group string) (*Consumer, error) {
+func NewConsumer(brokerAddrs map[string][]string, config *sarama.Config, topic, group string) (*Consumer, error) {
+	return NewConsumerWithOptions(brokerAddrs, config, topic, group, ConsumerOptions{})
+}
+
+// 每个机房创建opts.Workers个group成员并行fetch, 超过partition数的成员分配不到partition
+func NewConsumerWithOptions(brokerAddrs map[string][]string, config *sarama.Config, topic, group string, opts ConsumerOptions) (*Consumer, error) {
 
 	var consumer *Consumer
-	kConsumers := make(map[string]*cluster.Consumer)
+	kConsumers := make(map[string]*GroupConsumer)
 	if len(brokerAddrs) == 0 {
 		return nil, ErrEmptyAddr
 	}
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
 	for idc, brokerAddr := range brokerAddrs {
-		kConsumer, err := cluster.NewConsumer(brokerAddr, group, []string{topic}, config)
-		if err != nil {
-			log.Errorf("new kafka consumer failed, addrs: %s, idc: %s, err: %v", brokerAddr, idc, err)
-			goto Error
+		for worker := 0; worker < workers; worker++ {
+			kConsumer, err := NewGroupConsumerWithPolicy(brokerAddr, group, topic, config, opts.Commit)
+			if err != nil {
+				log.Errorf("new kafka consumer failed, addrs: %s, idc: %s, err: %v", brokerAddr, idc, err)
+				goto Error
+			}
+			kConsumers[memberName(idc, worker)] = kConsumer
 		}
-		kConsumers[idc] = kConsumer
 	}
 
 	consumer = &Consumer{
 		topic:     topic,
 		group:     group,
 		padding:   0,
+		workers:   workers,
+		commit:    opts.Commit,
 		consumers: kConsumers,
 		ackGroups: make(map[string]*ackGroup),
 		messages:  make(chan *message),
 		dying:     make(chan none),
 	}
 
-	for idc, kConsumer := range kConsumers {
-		consumer.dead.Add(1)
-		go consumer.dispatch(idc, kConsumer.Messages(), kConsumer.Errors())
-
-		if config.Group.Return.Notifications {
-			go consumer.receiveNotification(idc, kConsumer.Notifications())
+	for idc := range brokerAddrs {
+		for worker := 0; worker < workers; worker++ {
+			member := memberName(idc, worker)
+			kConsumer := kConsumers[member]
+			consumer.dead.Add(1)
+			go consumer.dispatch(member, worker, kConsumer.Messages(), kConsumer.Errors())
+			go consumer.receiveRebalance(member, kConsumer.Rebalances())
 		}
 	}
 	return consumer, nil
 
 Error:
-	for idc, kConsumer := range kConsumers {
+	for member, kConsumer := range kConsumers {
 		if err := kConsumer.Close(); err != nil {
-			log.Errorf("on error, close %s kConsumer err: %v", idc, err)
+			log.Errorf("on error, close %s kConsumer err: %v", member, err)
 		}
 	}
 	return nil, ErrNewConsumer
@@ -226,17 +406,23 @@ func (c *Consumer) recv() (msg *sarama.ConsumerMessage, idc string, err error) {
 		// 用2个锁来减少ack数据结构的锁粒度，保证一定的并发效率
 		node := newAckNode(m.msg)
 		c.mu.Lock()
-		g, ok := c.ackGroups[m.idc]
+		if c.revoked(m.member, m.msg.Partition) {
+			// rebalance之前已经fetch的消息, 由新的owner投递
+			c.mu.Unlock()
+			err = ErrTimeout
+			return
+		}
+		g, ok := c.ackGroups[m.member]
 		if !ok {
 			g = &ackGroup{
 				ackMessages:    make(map[int32]map[int64]*ackNode),
 				partitionHeads: make(map[int32]*ackHead),
 			}
-			c.ackGroups[m.idc] = g
+			c.ackGroups[m.member] = g
 		}
 		c.mu.Unlock()
 		msg = m.msg
-		idc = m.idc
+		idc = memberIdc(m.member)
 		g.Lock()
 		head, ok := g.partitionHeads[msg.Partition]
 		if !ok {
@@ -264,38 +450,67 @@ func (c *Consumer) Recv() (msg *sarama.ConsumerMessage, idc string, err error) {
 	}
 
 	if atomic.LoadInt32(&c.padding) > 0 {
-		now := time.Now()
-		// TODO 这里怎么优化？如何做到遍历的同时不同时获得2个锁，减小锁粒度。
-		c.mu.Lock()
-	Found:
-		for i, g := range c.ackGroups {
-			g.Lock()
-			for _, head := range g.partitionHeads {
-				if node := head.GetExpired(now); node != nil {
-					msg = node.msg
-					idc = i
-					err = nil
-					g.Unlock()
-					break Found
-				}
-			}
-			g.Unlock()
+		if msg, idc = c.recvExpired(); msg != nil {
+			return msg, idc, nil
 		}
-		c.mu.Unlock()
 	}
 
-	if msg == nil && err == nil {
-		err = ErrTimeout
+	if err == nil || err == ErrTimeout {
+		err = c.timeoutError()
+	}
+	return nil, "", err
+}
+
+func (c *Consumer) timeoutError() error {
+	if c.unassigned() {
+		return ErrNoPartition
+	}
+	return ErrTimeout
+}
+
+// 保证顺序的接收: 有未ACK的消息时不接收新的消息, 只重新投递超时未ACK的消息
+func (c *Consumer) RecvOrdered() (msg *sarama.ConsumerMessage, idc string, err error) {
+
+	c.orderMu.Lock()
+	defer c.orderMu.Unlock()
+
+	if atomic.LoadInt32(&c.padding) == 0 {
+		if msg, idc, err = c.recv(); err == ErrTimeout {
+			err = c.timeoutError()
+		}
+		return
+	}
+	if msg, idc = c.recvExpired(); msg != nil {
+		return msg, idc, nil
 	}
-	return msg, idc, err
+	return nil, "", ErrTimeout
+}
+
+// 返回一条超时未ACK的消息, 没有时返回nil
+func (c *Consumer) recvExpired() (*sarama.ConsumerMessage, string) {
+	now := time.Now()
+	// TODO 这里怎么优化？如何做到遍历的同时不同时获得2个锁，减小锁粒度。
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for member, g := range c.ackGroups {
+		g.Lock()
+		for _, head := range g.partitionHeads {
+			if node := head.GetExpired(now); node != nil {
+				g.Unlock()
+				return node.msg, memberIdc(member)
+			}
+		}
+		g.Unlock()
+	}
+	return nil, ""
 }
 
 func (c *Consumer) Ack(idc string, partition int32, offset int64) error {
 
 	c.mu.Lock()
-	g, ok := c.ackGroups[idc]
+	member, g := c.ackGroupOf(idc, partition, offset)
 	c.mu.Unlock()
-	if !ok {
+	if g == nil {
 		return ErrIdcNotExist
 	}
 
@@ -332,13 +547,13 @@ func (c *Consumer) Ack(idc string, partition int32, offset int64) error {
 	atomic.AddInt32(&c.padding, -1)
 	if first == node {
 		// c.consumers 是一个read-only的map，因此不需要锁保护
-		kConsumer := c.consumers[idc]
-		kConsumer.MarkOffset(node.msg, "")
+		kConsumer := c.consumers[member]
+		kConsumer.MarkOffset(node.msg, commitMetadata(time.Now()))
 		if !head.Empty() {
 			first = head.Front()
 			if first.msg.Offset > offset+1 {
 				kConsumer.MarkPartitionOffset(first.msg.Topic,
-					first.msg.Partition, first.msg.Offset-1, "")
+					first.msg.Partition, first.msg.Offset-1, commitMetadata(time.Now()))
 			}
 		}
 	}
@@ -350,9 +565,9 @@ func (c *Consumer) Ack(idc string, partition int32, offset int64) error {
 func (c *Consumer) Close() {
 	close(c.dying)
 	c.dead.Wait()
-	for idc, kConsumer := range c.consumers {
+	for member, kConsumer := range c.consumers {
 		if err := kConsumer.Close(); err != nil {
-			log.Errorf("idc %s consumer close occur error: %v", idc, err)
+			log.Errorf("member %s consumer close occur error: %v", member, err)
 		}
 	}
 }
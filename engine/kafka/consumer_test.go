@@ -16,7 +16,12 @@ limitations under the License.
 
 package kafka
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
 
 func TestConsumer(t *testing.T) {
 	//	consumer, err := NewConsumer([]string{"localhost:2181"}, "test-queue", "go-consumer")
@@ -25,3 +30,113 @@ func TestConsumer(t *testing.T) {
 	//	}
 	//	consumer.Close()
 }
+
+func TestRecvOrdered(t *testing.T) {
+	c := &Consumer{
+		ackGroups: make(map[string]*ackGroup),
+		messages:  make(chan *message, 2),
+		dying:     make(chan none),
+	}
+	c.messages <- &message{member: "idc1", msg: &sarama.ConsumerMessage{Partition: 0, Offset: 1}}
+	c.messages <- &message{member: "idc1", msg: &sarama.ConsumerMessage{Partition: 0, Offset: 2}}
+
+	msg, idc, err := c.RecvOrdered()
+	if err != nil || idc != "idc1" || msg.Offset != 1 {
+		t.Fatalf("expect offset 1, got %v %s %v", msg, idc, err)
+	}
+	// 上一条消息没有ACK时不投递新的消息
+	if _, _, err = c.RecvOrdered(); err != ErrTimeout {
+		t.Fatalf("expect timeout while message pending, got %v", err)
+	}
+
+	// 超时后重新投递同一条消息
+	c.ackGroups["idc1"].partitionHeads[0].Front().expired = time.Now().Add(-expiredMax - time.Second)
+	if msg, _, err = c.RecvOrdered(); err != nil || msg.Offset != 1 {
+		t.Fatalf("expect offset 1 redelivered, got %v %v", msg, err)
+	}
+	if len(c.messages) != 1 {
+		t.Errorf("offset 2 should stay in channel, %d left", len(c.messages))
+	}
+}
+
+func TestAssignReleasesRevokedPartitions(t *testing.T) {
+	c := &Consumer{
+		consumers: map[string]*GroupConsumer{"idc1": nil},
+		ackGroups: make(map[string]*ackGroup),
+		messages:  make(chan *message, 3),
+		dying:     make(chan none),
+	}
+	c.messages <- &message{member: "idc1", msg: &sarama.ConsumerMessage{Partition: 0, Offset: 1}}
+	c.messages <- &message{member: "idc1", msg: &sarama.ConsumerMessage{Partition: 1, Offset: 1}}
+	for i := 0; i < 2; i++ {
+		if _, _, err := c.Recv(); err != nil {
+			t.Fatalf("recv error: %v", err)
+		}
+	}
+
+	// partition 1分配给了其他proxy, 未ACK的消息不再由本consumer重发
+	c.assign("idc1", []int32{0})
+	if c.padding != 1 {
+		t.Errorf("expect 1 pending message, got %d", c.padding)
+	}
+	if err := c.Ack("idc1", 1, 1); err != ErrInvaildPartition {
+		t.Errorf("expect ack of revoked partition fail, got %v", err)
+	}
+	if assigned := c.Assigned(); len(assigned["idc1"]) != 1 || assigned["idc1"][0] != 0 {
+		t.Errorf("unexpected assigned partitions %v", assigned)
+	}
+
+	// rebalance之前已经fetch的消息被丢弃
+	c.messages <- &message{member: "idc1", msg: &sarama.ConsumerMessage{Partition: 1, Offset: 2}}
+	if _, _, err := c.recv(); err != ErrTimeout {
+		t.Errorf("expect message of revoked partition dropped, got %v", err)
+	}
+
+	c.assign("idc1", nil)
+	if c.padding != 0 {
+		t.Errorf("expect no pending message, got %d", c.padding)
+	}
+	if _, _, err := c.Recv(); err != ErrNoPartition {
+		t.Errorf("expect ErrNoPartition, got %v", err)
+	}
+}
+
+func TestConsumerWorkers(t *testing.T) {
+	c := &Consumer{
+		workers:   2,
+		consumers: map[string]*GroupConsumer{"idc1": nil, "idc1#1": nil},
+		ackGroups: make(map[string]*ackGroup),
+		messages:  make(chan *message, 2),
+		dying:     make(chan none),
+	}
+	if memberName("idc1", 1) != "idc1#1" || memberIdc("idc1#1") != "idc1" || memberIdc("idc1") != "idc1" {
+		t.Fatal("unexpected member name")
+	}
+
+	c.messages <- &message{member: "idc1", msg: &sarama.ConsumerMessage{Partition: 0, Offset: 1}}
+	c.messages <- &message{member: "idc1#1", msg: &sarama.ConsumerMessage{Partition: 1, Offset: 1}}
+	for i := 0; i < 2; i++ {
+		if _, idc, err := c.Recv(); err != nil || idc != "idc1" {
+			t.Fatalf("recv %s error: %v", idc, err)
+		}
+	}
+
+	// 按接收消息的成员ACK
+	if member, g := c.ackGroupOf("idc1", 1, 1); member != "idc1#1" || g == nil {
+		t.Errorf("ack group of partition 1 is %q", member)
+	}
+	if member, g := c.ackGroupOf("idc1", 0, 1); member != "idc1" || g == nil {
+		t.Errorf("ack group of partition 0 is %q", member)
+	}
+
+	// 各个成员都分配到partition之后才不是unassigned, 机房的partition合并返回
+	c.assign("idc1", []int32{0})
+	c.assign("idc1#1", []int32{1})
+	if assigned := c.Assigned(); len(assigned["idc1"]) != 2 || assigned["idc1"][0] != 0 || assigned["idc1"][1] != 1 {
+		t.Errorf("unexpected assigned partitions %v", assigned)
+	}
+	c.assign("idc1", nil)
+	if c.unassigned() {
+		t.Error("worker 1 still has partition 1")
+	}
+}
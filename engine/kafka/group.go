@@ -0,0 +1,177 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// kafka consumer group中的一个成员, Topics为订阅的topic, Partitions为分配到的partition
+type GroupMember struct {
+	MemberID   string             `json:"member_id"`
+	ClientID   string             `json:"client_id"`
+	ClientHost string             `json:"client_host"`
+	Topics     []string           `json:"topics"`
+	Partitions map[string][]int32 `json:"partitions"`
+}
+
+type GroupDescription struct {
+	Group    string         `json:"group"`
+	State    string         `json:"state"`
+	Protocol string         `json:"protocol"`
+	Members  []*GroupMember `json:"members"`
+}
+
+// 提交的offset, CommitTime为提交时的毫秒时间戳, 未知时为0
+type PartitionCommit struct {
+	Partition  int32 `json:"partition"`
+	Offset     int64 `json:"offset"`
+	CommitTime int64 `json:"commit_time,omitempty"`
+}
+
+// 提交offset时在metadata中记录提交时间, 用于查看group最后一次提交的时间
+func commitMetadata(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/1e6, 10)
+}
+
+func parseCommitMetadata(metadata string) int64 {
+	ms, err := strconv.ParseInt(metadata, 10, 64)
+	if err != nil || ms < 0 {
+		return 0
+	}
+	return ms
+}
+
+// 从coordinator查询consumer group的成员和分配的partition
+func (m *Manager) DescribeGroup(group string) (*GroupDescription, error) {
+	m.kClient.RefreshCoordinator(group)
+	broker, err := m.kClient.Coordinator(group)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	response, err := broker.DescribeGroups(&sarama.DescribeGroupsRequest{Groups: []string{group}})
+	if err != nil {
+		broker.Close()
+		return nil, errors.Trace(err)
+	}
+	if len(response.Groups) != 1 {
+		return nil, errors.Trace(sarama.ErrIncompleteResponse)
+	}
+	g := response.Groups[0]
+	if g.Err != sarama.ErrNoError {
+		return nil, errors.Annotatef(g.Err, "describe group %s", group)
+	}
+
+	desc := &GroupDescription{
+		Group:    g.GroupId,
+		State:    g.State,
+		Protocol: g.Protocol,
+		Members:  make([]*GroupMember, 0, len(g.Members)),
+	}
+	for id, member := range g.Members {
+		gm := &GroupMember{
+			MemberID:   id,
+			ClientID:   member.ClientId,
+			ClientHost: member.ClientHost,
+			Topics:     make([]string, 0),
+			Partitions: make(map[string][]int32),
+		}
+		if metadata, err := member.GetMemberMetadata(); err == nil && metadata != nil {
+			gm.Topics = append(gm.Topics, metadata.Topics...)
+		}
+		if assignment, err := member.GetMemberAssignment(); err == nil && assignment != nil {
+			for topic, partitions := range assignment.Topics {
+				sorted := append([]int32(nil), partitions...)
+				sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+				gm.Partitions[topic] = sorted
+			}
+		}
+		desc.Members = append(desc.Members, gm)
+	}
+	sort.Slice(desc.Members, func(i, j int) bool { return desc.Members[i].MemberID < desc.Members[j].MemberID })
+	return desc, nil
+}
+
+// 查询group在topic每个partition上提交的offset和提交时间, 按partition排序
+func (m *Manager) FetchGroupCommits(topic, group string) ([]PartitionCommit, error) {
+	partitions, err := m.kClient.Partitions(topic)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	m.kClient.RefreshCoordinator(group)
+	broker, err := m.kClient.Coordinator(group)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	req := &sarama.OffsetFetchRequest{
+		Version:       1,
+		ConsumerGroup: group,
+	}
+	for _, partition := range partitions {
+		req.AddPartition(topic, partition)
+	}
+	response, err := broker.FetchOffset(req)
+	if err != nil {
+		broker.Close()
+		return nil, errors.Trace(err)
+	}
+
+	commits := make([]PartitionCommit, 0, len(partitions))
+	for _, partition := range partitions {
+		block := response.GetBlock(topic, partition)
+		if block == nil {
+			return nil, errors.Trace(sarama.ErrIncompleteResponse)
+		}
+		if block.Err != sarama.ErrNoError {
+			return nil, errors.Annotatef(block.Err, "fetch offset partition %d", partition)
+		}
+		commits = append(commits, PartitionCommit{
+			Partition:  partition,
+			Offset:     block.Offset,
+			CommitTime: parseCommitMetadata(block.Metadata),
+		})
+	}
+	sort.Slice(commits, func(i, j int) bool { return commits[i].Partition < commits[j].Partition })
+	return commits, nil
+}
+
+// 将成员从consumer group中移除并触发rebalance, 成员仍然存活时会在下一次心跳后重新加入
+func (m *Manager) RemoveGroupMember(group string, memberID string) error {
+	m.kClient.RefreshCoordinator(group)
+	broker, err := m.kClient.Coordinator(group)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	response, err := broker.LeaveGroup(&sarama.LeaveGroupRequest{GroupId: group, MemberId: memberID})
+	if err != nil {
+		broker.Close()
+		return errors.Trace(err)
+	}
+	if response.Err != sarama.ErrNoError {
+		return errors.Annotatef(response.Err, "remove member %s from group %s", memberID, group)
+	}
+	return nil
+}
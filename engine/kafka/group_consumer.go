@@ -0,0 +1,358 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// offset的提交策略, 零值为按consumer.offsets.autocommit.interval定时提交, 关闭时提交已经标记的offset.
+// OnAck为true时每次标记后立即提交, Interval大于0时替代配置的提交间隔,
+// SkipOnClose为true时关闭consumer不提交, 最后一次提交之后ACK的消息由下一个owner重新投递
+type CommitPolicy struct {
+	OnAck       bool
+	Interval    time.Duration
+	SkipOnClose bool
+}
+
+// 标记的offset, 提交前只保留每个partition最新的一个
+type offsetMark struct {
+	offset   int64
+	metadata string
+}
+
+// 消费一个topic的kafka consumer group成员, 基于sarama的ConsumerGroup.
+// 每次rebalance后通过Rebalances返回分配到的partition.
+// MarkOffset传入已经消费的消息, 提交的offset为其下一条, 按CommitPolicy提交, 失败的提交记录在统计项CommitError中
+type GroupConsumer struct {
+	topic      string
+	groupID    string
+	backoff    time.Duration
+	client     sarama.Client
+	group      sarama.ConsumerGroup
+	policy     CommitPolicy
+	interval   time.Duration
+	messages   chan *sarama.ConsumerMessage
+	rebalances chan []int32
+	commits    chan struct{}
+	cancel     context.CancelFunc
+	done       chan struct{}
+	loopDone   chan struct{}
+	closed     int32
+
+	mu       sync.Mutex
+	session  sarama.ConsumerGroupSession
+	marked   map[int32]offsetMark
+	commitMu sync.Mutex
+}
+
+// consumer group需要kafka 0.10.2以上, 配置的版本低于该版本时使用0.10.2
+func NewGroupConsumer(brokerAddrs []string, groupID string, topic string, config *sarama.Config) (*GroupConsumer, error) {
+	return NewGroupConsumerWithPolicy(brokerAddrs, groupID, topic, config, CommitPolicy{})
+}
+
+// 关闭sarama的自动提交, 按policy自己提交offset, 以便统计提交失败
+func NewGroupConsumerWithPolicy(brokerAddrs []string, groupID string, topic string, config *sarama.Config, policy CommitPolicy) (*GroupConsumer, error) {
+
+	c := *config
+	if !c.Version.IsAtLeast(sarama.V0_10_2_0) {
+		c.Version = sarama.V0_10_2_0
+	}
+	interval := c.Consumer.Offsets.AutoCommit.Interval
+	if policy.Interval > 0 {
+		interval = policy.Interval
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	c.Consumer.Offsets.AutoCommit.Enable = false
+	client, err := sarama.NewClient(brokerAddrs, &c)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	group, err := sarama.NewConsumerGroupFromClient(groupID, client)
+	if err != nil {
+		client.Close()
+		return nil, errors.Trace(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	consumer := &GroupConsumer{
+		topic:      topic,
+		groupID:    groupID,
+		backoff:    c.Consumer.Retry.Backoff,
+		client:     client,
+		group:      group,
+		policy:     policy,
+		interval:   interval,
+		messages:   make(chan *sarama.ConsumerMessage, c.ChannelBufferSize),
+		rebalances: make(chan []int32, 1),
+		commits:    make(chan struct{}, 1),
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		loopDone:   make(chan struct{}),
+		marked:     make(map[int32]offsetMark),
+	}
+	go consumer.consume(ctx)
+	go consumer.commitLoop(ctx)
+	return consumer, nil
+}
+
+// 每次rebalance结束后重新加入group, 直到Close
+func (c *GroupConsumer) consume(ctx context.Context) {
+	defer close(c.done)
+	defer close(c.messages)
+
+	for {
+		err := c.group.Consume(ctx, []string{c.topic}, c)
+		if err == sarama.ErrClosedConsumerGroup || ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Errorf("topic %q group %q consume error: %v", c.topic, c.groupID, err)
+			select {
+			case <-time.After(c.backoff):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// 实现sarama.ConsumerGroupHandler, 新的session开始时记录分配到的partition,
+// 丢弃已经不属于本成员的partition上没有提交成功的offset
+func (c *GroupConsumer) Setup(session sarama.ConsumerGroupSession) error {
+	claims := session.Claims()[c.topic]
+	c.mu.Lock()
+	c.session = session
+	for partition := range c.marked {
+		if !containsPartition(claims, partition) {
+			delete(c.marked, partition)
+		}
+	}
+	c.mu.Unlock()
+
+	// 只保留最新一次的分配结果, 没有读取时不阻塞rebalance
+	select {
+	case <-c.rebalances:
+	default:
+	}
+	c.rebalances <- claims
+	return nil
+}
+
+func containsPartition(partitions []int32, partition int32) bool {
+	for _, p := range partitions {
+		if p == partition {
+			return true
+		}
+	}
+	return false
+}
+
+// session结束前提交已经标记的offset, 关闭时按SkipOnClose决定.
+// session结束时partition可能已经分配给其他成员, 之后的MarkOffset被忽略
+func (c *GroupConsumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	if atomic.LoadInt32(&c.closed) == 0 || !c.policy.SkipOnClose {
+		c.commit()
+	}
+	c.mu.Lock()
+	c.session = nil
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *GroupConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			select {
+			case c.messages <- msg:
+			case <-session.Context().Done():
+				return nil
+			}
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// Close之后关闭
+func (c *GroupConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// 只有Consumer.Return.Errors为true时才会返回错误
+func (c *GroupConsumer) Errors() <-chan error {
+	return c.group.Errors()
+}
+
+// 每次rebalance后分配给本成员的partition
+func (c *GroupConsumer) Rebalances() <-chan []int32 {
+	return c.rebalances
+}
+
+func (c *GroupConsumer) MarkOffset(msg *sarama.ConsumerMessage, metadata string) {
+	c.MarkPartitionOffset(msg.Topic, msg.Partition, msg.Offset, metadata)
+}
+
+// offset为已经消费的位置, 按提交策略提交offset+1.
+// rebalance后被收回的partition异步释放, 之后的ACK不能再标记, 否则会覆盖新owner提交的offset
+func (c *GroupConsumer) MarkPartitionOffset(topic string, partition int32, offset int64, metadata string) {
+	c.mu.Lock()
+	if c.session != nil && containsPartition(c.session.Claims()[c.topic], partition) {
+		c.session.MarkOffset(topic, partition, offset+1, metadata)
+		if c.marked != nil {
+			c.marked[partition] = offsetMark{offset: offset + 1, metadata: metadata}
+		}
+	}
+	c.mu.Unlock()
+	if c.policy.OnAck {
+		select {
+		case c.commits <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 按间隔定时提交, OnAck时每次标记后立即提交, 定时提交用于重试失败的提交
+func (c *GroupConsumer) commitLoop(ctx context.Context) {
+	defer close(c.loopDone)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.commit()
+		case <-c.commits:
+			c.commit()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// 用当前session的generation提交标记的offset, 失败时保留, 下次提交时重试
+func (c *GroupConsumer) commit() {
+	c.commitMu.Lock()
+	defer c.commitMu.Unlock()
+
+	c.mu.Lock()
+	session, marked := c.session, c.marked
+	if c.client == nil || session == nil || len(marked) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	c.marked = make(map[int32]offsetMark)
+	c.mu.Unlock()
+
+	if err := c.commitOffsets(session, marked); err != nil {
+		metrics.AddCounter(metrics.CommitError, 1)
+		metrics.AddCounter(c.topic+"."+c.groupID+"."+metrics.CommitError, 1)
+		log.Warnf("topic %q group %q commit offsets %v error: %v", c.topic, c.groupID, marked, err)
+		c.mu.Lock()
+		if c.session == session {
+			for partition, mark := range marked {
+				if _, ok := c.marked[partition]; !ok {
+					c.marked[partition] = mark
+				}
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *GroupConsumer) commitOffsets(session sarama.ConsumerGroupSession, marked map[int32]offsetMark) error {
+	request := c.commitRequest(session, marked)
+	if request == nil {
+		return nil
+	}
+	broker, err := c.client.Coordinator(c.groupID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	response, err := broker.CommitOffset(request)
+	if err != nil {
+		// 重新查找coordinator
+		c.client.RefreshCoordinator(c.groupID)
+		return errors.Trace(err)
+	}
+	for _, partitions := range response.Errors {
+		for partition, kerr := range partitions {
+			if kerr != sarama.ErrNoError {
+				if kerr == sarama.ErrNotCoordinatorForConsumer || kerr == sarama.ErrConsumerCoordinatorNotAvailable {
+					c.client.RefreshCoordinator(c.groupID)
+				}
+				return errors.Annotatef(kerr, "partition %d", partition)
+			}
+		}
+	}
+	return nil
+}
+
+// 只提交仍然分配给该session的partition, 没有需要提交的offset时返回nil
+func (c *GroupConsumer) commitRequest(session sarama.ConsumerGroupSession, marked map[int32]offsetMark) *sarama.OffsetCommitRequest {
+	claims := session.Claims()[c.topic]
+	request := &sarama.OffsetCommitRequest{
+		Version:                 2,
+		ConsumerGroup:           c.groupID,
+		ConsumerGroupGeneration: session.GenerationID(),
+		ConsumerID:              session.MemberID(),
+		RetentionTime:           -1,
+	}
+	if retention := c.client.Config().Consumer.Offsets.Retention; retention > 0 {
+		request.RetentionTime = int64(retention / time.Millisecond)
+	}
+	blocks := 0
+	for partition, mark := range marked {
+		if containsPartition(claims, partition) {
+			request.AddBlock(c.topic, partition, mark.offset, 0, mark.metadata)
+			blocks++
+		}
+	}
+	if blocks == 0 {
+		return nil
+	}
+	return request
+}
+
+// 离开group, 没有设置SkipOnClose时先提交已经标记的offset
+func (c *GroupConsumer) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	c.cancel()
+	err := c.group.Close()
+	<-c.done
+	<-c.loopDone
+	if cerr := c.client.Close(); err == nil && cerr != sarama.ErrClosedClient {
+		err = cerr
+	}
+	return errors.Trace(err)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	claims map[string][]int32
+	marked map[int32]int64
+}
+
+func (s *fakeSession) Claims() map[string][]int32 {
+	return s.claims
+}
+
+func (s *fakeSession) GenerationID() int32 { return 3 }
+
+func (s *fakeSession) MemberID() string { return "m" }
+
+func (s *fakeSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.marked[partition] = offset
+}
+
+func TestGroupConsumerSession(t *testing.T) {
+	c := &GroupConsumer{topic: "t", rebalances: make(chan []int32, 1)}
+
+	// 没有session时忽略
+	c.MarkPartitionOffset("t", 0, 10, "")
+
+	first := &fakeSession{claims: map[string][]int32{"t": {0, 1}}, marked: make(map[int32]int64)}
+	second := &fakeSession{claims: map[string][]int32{"t": {2}}, marked: make(map[int32]int64)}
+	c.Setup(first)
+	c.Cleanup(first)
+	c.Setup(second)
+	if partitions := <-c.Rebalances(); len(partitions) != 1 || partitions[0] != 2 {
+		t.Fatalf("expect latest partitions [2], got %v", partitions)
+	}
+
+	c.MarkOffset(&sarama.ConsumerMessage{Topic: "t", Partition: 2, Offset: 5}, "")
+	if second.marked[2] != 6 {
+		t.Errorf("expect offset 6 marked, got %d", second.marked[2])
+	}
+	c.Cleanup(second)
+	c.MarkPartitionOffset("t", 2, 7, "")
+	if second.marked[2] != 6 || len(first.marked) != 0 {
+		t.Errorf("mark after cleanup: %v %v", first.marked, second.marked)
+	}
+}
+
+func TestGroupConsumerMarked(t *testing.T) {
+	c := &GroupConsumer{
+		topic:      "t",
+		rebalances: make(chan []int32, 1),
+		commits:    make(chan struct{}, 1),
+		marked:     make(map[int32]offsetMark),
+		policy:     CommitPolicy{OnAck: true},
+	}
+	first := &fakeSession{claims: map[string][]int32{"t": {0, 1}}, marked: make(map[int32]int64)}
+	c.Setup(first)
+	c.MarkPartitionOffset("t", 0, 10, "m0")
+	c.MarkPartitionOffset("t", 1, 20, "m1")
+	c.MarkPartitionOffset("t", 1, 21, "m1")
+	if len(c.commits) != 1 {
+		t.Error("mark should trigger commit when commit on ack")
+	}
+	if mark := c.marked[1]; mark.offset != 22 || mark.metadata != "m1" {
+		t.Errorf("expect latest offset 22 of partition 1, got %+v", mark)
+	}
+
+	// 没有提交成功的offset只保留仍然分配给本成员的partition
+	second := &fakeSession{claims: map[string][]int32{"t": {1, 2}}, marked: make(map[int32]int64)}
+	c.Setup(second)
+	if _, ok := c.marked[0]; ok || len(c.marked) != 1 {
+		t.Errorf("unexpected marked offsets after rebalance %v", c.marked)
+	}
+}
+
+type configClient struct {
+	sarama.Client
+	config *sarama.Config
+}
+
+func (c *configClient) Config() *sarama.Config {
+	return c.config
+}
+
+// rebalance后被收回的partition不能再标记和提交, 否则会覆盖新owner的offset
+func TestGroupConsumerRevokedPartition(t *testing.T) {
+	c := &GroupConsumer{
+		topic:      "t",
+		client:     &configClient{config: sarama.NewConfig()},
+		rebalances: make(chan []int32, 1),
+		commits:    make(chan struct{}, 1),
+		marked:     make(map[int32]offsetMark),
+	}
+	session := &fakeSession{claims: map[string][]int32{"t": {1}}, marked: make(map[int32]int64)}
+	c.Setup(session)
+	c.MarkPartitionOffset("t", 0, 10, "")
+	c.MarkPartitionOffset("t", 1, 20, "")
+	if _, ok := c.marked[0]; ok || len(session.marked) != 1 {
+		t.Errorf("revoked partition marked: %v %v", c.marked, session.marked)
+	}
+
+	marked := map[int32]offsetMark{0: {offset: 11}, 1: {offset: 21}}
+	request := c.commitRequest(session, marked)
+	if request == nil {
+		t.Fatal("expect commit request")
+	}
+	if offset, _, err := request.Offset("t", 1); err != nil || offset != 21 {
+		t.Errorf("partition 1 offset %d err %v", offset, err)
+	}
+	if _, _, err := request.Offset("t", 0); err == nil {
+		t.Errorf("revoked partition 0 should not be committed")
+	}
+	if request.ConsumerGroupGeneration != 3 || request.ConsumerID != "m" {
+		t.Errorf("commit request generation %d member %q", request.ConsumerGroupGeneration, request.ConsumerID)
+	}
+	if request = c.commitRequest(session, map[int32]offsetMark{0: {offset: 11}}); request != nil {
+		t.Errorf("nothing to commit for revoked partitions, got %+v", request)
+	}
+}
@@ -0,0 +1,34 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommitMetadata(t *testing.T) {
+	now := time.Unix(1470024000, 123e6)
+	if ms := parseCommitMetadata(commitMetadata(now)); ms != 1470024000123 {
+		t.Errorf("expect commit time 1470024000123, got %d", ms)
+	}
+	for _, metadata := range []string{"", "abc", "-1"} {
+		if ms := parseCommitMetadata(metadata); ms != 0 {
+			t.Errorf("expect unknown commit time for %q, got %d", metadata, ms)
+		}
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/weibocom/wqs/engine/zookeeper"
 	"github.com/weibocom/wqs/log"
@@ -38,6 +39,8 @@ const (
 	adminDeleteTopicPath   = "/admin/delete_topics"
 	groupMetadataTopicName = "__consumer_offsets"
 	kafkaVersion           = 1
+	fetchTimeout           = 3 * time.Second
+	purgeTimeout           = 30 * time.Second
 )
 
 type Manager struct {
@@ -46,6 +49,7 @@ type Manager struct {
 	kafkaRoot   string
 	brokerAddrs []string
 	brokersList []int32
+	topics      *topicCache
 	mu          sync.Mutex
 	ops         sync.Mutex
 }
@@ -53,21 +57,43 @@ type Manager struct {
 // get online brokers
 func getBrokerAddrs(zkConn *zookeeper.Conn, kafkaRoot string) ([]string, []int32, error) {
 
-	brokerAddrs, brokersList := make([]string, 0), make([]int32, 0)
-	brokers, _, err := zkConn.Children(fmt.Sprintf("%s%s", kafkaRoot, brokersIds))
+	brokers, err := getBrokers(zkConn, kafkaRoot)
 	if err != nil {
 		return nil, nil, errors.Trace(err)
 	}
 
+	brokerAddrs, brokersList := make([]string, 0, len(brokers)), make([]int32, 0, len(brokers))
+	for id, addr := range brokers {
+		brokerAddrs = append(brokerAddrs, addr)
+		brokersList = append(brokersList, id)
+	}
+
+	if len(brokersList) == 0 || len(brokerAddrs) == 0 {
+		return nil, nil, errors.NotFoundf("brokers from zookeeper")
+	}
+
+	sort.Sort(utils.Int32Slice(brokersList))
+	return brokerAddrs, brokersList, nil
+}
+
+// 在zookeeper中注册的broker的id和地址
+func getBrokers(zkConn *zookeeper.Conn, kafkaRoot string) (map[int32]string, error) {
+
+	brokers, _, err := zkConn.Children(fmt.Sprintf("%s%s", kafkaRoot, brokersIds))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	result := make(map[int32]string, len(brokers))
 	for _, broker := range brokers {
 		data, _, err := zkConn.Get(fmt.Sprintf("%s%s/%s", kafkaRoot, brokersIds, broker))
 		if err != nil {
-			return nil, nil, errors.Trace(err)
+			return nil, errors.Trace(err)
 		}
 
 		config := brokerConfig{}
 		if err := config.LoadFromBytes(data); err != nil {
-			return nil, nil, errors.Trace(err)
+			return nil, errors.Trace(err)
 		}
 
 		id, err := strconv.ParseInt(broker, 10, 32)
@@ -75,16 +101,9 @@ func getBrokerAddrs(zkConn *zookeeper.Conn, kafkaRoot string) ([]string, []int32
 			log.Warnf("get invalid kafka broker %q and omit it", broker)
 			continue
 		}
-		brokerAddrs = append(brokerAddrs, fmt.Sprintf("%s:%d", config.Host, config.Port))
-		brokersList = append(brokersList, int32(id))
+		result[int32(id)] = fmt.Sprintf("%s:%d", config.Host, config.Port)
 	}
-
-	if len(brokersList) == 0 || len(brokerAddrs) == 0 {
-		return nil, nil, errors.NotFoundf("brokers from zookeeper")
-	}
-
-	sort.Sort(utils.Int32Slice(brokersList))
-	return brokerAddrs, brokersList, nil
+	return result, nil
 }
 
 func replicationIndex(firstReplicaIndex, secondReplicaShift, replicaIndex, nBrokers int32) int32 {
@@ -152,14 +171,14 @@ func assignReplicasToBrokers(brokersList []int32,
 	return assignment, nil
 }
 
-// new a kafka manager by give zookeeper address of kafka
-func NewManager(zkAddrs []string, kafkaRoot string, conf *sarama.Config) (*Manager, error) {
+// new a kafka manager by give zookeeper address of kafka, zkKerberos不为nil时使用kerberos连接zookeeper
+func NewManager(zkAddrs []string, kafkaRoot string, conf *sarama.Config, zkKerberos *sarama.GSSAPIConfig) (*Manager, error) {
 
 	if kafkaRoot == "/" {
 		kafkaRoot = ""
 	}
 
-	zkConn, err := zookeeper.NewConnect(zkAddrs)
+	zkConn, err := zookeeper.NewKerberosConnect(zkAddrs, zkKerberos)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -181,6 +200,7 @@ func NewManager(zkAddrs []string, kafkaRoot string, conf *sarama.Config) (*Manag
 		brokerAddrs: brokerAddrs,
 		brokersList: brokersList,
 	}
+	manager.topics = newTopicCache(topicCacheTTL, manager.Topics)
 
 	if err = manager.RefreshMetadata(); err != nil {
 		return nil, errors.Trace(err)
@@ -200,9 +220,29 @@ func (m *Manager) RefreshMetadata() error {
 	m.mu.Lock()
 	m.brokerAddrs, m.brokersList = brokerAddrs, brokersList
 	m.mu.Unlock()
+	defer m.topics.invalidate()
 	return m.kClient.RefreshMetadata()
 }
 
+// check connectivity of kafka cluster and its zookeeper,
+// no request is sent when there is a connected broker
+func (m *Manager) Ping() error {
+	if !m.zkConn.Connected() {
+		return errors.New("zookeeper session lost")
+	}
+	for _, broker := range m.kClient.Brokers() {
+		if ok, _ := broker.Connected(); ok {
+			return nil
+		}
+	}
+	return errors.Trace(m.kClient.RefreshMetadata())
+}
+
+// sarama config of this cluster, 各集群的认证可能不同
+func (m *Manager) Config() *sarama.Config {
+	return m.kClient.Config()
+}
+
 // get broker address from manager's cached data
 func (m *Manager) BrokerAddrs() []string {
 	m.mu.Lock()
@@ -223,7 +263,7 @@ func (m *Manager) BrokersList() []int32 {
 
 // create topic internal function
 func (m *Manager) createOrUpdateTopicPartitionAssignmentPathInZK(topic string,
-	assignment partitonAssignment, update bool) error {
+	assignment partitonAssignment, configs map[string]string, update bool) error {
 
 	topicPath := fmt.Sprintf("%s%s/%s", m.kafkaRoot, brokerTopics, topic)
 	if !update {
@@ -244,7 +284,10 @@ func (m *Manager) createOrUpdateTopicPartitionAssignmentPathInZK(topic string,
 		//				return errors.NotValidf("topic : %q", topic)
 		//			}
 		//		}
-		info := &topicInfo{Version: kafkaVersion}
+		info := &topicInfo{Version: kafkaVersion, Config: make(topicConfig)}
+		for key, value := range configs {
+			info.Config[key] = value
+		}
 		topicConfigPath := fmt.Sprintf("%s%s/%s", m.kafkaRoot, topicConfigs, topic)
 		if err = m.zkConn.Create(topicConfigPath, info.String(), 0); err != nil {
 			return errors.Trace(err)
@@ -270,8 +313,14 @@ func (m *Manager) createOrUpdateTopicPartitionAssignmentPathInZK(topic string,
 
 // create a topic by given name
 func (m *Manager) CreateTopic(topic string, replications int32, partitions int32) error {
+	return m.CreateTopicWithConfig(topic, replications, partitions, nil)
+}
+
+// create a topic with topic level configs, eg: retention.ms, compression.type
+func (m *Manager) CreateTopicWithConfig(topic string, replications int32, partitions int32, configs map[string]string) error {
 	m.ops.Lock()
 	defer m.ops.Unlock()
+	defer m.topics.invalidate()
 
 	brokersList := m.BrokersList()
 	assignment, err := assignReplicasToBrokers(brokersList, partitions, replications, -1, -1)
@@ -279,7 +328,10 @@ func (m *Manager) CreateTopic(topic string, replications int32, partitions int32
 		return errors.Trace(err)
 	}
 
-	if err = m.createOrUpdateTopicPartitionAssignmentPathInZK(topic, assignment, false); err != nil {
+	if m.adminProtocol() {
+		return m.createTopicByAdmin(topic, assignment, configs)
+	}
+	if err = m.createOrUpdateTopicPartitionAssignmentPathInZK(topic, assignment, configs, false); err != nil {
 		return errors.Trace(err)
 	}
 
@@ -295,6 +347,10 @@ func (m *Manager) UpdateTopic(topic string, partitions int) error {
 		return errors.NotValidf("cannot modify internal topic")
 	}
 
+	if m.adminProtocol() {
+		return m.updateTopicByAdmin(topic, partitions)
+	}
+
 	topicPath := fmt.Sprintf("%s%s/%s", m.kafkaRoot, brokerTopics, topic)
 	topicAssignData, _, err := m.zkConn.Get(topicPath)
 	if err != nil {
@@ -333,7 +389,7 @@ func (m *Manager) UpdateTopic(topic string, partitions int) error {
 		partitionConfig.Partitions[partition] = assign
 	}
 
-	if err = m.createOrUpdateTopicPartitionAssignmentPathInZK(topic, partitionConfig.Partitions, true); err != nil {
+	if err = m.createOrUpdateTopicPartitionAssignmentPathInZK(topic, partitionConfig.Partitions, nil, true); err != nil {
 		return errors.Trace(err)
 	}
 
@@ -342,6 +398,11 @@ func (m *Manager) UpdateTopic(topic string, partitions int) error {
 
 // mark given topic to delete
 func (m *Manager) DeleteTopic(topic string) error {
+	defer m.topics.invalidate()
+
+	if m.adminProtocol() {
+		return m.deleteTopicByAdmin(topic)
+	}
 
 	deleteTopicPath := fmt.Sprintf("%s%s/%s", m.kafkaRoot, adminDeleteTopicPath, topic)
 
@@ -356,18 +417,19 @@ func (m *Manager) Topics() (topics []string, err error) {
 	return m.kClient.Topics()
 }
 
+// 返回topic的partition列表
+func (m *Manager) Partitions(topic string) ([]int32, error) {
+	return m.kClient.Partitions(topic)
+}
+
 // test given topic whether exists.
+// 结果缓存topicCacheTTL, 本机创建和删除topic或刷新metadata后重新加载
 func (m *Manager) ExistTopic(topic string) (bool, error) {
-	topics, err := m.Topics()
+	exist, err := m.topics.exist(topic)
 	if err != nil {
 		return false, errors.Trace(err)
 	}
-	for _, t := range topics {
-		if t == topic {
-			return true, nil
-		}
-	}
-	return false, nil
+	return exist, nil
 }
 
 // 目前只能用于新建group时的offset置位，当group join-group后，Kafka server需要检查
@@ -470,6 +532,237 @@ func (m *Manager) FetchGroupOffsets(topic, group string) (map[int32]int64, error
 	return offsets, nil
 }
 
+// 返回partition中最早的消息的offset和将要写入的消息的offset
+func (m *Manager) PartitionOffsets(topic string, partition int32) (oldest int64, newest int64, err error) {
+	partitions, err := m.kClient.Partitions(topic)
+	if err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+	found := false
+	for _, p := range partitions {
+		if p == partition {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return 0, 0, errors.NotFoundf("topic %s partition %d", topic, partition)
+	}
+
+	if oldest, err = m.kClient.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+	if newest, err = m.kClient.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+	return oldest, newest, nil
+}
+
+// 从指定的offset开始读取partition中最多count条消息, 不属于任何group, 不会提交offset.
+// offset已经被清理时从最早的消息开始读取, 读到最新的消息或者超时后返回
+func (m *Manager) FetchMessages(topic string, partition int32, offset int64, count int) ([]*sarama.ConsumerMessage, error) {
+
+	oldest, newest, err := m.PartitionOffsets(topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	if offset < oldest {
+		offset = oldest
+	}
+	if count <= 0 || offset >= newest {
+		return nil, nil
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(m.kClient)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer consumer.Close()
+	pc, err := consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer pc.Close()
+
+	msgs := make([]*sarama.ConsumerMessage, 0, count)
+	timer := time.NewTimer(fetchTimeout)
+	defer timer.Stop()
+	for len(msgs) < count {
+		select {
+		case msg := <-pc.Messages():
+			msgs = append(msgs, msg)
+			if msg.Offset >= newest-1 {
+				return msgs, nil
+			}
+		case err := <-pc.Errors():
+			return msgs, errors.Trace(err)
+		case <-timer.C:
+			return msgs, nil
+		}
+	}
+	return msgs, nil
+}
+
+// 读取指定offset的一条消息, offset超出范围时返回NotFound
+func (m *Manager) FetchMessage(topic string, partition int32, offset int64) (*sarama.ConsumerMessage, error) {
+
+	oldest, newest, err := m.PartitionOffsets(topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	if offset < oldest || offset >= newest {
+		return nil, errors.NotFoundf("topic %s partition %d offset %d, available [%d, %d)",
+			topic, partition, offset, oldest, newest)
+	}
+
+	msgs, err := m.FetchMessages(topic, partition, offset, 1)
+	if err != nil {
+		return nil, err
+	}
+	// compact的topic中offset可能不连续
+	if len(msgs) == 0 || msgs[0].Offset != offset {
+		return nil, errors.NotFoundf("topic %s partition %d offset %d", topic, partition, offset)
+	}
+	return msgs[0], nil
+}
+
+// 从各个partition当前最新的位置开始读取新写入的消息, 不属于任何group, 不会提交offset.
+// 持续duration或者fn返回false后返回
+func (m *Manager) TailMessages(topic string, duration time.Duration, fn func(*sarama.ConsumerMessage) bool) error {
+
+	partitions, err := m.Partitions(topic)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	consumer, err := sarama.NewConsumerFromClient(m.kClient)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer consumer.Close()
+
+	msgs := make(chan *sarama.ConsumerMessage)
+	errs := make(chan error, len(partitions))
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	pcs := make([]sarama.PartitionConsumer, 0, len(partitions))
+	defer func() {
+		close(done)
+		for _, pc := range pcs {
+			pc.AsyncClose()
+		}
+		wg.Wait()
+	}()
+
+	for _, partition := range partitions {
+		pc, err := consumer.ConsumePartition(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		pcs = append(pcs, pc)
+		wg.Add(1)
+		go func(pc sarama.PartitionConsumer) {
+			defer wg.Done()
+			for {
+				select {
+				case msg, ok := <-pc.Messages():
+					if !ok {
+						return
+					}
+					select {
+					case msgs <- msg:
+					case <-done:
+						return
+					}
+				case err, ok := <-pc.Errors():
+					if ok {
+						errs <- err
+					}
+					return
+				case <-done:
+					return
+				}
+			}
+		}(pc)
+	}
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-msgs:
+			if !fn(msg) {
+				return nil
+			}
+		case err := <-errs:
+			return errors.Trace(err)
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+// 删除topic中当前已经写入的所有消息(delete-records到high watermark), topic本身和offset保持不变.
+// 返回删除后每个partition最早的offset, 需要kafka 0.11以上
+func (m *Manager) PurgeTopic(topic string) (map[int32]int64, error) {
+
+	if !m.kClient.Config().Version.IsAtLeast(sarama.V0_11_0_0) {
+		return nil, errors.NotSupportedf("purge topic with kafka version %s", m.kClient.Config().Version)
+	}
+
+	newest, err := m.FetchTopicOffsets(topic, sarama.OffsetNewest)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return m.DeleteRecords(topic, newest)
+}
+
+// 删除partition中offset之前的消息, 返回删除后每个partition最早的offset, 需要kafka 0.11以上
+func (m *Manager) DeleteRecords(topic string, offsets map[int32]int64) (map[int32]int64, error) {
+
+	if !m.kClient.Config().Version.IsAtLeast(sarama.V0_11_0_0) {
+		return nil, errors.NotSupportedf("delete records with kafka version %s", m.kClient.Config().Version)
+	}
+
+	// 每个partition的请求需要发送给其leader
+	requests := make(map[*sarama.Broker]*sarama.DeleteRecordsRequest)
+	for partition, offset := range offsets {
+		leader, err := m.kClient.Leader(topic, partition)
+		if err != nil {
+			return nil, errors.Annotatef(err, "leader of topic %s partition %d", topic, partition)
+		}
+		req, ok := requests[leader]
+		if !ok {
+			req = &sarama.DeleteRecordsRequest{
+				Topics: map[string]*sarama.DeleteRecordsRequestTopic{
+					topic: {PartitionOffsets: make(map[int32]int64)},
+				},
+				Timeout: purgeTimeout,
+			}
+			requests[leader] = req
+		}
+		req.Topics[topic].PartitionOffsets[partition] = offset
+	}
+
+	lowWatermarks := make(map[int32]int64, len(offsets))
+	for broker, req := range requests {
+		response, err := broker.DeleteRecords(req)
+		if err != nil {
+			return nil, errors.Annotatef(err, "delete records of topic %s at broker %s", topic, broker.Addr())
+		}
+		result, ok := response.Topics[topic]
+		if !ok {
+			return nil, sarama.ErrIncompleteResponse
+		}
+		for partition, block := range result.Partitions {
+			if block.Err != sarama.ErrNoError {
+				return nil, errors.Annotatef(block.Err, "delete records of topic %s partition %d", topic, partition)
+			}
+			lowWatermarks[partition] = block.LowWatermark
+		}
+	}
+	return lowWatermarks, nil
+}
+
 // 获得指定topic, group堆积的消息的信息
 func (m *Manager) Accumulation(topic, group string) (int64, int64, error) {
 	totalCount := int64(0)
@@ -23,6 +23,7 @@ import (
 
 type Producer struct {
 	sarama.SyncProducer
+	version sarama.KafkaVersion
 }
 
 func NewProducer(brokerAddrs []string, conf *sarama.Config) (*Producer, error) {
@@ -30,14 +31,57 @@ func NewProducer(brokerAddrs []string, conf *sarama.Config) (*Producer, error) {
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	return &Producer{producer}, nil
+	return &Producer{SyncProducer: producer, version: conf.Version}, nil
 }
 
-func (p *Producer) Send(topic string, key, data []byte) (partition int32, offset int64, err error) {
+// 返回conf的一份拷贝, 开启幂等写入. 幂等写入要求kafka 0.11以上, acks=all, 且同一连接上只有一个未完成的请求以保证顺序
+func IdempotentConfig(conf *sarama.Config) *sarama.Config {
+	c := *conf
+	c.Producer.Idempotent = true
+	c.Producer.RequiredAcks = sarama.WaitForAll
+	c.Net.MaxOpenRequests = 1
+	if c.Producer.Retry.Max < 1 {
+		c.Producer.Retry.Max = 1
+	}
+	if !c.Version.IsAtLeast(sarama.V0_11_0_0) {
+		c.Version = sarama.V0_11_0_0
+	}
+	return &c
+}
 
+// kafka 0.11以下不支持消息header, 此时忽略headers
+func (p *Producer) Send(topic string, key, data []byte, headers []sarama.RecordHeader) (partition int32, offset int64, err error) {
+
+	if !p.version.IsAtLeast(sarama.V0_11_0_0) {
+		headers = nil
+	}
 	return p.SendMessage(&sarama.ProducerMessage{
-		Topic: topic,
-		Key:   sarama.ByteEncoder(key),
-		Value: sarama.ByteEncoder(data),
+		Topic:   topic,
+		Key:     sarama.ByteEncoder(key),
+		Value:   sarama.ByteEncoder(data),
+		Headers: headers,
 	})
 }
+
+// 一条消息拆成多个分片发送, 分片使用相同的key, 需要按key选择partition以写入同一个partition.
+// headers[i]为第i个分片的header, 返回最后一个分片的位置
+func (p *Producer) SendChunks(topic string, key []byte, chunks [][]byte, headers [][]sarama.RecordHeader) (partition int32, offset int64, err error) {
+
+	msgs := make([]*sarama.ProducerMessage, len(chunks))
+	for i, chunk := range chunks {
+		msgs[i] = &sarama.ProducerMessage{
+			Topic:   topic,
+			Key:     sarama.ByteEncoder(key),
+			Value:   sarama.ByteEncoder(chunk),
+			Headers: headers[i],
+		}
+	}
+	if err = p.SendMessages(msgs); err != nil {
+		if errs, ok := err.(sarama.ProducerErrors); ok && len(errs) > 0 {
+			err = errs[0].Err
+		}
+		return 0, 0, err
+	}
+	last := msgs[len(msgs)-1]
+	return last.Partition, last.Offset, nil
+}
@@ -16,7 +16,11 @@ limitations under the License.
 
 package kafka
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
 
 func TestProducer(t *testing.T) {
 	//	producer := NewProducer([]string{"localhost:2181"}, nil)
@@ -27,3 +31,20 @@ func TestProducer(t *testing.T) {
 	//		}
 	//	}
 }
+
+func TestIdempotentConfig(t *testing.T) {
+	conf := sarama.NewConfig()
+	conf.Producer.RequiredAcks = sarama.WaitForLocal
+	conf.Net.MaxOpenRequests = 20
+
+	c := IdempotentConfig(conf)
+	if !c.Producer.Idempotent || c.Producer.RequiredAcks != sarama.WaitForAll || c.Net.MaxOpenRequests != 1 {
+		t.Errorf("idempotent config not set: %+v", c.Producer)
+	}
+	if !c.Version.IsAtLeast(sarama.V0_11_0_0) {
+		t.Errorf("idempotent producer requires kafka 0.11, got %s", c.Version)
+	}
+	if conf.Producer.Idempotent || conf.Net.MaxOpenRequests != 20 {
+		t.Errorf("origin config should not be modified")
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/weibocom/wqs/engine/zookeeper"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+const (
+	adminReassignPath = "/admin/reassign_partitions"
+	adminElectionPath = "/admin/preferred_replica_election"
+)
+
+// zookeeper中/admin/reassign_partitions和/admin/preferred_replica_election节点的格式,
+// 与kafka-reassign-partitions.sh和kafka-preferred-replica-election.sh相同, controller执行完成后删除节点
+type adminPartition struct {
+	Topic     string  `json:"topic"`
+	Partition int32   `json:"partition"`
+	Replicas  []int32 `json:"replicas,omitempty"`
+}
+
+type adminPartitions struct {
+	Version    int32            `json:"version"`
+	Partitions []adminPartition `json:"partitions"`
+}
+
+func newAdminPartitions(topic string, assignment map[int32][]int32) *adminPartitions {
+	partitions := make([]int32, 0, len(assignment))
+	for partition := range assignment {
+		partitions = append(partitions, partition)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+	data := &adminPartitions{Version: kafkaVersion, Partitions: make([]adminPartition, 0, len(partitions))}
+	for _, partition := range partitions {
+		data.Partitions = append(data.Partitions,
+			adminPartition{Topic: topic, Partition: partition, Replicas: assignment[partition]})
+	}
+	return data
+}
+
+func (p *adminPartitions) String() string {
+	data, _ := json.Marshal(p)
+	return string(data)
+}
+
+// topic当前的副本分布
+func (m *Manager) Assignment(topic string) (map[int32][]int32, error) {
+	if err := m.kClient.RefreshMetadata(topic); err != nil {
+		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+			return nil, errors.NotFoundf("topic : %q", topic)
+		}
+		return nil, errors.Trace(err)
+	}
+	partitions, err := m.kClient.Partitions(topic)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	assignment := make(map[int32][]int32, len(partitions))
+	for _, partition := range partitions {
+		replicas, err := m.kClient.Replicas(topic, partition)
+		if err != nil && err != sarama.ErrReplicaNotAvailable {
+			return nil, errors.Trace(err)
+		}
+		assignment[partition] = replicas
+	}
+	return assignment, nil
+}
+
+// 按当前broker列表重新生成副本分布, 分配方式与创建topic相同. replications<=0时保持partition 0的副本数
+func (m *Manager) ReassignPlan(topic string, replications int32) (map[int32][]int32, error) {
+	current, err := m.Assignment(topic)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if replications <= 0 {
+		replications = int32(len(current[0]))
+	}
+	assignment, err := assignReplicasToBrokers(m.BrokersList(), int32(len(current)), replications, -1, 0)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	plan := make(map[int32][]int32, len(assignment))
+	for partition, replicas := range assignment {
+		id, err := strconv.ParseInt(partition, 10, 32)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		plan[int32(id)] = replicas
+	}
+	return plan, nil
+}
+
+// 提交副本重新分布, 由controller异步迁移数据, assignment中没有的partition保持不变.
+// kafka同一时间只执行一个重新分布, 已经有正在执行的时返回AlreadyExists
+func (m *Manager) ReassignPartitions(topic string, assignment map[int32][]int32) error {
+	m.ops.Lock()
+	defer m.ops.Unlock()
+
+	if topic == groupMetadataTopicName {
+		return errors.NotValidf("cannot modify internal topic")
+	}
+	current, err := m.Assignment(topic)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = checkAssignment(current, assignment, m.BrokersList()); err != nil {
+		return errors.Trace(err)
+	}
+
+	path := m.kafkaRoot + adminReassignPath
+	if err = m.zkConn.Create(path, newAdminPartitions(topic, assignment).String(), 0); err != nil {
+		if zookeeper.IsExistError(err) {
+			return errors.AlreadyExistsf("partition reassignment in progress")
+		}
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// topic正在执行的重新分布, 没有时返回空
+func (m *Manager) Reassigning(topic string) (map[int32][]int32, error) {
+	data, _, err := m.zkConn.Get(m.kafkaRoot + adminReassignPath)
+	if zookeeper.IsNoNode(err) {
+		return map[int32][]int32{}, nil
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return parseReassignment(data, topic)
+}
+
+// 把partition的leader切回副本列表中的第一个broker, partitions为空时选举topic的所有partition.
+// 已经有正在执行的选举时返回AlreadyExists
+func (m *Manager) PreferredLeaderElection(topic string, partitions []int32) error {
+	m.ops.Lock()
+	defer m.ops.Unlock()
+
+	current, err := m.Assignment(topic)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	election := make(map[int32][]int32, len(current))
+	if len(partitions) == 0 {
+		for partition := range current {
+			election[partition] = nil
+		}
+	}
+	for _, partition := range partitions {
+		if _, ok := current[partition]; !ok {
+			return errors.NotValidf("partition %d of topic %s", partition, topic)
+		}
+		election[partition] = nil
+	}
+
+	path := m.kafkaRoot + adminElectionPath
+	if err = m.zkConn.Create(path, newAdminPartitions(topic, election).String(), 0); err != nil {
+		if zookeeper.IsExistError(err) {
+			return errors.AlreadyExistsf("preferred leader election in progress")
+		}
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// 副本必须是注册的broker且不能重复, 只能修改已有的partition
+func checkAssignment(current, assignment map[int32][]int32, brokers []int32) error {
+	if len(assignment) == 0 {
+		return errors.NotValidf("empty assignment")
+	}
+	registered := make(map[int32]bool, len(brokers))
+	for _, broker := range brokers {
+		registered[broker] = true
+	}
+	for partition, replicas := range assignment {
+		if _, ok := current[partition]; !ok {
+			return errors.NotValidf("partition %d", partition)
+		}
+		if len(replicas) == 0 {
+			return errors.NotValidf("empty replicas of partition %d", partition)
+		}
+		seen := make(map[int32]bool, len(replicas))
+		for _, broker := range replicas {
+			if !registered[broker] {
+				return errors.NotValidf("broker %d of partition %d", broker, partition)
+			}
+			if seen[broker] {
+				return errors.NotValidf("duplicate broker %d of partition %d", broker, partition)
+			}
+			seen[broker] = true
+		}
+	}
+	return nil
+}
+
+func parseReassignment(data []byte, topic string) (map[int32][]int32, error) {
+	plan := adminPartitions{}
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, errors.Trace(err)
+	}
+	result := make(map[int32][]int32)
+	for _, p := range plan.Partitions {
+		if p.Topic == topic {
+			result[p.Partition] = p.Replicas
+		}
+	}
+	return result, nil
+}
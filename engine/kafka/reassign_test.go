@@ -0,0 +1,64 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+func TestCheckAssignment(t *testing.T) {
+	current := map[int32][]int32{0: {1, 2}, 1: {2, 3}}
+	brokers := []int32{1, 2, 3}
+	if err := checkAssignment(current, map[int32][]int32{0: {2, 3}, 1: {3, 1}}, brokers); err != nil {
+		t.Fatalf("valid assignment err : %v", err)
+	}
+	for _, assignment := range []map[int32][]int32{
+		{},
+		{2: {1, 2}},
+		{0: {}},
+		{0: {1, 4}},
+		{1: {2, 2}},
+	} {
+		if err := checkAssignment(current, assignment, brokers); !errors.IsNotValid(err) {
+			t.Errorf("assignment %v err : %v, want not valid", assignment, err)
+		}
+	}
+}
+
+func TestAdminPartitions(t *testing.T) {
+	data := newAdminPartitions("q1", map[int32][]int32{1: {2, 3}, 0: {1, 2}}).String()
+	want := `{"version":1,"partitions":[{"topic":"q1","partition":0,"replicas":[1,2]},{"topic":"q1","partition":1,"replicas":[2,3]}]}`
+	if data != want {
+		t.Errorf("reassignment %s, want %s", data, want)
+	}
+	election := newAdminPartitions("q1", map[int32][]int32{0: nil}).String()
+	if election != `{"version":1,"partitions":[{"topic":"q1","partition":0}]}` {
+		t.Errorf("election %s", election)
+	}
+
+	reassigning, err := parseReassignment([]byte(`{"version":1,"partitions":[`+
+		`{"topic":"q1","partition":1,"replicas":[2,3]},{"topic":"q2","partition":0,"replicas":[1]}]}`), "q1")
+	if err != nil {
+		t.Fatalf("parse reassignment err : %v", err)
+	}
+	if !reflect.DeepEqual(reassigning, map[int32][]int32{1: {2, 3}}) {
+		t.Errorf("reassigning %v", reassigning)
+	}
+}
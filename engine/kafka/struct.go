@@ -31,10 +31,9 @@ func (b *brokerConfig) LoadFromBytes(data []byte) error {
 	return json.Unmarshal(data, b)
 }
 
-type topicConfig struct {
-	// {"segment.bytes":"104857600","compression.type":"uncompressed","cleanup.policy":"compact"}}
-	// empty object by default
-}
+// {"segment.bytes":"104857600","compression.type":"uncompressed","cleanup.policy":"compact"}}
+// empty object by default
+type topicConfig map[string]string
 
 type topicInfo struct {
 	Version int32       `json:"version"`
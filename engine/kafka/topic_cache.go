@@ -0,0 +1,74 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"sync"
+	"time"
+)
+
+// topic列表的缓存时间, 创建和删除topic时立即失效
+const topicCacheTTL = 5 * time.Second
+
+// 缓存topic列表, 避免每次ExistTopic都复制sarama client中的metadata
+type topicCache struct {
+	ttl    time.Duration
+	load   func() ([]string, error)
+	mu     sync.RWMutex
+	topics map[string]struct{}
+	expire time.Time
+}
+
+func newTopicCache(ttl time.Duration, load func() ([]string, error)) *topicCache {
+	return &topicCache{ttl: ttl, load: load}
+}
+
+func (c *topicCache) exist(topic string) (bool, error) {
+	now := time.Now()
+	c.mu.RLock()
+	if now.Before(c.expire) {
+		_, ok := c.topics[topic]
+		c.mu.RUnlock()
+		return ok, nil
+	}
+	c.mu.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// 其他goroutine可能已经重新加载
+	if now.Before(c.expire) {
+		_, ok := c.topics[topic]
+		return ok, nil
+	}
+	topics, err := c.load()
+	if err != nil {
+		return false, err
+	}
+	c.topics = make(map[string]struct{}, len(topics))
+	for _, t := range topics {
+		c.topics[t] = struct{}{}
+	}
+	c.expire = time.Now().Add(c.ttl)
+	_, ok := c.topics[topic]
+	return ok, nil
+}
+
+func (c *topicCache) invalidate() {
+	c.mu.Lock()
+	c.expire = time.Time{}
+	c.mu.Unlock()
+}
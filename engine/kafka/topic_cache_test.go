@@ -0,0 +1,78 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTopicCache(t *testing.T) {
+	loads := 0
+	topics := []string{"q1"}
+	var loadErr error
+	cache := newTopicCache(time.Hour, func() ([]string, error) {
+		loads++
+		return topics, loadErr
+	})
+
+	for i := 0; i < 3; i++ {
+		if exist, err := cache.exist("q1"); !exist || err != nil {
+			t.Fatalf("q1 should exist: %v", err)
+		}
+		if exist, _ := cache.exist("q2"); exist {
+			t.Fatalf("q2 should not exist")
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("expect load once, got %d", loads)
+	}
+
+	topics = append(topics, "q2")
+	if exist, _ := cache.exist("q2"); exist {
+		t.Errorf("cached result should not change before invalidate")
+	}
+	cache.invalidate()
+	if exist, _ := cache.exist("q2"); !exist || loads != 2 {
+		t.Errorf("q2 should exist after invalidate, loads %d", loads)
+	}
+
+	cache.invalidate()
+	loadErr = errors.New("metadata error")
+	if _, err := cache.exist("q1"); err != loadErr {
+		t.Errorf("expect load error, got %v", err)
+	}
+	loadErr = nil
+	if exist, err := cache.exist("q1"); !exist || err != nil || loads != 4 {
+		t.Errorf("should reload after error: %v %v %d", exist, err, loads)
+	}
+}
+
+func TestTopicCacheExpire(t *testing.T) {
+	loads := 0
+	cache := newTopicCache(10*time.Millisecond, func() ([]string, error) {
+		loads++
+		return nil, nil
+	})
+	cache.exist("q1")
+	time.Sleep(20 * time.Millisecond)
+	cache.exist("q1")
+	if loads != 2 {
+		t.Errorf("expect reload after ttl, got %d loads", loads)
+	}
+}
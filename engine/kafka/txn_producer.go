@@ -0,0 +1,319 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// 事务中的一条消息
+type TxnMessage struct {
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers []sarama.RecordHeader
+}
+
+// 事务中消息写入的位置, 与TxnMessage一一对应
+type TxnOffset struct {
+	Partition int32
+	Offset    int64
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// 事务producer, 一批消息全部写入后提交, 任一消息失败时abort, read_committed的consumer看不到abort的消息.
+// sarama没有实现事务, 这里直接使用InitProducerId、AddPartitionsToTxn、Produce和EndTxn请求, 要求kafka 0.11以上.
+// 同一个transactional.id同一时间只能有一个producer, 新的producer初始化后旧的被fence. 事务串行执行
+type TxnProducer struct {
+	client  sarama.Client
+	id      string
+	timeout time.Duration
+
+	mu          sync.Mutex
+	coordinator *sarama.Broker
+	producerID  int64
+	epoch       int16
+	sequences   map[topicPartition]int32
+}
+
+func NewTxnProducer(brokerAddrs []string, conf *sarama.Config, transactionalID string, timeout time.Duration) (*TxnProducer, error) {
+	if !conf.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return nil, errors.NotSupportedf("transaction with kafka version %s", conf.Version)
+	}
+	client, err := sarama.NewClient(brokerAddrs, conf)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return newTxnProducer(client, transactionalID, timeout), nil
+}
+
+func newTxnProducer(client sarama.Client, transactionalID string, timeout time.Duration) *TxnProducer {
+	return &TxnProducer{client: client, id: transactionalID, timeout: timeout, producerID: -1}
+}
+
+// 在一个事务中写入msgs, 成功时返回各消息的位置. 失败时事务已经abort, 可以整体重试
+func (p *TxnProducer) Send(msgs []TxnMessage) ([]TxnOffset, error) {
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.producerID < 0 {
+		if err := p.init(); err != nil {
+			p.reset()
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	batches := make(map[topicPartition]*sarama.RecordBatch)
+	offsets := make([]TxnOffset, len(msgs))
+	deltas := make([]int64, len(msgs))
+	for i, msg := range msgs {
+		partition, err := p.partition(msg)
+		if err != nil {
+			return nil, err
+		}
+		tp := topicPartition{topic: msg.Topic, partition: partition}
+		batch, ok := batches[tp]
+		if !ok {
+			batch = &sarama.RecordBatch{
+				Version:         2,
+				FirstTimestamp:  now,
+				MaxTimestamp:    now,
+				ProducerID:      p.producerID,
+				ProducerEpoch:   p.epoch,
+				FirstSequence:   p.sequences[tp],
+				IsTransactional: true,
+			}
+			batches[tp] = batch
+		}
+		headers := make([]*sarama.RecordHeader, len(msg.Headers))
+		for j := range msg.Headers {
+			headers[j] = &msg.Headers[j]
+		}
+		deltas[i] = int64(len(batch.Records))
+		batch.Records = append(batch.Records, &sarama.Record{
+			Key:         msg.Key,
+			Value:       msg.Value,
+			Headers:     headers,
+			OffsetDelta: deltas[i],
+		})
+		batch.LastOffsetDelta = int32(deltas[i])
+		offsets[i].Partition = partition
+	}
+
+	base, err := p.produce(batches)
+	if err != nil {
+		p.abort()
+		return nil, err
+	}
+	if err = p.endTxn(true); err != nil {
+		// 提交的结果未知, 重新初始化时coordinator会完成或abort该事务
+		p.reset()
+		return nil, err
+	}
+	for tp, batch := range batches {
+		p.sequences[tp] += int32(len(batch.Records))
+	}
+	for i, msg := range msgs {
+		offsets[i].Offset = base[topicPartition{topic: msg.Topic, partition: offsets[i].Partition}] + deltas[i]
+	}
+	return offsets, nil
+}
+
+func (p *TxnProducer) Close() error {
+	p.mu.Lock()
+	p.reset()
+	p.mu.Unlock()
+	return p.client.Close()
+}
+
+// key相同的消息写入同一个partition, 与普通producer的分区方式一致
+func (p *TxnProducer) partition(msg TxnMessage) (int32, error) {
+	partitions, err := p.client.Partitions(msg.Topic)
+	if err != nil {
+		return 0, errors.Annotatef(err, "partitions of topic %q", msg.Topic)
+	}
+	if len(partitions) == 0 {
+		return 0, errors.NotFoundf("partitions of topic %q", msg.Topic)
+	}
+	partitioner := sarama.NewHashPartitioner(msg.Topic)
+	i, err := partitioner.Partition(&sarama.ProducerMessage{Topic: msg.Topic, Key: sarama.ByteEncoder(msg.Key)}, int32(len(partitions)))
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return partitions[i], nil
+}
+
+// 查找transaction coordinator并获取producer id和epoch, 同时fence使用同一个transactional.id的旧producer
+func (p *TxnProducer) init() error {
+	coordinator, err := p.findCoordinator()
+	if err != nil {
+		return err
+	}
+	p.coordinator = coordinator
+	response, err := coordinator.InitProducerID(&sarama.InitProducerIDRequest{
+		TransactionalID:    &p.id,
+		TransactionTimeout: p.timeout,
+	})
+	if err != nil {
+		return errors.Annotatef(err, "init producer id of %q", p.id)
+	}
+	if response.Err != sarama.ErrNoError {
+		return errors.Annotatef(response.Err, "init producer id of %q", p.id)
+	}
+	p.producerID, p.epoch = response.ProducerID, response.ProducerEpoch
+	p.sequences = make(map[topicPartition]int32)
+	return nil
+}
+
+func (p *TxnProducer) findCoordinator() (*sarama.Broker, error) {
+	conf := p.client.Config()
+	request := &sarama.FindCoordinatorRequest{
+		Version:         1,
+		CoordinatorKey:  p.id,
+		CoordinatorType: sarama.CoordinatorTransaction,
+	}
+	err := error(sarama.ErrOutOfBrokers)
+	for _, broker := range p.client.Brokers() {
+		if ok, _ := broker.Connected(); !ok {
+			if err = broker.Open(conf); err != nil && err != sarama.ErrAlreadyConnected {
+				continue
+			}
+		}
+		var response *sarama.FindCoordinatorResponse
+		if response, err = broker.FindCoordinator(request); err != nil {
+			continue
+		}
+		if response.Err != sarama.ErrNoError {
+			return nil, errors.Annotatef(response.Err, "find transaction coordinator of %q", p.id)
+		}
+		coordinator := response.Coordinator
+		if err = coordinator.Open(conf); err != nil && err != sarama.ErrAlreadyConnected {
+			return nil, errors.Trace(err)
+		}
+		return coordinator, nil
+	}
+	return nil, errors.Annotatef(err, "find transaction coordinator of %q", p.id)
+}
+
+// 先把partition加入事务, 再按leader分组写入, 返回各partition的base offset
+func (p *TxnProducer) produce(batches map[topicPartition]*sarama.RecordBatch) (map[topicPartition]int64, error) {
+	partitions := make(map[string][]int32)
+	for tp := range batches {
+		partitions[tp.topic] = append(partitions[tp.topic], tp.partition)
+	}
+	response, err := p.coordinator.AddPartitionsToTxn(&sarama.AddPartitionsToTxnRequest{
+		TransactionalID: p.id,
+		ProducerID:      p.producerID,
+		ProducerEpoch:   p.epoch,
+		TopicPartitions: partitions,
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "add partitions to transaction %q", p.id)
+	}
+	for topic, errs := range response.Errors {
+		for _, e := range errs {
+			if e.Err != sarama.ErrNoError {
+				return nil, errors.Annotatef(e.Err, "add partition %s/%d to transaction %q", topic, e.Partition, p.id)
+			}
+		}
+	}
+
+	requests := make(map[*sarama.Broker]*sarama.ProduceRequest)
+	for tp, batch := range batches {
+		leader, err := p.client.Leader(tp.topic, tp.partition)
+		if err != nil {
+			return nil, errors.Annotatef(err, "leader of %s/%d", tp.topic, tp.partition)
+		}
+		request, ok := requests[leader]
+		if !ok {
+			request = &sarama.ProduceRequest{
+				Version:         3,
+				TransactionalID: &p.id,
+				RequiredAcks:    sarama.WaitForAll,
+				Timeout:         int32(p.client.Config().Producer.Timeout / time.Millisecond),
+			}
+			requests[leader] = request
+		}
+		request.AddBatch(tp.topic, tp.partition, batch)
+	}
+
+	base := make(map[topicPartition]int64, len(batches))
+	for leader, request := range requests {
+		response, err := leader.Produce(request)
+		if err != nil {
+			return nil, errors.Annotatef(err, "produce to broker %d", leader.ID())
+		}
+		for tp := range batches {
+			block := response.GetBlock(tp.topic, tp.partition)
+			if block == nil {
+				continue
+			}
+			if block.Err != sarama.ErrNoError {
+				return nil, errors.Annotatef(block.Err, "produce to %s/%d", tp.topic, tp.partition)
+			}
+			base[tp] = block.Offset
+		}
+	}
+	for tp := range batches {
+		if _, ok := base[tp]; !ok {
+			return nil, errors.Annotatef(sarama.ErrIncompleteResponse, "produce to %s/%d", tp.topic, tp.partition)
+		}
+	}
+	return base, nil
+}
+
+func (p *TxnProducer) endTxn(commit bool) error {
+	response, err := p.coordinator.EndTxn(&sarama.EndTxnRequest{
+		TransactionalID:   p.id,
+		ProducerID:        p.producerID,
+		ProducerEpoch:     p.epoch,
+		TransactionResult: commit,
+	})
+	if err != nil {
+		return errors.Annotatef(err, "end transaction %q", p.id)
+	}
+	if response.Err != sarama.ErrNoError {
+		return errors.Annotatef(response.Err, "end transaction %q", p.id)
+	}
+	return nil
+}
+
+// abort后sequence可能已经不连续, 下一个事务重新初始化producer id
+func (p *TxnProducer) abort() {
+	p.endTxn(false)
+	p.reset()
+}
+
+func (p *TxnProducer) reset() {
+	p.producerID = -1
+	if p.coordinator != nil {
+		p.coordinator.Close()
+		p.coordinator = nil
+	}
+}
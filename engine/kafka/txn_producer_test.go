@@ -0,0 +1,113 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func newTxnMockBroker(t *testing.T) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("t", 0, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockWrapper(&sarama.FindCoordinatorResponse{
+			Version:     1,
+			Coordinator: sarama.NewBroker(broker.Addr()),
+		}),
+		"InitProducerIDRequest": sarama.NewMockWrapper(&sarama.InitProducerIDResponse{ProducerID: 7, ProducerEpoch: 1}),
+		"AddPartitionsToTxnRequest": sarama.NewMockWrapper(&sarama.AddPartitionsToTxnResponse{
+			Errors: map[string][]*sarama.PartitionError{"t": {{Partition: 0, Err: sarama.ErrNoError}}},
+		}),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3),
+		"EndTxnRequest":  sarama.NewMockWrapper(&sarama.EndTxnResponse{}),
+	})
+	return broker
+}
+
+func txnRequests(broker *sarama.MockBroker) (inits int, commits int, aborts int) {
+	for _, rr := range broker.History() {
+		switch req := rr.Request.(type) {
+		case *sarama.InitProducerIDRequest:
+			inits++
+		case *sarama.EndTxnRequest:
+			if req.TransactionResult {
+				commits++
+			} else {
+				aborts++
+			}
+		}
+	}
+	return
+}
+
+func TestTxnProducerVersion(t *testing.T) {
+	conf := sarama.NewConfig()
+	conf.Version = sarama.V0_10_2_0
+	if _, err := NewTxnProducer([]string{"localhost:9092"}, conf, "wqs-1", time.Second); err == nil {
+		t.Errorf("expect error for kafka version %s", conf.Version)
+	}
+}
+
+func TestTxnProducerSend(t *testing.T) {
+	broker := newTxnMockBroker(t)
+	defer broker.Close()
+
+	conf := sarama.NewConfig()
+	conf.Version = sarama.V0_11_0_0
+	producer, err := NewTxnProducer([]string{broker.Addr()}, conf, "wqs-1", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+
+	offsets, err := producer.Send([]TxnMessage{{Topic: "t", Value: []byte("a")}, {Topic: "t", Value: []byte("b")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) != 2 || offsets[0] != (TxnOffset{0, 0}) || offsets[1] != (TxnOffset{0, 1}) {
+		t.Errorf("unexpected offsets %v", offsets)
+	}
+	if producer.producerID != 7 || producer.epoch != 1 || producer.sequences[topicPartition{"t", 0}] != 2 {
+		t.Errorf("unexpected producer state %d %d %v", producer.producerID, producer.epoch, producer.sequences)
+	}
+	if inits, commits, aborts := txnRequests(broker); inits != 1 || commits != 1 || aborts != 0 {
+		t.Errorf("expect 1 init and 1 commit, got %d %d %d", inits, commits, aborts)
+	}
+
+	// 写入失败时abort, 下一个事务重新初始化producer id
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("t", 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3).SetError("t", 0, sarama.ErrNotEnoughReplicas),
+		"AddPartitionsToTxnRequest": sarama.NewMockWrapper(&sarama.AddPartitionsToTxnResponse{
+			Errors: map[string][]*sarama.PartitionError{"t": {{Partition: 0, Err: sarama.ErrNoError}}},
+		}),
+		"EndTxnRequest": sarama.NewMockWrapper(&sarama.EndTxnResponse{}),
+	})
+	if _, err = producer.Send([]TxnMessage{{Topic: "t", Value: []byte("c")}}); err == nil {
+		t.Fatal("expect produce error")
+	}
+	if _, _, aborts := txnRequests(broker); aborts != 1 || producer.producerID != -1 {
+		t.Errorf("expect abort and reset, got %d aborts producer id %d", aborts, producer.producerID)
+	}
+}
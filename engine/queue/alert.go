@@ -0,0 +1,319 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/juju/errors"
+)
+
+const (
+	alertLag         = "lag"
+	alertDepth       = "depth"
+	alertSendError   = "send_error_rate"
+	alertStale       = "stale_commit"
+	alertFiring      = "firing"
+	alertResolved    = "resolved"
+	alertHookTimeout = 3 * time.Second
+)
+
+type alertRecord struct {
+	Proxy     int     `json:"proxy"`
+	Host      string  `json:"host"`
+	Type      string  `json:"type"`
+	Status    string  `json:"status"`
+	Queue     string  `json:"queue,omitempty"`
+	Group     string  `json:"group,omitempty"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Time      int64   `json:"time"`
+}
+
+// 没有queue的报警是proxy级别的(如send_error_rate), 每个proxy各自一个节点
+func (r *alertRecord) key() string {
+	if r.Queue == "" {
+		return fmt.Sprintf("%s.%d", r.Type, r.Proxy)
+	}
+	if r.Group == "" {
+		return fmt.Sprintf("%s.%s", r.Type, r.Queue)
+	}
+	return fmt.Sprintf("%s.%s.%s", r.Type, r.Queue, r.Group)
+}
+
+func (r *alertRecord) String() string {
+	data, _ := json.Marshal(r)
+	return string(data)
+}
+
+// alerter依赖的元数据操作, 由Metadata实现
+type alertMetadata interface {
+	Depth(queue string) (int64, error)
+	StalePartitions(queue string, group string, stale time.Duration) ([]int32, error)
+	SaveAlert(key string, data string) error
+	DeleteAlert(key string) error
+	Alerts() (map[string]string, error)
+}
+
+// alerter在每次monitoring时检查堆积, 队列深度, 写入错误率以及长时间没有提交的partition,
+// 超过阈值时调用webhook或者将报警记录写入zookeeper. 只在状态变化时通知, 避免重复报警.
+type alerter struct {
+	proxy              int
+	host               string
+	lagThreshold       int64
+	depthThreshold     int64
+	errorRateThreshold float64
+	staleThreshold     time.Duration
+	webhook            string
+	record             bool
+	metadata           alertMetadata
+	client             *http.Client
+	errorRate          func() float64
+	firing             map[string]bool
+	// 上次evaluate时是否是leader
+	leader bool
+}
+
+// 未配置或者未开启报警时返回nil
+func newAlerter(conf *config.Config, metadata alertMetadata, host string) (*alerter, error) {
+
+	section, err := conf.GetSection("alert")
+	if err != nil {
+		return nil, nil
+	}
+	if !section.GetBoolMust("enable", false) {
+		return nil, nil
+	}
+
+	a := &alerter{
+		proxy:              conf.ProxyId,
+		host:               host,
+		lagThreshold:       section.GetInt64Must("lag.threshold", 0),
+		depthThreshold:     section.GetInt64Must("depth.threshold", 0),
+		errorRateThreshold: section.GetFloat64Must("send.error.rate.threshold", 0),
+		staleThreshold:     time.Duration(section.GetInt64Must("stale.commit.minutes", 0)) * time.Minute,
+		webhook:            section.GetStringMust("webhook.url", ""),
+		record:             section.GetBoolMust("zookeeper.record", false),
+		metadata:           metadata,
+		client:             &http.Client{Timeout: alertHookTimeout},
+		errorRate: func() float64 {
+			return metrics.GetMeterRate(metrics.CmdSetError + "." + metrics.Qps)
+		},
+		firing: make(map[string]bool),
+	}
+
+	if a.webhook == "" && !a.record {
+		return nil, errors.NotValidf("alert.webhook.url or alert.zookeeper.record")
+	}
+	return a, nil
+}
+
+// 检查各项指标, 只在monitoring的goroutine中调用, 因此不需要加锁
+// 堆积相关的报警是集群范围的, 只由leader检查, 避免多个proxy重复发送.
+// 写入失败率是每个proxy自己的, 所有proxy都检查
+func (a *alerter) evaluate(accInfos []AccumulationInfo, leader bool) {
+
+	now := time.Now().Unix()
+	checked := make(map[string]bool)
+	if !leader {
+		accInfos = nil
+	}
+	if leader && !a.leader {
+		if err := a.loadRecords(); err != nil {
+			log.Warnf("alert load records err: %v", err)
+			leader, accInfos = false, nil
+		}
+	}
+	a.leader = leader
+
+	if a.lagThreshold > 0 {
+		for _, info := range accInfos {
+			lag := info.Total - info.Consumed
+			a.check(&alertRecord{
+				Type:      alertLag,
+				Queue:     info.Queue,
+				Group:     info.Group,
+				Value:     float64(lag),
+				Threshold: float64(a.lagThreshold),
+				Time:      now,
+			}, lag > a.lagThreshold, checked)
+		}
+	}
+
+	if a.depthThreshold > 0 {
+		queues := make(map[string]bool)
+		for _, info := range accInfos {
+			if queues[info.Queue] {
+				continue
+			}
+			queues[info.Queue] = true
+			depth, err := a.metadata.Depth(info.Queue)
+			if err != nil {
+				log.Warnf("alert get queue %q depth err: %v", info.Queue, err)
+				continue
+			}
+			a.check(&alertRecord{
+				Type:      alertDepth,
+				Queue:     info.Queue,
+				Value:     float64(depth),
+				Threshold: float64(a.depthThreshold),
+				Time:      now,
+			}, depth > a.depthThreshold, checked)
+		}
+	}
+
+	// 只检查有堆积的group, 没有堆积时直接恢复
+	if a.staleThreshold > 0 {
+		for _, info := range accInfos {
+			var stale []int32
+			if info.Total > info.Consumed {
+				var err error
+				if stale, err = a.metadata.StalePartitions(info.Queue, info.Group, a.staleThreshold); err != nil {
+					log.Warnf("alert get queue %q group %q stale partitions err: %v", info.Queue, info.Group, err)
+					continue
+				}
+			}
+			a.check(&alertRecord{
+				Type:      alertStale,
+				Queue:     info.Queue,
+				Group:     info.Group,
+				Value:     float64(len(stale)),
+				Threshold: a.staleThreshold.Minutes(),
+				Time:      now,
+			}, len(stale) > 0, checked)
+		}
+	}
+
+	if a.errorRateThreshold > 0 {
+		rate := a.errorRate()
+		a.check(&alertRecord{
+			Proxy:     a.proxy,
+			Type:      alertSendError,
+			Value:     rate,
+			Threshold: a.errorRateThreshold,
+			Time:      now,
+		}, rate > a.errorRateThreshold, checked)
+	}
+
+	// queue或group已经被删除的报警直接恢复.
+	// 不再是leader时只清理本地状态, 报警记录由新的leader维护
+	for key := range a.firing {
+		if !checked[key] && !leader {
+			delete(a.firing, key)
+			continue
+		}
+		if !checked[key] {
+			delete(a.firing, key)
+			if a.record {
+				if err := a.metadata.DeleteAlert(key); err != nil {
+					log.Warnf("alert delete record %q err: %v", key, err)
+				}
+			}
+		}
+	}
+}
+
+// 成为leader时接管之前的leader记录的集群范围的报警, 之后恢复时删除记录, 仍然超过阈值时不重复通知.
+// 其他proxy的proxy级别报警由它们自己维护
+func (a *alerter) loadRecords() error {
+	if !a.record {
+		return nil
+	}
+	records, err := a.metadata.Alerts()
+	if err != nil {
+		return err
+	}
+	for key, data := range records {
+		r := &alertRecord{}
+		if err := json.Unmarshal([]byte(data), r); err != nil {
+			log.Warnf("alert record %q unmarshal err: %v", key, err)
+			continue
+		}
+		if r.Queue != "" && r.Status == alertFiring {
+			a.firing[key] = true
+		}
+	}
+	return nil
+}
+
+// 关闭报警或不再写入zookeeper时删除本proxy正在触发的报警记录, 否则这些记录不会再被恢复
+func (a *alerter) deleteRecords() {
+	if !a.record {
+		return
+	}
+	for key := range a.firing {
+		if err := a.metadata.DeleteAlert(key); err != nil {
+			log.Warnf("alert delete record %q err: %v", key, err)
+		}
+	}
+}
+
+func (a *alerter) check(r *alertRecord, breached bool, checked map[string]bool) {
+	r.Proxy = a.proxy
+	r.Host = a.host
+	key := r.key()
+	checked[key] = true
+	switch {
+	case breached && !a.firing[key]:
+		a.firing[key] = true
+		r.Status = alertFiring
+	case !breached && a.firing[key]:
+		delete(a.firing, key)
+		r.Status = alertResolved
+	default:
+		return
+	}
+	a.fire(key, r)
+}
+
+func (a *alerter) fire(key string, r *alertRecord) {
+
+	data := r.String()
+	log.Warnf("alert %s: %s", r.Status, data)
+
+	if a.record {
+		var err error
+		if r.Status == alertFiring {
+			err = a.metadata.SaveAlert(key, data)
+		} else {
+			err = a.metadata.DeleteAlert(key)
+		}
+		if err != nil {
+			log.Errorf("alert record %q to zookeeper err: %v", key, err)
+		}
+	}
+
+	if a.webhook != "" {
+		resp, err := a.client.Post(a.webhook, "application/json", bytes.NewBufferString(data))
+		if err != nil {
+			log.Errorf("alert webhook %q err: %v", a.webhook, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			log.Errorf("alert webhook %q response status: %s", a.webhook, resp.Status)
+		}
+	}
+}
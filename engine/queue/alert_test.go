@@ -0,0 +1,261 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+)
+
+const testAlertBaseConfig = `
+proxy.id=1
+ui.dir=./ui
+protocol.http.port=8080
+protocol.mc.port=11211
+protocol.motan.port=8881
+metadata.zookeeper.connect=localhost:2181
+metadata.zookeeper.root=/
+log.info=info.log
+log.debug=debug.log
+log.profile=profile.log
+`
+
+type fakeAlertMetadata struct {
+	depth   map[string]int64
+	stale   map[string][]int32
+	records map[string]string
+}
+
+func (f *fakeAlertMetadata) Depth(queue string) (int64, error) {
+	return f.depth[queue], nil
+}
+
+func (f *fakeAlertMetadata) StalePartitions(queue string, group string, stale time.Duration) ([]int32, error) {
+	return f.stale[queue+"."+group], nil
+}
+
+func (f *fakeAlertMetadata) SaveAlert(key string, data string) error {
+	f.records[key] = data
+	return nil
+}
+
+func (f *fakeAlertMetadata) DeleteAlert(key string) error {
+	delete(f.records, key)
+	return nil
+}
+
+func (f *fakeAlertMetadata) Alerts() (map[string]string, error) {
+	records := make(map[string]string, len(f.records))
+	for key, data := range f.records {
+		records[key] = data
+	}
+	return records, nil
+}
+
+func TestNewAlerter(t *testing.T) {
+	conf, err := config.NewConfigFromBytes([]byte(testAlertBaseConfig + "alert.enable=true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newAlerter(conf, nil, "localhost"); err == nil {
+		t.Fatal("alert without webhook and zookeeper record should be invalid")
+	}
+
+	conf, err = config.NewConfigFromBytes([]byte(testAlertBaseConfig + "alert.enable=false\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, err := newAlerter(conf, nil, "localhost"); err != nil || a != nil {
+		t.Fatalf("disabled alerter should be nil, got %v, %v", a, err)
+	}
+}
+
+func TestAlerterEvaluate(t *testing.T) {
+	hooks := make([]*alertRecord, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record := &alertRecord{}
+		if err := json.NewDecoder(r.Body).Decode(record); err != nil {
+			t.Errorf("decode webhook body err: %v", err)
+		}
+		hooks = append(hooks, record)
+	}))
+	defer server.Close()
+
+	meta := &fakeAlertMetadata{
+		depth:   map[string]int64{"q1": 10},
+		records: make(map[string]string),
+	}
+	rate := 0.0
+	a := &alerter{
+		proxy:              1,
+		host:               "localhost",
+		lagThreshold:       100,
+		depthThreshold:     50,
+		errorRateThreshold: 1,
+		webhook:            server.URL,
+		record:             true,
+		metadata:           meta,
+		client:             http.DefaultClient,
+		errorRate:          func() float64 { return rate },
+		firing:             make(map[string]bool),
+	}
+
+	infos := []AccumulationInfo{{Queue: "q1", Group: "g1", Total: 1000, Consumed: 800}}
+	a.evaluate(infos, true)
+	if len(hooks) != 1 || hooks[0].Type != alertLag || hooks[0].Status != alertFiring {
+		t.Fatalf("expect one lag firing alert, got %v", hooks)
+	}
+	if _, ok := meta.records["lag.q1.g1"]; !ok {
+		t.Fatalf("expect lag alert recorded, got %v", meta.records)
+	}
+
+	// 持续超过阈值不重复报警
+	a.evaluate(infos, true)
+	if len(hooks) != 1 {
+		t.Fatalf("expect no repeated alert, got %d", len(hooks))
+	}
+
+	rate = 5
+	meta.depth["q1"] = 100
+	infos[0].Consumed = 1000
+	a.evaluate(infos, true)
+	if len(hooks) != 4 {
+		t.Fatalf("expect 4 alerts, got %d", len(hooks))
+	}
+	if _, ok := meta.records["lag.q1.g1"]; ok {
+		t.Fatal("resolved lag alert should be removed")
+	}
+	if len(meta.records) != 2 {
+		t.Fatalf("expect depth and error rate alert recorded, got %v", meta.records)
+	}
+	if record, ok := meta.records["send_error_rate.1"]; !ok || !strings.Contains(record, `"proxy":1`) {
+		t.Fatalf("expect error rate alert recorded per proxy, got %v", meta.records)
+	}
+
+	// queue被删除后报警记录一起清理
+	rate = 0
+	a.evaluate(nil, true)
+	if len(meta.records) != 0 || len(a.firing) != 0 {
+		t.Fatalf("expect all alerts resolved, got records %v firing %v", meta.records, a.firing)
+	}
+}
+
+func TestAlerterStaleCommit(t *testing.T) {
+	meta := &fakeAlertMetadata{
+		stale:   map[string][]int32{"q1.g1": {0, 3}},
+		records: make(map[string]string),
+	}
+	a := &alerter{
+		staleThreshold: 5 * time.Minute,
+		record:         true,
+		metadata:       meta,
+		firing:         make(map[string]bool),
+	}
+
+	infos := []AccumulationInfo{
+		{Queue: "q1", Group: "g1", Total: 1000, Consumed: 800},
+		{Queue: "q1", Group: "g2", Total: 1000, Consumed: 1000},
+	}
+	a.evaluate(infos, true)
+	if len(meta.records) != 1 || !a.firing["stale_commit.q1.g1"] {
+		t.Fatalf("expect stale commit alert of g1, got %v", meta.records)
+	}
+
+	// 消费追上之后恢复
+	infos[0].Consumed = 1000
+	a.evaluate(infos, true)
+	if len(meta.records) != 0 || len(a.firing) != 0 {
+		t.Fatalf("expect stale commit alert resolved, got %v", meta.records)
+	}
+}
+
+func TestAlerterFollower(t *testing.T) {
+	meta := &fakeAlertMetadata{
+		depth:   make(map[string]int64),
+		records: make(map[string]string),
+	}
+	rate := 5.0
+	a := &alerter{
+		lagThreshold:       100,
+		errorRateThreshold: 1,
+		record:             true,
+		metadata:           meta,
+		errorRate:          func() float64 { return rate },
+		firing:             make(map[string]bool),
+	}
+
+	infos := []AccumulationInfo{{Queue: "q1", Group: "g1", Total: 1000, Consumed: 800}}
+	a.evaluate(infos, true)
+	if len(meta.records) != 2 {
+		t.Fatalf("expect lag and error rate alert recorded, got %v", meta.records)
+	}
+
+	// 失去leader后只检查本proxy的写入失败率, 堆积报警记录留给新的leader
+	a.evaluate(infos, false)
+	if a.firing["lag.q1.g1"] || !a.firing[alertSendError+".0"] {
+		t.Fatalf("unexpected firing alerts %v", a.firing)
+	}
+	if _, ok := meta.records["lag.q1.g1"]; !ok {
+		t.Fatalf("lag alert record should be kept, got %v", meta.records)
+	}
+}
+
+func TestAlerterTakeOverRecords(t *testing.T) {
+	meta := &fakeAlertMetadata{
+		depth:   make(map[string]int64),
+		records: make(map[string]string),
+	}
+	old := &alerter{proxy: 1, lagThreshold: 100, errorRateThreshold: 1, record: true, metadata: meta,
+		errorRate: func() float64 { return 5 }, firing: make(map[string]bool)}
+	infos := []AccumulationInfo{
+		{Queue: "q1", Group: "g1", Total: 1000, Consumed: 800},
+		{Queue: "q2", Group: "g2", Total: 1000, Consumed: 800},
+	}
+	old.evaluate(infos, true)
+	if len(meta.records) != 3 {
+		t.Fatalf("expect 2 lag and 1 error rate alerts, got %v", meta.records)
+	}
+
+	// 新的leader接管之前的记录: q1仍然堆积不重复通知, q2恢复后删除记录, 旧leader的proxy级别报警不处理
+	fired := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fired++
+	}))
+	defer server.Close()
+	a := &alerter{proxy: 2, lagThreshold: 100, record: true, metadata: meta, webhook: server.URL,
+		client: server.Client(), firing: make(map[string]bool)}
+	infos[1].Consumed = 1000
+	a.evaluate(infos, true)
+	if fired != 1 {
+		t.Errorf("expect only the resolved q2 alert notified, got %d", fired)
+	}
+	if _, ok := meta.records["lag.q1.g1"]; !ok {
+		t.Errorf("lag.q1.g1 should still be firing, got %v", meta.records)
+	}
+	if _, ok := meta.records["lag.q2.g2"]; ok {
+		t.Errorf("lag.q2.g2 should be resolved, got %v", meta.records)
+	}
+	if _, ok := meta.records[alertSendError+".1"]; !ok || a.firing[alertSendError+".1"] {
+		t.Errorf("proxy level alert of proxy 1 should be left alone, records %v firing %v", meta.records, a.firing)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/juju/errors"
+)
+
+const (
+	defaultBreakerFailures = 20
+	defaultBreakerTimeout  = 10
+)
+
+// 熔断状态, 同时作为metrics中Breaker gauge的值
+const (
+	breakerClosed int64 = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// kafka持续出错时熔断器打开, 请求直接返回该错误而不再访问kafka
+type CircuitOpenError struct {
+	Target     string
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	ms := e.RetryAfter.Nanoseconds() / 1e6
+	if ms == 0 {
+		ms = 1
+	}
+	return fmt.Sprintf("kafka %s circuit open, retry after %dms", e.Target, ms)
+}
+
+func IsCircuitOpen(err error) bool {
+	_, ok := errors.Cause(err).(*CircuitOpenError)
+	return ok
+}
+
+// 连续失败threshold次后打开, 经过timeout后进入半开状态放行请求,
+// 半开状态下第一次成功则关闭, 失败则重新打开. nil表示不熔断
+type circuitBreaker struct {
+	name      string
+	threshold int
+	timeout   time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    int64
+	failures int
+	openedAt time.Time
+}
+
+// breaker段是可选的, failures为0时关闭熔断
+func newCircuitBreakers(conf *config.Config) (send *circuitBreaker, recv *circuitBreaker) {
+	failures, timeout := breakerConfig(conf)
+	if failures <= 0 {
+		return nil, nil
+	}
+	return newCircuitBreaker(metrics.CmdSet, failures, timeout),
+		newCircuitBreaker(metrics.CmdGet, failures, timeout)
+}
+
+func breakerConfig(conf *config.Config) (int, time.Duration) {
+	failures, timeout := int64(defaultBreakerFailures), int64(defaultBreakerTimeout)
+	if section, err := conf.GetSection("breaker"); err == nil {
+		failures = section.GetInt64Must("failures", failures)
+		timeout = section.GetInt64Must("timeout", timeout)
+	}
+	return int(failures), time.Duration(timeout) * time.Second
+}
+
+func newCircuitBreaker(name string, threshold int, timeout time.Duration) *circuitBreaker {
+	b := &circuitBreaker{
+		name:      name,
+		threshold: threshold,
+		timeout:   timeout,
+		now:       time.Now,
+	}
+	metrics.AddGauge(name+"."+metrics.Breaker, breakerClosed)
+	return b
+}
+
+// 重新加载配置时修改阈值和熔断时间, 不改变当前的状态
+func (b *circuitBreaker) configure(threshold int, timeout time.Duration) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.threshold = threshold
+	b.timeout = timeout
+	b.mu.Unlock()
+}
+
+// 熔断器打开时返回CircuitOpenError
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerOpen {
+		return nil
+	}
+	if elapsed := b.now().Sub(b.openedAt); elapsed < b.timeout {
+		metrics.AddCounter(b.name+"."+metrics.Rejected, 1)
+		return &CircuitOpenError{Target: b.name, RetryAfter: b.timeout - elapsed}
+	}
+	b.setState(breakerHalfOpen)
+	log.Infof("kafka %s circuit half-open", b.name)
+	return nil
+}
+
+func (b *circuitBreaker) success() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	if b.state == breakerHalfOpen {
+		b.setState(breakerClosed)
+		log.Infof("kafka %s circuit closed", b.name)
+	}
+}
+
+func (b *circuitBreaker) failure(err error) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerClosed:
+		b.failures++
+		if b.failures < b.threshold {
+			return
+		}
+	case breakerOpen:
+		// 打开前已经放行的请求
+		return
+	}
+	b.failures = 0
+	b.openedAt = b.now()
+	b.setState(breakerOpen)
+	metrics.AddCounter(b.name+"."+metrics.Tripped, 1)
+	log.Warnf("kafka %s circuit open for %v, last error: %v", b.name, b.timeout, err)
+}
+
+func (b *circuitBreaker) setState(state int64) {
+	b.state = state
+	metrics.AddGauge(b.name+"."+metrics.Breaker, state)
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker("SET", 3, 10*time.Second)
+	b.now = func() time.Time { return now }
+	fail := errors.New("broker down")
+
+	b.failure(fail)
+	b.failure(fail)
+	b.success()
+	b.failure(fail)
+	b.failure(fail)
+	if err := b.allow(); err != nil || b.state != breakerClosed {
+		t.Fatalf("success should reset failures, state %d err %v", b.state, err)
+	}
+
+	b.failure(fail)
+	err := b.allow()
+	if !IsCircuitOpen(err) || b.state != breakerOpen {
+		t.Fatalf("expect circuit open, state %d err %v", b.state, err)
+	}
+	if retry := err.(*CircuitOpenError).RetryAfter; retry != 10*time.Second {
+		t.Errorf("expect retry after 10s, got %v", retry)
+	}
+
+	// 半开后失败重新熔断
+	now = now.Add(10 * time.Second)
+	if err := b.allow(); err != nil || b.state != breakerHalfOpen {
+		t.Fatalf("expect half-open, state %d err %v", b.state, err)
+	}
+	b.failure(fail)
+	if err := b.allow(); !IsCircuitOpen(err) {
+		t.Fatalf("failure in half-open should reopen, got %v", err)
+	}
+
+	// 半开后成功则关闭
+	now = now.Add(10 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expect half-open, got %v", err)
+	}
+	b.success()
+	if b.state != breakerClosed {
+		t.Errorf("success in half-open should close, state %d", b.state)
+	}
+}
+
+func TestNilCircuitBreaker(t *testing.T) {
+	var b *circuitBreaker
+	b.failure(errors.New("broker down"))
+	b.success()
+	if err := b.allow(); err != nil {
+		t.Errorf("nil breaker should always allow, got %v", err)
+	}
+}
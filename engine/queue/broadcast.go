@@ -0,0 +1,126 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/weibocom/wqs/log"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+const (
+	// 广播group的实例在kafka中使用的consumer group前缀
+	broadcastPrefix = "__wqs_bc."
+	// 实例超过该时间没有接收消息时关闭其consumer
+	broadcastIdle = 5 * time.Minute
+)
+
+var validInstance = regexp.MustCompile(`^[a-zA-Z0-9_.\-]{1,64}$`)
+
+type instanceKey struct{}
+
+// 接收和ACK时携带的客户端实例id, 广播模式的group需要
+func WithInstance(ctx context.Context, instance string) context.Context {
+	return context.WithValue(ctx, instanceKey{}, instance)
+}
+
+func instanceFrom(ctx context.Context) string {
+	instance, _ := ctx.Value(instanceKey{}).(string)
+	return instance
+}
+
+// 记录广播group每个实例最后一次使用的时间, 用于关闭已经下线的实例的consumer
+type broadcastKeeper struct {
+	// 新的实例只接收之后写入的消息
+	config *sarama.Config
+
+	mu       sync.Mutex
+	lastSeen map[string]time.Time
+}
+
+func newBroadcastKeeper(clusterConfig *sarama.Config) *broadcastKeeper {
+	config := *clusterConfig
+	config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	return &broadcastKeeper{
+		config:   &config,
+		lastSeen: make(map[string]time.Time),
+	}
+}
+
+func (k *broadcastKeeper) touch(owner string) {
+	k.mu.Lock()
+	k.lastSeen[owner] = time.Now()
+	k.mu.Unlock()
+}
+
+func (k *broadcastKeeper) idle(now time.Time) []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	owners := make([]string, 0)
+	for owner, t := range k.lastSeen {
+		if now.Sub(t) > broadcastIdle {
+			owners = append(owners, owner)
+			delete(k.lastSeen, owner)
+		}
+	}
+	return owners
+}
+
+// 返回接收和ACK使用的consumer的key, kafka consumer group和配置.
+// 广播模式的group中每个客户端实例使用单独的kafka consumer group, 因此每个实例都能收到全部消息
+func (q *queueImp) consumerOwner(ctx context.Context, queue string, group string) (string, string, *sarama.Config, error) {
+
+	config, err := q.metadata.GetGroupConfig(group, queue)
+	if err != nil || !config.Broadcast {
+		return q.keys.owner(queue, group), group, q.groupClusterConfig(config), nil
+	}
+
+	instance := instanceFrom(ctx)
+	if !validInstance.MatchString(instance) {
+		return "", "", nil, errors.NotValidf("instance %q of broadcast group %q", instance, group)
+	}
+	owner := queue + "@" + group + "@" + instance
+	q.broadcasts.touch(owner)
+	return owner, broadcastPrefix + group + "." + instance, q.broadcasts.config, nil
+}
+
+// 关闭长时间没有接收消息的广播实例的consumer, 其在kafka中的offset按retention过期
+func (q *queueImp) closeIdleBroadcasts() {
+	for _, idle := range q.broadcasts.idle(time.Now()) {
+		for _, owner := range priorityOwners(idle) {
+			consumer, ok := q.consumers.remove(owner)
+			if ok {
+				consumer.Close()
+				log.Infof("close idle broadcast consumer %s", owner)
+			}
+		}
+	}
+}
+
+// 开启后group中每个客户端实例都会收到全部消息, 新的实例从最新的消息开始接收
+func (q *queueImp) SetGroupBroadcast(group string, queue string, broadcast bool) error {
+	return q.metadata.ModifyGroupConfig(group, queue, func(config *GroupConfig) error {
+		config.Broadcast = broadcast
+		return nil
+	})
+}
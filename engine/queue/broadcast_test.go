@@ -0,0 +1,68 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestBroadcastKeeper(t *testing.T) {
+	clusterConfig := genClusterConfig("test")
+	k := newBroadcastKeeper(clusterConfig)
+	if k.config.Consumer.Offsets.Initial != sarama.OffsetNewest {
+		t.Errorf("broadcast consumer should start from newest")
+	}
+	if clusterConfig.Consumer.Offsets.Initial != sarama.OffsetOldest {
+		t.Errorf("shared cluster config should not be modified")
+	}
+
+	k.touch("q@g@a")
+	k.touch("q@g@b")
+	k.lastSeen["q@g@a"] = time.Now().Add(-broadcastIdle - time.Second)
+	if idle := k.idle(time.Now()); len(idle) != 1 || idle[0] != "q@g@a" {
+		t.Errorf("expect q@g@a idle, got %v", idle)
+	}
+	if idle := k.idle(time.Now().Add(broadcastIdle + time.Second)); len(idle) != 1 || idle[0] != "q@g@b" {
+		t.Errorf("expect q@g@b idle, got %v", idle)
+	}
+	if len(k.lastSeen) != 0 {
+		t.Errorf("idle instances should be forgotten, got %v", k.lastSeen)
+	}
+}
+
+func TestWithInstance(t *testing.T) {
+	if instance := instanceFrom(context.Background()); instance != "" {
+		t.Errorf("expect no instance, got %q", instance)
+	}
+	if instance := instanceFrom(WithInstance(context.Background(), "web-01")); instance != "web-01" {
+		t.Errorf("expect instance web-01, got %q", instance)
+	}
+	for _, instance := range []string{"web-01", "host.example_1"} {
+		if !validInstance.MatchString(instance) {
+			t.Errorf("expect instance %q valid", instance)
+		}
+	}
+	for _, instance := range []string{"", "a/b", "a@b"} {
+		if validInstance.MatchString(instance) {
+			t.Errorf("expect instance %q invalid", instance)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"strings"
+
+	"github.com/weibocom/wqs/engine/zookeeper"
+	"github.com/weibocom/wqs/log"
+
+	"github.com/juju/errors"
+)
+
+// 一次批量操作最多包含的group配置数, zookeeper事务的请求大小有限制
+const maxBulkGroups = 500
+
+const (
+	GroupAdded   = "add"
+	GroupUpdated = "update"
+)
+
+// 批量操作中一个group配置的结果, Action为add或update
+type GroupChange struct {
+	Group  string `json:"group"`
+	Queue  string `json:"queue"`
+	Action string `json:"action"`
+}
+
+// 批量添加或修改group, 只使用write, read, url, ips, start和验证结果, 已有group的其他配置保持不变.
+// 所有修改在一个zookeeper事务中提交, 全部成功或全部失败. 新建的group在提交之前按start重置offset,
+// 提交失败时这些offset不属于任何group的配置, 再次添加时会重新重置
+func (m *Metadata) ApplyGroupConfigs(configs []GroupConfig) ([]GroupChange, error) {
+
+	mu := m.zkConn.NewMutex(m.operationPath)
+	if err := mu.Lock(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer mu.Unlock()
+
+	if err := m.RefreshMetadata(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	changes := make([]GroupChange, 0, len(configs))
+	ops := make([]zookeeper.TxnOp, 0, len(configs))
+	added := make([]GroupConfig, 0)
+	for _, c := range configs {
+		if !m.ExistQueue(c.Queue) {
+			return nil, errors.NotFoundf("queue : %q", c.Queue)
+		}
+		path := m.buildConfigPath(c.Group, c.Queue)
+		if !m.ExistGroup(c.Queue, c.Group) {
+			if err := m.checkNewGroup(c.Group); err != nil {
+				return nil, err
+			}
+			config := GroupConfig{
+				Group:    c.Group,
+				Queue:    c.Queue,
+				Write:    c.Write,
+				Read:     c.Read,
+				Url:      c.Url,
+				Ips:      c.Ips,
+				Start:    c.Start,
+				Verified: c.Verified,
+			}
+			ops = append(ops, zookeeper.TxnOp{Type: zookeeper.TxnCreate, Path: path, Data: config.String()})
+			changes = append(changes, GroupChange{Group: c.Group, Queue: c.Queue, Action: GroupAdded})
+			added = append(added, config)
+			continue
+		}
+
+		data, stat, err := m.zkConn.Get(path)
+		if err != nil {
+			return nil, errors.Annotatef(err, "get %s", path)
+		}
+		config := GroupConfig{}
+		if err = config.Load(data); err != nil {
+			return nil, errors.Annotatef(err, "unmarshal %s", path)
+		}
+		config.Group, config.Queue = c.Group, c.Queue
+		config.Write, config.Read, config.Url, config.Ips, config.Start = c.Write, c.Read, c.Url, c.Ips, c.Start
+		config.Verified = c.Verified
+		ops = append(ops, zookeeper.TxnOp{Type: zookeeper.TxnSet, Path: path, Data: config.String(), Version: stat.Version})
+		changes = append(changes, GroupChange{Group: c.Group, Queue: c.Queue, Action: GroupUpdated})
+	}
+
+	for _, config := range added {
+		if err := m.ResetOffset(config.Queue, config.Group, groupStartOffset(config.Start)); err != nil {
+			return nil, errors.Annotatef(err, "reset offset of queue %q group %q", config.Queue, config.Group)
+		}
+	}
+
+	if err := m.zkConn.Txn(ops); err != nil {
+		return nil, errors.Annotatef(err, "apply %d group configs", len(ops))
+	}
+	log.Infof("apply group configs: %v", changes)
+	return changes, errors.Trace(m.RefreshMetadata())
+}
+
+// 批量添加或修改group, 先检查全部配置, 有一个不合法时不做任何修改
+func (q *queueImp) ApplyGroups(configs []GroupConfig) ([]GroupChange, error) {
+
+	if len(configs) == 0 || len(configs) > maxBulkGroups {
+		return nil, errors.NotValidf("%d group configs, expect 1~%d", len(configs), maxBulkGroups)
+	}
+	seen := make(map[string]bool, len(configs))
+	names := make(map[string]string, len(configs))
+	for _, c := range configs {
+		if err := checkGroupName(c.Group, c.Queue); err != nil {
+			return nil, err
+		}
+		if err := validGroupStart(c.Start); err != nil {
+			return nil, err
+		}
+		if _, err := parseGroupURL(c.Url); err != nil {
+			return nil, err
+		}
+		key := c.Group + "." + c.Queue
+		if seen[key] {
+			return nil, errors.NotValidf("duplicate group %q of queue %q", c.Group, c.Queue)
+		}
+		seen[key] = true
+		// 同一批中只有大小写不同的group
+		if name, ok := names[strings.ToLower(c.Group)]; ok && name != c.Group {
+			return nil, errors.NotValidf("group %q and %q differ only in case", c.Group, name)
+		}
+		names[strings.ToLower(c.Group)] = c.Group
+	}
+
+	// 格式都正确之后再验证推送地址, 同一个地址只验证一次
+	verified := make(map[string]bool)
+	for i := range configs {
+		c := &configs[i]
+		v, ok := verified[c.Url]
+		if !ok {
+			var err error
+			if v, err = q.urls.check(c.Group, c.Queue, c.Url); err != nil {
+				return nil, err
+			}
+			verified[c.Url] = v
+		}
+		c.Verified = v
+	}
+
+	changes, err := q.metadata.ApplyGroupConfigs(configs)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return changes, nil
+}
+
+// 将同一个group配置应用到多个queue
+func (q *queueImp) ApplyGroupToQueues(config GroupConfig, queues []string) ([]GroupChange, error) {
+	configs := make([]GroupConfig, 0, len(queues))
+	for _, queue := range queues {
+		c := config
+		c.Queue = queue
+		configs = append(configs, c)
+	}
+	return q.ApplyGroups(configs)
+}
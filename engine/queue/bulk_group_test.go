@@ -0,0 +1,45 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+func TestApplyGroupsInvalid(t *testing.T) {
+	q := &queueImp{}
+	tooMany := make([]GroupConfig, maxBulkGroups+1)
+	for i := range tooMany {
+		tooMany[i] = GroupConfig{Group: "g1", Queue: "q1"}
+	}
+	for _, configs := range [][]GroupConfig{
+		nil,
+		tooMany,
+		{{Group: "g1", Queue: "q 1"}},
+		{{Group: "g1", Queue: "q1", Start: "middle"}},
+		{{Group: "g1", Queue: "q1"}, {Group: "g2", Queue: "q1"}, {Group: "g1", Queue: "q1"}},
+	} {
+		if _, err := q.ApplyGroups(configs); !errors.IsNotValid(err) {
+			t.Errorf("apply %d groups err: %v, want not valid", len(configs), err)
+		}
+	}
+	if _, err := q.ApplyGroupToQueues(GroupConfig{Group: "g1"}, []string{"q1", "q1"}); !errors.IsNotValid(err) {
+		t.Errorf("apply group to duplicate queues err: %v, want not valid", err)
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// 灰度group是否接收该消息. 按消息key取样, 同一条消息的分片、重新投递和其他机房复制的消息结果一致;
+// 没有key的消息(如直接写入kafka的消息)按partition和offset取样
+func canarySelected(percent int, msg *sarama.ConsumerMessage) bool {
+	if percent <= 0 || percent >= 100 {
+		return true
+	}
+	h := fnv.New32a()
+	if len(msg.Key) > 0 {
+		h.Write(msg.Key)
+	} else {
+		var buf [12]byte
+		binary.BigEndian.PutUint32(buf[:4], uint32(msg.Partition))
+		binary.BigEndian.PutUint64(buf[4:], uint64(msg.Offset))
+		h.Write(buf[:])
+	}
+	return int(h.Sum32()%100) < percent
+}
+
+// 把group设置为灰度group, 只接收该queue百分之percent的消息, 其余的在接收时跳过并ACK.
+// 每个group都会收到queue的全部消息, 灰度group不影响其他group, percent为0时取消灰度
+func (q *queueImp) SetGroupCanary(group string, queue string, percent int) error {
+
+	if percent < 0 || percent > 100 {
+		return errors.NotValidf("percent : %d", percent)
+	}
+
+	return q.metadata.ModifyGroupConfig(group, queue, func(config *GroupConfig) error {
+		config.Canary = percent
+		return nil
+	})
+}
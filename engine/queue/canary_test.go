@@ -0,0 +1,70 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+func TestCanarySelected(t *testing.T) {
+	gen := newIDGenerator(1)
+	selected := 0
+	for i := 0; i < 10000; i++ {
+		msg := &sarama.ConsumerMessage{Key: []byte(fmt.Sprintf("%x:%x", gen.Get(), 0)), Offset: int64(i)}
+		if canarySelected(10, msg) {
+			selected++
+		}
+		if !canarySelected(0, msg) || !canarySelected(100, msg) {
+			t.Fatal("percent 0 and 100 should select all messages")
+		}
+	}
+	if selected < 800 || selected > 1200 {
+		t.Errorf("selected %d of 10000 messages, want about 1000", selected)
+	}
+
+	// 同一条消息的分片和重新投递结果一致
+	msg := &sarama.ConsumerMessage{Key: []byte("abc:0"), Partition: 1, Offset: 100}
+	chunk := &sarama.ConsumerMessage{Key: []byte("abc:0"), Partition: 1, Offset: 101}
+	for percent := 1; percent < 100; percent++ {
+		if canarySelected(percent, msg) != canarySelected(percent, chunk) {
+			t.Fatalf("chunks of one message selected differently at %d%%", percent)
+		}
+	}
+
+	selected = 0
+	for i := 0; i < 1000; i++ {
+		if canarySelected(50, &sarama.ConsumerMessage{Partition: int32(i % 4), Offset: int64(i)}) {
+			selected++
+		}
+	}
+	if selected < 400 || selected > 600 {
+		t.Errorf("selected %d of 1000 messages without key, want about 500", selected)
+	}
+}
+
+func TestSetGroupCanaryInvalid(t *testing.T) {
+	q := &queueImp{}
+	for _, percent := range []int{-1, 101} {
+		if err := q.SetGroupCanary("g1", "q1", percent); !errors.IsNotValid(err) {
+			t.Errorf("set canary %d err: %v, want not valid", percent, err)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/log"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+const (
+	// 分片的header, 值为"序号/分片数", 序号从0开始
+	chunkHeader = "wqs-chunk"
+	// 一条消息最多拆成的分片数
+	maxChunks = 1024
+	// 分片的header等额外占用的空间
+	chunkOverhead = 1024
+	// 未收齐的分片在proxy中保留的时间
+	chunkExpire = 5 * time.Minute
+)
+
+type MessageTooLargeError struct {
+	Queue string
+	Size  int
+	Max   int
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("message of queue %q is %d bytes, exceeds max size %d", e.Queue, e.Size, e.Max)
+}
+
+func IsMessageTooLarge(err error) bool {
+	_, ok := errors.Cause(err).(*MessageTooLargeError)
+	return ok
+}
+
+// 返回queue允许的最大消息和分片大小, 分片大小为0表示不分片
+func (q *queueImp) messageSize(config *QueueConfig) (max int, chunk int) {
+	max = q.clusterConfig.Producer.MaxMessageBytes
+	if config != nil && config.Size != nil {
+		if config.Size.Max > 0 {
+			max = config.Size.Max
+		}
+		chunk = config.Size.Chunk
+	}
+	return max, chunk
+}
+
+// 设置queue的消息大小限制, 分片依赖消息header, 需要kafka.version不低于0.11.0.0
+func (q *queueImp) SetQueueSize(queue string, size MessageSize) error {
+
+	producerMax := q.clusterConfig.Producer.MaxMessageBytes
+	if size.Max < 0 || size.Chunk < 0 {
+		return errors.NotValidf("size : %+v", size)
+	}
+	if size.Chunk == 0 && size.Max > producerMax {
+		return errors.NotValidf("max size %d greater than kafka.producer.max.message.bytes %d without chunk", size.Max, producerMax)
+	}
+	if size.Chunk > 0 {
+		if !q.clusterConfig.Version.IsAtLeast(sarama.V0_11_0_0) {
+			return errors.NotValidf("chunk without message header, kafka.version %s", q.clusterConfig.Version)
+		}
+		if size.Chunk > producerMax-chunkOverhead {
+			return errors.NotValidf("chunk size %d greater than %d", size.Chunk, producerMax-chunkOverhead)
+		}
+		max := size.Max
+		if max == 0 {
+			max = producerMax
+		}
+		if (max+size.Chunk-1)/size.Chunk > maxChunks {
+			return errors.NotValidf("max size %d split into more than %d chunks", max, maxChunks)
+		}
+	}
+
+	return q.metadata.ModifyQueueConfig(queue, func(config *QueueConfig) error {
+		if size == (MessageSize{}) {
+			config.Size = nil
+		} else {
+			config.Size = &size
+		}
+		return nil
+	})
+}
+
+func splitChunks(data []byte, chunk int) [][]byte {
+	chunks := make([][]byte, 0, (len(data)+chunk-1)/chunk)
+	for len(data) > chunk {
+		chunks = append(chunks, data[:chunk])
+		data = data[chunk:]
+	}
+	return append(chunks, data)
+}
+
+// 每个分片带有消息的全部header, 过滤条件对所有分片的结果一致
+func chunkHeaders(headers []sarama.RecordHeader, total int) [][]sarama.RecordHeader {
+	chunks := make([][]sarama.RecordHeader, total)
+	for i := range chunks {
+		chunks[i] = append(headers[:len(headers):len(headers)], sarama.RecordHeader{
+			Key:   []byte(chunkHeader),
+			Value: []byte(strconv.Itoa(i) + "/" + strconv.Itoa(total)),
+		})
+	}
+	return chunks
+}
+
+// 不是分片或header无法解析时ok为false, 按普通消息处理
+func parseChunk(headers []*sarama.RecordHeader) (index int, total int, ok bool) {
+	for _, h := range headers {
+		if h == nil || string(h.Key) != chunkHeader {
+			continue
+		}
+		parts := strings.SplitN(string(h.Value), "/", 2)
+		if len(parts) != 2 {
+			return 0, 0, false
+		}
+		index, err1 := strconv.Atoi(parts[0])
+		total, err2 := strconv.Atoi(parts[1])
+		if err1 != nil || err2 != nil || total <= 0 || total > maxChunks || index < 0 || index >= total {
+			return 0, 0, false
+		}
+		return index, total, true
+	}
+	return 0, 0, false
+}
+
+// 接收到的消息的分片状态
+const (
+	chunkNone = iota
+	chunkPending
+	chunkAssembled
+	chunkIncomplete
+)
+
+type chunkParts struct {
+	parts    [][]byte
+	offsets  []int64
+	received int
+	created  time.Time
+	updated  time.Time
+}
+
+type chunkHold struct {
+	offsets []int64
+	created time.Time
+}
+
+// 在proxy内存中合并同一个consumer收到的分片. 收齐之前的分片不ACK, 超时后由consumer重新投递;
+// 合并后的消息使用最后收到的分片的id, 该id被ACK时一起ACK其他分片
+type chunkAssembler struct {
+	mu      sync.Mutex
+	pending map[string]*chunkParts
+	holds   map[string]chunkHold
+}
+
+func newChunkAssembler() *chunkAssembler {
+	return &chunkAssembler{
+		pending: make(map[string]*chunkParts),
+		holds:   make(map[string]chunkHold),
+	}
+}
+
+func chunkKey(owner string, idc string, partition int32, suffix string) string {
+	return owner + "/" + idc + "/" + strconv.Itoa(int(partition)) + "/" + suffix
+}
+
+// 不是分片的消息原样返回; 分片收齐时返回合并后的消息. 超过chunkExpire仍未收齐时返回chunkIncomplete,
+// 发送时部分失败的分片不会收齐, 由调用方ACK, 避免一直重新投递
+func (a *chunkAssembler) receive(owner string, idc string, msg *sarama.ConsumerMessage) (*sarama.ConsumerMessage, int) {
+
+	index, total, ok := parseChunk(msg.Headers)
+	if !ok {
+		return msg, chunkNone
+	}
+
+	key := chunkKey(owner, idc, msg.Partition, string(msg.Key))
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p, ok := a.pending[key]
+	if !ok || len(p.parts) != total {
+		p = &chunkParts{parts: make([][]byte, total), offsets: make([]int64, total), created: now}
+		a.pending[key] = p
+	}
+	if p.parts[index] == nil {
+		p.received++
+	}
+	p.parts[index] = msg.Value
+	p.offsets[index] = msg.Offset
+	p.updated = now
+	if p.received < total {
+		if now.Sub(p.created) > chunkExpire {
+			return nil, chunkIncomplete
+		}
+		return nil, chunkPending
+	}
+
+	delete(a.pending, key)
+	size := 0
+	for _, part := range p.parts {
+		size += len(part)
+	}
+	data := make([]byte, 0, size)
+	others := make([]int64, 0, total-1)
+	for i, part := range p.parts {
+		data = append(data, part...)
+		if i != index {
+			others = append(others, p.offsets[i])
+		}
+	}
+	a.holds[chunkKey(owner, idc, msg.Partition, strconv.FormatInt(msg.Offset, 10))] = chunkHold{
+		offsets: others,
+		created: now,
+	}
+
+	assembled := *msg
+	assembled.Value = data
+	return &assembled, chunkAssembled
+}
+
+// 返回合并后的消息被ACK时需要一起ACK的其他分片的offset
+func (a *chunkAssembler) release(owner string, idc string, partition int32, offset int64) []int64 {
+	key := chunkKey(owner, idc, partition, strconv.FormatInt(offset, 10))
+	a.mu.Lock()
+	hold, ok := a.holds[key]
+	delete(a.holds, key)
+	a.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return hold.offsets
+}
+
+// 合并后的消息ACK之后ACK其余的分片
+func (q *queueImp) ackChunks(consumer *kafka.Consumer, owner string, idc string, partition int32, offset int64) {
+	for _, chunk := range q.chunks.release(owner, idc, partition, offset) {
+		if err := consumer.Ack(idc, partition, chunk); err != nil {
+			log.Warnf("ack chunk of %q idc:%q partition:%d offset:%d err:%s", owner, idc, partition, chunk, err)
+		}
+	}
+}
+
+// 清理长时间没有再收到的分片和没有ACK的合并记录, 这些分片会被重新投递
+func (a *chunkAssembler) expire(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, p := range a.pending {
+		if now.Sub(p.updated) > chunkExpire {
+			delete(a.pending, key)
+		}
+	}
+	for key, hold := range a.holds {
+		if now.Sub(hold.created) > chunkExpire {
+			delete(a.holds, key)
+		}
+	}
+}
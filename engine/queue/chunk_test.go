@@ -0,0 +1,145 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+func chunkMessages(data []byte, chunk int, key string, offset int64) []*sarama.ConsumerMessage {
+	chunks := splitChunks(data, chunk)
+	headers := chunkHeaders([]sarama.RecordHeader{{Key: []byte("k"), Value: []byte("v")}}, len(chunks))
+	msgs := make([]*sarama.ConsumerMessage, len(chunks))
+	for i := range chunks {
+		msg := &sarama.ConsumerMessage{Key: []byte(key), Value: chunks[i], Offset: offset + int64(i)}
+		for j := range headers[i] {
+			msg.Headers = append(msg.Headers, &headers[i][j])
+		}
+		msgs[i] = msg
+	}
+	return msgs
+}
+
+func TestSplitChunks(t *testing.T) {
+	data := []byte("0123456789")
+	if chunks := splitChunks(data, 4); len(chunks) != 3 || string(chunks[2]) != "89" {
+		t.Errorf("split into %q", chunks)
+	}
+	if chunks := splitChunks(data, 5); len(chunks) != 2 || string(chunks[1]) != "56789" {
+		t.Errorf("split into %q", chunks)
+	}
+
+	msgs := chunkMessages(data, 4, "key", 0)
+	if index, total, ok := parseChunk(msgs[1].Headers); !ok || index != 1 || total != 3 {
+		t.Errorf("parse chunk %d/%d %v", index, total, ok)
+	}
+	if len(msgs[0].Headers) != 2 || string(msgs[0].Headers[0].Key) != "k" {
+		t.Errorf("chunk should keep message headers: %v", msgs[0].Headers)
+	}
+	for _, v := range []string{"", "1", "a/2", "2/2", "-1/2", "0/0"} {
+		h := []*sarama.RecordHeader{{Key: []byte(chunkHeader), Value: []byte(v)}}
+		if _, _, ok := parseChunk(h); ok {
+			t.Errorf("parse chunk %q should fail", v)
+		}
+	}
+}
+
+func TestChunkAssembler(t *testing.T) {
+	a := newChunkAssembler()
+
+	plain := &sarama.ConsumerMessage{Value: []byte("plain")}
+	if msg, state := a.receive("q@g", "idc", plain); state != chunkNone || msg != plain {
+		t.Errorf("plain message state %d", state)
+	}
+
+	data := []byte("0123456789")
+	msgs := chunkMessages(data, 4, "key", 10)
+	// 乱序和重复投递的分片
+	for _, i := range []int{2, 0, 2} {
+		if _, state := a.receive("q@g", "idc", msgs[i]); state != chunkPending {
+			t.Fatalf("chunk %d state %d, want pending", i, state)
+		}
+	}
+	// 其他consumer的分片不影响合并
+	if _, state := a.receive("q@g2", "idc", msgs[1]); state != chunkPending {
+		t.Fatalf("chunk of other owner state %d", state)
+	}
+	msg, state := a.receive("q@g", "idc", msgs[1])
+	if state != chunkAssembled || !bytes.Equal(msg.Value, data) || msg.Offset != 11 {
+		t.Fatalf("assembled %q offset %d state %d", msg.Value, msg.Offset, state)
+	}
+	if !bytes.Equal(msgs[1].Value, []byte("4567")) {
+		t.Error("chunk message modified")
+	}
+
+	if offsets := a.release("q@g", "idc", 0, 11); len(offsets) != 2 || offsets[0] != 10 || offsets[1] != 12 {
+		t.Errorf("release offsets %v", offsets)
+	}
+	if offsets := a.release("q@g", "idc", 0, 11); offsets != nil {
+		t.Errorf("release twice %v", offsets)
+	}
+
+	// 长时间收不齐的分片
+	a.pending[chunkKey("q@g2", "idc", 0, "key")].created = time.Now().Add(-chunkExpire - time.Second)
+	if _, state := a.receive("q@g2", "idc", msgs[0]); state != chunkIncomplete {
+		t.Errorf("stale chunk state %d, want incomplete", state)
+	}
+	a.expire(time.Now().Add(chunkExpire + time.Second))
+	if len(a.pending) != 0 || len(a.holds) != 0 {
+		t.Errorf("expire left %d pending %d holds", len(a.pending), len(a.holds))
+	}
+}
+
+func TestSetQueueSize(t *testing.T) {
+	clusterConfig := genClusterConfig("localhost")
+	q := &queueImp{clusterConfig: clusterConfig}
+
+	max := clusterConfig.Producer.MaxMessageBytes
+	if m, chunk := q.messageSize(nil); m != max || chunk != 0 {
+		t.Errorf("default size %d chunk %d", m, chunk)
+	}
+	if m, chunk := q.messageSize(&QueueConfig{Size: &MessageSize{Max: 100, Chunk: 10}}); m != 100 || chunk != 10 {
+		t.Errorf("queue size %d chunk %d", m, chunk)
+	}
+
+	// 0.11之前不支持header, 不能分片
+	clusterConfig.Version = sarama.V0_10_2_0
+	invalid := []MessageSize{{Max: -1}, {Max: max + 1}, {Chunk: 1024}}
+	for _, size := range invalid {
+		if err := q.SetQueueSize("q", size); !errors.IsNotValid(err) {
+			t.Errorf("set size %+v err: %v, want not valid", size, err)
+		}
+	}
+
+	clusterConfig.Version = sarama.V0_11_0_0
+	invalid = []MessageSize{{Chunk: max}, {Max: 10 << 20, Chunk: 1024}}
+	for _, size := range invalid {
+		if err := q.SetQueueSize("q", size); !errors.IsNotValid(err) {
+			t.Errorf("set size %+v err: %v, want not valid", size, err)
+		}
+	}
+
+	err := &MessageTooLargeError{Queue: "q", Size: 2, Max: 1}
+	if !IsMessageTooLarge(errors.Trace(err)) {
+		t.Error("should be message too large")
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/juju/errors"
+)
+
+// 对没有单独设置限流的客户端生效的限流
+const AllClients = "*"
+
+// 客户端标识会作为监控指标key的一部分, 不能包含"."
+var validClient = regexp.MustCompile(`^[a-zA-Z0-9_\-]{1,64}$`)
+
+type clientKey struct{}
+
+// 发送和接收时携带的客户端标识, 用于按客户端统计和限流
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+func clientFrom(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
+
+func ValidClient(client string) bool {
+	return validClient.MatchString(client)
+}
+
+// 单独设置的限流优先, 其次是AllClients
+func clientLimit(config *GroupConfig, client string) *RateLimit {
+	if client == "" || config.ClientLimits == nil {
+		return nil
+	}
+	if limit, ok := config.ClientLimits[client]; ok {
+		return limit
+	}
+	return config.ClientLimits[AllClients]
+}
+
+// 按客户端统计发送和接收的次数, prefix为"queue.group.SET."或"queue.group.GET."
+func clientMetrics(prefix string, client string) {
+	if client == "" {
+		return
+	}
+	prefix += metrics.Client + "." + client + "."
+	metrics.AddCounter(prefix+metrics.Ops, 1)
+	metrics.AddMeter(prefix+metrics.Qps, 1)
+}
+
+func clientThrottled(prefix string, client string) {
+	if client != "" {
+		metrics.AddCounter(prefix+metrics.Client+"."+client+"."+metrics.Throttled, 1)
+	}
+}
+
+// 设置group下某个客户端的限流, 0表示取消. 每个客户端单独计算, 不影响group中的其他客户端
+func (q *queueImp) SetClientLimit(group string, queue string, client string, limit RateLimit) error {
+
+	if client != AllClients && !ValidClient(client) {
+		return errors.NotValidf("client : %q", client)
+	}
+	if limit.MsgRate < 0 || limit.ByteRate < 0 {
+		return errors.NotValidf("limit : %+v", limit)
+	}
+
+	return q.metadata.ModifyGroupConfig(group, queue, func(config *GroupConfig) error {
+		if limit.MsgRate == 0 && limit.ByteRate == 0 {
+			delete(config.ClientLimits, client)
+			if len(config.ClientLimits) == 0 {
+				config.ClientLimits = nil
+			}
+			return nil
+		}
+		if config.ClientLimits == nil {
+			config.ClientLimits = make(map[string]*RateLimit)
+		}
+		config.ClientLimits[client] = &limit
+		return nil
+	})
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWithClient(t *testing.T) {
+	ctx := context.Background()
+	if client := clientFrom(ctx); client != "" {
+		t.Errorf("expect empty client, got %q", client)
+	}
+	if client := clientFrom(WithClient(ctx, "order-service")); client != "order-service" {
+		t.Errorf("expect order-service, got %q", client)
+	}
+	for client, valid := range map[string]bool{
+		"order-service": true,
+		"svc_1":         true,
+		"":              false,
+		"a.b":           false,
+		AllClients:      false,
+	} {
+		if ValidClient(client) != valid {
+			t.Errorf("client %q expect valid %v", client, valid)
+		}
+	}
+}
+
+func TestClientLimit(t *testing.T) {
+	config := &GroupConfig{}
+	if limit := clientLimit(config, "a"); limit != nil {
+		t.Errorf("expect no limit, got %+v", limit)
+	}
+
+	config.ClientLimits = map[string]*RateLimit{
+		"a":        {MsgRate: 10},
+		AllClients: {MsgRate: 100},
+	}
+	if limit := clientLimit(config, "a"); limit == nil || limit.MsgRate != 10 {
+		t.Errorf("expect limit of a, got %+v", limit)
+	}
+	if limit := clientLimit(config, "b"); limit == nil || limit.MsgRate != 100 {
+		t.Errorf("expect default client limit, got %+v", limit)
+	}
+	if limit := clientLimit(config, ""); limit != nil {
+		t.Errorf("request without client should not be limited, got %+v", limit)
+	}
+
+	// 每个客户端使用单独的令牌桶
+	l := newRateLimiter()
+	reqs := func(client string) []limitRequest {
+		return []limitRequest{{key: "q.g.SET@" + client + ".msg", rate: 1, n: 1}}
+	}
+	if err := l.acquire("q.g", reqs("a")); err != nil {
+		t.Fatalf("first request of a should pass: %v", err)
+	}
+	if err := l.acquire("q.g", reqs("a")); !IsThrottled(err) {
+		t.Errorf("expect a throttled, got %v", err)
+	}
+	if err := l.acquire("q.g", reqs("b")); err != nil {
+		t.Errorf("b should not be affected by a: %v", err)
+	}
+}
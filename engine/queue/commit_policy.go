@@ -0,0 +1,86 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"time"
+
+	"github.com/weibocom/wqs/engine/kafka"
+
+	"github.com/juju/errors"
+)
+
+const (
+	CommitPeriodic = "periodic"
+	CommitOnAck    = "ack"
+
+	// 定时提交的最大间隔, 毫秒
+	maxCommitInterval = 10 * 60 * 1000
+)
+
+// group的offset提交策略. Mode为periodic(默认)时按Interval毫秒定时提交, 0表示使用queue或全局的提交间隔;
+// 为ack时每次ACK推进offset后立即提交. OnClose为false时关闭consumer(proxy退出、worker数变化等)不提交,
+// 最后一次提交之后ACK的消息会重新投递, 默认提交
+type CommitPolicy struct {
+	Mode     string `json:"mode,omitempty"`
+	Interval int64  `json:"interval,omitempty"`
+	OnClose  *bool  `json:"on_close,omitempty"`
+}
+
+func (p *CommitPolicy) validate() error {
+	if p.Mode != "" && p.Mode != CommitPeriodic && p.Mode != CommitOnAck {
+		return errors.NotValidf("commit mode %q", p.Mode)
+	}
+	if p.Interval < 0 || p.Interval > maxCommitInterval {
+		return errors.NotValidf("commit interval %d, expect 0~%d", p.Interval, maxCommitInterval)
+	}
+	return nil
+}
+
+// 与默认策略相同时不需要保存
+func (p *CommitPolicy) isDefault() bool {
+	return (p.Mode == "" || p.Mode == CommitPeriodic) && p.Interval == 0 && (p.OnClose == nil || *p.OnClose)
+}
+
+// 没有设置时返回默认策略
+func (p *CommitPolicy) policy() kafka.CommitPolicy {
+	if p == nil {
+		return kafka.CommitPolicy{}
+	}
+	return kafka.CommitPolicy{
+		OnAck:       p.Mode == CommitOnAck,
+		Interval:    time.Duration(p.Interval) * time.Millisecond,
+		SkipOnClose: p.OnClose != nil && !*p.OnClose,
+	}
+}
+
+// 设置group的offset提交策略, 各个proxy在下次接收时按新的策略重建consumer
+func (q *queueImp) SetGroupCommit(group string, queue string, policy CommitPolicy) error {
+
+	if err := policy.validate(); err != nil {
+		return err
+	}
+
+	return q.metadata.ModifyGroupConfig(group, queue, func(config *GroupConfig) error {
+		if policy.isDefault() {
+			config.Commit = nil
+		} else {
+			config.Commit = &policy
+		}
+		return nil
+	})
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/engine/kafka"
+
+	"github.com/juju/errors"
+)
+
+func TestCommitPolicy(t *testing.T) {
+	var p *CommitPolicy
+	if policy := p.policy(); policy != (kafka.CommitPolicy{}) {
+		t.Errorf("nil policy %+v", policy)
+	}
+
+	off := false
+	p = &CommitPolicy{Mode: CommitOnAck, Interval: 500, OnClose: &off}
+	if err := p.validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := kafka.CommitPolicy{OnAck: true, Interval: 500 * time.Millisecond, SkipOnClose: true}
+	if policy := p.policy(); policy != want {
+		t.Errorf("policy %+v, want %+v", policy, want)
+	}
+	if p.isDefault() {
+		t.Error("commit on ack is not default")
+	}
+
+	on := true
+	for _, p := range []*CommitPolicy{{}, {Mode: CommitPeriodic}, {OnClose: &on}} {
+		if !p.isDefault() {
+			t.Errorf("%+v should be default", p)
+		}
+	}
+	for _, p := range []*CommitPolicy{{Mode: "close"}, {Interval: -1}, {Interval: maxCommitInterval + 1}} {
+		if err := p.validate(); !errors.IsNotValid(err) {
+			t.Errorf("validate %+v err: %v, want not valid", p, err)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"sort"
+
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+const (
+	maxCompactKeyLen = 256
+	compactFetchSize = 500
+)
+
+// compact queue中一个key的最新值
+type KeyValue struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type compactKey struct{}
+
+// 发送到compact queue的消息的key, 同一个key只保留最新的一条消息
+func WithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, compactKey{}, key)
+}
+
+func keyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(compactKey{}).(string)
+	return key
+}
+
+// 发送到compact queue的消息必须指定key, 其他queue忽略key
+func sendKey(queue string, config *QueueConfig, key string) (string, error) {
+	if !config.Compact {
+		return "", nil
+	}
+	if key == "" || len(key) > maxCompactKeyLen {
+		return "", errors.NotValidf("key %q of compact queue %q", key, queue)
+	}
+	return key, nil
+}
+
+func (q *queueImp) compactConfig(queue string) (*QueueConfig, error) {
+	config := q.metadata.GetQueueConfig(queue)
+	if config == nil {
+		return nil, errors.NotFoundf("queue : %q", queue)
+	}
+	if !config.Compact {
+		return nil, errors.NotValidf("queue %q is not compact", queue)
+	}
+	return config, nil
+}
+
+// 写入key的删除标记(value为null), kafka清理时删除该key的全部消息
+func (q *queueImp) DeleteValue(ctx context.Context, queue string, group string, key string) error {
+
+	config, err := q.compactConfig(queue)
+	if err != nil {
+		return err
+	}
+	if ok := q.metadata.ExistGroup(queue, group); !ok {
+		return errors.NotFoundf("queue : %q , group: %q", queue, group)
+	}
+	if key, err = sendKey(queue, config, key); err != nil {
+		return err
+	}
+	producer, err := q.getProducer(queue)
+	if err != nil {
+		return err
+	}
+	if _, _, err = producer.Send(queue, []byte(key), nil, nil); err != nil {
+		metrics.AddCounter(metrics.CmdSetError, 1)
+		log.Errorf("DeleteValue: queue %q group %q key %q error %s", queue, group, key, err)
+		return err
+	}
+	log.Debugf("delete %s:%s key %s", queue, group, key)
+	return nil
+}
+
+// 读取key所在partition的全部消息, 返回该key的最新值. 分区数变化后之前写入的key可能无法找到
+func (q *queueImp) GetValue(queue string, key string) (*KeyValue, error) {
+
+	if _, err := q.compactConfig(queue); err != nil {
+		return nil, err
+	}
+	partitions, err := q.metadata.LocalManager().Partitions(queue)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	partition, err := sarama.NewHashPartitioner(queue).Partition(
+		&sarama.ProducerMessage{Key: sarama.StringEncoder(key)}, int32(len(partitions)))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	values := make(map[string]*sarama.ConsumerMessage)
+	if err = q.scanValues(queue, partition, values); err != nil {
+		return nil, err
+	}
+	msg, ok := values[key]
+	if !ok {
+		return nil, errors.NotFoundf("queue %q key %q", queue, key)
+	}
+	return q.keyValue(msg)
+}
+
+// 读取本机房topic的全部消息, 按key排序返回各个key的最新值
+func (q *queueImp) GetValues(queue string) ([]*KeyValue, error) {
+
+	if _, err := q.compactConfig(queue); err != nil {
+		return nil, err
+	}
+	partitions, err := q.metadata.LocalManager().Partitions(queue)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	values := make(map[string]*sarama.ConsumerMessage)
+	for _, partition := range partitions {
+		if err = q.scanValues(queue, partition, values); err != nil {
+			return nil, err
+		}
+	}
+
+	kvs := make([]*KeyValue, 0, len(values))
+	for _, msg := range values {
+		kv, err := q.keyValue(msg)
+		if err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, kv)
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs, nil
+}
+
+// 从最早的位置读到最新的位置, 后写入的消息覆盖之前的值, 删除标记移除对应的key
+func (q *queueImp) scanValues(queue string, partition int32, values map[string]*sarama.ConsumerMessage) error {
+	manager := q.metadata.LocalManager()
+	oldest, newest, err := manager.PartitionOffsets(queue, partition)
+	if err != nil {
+		return err
+	}
+	for offset := oldest; offset < newest; {
+		msgs, err := manager.FetchMessages(queue, partition, offset, compactFetchSize)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		for _, msg := range msgs {
+			if msg.Value == nil {
+				delete(values, string(msg.Key))
+			} else {
+				values[string(msg.Key)] = msg
+			}
+		}
+		offset = msgs[len(msgs)-1].Offset + 1
+	}
+	return nil
+}
+
+func (q *queueImp) keyValue(msg *sarama.ConsumerMessage) (*KeyValue, error) {
+	data, err := q.crypto.decrypt(msg.Headers, msg.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyValue{
+		Key:       string(msg.Key),
+		Value:     string(data),
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp.UnixNano() / 1e6,
+	}, nil
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+func TestSendKey(t *testing.T) {
+	ctx := WithKey(context.Background(), "switch.like")
+	if key := keyFrom(ctx); key != "switch.like" {
+		t.Fatalf("key from context %q", key)
+	}
+
+	// 非compact queue忽略key
+	if key, err := sendKey("q1", &QueueConfig{}, keyFrom(ctx)); err != nil || key != "" {
+		t.Errorf("send key %q err: %v", key, err)
+	}
+
+	config := &QueueConfig{Compact: true}
+	if key, err := sendKey("q1", config, keyFrom(ctx)); err != nil || key != "switch.like" {
+		t.Errorf("send key %q err: %v", key, err)
+	}
+	for _, key := range []string{"", strings.Repeat("k", maxCompactKeyLen+1)} {
+		if _, err := sendKey("q1", config, key); !errors.IsNotValid(err) {
+			t.Errorf("send key of length %d err: %v, want not valid", len(key), err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/metrics"
+)
+
+const consumerShards = 32
+
+// 同一owner的consumer只创建一次, 并发的请求等待ready后使用同一个结果
+type consumerEntry struct {
+	ready    chan struct{}
+	consumer *kafka.Consumer
+	err      error
+}
+
+func (e *consumerEntry) done() bool {
+	select {
+	case <-e.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// 等待创建完成, 等待的次数和时间计入Consumer.Waited和Consumer.Wait
+func (e *consumerEntry) wait() (*kafka.Consumer, error) {
+	if !e.done() {
+		start := time.Now()
+		<-e.ready
+		metrics.AddCounter(metrics.Consumer+".Waited", 1)
+		metrics.AddTimer(metrics.Consumer+".Wait", time.Since(start).Nanoseconds()/1e6)
+	}
+	return e.consumer, e.err
+}
+
+type consumerShard struct {
+	mu      sync.RWMutex
+	entries map[string]*consumerEntry
+}
+
+// 按owner(queue@group)哈希分片的consumer表, 创建consumer时不持有锁,
+// 不同owner的查找和创建互不阻塞
+type consumerMap struct {
+	shards [consumerShards]consumerShard
+}
+
+func newConsumerMap() *consumerMap {
+	m := &consumerMap{}
+	for i := range m.shards {
+		m.shards[i].entries = make(map[string]*consumerEntry)
+	}
+	return m
+}
+
+// fnv-1a
+func (m *consumerMap) shard(owner string) *consumerShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(owner); i++ {
+		h ^= uint32(owner[i])
+		h *= 16777619
+	}
+	return &m.shards[h%consumerShards]
+}
+
+// 返回owner的consumer, 正在创建时等待创建完成
+func (m *consumerMap) get(owner string) (*kafka.Consumer, bool) {
+	s := m.shard(owner)
+	s.mu.RLock()
+	e, ok := s.entries[owner]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	consumer, err := e.wait()
+	return consumer, err == nil
+}
+
+// 返回owner的consumer, 不存在时调用create创建. 创建失败时删除, 下次请求重新创建
+func (m *consumerMap) getOrCreate(owner string, create func() (*kafka.Consumer, error)) (*kafka.Consumer, error) {
+	s := m.shard(owner)
+	s.mu.RLock()
+	e, ok := s.entries[owner]
+	s.mu.RUnlock()
+	if ok {
+		return e.wait()
+	}
+
+	s.mu.Lock()
+	if e, ok = s.entries[owner]; ok {
+		s.mu.Unlock()
+		return e.wait()
+	}
+	e = &consumerEntry{ready: make(chan struct{})}
+	s.entries[owner] = e
+	s.mu.Unlock()
+
+	e.consumer, e.err = create()
+	if e.err != nil {
+		s.mu.Lock()
+		if s.entries[owner] == e {
+			delete(s.entries, owner)
+		}
+		s.mu.Unlock()
+	} else {
+		metrics.AddCounter(metrics.Consumer+".Created", 1)
+	}
+	close(e.ready)
+	return e.consumer, e.err
+}
+
+// 删除owner的consumer, 正在创建时等待创建完成, 由调用者关闭返回的consumer
+func (m *consumerMap) remove(owner string) (*kafka.Consumer, bool) {
+	s := m.shard(owner)
+	s.mu.Lock()
+	e, ok := s.entries[owner]
+	delete(s.entries, owner)
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	consumer, err := e.wait()
+	return consumer, err == nil
+}
+
+// 只有owner对应的仍然是consumer时才删除, 避免并发替换时删除了新创建的consumer
+func (m *consumerMap) removeIf(owner string, consumer *kafka.Consumer) bool {
+	s := m.shard(owner)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[owner]
+	if !ok || !e.done() || e.consumer != consumer {
+		return false
+	}
+	delete(s.entries, owner)
+	return true
+}
+
+// 删除owner以prefix开头的consumer, 由调用者关闭返回的consumer
+func (m *consumerMap) removePrefix(prefix string) []*kafka.Consumer {
+	var entries []*consumerEntry
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock()
+		for owner, e := range s.entries {
+			if strings.HasPrefix(owner, prefix) {
+				entries = append(entries, e)
+				delete(s.entries, owner)
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	consumers := make([]*kafka.Consumer, 0, len(entries))
+	for _, e := range entries {
+		if consumer, err := e.wait(); err == nil {
+			consumers = append(consumers, consumer)
+		}
+	}
+	return consumers
+}
+
+// 返回排序后的owner, 包括正在创建的consumer
+func (m *consumerMap) owners() []string {
+	owners := make([]string, 0)
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.RLock()
+		for owner := range s.entries {
+			owners = append(owners, owner)
+		}
+		s.mu.RUnlock()
+	}
+	sort.Strings(owners)
+	return owners
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/engine/kafka"
+)
+
+func TestConsumerMapCreateOnce(t *testing.T) {
+	m := newConsumerMap()
+	var created int32
+	create := func() (*kafka.Consumer, error) {
+		atomic.AddInt32(&created, 1)
+		time.Sleep(20 * time.Millisecond)
+		return &kafka.Consumer{}, nil
+	}
+
+	var wg sync.WaitGroup
+	consumers := make([]*kafka.Consumer, 16)
+	for i := range consumers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			consumers[i], _ = m.getOrCreate("q1@g1", create)
+		}(i)
+	}
+	wg.Wait()
+
+	if created != 1 {
+		t.Fatalf("expect create once, got %d", created)
+	}
+	for _, consumer := range consumers {
+		if consumer == nil || consumer != consumers[0] {
+			t.Fatalf("expect the same consumer, got %p %p", consumer, consumers[0])
+		}
+	}
+	if consumer, ok := m.get("q1@g1"); !ok || consumer != consumers[0] {
+		t.Errorf("get consumer: %p %v", consumer, ok)
+	}
+}
+
+func TestConsumerMapCreateError(t *testing.T) {
+	m := newConsumerMap()
+	errCreate := errors.New("create error")
+	if _, err := m.getOrCreate("q1@g1", func() (*kafka.Consumer, error) { return nil, errCreate }); err != errCreate {
+		t.Fatalf("expect create error, got %v", err)
+	}
+	if _, ok := m.get("q1@g1"); ok {
+		t.Fatalf("failed consumer should not be kept")
+	}
+
+	expect := &kafka.Consumer{}
+	if consumer, err := m.getOrCreate("q1@g1", func() (*kafka.Consumer, error) { return expect, nil }); err != nil || consumer != expect {
+		t.Errorf("expect retry create, got %p %v", consumer, err)
+	}
+}
+
+func TestConsumerMapRemove(t *testing.T) {
+	m := newConsumerMap()
+	consumers := make(map[string]*kafka.Consumer)
+	for _, owner := range []string{"q1@g1", "q1@g2", "q10@g1", "q2@g1"} {
+		consumer := &kafka.Consumer{}
+		consumers[owner] = consumer
+		m.getOrCreate(owner, func() (*kafka.Consumer, error) { return consumer, nil })
+	}
+	if owners := m.owners(); !reflect.DeepEqual(owners, []string{"q10@g1", "q1@g1", "q1@g2", "q2@g1"}) {
+		t.Fatalf("owners: %v", owners)
+	}
+
+	if m.removeIf("q2@g1", &kafka.Consumer{}) {
+		t.Errorf("should not remove a different consumer")
+	}
+	if !m.removeIf("q2@g1", consumers["q2@g1"]) {
+		t.Errorf("expect remove q2@g1")
+	}
+	if consumer, ok := m.remove("q10@g1"); !ok || consumer != consumers["q10@g1"] {
+		t.Errorf("remove q10@g1: %p %v", consumer, ok)
+	}
+	if _, ok := m.remove("q10@g1"); ok {
+		t.Errorf("q10@g1 has been removed")
+	}
+
+	if removed := m.removePrefix("q1@"); len(removed) != 2 {
+		t.Errorf("expect remove 2 consumers of q1, got %d", len(removed))
+	}
+	if owners := m.owners(); len(owners) != 0 {
+		t.Errorf("expect no owners, got %v", owners)
+	}
+}
+
+func BenchmarkConsumerMapGet(b *testing.B) {
+	m := newConsumerMap()
+	owners := make([]string, 64)
+	for i := range owners {
+		owners[i] = fmt.Sprintf("q%d@g1", i)
+		m.getOrCreate(owners[i], func() (*kafka.Consumer, error) { return &kafka.Consumer{}, nil })
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			m.get(owners[i%len(owners)])
+			i++
+		}
+	})
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// queue的consumer参数, 0表示使用kafka.consumer.*的全局配置.
+// 字节数对应sarama的Consumer.Fetch, 时间单位为毫秒, 修改后对新建的consumer生效
+type ConsumerTuning struct {
+	FetchMin       int32 `json:"fetch_min,omitempty"`
+	FetchDefault   int32 `json:"fetch_default,omitempty"`
+	FetchMax       int32 `json:"fetch_max,omitempty"`
+	MaxWait        int64 `json:"max_wait,omitempty"`
+	ChannelBuffer  int   `json:"channel_buffer,omitempty"`
+	CommitInterval int64 `json:"commit_interval,omitempty"`
+}
+
+func (t *ConsumerTuning) empty() bool {
+	return *t == ConsumerTuning{}
+}
+
+// 返回应用了queue参数的配置拷贝, 没有参数时返回原配置
+func (t *ConsumerTuning) apply(clusterConfig *sarama.Config) *sarama.Config {
+	if t == nil || t.empty() {
+		return clusterConfig
+	}
+	config := *clusterConfig
+	if t.FetchMin > 0 {
+		config.Consumer.Fetch.Min = t.FetchMin
+	}
+	if t.FetchDefault > 0 {
+		config.Consumer.Fetch.Default = t.FetchDefault
+	}
+	if t.FetchMax > 0 {
+		config.Consumer.Fetch.Max = t.FetchMax
+	}
+	if t.MaxWait > 0 {
+		config.Consumer.MaxWaitTime = time.Duration(t.MaxWait) * time.Millisecond
+	}
+	if t.ChannelBuffer > 0 {
+		config.ChannelBufferSize = t.ChannelBuffer
+	}
+	if t.CommitInterval > 0 {
+		config.Consumer.Offsets.CommitInterval = time.Duration(t.CommitInterval) * time.Millisecond
+	}
+	return &config
+}
+
+// 大消息的queue可以调大fetch_default(不能超过fetch_max), 避免消息超过fetch大小时反复重试
+func (q *queueImp) SetQueueConsumer(queue string, tuning ConsumerTuning) error {
+
+	if tuning.FetchMin < 0 || tuning.FetchDefault < 0 || tuning.FetchMax < 0 ||
+		tuning.MaxWait < 0 || tuning.ChannelBuffer < 0 || tuning.CommitInterval < 0 {
+		return errors.NotValidf("consumer : %+v", tuning)
+	}
+	applied := tuning.apply(q.clusterConfig)
+	if err := applied.Validate(); err != nil {
+		return errors.NewNotValid(err, "consumer")
+	}
+	if fetch := applied.Consumer.Fetch; fetch.Max > 0 && (fetch.Default > fetch.Max || fetch.Min > fetch.Max) {
+		return errors.NotValidf("consumer fetch min %d, default %d greater than max %d", fetch.Min, fetch.Default, fetch.Max)
+	}
+
+	return q.metadata.ModifyQueueConfig(queue, func(config *QueueConfig) error {
+		if tuning.empty() {
+			config.Consumer = nil
+		} else {
+			config.Consumer = &tuning
+		}
+		return nil
+	})
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+func TestConsumerTuning(t *testing.T) {
+	clusterConfig := genClusterConfig("localhost")
+
+	var tuning *ConsumerTuning
+	if c := tuning.apply(clusterConfig); c != clusterConfig {
+		t.Error("nil tuning should use default config")
+	}
+	if c := (&ConsumerTuning{}).apply(clusterConfig); c != clusterConfig {
+		t.Error("empty tuning should use default config")
+	}
+
+	tuning = &ConsumerTuning{FetchDefault: 4 << 20, MaxWait: 500, CommitInterval: 1000}
+	c := tuning.apply(clusterConfig)
+	if c == clusterConfig {
+		t.Fatal("tuning should copy config")
+	}
+	if c.Consumer.Fetch.Default != 4<<20 || c.Consumer.MaxWaitTime != 500*time.Millisecond ||
+		c.Consumer.Offsets.CommitInterval != time.Second {
+		t.Errorf("tuning not applied: %+v", c.Consumer)
+	}
+	if c.ChannelBufferSize != clusterConfig.ChannelBufferSize || c.Consumer.Fetch.Min != clusterConfig.Consumer.Fetch.Min {
+		t.Error("unset tuning should keep default")
+	}
+	if clusterConfig.Consumer.Fetch.Default == 4<<20 {
+		t.Error("default config modified")
+	}
+
+	q := &queueImp{clusterConfig: clusterConfig}
+	for _, invalid := range []ConsumerTuning{
+		{FetchMin: -1},
+		{ChannelBuffer: -1},
+		{FetchDefault: 1 << 20, FetchMax: 1 << 10},
+	} {
+		if err := q.SetQueueConsumer("q", invalid); !errors.IsNotValid(err) {
+			t.Errorf("set consumer %+v err: %v, want not valid", invalid, err)
+		}
+	}
+}
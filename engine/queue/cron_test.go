@@ -0,0 +1,72 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@every"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("cron %q should be invalid", expr)
+		}
+	}
+
+	at := func(s string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	cases := []struct {
+		expr  string
+		time  string
+		match bool
+	}{
+		{"* * * * *", "2026-10-17 06:14", true},
+		{"*/15 * * * *", "2026-10-17 06:30", true},
+		{"*/15 * * * *", "2026-10-17 06:31", false},
+		{"5/10 * * * *", "2026-10-17 06:25", true},
+		{"0 9-18/3 * * *", "2026-10-17 15:00", true},
+		{"0 9-18/3 * * *", "2026-10-17 16:00", false},
+		{"30 8 1,15 * *", "2026-10-15 08:30", true},
+		{"30 8 * 11 *", "2026-10-15 08:30", false},
+		// 2026-10-18是周日
+		{"0 0 * * 7", "2026-10-18 00:00", true},
+		{"0 0 * * 0", "2026-10-18 00:00", true},
+		{"0 0 * * 1-5", "2026-10-18 00:00", false},
+		// 日和周都指定时满足其一即可
+		{"0 0 1 * 0", "2026-10-18 00:00", true},
+		{"0 0 1 * 0", "2026-10-01 00:00", true},
+		{"0 0 1 * 0", "2026-10-02 00:00", false},
+		{"@daily", "2026-10-17 00:00", true},
+		{"@hourly", "2026-10-17 06:01", false},
+	}
+	for _, c := range cases {
+		cron, err := parseCron(c.expr)
+		if err != nil {
+			t.Errorf("parse cron %q error: %v", c.expr, err)
+			continue
+		}
+		if got := cron.match(at(c.time)); got != c.match {
+			t.Errorf("cron %q at %s: %v, want %v", c.expr, c.time, got, c.match)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"sync"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+const (
+	// 加密消息的header, 值为密钥的名字
+	encryptHeader   = "wqs-encrypt"
+	cryptoKeyPrefix = "key."
+)
+
+// 按名字提供AES密钥(16, 24或32字节), 同一个名字的密钥不能改变, 否则之前的消息无法解密
+type KeyProvider interface {
+	Key(name string) ([]byte, error)
+}
+
+type KeyProviderFactory func(conf *config.Config) (KeyProvider, error)
+
+var (
+	keyProviderMu sync.RWMutex
+	keyProviders  = make(map[string]KeyProviderFactory)
+)
+
+// 对接KMS的插件在init中注册, 通过crypto.kms配置启用, 重复注册时后者覆盖前者
+func RegisterKeyProvider(name string, factory KeyProviderFactory) {
+	keyProviderMu.Lock()
+	keyProviders[name] = factory
+	keyProviderMu.Unlock()
+}
+
+// 用AES-GCM加密消息内容, 密文为12字节nonce + 密文 + 16字节tag.
+// 优先使用配置文件中的密钥, 没有时通过KMS取得, 取得的密钥缓存在内存中
+type payloadCrypto struct {
+	keys map[string][]byte
+	kms  KeyProvider
+
+	mu    sync.RWMutex
+	aeads map[string]cipher.AEAD
+}
+
+// crypto段是可选的, crypto.key.<名字>为base64编码的密钥, crypto.kms为注册的KeyProvider的名字
+func newPayloadCrypto(conf *config.Config) (*payloadCrypto, error) {
+
+	c := &payloadCrypto{
+		keys:  make(map[string][]byte),
+		aeads: make(map[string]cipher.AEAD),
+	}
+	section, err := conf.GetSection("crypto")
+	if err != nil {
+		return c, nil
+	}
+
+	for key, value := range section {
+		if !strings.HasPrefix(key, cryptoKeyPrefix) {
+			continue
+		}
+		secret, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, errors.NotValidf("crypto.%s", key)
+		}
+		c.keys[strings.TrimPrefix(key, cryptoKeyPrefix)] = secret
+	}
+
+	if name := section.GetStringMust("kms", ""); name != "" {
+		keyProviderMu.RLock()
+		factory, ok := keyProviders[name]
+		keyProviderMu.RUnlock()
+		if !ok {
+			return nil, errors.NotFoundf("key provider %q", name)
+		}
+		if c.kms, err = factory(conf); err != nil {
+			return nil, errors.Annotatef(err, "key provider %q", name)
+		}
+	}
+	return c, nil
+}
+
+func (c *payloadCrypto) aead(name string) (cipher.AEAD, error) {
+	c.mu.RLock()
+	aead, ok := c.aeads[name]
+	c.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	secret, ok := c.keys[name]
+	if !ok {
+		if c.kms == nil {
+			return nil, errors.NotFoundf("crypto key %q", name)
+		}
+		var err error
+		if secret, err = c.kms.Key(name); err != nil {
+			return nil, errors.Annotatef(err, "crypto key %q", name)
+		}
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, errors.NewNotValid(err, "crypto key "+name)
+	}
+	if aead, err = cipher.NewGCM(block); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	c.mu.Lock()
+	c.aeads[name] = aead
+	c.mu.Unlock()
+	return aead, nil
+}
+
+func (c *payloadCrypto) encrypt(name string, data []byte) ([]byte, error) {
+	aead, err := c.aead(name)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// 没有加密header的消息原样返回, 开启加密之前发送的消息不受影响
+func (c *payloadCrypto) decrypt(headers []*sarama.RecordHeader, data []byte) ([]byte, error) {
+	name, ok := encryptedBy(headers)
+	if !ok {
+		return data, nil
+	}
+	aead, err := c.aead(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.NotValidf("encrypted message of %d bytes", len(data))
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.Annotatef(err, "decrypt message with key %q", name)
+	}
+	return plain, nil
+}
+
+func encryptedBy(headers []*sarama.RecordHeader) (string, bool) {
+	for _, h := range headers {
+		if h != nil && string(h.Key) == encryptHeader {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
+
+// 设置queue加密使用的密钥名字, 空字符串表示不加密. 密钥名字写入消息header, 需要kafka.version不低于0.11.0.0
+func (q *queueImp) SetQueueEncryption(queue string, key string) error {
+
+	if key != "" {
+		if !q.clusterConfig.Version.IsAtLeast(sarama.V0_11_0_0) {
+			return errors.NotValidf("encryption without message header, kafka.version %s", q.clusterConfig.Version)
+		}
+		if _, err := q.crypto.aead(key); err != nil {
+			return err
+		}
+	}
+
+	return q.metadata.ModifyQueueConfig(queue, func(config *QueueConfig) error {
+		config.Encryption = key
+		return nil
+	})
+}
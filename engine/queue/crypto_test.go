@@ -0,0 +1,119 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"testing"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+type testKMS struct {
+	calls int
+}
+
+func (k *testKMS) Key(name string) ([]byte, error) {
+	k.calls++
+	if name != "remote" {
+		return nil, errors.NotFoundf("key %q", name)
+	}
+	return bytes.Repeat([]byte("r"), 32), nil
+}
+
+func TestPayloadCrypto(t *testing.T) {
+	kms := &testKMS{}
+	RegisterKeyProvider("test_kms", func(conf *config.Config) (KeyProvider, error) {
+		return kms, nil
+	})
+
+	// MDEyMzQ1Njc4OWFiY2RlZg== 为16字节的"0123456789abcdef"
+	conf, err := config.NewConfigFromBytes([]byte(testAlertBaseConfig +
+		"crypto.key.local=MDEyMzQ1Njc4OWFiY2RlZg==\ncrypto.kms=test_kms\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newPayloadCrypto(conf)
+	if err != nil {
+		t.Fatalf("newPayloadCrypto err: %v", err)
+	}
+
+	plain := []byte("sensitive")
+	for _, name := range []string{"local", "remote"} {
+		data, err := c.encrypt(name, plain)
+		if err != nil {
+			t.Fatalf("encrypt with %q err: %v", name, err)
+		}
+		if bytes.Contains(data, plain) {
+			t.Errorf("plain text in encrypted message")
+		}
+		headers := []*sarama.RecordHeader{{Key: []byte(encryptHeader), Value: []byte(name)}}
+		if decrypted, err := c.decrypt(headers, data); err != nil || !bytes.Equal(decrypted, plain) {
+			t.Errorf("decrypt with %q: %q %v", name, decrypted, err)
+		}
+
+		data[len(data)-1] ^= 1
+		if _, err := c.decrypt(headers, data); err == nil {
+			t.Errorf("decrypt modified message with %q should fail", name)
+		}
+	}
+	// KMS的密钥缓存后不再获取
+	c.encrypt("remote", plain)
+	if kms.calls != 1 {
+		t.Errorf("kms called %d times", kms.calls)
+	}
+	if _, err := c.encrypt("missing", plain); err == nil {
+		t.Error("encrypt with missing key should fail")
+	}
+
+	// 没有加密header的消息原样返回
+	if data, err := c.decrypt(nil, plain); err != nil || !bytes.Equal(data, plain) {
+		t.Errorf("decrypt plain message: %q %v", data, err)
+	}
+
+	conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig + "crypto.kms=none\n"))
+	if _, err = newPayloadCrypto(conf); !errors.IsNotFound(err) {
+		t.Errorf("unknown kms err: %v, want not found", err)
+	}
+}
+
+func TestSetQueueEncryption(t *testing.T) {
+	clusterConfig := genClusterConfig("localhost")
+	clusterConfig.Version = sarama.V0_11_0_0
+	q := &queueImp{clusterConfig: clusterConfig, crypto: &payloadCrypto{
+		keys: map[string][]byte{
+			"bad":  []byte("short"),
+			"good": bytes.Repeat([]byte("g"), 16),
+		},
+		aeads: make(map[string]cipher.AEAD),
+	}}
+
+	if err := q.SetQueueEncryption("q", "bad"); !errors.IsNotValid(err) {
+		t.Errorf("bad key err: %v, want not valid", err)
+	}
+	if err := q.SetQueueEncryption("q", "missing"); !errors.IsNotFound(err) {
+		t.Errorf("missing key err: %v, want not found", err)
+	}
+	clusterConfig.Version = sarama.V0_10_2_0
+	if err := q.SetQueueEncryption("q", "good"); !errors.IsNotValid(err) {
+		t.Errorf("encryption without header err: %v, want not valid", err)
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/log"
+
+	"github.com/juju/errors"
+)
+
+const (
+	defaultDedupWindow = 300
+	defaultDedupSize   = 100000
+	maxDedupIDLen      = 128
+)
+
+// 保存去重key与第一次发送时返回的消息id
+type dedupStore interface {
+	Get(key string) (string, bool, error)
+	Set(key string, receipt string, window time.Duration) error
+}
+
+type lruEntry struct {
+	key     string
+	receipt string
+	expire  time.Time
+}
+
+// 带过期时间的LRU, 超过容量时淘汰最久未使用的key
+type lruStore struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	ll      *list.List
+}
+
+func newLRUStore(size int) *lruStore {
+	return &lruStore{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		ll:      list.New(),
+	}
+}
+
+func (s *lruStore) Get(key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return "", false, nil
+	}
+	entry := e.Value.(*lruEntry)
+	if time.Now().After(entry.expire) {
+		s.ll.Remove(e)
+		delete(s.entries, key)
+		return "", false, nil
+	}
+	s.ll.MoveToFront(e)
+	return entry.receipt, true, nil
+}
+
+func (s *lruStore) Set(key string, receipt string, window time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expire := time.Now().Add(window)
+	if e, ok := s.entries[key]; ok {
+		entry := e.Value.(*lruEntry)
+		entry.receipt, entry.expire = receipt, expire
+		s.ll.MoveToFront(e)
+		return nil
+	}
+
+	s.entries[key] = s.ll.PushFront(&lruEntry{key: key, receipt: receipt, expire: expire})
+	for s.ll.Len() > s.size {
+		e := s.ll.Back()
+		s.ll.Remove(e)
+		delete(s.entries, e.Value.(*lruEntry).key)
+	}
+	return nil
+}
+
+type dedupCall struct {
+	wg      sync.WaitGroup
+	receipt string
+	err     error
+}
+
+// 发送端去重, 本地LRU之外可以使用redis在多个proxy之间共享去重记录
+type deduper struct {
+	window   time.Duration
+	local    dedupStore
+	remote   dedupStore
+	mu       sync.Mutex
+	inflight map[string]*dedupCall
+}
+
+func newDeduper(conf *config.Config) *deduper {
+	window, size := int64(defaultDedupWindow), int64(defaultDedupSize)
+	var remote dedupStore
+
+	// dedup段是可选的
+	if section, err := conf.GetSection("dedup"); err == nil {
+		window = section.GetInt64Must("window", window)
+		size = section.GetInt64Must("size", size)
+		if addr := section.GetStringMust("redis.addr", ""); addr != "" {
+			remote = newRedisStore(addr, section.GetStringMust("redis.password", ""),
+				section.GetInt64Must("redis.db", 0))
+		}
+	}
+
+	return &deduper{
+		window:   time.Duration(window) * time.Second,
+		local:    newLRUStore(int(size)),
+		remote:   remote,
+		inflight: make(map[string]*dedupCall),
+	}
+}
+
+func (d *deduper) lookup(key string) (string, bool) {
+	if receipt, ok, _ := d.local.Get(key); ok {
+		return receipt, true
+	}
+	if d.remote == nil {
+		return "", false
+	}
+
+	receipt, ok, err := d.remote.Get(key)
+	if err != nil {
+		// redis不可用时退化为只使用本地去重
+		log.Warnf("dedup: get %s from redis error %v", key, err)
+		return "", false
+	}
+	if ok {
+		d.local.Set(key, receipt, d.window)
+	}
+	return receipt, ok
+}
+
+func (d *deduper) record(key string, receipt string) {
+	d.local.Set(key, receipt, d.window)
+	if d.remote != nil {
+		if err := d.remote.Set(key, receipt, d.window); err != nil {
+			log.Warnf("dedup: set %s to redis error %v", key, err)
+		}
+	}
+}
+
+// 窗口内已经发送过key时返回原来的消息id和true, 否则调用send发送.
+// 同一个key的并发请求只会发送一次
+func (d *deduper) do(key string, send func() (string, error)) (string, bool, error) {
+	if receipt, ok := d.lookup(key); ok {
+		return receipt, true, nil
+	}
+
+	d.mu.Lock()
+	if c, ok := d.inflight[key]; ok {
+		d.mu.Unlock()
+		c.wg.Wait()
+		return c.receipt, c.err == nil, c.err
+	}
+	c := &dedupCall{}
+	c.wg.Add(1)
+	d.inflight[key] = c
+	d.mu.Unlock()
+
+	c.receipt, c.err = send()
+	// 没有消息id时消息没有写入, 不记录, 客户端重试时重新发送
+	if c.err == nil && c.receipt != "" {
+		d.record(key, c.receipt)
+	}
+	c.wg.Done()
+
+	d.mu.Lock()
+	delete(d.inflight, key)
+	d.mu.Unlock()
+	return c.receipt, false, c.err
+}
+
+// 没有配置redis时返回nil
+func (d *deduper) ping() error {
+	if d.remote == nil {
+		return nil
+	}
+	if p, ok := d.remote.(interface {
+		Ping() error
+	}); ok {
+		return p.Ping()
+	}
+	return nil
+}
+
+func validDedupID(id string) error {
+	if len(id) > maxDedupIDLen {
+		return errors.NotValidf("message id longer than %d", maxDedupIDLen)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const (
+	redisKeyPrefix = "wqs:dedup:"
+	redisTimeout   = 100 * time.Millisecond
+)
+
+// 只实现去重需要的GET和SET命令, 所有请求共用一个连接, 出错后下次请求时重连
+type redisStore struct {
+	addr     string
+	password string
+	db       int64
+	mu       sync.Mutex
+	conn     net.Conn
+	r        *bufio.Reader
+	w        *bufio.Writer
+}
+
+func newRedisStore(addr string, password string, db int64) *redisStore {
+	return &redisStore{addr: addr, password: password, db: db}
+}
+
+func (s *redisStore) Get(key string) (string, bool, error) {
+	reply, err := s.do("GET", redisKeyPrefix+key)
+	if err != nil {
+		return "", false, err
+	}
+	if reply == nil {
+		return "", false, nil
+	}
+	return reply.(string), true, nil
+}
+
+func (s *redisStore) Set(key string, receipt string, window time.Duration) error {
+	ms := strconv.FormatInt(window.Nanoseconds()/1e6, 10)
+	_, err := s.do("SET", redisKeyPrefix+key, receipt, "PX", ms)
+	return err
+}
+
+func (s *redisStore) Ping() error {
+	_, err := s.do("PING")
+	return err
+}
+
+func (s *redisStore) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, redisTimeout)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	s.conn = conn
+	s.r = bufio.NewReader(conn)
+	s.w = bufio.NewWriter(conn)
+
+	if s.password != "" {
+		if _, err = s.roundTrip("AUTH", s.password); err != nil {
+			return err
+		}
+	}
+	if s.db != 0 {
+		if _, err = s.roundTrip("SELECT", strconv.FormatInt(s.db, 10)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *redisStore) do(args ...string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			s.close()
+			return nil, err
+		}
+	}
+	reply, err := s.roundTrip(args...)
+	if err != nil {
+		s.close()
+	}
+	return reply, err
+}
+
+func (s *redisStore) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func (s *redisStore) roundTrip(args ...string) (interface{}, error) {
+	s.conn.SetDeadline(time.Now().Add(redisTimeout))
+	writeRedisCommand(s.w, args...)
+	if err := s.w.Flush(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return readRedisReply(s.r)
+}
+
+func writeRedisCommand(w *bufio.Writer, args ...string) {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// 返回string, int64或nil, 不支持数组
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.Errorf("redis: bad reply %q", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.Errorf("redis: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errors.Errorf("redis: bad reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return nil, errors.Trace(err)
+		}
+		return string(data[:n]), nil
+	}
+	return nil, errors.Errorf("redis: unsupported reply %q", line)
+}
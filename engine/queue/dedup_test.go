@@ -0,0 +1,199 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLRUStore(t *testing.T) {
+	s := newLRUStore(2)
+	s.Set("a", "1", time.Minute)
+	s.Set("b", "2", time.Minute)
+	s.Get("a")
+	s.Set("c", "3", time.Minute)
+
+	if _, ok, _ := s.Get("b"); ok {
+		t.Errorf("least recently used key should be evicted")
+	}
+	if r, ok, _ := s.Get("a"); !ok || r != "1" {
+		t.Errorf("expect a=1, got %q %v", r, ok)
+	}
+
+	s.Set("d", "4", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := s.Get("d"); ok {
+		t.Errorf("expired key should not be found")
+	}
+}
+
+func TestDeduperDo(t *testing.T) {
+	d := &deduper{
+		window:   time.Minute,
+		local:    newLRUStore(10),
+		inflight: make(map[string]*dedupCall),
+	}
+
+	var sent int32
+	send := func() (string, error) {
+		atomic.AddInt32(&sent, 1)
+		time.Sleep(10 * time.Millisecond)
+		return "receipt-1", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r, _, err := d.do("k", send); err != nil || r != "receipt-1" {
+				t.Errorf("expect receipt-1, got %q %v", r, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if r, dup, _ := d.do("k", send); !dup || r != "receipt-1" {
+		t.Errorf("expect duplicated receipt-1, got %q %v", r, dup)
+	}
+	if sent != 1 {
+		t.Errorf("expect send once, got %d", sent)
+	}
+
+	// 发送失败时不记录, 重试会再次发送
+	failed := func() (string, error) { return "", fmt.Errorf("send error") }
+	if _, _, err := d.do("k2", failed); err == nil {
+		t.Fatalf("expect send error")
+	}
+	if _, dup, _ := d.do("k2", send); dup {
+		t.Errorf("failed send should not be recorded")
+	}
+
+	// 没有消息id的发送不记录
+	empty := func() (string, error) { return "", nil }
+	d.do("k3", empty)
+	if _, dup, _ := d.do("k3", send); dup {
+		t.Errorf("send without receipt should not be recorded")
+	}
+}
+
+// 只支持GET和SET的redis
+func fakeRedis(t *testing.T) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	data := make(map[string]string)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+					args := make([]string, n)
+					for i := range args {
+						line, _ = r.ReadString('\n')
+						size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+						buf := make([]byte, size+2)
+						io.ReadFull(r, buf)
+						args[i] = string(buf[:size])
+					}
+
+					mu.Lock()
+					switch args[0] {
+					case "GET":
+						if v, ok := data[args[1]]; ok {
+							fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+						} else {
+							conn.Write([]byte("$-1\r\n"))
+						}
+					case "SET":
+						data[args[1]] = args[2]
+						conn.Write([]byte("+OK\r\n"))
+					default:
+						conn.Write([]byte("-ERR unknown command\r\n"))
+					}
+					mu.Unlock()
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func TestRedisStore(t *testing.T) {
+	addr, stop := fakeRedis(t)
+	defer stop()
+
+	s := newRedisStore(addr, "", 0)
+	if _, ok, err := s.Get("k"); ok || err != nil {
+		t.Fatalf("expect miss, got %v %v", ok, err)
+	}
+	if err := s.Set("k", "receipt", time.Minute); err != nil {
+		t.Fatalf("set error %v", err)
+	}
+	if r, ok, err := s.Get("k"); !ok || err != nil || r != "receipt" {
+		t.Fatalf("expect receipt, got %q %v %v", r, ok, err)
+	}
+
+	s.password = "secret"
+	s.close()
+	if _, _, err := s.Get("k"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("expect auth error, got %v", err)
+	}
+}
+
+func TestReadRedisReply(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect interface{}
+		err    bool
+	}{
+		{"+OK\r\n", "OK", false},
+		{":12\r\n", int64(12), false},
+		{"$5\r\nhello\r\n", "hello", false},
+		{"$-1\r\n", nil, false},
+		{"-ERR wrong\r\n", nil, true},
+		{"*1\r\n", nil, true},
+	}
+	for _, test := range tests {
+		reply, err := readRedisReply(bufio.NewReader(strings.NewReader(test.input)))
+		if (err != nil) != test.err || reply != test.expect {
+			t.Errorf("read %q expect %v, got %v %v", test.input, test.expect, reply, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"github.com/weibocom/wqs/engine/kafka"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// group在本机房kafka中的消费情况
+type GroupDescription struct {
+	Queue      string           `json:"queue"`
+	Group      string           `json:"group"`
+	State      string           `json:"state"`
+	Members    []*GroupMember   `json:"members"`
+	Partitions []*PartitionInfo `json:"partitions"`
+}
+
+// 消费该queue的成员, 一般是proxy上的consumer, ClientID为pid..hostname
+type GroupMember struct {
+	MemberID   string  `json:"member_id"`
+	ClientID   string  `json:"client_id"`
+	ClientHost string  `json:"client_host"`
+	Partitions []int32 `json:"partitions"`
+}
+
+// partition的消费进度, Owner为分配到该partition的成员, CommitTime为最后一次提交的毫秒时间戳, 未知时为0
+type PartitionInfo struct {
+	Partition  int32  `json:"partition"`
+	Owner      string `json:"owner,omitempty"`
+	Offset     int64  `json:"offset"`
+	Newest     int64  `json:"newest"`
+	Lag        int64  `json:"lag"`
+	CommitTime int64  `json:"commit_time,omitempty"`
+}
+
+// 只保留订阅了queue的成员, 同名group订阅的其他queue的成员不返回
+func newGroupDescription(queue string, group string, desc *kafka.GroupDescription,
+	commits []kafka.PartitionCommit, newest map[int32]int64) *GroupDescription {
+
+	result := &GroupDescription{
+		Queue:      queue,
+		Group:      group,
+		State:      desc.State,
+		Members:    make([]*GroupMember, 0),
+		Partitions: make([]*PartitionInfo, 0, len(commits)),
+	}
+
+	owners := make(map[int32]string)
+	for _, m := range desc.Members {
+		partitions := m.Partitions[queue]
+		if !contains(m.Topics, queue) && len(partitions) == 0 {
+			continue
+		}
+		if partitions == nil {
+			partitions = make([]int32, 0)
+		}
+		result.Members = append(result.Members, &GroupMember{
+			MemberID:   m.MemberID,
+			ClientID:   m.ClientID,
+			ClientHost: m.ClientHost,
+			Partitions: partitions,
+		})
+		for _, p := range partitions {
+			owners[p] = m.MemberID
+		}
+	}
+
+	for _, c := range commits {
+		info := &PartitionInfo{
+			Partition:  c.Partition,
+			Owner:      owners[c.Partition],
+			Offset:     c.Offset,
+			Newest:     newest[c.Partition],
+			CommitTime: c.CommitTime,
+		}
+		// 没有提交过offset时为-1
+		if c.Offset >= 0 {
+			info.Lag = info.Newest - c.Offset
+		}
+		result.Partitions = append(result.Partitions, info)
+	}
+	return result
+}
+
+// 从本机房kafka的coordinator查询group的成员、partition分配和每个partition的提交情况.
+// 广播模式的group每个实例使用单独的kafka group, 这里只返回group本身的情况
+func (q *queueImp) DescribeGroup(queue string, group string) (*GroupDescription, error) {
+
+	if !q.metadata.ExistGroup(queue, group) {
+		return nil, errors.NotFoundf("queue : %q, group: %q", queue, group)
+	}
+
+	manager := q.metadata.LocalManager()
+	desc, err := manager.DescribeGroup(group)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	commits, err := manager.FetchGroupCommits(queue, group)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	newest, err := manager.FetchTopicOffsets(queue, sarama.OffsetNewest)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return newGroupDescription(queue, group, desc, commits, newest), nil
+}
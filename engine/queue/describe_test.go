@@ -0,0 +1,55 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+
+	"github.com/weibocom/wqs/engine/kafka"
+)
+
+func TestNewGroupDescription(t *testing.T) {
+	desc := &kafka.GroupDescription{
+		Group: "g",
+		State: "Stable",
+		Members: []*kafka.GroupMember{
+			{MemberID: "m1", ClientID: "1..host1", Topics: []string{"q"}, Partitions: map[string][]int32{"q": {0, 1}}},
+			{MemberID: "m2", ClientID: "2..host2", Topics: []string{"q"}, Partitions: map[string][]int32{}},
+			{MemberID: "m3", ClientID: "3..host3", Topics: []string{"other"}, Partitions: map[string][]int32{"other": {0}}},
+		},
+	}
+	commits := []kafka.PartitionCommit{
+		{Partition: 0, Offset: 10, CommitTime: 1000},
+		{Partition: 1, Offset: -1},
+	}
+	newest := map[int32]int64{0: 15, 1: 3}
+
+	result := newGroupDescription("q", "g", desc, commits, newest)
+	if result.State != "Stable" || len(result.Members) != 2 {
+		t.Fatalf("expect 2 members of queue q, got %+v", result.Members)
+	}
+	if result.Members[1].MemberID != "m2" || result.Members[1].Partitions == nil || len(result.Members[1].Partitions) != 0 {
+		t.Errorf("expect idle member m2 without partitions, got %+v", result.Members[1])
+	}
+	p0, p1 := result.Partitions[0], result.Partitions[1]
+	if p0.Owner != "m1" || p0.Lag != 5 || p0.CommitTime != 1000 {
+		t.Errorf("unexpected partition 0 %+v", p0)
+	}
+	if p1.Owner != "m1" || p1.Lag != 0 || p1.Newest != 3 {
+		t.Errorf("unexpected partition 1 %+v", p1)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/log"
+
+	"github.com/juju/errors"
+)
+
+// 在线的proxy实例, 客户端可以据此做负载均衡
+type ProxyInstance struct {
+	ID           int      `json:"id"`
+	Host         string   `json:"host"`
+	Idc          string   `json:"idc,omitempty"`
+	Version      string   `json:"version,omitempty"`
+	HTTPPort     string   `json:"http_port,omitempty"`
+	McPort       string   `json:"mc_port,omitempty"`
+	Capabilities []string `json:"capabilities"`
+	Start        int64    `json:"start,omitempty"`
+	// 是否为执行后台任务的leader
+	Leader bool `json:"leader,omitempty"`
+}
+
+// proxy支持的协议和开启的功能
+func proxyCapabilities(conf *config.Config) []string {
+	capabilities := []string{"http"}
+	if conf.McPort != "" {
+		capabilities = append(capabilities, "mc")
+	}
+	if section, err := conf.GetSection("redis"); err == nil && section.GetStringMust("addr", "") != "" {
+		capabilities = append(capabilities, "redis")
+	}
+	if section, err := conf.GetSection("tls"); err == nil && section.GetStringMust("cert.file", "") != "" {
+		capabilities = append(capabilities, "https")
+	}
+	for _, feature := range []string{"auth", "mirror", "trace"} {
+		if section, err := conf.GetSection(feature); err == nil && section.GetBoolMust("enable", false) {
+			capabilities = append(capabilities, feature)
+		}
+	}
+	return capabilities
+}
+
+// 注册的地址默认为hostname, 可以通过proxy.advertise.host指定
+func advertiseHost(conf *config.Config, hostname string) string {
+	if section, err := conf.GetSection("proxy"); err == nil {
+		if host := section.GetStringMust("advertise.host", ""); host != "" {
+			return host
+		}
+	}
+	return hostname
+}
+
+func newProxyInstance(id int, info *proxyInfo) *ProxyInstance {
+	instance := &ProxyInstance{
+		ID:           id,
+		Host:         info.Host,
+		Idc:          info.Idc,
+		Version:      info.Version,
+		HTTPPort:     info.HTTPPort,
+		McPort:       info.McPort,
+		Capabilities: info.Capabilities,
+		Start:        info.Start,
+	}
+	if instance.Capabilities == nil {
+		instance.Capabilities = make([]string, 0)
+	}
+	return instance
+}
+
+// 返回所有在线的proxy实例, 按id排序
+func (m *Metadata) Instances() ([]*ProxyInstance, error) {
+
+	ids, _, err := m.zkConn.Children(m.servicePath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	paths := make([]string, len(ids))
+	for i, id := range ids {
+		paths[i] = fmt.Sprintf("%s/%s", m.servicePath, id)
+	}
+
+	leader, err := m.LeaderID()
+	if err != nil {
+		log.Warnf("get leader error: %s", err)
+	}
+
+	instances := make([]*ProxyInstance, 0, len(ids))
+	for i, node := range m.zkConn.GetMulti(paths, refreshConcurrency) {
+		// 读取前proxy已经下线
+		if node.Err != nil {
+			continue
+		}
+		id, err := strconv.Atoi(ids[i])
+		if err != nil {
+			continue
+		}
+		info := &proxyInfo{}
+		if err = info.Load(node.Data); err != nil {
+			return nil, errors.Trace(err)
+		}
+		instance := newProxyInstance(id, info)
+		instance.Leader = id == leader
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+	return instances, nil
+}
+
+func (q *queueImp) Instances() ([]*ProxyInstance, error) {
+	return q.metadata.Instances()
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/weibocom/wqs/config"
+)
+
+func TestProxyCapabilities(t *testing.T) {
+	conf, err := config.NewConfigFromBytes([]byte(testAlertBaseConfig +
+		"auth.enable=true\nmirror.enable=false\nproxy.advertise.host=10.0.0.1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(proxyCapabilities(conf)); got != "[http mc auth]" {
+		t.Errorf("expect capabilities [http mc auth], got %s", got)
+	}
+	if host := advertiseHost(conf, "host1"); host != "10.0.0.1" {
+		t.Errorf("expect advertise host 10.0.0.1, got %s", host)
+	}
+
+	conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig))
+	if host := advertiseHost(conf, "host1"); host != "host1" {
+		t.Errorf("expect hostname as advertise host, got %s", host)
+	}
+}
+
+func TestNewProxyInstance(t *testing.T) {
+	// 旧版本proxy只注册了host和config
+	info := &proxyInfo{}
+	if err := info.Load([]byte(`{"host":"host1","config":"proxy.id=1"}`)); err != nil {
+		t.Fatal(err)
+	}
+	instance := newProxyInstance(1, info)
+	if instance.ID != 1 || instance.Host != "host1" || instance.Capabilities == nil {
+		t.Errorf("unexpected instance %+v", instance)
+	}
+}
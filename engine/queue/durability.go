@@ -0,0 +1,99 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/engine/kafka"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// 单条消息的持久化级别
+const (
+	// 使用queue的producer, acks由kafka.producer.required.acks决定
+	DurabilityDefault = "default"
+	// 通过acks=all的producer写入, 发送前检查isr不少于min.insync
+	DurabilityAll = "all"
+
+	defaultDurableMinISR = 2
+)
+
+type durabilityKey struct{}
+
+// 发送消息的持久化级别, 不设置时为DurabilityDefault
+func WithDurability(ctx context.Context, level string) context.Context {
+	return context.WithValue(ctx, durabilityKey{}, level)
+}
+
+func durabilityFrom(ctx context.Context) (string, error) {
+	level, _ := ctx.Value(durabilityKey{}).(string)
+	switch level {
+	case "", DurabilityDefault:
+		return DurabilityDefault, nil
+	case DurabilityAll:
+		return DurabilityAll, nil
+	}
+	return "", errors.NotValidf("durability %q, expect default or all", level)
+}
+
+// durability=all的消息发送前的isr检查, 总是拒绝isr不足的写入.
+// queue的模板没有设置min.insync时使用kafka.producer.durable.min.isr
+func newDurableGuard(conf *config.Config, source isrSource) (*isrGuard, error) {
+	minISR := int64(defaultDurableMinISR)
+	if section, err := conf.GetSection("kafka"); err == nil {
+		minISR = section.GetInt64Must("producer.durable.min.isr", minISR)
+	}
+	if minISR < 0 {
+		return nil, errors.NotValidf("kafka.producer.durable.min.isr %d", minISR)
+	}
+	return &isrGuard{
+		mode:   ISRGuardReject,
+		minISR: int(minISR),
+		source: source,
+		states: make(map[string]isrState),
+	}, nil
+}
+
+// 返回conf的一份拷贝, 等待全部isr写入
+func durableConfig(conf *sarama.Config) *sarama.Config {
+	c := *conf
+	c.Producer.RequiredAcks = sarama.WaitForAll
+	return &c
+}
+
+// durability=all的消息使用单独的producer, 不影响普通消息的吞吐和延迟.
+// 幂等和顺序queue的producer已经是acks=all, 直接使用
+func (q *queueImp) getDurableProducer(producer *kafka.Producer) (*kafka.Producer, error) {
+	if producer != q.producer || q.clusterConfig.Producer.RequiredAcks == sarama.WaitForAll {
+		return producer, nil
+	}
+
+	q.producerMu.Lock()
+	defer q.producerMu.Unlock()
+	if q.durable == nil {
+		durable, err := kafka.NewProducer(q.metadata.LocalManager().BrokerAddrs(), durableConfig(q.clusterConfig))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		q.durable = durable
+	}
+	return q.durable, nil
+}
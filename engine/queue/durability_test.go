@@ -0,0 +1,111 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/engine/kafka"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+func TestDurabilityFrom(t *testing.T) {
+	for _, c := range []struct {
+		ctx     context.Context
+		level   string
+		invalid bool
+	}{
+		{context.Background(), DurabilityDefault, false},
+		{WithDurability(context.Background(), ""), DurabilityDefault, false},
+		{WithDurability(context.Background(), "default"), DurabilityDefault, false},
+		{WithDurability(context.Background(), "all"), DurabilityAll, false},
+		{WithDurability(context.Background(), "ALL"), "", true},
+	} {
+		level, err := durabilityFrom(c.ctx)
+		if level != c.level || errors.IsNotValid(err) != c.invalid {
+			t.Errorf("durability %v: level %q err %v", c.ctx.Value(durabilityKey{}), level, err)
+		}
+	}
+}
+
+func TestNewDurableGuard(t *testing.T) {
+	for _, c := range []struct {
+		conf    string
+		minISR  int
+		invalid bool
+	}{
+		{"", defaultDurableMinISR, false},
+		{"kafka.producer.durable.min.isr=3\n", 3, false},
+		{"kafka.producer.durable.min.isr=-1\n", 0, true},
+	} {
+		conf, err := config.NewConfigFromBytes([]byte(testAlertBaseConfig + c.conf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := newDurableGuard(conf, &testISRSource{})
+		if errors.IsNotValid(err) != c.invalid {
+			t.Errorf("%q: err %v", c.conf, err)
+		}
+		if err == nil && (g.mode != ISRGuardReject || g.minISR != c.minISR) {
+			t.Errorf("%q: mode %v min isr %d", c.conf, g.mode, g.minISR)
+		}
+	}
+
+	// queue的min.insync优先于kafka.producer.durable.min.isr
+	source := &testISRSource{under: map[string][]int32{"q1": {0}}}
+	g := &isrGuard{mode: ISRGuardReject, minISR: defaultDurableMinISR, source: source, states: make(map[string]isrState)}
+	if err := g.check("q1", 0, time.Now()); err == nil {
+		t.Errorf("durable send under min isr should be rejected")
+	}
+	if err := g.check("q2", 3, time.Now()); err != nil {
+		t.Errorf("queue with enough isr: %v", err)
+	}
+}
+
+func TestDurableConfig(t *testing.T) {
+	cc := genClusterConfig("localhost")
+	cc.Producer.RequiredAcks = sarama.WaitForLocal
+	durable := durableConfig(cc)
+	if durable.Producer.RequiredAcks != sarama.WaitForAll {
+		t.Errorf("durable acks %d", durable.Producer.RequiredAcks)
+	}
+	if cc.Producer.RequiredAcks != sarama.WaitForLocal {
+		t.Errorf("cluster config should not be changed")
+	}
+}
+
+func TestGetDurableProducer(t *testing.T) {
+	cc := genClusterConfig("localhost")
+	cc.Producer.RequiredAcks = sarama.WaitForLocal
+	q := &queueImp{producer: &kafka.Producer{}, clusterConfig: cc}
+
+	// 幂等和顺序queue的producer直接使用
+	other := &kafka.Producer{}
+	if p, err := q.getDurableProducer(other); err != nil || p != other {
+		t.Errorf("other producer: %p err %v", p, err)
+	}
+
+	cc.Producer.RequiredAcks = sarama.WaitForAll
+	if p, err := q.getDurableProducer(q.producer); err != nil || p != q.producer || q.durable != nil {
+		t.Errorf("producer with acks=all: %p err %v", p, err)
+	}
+}
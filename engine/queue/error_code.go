@@ -0,0 +1,186 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"strings"
+
+	"github.com/weibocom/wqs/engine/kafka"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// 机器可读的错误码, 与错误信息一起返回给客户端, 客户端根据错误码而不是错误信息处理错误
+type ErrorCode string
+
+const (
+	ErrCodeInvalidRequest   ErrorCode = "INVALID_REQUEST"
+	ErrCodeUnauthorized     ErrorCode = "UNAUTHORIZED"
+	ErrCodeForbidden        ErrorCode = "FORBIDDEN"
+	ErrCodeNotFound         ErrorCode = "NOT_FOUND"
+	ErrCodeQueueNotFound    ErrorCode = "QUEUE_NOT_FOUND"
+	ErrCodeGroupNotFound    ErrorCode = "GROUP_NOT_FOUND"
+	ErrCodeAlreadyExists    ErrorCode = "ALREADY_EXISTS"
+	ErrCodeMessageTooLarge  ErrorCode = "MESSAGE_TOO_LARGE"
+	ErrCodeThrottled        ErrorCode = "THROTTLED"
+	ErrCodeQuotaExceeded    ErrorCode = "QUOTA_EXCEEDED"
+	ErrCodeGroupPaused      ErrorCode = "GROUP_PAUSED"
+	ErrCodeUnassigned       ErrorCode = "PARTITION_UNASSIGNED"
+	ErrCodeNoMessage        ErrorCode = "NO_MESSAGE"
+	ErrCodeNotSupported     ErrorCode = "NOT_SUPPORTED"
+	ErrCodeKafkaUnavailable ErrorCode = "KAFKA_UNAVAILABLE"
+	ErrCodeOverloaded       ErrorCode = "OVERLOADED"
+	ErrCodeDropped          ErrorCode = "MESSAGE_DROPPED"
+	ErrCodeInternal         ErrorCode = "INTERNAL_ERROR"
+)
+
+var errorCodeStatus = map[ErrorCode]int{
+	ErrCodeInvalidRequest:   400,
+	ErrCodeUnauthorized:     401,
+	ErrCodeForbidden:        403,
+	ErrCodeNotFound:         404,
+	ErrCodeQueueNotFound:    404,
+	ErrCodeGroupNotFound:    404,
+	ErrCodeAlreadyExists:    409,
+	ErrCodeGroupPaused:      409,
+	ErrCodeUnassigned:       409,
+	ErrCodeMessageTooLarge:  413,
+	ErrCodeThrottled:        429,
+	ErrCodeQuotaExceeded:    429,
+	ErrCodeNoMessage:        404,
+	ErrCodeNotSupported:     501,
+	ErrCodeKafkaUnavailable: 503,
+	ErrCodeOverloaded:       503,
+	ErrCodeDropped:          503,
+	ErrCodeInternal:         500,
+}
+
+// 返回错误对应的HTTP状态码
+func (c ErrorCode) Status() int {
+	if status, ok := errorCodeStatus[c]; ok {
+		return status
+	}
+	return 500
+}
+
+// 相同的请求稍后重试或者换到其他proxy可能成功
+func (c ErrorCode) Retryable() bool {
+	switch c {
+	case ErrCodeThrottled, ErrCodeGroupPaused, ErrCodeUnassigned, ErrCodeNoMessage, ErrCodeKafkaUnavailable,
+		ErrCodeOverloaded:
+		return true
+	}
+	return false
+}
+
+// 没有错误码的HTTP状态码使用的默认错误码
+func ErrorCodeOfStatus(status int) ErrorCode {
+	switch status {
+	case 400:
+		return ErrCodeInvalidRequest
+	case 401:
+		return ErrCodeUnauthorized
+	case 403:
+		return ErrCodeForbidden
+	case 404:
+		return ErrCodeNotFound
+	case 409:
+		return ErrCodeAlreadyExists
+	case 413:
+		return ErrCodeMessageTooLarge
+	case 429:
+		return ErrCodeThrottled
+	case 501:
+		return ErrCodeNotSupported
+	case 503:
+		return ErrCodeKafkaUnavailable
+	}
+	if status >= 400 && status < 500 {
+		return ErrCodeInvalidRequest
+	}
+	return ErrCodeInternal
+}
+
+// 返回错误的错误码, err为nil时返回空
+func ErrorCodeOf(err error) ErrorCode {
+	if err == nil {
+		return ""
+	}
+	cause := errors.Cause(err)
+	switch {
+	case IsCircuitOpen(err), IsUnderReplicated(err), IsFaultInjected(err):
+		return ErrCodeKafkaUnavailable
+	case IsOverloaded(err):
+		return ErrCodeOverloaded
+	case IsDropped(err):
+		return ErrCodeDropped
+	case IsThrottled(err):
+		return ErrCodeThrottled
+	case IsQuotaExceeded(err):
+		return ErrCodeQuotaExceeded
+	case IsMessageTooLarge(err), cause == sarama.ErrMessageSizeTooLarge:
+		return ErrCodeMessageTooLarge
+	case IsPaused(err):
+		return ErrCodeGroupPaused
+	case IsUnassigned(err):
+		return ErrCodeUnassigned
+	case cause == kafka.ErrTimeout:
+		return ErrCodeNoMessage
+	case cause == ErrPermissionDenied:
+		return ErrCodeForbidden
+	case errors.IsUnauthorized(err):
+		return ErrCodeUnauthorized
+	case errors.IsNotFound(err):
+		return notFoundCode(cause.Error())
+	case errors.IsAlreadyExists(err):
+		return ErrCodeAlreadyExists
+	case errors.IsNotValid(err):
+		return ErrCodeInvalidRequest
+	case errors.IsNotSupported(err):
+		return ErrCodeNotSupported
+	case isKafkaUnavailable(cause):
+		return ErrCodeKafkaUnavailable
+	}
+	return ErrCodeInternal
+}
+
+// queue和group不存在的错误信息为"queue : %q"或"queue : %q, group : %q"
+func notFoundCode(msg string) ErrorCode {
+	msg = strings.Replace(msg, " ", "", -1)
+	switch {
+	case strings.HasPrefix(msg, "queue:") && strings.Contains(msg, ",group:"):
+		return ErrCodeGroupNotFound
+	case strings.HasPrefix(msg, "group:"):
+		return ErrCodeGroupNotFound
+	case strings.HasPrefix(msg, "queue:"):
+		return ErrCodeQueueNotFound
+	}
+	return ErrCodeNotFound
+}
+
+// broker不可用、leader切换等kafka集群的暂时性错误
+func isKafkaUnavailable(err error) bool {
+	switch err {
+	case sarama.ErrOutOfBrokers, sarama.ErrNotConnected, sarama.ErrShuttingDown, sarama.ErrClosedClient,
+		sarama.ErrLeaderNotAvailable, sarama.ErrNotLeaderForPartition, sarama.ErrRequestTimedOut,
+		sarama.ErrBrokerNotAvailable, sarama.ErrReplicaNotAvailable, sarama.ErrNotEnoughReplicas,
+		sarama.ErrNotEnoughReplicasAfterAppend:
+		return true
+	}
+	return false
+}
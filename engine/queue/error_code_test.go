@@ -0,0 +1,80 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+
+	"github.com/weibocom/wqs/engine/kafka"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+func TestErrorCodeOf(t *testing.T) {
+	for _, c := range []struct {
+		err       error
+		code      ErrorCode
+		status    int
+		retryable bool
+	}{
+		{nil, "", 500, false},
+		{errors.NotFoundf("queue : %q", "q1"), ErrCodeQueueNotFound, 404, false},
+		{errors.NotFoundf("queue : %q , group: %q", "q1", "g1"), ErrCodeGroupNotFound, 404, false},
+		{errors.NotFoundf("group : %q", "g1"), ErrCodeGroupNotFound, 404, false},
+		{errors.NotFoundf("transfer %q", "t1"), ErrCodeNotFound, 404, false},
+		{errors.AlreadyExistsf("queue: %q ", "q1"), ErrCodeAlreadyExists, 409, false},
+		{errors.NotValidf("queue name"), ErrCodeInvalidRequest, 400, false},
+		{errors.NotSupportedf("delay"), ErrCodeNotSupported, 501, false},
+		{errors.Unauthorizedf("invalid token"), ErrCodeUnauthorized, 401, false},
+		{ErrPermissionDenied, ErrCodeForbidden, 403, false},
+		{errors.Trace(&ThrottledError{Target: "q1"}), ErrCodeThrottled, 429, true},
+		{&QuotaExceededError{Queue: "q1"}, ErrCodeQuotaExceeded, 429, false},
+		{&MessageTooLargeError{Queue: "q1"}, ErrCodeMessageTooLarge, 413, false},
+		{errors.Annotatef(ErrGroupPaused, "queue %q", "q1"), ErrCodeGroupPaused, 409, true},
+		{kafka.ErrNoPartition, ErrCodeUnassigned, 409, true},
+		{kafka.ErrTimeout, ErrCodeNoMessage, 404, true},
+		{&CircuitOpenError{Target: "SET"}, ErrCodeKafkaUnavailable, 503, true},
+		{errors.Trace(sarama.ErrOutOfBrokers), ErrCodeKafkaUnavailable, 503, true},
+		{sarama.ErrNotLeaderForPartition, ErrCodeKafkaUnavailable, 503, true},
+		{errors.Trace(ErrOverloaded), ErrCodeOverloaded, 503, true},
+		{ErrDropped, ErrCodeDropped, 503, false},
+		{errors.New("unknown"), ErrCodeInternal, 500, false},
+	} {
+		code := ErrorCodeOf(c.err)
+		if code != c.code || code.Status() != c.status || code.Retryable() != c.retryable {
+			t.Errorf("%v: expect %s %d %v, got %s %d %v", c.err, c.code, c.status, c.retryable,
+				code, code.Status(), code.Retryable())
+		}
+	}
+}
+
+func TestErrorCodeOfStatus(t *testing.T) {
+	for status, code := range map[int]ErrorCode{
+		400: ErrCodeInvalidRequest,
+		405: ErrCodeInvalidRequest,
+		404: ErrCodeNotFound,
+		429: ErrCodeThrottled,
+		500: ErrCodeInternal,
+		502: ErrCodeInternal,
+		503: ErrCodeKafkaUnavailable,
+	} {
+		if got := ErrorCodeOfStatus(status); got != code {
+			t.Errorf("status %d: expect %s, got %s", status, code, got)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+const (
+	defaultFailoverIdc      = "standby"
+	defaultFailoverFailures = 10
+	defaultFailoverSustain  = 30 * time.Second
+	defaultFailoverRecover  = 60 * time.Second
+	failoverProbeInterval   = 5 * time.Second
+)
+
+// 切换状态, 同时作为metrics中Failover gauge的值
+const (
+	failoverPrimary int64 = iota
+	failoverStandby
+)
+
+// 本机房kafka持续写入失败时切换到standby集群写入, 主集群恢复后切回. nil表示不切换.
+// 连续失败failures次且持续sustain后切换, 切换后每隔failoverProbeInterval探测主集群,
+// 持续正常recover后切回
+type producerFailover struct {
+	brokers  []string
+	idc      string
+	failures int
+	sustain  time.Duration
+	recover  time.Duration
+	now      func() time.Time
+	probe    func() error
+	create   func(brokers []string) (*kafka.Producer, error)
+
+	mu           sync.Mutex
+	state        int64
+	count        int
+	firstFailure time.Time
+	healthySince time.Time
+	switching    bool
+	standby      *kafka.Producer
+}
+
+// failover段是可选的, 默认不开启
+func newProducerFailover(conf *config.Config, clusterConfig *sarama.Config, probe func() error) (*producerFailover, error) {
+	section, err := conf.GetSection("failover")
+	if err != nil || !section.GetBoolMust("enable", false) {
+		return nil, nil
+	}
+
+	// standby集群可以通过failover.sasl.*使用不同的认证
+	standbyConfig, err := clusterSASLConfig(section, "failover", "", clusterConfig)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	f := &producerFailover{
+		idc:      section.GetStringMust("idc", defaultFailoverIdc),
+		failures: int(section.GetInt64Must("failures", defaultFailoverFailures)),
+		sustain:  defaultFailoverSustain,
+		recover:  defaultFailoverRecover,
+		now:      time.Now,
+		probe:    probe,
+		create: func(brokers []string) (*kafka.Producer, error) {
+			return kafka.NewProducer(brokers, standbyConfig)
+		},
+	}
+	for _, addr := range strings.Split(section.GetStringMust("brokers", ""), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			f.brokers = append(f.brokers, addr)
+		}
+	}
+	if len(f.brokers) == 0 {
+		return nil, errors.NotValidf("failover.brokers is empty")
+	}
+	if f.failures < 1 {
+		return nil, errors.NotValidf("failover.failures %d", f.failures)
+	}
+	for key, d := range map[string]*time.Duration{"sustain": &f.sustain, "recover": &f.recover} {
+		if value := section.GetStringMust(key, ""); value != "" {
+			if *d, err = time.ParseDuration(value); err != nil || *d < 0 {
+				return nil, errors.NotValidf("failover.%s %q", key, value)
+			}
+		}
+	}
+	metrics.AddGauge(metrics.Failover, failoverPrimary)
+	log.Infof("producer failover to %v(idc %s) enabled", f.brokers, f.idc)
+	return f, nil
+}
+
+// 返回本次发送使用的producer和消息id中的idc, 切换后为standby集群
+func (f *producerFailover) pick(primary *kafka.Producer, idc string) (*kafka.Producer, string) {
+	if f == nil {
+		return primary, idc
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.state == failoverStandby {
+		return f.standby, f.idc
+	}
+	return primary, idc
+}
+
+// 只统计主集群的结果, standby集群的失败不会切回
+func (f *producerFailover) success(used *kafka.Producer) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	if used != f.standby {
+		f.count = 0
+	}
+	f.mu.Unlock()
+}
+
+func (f *producerFailover) failure(used *kafka.Producer, err error) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if used == f.standby || f.state == failoverStandby || f.switching {
+		return
+	}
+
+	now := f.now()
+	if f.count == 0 {
+		f.firstFailure = now
+	}
+	f.count++
+	if f.count < f.failures || now.Sub(f.firstFailure) < f.sustain {
+		return
+	}
+	f.switching = true
+	log.Warnf("kafka produce failed %d times in %v, failover to %v, last error: %v",
+		f.count, now.Sub(f.firstFailure), f.brokers, err)
+	go f.switchOver()
+}
+
+// 创建standby集群的producer可能很慢, 不阻塞发送
+func (f *producerFailover) switchOver() {
+	f.mu.Lock()
+	standby := f.standby
+	f.mu.Unlock()
+
+	var err error
+	if standby == nil {
+		standby, err = f.create(f.brokers)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.switching = false
+	if err != nil {
+		// 下一次失败时重试
+		log.Errorf("failover producer to %v error: %s", f.brokers, err)
+		return
+	}
+	f.standby = standby
+	f.count = 0
+	f.healthySince = time.Time{}
+	f.setState(failoverStandby)
+	metrics.AddCounter(metrics.Failover+".Switched", 1)
+	log.Warnf("producer failed over to %v", f.brokers)
+}
+
+// 切换后探测主集群, 持续正常recover后切回
+func (f *producerFailover) check() {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	state := f.state
+	f.mu.Unlock()
+	if state != failoverStandby {
+		return
+	}
+
+	err := f.probe()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err != nil {
+		f.healthySince = time.Time{}
+		log.Debugf("probe primary kafka error: %s", err)
+		return
+	}
+	now := f.now()
+	if f.healthySince.IsZero() {
+		f.healthySince = now
+	}
+	if now.Sub(f.healthySince) < f.recover {
+		return
+	}
+	f.setState(failoverPrimary)
+	metrics.AddCounter(metrics.Failover+".Recovered", 1)
+	log.Warnf("primary kafka healthy for %v, producer failed back", now.Sub(f.healthySince))
+}
+
+func (f *producerFailover) run(dying <-chan struct{}) {
+	if f == nil {
+		return
+	}
+	ticker := time.NewTicker(failoverProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.check()
+		case <-dying:
+			return
+		}
+	}
+}
+
+func (f *producerFailover) setState(state int64) {
+	f.state = state
+	metrics.AddGauge(metrics.Failover, state)
+}
+
+func (f *producerFailover) close() {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.standby != nil {
+		if err := f.standby.Close(); err != nil {
+			log.Errorf("close failover producer err: %s", err)
+		}
+	}
+}
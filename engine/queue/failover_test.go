@@ -0,0 +1,128 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/engine/kafka"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+func testFailover(t *testing.T, extra string) (*producerFailover, error) {
+	conf, err := config.NewConfigFromBytes([]byte(testAlertBaseConfig + extra))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newProducerFailover(conf, sarama.NewConfig(), func() error { return nil })
+}
+
+func TestFailoverConfig(t *testing.T) {
+	if f, err := testFailover(t, "failover.brokers=standby:9092\n"); f != nil || err != nil {
+		t.Errorf("failover should be disabled by default: %v %v", f, err)
+	}
+	if _, err := testFailover(t, "failover.enable=true\n"); !errors.IsNotValid(err) {
+		t.Errorf("expect not valid without brokers, got %v", err)
+	}
+	if _, err := testFailover(t, "failover.enable=true\nfailover.brokers=standby:9092\nfailover.recover=1\n"); !errors.IsNotValid(err) {
+		t.Errorf("expect not valid recover, got %v", err)
+	}
+	f, err := testFailover(t, "failover.enable=true\nfailover.brokers=s1:9092, s2:9092\nfailover.sustain=1s\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.brokers) != 2 || f.brokers[1] != "s2:9092" || f.idc != defaultFailoverIdc || f.sustain != time.Second {
+		t.Errorf("failover config: %+v", f)
+	}
+}
+
+func TestFailoverSwitch(t *testing.T) {
+	f, err := testFailover(t, "failover.enable=true\nfailover.brokers=standby:9092\nfailover.failures=3\nfailover.sustain=10s\nfailover.recover=20s\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	f.now = func() time.Time { return now }
+	standby := &kafka.Producer{}
+	f.create = func([]string) (*kafka.Producer, error) { return standby, nil }
+	probeErr := errors.New("primary down")
+	f.probe = func() error { return probeErr }
+
+	primary := &kafka.Producer{}
+	for i := 0; i < 5; i++ {
+		f.failure(primary, errors.New("send error"))
+	}
+	// 失败次数足够, 但持续时间不够
+	if p, idc := f.pick(primary, "local"); p != primary || idc != "local" {
+		t.Fatalf("should not failover before sustain")
+	}
+	f.success(primary)
+	now = now.Add(20 * time.Second)
+	f.failure(primary, errors.New("send error"))
+	if f.count != 1 {
+		t.Fatalf("success should reset failures, got %d", f.count)
+	}
+
+	now = now.Add(10 * time.Second)
+	f.failure(primary, errors.New("send error"))
+	f.failure(primary, errors.New("send error"))
+	for i := 0; i < 100; i++ {
+		if p, _ := f.pick(primary, "local"); p == standby {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if p, idc := f.pick(primary, "local"); p != standby || idc != defaultFailoverIdc {
+		t.Fatalf("should failover to standby")
+	}
+
+	// standby的失败不影响切换状态
+	f.failure(standby, errors.New("standby error"))
+	f.check()
+	if p, _ := f.pick(primary, "local"); p != standby {
+		t.Fatalf("should stay on standby while primary is down")
+	}
+
+	probeErr = nil
+	f.check()
+	now = now.Add(10 * time.Second)
+	f.check()
+	if p, _ := f.pick(primary, "local"); p != standby {
+		t.Fatalf("should stay on standby before recover")
+	}
+	now = now.Add(10 * time.Second)
+	f.check()
+	if p, idc := f.pick(primary, "local"); p != primary || idc != "local" {
+		t.Fatalf("should fail back to primary")
+	}
+}
+
+func TestFailoverNil(t *testing.T) {
+	var f *producerFailover
+	primary := &kafka.Producer{}
+	if p, idc := f.pick(primary, "local"); p != primary || idc != "local" {
+		t.Error("nil failover should use primary")
+	}
+	f.failure(primary, errors.New("send error"))
+	f.success(primary)
+	f.check()
+	f.close()
+}
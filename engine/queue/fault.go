@@ -0,0 +1,254 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/engine/zookeeper"
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/juju/errors"
+)
+
+// 注入的发送失败, 错误码与kafka不可用相同, 客户端应该重试
+var ErrFaultInjected = errors.New("fault injected")
+
+func IsFaultInjected(err error) bool {
+	return errors.Cause(err) == ErrFaultInjected
+}
+
+// 故障注入的配置, 概率为0~1, 全部为0时不注入任何故障
+type FaultConfig struct {
+	ProduceErrorRate float64 `json:"produce_error_rate"`
+	LatencyMs        int64   `json:"latency_ms"`
+	LatencyRate      float64 `json:"latency_rate"`
+	AckDropRate      float64 `json:"ack_drop_rate"`
+	ZkExpireSeconds  int64   `json:"zk_expire_seconds"`
+}
+
+func (c *FaultConfig) valid() error {
+	for name, rate := range map[string]float64{
+		"produce_error_rate": c.ProduceErrorRate,
+		"latency_rate":       c.LatencyRate,
+		"ack_drop_rate":      c.AckDropRate,
+	} {
+		if rate < 0 || rate > 1 {
+			return errors.NotValidf("%s : %v, expect 0~1", name, rate)
+		}
+	}
+	if c.LatencyMs < 0 || c.ZkExpireSeconds < 0 {
+		return errors.NotValidf("latency_ms : %d, zk_expire_seconds : %d", c.LatencyMs, c.ZkExpireSeconds)
+	}
+	return nil
+}
+
+// 已经注入的各种故障的次数
+type FaultStatus struct {
+	Config        FaultConfig `json:"config"`
+	ProduceErrors int64       `json:"produce_errors"`
+	Delays        int64       `json:"delays"`
+	DroppedAcks   int64       `json:"dropped_acks"`
+	ZkExpires     int64       `json:"zk_expires"`
+}
+
+// 只用于测试环境验证客户端的重试和proxy的恢复, fault.enable=false时不注入任何故障, 也不能通过接口修改
+type faultInjector struct {
+	enable bool
+
+	mu         sync.RWMutex
+	config     FaultConfig
+	lastExpire time.Time
+
+	produceErrors int64
+	delays        int64
+	droppedAcks   int64
+	zkExpires     int64
+}
+
+func newFaultInjector(conf *config.Config) (*faultInjector, error) {
+	f := &faultInjector{lastExpire: time.Now()}
+	section, err := conf.GetSection("fault")
+	if err != nil {
+		return f, nil
+	}
+	f.enable = section.GetBoolMust("enable", false)
+	f.config = FaultConfig{
+		ProduceErrorRate: section.GetFloat64Must("produce.error.rate", 0),
+		LatencyMs:        section.GetInt64Must("latency.ms", 0),
+		LatencyRate:      section.GetFloat64Must("latency.rate", 0),
+		AckDropRate:      section.GetFloat64Must("ack.drop.rate", 0),
+		ZkExpireSeconds:  section.GetInt64Must("zk.expire.seconds", 0),
+	}
+	if err := f.config.valid(); err != nil {
+		return nil, errors.Annotatef(err, "fault")
+	}
+	if f.enable {
+		log.Warnf("fault injection enabled: %+v", f.config)
+	}
+	return f, nil
+}
+
+func (f *faultInjector) current() FaultConfig {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.config
+}
+
+func hit(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// 按概率决定本次发送是否失败, 失败时返回ErrFaultInjected
+func (f *faultInjector) failProduce() bool {
+	if f == nil || !f.enable || !hit(f.current().ProduceErrorRate) {
+		return false
+	}
+	atomic.AddInt64(&f.produceErrors, 1)
+	metrics.AddCounter(metrics.Fault+"."+metrics.CmdSetError, 1)
+	return true
+}
+
+// 按概率增加发送和接收的延迟, ctx取消时提前返回
+func (f *faultInjector) delay(ctx context.Context) {
+	if f == nil || !f.enable {
+		return
+	}
+	config := f.current()
+	if config.LatencyMs <= 0 || !hit(config.LatencyRate) {
+		return
+	}
+	atomic.AddInt64(&f.delays, 1)
+	metrics.AddCounter(metrics.Fault+"."+metrics.Latency, 1)
+	timer := time.NewTimer(time.Duration(config.LatencyMs) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// 丢弃的ACK返回成功但不提交, 消息在ACK超时后重新投递
+func (f *faultInjector) dropAck() bool {
+	if f == nil || !f.enable || !hit(f.current().AckDropRate) {
+		return false
+	}
+	atomic.AddInt64(&f.droppedAcks, 1)
+	metrics.AddCounter(metrics.Fault+"."+metrics.CmdAck+"."+metrics.Dropped, 1)
+	return true
+}
+
+// 是否到了定期模拟zookeeper会话过期的时间
+func (f *faultInjector) expireDue(now time.Time) bool {
+	if f == nil || !f.enable {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	interval := time.Duration(f.config.ZkExpireSeconds) * time.Second
+	if interval <= 0 || now.Sub(f.lastExpire) < interval {
+		return false
+	}
+	f.lastExpire = now
+	return true
+}
+
+func (f *faultInjector) set(config FaultConfig) error {
+	if f == nil || !f.enable {
+		return errors.NotSupportedf("fault injection")
+	}
+	if err := config.valid(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.config = config
+	f.mu.Unlock()
+	log.Warnf("fault injection config: %+v", config)
+	return nil
+}
+
+func (f *faultInjector) status() (*FaultStatus, error) {
+	if f == nil || !f.enable {
+		return nil, errors.NotSupportedf("fault injection")
+	}
+	return &FaultStatus{
+		Config:        f.current(),
+		ProduceErrors: atomic.LoadInt64(&f.produceErrors),
+		Delays:        atomic.LoadInt64(&f.delays),
+		DroppedAcks:   atomic.LoadInt64(&f.droppedAcks),
+		ZkExpires:     atomic.LoadInt64(&f.zkExpires),
+	}, nil
+}
+
+// 模拟会话过期: 删除本proxy的临时节点(注册的服务和持有的leader节点), 由定期检查重新注册, 其他实例重新竞选.
+// 不会真正断开连接, 使用zookeeper的consumer等其他组件不受影响
+func (m *Metadata) simulateSessionExpiry() error {
+	path := fmt.Sprintf("%s/%d", m.servicePath, m.id)
+	if err := m.zkConn.Delete(path); err != nil && !zookeeper.IsNoNode(err) {
+		return errors.Annotatef(err, "delete %s", path)
+	}
+	data, _, err := m.zkConn.Get(m.leaderPath)
+	if err != nil && !zookeeper.IsNoNode(err) {
+		return errors.Trace(err)
+	}
+	if err == nil && string(data) == strconv.Itoa(m.id) {
+		if err := m.zkConn.Delete(m.leaderPath); err != nil && !zookeeper.IsNoNode(err) {
+			return errors.Annotatef(err, "delete %s", m.leaderPath)
+		}
+	}
+	log.Warnf("fault injection: simulated zookeeper session expiry of proxy %d", m.id)
+	return nil
+}
+
+// 立即模拟一次zookeeper会话过期
+func (q *queueImp) ExpireSession() error {
+	if q.faults == nil || !q.faults.enable {
+		return errors.NotSupportedf("fault injection")
+	}
+	if err := q.metadata.simulateSessionExpiry(); err != nil {
+		return err
+	}
+	atomic.AddInt64(&q.faults.zkExpires, 1)
+	metrics.AddCounter(metrics.Fault+"."+metrics.Expired, 1)
+	return nil
+}
+
+func (q *queueImp) SetFaults(config FaultConfig) error {
+	return q.faults.set(config)
+}
+
+func (q *queueImp) Faults() (*FaultStatus, error) {
+	return q.faults.status()
+}
+
+// 在monitoring中调用
+func (q *queueImp) injectFaults(now time.Time) {
+	if !q.faults.expireDue(now) {
+		return
+	}
+	if err := q.ExpireSession(); err != nil {
+		log.Warnf("simulate zookeeper session expiry error: %s", err)
+	}
+}
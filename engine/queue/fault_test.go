@@ -0,0 +1,104 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/juju/errors"
+)
+
+func TestFaultInjectorDisabled(t *testing.T) {
+	conf, err := config.NewConfigFromBytes([]byte(testAlertBaseConfig + "fault.produce.error.rate=1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := newFaultInjector(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.failProduce() || f.dropAck() || f.expireDue(time.Now().Add(time.Hour)) {
+		t.Error("disabled injector should not inject faults")
+	}
+	if err := f.set(FaultConfig{}); !errors.IsNotSupported(err) {
+		t.Errorf("set faults when disabled err: %v", err)
+	}
+
+	var nilInjector *faultInjector
+	nilInjector.delay(context.Background())
+	if nilInjector.failProduce() {
+		t.Error("nil injector should not inject faults")
+	}
+}
+
+func TestFaultInjector(t *testing.T) {
+	conf, err := config.NewConfigFromBytes([]byte(testAlertBaseConfig +
+		"fault.enable=true\nfault.produce.error.rate=1\nfault.ack.drop.rate=0\nfault.zk.expire.seconds=60\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := newFaultInjector(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.failProduce() || f.dropAck() {
+		t.Error("expect produce failure and no dropped ack")
+	}
+	now := time.Now()
+	if f.expireDue(now) || !f.expireDue(now.Add(time.Minute)) || f.expireDue(now.Add(time.Minute+time.Second)) {
+		t.Error("zk expiry should be due once per interval")
+	}
+
+	if err := f.set(FaultConfig{AckDropRate: 1.5}); !errors.IsNotValid(err) {
+		t.Errorf("set invalid rate err: %v", err)
+	}
+	if err := f.set(FaultConfig{AckDropRate: 1, LatencyMs: 1000, LatencyRate: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if f.failProduce() || !f.dropAck() {
+		t.Error("expect dropped ack and no produce failure")
+	}
+
+	// ctx取消时不等待注入的延迟
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	f.delay(ctx)
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("delay should return when ctx done")
+	}
+
+	status, err := f.status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.ProduceErrors != 1 || status.DroppedAcks != 1 || status.Delays != 1 {
+		t.Errorf("status: %+v", status)
+	}
+	if ErrorCodeOf(ErrFaultInjected) != ErrCodeKafkaUnavailable {
+		t.Error("injected produce failure should be retryable")
+	}
+
+	conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig + "fault.latency.rate=2\n"))
+	if _, err := newFaultInjector(conf); !errors.IsNotValid(err) {
+		t.Errorf("invalid config err: %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/juju/errors"
+)
+
+const (
+	groupURLVerifyTimeout = 3 * time.Second
+	// 读取验证响应的最大字节数
+	maxVerifyResponse = 4096
+)
+
+// 只填域名的旧配置, 可以带端口
+var validGroupHost = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9\-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9\-]*[a-zA-Z0-9])?)*(:[0-9]{1,5})?$`)
+
+// 检查group的url, 可以为空、域名或者http(s)地址, 返回是否为需要验证的推送地址
+func parseGroupURL(raw string) (bool, error) {
+	if raw == "" {
+		return false, nil
+	}
+	if !strings.Contains(raw, "://") {
+		if !validGroupHost.MatchString(raw) {
+			return false, errors.NotValidf("group url %q", raw)
+		}
+		return false, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return false, errors.NotValidf("group url %q, expect http(s)://host/path", raw)
+	}
+	return true, nil
+}
+
+// 验证请求的内容, 推送地址需要在2xx响应中原样返回challenge, 可以是纯文本或者{"challenge":"..."}
+type urlChallenge struct {
+	Type      string `json:"type"`
+	Group     string `json:"group,omitempty"`
+	Queue     string `json:"queue,omitempty"`
+	Challenge string `json:"challenge"`
+}
+
+// 配置推送地址时发送验证请求, 避免错误的地址吞掉消息. group段是可选的, group.url.verify=false时不发送验证请求,
+// 直接视为通过
+type urlVerifier struct {
+	enable bool
+	client *http.Client
+}
+
+func newURLVerifier(conf *config.Config) *urlVerifier {
+	v := &urlVerifier{
+		enable: true,
+		client: &http.Client{Timeout: groupURLVerifyTimeout},
+	}
+	if section, err := conf.GetSection("group"); err == nil {
+		v.enable = section.GetBoolMust("url.verify", true)
+	}
+	return v
+}
+
+// 检查url的格式, 推送地址需要通过验证, 返回是否可以用于推送
+func (v *urlVerifier) check(group string, queue string, raw string) (bool, error) {
+	push, err := parseGroupURL(raw)
+	if err != nil || !push {
+		return false, err
+	}
+	if v == nil || !v.enable {
+		return true, nil
+	}
+	if err = v.verify(group, queue, raw); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (v *urlVerifier) verify(group string, queue string, raw string) error {
+	challenge, err := genToken()
+	if err != nil {
+		return err
+	}
+	data, _ := json.Marshal(&urlChallenge{Type: "url_verification", Group: group, Queue: queue, Challenge: challenge})
+
+	resp, err := v.client.Post(raw, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return errors.NewNotValid(err, "verify group url "+raw)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.NotValidf("verify group url %q response status %s", raw, resp.Status)
+	}
+	body, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: maxVerifyResponse})
+	if err != nil {
+		return errors.NewNotValid(err, "verify group url "+raw)
+	}
+	if echoed(body, challenge) {
+		return nil
+	}
+	return errors.NotValidf("verify group url %q challenge mismatch", raw)
+}
+
+func echoed(body []byte, challenge string) bool {
+	if strings.TrimSpace(string(body)) == challenge {
+		return true
+	}
+	reply := &urlChallenge{}
+	return json.Unmarshal(body, reply) == nil && reply.Challenge == challenge
+}
+
+// 重新发送验证请求, 推送地址修复后使用
+func (q *queueImp) VerifyGroupURL(group string, queue string) error {
+
+	config, err := q.metadata.GetGroupConfig(group, queue)
+	if err != nil {
+		return errors.NotFoundf("queue : %q , group: %q", queue, group)
+	}
+	push, err := parseGroupURL(config.Url)
+	if err != nil {
+		return err
+	}
+	if !push {
+		return errors.NotValidf("group url %q is not a push url", config.Url)
+	}
+	if err = q.urls.verify(group, queue, config.Url); err != nil {
+		return err
+	}
+
+	return q.metadata.ModifyGroupConfig(group, queue, func(c *GroupConfig) error {
+		if c.Url != config.Url {
+			return errors.NotValidf("group url changed to %q during verification", c.Url)
+		}
+		c.Verified = true
+		return nil
+	})
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+type headersKey struct{}
+
+// 发送消息时附带的header, 写入kafka消息的header中, 用于group按header过滤
+func WithHeaders(ctx context.Context, headers map[string]string) context.Context {
+	return context.WithValue(ctx, headersKey{}, headers)
+}
+
+func headersFrom(ctx context.Context) map[string]string {
+	headers, _ := ctx.Value(headersKey{}).(map[string]string)
+	return headers
+}
+
+// 单个条件: key=v1|v2 header等于任一值, key!=v1|v2 header不存在或不等于任一值, key header存在
+type headerMatcher struct {
+	key    string
+	values []string
+	negate bool
+}
+
+func (m headerMatcher) match(headers map[string]string) bool {
+	value, ok := headers[m.key]
+	if m.values == nil {
+		return ok
+	}
+	hit := false
+	if ok {
+		for _, v := range m.values {
+			if v == value {
+				hit = true
+				break
+			}
+		}
+	}
+	return hit != m.negate
+}
+
+// group的过滤表达式, 逗号分隔的条件全部满足时消息才投递, eg: type=order|refund,region!=test
+type headerFilter []headerMatcher
+
+func parseHeaderFilter(expr string) (headerFilter, error) {
+
+	filter := make(headerFilter, 0)
+	for _, clause := range strings.Split(expr, ",") {
+		clause = strings.TrimSpace(clause)
+		if clause == "" {
+			continue
+		}
+
+		m := headerMatcher{key: clause}
+		if i := strings.Index(clause, "="); i >= 0 {
+			m.key, m.values = clause[:i], strings.Split(clause[i+1:], "|")
+			if strings.HasSuffix(m.key, "!") {
+				m.key, m.negate = m.key[:len(m.key)-1], true
+			}
+		}
+		m.key = strings.TrimSpace(m.key)
+		if m.key == "" || strings.ContainsAny(m.key, "!|") {
+			return nil, errors.NotValidf("filter clause %q", clause)
+		}
+		for i := range m.values {
+			m.values[i] = strings.TrimSpace(m.values[i])
+		}
+		filter = append(filter, m)
+	}
+	if len(filter) == 0 {
+		return nil, errors.NotValidf("empty filter %q", expr)
+	}
+	return filter, nil
+}
+
+func (f headerFilter) match(headers map[string]string) bool {
+	for _, m := range f {
+		if !m.match(headers) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+func TestHeaderFilter(t *testing.T) {
+	filter, err := parseHeaderFilter("type=order|refund, region!=test,vip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		headers map[string]string
+		match   bool
+	}{
+		{map[string]string{"type": "order", "vip": ""}, true},
+		{map[string]string{"type": "refund", "region": "bj", "vip": "1"}, true},
+		{map[string]string{"type": "refund", "region": "test", "vip": "1"}, false},
+		{map[string]string{"type": "pay", "vip": "1"}, false},
+		{map[string]string{"type": "order"}, false},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if match := filter.match(c.headers); match != c.match {
+			t.Errorf("headers %v expect match %v, got %v", c.headers, c.match, match)
+		}
+	}
+
+	for _, expr := range []string{"", " , ", "=order", "!=x", "a|b=c"} {
+		if _, err := parseHeaderFilter(expr); !errors.IsNotValid(err) {
+			t.Errorf("expect filter %q rejected, got %v", expr, err)
+		}
+	}
+}
+
+func TestWithHeaders(t *testing.T) {
+	if headers := headersFrom(context.Background()); headers != nil {
+		t.Errorf("expect no headers, got %v", headers)
+	}
+	ctx := WithHeaders(context.Background(), map[string]string{"type": "order"})
+	if headers := headersFrom(ctx); headers["type"] != "order" {
+		t.Errorf("expect headers from context, got %v", headers)
+	}
+}
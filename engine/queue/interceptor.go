@@ -0,0 +1,169 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+var (
+	// 接收拦截器返回ErrSkipMessage时ACK并跳过该消息, 用于采样和过滤
+	ErrSkipMessage = errors.New("skip message")
+)
+
+// 数据通路上的消息, 拦截器可以修改Data, Flag和Headers
+type Message struct {
+	Queue   string
+	Group   string
+	ID      string // 只在接收时有效
+	Data    []byte
+	Flag    uint64
+	Headers map[string]string
+}
+
+// 发送前按配置的顺序执行, 返回错误时拒绝发送并把错误返回给客户端
+type SendInterceptor interface {
+	BeforeSend(ctx context.Context, msg *Message) error
+}
+
+// 接收后按配置的顺序执行, 返回ErrSkipMessage以外的错误时消息不ACK, 超时后重新投递
+type ReceiveInterceptor interface {
+	AfterReceive(ctx context.Context, msg *Message) error
+}
+
+type SendInterceptorFactory func(conf *config.Config) (SendInterceptor, error)
+type ReceiveInterceptorFactory func(conf *config.Config) (ReceiveInterceptor, error)
+
+var (
+	interceptorMu sync.RWMutex
+	sendFactories = make(map[string]SendInterceptorFactory)
+	recvFactories = make(map[string]ReceiveInterceptorFactory)
+)
+
+// 插件在init中注册, 通过interceptor.send配置启用, 重复注册时后者覆盖前者
+func RegisterSendInterceptor(name string, factory SendInterceptorFactory) {
+	interceptorMu.Lock()
+	sendFactories[name] = factory
+	interceptorMu.Unlock()
+}
+
+// 插件在init中注册, 通过interceptor.receive配置启用, 重复注册时后者覆盖前者
+func RegisterReceiveInterceptor(name string, factory ReceiveInterceptorFactory) {
+	interceptorMu.Lock()
+	recvFactories[name] = factory
+	interceptorMu.Unlock()
+}
+
+type interceptorChain struct {
+	send []SendInterceptor
+	recv []ReceiveInterceptor
+}
+
+// interceptor段是可选的, send和receive为逗号分隔的拦截器名字, 按顺序执行
+func newInterceptorChain(conf *config.Config) (*interceptorChain, error) {
+
+	chain := &interceptorChain{}
+	section, err := conf.GetSection("interceptor")
+	if err != nil {
+		return chain, nil
+	}
+
+	interceptorMu.RLock()
+	defer interceptorMu.RUnlock()
+
+	for _, name := range splitNames(section.GetStringMust("send", "")) {
+		factory, ok := sendFactories[name]
+		if !ok {
+			return nil, errors.NotFoundf("send interceptor %q", name)
+		}
+		interceptor, err := factory(conf)
+		if err != nil {
+			return nil, errors.Annotatef(err, "send interceptor %q", name)
+		}
+		chain.send = append(chain.send, interceptor)
+	}
+	for _, name := range splitNames(section.GetStringMust("receive", "")) {
+		factory, ok := recvFactories[name]
+		if !ok {
+			return nil, errors.NotFoundf("receive interceptor %q", name)
+		}
+		interceptor, err := factory(conf)
+		if err != nil {
+			return nil, errors.Annotatef(err, "receive interceptor %q", name)
+		}
+		chain.recv = append(chain.recv, interceptor)
+	}
+	return chain, nil
+}
+
+func splitNames(s string) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (c *interceptorChain) beforeSend(ctx context.Context, msg *Message) error {
+	for _, interceptor := range c.send {
+		if err := interceptor.BeforeSend(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *interceptorChain) afterReceive(ctx context.Context, msg *Message) error {
+	for _, interceptor := range c.recv {
+		if err := interceptor.AfterReceive(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *interceptorChain) hasReceive() bool {
+	return len(c.recv) != 0
+}
+
+// 拦截器添加的header与trace的header一起写入kafka
+func recordHeaders(headers map[string]string) []sarama.RecordHeader {
+	records := make([]sarama.RecordHeader, 0, len(headers))
+	for k, v := range headers {
+		records = append(records, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	return records
+}
+
+func messageHeaders(records []*sarama.RecordHeader) map[string]string {
+	headers := make(map[string]string, len(records))
+	for _, h := range records {
+		if h != nil {
+			headers[string(h.Key)] = string(h.Value)
+		}
+	}
+	return headers
+}
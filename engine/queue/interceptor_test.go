@@ -0,0 +1,104 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+type tagInterceptor struct {
+	tag string
+}
+
+func (i *tagInterceptor) BeforeSend(ctx context.Context, msg *Message) error {
+	if len(msg.Data) == 0 {
+		return errors.NotValidf("empty message")
+	}
+	msg.Data = append(msg.Data, i.tag...)
+	if msg.Headers == nil {
+		msg.Headers = make(map[string]string)
+	}
+	msg.Headers["via"] += i.tag
+	return nil
+}
+
+func (i *tagInterceptor) AfterReceive(ctx context.Context, msg *Message) error {
+	if msg.Headers["skip"] == "true" {
+		return ErrSkipMessage
+	}
+	msg.Data = append(msg.Data, i.tag...)
+	return nil
+}
+
+func TestInterceptorChain(t *testing.T) {
+	for _, tag := range []string{"a", "b"} {
+		tag := tag
+		RegisterSendInterceptor("test_"+tag, func(conf *config.Config) (SendInterceptor, error) {
+			return &tagInterceptor{tag: tag}, nil
+		})
+		RegisterReceiveInterceptor("test_"+tag, func(conf *config.Config) (ReceiveInterceptor, error) {
+			return &tagInterceptor{tag: tag}, nil
+		})
+	}
+
+	conf, err := config.NewConfigFromBytes([]byte(testAlertBaseConfig +
+		"interceptor.send=test_a, test_b\ninterceptor.receive=test_b\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := newInterceptorChain(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &Message{Queue: "q", Group: "g", Data: []byte("x")}
+	if err := chain.beforeSend(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Data) != "xab" || msg.Headers["via"] != "ab" {
+		t.Errorf("interceptors should run in order, got data %q headers %v", msg.Data, msg.Headers)
+	}
+	if err := chain.beforeSend(context.Background(), &Message{}); !errors.IsNotValid(err) {
+		t.Errorf("expect send rejected, got %v", err)
+	}
+
+	headers := messageHeaders([]*sarama.RecordHeader{{Key: []byte("skip"), Value: []byte("true")}})
+	if err := chain.afterReceive(context.Background(), &Message{Headers: headers}); err != ErrSkipMessage {
+		t.Errorf("expect message skipped, got %v", err)
+	}
+	msg = &Message{Data: []byte("x")}
+	if err := chain.afterReceive(context.Background(), msg); err != nil || string(msg.Data) != "xb" {
+		t.Errorf("expect data %q, got %q err %v", "xb", msg.Data, err)
+	}
+
+	conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig + "interceptor.send=unknown\n"))
+	if _, err := newInterceptorChain(conf); !errors.IsNotFound(err) || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("expect unknown interceptor rejected, got %v", err)
+	}
+
+	conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig))
+	if chain, err = newInterceptorChain(conf); err != nil || chain.hasReceive() {
+		t.Errorf("expect empty chain without interceptor section, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/log"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+const (
+	ISRGuardOff = "off"
+	// 只记录UnderISR指标, 继续发送
+	ISRGuardWarn = "warn"
+	// 拒绝发送, 客户端收到可重试的错误
+	ISRGuardReject = "reject"
+
+	// 检查结果的缓存时间, isr来自sarama缓存的metadata, 随metadata刷新
+	isrGuardTTL = time.Second
+)
+
+type UnderReplicatedError struct {
+	Queue      string
+	MinISR     int
+	Partitions []int32
+}
+
+func (e *UnderReplicatedError) Error() string {
+	return fmt.Sprintf("queue %q partitions %v have fewer than %d in-sync replicas", e.Queue, e.Partitions, e.MinISR)
+}
+
+func IsUnderReplicated(err error) bool {
+	_, ok := errors.Cause(err).(*UnderReplicatedError)
+	return ok
+}
+
+type isrSource interface {
+	UnderMinISR(topic string, minISR int) ([]int32, error)
+}
+
+type isrState struct {
+	partitions []int32
+	expire     time.Time
+}
+
+// acks=all时kafka在isr少于min.insync.replicas时拒绝写入, 但要等到请求超时或重试结束才返回.
+// 发送前按缓存的metadata检查queue的isr, 可以快速失败或者只记录指标
+type isrGuard struct {
+	mode string
+	// queue的模板没有设置min.insync时使用
+	minISR int
+	source isrSource
+
+	mu     sync.Mutex
+	states map[string]isrState
+}
+
+// 未开启时返回nil
+func newISRGuard(conf *config.Config, cc *sarama.Config, source isrSource) (*isrGuard, error) {
+	section, err := conf.GetSection("kafka")
+	if err != nil {
+		return nil, nil
+	}
+	mode := section.GetStringMust("producer.isr.guard", ISRGuardOff)
+	switch mode {
+	case ISRGuardOff:
+		return nil, nil
+	case ISRGuardWarn, ISRGuardReject:
+	default:
+		return nil, errors.NotValidf("kafka.producer.isr.guard %q", mode)
+	}
+	if cc.Producer.RequiredAcks != sarama.WaitForAll {
+		return nil, errors.NotValidf("kafka.producer.isr.guard without kafka.producer.required.acks=-1")
+	}
+	minISR := section.GetInt64Must("producer.min.isr", 0)
+	if minISR < 0 {
+		return nil, errors.NotValidf("kafka.producer.min.isr %d", minISR)
+	}
+	return &isrGuard{
+		mode:   mode,
+		minISR: int(minISR),
+		source: source,
+		states: make(map[string]isrState),
+	}, nil
+}
+
+// minISR为queue的模板设置的min.insync, 都没有设置时不检查. 查询metadata失败时不影响发送
+func (g *isrGuard) check(queue string, minISR int, now time.Time) error {
+	if g == nil {
+		return nil
+	}
+	if minISR <= 0 {
+		minISR = g.minISR
+	}
+	if minISR <= 0 {
+		return nil
+	}
+
+	g.mu.Lock()
+	state, ok := g.states[queue]
+	g.mu.Unlock()
+	if !ok || now.After(state.expire) {
+		partitions, err := g.source.UnderMinISR(queue, minISR)
+		if err != nil {
+			log.Warnf("check isr of queue %q error: %s", queue, err)
+			return nil
+		}
+		state = isrState{partitions: partitions, expire: now.Add(isrGuardTTL)}
+		g.mu.Lock()
+		g.states[queue] = state
+		g.mu.Unlock()
+	}
+
+	if len(state.partitions) == 0 {
+		return nil
+	}
+	return &UnderReplicatedError{Queue: queue, MinISR: minISR, Partitions: state.partitions}
+}
+
+func (g *isrGuard) rejects() bool {
+	return g != nil && g.mode == ISRGuardReject
+}
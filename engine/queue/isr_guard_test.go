@@ -0,0 +1,112 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/Shopify/sarama"
+)
+
+type testISRSource struct {
+	calls int
+	under map[string][]int32
+	err   error
+}
+
+func (s *testISRSource) UnderMinISR(topic string, minISR int) ([]int32, error) {
+	s.calls++
+	return s.under[topic], s.err
+}
+
+func TestNewISRGuard(t *testing.T) {
+	cc := genClusterConfig("localhost")
+	cc.Producer.RequiredAcks = sarama.WaitForAll
+	for _, c := range []struct {
+		conf    string
+		enabled bool
+		invalid bool
+	}{
+		{"", false, false},
+		{"kafka.producer.isr.guard=off\n", false, false},
+		{"kafka.producer.isr.guard=warn\n", true, false},
+		{"kafka.producer.isr.guard=reject\nkafka.producer.min.isr=2\n", true, false},
+		{"kafka.producer.isr.guard=block\n", false, true},
+		{"kafka.producer.isr.guard=warn\nkafka.producer.min.isr=-1\n", false, true},
+	} {
+		conf, err := config.NewConfigFromBytes([]byte(testAlertBaseConfig + c.conf))
+		if err != nil {
+			t.Fatalf("NewConfigFromBytes err : %s", err)
+		}
+		g, err := newISRGuard(conf, cc, &testISRSource{})
+		if (err != nil) != c.invalid || (g != nil) != c.enabled {
+			t.Errorf("%q: guard %v err %v", c.conf, g, err)
+		}
+	}
+
+	conf, _ := config.NewConfigFromBytes([]byte(testAlertBaseConfig + "kafka.producer.isr.guard=warn\n"))
+	cc.Producer.RequiredAcks = sarama.WaitForLocal
+	if _, err := newISRGuard(conf, cc, &testISRSource{}); err == nil {
+		t.Errorf("guard without acks=all should be invalid")
+	}
+}
+
+func TestISRGuardCheck(t *testing.T) {
+	source := &testISRSource{under: map[string][]int32{"q1": {1}}}
+	g := &isrGuard{mode: ISRGuardReject, source: source, states: make(map[string]isrState)}
+	now := time.Now()
+
+	if err := g.check("q1", 0, now); err != nil || source.calls != 0 {
+		t.Fatalf("queue without min isr should not be checked: %v", err)
+	}
+	err := g.check("q1", 2, now)
+	if !IsUnderReplicated(err) || ErrorCodeOf(err) != ErrCodeKafkaUnavailable {
+		t.Fatalf("check q1 err : %v, want under replicated", err)
+	}
+	if e := err.(*UnderReplicatedError); e.MinISR != 2 || len(e.Partitions) != 1 {
+		t.Errorf("unexpected error %+v", e)
+	}
+	if err := g.check("q2", 2, now); err != nil {
+		t.Errorf("check q2 err : %v", err)
+	}
+
+	// 缓存期内不再查询
+	source.under["q1"] = nil
+	g.check("q1", 2, now.Add(isrGuardTTL/2))
+	if source.calls != 2 {
+		t.Errorf("calls %d, want 2", source.calls)
+	}
+	if err := g.check("q1", 2, now.Add(2*isrGuardTTL)); err != nil || source.calls != 3 {
+		t.Errorf("check q1 after ttl err : %v, calls %d", err, source.calls)
+	}
+
+	// 默认min isr, 查询失败时不影响发送
+	g.minISR = 2
+	source.err = errors.New("no metadata")
+	if err := g.check("q3", 0, now); err != nil || source.calls != 4 {
+		t.Errorf("check q3 err : %v, calls %d", err, source.calls)
+	}
+
+	var nilGuard *isrGuard
+	if err := nilGuard.check("q1", 2, now); err != nil || nilGuard.rejects() {
+		t.Errorf("nil guard should accept all: %v", err)
+	}
+}
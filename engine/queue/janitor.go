@@ -0,0 +1,129 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"sync"
+	"time"
+
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+type activity struct {
+	mark  int64
+	since time.Time
+}
+
+// 记录临时queue最后一次有活动的时间, 写入的消息总数或消费进度变化时视为有活动.
+// 只保存在内存中, proxy重启或leader切换后重新计时, 因此queue至少在最后一次活动expire之后才会被删除
+type janitor struct {
+	mu   sync.Mutex
+	seen map[string]activity
+	now  func() time.Time
+}
+
+func newJanitor() *janitor {
+	return &janitor{
+		seen: make(map[string]activity),
+		now:  time.Now,
+	}
+}
+
+// mark为queue当前的活动标记, 返回queue是否已经超过expire没有活动
+func (j *janitor) expired(queue string, mark int64, expire time.Duration) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.now()
+	a, ok := j.seen[queue]
+	if !ok || a.mark != mark {
+		j.seen[queue] = activity{mark: mark, since: now}
+		return false
+	}
+	return now.Sub(a.since) > expire
+}
+
+// 删除不再存在的queue的记录
+func (j *janitor) retain(queues map[string]bool) {
+	j.mu.Lock()
+	for queue := range j.seen {
+		if !queues[queue] {
+			delete(j.seen, queue)
+		}
+	}
+	j.mu.Unlock()
+}
+
+// 删除超过有效期没有活动的临时queue, 包括其所有group和本机房的topic
+func (q *queueImp) cleanExpiredQueues(accInfos []AccumulationInfo) {
+
+	consumed := make(map[string]int64)
+	for _, info := range accInfos {
+		consumed[info.Queue] += info.Consumed
+	}
+
+	ephemeral := make(map[string]bool)
+	for _, queue := range q.metadata.GetQueues() {
+		config := q.metadata.GetQueueConfig(queue)
+		if config == nil || config.Expire <= 0 {
+			continue
+		}
+		ephemeral[queue] = true
+
+		offsets, err := q.metadata.LocalManager().FetchTopicOffsets(queue, sarama.OffsetNewest)
+		if err != nil {
+			log.Warnf("fetch offsets of ephemeral queue %q error: %s", queue, err)
+			continue
+		}
+		mark := consumed[queue]
+		for _, offset := range offsets {
+			mark += offset
+		}
+		if !q.janitor.expired(queue, mark, time.Duration(config.Expire)*time.Second) {
+			continue
+		}
+
+		if err = q.deleteExpiredQueue(queue, config); err != nil {
+			log.Errorf("delete expired queue %q error: %s", queue, errors.ErrorStack(err))
+			continue
+		}
+		delete(ephemeral, queue)
+		metrics.AddCounter(metrics.QueueExpire, 1)
+		log.Infof("delete queue %q inactive for %ds", queue, config.Expire)
+	}
+	q.janitor.retain(ephemeral)
+}
+
+func (q *queueImp) deleteExpiredQueue(queue string, config *QueueConfig) error {
+	for group := range config.Groups {
+		if err := q.metadata.DeleteGroup(group, queue); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	if err := q.metadata.DelQueue(queue); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	for _, consumer := range q.consumers.removePrefix(queue + "@") {
+		consumer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJanitor(t *testing.T) {
+	now := time.Now()
+	j := newJanitor()
+	j.now = func() time.Time { return now }
+	expire := time.Minute
+
+	if j.expired("q", 10, expire) {
+		t.Fatalf("first seen queue should not expire")
+	}
+	now = now.Add(50 * time.Second)
+	if j.expired("q", 10, expire) {
+		t.Fatalf("queue should not expire before %v", expire)
+	}
+	// 有新的写入或消费时重新计时
+	if j.expired("q", 11, expire) {
+		t.Fatalf("activity should reset timer")
+	}
+	now = now.Add(50 * time.Second)
+	if j.expired("q", 11, expire) {
+		t.Fatalf("queue should not expire within %v after activity", expire)
+	}
+	now = now.Add(20 * time.Second)
+	if !j.expired("q", 11, expire) {
+		t.Fatalf("expect queue expired")
+	}
+
+	j.retain(map[string]bool{})
+	if len(j.seen) != 0 {
+		t.Errorf("deleted queue should be forgotten, got %v", j.seen)
+	}
+}
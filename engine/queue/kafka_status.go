@@ -0,0 +1,67 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"sort"
+
+	"github.com/weibocom/wqs/engine/kafka"
+)
+
+// 一个idc的kafka集群状态, 查询失败时只有Error
+type KafkaStatus struct {
+	Idc   string `json:"idc"`
+	Error string `json:"error,omitempty"`
+	*kafka.ClusterStatus
+}
+
+// 各个idc的kafka中broker、controller和部署在该idc的queue的副本状态
+func (q *queueImp) KafkaStatus() []*KafkaStatus {
+	return q.metadata.KafkaStatus()
+}
+
+func (m *Metadata) KafkaStatus() []*KafkaStatus {
+
+	topics := make(map[string][]string)
+	m.rw.RLock()
+	for queue, config := range m.queueConfigs {
+		for _, idc := range config.Idcs {
+			topics[idc] = append(topics[idc], queue)
+		}
+	}
+	m.rw.RUnlock()
+
+	idcs := make([]string, 0, len(m.managers))
+	for idc := range m.managers {
+		idcs = append(idcs, idc)
+	}
+	sort.Strings(idcs)
+
+	result := make([]*KafkaStatus, 0, len(idcs))
+	for _, idc := range idcs {
+		sort.Strings(topics[idc])
+		status := &KafkaStatus{Idc: idc}
+		cluster, err := m.managers[idc].ClusterStatus(topics[idc])
+		if err != nil {
+			status.Error = err.Error()
+		} else {
+			status.ClusterStatus = cluster
+		}
+		result = append(result, status)
+	}
+	return result
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"strconv"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/engine/zookeeper"
+
+	"github.com/juju/errors"
+)
+
+// 集群范围的后台任务(跨机房复制、临时queue清理、孤立资源检查、报警检查、定时消息)只在leader上执行.
+// leader通过zookeeper临时节点选出, leader下线后其他proxy自动接管.
+// leader段是可选的, leader.candidate=false的proxy不参与竞选
+func isLeaderCandidate(conf *config.Config) bool {
+	if section, err := conf.GetSection("leader"); err == nil {
+		return section.GetBoolMust("candidate", true)
+	}
+	return true
+}
+
+func (m *Metadata) startElection() {
+	m.election = m.zkConn.NewElection(m.leaderPath, strconv.Itoa(m.id))
+	m.election.Start()
+}
+
+// 当前proxy是否为leader, 不参与竞选时总是false
+func (m *Metadata) IsLeader() bool {
+	return m.election != nil && m.election.IsLeader()
+}
+
+// 返回leader的proxy id, 没有leader时返回-1
+func (m *Metadata) LeaderID() (int, error) {
+	data, _, err := m.zkConn.Get(m.leaderPath)
+	if zookeeper.IsNoNode(err) {
+		return -1, nil
+	}
+	if err != nil {
+		return -1, errors.Trace(err)
+	}
+	id, err := strconv.Atoi(string(data))
+	if err != nil {
+		return -1, errors.NotValidf("leader id : %q", data)
+	}
+	return id, nil
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+
+	"github.com/weibocom/wqs/config"
+)
+
+func TestLeaderCandidate(t *testing.T) {
+	conf, err := config.NewConfigFromBytes([]byte(testAlertBaseConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isLeaderCandidate(conf) {
+		t.Error("proxy should be candidate by default")
+	}
+
+	conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig + "leader.candidate=false\n"))
+	if isLeaderCandidate(conf) {
+		t.Error("proxy should not be candidate")
+	}
+
+	m := &Metadata{}
+	if m.IsLeader() {
+		t.Error("proxy without election should not be leader")
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// 超过限流阈值时返回, RetryAfter为建议的重试间隔
+type ThrottledError struct {
+	Target     string
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	ms := e.RetryAfter.Nanoseconds() / 1e6
+	if ms == 0 {
+		ms = 1
+	}
+	return fmt.Sprintf("%s throttled, retry after %dms", e.Target, ms)
+}
+
+func IsThrottled(err error) bool {
+	_, ok := errors.Cause(err).(*ThrottledError)
+	return ok
+}
+
+// 令牌桶, 容量等于每秒的速率
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) setRate(rate int64) {
+	b.rate = float64(rate)
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+		b.last = now
+	}
+}
+
+// 返回获取n个令牌需要等待的时间, 0表示可以立即获取.
+// 桶满时总是允许, 避免大于桶容量的请求永远无法通过
+func (b *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= n || b.tokens >= b.rate {
+		return 0
+	}
+	need := n - b.tokens
+	if n > b.rate {
+		need = b.rate - b.tokens
+	}
+	return time.Duration(need / b.rate * float64(time.Second))
+}
+
+// 可以透支, 透支的部分由之后的请求等待
+func (b *tokenBucket) take(n float64) {
+	b.tokens -= n
+}
+
+type limitRequest struct {
+	key  string
+	rate int64
+	n    int64
+}
+
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+func (l *rateLimiter) bucket(key string, rate int64, now time.Time) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newTokenBucket(rate, now)
+		l.buckets[key] = b
+	} else if b.rate != float64(rate) {
+		b.setRate(rate)
+	}
+	return b
+}
+
+// 所有限制都满足时才消耗令牌, 否则返回需要等待最久的ThrottledError
+func (l *rateLimiter) acquire(target string, reqs []limitRequest) error {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var wait time.Duration
+	buckets := make([]*tokenBucket, 0, len(reqs))
+	for _, req := range reqs {
+		if req.rate <= 0 {
+			delete(l.buckets, req.key)
+			buckets = append(buckets, nil)
+			continue
+		}
+		b := l.bucket(req.key, req.rate, now)
+		if d := b.reserve(float64(req.n), now); d > wait {
+			wait = d
+		}
+		buckets = append(buckets, b)
+	}
+	if wait > 0 {
+		return &ThrottledError{Target: target, RetryAfter: wait}
+	}
+
+	for i, b := range buckets {
+		if b != nil {
+			b.take(float64(reqs[i].n))
+		}
+	}
+	return nil
+}
+
+// 事后扣除令牌, 用于接收消息时才知道大小的情况
+func (l *rateLimiter) charge(reqs []limitRequest) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, req := range reqs {
+		if req.rate <= 0 {
+			continue
+		}
+		b := l.bucket(req.key, req.rate, now)
+		b.refill(now)
+		b.take(float64(req.n))
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(10, now)
+
+	for i := 0; i < 10; i++ {
+		if d := b.reserve(1, now); d != 0 {
+			t.Fatalf("request %d should pass, wait %v", i, d)
+		}
+		b.take(1)
+	}
+	if d := b.reserve(1, now); d != 100*time.Millisecond {
+		t.Errorf("expect wait 100ms, got %v", d)
+	}
+	if d := b.reserve(1, now.Add(100*time.Millisecond)); d != 0 {
+		t.Errorf("expect pass after refill, wait %v", d)
+	}
+
+	// 超过容量的请求在桶满时允许通过
+	b = newTokenBucket(10, now)
+	if d := b.reserve(100, now); d != 0 {
+		t.Errorf("large request should pass when bucket is full, wait %v", d)
+	}
+	b.take(100)
+	if d := b.reserve(1, now.Add(time.Second)); d == 0 {
+		t.Errorf("overdraft should be paid back before next request")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter()
+	reqs := []limitRequest{
+		{key: "q.g.SET.msg", rate: 100, n: 1},
+		{key: "q.g.SET.byte", rate: 10, n: 10},
+	}
+
+	if err := l.acquire("q.g", reqs); err != nil {
+		t.Fatalf("first request should pass: %v", err)
+	}
+	err := l.acquire("q.g", reqs)
+	if !IsThrottled(err) {
+		t.Fatalf("expect throttled error, got %v", err)
+	}
+	if !IsThrottled(errors.Trace(err)) {
+		t.Errorf("IsThrottled should check the cause")
+	}
+	if !strings.Contains(err.Error(), "retry after") {
+		t.Errorf("error should contain retry after: %s", err)
+	}
+	// 字节限制没有通过时不应该消耗消息令牌
+	if tokens := l.buckets["q.g.SET.msg"].tokens; tokens < 98 || tokens > 99.5 {
+		t.Errorf("msg tokens should only be taken once, got %f", tokens)
+	}
+
+	// 限制被取消后不再限流
+	reqs[1].rate = 0
+	if err := l.acquire("q.g", reqs); err != nil {
+		t.Errorf("request should pass after limit removed: %v", err)
+	}
+	if _, ok := l.buckets["q.g.SET.byte"]; ok {
+		t.Errorf("bucket should be removed with limit")
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/weibocom/wqs/log"
+
+	"github.com/juju/errors"
+)
+
+// 查询queue的条件, 为空时返回所有queue.
+// Prefix匹配queue名字的前缀, Tags中的每一项都需要与queue的标签相同.
+// Limit大于0时只返回按名字排序后从Offset开始的Limit个queue
+type QueueFilter struct {
+	Prefix string
+	Tags   map[string]string
+	Offset int
+	Limit  int
+}
+
+func (f *QueueFilter) match(queue string, tags map[string]string) bool {
+	if !strings.HasPrefix(queue, f.Prefix) {
+		return false
+	}
+	for key, value := range f.Tags {
+		if tag, ok := tags[key]; !ok || tag != value {
+			return false
+		}
+	}
+	return true
+}
+
+// 查询group的条件, Queue不为空时只返回订阅了该queue的group, 分页方式与QueueFilter相同
+type GroupFilter struct {
+	Prefix string
+	Queue  string
+	Offset int
+	Limit  int
+}
+
+// 返回[offset, offset+limit)与[0, n)的交集, limit小于等于0时不分页
+func paginate(n int, offset int, limit int) (int, int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > n {
+		offset = n
+	}
+	end := n
+	if limit > 0 && offset+limit < n {
+		end = offset + limit
+	}
+	return offset, end
+}
+
+// 按名字前缀和标签查询queue, 返回按名字排序的一页和符合条件的总数
+func (q *queueImp) LookupQueues(filter QueueFilter) ([]*QueueInfo, int, error) {
+
+	if err := q.metadata.RefreshMetadata(); err != nil {
+		log.Errorf("LookupQueues refresh metadata error %s", errors.ErrorStack(err))
+		return nil, 0, err
+	}
+
+	queues := make([]string, 0)
+	for _, queue := range q.metadata.GetQueues() {
+		config := q.metadata.GetQueueConfig(queue)
+		if config != nil && filter.match(queue, config.Tags) {
+			queues = append(queues, queue)
+		}
+	}
+	sort.Strings(queues)
+
+	start, end := paginate(len(queues), filter.Offset, filter.Limit)
+	infos, err := q.metadata.GetQueueInfo(queues[start:end]...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return infos, len(queues), nil
+}
+
+// 按名字前缀和订阅的queue查询group, 返回按名字排序的一页和符合条件的总数
+func (q *queueImp) LookupGroups(filter GroupFilter) ([]*GroupInfo, int, error) {
+
+	if err := q.metadata.RefreshMetadata(); err != nil {
+		log.Errorf("LookupGroups refresh metadata error %s", errors.ErrorStack(err))
+		return nil, 0, err
+	}
+
+	groupMap := q.metadata.GetGroupMap()
+	groups := make([]string, 0, len(groupMap))
+	for group, queues := range groupMap {
+		if !strings.HasPrefix(group, filter.Prefix) {
+			continue
+		}
+		if filter.Queue != "" && !contains(queues, filter.Queue) {
+			continue
+		}
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	start, end := paginate(len(groups), filter.Offset, filter.Limit)
+	groupInfos := make([]*GroupInfo, 0, end-start)
+	for _, group := range groups[start:end] {
+		groupInfos = append(groupInfos, q.newGroupInfo(group, groupMap[group]))
+	}
+	return groupInfos, len(groups), nil
+}
+
+// group订阅的每个queue的配置, 按queue名字排序
+func (q *queueImp) newGroupInfo(group string, queues []string) *GroupInfo {
+	sorted := make([]string, len(queues))
+	copy(sorted, queues)
+	sort.Strings(sorted)
+
+	groupInfo := &GroupInfo{
+		Group:  group,
+		Queues: make([]*GroupConfig, 0, len(sorted)),
+	}
+	for _, queue := range sorted {
+		groupConfig, err := q.metadata.GetGroupConfig(group, queue)
+		if err != nil {
+			continue
+		}
+		groupInfo.Queues = append(groupInfo.Queues, groupConfig)
+	}
+	return groupInfo
+}
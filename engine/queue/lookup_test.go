@@ -0,0 +1,113 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testLookupMetadata() *Metadata {
+	return &Metadata{queueConfigs: map[string]QueueConfig{
+		"q1": {Queue: "q1", Groups: map[string]GroupConfig{
+			"g2": {Group: "g2", Queue: "q1"},
+			"g1": {Group: "g1", Queue: "q1"},
+		}},
+		"q0": {Queue: "q0", Groups: map[string]GroupConfig{
+			"g3": {Group: "g3", Queue: "q0"},
+			"g1": {Group: "g1", Queue: "q0"},
+		}},
+		"q2": {Queue: "q2", Groups: map[string]GroupConfig{}},
+	}}
+}
+
+func groupNames(groups []GroupConfig) []string {
+	names := make([]string, 0, len(groups))
+	for _, g := range groups {
+		names = append(names, g.Group+"@"+g.Queue)
+	}
+	return names
+}
+
+func TestGetQueueInfoGroups(t *testing.T) {
+	infos, err := testLookupMetadata().GetQueueInfo("q1", "q2", "q0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{
+		"q0": "[g1@q0 g3@q0]",
+		"q1": "[g1@q1 g2@q1]",
+		"q2": "[]",
+	}
+	if len(infos) != 3 || infos[0].Queue != "q0" || infos[1].Queue != "q1" || infos[2].Queue != "q2" {
+		t.Fatalf("expect queues sorted, got %v", infos)
+	}
+	for _, info := range infos {
+		if got := fmt.Sprint(groupNames(info.Groups)); got != expect[info.Queue] {
+			t.Errorf("queue %s expect groups %s, got %s", info.Queue, expect[info.Queue], got)
+		}
+	}
+}
+
+func TestNewGroupInfo(t *testing.T) {
+	q := &queueImp{metadata: testLookupMetadata()}
+	info := q.newGroupInfo("g1", []string{"q1", "q0", "unknown"})
+	if len(info.Queues) != 2 || info.Queues[0].Queue != "q0" || info.Queues[1].Queue != "q1" {
+		t.Errorf("expect queues of g1 sorted, got %v", info.Queues)
+	}
+}
+
+func TestQueueFilter(t *testing.T) {
+	tags := map[string]string{"team": "feed", "env": "staging"}
+	cases := []struct {
+		filter QueueFilter
+		queue  string
+		match  bool
+	}{
+		{QueueFilter{}, "feed_like", true},
+		{QueueFilter{Prefix: "feed_"}, "feed_like", true},
+		{QueueFilter{Prefix: "feed_"}, "user_like", false},
+		{QueueFilter{Tags: map[string]string{"team": "feed"}}, "feed_like", true},
+		{QueueFilter{Tags: map[string]string{"team": "feed", "env": "prod"}}, "feed_like", false},
+		{QueueFilter{Prefix: "feed", Tags: map[string]string{"owner": ""}}, "feed_like", false},
+	}
+	for i, c := range cases {
+		if match := c.filter.match(c.queue, tags); match != c.match {
+			t.Errorf("case %d: expect %v, got %v", i, c.match, match)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	cases := []struct {
+		n, offset, limit int
+		start, end       int
+	}{
+		{10, 0, 0, 0, 10},
+		{10, 0, 3, 0, 3},
+		{10, 8, 3, 8, 10},
+		{10, 12, 3, 10, 10},
+		{10, -1, 3, 0, 3},
+		{0, 0, 5, 0, 0},
+	}
+	for _, c := range cases {
+		if start, end := paginate(c.n, c.offset, c.limit); start != c.start || end != c.end {
+			t.Errorf("paginate(%d, %d, %d) expect [%d, %d), got [%d, %d)",
+				c.n, c.offset, c.limit, c.start, c.end, start, end)
+		}
+	}
+}
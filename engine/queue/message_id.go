@@ -18,7 +18,6 @@ package queue
 
 import (
 	"errors"
-	"fmt"
 	"strconv"
 	"strings"
 	"sync"
@@ -90,7 +89,8 @@ func (m *messageId) Parse(id string) error {
 	if len(tokens) != 6 {
 		return errBadMessageID
 	}
-	// 目前不需要解析 sequence
+	// sequence中包含消息的发送时间, 无法解析时为0
+	m.sequence, _ = strconv.ParseUint(tokens[0], 16, 64)
 	m.queue = tokens[1]
 	m.group = tokens[2]
 	m.idc = tokens[5]
@@ -108,7 +108,46 @@ func (m *messageId) Parse(id string) error {
 	return nil
 }
 
+// 格式为sequence:queue:group:partition:offset:idc, 数字为十六进制
 func (m *messageId) String() string {
-	return fmt.Sprintf("%x:%s:%s:%x:%x:%s",
-		m.sequence, m.queue, m.group, m.partition, m.offset, m.idc)
+	buf := getBuffer()
+	b := strconv.AppendUint(*buf, m.sequence, 16)
+	b = append(b, ':')
+	b = append(b, m.queue...)
+	b = append(b, ':')
+	b = append(b, m.group...)
+	b = append(b, ':')
+	b = strconv.AppendInt(b, int64(m.partition), 16)
+	b = append(b, ':')
+	b = strconv.AppendInt(b, m.offset, 16)
+	b = append(b, ':')
+	b = append(b, m.idc...)
+	id := string(b)
+	*buf = b
+	putBuffer(buf)
+	return id
+}
+
+// 返回消息id对应的partition和offset, 发送和接收返回的消息id都可以解析
+func MessagePosition(id string) (int32, int64, error) {
+	m := &messageId{}
+	if err := m.Parse(id); err != nil {
+		return 0, 0, err
+	}
+	return m.partition, m.offset, nil
+}
+
+// 解析kafka消息的key, 格式为 sequence:flag
+func parseMessageKey(key []byte) (sequence uint64, flag uint64) {
+	tokens := strings.Split(string(key), ":")
+	sequence, _ = strconv.ParseUint(tokens[0], 16, 64)
+	if len(tokens) > 1 {
+		flag, _ = strconv.ParseUint(tokens[1], 16, 32)
+	}
+	return
+}
+
+// 返回生成sequence时的毫秒时间戳
+func sequenceTime(sequence uint64) int64 {
+	return baseTime + int64((sequence>>24)&0xFFFFFFFFFF)
 }
@@ -36,6 +36,31 @@ func TestMessageID(t *testing.T) {
 	}
 }
 
+func TestMessageExpired(t *testing.T) {
+	genor := newIDGenerator(0xEE)
+	sequence := genor.Get()
+	key := []byte(fmt.Sprintf("%x:%x", sequence, 3))
+
+	seq, flag := parseMessageKey(key)
+	if seq != sequence || flag != 3 {
+		t.Fatalf("parse key %s error: %x %x", key, seq, flag)
+	}
+	if now := time.Now().UnixNano() / 1e6; now-sequenceTime(sequence) > 1000 {
+		t.Errorf("sequence time %d too far from now %d", sequenceTime(sequence), now)
+	}
+
+	if expired(key, 60*1000) {
+		t.Errorf("new message should not expire")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !expired(key, 10) {
+		t.Errorf("message should expire after ttl")
+	}
+	if expired([]byte("bad key"), 10) {
+		t.Errorf("message with bad key should not expire")
+	}
+}
+
 //防止编译器在test时，内联优化
 func dummy(id uint64) {
 }
@@ -62,3 +87,14 @@ func BenchmarkMessageIDParallel(b *testing.B) {
 		}
 	})
 }
+
+func TestMessagePosition(t *testing.T) {
+	id := (&messageId{queue: "q1", group: "g1", idc: "local", partition: 10, offset: 255, sequence: 1}).String()
+	partition, offset, err := MessagePosition(id)
+	if err != nil || partition != 10 || offset != 255 {
+		t.Errorf("message position of %s error: %d %d %v", id, partition, offset, err)
+	}
+	if _, _, err = MessagePosition("bad"); err != errBadMessageID {
+		t.Errorf("expect bad message id, got %v", err)
+	}
+}
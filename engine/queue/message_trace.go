@@ -0,0 +1,273 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+const (
+	TraceSend = "send"
+	TraceRecv = "recv"
+	TraceAck  = "ack"
+
+	defaultTraceTopic     = "wqs_msgtrace"
+	defaultTraceBuffer    = 10000
+	defaultTraceScanLimit = 100000
+	// 各个proxy的时钟可能有偏差, 从消息发送时间之前开始查找
+	defaultTraceLookback = time.Minute
+	traceBatchSize       = 500
+)
+
+// 消息轨迹中的一个事件, 同一条消息的事件使用相同的key写入trace topic的同一个partition
+type TraceEvent struct {
+	Event     string `json:"event"`
+	Queue     string `json:"queue"`
+	Group     string `json:"group"`
+	Idc       string `json:"idc"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Client    string `json:"client,omitempty"`
+	Proxy     int    `json:"proxy"`
+	Time      int64  `json:"time"`
+}
+
+// 消息在kafka中的位置, 与发送和接收时使用的group无关
+func traceKey(queue string, idc string, partition int32, offset int64) string {
+	return fmt.Sprintf("%s:%s:%x:%x", queue, idc, partition, offset)
+}
+
+func (e *TraceEvent) key() string {
+	return traceKey(e.Queue, e.Idc, e.Partition, e.Offset)
+}
+
+// 开启了消息轨迹的queue的发送、接收和ACK事件异步写入本机房kafka的trace topic,
+// 按消息id查询时从消息发送的时间开始读取该消息所在的partition. 缓冲区满时丢弃事件, 不影响读写消息
+type messageTracer struct {
+	topic     string
+	scanLimit int
+	lookback  time.Duration
+	proxy     int
+	metadata  *Metadata
+	producer  *kafka.Producer
+	events    chan *TraceEvent
+	ensure    sync.Once
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newMessageTracer(conf *config.Config, metadata *Metadata, producer *kafka.Producer) (*messageTracer, error) {
+	t := &messageTracer{
+		topic:     defaultTraceTopic,
+		scanLimit: defaultTraceScanLimit,
+		lookback:  defaultTraceLookback,
+		proxy:     conf.ProxyId,
+		metadata:  metadata,
+		producer:  producer,
+		done:      make(chan struct{}),
+	}
+	buffer := int64(defaultTraceBuffer)
+	// msgtrace段是可选的
+	if section, err := conf.GetSection("msgtrace"); err == nil {
+		t.topic = section.GetStringMust("topic", defaultTraceTopic)
+		buffer = section.GetInt64Must("buffer", defaultTraceBuffer)
+		t.scanLimit = int(section.GetInt64Must("scan.limit", defaultTraceScanLimit))
+		if value := section.GetStringMust("lookback", ""); value != "" {
+			if t.lookback, err = time.ParseDuration(value); err != nil || t.lookback < 0 {
+				return nil, errors.NotValidf("msgtrace.lookback %q", value)
+			}
+		}
+	}
+	if t.topic == "" || buffer < 1 || t.scanLimit < 1 {
+		return nil, errors.NotValidf("msgtrace topic %q buffer %d scan.limit %d", t.topic, buffer, t.scanLimit)
+	}
+	t.events = make(chan *TraceEvent, buffer)
+	go t.run()
+	return t, nil
+}
+
+// 记录消息id对应的消息的一个事件, queue没有开启消息轨迹时忽略
+func (t *messageTracer) record(event string, queue string, group string, id string, client string) {
+	if config := t.metadata.GetQueueConfig(queue); config == nil || !config.Trace {
+		return
+	}
+	msgID := &messageId{}
+	if err := msgID.Parse(id); err != nil {
+		return
+	}
+	e := &TraceEvent{
+		Event:     event,
+		Queue:     queue,
+		Group:     group,
+		Idc:       msgID.idc,
+		Partition: msgID.partition,
+		Offset:    msgID.offset,
+		Client:    client,
+		Proxy:     t.proxy,
+		Time:      time.Now().UnixNano() / 1e6,
+	}
+	select {
+	case t.events <- e:
+	default:
+		metrics.AddCounter(metrics.MsgTrace+"."+metrics.Dropped, 1)
+	}
+}
+
+func (t *messageTracer) run() {
+	defer close(t.done)
+	batch := make([]*sarama.ProducerMessage, 0, traceBatchSize)
+	for e := range t.events {
+		batch = append(batch[:0], traceMessage(t.topic, e))
+	Batch:
+		for len(batch) < traceBatchSize {
+			select {
+			case e, ok := <-t.events:
+				if !ok {
+					break Batch
+				}
+				batch = append(batch, traceMessage(t.topic, e))
+			default:
+				break Batch
+			}
+		}
+		t.send(batch)
+	}
+}
+
+func (t *messageTracer) send(batch []*sarama.ProducerMessage) {
+	t.ensure.Do(t.ensureTopic)
+	if err := t.producer.SendMessages(batch); err != nil {
+		metrics.AddCounter(metrics.MsgTrace+"."+metrics.Dropped, int64(len(batch)))
+		log.Warnf("write %d message trace events error: %s", len(batch), err)
+	}
+}
+
+// trace topic不存在时按kafka.topic.*创建
+func (t *messageTracer) ensureTopic() {
+	manager := t.metadata.LocalManager()
+	exist, err := manager.ExistTopic(t.topic)
+	if err != nil || exist {
+		return
+	}
+	if err = manager.CreateTopic(t.topic, t.metadata.replications, t.metadata.partitions); err != nil && !errors.IsAlreadyExists(err) {
+		log.Warnf("create message trace topic %s error: %s", t.topic, err)
+		return
+	}
+	if err = manager.RefreshMetadata(); err != nil {
+		log.Warnf("refresh metadata after creating topic %s error: %s", t.topic, err)
+	}
+}
+
+func traceMessage(topic string, e *TraceEvent) *sarama.ProducerMessage {
+	data, _ := json.Marshal(e)
+	return &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(e.key()),
+		Value: sarama.ByteEncoder(data),
+	}
+}
+
+// 写入缓冲区中剩余的事件, 需要在关闭producer之前调用
+func (t *messageTracer) close() {
+	t.closeOnce.Do(func() {
+		close(t.events)
+		<-t.done
+	})
+}
+
+// 按时间顺序返回消息的所有事件, 发送和接收返回的消息id都可以查询
+func (t *messageTracer) lookup(id string) ([]*TraceEvent, error) {
+	msgID := &messageId{}
+	if err := msgID.Parse(id); err != nil {
+		return nil, errors.NotValidf("message id: %q", id)
+	}
+	key := traceKey(msgID.queue, msgID.idc, msgID.partition, msgID.offset)
+
+	manager := t.metadata.LocalManager()
+	partitions, err := manager.Partitions(t.topic)
+	if err != nil {
+		return nil, errors.Annotatef(err, "message trace topic %s", t.topic)
+	}
+	partition, err := sarama.NewHashPartitioner(t.topic).Partition(
+		&sarama.ProducerMessage{Key: sarama.StringEncoder(key)}, int32(len(partitions)))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	start := sequenceTime(msgID.sequence) - int64(t.lookback/time.Millisecond)
+	offsets, err := manager.FetchTopicOffsets(t.topic, start)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	events := make([]*TraceEvent, 0)
+	offset := offsets[partition]
+	// 起始时间之后没有写入事件时offset为-1
+	for scanned := 0; offset >= 0 && scanned < t.scanLimit; {
+		msgs, err := manager.FetchMessages(t.topic, partition, offset, traceBatchSize)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		events = append(events, matchEvents(msgs, key)...)
+		if len(msgs) < traceBatchSize {
+			break
+		}
+		scanned += len(msgs)
+		offset = msgs[len(msgs)-1].Offset + 1
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time < events[j].Time })
+	return events, nil
+}
+
+func matchEvents(msgs []*sarama.ConsumerMessage, key string) []*TraceEvent {
+	events := make([]*TraceEvent, 0)
+	for _, msg := range msgs {
+		if string(msg.Key) != key {
+			continue
+		}
+		e := &TraceEvent{}
+		if err := json.Unmarshal(msg.Value, e); err != nil {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// 开启后记录该queue中消息的发送、接收和ACK事件
+func (q *queueImp) SetQueueMessageTrace(queue string, enable bool) error {
+	return q.metadata.ModifyQueueConfig(queue, func(config *QueueConfig) error {
+		config.Trace = enable
+		return nil
+	})
+}
+
+func (q *queueImp) MessageTrace(id string) ([]*TraceEvent, error) {
+	return q.msgTracer.lookup(id)
+}
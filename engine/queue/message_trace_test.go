@@ -0,0 +1,93 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestMessageTracerRecord(t *testing.T) {
+	tracer := &messageTracer{
+		proxy: 3,
+		metadata: &Metadata{queueConfigs: map[string]QueueConfig{
+			"q1": {Queue: "q1", Trace: true},
+			"q2": {Queue: "q2"},
+		}},
+		events: make(chan *TraceEvent, 1),
+	}
+	sent := (&messageId{queue: "q1", group: "g1", idc: "bj", partition: 2, offset: 10, sequence: 1}).String()
+	recv := (&messageId{queue: "q1", group: "g2", idc: "bj", partition: 2, offset: 10, sequence: 1}).String()
+
+	tracer.record(TraceSend, "q2", "g1", sent, "c1")
+	tracer.record(TraceSend, "q1", "g1", "bad id", "c1")
+	if len(tracer.events) != 0 {
+		t.Fatalf("queue without trace or bad id should not be recorded")
+	}
+	tracer.record(TraceSend, "q1", "g1", sent, "c1")
+	// 缓冲区满时丢弃
+	tracer.record(TraceRecv, "q1", "g2", recv, "c2")
+	if len(tracer.events) != 1 {
+		t.Fatalf("events %d, want 1", len(tracer.events))
+	}
+
+	e := <-tracer.events
+	if e.Event != TraceSend || e.Group != "g1" || e.Client != "c1" || e.Proxy != 3 || e.key() != "q1:bj:2:a" {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	// 不同group的消息id对应同一个key
+	msg := traceMessage("trace", e)
+	e2 := *e
+	e2.Event, e2.Group, e2.Time = TraceRecv, "g2", e.Time+1
+	other := *e
+	other.Offset = 11
+	msgs := []*sarama.ConsumerMessage{
+		{Key: []byte("q1:bj:2:a"), Value: mustEncode(msg.Value)},
+		{Key: []byte("q1:bj:2:b"), Value: mustEncode(traceMessage("trace", &other).Value)},
+		{Key: []byte("q1:bj:2:a"), Value: mustEncode(traceMessage("trace", &e2).Value)},
+		{Key: []byte("q1:bj:2:a"), Value: []byte("not json")},
+	}
+	events := matchEvents(msgs, "q1:bj:2:a")
+	if len(events) != 2 || events[0].Event != TraceSend || events[1].Group != "g2" {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestMessageIDSequence(t *testing.T) {
+	g := newIDGenerator(1)
+	sequence := g.Get()
+	id := (&messageId{queue: "q1", group: "g1", idc: "bj", sequence: sequence}).String()
+
+	m := &messageId{}
+	if err := m.Parse(id); err != nil {
+		t.Fatalf("parse %s err : %v", id, err)
+	}
+	if m.sequence != sequence {
+		t.Errorf("sequence %x, want %x", m.sequence, sequence)
+	}
+	if d := time.Now().UnixNano()/1e6 - sequenceTime(m.sequence); d < 0 || d > 1000 {
+		t.Errorf("sequence time %d ms ago", d)
+	}
+}
+
+func mustEncode(e sarama.Encoder) []byte {
+	data, _ := e.Encode()
+	return data
+}
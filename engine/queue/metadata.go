@@ -39,7 +39,15 @@ const (
 	servicePathPrefix     = "/wqs/metadata/service"
 	metricsPathPrefix     = "/wqs/metadata/metrics"
 	operationPathPrefix   = "/wqs/metadata/operation"
+	alertPathPrefix       = "/wqs/metadata/alert"
+	tokenPathPrefix       = "/wqs/metadata/token"
+	quotaPathPrefix       = "/wqs/metadata/quota"
+	leaderPathPrefix      = "/wqs/metadata/leader"
+	schedulePathPrefix    = "/wqs/metadata/schedule"
+	snapshotPathPrefix    = "/wqs/metadata/snapshot"
 	defaultIdc            = "local"
+	// 刷新元数据时并发读取zookeeper节点的请求数
+	refreshConcurrency = 16
 )
 
 type Metadata struct {
@@ -51,16 +59,63 @@ type Metadata struct {
 	servicePath     string
 	metricsPath     string
 	operationPath   string
+	alertPath       string
+	tokenPath       string
+	quotaPath       string
+	leaderPath      string
+	schedulePath    string
+	snapshotPath    string
+	election        *zookeeper.Election
 	local           string
 	partitions      int32
 	replications    int32
+	profiles        map[string]*Profile
 	stopping        int32
+	serviceData     string
 	id              int
 	queueConfigs    map[string]QueueConfig
+	tokens          map[string]TokenInfo
+	tokenMisses     map[string]struct{}
 	dying           chan struct{}
 	rw              sync.RWMutex
 }
 
+// 配置了metadata.zookeeper.sasl.*时使用kerberos认证连接, 创建的元数据节点只有该principal可以修改.
+// 配置了metadata.zookeeper.auth时使用digest认证, 创建的元数据节点只有该用户可以修改.
+// metadata.zookeeper.acl.world.read为false时其他客户端也不能读取
+func connectMetadataZK(conf *config.Config) (*zookeeper.Conn, error) {
+	return ConnectZK(strings.Split(conf.MetaDataZKAddr, ","), conf)
+}
+
+// 使用conf中metadata.zookeeper.*的认证配置连接addrs, 也用于读取-config.zk指定的集中配置
+func ConnectZK(addrs []string, conf *config.Config) (*zookeeper.Conn, error) {
+	section, err := conf.GetSection("metadata")
+	if err != nil {
+		return zookeeper.NewConnect(addrs)
+	}
+	kerberos, err := zookeeperKerberos(section, "metadata", "")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := zookeeper.NewKerberosConnect(addrs, kerberos)
+	if err != nil {
+		return nil, err
+	}
+	worldRead := section.GetBoolMust("zookeeper.acl.world.read", true)
+	if kerberos != nil {
+		conn.SASLOwner(section.GetStringMust("zookeeper.acl.sasl.id", kerberos.Username+"@"+kerberos.Realm), worldRead)
+	}
+	auth := section.GetStringMust("zookeeper.auth", "")
+	if auth == "" {
+		return conn, nil
+	}
+	if err = conn.DigestAuth(auth, worldRead); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
 // return a new metadata instance
 func NewMetadata(config *config.Config, sconfig *sarama.Config) (*Metadata, error) {
 
@@ -69,7 +124,7 @@ func NewMetadata(config *config.Config, sconfig *sarama.Config) (*Metadata, erro
 		return nil, errors.Trace(err)
 	}
 
-	zkConn, err := zookeeper.NewConnect(strings.Split(config.MetaDataZKAddr, ","))
+	zkConn, err := connectMetadataZK(config)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -84,6 +139,12 @@ func NewMetadata(config *config.Config, sconfig *sarama.Config) (*Metadata, erro
 	servicePath := fmt.Sprintf("%s%s", root, servicePathPrefix)
 	operationPath := fmt.Sprintf("%s%s", root, operationPathPrefix)
 	metricsPath := fmt.Sprintf("%s%s", root, metricsPathPrefix)
+	alertPath := fmt.Sprintf("%s%s", root, alertPathPrefix)
+	tokenPath := fmt.Sprintf("%s%s", root, tokenPathPrefix)
+	quotaPath := fmt.Sprintf("%s%s", root, quotaPathPrefix)
+	leaderPath := fmt.Sprintf("%s%s", root, leaderPathPrefix)
+	schedulePath := fmt.Sprintf("%s%s", root, schedulePathPrefix)
+	snapshotPath := fmt.Sprintf("%s%s", root, snapshotPathPrefix)
 
 	if err = zkConn.CreateRecursiveIgnoreExist(groupConfigPath, "", 0); err != nil {
 		return nil, errors.Trace(err)
@@ -101,6 +162,18 @@ func NewMetadata(config *config.Config, sconfig *sarama.Config) (*Metadata, erro
 		return nil, errors.Trace(err)
 	}
 
+	if err = zkConn.CreateRecursiveIgnoreExist(tokenPath, "", 0); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err = zkConn.CreateRecursiveIgnoreExist(schedulePath, "", 0); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err = zkConn.CreateRecursiveIgnoreExist(snapshotPath, "", 0); err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	kafkaZkAddr, err := kafkaSection.GetString("zookeeper.connect")
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -118,8 +191,16 @@ func NewMetadata(config *config.Config, sconfig *sarama.Config) (*Metadata, erro
 	if partitions < 1 {
 		return nil, errors.NotValidf("kafka.topic.partitions")
 	}
+	profiles, err := loadProfiles(config, partitions, replications)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 
-	manager, err := kafka.NewManager(strings.Split(kafkaZkAddr, ","), kafkaZkRoot, sconfig)
+	zkKerberos, err := zookeeperKerberos(kafkaSection, "kafka", "")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	manager, err := kafka.NewManager(strings.Split(kafkaZkAddr, ","), kafkaZkRoot, sconfig, zkKerberos)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -142,7 +223,19 @@ func NewMetadata(config *config.Config, sconfig *sarama.Config) (*Metadata, erro
 			addrs = tokens[0]
 			kafkaRoot += tokens[1]
 		}
-		idcKafakManager, err := kafka.NewManager(strings.Split(addrs, ","), kafkaRoot, sconfig)
+		// 远端集群可以通过kafka.remote.<idc>.sasl.*使用不同的认证
+		remoteConfig, err := clusterSASLConfig(kafkaSection, "kafka", "remote."+idc+".", sconfig)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		// 远端集群的zookeeper可以通过kafka.remote.<idc>.zookeeper.sasl.*使用不同的认证
+		remoteKerberos := zkKerberos
+		if kafkaSection.GetStringMust("remote."+idc+".zookeeper.sasl.mechanism", "") != "" {
+			if remoteKerberos, err = zookeeperKerberos(kafkaSection, "kafka", "remote."+idc+"."); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		idcKafakManager, err := kafka.NewManager(strings.Split(addrs, ","), kafkaRoot, remoteConfig, remoteKerberos)
 		if err != nil {
 			return nil, errors.Trace(errors.Annotatef(err, "at add idc kafka: %q", idc))
 		}
@@ -161,11 +254,20 @@ func NewMetadata(config *config.Config, sconfig *sarama.Config) (*Metadata, erro
 		servicePath:     servicePath,
 		metricsPath:     metricsPath,
 		operationPath:   operationPath,
+		alertPath:       alertPath,
+		tokenPath:       tokenPath,
+		quotaPath:       quotaPath,
+		leaderPath:      leaderPath,
+		schedulePath:    schedulePath,
+		snapshotPath:    snapshotPath,
 		local:           idc,
 		partitions:      partitions,
 		replications:    replications,
+		profiles:        profiles,
 		id:              config.ProxyId,
 		queueConfigs:    make(map[string]QueueConfig),
+		tokens:          make(map[string]TokenInfo),
+		tokenMisses:     make(map[string]struct{}),
 		dying:           make(chan struct{}),
 	}
 
@@ -173,6 +275,10 @@ func NewMetadata(config *config.Config, sconfig *sarama.Config) (*Metadata, erro
 		return nil, errors.Trace(err)
 	}
 
+	if err = metadata.RefreshTokens(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	go func(m *Metadata) {
 		ticker := time.NewTicker(sconfig.Metadata.RefreshFrequency)
 		for {
@@ -181,6 +287,12 @@ func NewMetadata(config *config.Config, sconfig *sarama.Config) (*Metadata, erro
 				if err := m.RefreshMetadata(); err != nil {
 					log.Errorf("timeout refresh metadata error %s", errors.ErrorStack(err))
 				}
+				if err := m.RefreshTokens(); err != nil {
+					log.Errorf("timeout refresh tokens error %s", errors.ErrorStack(err))
+				}
+				if err := m.ensureService(); err != nil {
+					log.Errorf("timeout check service error %s", errors.ErrorStack(err))
+				}
 			case <-m.dying:
 				ticker.Stop()
 				return
@@ -199,12 +311,36 @@ func (m *Metadata) LocalManager() *kafka.Manager {
 // register service to zookeeper
 func (m *Metadata) RegisterService(id int, data string) error {
 	path := fmt.Sprintf("%s/%d", m.servicePath, id)
-	if err := m.zkConn.Create(path, data, zookeeper.Ephemeral); err != nil {
+	if err := m.zkConn.CreatePrivate(path, data, zookeeper.Ephemeral); err != nil {
 		if zookeeper.IsExistError(err) {
 			return errors.AlreadyExistsf("service %d", id)
 		}
 		return errors.Trace(err)
 	}
+	m.rw.Lock()
+	m.serviceData = data
+	m.rw.Unlock()
+	return nil
+}
+
+// zookeeper的session过期后临时节点会被删除, 定时检查并重新注册
+func (m *Metadata) ensureService() error {
+	m.rw.RLock()
+	data := m.serviceData
+	m.rw.RUnlock()
+	if data == "" {
+		return nil
+	}
+
+	path := fmt.Sprintf("%s/%d", m.servicePath, m.id)
+	exist, _, err := m.zkConn.Exists(path)
+	if err != nil || exist {
+		return errors.Trace(err)
+	}
+	if err = m.zkConn.CreatePrivate(path, data, zookeeper.Ephemeral); err != nil && !zookeeper.IsExistError(err) {
+		return errors.Trace(err)
+	}
+	log.Warnf("service %d re-registered", m.id)
 	return nil
 }
 
@@ -264,28 +400,37 @@ func (m *Metadata) RefreshMetadata() error {
 		return errors.Trace(err)
 	}
 
-	for _, queue := range queues {
-		data, stat, err := m.zkConn.Get(m.buildQueuePath(queue))
-		if err != nil {
-			log.Errorf("refresh err : %s", err)
-			return errors.Trace(err)
-		}
+	topics, err := m.LocalManager().Topics()
+	if err != nil {
+		log.Errorf("refresh get topics err : %s", err)
+		return errors.Trace(err)
+	}
+	existTopics := make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		existTopics[topic] = true
+	}
 
-		exist, err := m.LocalManager().ExistTopic(queue)
-		if err != nil {
-			log.Errorf("refresh test ExistTopic err : %s", err)
-			return errors.Trace(err)
+	paths := make([]string, len(queues))
+	for i, queue := range queues {
+		paths[i] = m.buildQueuePath(queue)
+	}
+	for i, node := range m.zkConn.GetMulti(paths, refreshConcurrency) {
+		queue := queues[i]
+		if node.Err != nil {
+			log.Errorf("refresh err : %s", node.Err)
+			return errors.Trace(node.Err)
 		}
-		if !exist {
+
+		if !existTopics[queue] {
 			log.Errorf("queue : %q has metadata, but has no topic", queue)
 			continue
 		}
 
 		config := QueueConfig{}
 		// 兼容旧版本元数据
-		if err := config.Parse(data); err != nil {
+		if err := config.Parse(node.Data); err != nil {
 			config.Queue = queue
-			config.Ctime = stat.Ctime / 1e3
+			config.Ctime = node.Stat.Ctime / 1e3
 			config.Length = 0
 		}
 		if config.Idcs == nil {
@@ -303,31 +448,26 @@ func (m *Metadata) RefreshMetadata() error {
 		return errors.Trace(err)
 	}
 
-	for _, groupKey := range groupKeys {
-		tokens := strings.Split(groupKey, ".")
-		if len(tokens) != 2 {
-			continue
-		}
-		queueName, groupName := tokens[1], tokens[0]
-		queue, ok := queueConfigs[queueName]
-		if !ok {
-			continue
-		}
-
-		groupDataPath := fmt.Sprintf("%s/%s", m.groupConfigPath, groupKey)
-		data, _, err := m.zkConn.Get(groupDataPath)
-		if err != nil {
-			log.Warnf("get %s err: %s", groupDataPath, err)
+	groupKeys = filterGroupKeys(groupKeys, queueConfigs)
+	paths = make([]string, len(groupKeys))
+	for i, groupKey := range groupKeys {
+		paths[i] = fmt.Sprintf("%s/%s", m.groupConfigPath, groupKey)
+	}
+	for i, node := range m.zkConn.GetMulti(paths, refreshConcurrency) {
+		groupDataPath := paths[i]
+		if node.Err != nil {
+			log.Warnf("get %s err: %s", groupDataPath, node.Err)
 			continue
 		}
 
 		groupConfig := GroupConfig{}
-		if err = groupConfig.Load(data); err != nil {
+		if err = groupConfig.Load(node.Data); err != nil {
 			log.Warnf("Unmarshal %s data err: %s", groupDataPath, err)
 			continue
 		}
 
-		queue.Groups[groupName] = groupConfig
+		tokens := strings.Split(groupKeys[i], ".")
+		queueConfigs[tokens[1]].Groups[tokens[0]] = groupConfig
 	}
 
 	m.rw.Lock()
@@ -336,6 +476,22 @@ func (m *Metadata) RefreshMetadata() error {
 	return nil
 }
 
+// 只保留格式正确并且queue存在的group节点, 节点名为group.queue
+func filterGroupKeys(groupKeys []string, queueConfigs map[string]QueueConfig) []string {
+	keys := make([]string, 0, len(groupKeys))
+	for _, groupKey := range groupKeys {
+		tokens := strings.Split(groupKey, ".")
+		if len(tokens) != 2 {
+			continue
+		}
+		if _, ok := queueConfigs[tokens[1]]; !ok {
+			continue
+		}
+		keys = append(keys, groupKey)
+	}
+	return keys
+}
+
 // reset given queue-group's offset by time
 func (m *Metadata) ResetOffset(queue string, group string, time int64) error {
 	if err := m.RefreshMetadata(); err != nil {
@@ -355,8 +511,29 @@ func (m *Metadata) ResetOffset(queue string, group string, time int64) error {
 }
 
 // add a group to given queue
+// 清空所有机房中queue已有的消息, 并将各个group的offset提交到清空后的位置, queue和group的配置保持不变
+func (m *Metadata) PurgeQueue(queue string) error {
+	if err := m.RefreshMetadata(); err != nil {
+		return errors.Trace(err)
+	}
+
+	groups := m.GetQueueMap()[queue]
+	for idc, manager := range m.managers {
+		offsets, err := manager.PurgeTopic(queue)
+		if err != nil {
+			return errors.Annotatef(err, " at purge idc %s", idc)
+		}
+		for _, group := range groups {
+			if err = manager.CommitOffset(queue, group, offsets); err != nil {
+				return errors.Annotatef(err, " at commit offset of group %s idc %s", group, idc)
+			}
+		}
+	}
+	return nil
+}
+
 func (m *Metadata) AddGroup(group string, queue string,
-	write bool, read bool, url string, ips []string) error {
+	write bool, read bool, url string, ips []string, start string, verified bool) error {
 
 	mu := m.zkConn.NewMutex(m.operationPath)
 	if err := mu.Lock(); err != nil {
@@ -371,14 +548,19 @@ func (m *Metadata) AddGroup(group string, queue string,
 	if exist := m.ExistGroup(queue, group); exist {
 		return errors.AlreadyExistsf("queue : %q, group : %q", queue, group)
 	}
+	if err := m.checkNewGroup(group); err != nil {
+		return err
+	}
 
 	config := GroupConfig{
-		Group: group,
-		Queue: queue,
-		Write: write,
-		Read:  read,
-		Url:   url,
-		Ips:   ips,
+		Group:    group,
+		Queue:    queue,
+		Write:    write,
+		Read:     read,
+		Url:      url,
+		Ips:      ips,
+		Start:    start,
+		Verified: verified,
 	}
 
 	data := config.String()
@@ -412,12 +594,29 @@ func (m *Metadata) DeleteGroup(group string, queue string) error {
 	if err := m.zkConn.DeleteRecursive(path); err != nil {
 		return errors.Trace(err)
 	}
+	if err := m.zkConn.DeleteRecursive(m.buildSnapshotPath(group, queue)); err != nil && !zookeeper.IsNoNode(err) {
+		log.Warnf("delete offset snapshots of queue %q group %q error: %s", queue, group, err)
+	}
 	return nil
 }
 
 // update given group config
 func (m *Metadata) UpdateGroupConfig(group string, queue string,
-	write bool, read bool, url string, ips []string) error {
+	write bool, read bool, url string, ips []string, start string, verified bool) error {
+
+	return m.ModifyGroupConfig(group, queue, func(config *GroupConfig) error {
+		config.Write = write
+		config.Read = read
+		config.Url = url
+		config.Ips = ips
+		config.Start = start
+		config.Verified = verified
+		return nil
+	})
+}
+
+// 在zookeeper锁的保护下修改group的配置, 未修改的字段保持原值
+func (m *Metadata) ModifyGroupConfig(group string, queue string, modify func(*GroupConfig) error) error {
 
 	mu := m.zkConn.NewMutex(m.operationPath)
 	if err := mu.Lock(); err != nil {
@@ -425,29 +624,67 @@ func (m *Metadata) UpdateGroupConfig(group string, queue string,
 	}
 	defer mu.Unlock()
 
-	if err := m.RefreshMetadata(); err != nil {
+	path := m.buildConfigPath(group, queue)
+	data, _, err := m.zkConn.Get(path)
+	if err != nil {
+		if zookeeper.IsNoNode(err) {
+			return errors.NotFoundf("queue : %q, group: %q", queue, group)
+		}
 		return errors.Trace(err)
 	}
 
-	if exist := m.ExistGroup(queue, group); !exist {
-		return errors.NotFoundf("queue : %q, group: %q", queue, group)
+	config := GroupConfig{}
+	if err = config.Load(data); err != nil {
+		return errors.Annotatef(err, "unmarshal %s", path)
 	}
-
-	path := m.buildConfigPath(group, queue)
-	config := GroupConfig{
-		Group: group,
-		Queue: queue,
-		Write: write,
-		Read:  read,
-		Url:   url,
-		Ips:   ips,
+	config.Group, config.Queue = group, queue
+	if err = modify(&config); err != nil {
+		return err
 	}
-	data := config.String()
+
+	data = []byte(config.String())
 	log.Debugf("update group config, zk path:%s, data:%s", path, data)
-	if err := m.zkConn.Set(path, data); err != nil {
+	if err = m.zkConn.Set(path, string(data)); err != nil {
 		return errors.Trace(err)
 	}
-	return nil
+	return errors.Trace(m.RefreshMetadata())
+}
+
+// 在zookeeper锁的保护下修改queue的配置, 未修改的字段保持原值
+func (m *Metadata) ModifyQueueConfig(queue string, modify func(*QueueConfig) error) error {
+
+	mu := m.zkConn.NewMutex(m.operationPath)
+	if err := mu.Lock(); err != nil {
+		return errors.Trace(err)
+	}
+	defer mu.Unlock()
+
+	path := m.buildQueuePath(queue)
+	data, stat, err := m.zkConn.Get(path)
+	if err != nil {
+		if zookeeper.IsNoNode(err) {
+			return errors.NotFoundf("queue : %q", queue)
+		}
+		return errors.Trace(err)
+	}
+
+	config := QueueConfig{}
+	// 兼容旧版本元数据
+	if err = config.Parse(data); err != nil {
+		config.Ctime = stat.Ctime / 1e3
+	}
+	config.Queue = queue
+	if err = modify(&config); err != nil {
+		return err
+	}
+	// group的配置保存在groupconfig节点下
+	config.Groups = nil
+
+	log.Debugf("update queue config, zk path:%s, data:%s", path, config.String())
+	if err = m.zkConn.Set(path, config.String()); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(m.RefreshMetadata())
 }
 
 //TODO 回头修改HTTP API时同时修改返回的数据结构，能够最大化简化逻辑
@@ -462,18 +699,7 @@ func (m *Metadata) GetQueueInfo(queues ...string) ([]*QueueInfo, error) {
 			return queueInfos, errors.NotFoundf("queue: %q", queue)
 		}
 
-		queueInfo := QueueInfo{
-			Queue:  queue,
-			Ctime:  queueConfig.Ctime,
-			Length: queueConfig.Length,
-			Groups: make([]GroupConfig, 0),
-		}
-
-		for _, groupConfig := range queueConfig.Groups {
-			queueInfo.Groups = append(queueInfo.Groups, groupConfig)
-		}
-		sort.Sort(groupSlice(queueInfo.Groups))
-		queueInfos = append(queueInfos, &queueInfo)
+		queueInfos = append(queueInfos, newQueueInfo(queue, &queueConfig))
 	}
 
 	sort.Sort(queueInfoSlice(queueInfos))
@@ -481,6 +707,39 @@ func (m *Metadata) GetQueueInfo(queues ...string) ([]*QueueInfo, error) {
 	return queueInfos, nil
 }
 
+// 每个queue只包含自己的group, 按group名字排序
+func newQueueInfo(queue string, config *QueueConfig) *QueueInfo {
+	info := &QueueInfo{
+		Queue:      queue,
+		Ctime:      config.Ctime,
+		Length:     config.Length,
+		Groups:     make([]GroupConfig, 0),
+		Limit:      config.Limit,
+		Quota:      config.Quota,
+		TTL:        config.TTL,
+		Idempotent: config.Idempotent,
+		Mirror:     config.Mirror,
+		Schema:     config.Schema,
+		Ordered:    config.Ordered,
+		Expire:     config.Expire,
+		Profile:    config.Profile,
+		Tags:       config.Tags,
+		Consumer:   config.Consumer,
+		Size:       config.Size,
+		Encryption: config.Encryption,
+		Trace:      config.Trace,
+		Priority:   config.Priority,
+		Compact:    config.Compact,
+		Overflow:   config.Overflow,
+	}
+
+	for _, groupConfig := range config.Groups {
+		info.Groups = append(info.Groups, groupConfig)
+	}
+	sort.Sort(groupSlice(info.Groups))
+	return info
+}
+
 // 没有深拷贝，目前貌似不需要
 func (m *Metadata) GetQueueConfig(queue string) *QueueConfig {
 	m.rw.RLock()
@@ -538,7 +797,7 @@ func (m *Metadata) GetQueueMap() map[string][]string {
 }
 
 //Add a queue by name. if want use multi idc, pass idc names in `idcs`
-func (m *Metadata) AddQueue(queue string, idcs []string) error {
+func (m *Metadata) AddQueue(queue string, opts QueueOptions) error {
 
 	mu := m.zkConn.NewMutex(m.operationPath)
 	if err := mu.Lock(); err != nil {
@@ -553,7 +812,11 @@ func (m *Metadata) AddQueue(queue string, idcs []string) error {
 	if exist := m.ExistQueue(queue); exist {
 		return errors.AlreadyExistsf("queue: %q ", queue)
 	}
+	if err := m.checkNewQueue(queue); err != nil {
+		return err
+	}
 
+	idcs := opts.Idcs
 	if len(idcs) == 0 {
 		idcs = []string{m.local}
 	}
@@ -569,21 +832,59 @@ func (m *Metadata) AddQueue(queue string, idcs []string) error {
 		}
 	}
 
+	profile := &Profile{Partitions: m.partitions, Replications: m.replications}
+	if opts.Profile != "" {
+		p, ok := m.getProfile(opts.Profile)
+		if !ok {
+			return errors.NotFoundf("profile: %q", opts.Profile)
+		}
+		profile = p
+	}
+
+	// 顺序queue只有一个partition
+	partitions := profile.Partitions
+	if opts.Ordered {
+		partitions = 1
+	}
+
 	// 缺乏出错回滚
 	for _, idc := range idcs {
 		manager := m.managers[idc]
 		if exist, _ := manager.ExistTopic(queue); exist {
+			if !opts.Ordered {
+				continue
+			}
+			ps, err := manager.Partitions(queue)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if len(ps) != 1 {
+				return errors.NotValidf("ordered queue %q has %d partitions at idc %q", queue, len(ps), idc)
+			}
 			continue
 		}
-		if err := manager.CreateTopic(queue, m.replications, m.partitions); err != nil {
+		topicConfig := profile.topicConfig()
+		if opts.Compact {
+			topicConfig["cleanup.policy"] = "compact"
+		}
+		if err := manager.CreateTopicWithConfig(queue, profile.Replications, partitions, topicConfig); err != nil {
 			return errors.Trace(err)
 		}
 	}
 
 	config := &QueueConfig{
-		Queue: queue,
-		Ctime: time.Now().Unix(),
-		Idcs:  idcs,
+		Queue:   queue,
+		Ctime:   time.Now().Unix(),
+		Idcs:    idcs,
+		Ordered: opts.Ordered,
+		Expire:  opts.Expire,
+		Compact: opts.Compact,
+	}
+	if len(opts.Tags) != 0 {
+		config.Tags = opts.Tags
+	}
+	if opts.Profile != "" {
+		profile.apply(config)
 	}
 
 	if err := m.zkConn.CreateRecursive(m.buildQueuePath(queue), config.String(), 0); err != nil {
@@ -622,7 +923,7 @@ func (m *Metadata) DelQueue(queue string) error {
 	if err := m.LocalManager().DeleteTopic(queue); err != nil {
 		return errors.Trace(err)
 	}
-	return nil
+	return m.deletePriorityTopics(queue)
 }
 
 //Get all queues' name
@@ -691,8 +992,141 @@ func (m *Metadata) LoadMetrics() ([]byte, error) {
 	return data, err
 }
 
+// 设置了优先级的queue包括各个子topic
 func (m *Metadata) Accumulation(queue, group string) (int64, int64, error) {
-	return m.LocalManager().Accumulation(queue, group)
+	total, consumed, err := m.LocalManager().Accumulation(queue, group)
+	if err != nil {
+		return 0, 0, err
+	}
+	for level := 1; level < priorityLevels(m.GetQueueConfig(queue)); level++ {
+		t, c, err := m.LocalManager().Accumulation(priorityTopic(queue, level), group)
+		if err != nil {
+			return 0, 0, err
+		}
+		total, consumed = total+t, consumed+c
+	}
+	return total, consumed, nil
+}
+
+// 检查元数据使用的zookeeper和各个idc的kafka集群
+func (m *Metadata) Health() []HealthStatus {
+	status := make([]HealthStatus, 0, len(m.managers)+1)
+
+	var err error
+	if !m.zkConn.Connected() {
+		err = errors.New("session lost")
+	}
+	status = append(status, newHealthStatus("zookeeper", err))
+
+	for idc, manager := range m.managers {
+		status = append(status, newHealthStatus("kafka."+idc, manager.Ping()))
+	}
+	return status
+}
+
+// 获得queue当前在kafka中保留的消息数
+func (m *Metadata) Depth(queue string) (int64, error) {
+	manager := m.LocalManager()
+	newest, err := manager.FetchTopicOffsets(queue, sarama.OffsetNewest)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	oldest, err := manager.FetchTopicOffsets(queue, sarama.OffsetOldest)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	depth := int64(0)
+	for partition, offset := range newest {
+		depth += offset - oldest[partition]
+	}
+	return depth, nil
+}
+
+// 获得queue在本地idc写入过的消息总数
+func (m *Metadata) Volume(queue string) (int64, error) {
+	offsets, err := m.LocalManager().FetchTopicOffsets(queue, sarama.OffsetNewest)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	volume := int64(0)
+	for _, offset := range offsets {
+		volume += offset
+	}
+	return volume, nil
+}
+
+// 返回queue在day当天开始时的消息总数, 当天第一次调用时以volume作为基准记录到zookeeper
+func (m *Metadata) DailyBase(queue string, day string, volume int64) (int64, error) {
+	path := fmt.Sprintf("%s/%s", m.quotaPath, queue)
+	data, _, err := m.zkConn.Get(path)
+	if err != nil && !zookeeper.IsNoNode(err) {
+		return 0, errors.Trace(err)
+	}
+
+	base := dailyBase{}
+	if err == nil && base.Load(data) == nil && base.Day == day {
+		return base.Volume, nil
+	}
+
+	base = dailyBase{Day: day, Volume: volume}
+	if err = m.zkConn.CreateOrUpdate(path, base.String(), 0); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return volume, nil
+}
+
+// 丢弃最老的消息时, 本地idc每个partition允许消费的最小offset
+func (m *Metadata) DropFloors(queue string, maxDepth int64) (map[int32]int64, error) {
+	newest, err := m.LocalManager().FetchTopicOffsets(queue, sarama.OffsetNewest)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(newest) == 0 {
+		return nil, nil
+	}
+
+	keep := maxDepth / int64(len(newest))
+	floors := make(map[int32]int64, len(newest))
+	for partition, offset := range newest {
+		floors[partition] = offset - keep
+	}
+	return floors, nil
+}
+
+// 记录一条正在触发的报警, 同一个key的报警会被覆盖
+func (m *Metadata) SaveAlert(key string, data string) error {
+	return m.zkConn.CreateOrUpdate(fmt.Sprintf("%s/%s", m.alertPath, key), data, 0)
+}
+
+// 读取所有报警记录, key为节点名
+func (m *Metadata) Alerts() (map[string]string, error) {
+	keys, _, err := m.zkConn.Children(m.alertPath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	records := make(map[string]string, len(keys))
+	for _, key := range keys {
+		data, _, err := m.zkConn.Get(fmt.Sprintf("%s/%s", m.alertPath, key))
+		if err != nil {
+			if zookeeper.IsNoNode(err) {
+				continue
+			}
+			return nil, errors.Trace(err)
+		}
+		records[key] = string(data)
+	}
+	return records, nil
+}
+
+// 报警恢复后删除对应的记录
+func (m *Metadata) DeleteAlert(key string) error {
+	err := m.zkConn.Delete(fmt.Sprintf("%s/%s", m.alertPath, key))
+	if zookeeper.IsNoNode(err) {
+		err = nil
+	}
+	return err
 }
 
 func (m *Metadata) buildConfigPath(group string, queue string) string {
@@ -710,6 +1144,9 @@ func (m *Metadata) Close() {
 		return
 	}
 	close(m.dying)
+	if m.election != nil {
+		m.election.Close()
+	}
 	m.zkConn.Close()
 	for _, manager := range m.managers {
 		manager.Close()
@@ -729,3 +1166,12 @@ func appendIfNotContains(items []string, it string) []string {
 	}
 	return items
 }
+
+func contains(items []string, it string) bool {
+	for _, item := range items {
+		if item == it {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"sync"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+const (
+	// 所有proxy使用同一个group消费源topic, partition在开启了复制的proxy之间均衡
+	mirrorGroup   = "__wqs_mirror"
+	mirrorBackoff = time.Second
+)
+
+// 负责复制的worker, 每个queue一个
+type mirrorWorker struct {
+	queue    string
+	config   MirrorConfig
+	consumer *kafka.GroupConsumer
+	producer *kafka.Producer
+	dying    chan struct{}
+	done     chan struct{}
+}
+
+func (w *mirrorWorker) run() {
+	defer close(w.done)
+
+	prefix := w.queue + "." + metrics.Mirror + "."
+	for {
+		select {
+		case msg, ok := <-w.consumer.Messages():
+			if !ok {
+				return
+			}
+			if !w.send(msg) {
+				return
+			}
+			w.consumer.MarkOffset(msg, "")
+			metrics.AddCounter(prefix+metrics.Ops, 1)
+			metrics.AddMeter(prefix+metrics.Qps, 1)
+		case err := <-w.consumer.Errors():
+			metrics.AddCounter(prefix+metrics.RecvError, 1)
+			log.Errorf("mirror %q from %q consumer error: %v", w.queue, w.config.From, err)
+		case <-w.dying:
+			return
+		}
+	}
+}
+
+// 写入失败时一直重试, 保证消息按offset顺序提交. 停止时返回false, 该消息不提交
+func (w *mirrorWorker) send(msg *sarama.ConsumerMessage) bool {
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers = append(headers, *h)
+	}
+
+	for {
+		_, _, err := w.producer.Send(w.queue, msg.Key, msg.Value, headers)
+		if err == nil {
+			return true
+		}
+		metrics.AddCounter(w.queue+"."+metrics.Mirror+"."+metrics.CmdSetError, 1)
+		log.Errorf("mirror %q to %q partition %d offset %d error: %v",
+			w.queue, w.config.To, msg.Partition, msg.Offset, err)
+
+		select {
+		case <-time.After(mirrorBackoff):
+		case <-w.dying:
+			return false
+		}
+	}
+}
+
+func (w *mirrorWorker) stop() {
+	close(w.dying)
+	<-w.done
+	if err := w.consumer.Close(); err != nil {
+		log.Errorf("mirror %q close consumer error: %v", w.queue, err)
+	}
+	if err := w.producer.Close(); err != nil {
+		log.Errorf("mirror %q close producer error: %v", w.queue, err)
+	}
+}
+
+// 根据queue配置中的mirror启动或停止复制. mirror段是可选的, 默认不参与复制,
+// 只有mirror.enable=true且为leader的proxy运行worker, 但所有proxy都会上报复制延迟
+type mirrorer struct {
+	enable   bool
+	metadata *Metadata
+	config   *sarama.Config
+
+	mu      sync.Mutex
+	workers map[string]*mirrorWorker
+}
+
+func newMirrorer(conf *config.Config, metadata *Metadata, clusterConfig *sarama.Config) *mirrorer {
+	var enable bool
+	if section, err := conf.GetSection("mirror"); err == nil {
+		enable = section.GetBoolMust("enable", false)
+	}
+
+	return &mirrorer{
+		enable:   enable,
+		metadata: metadata,
+		config:   clusterConfig,
+		workers:  make(map[string]*mirrorWorker),
+	}
+}
+
+func (m *mirrorer) sync() {
+	leader := m.metadata.IsLeader()
+	want := make(map[string]MirrorConfig)
+	for _, queue := range m.metadata.GetQueues() {
+		config := m.metadata.GetQueueConfig(queue)
+		if config == nil || config.Mirror == nil {
+			continue
+		}
+		if lag, err := m.lag(queue, *config.Mirror); err == nil {
+			metrics.AddGauge(queue+"."+metrics.Mirror+"."+metrics.Accum, lag)
+		}
+		if m.enable && leader && !config.Mirror.Paused {
+			want[queue] = *config.Mirror
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for queue, worker := range m.workers {
+		if config, ok := want[queue]; !ok || config != worker.config {
+			worker.stop()
+			delete(m.workers, queue)
+			log.Infof("mirror %q from %q to %q stopped", queue, worker.config.From, worker.config.To)
+		}
+	}
+
+	for queue, config := range want {
+		if _, ok := m.workers[queue]; ok {
+			continue
+		}
+		worker, err := m.start(queue, config)
+		if err != nil {
+			log.Errorf("mirror %q from %q to %q start error: %s", queue, config.From, config.To, errors.ErrorStack(err))
+			continue
+		}
+		m.workers[queue] = worker
+		log.Infof("mirror %q from %q to %q started", queue, config.From, config.To)
+	}
+}
+
+func (m *mirrorer) start(queue string, config MirrorConfig) (*mirrorWorker, error) {
+	from, ok := m.metadata.managers[config.From]
+	if !ok {
+		return nil, errors.NotFoundf("idc: %q", config.From)
+	}
+	to, ok := m.metadata.managers[config.To]
+	if !ok {
+		return nil, errors.NotFoundf("idc: %q", config.To)
+	}
+
+	consumer, err := kafka.NewGroupConsumer(from.BrokerAddrs(), mirrorGroup, queue, withSASL(m.config, from.Config()))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	producer, err := kafka.NewProducer(to.BrokerAddrs(), withSASL(m.config, to.Config()))
+	if err != nil {
+		consumer.Close()
+		return nil, errors.Trace(err)
+	}
+
+	worker := &mirrorWorker{
+		queue:    queue,
+		config:   config,
+		consumer: consumer,
+		producer: producer,
+		dying:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go worker.run()
+	return worker, nil
+}
+
+// 源topic中尚未复制的消息数
+func (m *mirrorer) lag(queue string, config MirrorConfig) (int64, error) {
+	manager, ok := m.metadata.managers[config.From]
+	if !ok {
+		return 0, errors.NotFoundf("idc: %q", config.From)
+	}
+	total, consumed, err := manager.Accumulation(queue, mirrorGroup)
+	if err != nil {
+		return 0, err
+	}
+	return total - consumed, nil
+}
+
+func (m *mirrorer) running(queue string) bool {
+	m.mu.Lock()
+	_, ok := m.workers[queue]
+	m.mu.Unlock()
+	return ok
+}
+
+func (m *mirrorer) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for queue, worker := range m.workers {
+		worker.stop()
+		delete(m.workers, queue)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+const maxNameLength = 20
+
+var nameChars = regexp.MustCompile(`^[a-zA-Z0-9_]*$`)
+
+// 内部使用的topic和group的前缀, 例如广播topic(__wqs_bc.), mirror group(__wqs_mirror)
+// 和消息轨迹topic(wqs_msgtrace), 新建的queue和group不能使用, 不区分大小写
+var reservedNamePrefixes = []string{"__", "wqs_"}
+
+// 返回queue和group名字不合法的原因, 合法时返回空. 所有接口使用相同的规则
+func InvalidNameReason(name string) string {
+	switch {
+	case name == "":
+		return "is empty"
+	case len(name) > maxNameLength:
+		return fmt.Sprintf("is longer than %d", maxNameLength)
+	case !nameChars.MatchString(name):
+		return "should only contain letters, digits or underscores"
+	}
+	return ""
+}
+
+// kind为queue, group等, 用于错误信息
+func checkName(kind string, name string) error {
+	if reason := InvalidNameReason(name); reason != "" {
+		return errors.NewNotValid(nil, fmt.Sprintf("%s %q %s", kind, name, reason))
+	}
+	return nil
+}
+
+func checkGroupName(group string, queue string) error {
+	if err := checkName("group", group); err != nil {
+		return err
+	}
+	return checkName("queue", queue)
+}
+
+// 新建的queue和group还要检查保留前缀, 已经存在的不受影响
+func checkNewName(kind string, name string) error {
+	if err := checkName(kind, name); err != nil {
+		return err
+	}
+	lower := strings.ToLower(name)
+	for _, prefix := range reservedNamePrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return errors.NewNotValid(nil, fmt.Sprintf("%s %q uses reserved prefix %q", kind, name, prefix))
+		}
+	}
+	return nil
+}
+
+// topic名字区分大小写, 只有大小写不同的名字容易混淆, 返回已存在的这样的queue, 没有时返回空
+func (m *Metadata) queueCaseConflict(queue string) string {
+	m.rw.RLock()
+	defer m.rw.RUnlock()
+	for name := range m.queueConfigs {
+		if name != queue && strings.EqualFold(name, queue) {
+			return name
+		}
+	}
+	return ""
+}
+
+// group可以订阅多个queue, 在所有queue的group中查找
+func (m *Metadata) groupCaseConflict(group string) string {
+	m.rw.RLock()
+	defer m.rw.RUnlock()
+	for _, config := range m.queueConfigs {
+		for name := range config.Groups {
+			if name != group && strings.EqualFold(name, group) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// 新建queue时在metadata锁中检查, 只有大小写不同视为已存在
+func (m *Metadata) checkNewQueue(queue string) error {
+	if err := checkNewName("queue", queue); err != nil {
+		return err
+	}
+	if name := m.queueCaseConflict(queue); name != "" {
+		return errors.NewAlreadyExists(nil, fmt.Sprintf("queue %q already exists as %q", queue, name))
+	}
+	return nil
+}
+
+func (m *Metadata) checkNewGroup(group string) error {
+	if err := checkNewName("group", group); err != nil {
+		return err
+	}
+	if name := m.groupCaseConflict(group); name != "" {
+		return errors.NewAlreadyExists(nil, fmt.Sprintf("group %q already exists as %q", group, name))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+func TestInvalidNameReason(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"remind":                true,
+		"Feed_2":                true,
+		"":                      false,
+		"a-b":                   false,
+		"a.p1":                  false,
+		"中文":                    false,
+		strings.Repeat("a", 20): true,
+		strings.Repeat("a", 21): false,
+	} {
+		if reason := InvalidNameReason(name); (reason == "") != valid {
+			t.Errorf("name %q reason %q, want valid %v", name, reason, valid)
+		}
+	}
+}
+
+func TestCheckNewName(t *testing.T) {
+	for _, name := range []string{"__consumer", "wqs_msgtrace", "WQS_x"} {
+		if err := checkNewName("queue", name); !errors.IsNotValid(err) {
+			t.Errorf("new queue %q err: %v, want not valid", name, err)
+		}
+		// 已经存在的queue仍然可以使用
+		if err := checkName("queue", name); err != nil {
+			t.Errorf("queue %q err: %v", name, err)
+		}
+	}
+	if err := checkNewName("group", "my_wqs_group"); err != nil {
+		t.Errorf("new group err: %v", err)
+	}
+}
+
+func TestCaseConflict(t *testing.T) {
+	m := &Metadata{queueConfigs: map[string]QueueConfig{
+		"Remind": {Groups: map[string]GroupConfig{"IF": {}}},
+	}}
+	if err := m.checkNewQueue("remind"); !errors.IsAlreadyExists(err) {
+		t.Errorf("new queue remind err: %v, want already exists", err)
+	}
+	if err := m.checkNewQueue("remind2"); err != nil {
+		t.Errorf("new queue remind2 err: %v", err)
+	}
+	if err := m.checkNewGroup("if"); !errors.IsAlreadyExists(err) {
+		t.Errorf("new group if err: %v, want already exists", err)
+	}
+	// 已有的group订阅其他queue
+	if err := m.checkNewGroup("IF"); err != nil {
+		t.Errorf("new group IF err: %v", err)
+	}
+
+	q := &queueImp{}
+	_, err := q.ApplyGroups([]GroupConfig{{Group: "g1", Queue: "q1"}, {Group: "G1", Queue: "q2"}})
+	if !errors.IsNotValid(err) {
+		t.Errorf("apply groups differ in case err: %v, want not valid", err)
+	}
+}
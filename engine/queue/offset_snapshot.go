@@ -0,0 +1,263 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/weibocom/wqs/engine/zookeeper"
+	"github.com/weibocom/wqs/log"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// 每个group最多保存的offset快照数
+const maxOffsetSnapshots = 32
+
+// group已经提交的offset快照, 按机房、topic(包括优先级子topic)和partition保存, 没有提交过的partition为-1.
+// 保存在zookeeper中, 删除group时一起删除
+type OffsetSnapshot struct {
+	Name    string                                `json:"name"`
+	Queue   string                                `json:"queue"`
+	Group   string                                `json:"group"`
+	Ctime   int64                                 `json:"ctime"`
+	Offsets map[string]map[string]map[int32]int64 `json:"offsets"`
+}
+
+func (s *OffsetSnapshot) String() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// 恢复时的offset限制在partition现有消息的范围内, 已经被清理的位置从最早的消息开始,
+// 避免越界后按group的起始位置跳到最新. 没有提交过的partition不恢复
+func restoreOffsets(saved map[int32]int64, oldest map[int32]int64, newest map[int32]int64) map[int32]int64 {
+	offsets := make(map[int32]int64, len(saved))
+	for partition, offset := range saved {
+		low, ok := oldest[partition]
+		if !ok || offset < 0 {
+			continue
+		}
+		if offset < low {
+			offset = low
+		}
+		if high := newest[partition]; offset > high {
+			offset = high
+		}
+		offsets[partition] = offset
+	}
+	return offsets
+}
+
+func (m *Metadata) buildSnapshotPath(group string, queue string) string {
+	return m.snapshotPath + "/" + group + "." + queue
+}
+
+// 读取group在各个机房的queue及优先级子topic上已经提交的offset
+func (m *Metadata) fetchOffsetSnapshot(queue string, group string) (map[string]map[string]map[int32]int64, error) {
+	levels := priorityLevels(m.GetQueueConfig(queue))
+	snapshot := make(map[string]map[string]map[int32]int64, len(m.managers))
+	for idc, manager := range m.managers {
+		topics := make(map[string]map[int32]int64, levels)
+		for level := 0; level < levels; level++ {
+			topic := priorityTopic(queue, level)
+			offsets, err := manager.FetchGroupOffsets(topic, group)
+			if err != nil {
+				return nil, errors.Annotatef(err, " at fetch offset of topic %s idc %s", topic, idc)
+			}
+			topics[topic] = offsets
+		}
+		snapshot[idc] = topics
+	}
+	return snapshot, nil
+}
+
+func (m *Metadata) SaveOffsetSnapshot(s *OffsetSnapshot) error {
+	parent := m.buildSnapshotPath(s.Group, s.Queue)
+	if err := m.zkConn.CreateRecursiveIgnoreExist(parent, "", 0); err != nil {
+		return errors.Trace(err)
+	}
+	names, _, err := m.zkConn.Children(parent)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(names) >= maxOffsetSnapshots {
+		return errors.NotValidf("snapshots of queue %q group %q more than %d", s.Queue, s.Group, maxOffsetSnapshots)
+	}
+
+	err = m.zkConn.Create(parent+"/"+s.Name, s.String(), 0)
+	if zookeeper.IsExistError(err) {
+		return errors.AlreadyExistsf("snapshot : %q", s.Name)
+	}
+	return errors.Trace(err)
+}
+
+func (m *Metadata) GetOffsetSnapshot(queue string, group string, name string) (*OffsetSnapshot, error) {
+	path := fmt.Sprintf("%s/%s", m.buildSnapshotPath(group, queue), name)
+	data, _, err := m.zkConn.Get(path)
+	if err != nil {
+		if zookeeper.IsNoNode(err) {
+			return nil, errors.NotFoundf("snapshot : %q", name)
+		}
+		return nil, errors.Trace(err)
+	}
+
+	s := &OffsetSnapshot{}
+	if err = json.Unmarshal(data, s); err != nil {
+		return nil, errors.Annotatef(err, "unmarshal %s", path)
+	}
+	s.Name, s.Queue, s.Group = name, queue, group
+	return s, nil
+}
+
+// 按创建时间排序
+func (m *Metadata) GetOffsetSnapshots(queue string, group string) ([]*OffsetSnapshot, error) {
+	names, _, err := m.zkConn.Children(m.buildSnapshotPath(group, queue))
+	if err != nil {
+		if zookeeper.IsNoNode(err) {
+			return []*OffsetSnapshot{}, nil
+		}
+		return nil, errors.Trace(err)
+	}
+
+	snapshots := make([]*OffsetSnapshot, 0, len(names))
+	for _, name := range names {
+		s, err := m.GetOffsetSnapshot(queue, group, name)
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				log.Warnf("load snapshot %q of queue %q group %q error: %s", name, queue, group, err)
+			}
+			continue
+		}
+		snapshots = append(snapshots, s)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].Ctime != snapshots[j].Ctime {
+			return snapshots[i].Ctime < snapshots[j].Ctime
+		}
+		return snapshots[i].Name < snapshots[j].Name
+	})
+	return snapshots, nil
+}
+
+func (m *Metadata) DeleteOffsetSnapshot(queue string, group string, name string) error {
+	path := fmt.Sprintf("%s/%s", m.buildSnapshotPath(group, queue), name)
+	if err := m.zkConn.Delete(path); err != nil {
+		if zookeeper.IsNoNode(err) {
+			return errors.NotFoundf("snapshot : %q", name)
+		}
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// 将group在各个机房的offset恢复到快照的位置, 快照中没有的机房和topic保持不变.
+// 与ResetOffset相同, group中有正在消费的consumer时kafka会拒绝提交
+func (m *Metadata) RestoreOffsetSnapshot(s *OffsetSnapshot) error {
+	if err := m.RefreshMetadata(); err != nil {
+		return errors.Trace(err)
+	}
+
+	for idc, manager := range m.managers {
+		topics, ok := s.Offsets[idc]
+		if !ok {
+			log.Warnf("snapshot %q of queue %q group %q has no idc %s", s.Name, s.Queue, s.Group, idc)
+			continue
+		}
+		for topic, saved := range topics {
+			oldest, err := manager.FetchTopicOffsets(topic, sarama.OffsetOldest)
+			if err != nil {
+				return errors.Annotatef(err, " at fetch offset of topic %s idc %s", topic, idc)
+			}
+			newest, err := manager.FetchTopicOffsets(topic, sarama.OffsetNewest)
+			if err != nil {
+				return errors.Annotatef(err, " at fetch offset of topic %s idc %s", topic, idc)
+			}
+			offsets := restoreOffsets(saved, oldest, newest)
+			if len(offsets) == 0 {
+				continue
+			}
+			if err = manager.CommitOffset(topic, s.Group, offsets); err != nil {
+				return errors.Annotatef(err, " at restore offset of topic %s idc %s", topic, idc)
+			}
+		}
+	}
+	return nil
+}
+
+// 记录group当前已经提交的offset, 名字与queue和group的规则相同
+func (q *queueImp) SnapshotOffsets(queue string, group string, name string) (*OffsetSnapshot, error) {
+
+	if err := checkName("snapshot", name); err != nil {
+		return nil, err
+	}
+	if ok := q.metadata.ExistGroup(queue, group); !ok {
+		return nil, errors.NotFoundf("queue : %q , group: %q", queue, group)
+	}
+
+	offsets, err := q.metadata.fetchOffsetSnapshot(queue, group)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s := &OffsetSnapshot{
+		Name:    name,
+		Queue:   queue,
+		Group:   group,
+		Ctime:   time.Now().Unix(),
+		Offsets: offsets,
+	}
+	if err = q.metadata.SaveOffsetSnapshot(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (q *queueImp) OffsetSnapshots(queue string, group string) ([]*OffsetSnapshot, error) {
+	if ok := q.metadata.ExistGroup(queue, group); !ok {
+		return nil, errors.NotFoundf("queue : %q , group: %q", queue, group)
+	}
+	return q.metadata.GetOffsetSnapshots(queue, group)
+}
+
+func (q *queueImp) GetOffsetSnapshot(queue string, group string, name string) (*OffsetSnapshot, error) {
+	return q.metadata.GetOffsetSnapshot(queue, group, name)
+}
+
+func (q *queueImp) DeleteOffsetSnapshot(queue string, group string, name string) error {
+	return q.metadata.DeleteOffsetSnapshot(queue, group, name)
+}
+
+// 将group的消费位置恢复到快照的位置, 用于回滚有问题的consumer上线
+func (q *queueImp) RestoreOffsets(queue string, group string, name string) error {
+
+	if ok := q.metadata.ExistGroup(queue, group); !ok {
+		return errors.NotFoundf("queue : %q , group: %q", queue, group)
+	}
+	s, err := q.metadata.GetOffsetSnapshot(queue, group, name)
+	if err != nil {
+		return err
+	}
+	if err = q.metadata.RestoreOffsetSnapshot(s); err != nil {
+		log.Errorf("restore offset queue %q group %q snapshot %q error %s", queue, group, name, errors.ErrorStack(err))
+		return errors.Trace(err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+func TestRestoreOffsets(t *testing.T) {
+	saved := map[int32]int64{0: 5, 1: 50, 2: 200, 3: -1, 4: 10}
+	oldest := map[int32]int64{0: 10, 1: 0, 2: 0, 3: 0}
+	newest := map[int32]int64{0: 100, 1: 100, 2: 100, 3: 100}
+	expected := map[int32]int64{0: 10, 1: 50, 2: 100}
+	if offsets := restoreOffsets(saved, oldest, newest); !reflect.DeepEqual(offsets, expected) {
+		t.Errorf("restore offsets %v, expect %v", offsets, expected)
+	}
+}
+
+func TestSnapshotOffsetsInvalid(t *testing.T) {
+	q := &queueImp{
+		metadata: &Metadata{queueConfigs: map[string]QueueConfig{
+			"q1": {Groups: map[string]GroupConfig{"g1": {}}},
+		}},
+	}
+	if _, err := q.SnapshotOffsets("q1", "g1", "bad name"); !errors.IsNotValid(err) {
+		t.Errorf("snapshot with bad name err: %v, want not valid", err)
+	}
+	if _, err := q.SnapshotOffsets("q1", "g2", "s1"); !errors.IsNotFound(err) {
+		t.Errorf("snapshot of unknown group err: %v, want not found", err)
+	}
+	if err := q.RestoreOffsets("q2", "g1", "s1"); !errors.IsNotFound(err) {
+		t.Errorf("restore unknown queue err: %v, want not found", err)
+	}
+}
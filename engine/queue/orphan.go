@@ -0,0 +1,367 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/juju/errors"
+)
+
+const defaultOrphanInterval = time.Hour
+
+// 没有queue元数据的topic
+type OrphanTopic struct {
+	Idc   string `json:"idc"`
+	Topic string `json:"topic"`
+}
+
+// 元数据中有, 但所在机房的kafka中没有topic的queue
+type OrphanQueue struct {
+	Queue string `json:"queue"`
+	Idc   string `json:"idc"`
+}
+
+// 超过orphan.group.idle.days没有提交过offset且没有成员的group, LastCommit为最后一次提交的毫秒时间戳
+type IdleGroup struct {
+	Queue      string `json:"queue"`
+	Group      string `json:"group"`
+	LastCommit int64  `json:"last_commit"`
+}
+
+type OrphanReport struct {
+	Topics []OrphanTopic `json:"topics"`
+	Queues []OrphanQueue `json:"queues"`
+	Groups []IdleGroup   `json:"groups"`
+}
+
+func (r *OrphanReport) keys() []string {
+	keys := make([]string, 0, len(r.Topics)+len(r.Queues)+len(r.Groups))
+	for _, t := range r.Topics {
+		keys = append(keys, "topic:"+t.Idc+":"+t.Topic)
+	}
+	for _, q := range r.Queues {
+		keys = append(keys, "queue:"+q.Idc+":"+q.Queue)
+	}
+	for _, g := range r.Groups {
+		keys = append(keys, "group:"+g.Queue+":"+g.Group)
+	}
+	return keys
+}
+
+// leader定期检查孤立的资源, 记录日志和Orphan.*指标, orphan.clean开启时清理连续两次检查都存在的资源,
+// 避免删除正在创建的queue(先创建topic再写入元数据). 只在monitoring的goroutine中调用, 因此不需要加锁
+type orphanCollector struct {
+	enable   bool
+	clean    bool
+	interval time.Duration
+	prefix   string
+	idle     time.Duration
+	last     time.Time
+	seen     map[string]time.Time
+	now      func() time.Time
+}
+
+// orphan段是可选的, 默认不开启
+func newOrphanCollector(conf *config.Config) (*orphanCollector, error) {
+	c := &orphanCollector{
+		interval: defaultOrphanInterval,
+		seen:     make(map[string]time.Time),
+		now:      time.Now,
+	}
+	section, err := conf.GetSection("orphan")
+	if err != nil {
+		return c, nil
+	}
+	c.enable = section.GetBoolMust("enable", false)
+	c.clean = section.GetBoolMust("clean", false)
+	c.prefix = section.GetStringMust("topic.prefix", "")
+	c.idle = time.Duration(section.GetInt64Must("group.idle.days", 0)) * 24 * time.Hour
+	if value := section.GetStringMust("interval", ""); value != "" {
+		if c.interval, err = time.ParseDuration(value); err != nil || c.interval <= 0 {
+			return nil, errors.NotValidf("orphan.interval %q", value)
+		}
+	}
+	if c.clean && c.prefix == "" {
+		return nil, errors.NotValidf("orphan.clean without orphan.topic.prefix")
+	}
+	return c, nil
+}
+
+// 距离上次检查超过interval时返回true
+func (c *orphanCollector) due() bool {
+	now := c.now()
+	if !c.enable || now.Sub(c.last) < c.interval {
+		return false
+	}
+	c.last = now
+	return true
+}
+
+// 返回本次和上一次检查都存在的资源, 只保留本次存在的记录
+func (c *orphanCollector) confirmed(report *OrphanReport) map[string]bool {
+	now := c.now()
+	keys := report.keys()
+	current := make(map[string]bool, len(keys))
+	confirmed := make(map[string]bool)
+	for _, key := range keys {
+		current[key] = true
+		if since, ok := c.seen[key]; ok && now.Sub(since) >= c.interval {
+			confirmed[key] = true
+		} else if !ok {
+			c.seen[key] = now
+		}
+	}
+	for key := range c.seen {
+		if !current[key] {
+			delete(c.seen, key)
+		}
+	}
+	return confirmed
+}
+
+// 属于queue的topic, 包括可能存在的所有优先级子topic
+func knownTopics(queues []string, extra ...string) map[string]bool {
+	known := make(map[string]bool, len(queues)+len(extra))
+	for _, queue := range queues {
+		for level := 0; level < maxPriorityLevels; level++ {
+			known[priorityTopic(queue, level)] = true
+		}
+	}
+	for _, topic := range extra {
+		known[topic] = true
+	}
+	return known
+}
+
+// 有前缀(为空时为所有)且不属于任何queue的topic, kafka内部topic除外
+func orphanTopics(topics []string, prefix string, known map[string]bool) []string {
+	orphans := make([]string, 0)
+	for _, topic := range topics {
+		if strings.HasPrefix(topic, "__") || !strings.HasPrefix(topic, prefix) || known[topic] {
+			continue
+		}
+		orphans = append(orphans, topic)
+	}
+	sort.Strings(orphans)
+	return orphans
+}
+
+// 各个机房的kafka中已有的topic
+func (m *Metadata) idcTopics() (map[string][]string, error) {
+	topics := make(map[string][]string, len(m.managers))
+	for idc, manager := range m.managers {
+		t, err := manager.Topics()
+		if err != nil {
+			return nil, errors.Annotatef(err, " at list topics of idc %s", idc)
+		}
+		topics[idc] = t
+	}
+	return topics, nil
+}
+
+// 删除kafka中已经没有topic的queue的元数据, 包括其所有group的配置和offset快照, 不操作kafka
+func (m *Metadata) DropQueueMetadata(queue string) error {
+
+	mu := m.zkConn.NewMutex(m.operationPath)
+	if err := mu.Lock(); err != nil {
+		return errors.Trace(err)
+	}
+	defer mu.Unlock()
+
+	if err := m.RefreshMetadata(); err != nil {
+		return errors.Trace(err)
+	}
+	config := m.GetQueueConfig(queue)
+	if config == nil {
+		return errors.NotFoundf("queue : %q", queue)
+	}
+
+	for group := range config.Groups {
+		if err := m.zkConn.DeleteRecursive(m.buildConfigPath(group, queue)); err != nil {
+			return errors.Trace(err)
+		}
+		m.zkConn.DeleteRecursive(m.buildSnapshotPath(group, queue))
+	}
+	if err := m.zkConn.DeleteRecursive(m.buildQueuePath(queue)); err != nil {
+		return errors.Trace(err)
+	}
+	m.rw.Lock()
+	delete(m.queueConfigs, queue)
+	m.rw.Unlock()
+	return nil
+}
+
+// 检查孤立的资源, 只读取kafka和元数据, 不做修改
+func (q *queueImp) FindOrphans() (*OrphanReport, error) {
+
+	if err := q.metadata.RefreshMetadata(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	idcTopics, err := q.metadata.idcTopics()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	report := &OrphanReport{
+		Topics: make([]OrphanTopic, 0),
+		Queues: make([]OrphanQueue, 0),
+		Groups: make([]IdleGroup, 0),
+	}
+	queueMap := q.metadata.GetQueueMap()
+	queues := make([]string, 0, len(queueMap))
+	for queue := range queueMap {
+		queues = append(queues, queue)
+	}
+	sort.Strings(queues)
+
+	extra := make([]string, 0, 1)
+	if q.msgTracer != nil {
+		extra = append(extra, q.msgTracer.topic)
+	}
+	known := knownTopics(queues, extra...)
+	idcs := make([]string, 0, len(idcTopics))
+	for idc := range idcTopics {
+		idcs = append(idcs, idc)
+	}
+	sort.Strings(idcs)
+	for _, idc := range idcs {
+		for _, topic := range orphanTopics(idcTopics[idc], q.orphans.prefix, known) {
+			report.Topics = append(report.Topics, OrphanTopic{Idc: idc, Topic: topic})
+		}
+	}
+
+	for _, queue := range queues {
+		config := q.metadata.GetQueueConfig(queue)
+		if config == nil {
+			continue
+		}
+		for _, idc := range config.Idcs {
+			topics, ok := idcTopics[idc]
+			if ok && !contains(topics, queue) {
+				report.Queues = append(report.Queues, OrphanQueue{Queue: queue, Idc: idc})
+			}
+		}
+	}
+
+	if q.orphans.idle > 0 {
+		report.Groups = q.idleGroups(queues, queueMap)
+	}
+	return report, nil
+}
+
+// 本机房所有partition最后一次提交都早于idle, 并且没有成员的group. 提交时间未知的group不计入
+func (q *queueImp) idleGroups(queues []string, queueMap map[string][]string) []IdleGroup {
+	manager := q.metadata.LocalManager()
+	deadline := q.orphans.now().Add(-q.orphans.idle).UnixNano() / 1e6
+	idle := make([]IdleGroup, 0)
+	for _, queue := range queues {
+		groups := append([]string(nil), queueMap[queue]...)
+		sort.Strings(groups)
+		for _, group := range groups {
+			commits, err := manager.FetchGroupCommits(queue, group)
+			if err != nil {
+				log.Warnf("orphan fetch commits of queue %q group %q err: %v", queue, group, err)
+				continue
+			}
+			last := int64(0)
+			for _, c := range commits {
+				if c.CommitTime > last {
+					last = c.CommitTime
+				}
+			}
+			if last == 0 || last > deadline {
+				continue
+			}
+			desc, err := manager.DescribeGroup(group)
+			if err != nil || len(desc.Members) > 0 {
+				continue
+			}
+			idle = append(idle, IdleGroup{Queue: queue, Group: group, LastCommit: last})
+		}
+	}
+	return idle
+}
+
+// leader定期执行, orphan.clean开启时清理连续两次检查都存在的资源.
+// 只删除本机房没有topic的queue的元数据, 其他机房缺少topic时只报告
+func (q *queueImp) collectOrphans() {
+	if !q.orphans.due() {
+		return
+	}
+	report, err := q.FindOrphans()
+	if err != nil {
+		log.Warnf("find orphans err: %v", err)
+		return
+	}
+	metrics.AddGauge(metrics.Orphan+".Topics", int64(len(report.Topics)))
+	metrics.AddGauge(metrics.Orphan+".Queues", int64(len(report.Queues)))
+	metrics.AddGauge(metrics.Orphan+".Groups", int64(len(report.Groups)))
+	for _, t := range report.Topics {
+		log.Warnf("orphan topic %q at idc %s has no queue metadata", t.Topic, t.Idc)
+	}
+	for _, o := range report.Queues {
+		log.Warnf("orphan queue %q has no topic at idc %s", o.Queue, o.Idc)
+	}
+	for _, g := range report.Groups {
+		log.Warnf("idle group %q of queue %q last commit at %s", g.Group, g.Queue, time.Unix(0, g.LastCommit*1e6))
+	}
+
+	confirmed := q.orphans.confirmed(report)
+	if !q.orphans.clean {
+		return
+	}
+	for _, t := range report.Topics {
+		if !confirmed["topic:"+t.Idc+":"+t.Topic] {
+			continue
+		}
+		if err := q.metadata.managers[t.Idc].DeleteTopic(t.Topic); err != nil {
+			log.Errorf("delete orphan topic %q at idc %s err: %v", t.Topic, t.Idc, err)
+			continue
+		}
+		metrics.AddCounter(metrics.Orphan+"."+metrics.Cleaned, 1)
+		log.Infof("delete orphan topic %q at idc %s", t.Topic, t.Idc)
+	}
+	for _, o := range report.Queues {
+		if o.Idc != q.metadata.local || !confirmed["queue:"+o.Idc+":"+o.Queue] {
+			continue
+		}
+		if err := q.metadata.DropQueueMetadata(o.Queue); err != nil {
+			log.Errorf("drop metadata of orphan queue %q err: %v", o.Queue, err)
+			continue
+		}
+		metrics.AddCounter(metrics.Orphan+"."+metrics.Cleaned, 1)
+		log.Infof("drop metadata of orphan queue %q", o.Queue)
+	}
+	for _, g := range report.Groups {
+		if !confirmed["group:"+g.Queue+":"+g.Group] {
+			continue
+		}
+		if err := q.metadata.DeleteGroup(g.Group, g.Queue); err != nil {
+			log.Errorf("delete idle group %q of queue %q err: %v", g.Group, g.Queue, err)
+			continue
+		}
+		metrics.AddCounter(metrics.Orphan+"."+metrics.Cleaned, 1)
+		log.Infof("delete idle group %q of queue %q", g.Group, g.Queue)
+	}
+}
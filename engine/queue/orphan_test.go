@@ -0,0 +1,87 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+)
+
+func TestOrphanTopics(t *testing.T) {
+	known := knownTopics([]string{"wqs_q1", "wqs_q2"}, "wqs_msgtrace")
+	topics := []string{"wqs_q1", "wqs_q1.p2", "wqs_q3", "wqs_q3.p1", "wqs_msgtrace", "__consumer_offsets", "other"}
+
+	expected := []string{"wqs_q3", "wqs_q3.p1"}
+	if orphans := orphanTopics(topics, "wqs_", known); !reflect.DeepEqual(orphans, expected) {
+		t.Errorf("orphan topics %v, expect %v", orphans, expected)
+	}
+	expected = []string{"other", "wqs_q3", "wqs_q3.p1"}
+	if orphans := orphanTopics(topics, "", known); !reflect.DeepEqual(orphans, expected) {
+		t.Errorf("orphan topics without prefix %v, expect %v", orphans, expected)
+	}
+}
+
+func TestOrphanCollectorConfirmed(t *testing.T) {
+	now := time.Now()
+	c := &orphanCollector{interval: time.Hour, seen: make(map[string]time.Time), now: func() time.Time { return now }}
+	report := &OrphanReport{
+		Topics: []OrphanTopic{{Idc: "tc", Topic: "t1"}},
+		Groups: []IdleGroup{{Queue: "q1", Group: "g1"}},
+	}
+	if confirmed := c.confirmed(report); len(confirmed) != 0 {
+		t.Fatalf("first seen orphans should not be confirmed, got %v", confirmed)
+	}
+
+	now = now.Add(time.Hour)
+	report.Groups = nil
+	report.Queues = []OrphanQueue{{Queue: "q2", Idc: "tc"}}
+	expected := map[string]bool{"topic:tc:t1": true}
+	if confirmed := c.confirmed(report); !reflect.DeepEqual(confirmed, expected) {
+		t.Errorf("confirmed %v, expect %v", confirmed, expected)
+	}
+	if _, ok := c.seen["group:q1:g1"]; ok {
+		t.Errorf("disappeared orphan should be forgotten")
+	}
+}
+
+func TestNewOrphanCollector(t *testing.T) {
+	conf, err := config.NewConfigFromBytes([]byte(testAlertBaseConfig + "orphan.enable=true\norphan.clean=true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newOrphanCollector(conf); err == nil {
+		t.Error("clean without topic prefix should be invalid")
+	}
+
+	conf, err = config.NewConfigFromBytes([]byte(testAlertBaseConfig + "orphan.enable=true\norphan.interval=10m\norphan.group.idle.days=7\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newOrphanCollector(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.interval != 10*time.Minute || c.idle != 7*24*time.Hour {
+		t.Errorf("interval %v idle %v", c.interval, c.idle)
+	}
+	if !c.due() || c.due() {
+		t.Error("collector should run once per interval")
+	}
+}
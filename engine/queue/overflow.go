@@ -0,0 +1,150 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/engine/kafka"
+
+	"github.com/juju/errors"
+)
+
+// producer缓冲区满时的处理策略
+const (
+	OverflowBlock  = "block"  // 等待缓冲区有空位, 直到请求超时
+	OverflowReject = "reject" // 立即返回ErrOverloaded
+	OverflowDrop   = "drop"   // 丢弃消息并计数, 返回ErrDropped
+)
+
+// producer缓冲区已满, 客户端稍后重试或者换到其他proxy
+var ErrOverloaded = errors.New("producer buffer full")
+
+func IsOverloaded(err error) bool {
+	return errors.Cause(err) == ErrOverloaded
+}
+
+// 消息按drop策略丢弃, 没有写入kafka. 允许丢失的客户端可以忽略, 重试会增加proxy的负载
+var ErrDropped = errors.New("message dropped by overflow policy")
+
+func IsDropped(err error) bool {
+	return errors.Cause(err) == ErrDropped
+}
+
+func validOverflow(policy string) bool {
+	switch policy {
+	case OverflowBlock, OverflowReject, OverflowDrop:
+		return true
+	}
+	return false
+}
+
+// 限制本proxy同时发送中的消息数, 缓冲区满时按queue的策略处理, queue没有设置时使用默认策略.
+// size为0或者nil时不限制
+type producerBuffer struct {
+	slots  chan struct{}
+	policy string
+}
+
+func newProducerBuffer(conf *config.Config) (*producerBuffer, error) {
+	section, err := conf.GetSection("kafka")
+	if err != nil {
+		return nil, nil
+	}
+	size := section.GetInt64Must("producer.buffer.size", 0)
+	policy := section.GetStringMust("producer.overflow", OverflowBlock)
+	if size < 0 {
+		return nil, errors.NotValidf("kafka.producer.buffer.size %d", size)
+	}
+	if !validOverflow(policy) {
+		return nil, errors.NotValidf("kafka.producer.overflow %q", policy)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	return &producerBuffer{slots: make(chan struct{}, size), policy: policy}, nil
+}
+
+// 占用一个位置, 成功后必须调用release. 缓冲区满时block策略等待ctx结束,
+// reject返回ErrOverloaded, drop返回ErrDropped
+func (b *producerBuffer) acquire(ctx context.Context, policy string) error {
+	if b == nil {
+		return nil
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if policy == "" {
+		policy = b.policy
+	}
+	switch policy {
+	case OverflowReject:
+		return ErrOverloaded
+	case OverflowDrop:
+		return ErrDropped
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ErrOverloaded
+	}
+}
+
+// durability=all的消息以及顺序和幂等queue不能丢弃, drop策略按reject处理
+func (b *producerBuffer) noDrop(policy string) string {
+	if b == nil {
+		return policy
+	}
+	if policy == "" {
+		policy = b.policy
+	}
+	if policy == OverflowDrop {
+		return OverflowReject
+	}
+	return policy
+}
+
+// 本次发送的溢出策略, durability=all的消息和使用单独producer的顺序、幂等queue需要保证写入
+func (q *queueImp) overflowPolicy(policy, durability string, producer *kafka.Producer) string {
+	if durability == DurabilityAll || producer != q.producer {
+		return q.buffer.noDrop(policy)
+	}
+	return policy
+}
+
+func (b *producerBuffer) release() {
+	if b == nil {
+		return
+	}
+	<-b.slots
+}
+
+// 设置queue的缓冲区溢出策略, 为空时使用kafka.producer.overflow
+func (q *queueImp) SetQueueOverflow(queue string, policy string) error {
+	if policy != "" && !validOverflow(policy) {
+		return errors.NotValidf("overflow : %q, expect block, reject or drop", policy)
+	}
+	return q.metadata.ModifyQueueConfig(queue, func(config *QueueConfig) error {
+		config.Overflow = policy
+		return nil
+	})
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/engine/kafka"
+
+	"github.com/juju/errors"
+)
+
+func TestProducerBufferConfig(t *testing.T) {
+	for _, c := range []struct {
+		conf    string
+		enabled bool
+		invalid bool
+	}{
+		{"", false, false},
+		{"kafka.producer.overflow=reject\n", false, false},
+		{"kafka.producer.buffer.size=10\n", true, false},
+		{"kafka.producer.buffer.size=-1\n", false, true},
+		{"kafka.producer.buffer.size=10\nkafka.producer.overflow=wait\n", false, true},
+	} {
+		conf, err := config.NewConfigFromBytes([]byte(testAlertBaseConfig + c.conf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := newProducerBuffer(conf)
+		if (b != nil) != c.enabled || errors.IsNotValid(err) != c.invalid {
+			t.Errorf("%q: buffer %v err %v", c.conf, b, err)
+		}
+	}
+}
+
+func TestProducerBufferOverflow(t *testing.T) {
+	b := &producerBuffer{slots: make(chan struct{}, 1), policy: OverflowReject}
+	if err := b.acquire(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.acquire(context.Background(), ""); !IsOverloaded(err) {
+		t.Errorf("default policy should reject, got %v", err)
+	}
+	if err := b.acquire(context.Background(), OverflowDrop); err != ErrDropped {
+		t.Errorf("drop policy should drop, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.acquire(ctx, OverflowBlock); !IsOverloaded(err) {
+		t.Errorf("block policy should fail after context done, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		b.release()
+	}()
+	if err := b.acquire(context.Background(), OverflowBlock); err != nil {
+		t.Errorf("block policy should wait for release, got %v", err)
+	}
+
+	var nilBuffer *producerBuffer
+	if err := nilBuffer.acquire(context.Background(), OverflowReject); err != nil {
+		t.Errorf("nil buffer should not limit, got %v", err)
+	}
+	nilBuffer.release()
+}
+
+// durability=all的消息以及顺序和幂等queue不会被丢弃
+func TestProducerBufferNoDrop(t *testing.T) {
+	b := &producerBuffer{slots: make(chan struct{}, 1), policy: OverflowDrop}
+	for _, c := range []struct{ policy, want string }{
+		{"", OverflowReject},
+		{OverflowDrop, OverflowReject},
+		{OverflowBlock, OverflowBlock},
+		{OverflowReject, OverflowReject},
+	} {
+		if policy := b.noDrop(c.policy); policy != c.want {
+			t.Errorf("noDrop(%q) = %q, want %q", c.policy, policy, c.want)
+		}
+	}
+
+	b.acquire(context.Background(), "")
+	if err := b.acquire(context.Background(), b.noDrop("")); !IsOverloaded(err) || IsDropped(err) {
+		t.Errorf("guaranteed message should be rejected instead of dropped, got %v", err)
+	}
+
+	q := &queueImp{producer: &kafka.Producer{}, buffer: b}
+	for _, c := range []struct {
+		durability string
+		producer   *kafka.Producer
+		want       string
+	}{
+		{DurabilityDefault, q.producer, ""},
+		{DurabilityAll, q.producer, OverflowReject},
+		{DurabilityDefault, &kafka.Producer{}, OverflowReject},
+	} {
+		if policy := q.overflowPolicy("", c.durability, c.producer); policy != c.want {
+			t.Errorf("durability %s: policy %q, want %q", c.durability, policy, c.want)
+		}
+	}
+
+	var nilBuffer *producerBuffer
+	if policy := nilBuffer.noDrop(OverflowDrop); policy != OverflowDrop {
+		t.Errorf("nil buffer noDrop = %q", policy)
+	}
+}
+
+func TestSetQueueOverflow(t *testing.T) {
+	q := &queueImp{}
+	if err := q.SetQueueOverflow("q1", "wait"); !errors.IsNotValid(err) {
+		t.Errorf("expect not valid, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// 指定partition接收本机房的消息, 由客户端自己分配partition和管理offset, 不经过group的consumer, 不需要ACK.
+// offset小于0时从group在该partition已提交的offset开始, 没有提交过时按group的起始位置.
+// 返回的NextOffset为下次接收的位置, 分片消息按kafka中的分片返回
+func (q *queueImp) RecvPartition(ctx context.Context, queue string, group string, partition int32, offset int64, limit int) (*MessagePage, error) {
+
+	config, err := q.metadata.GetGroupConfig(group, queue)
+	if err != nil {
+		return nil, errors.NotFoundf("queue : %q , group: %q", queue, group)
+	}
+	if config.Paused {
+		metrics.AddCounter(queue+"."+group+"."+metrics.CmdGet+"."+metrics.Paused, 1)
+		return nil, errors.Annotatef(ErrGroupPaused, "queue %q group %q", queue, group)
+	}
+	if limit <= 0 || limit > maxPeekCount {
+		return nil, errors.NotValidf("limit : %d", limit)
+	}
+
+	client := clientFrom(ctx)
+	if err := q.limiter.acquire(queue+"."+group,
+		q.limitRequests(queue, group, client, metrics.CmdGet, 1, 0)); err != nil {
+		metrics.AddCounter(metrics.Throttled, 1)
+		metrics.AddCounter(queue+"."+group+"."+metrics.CmdGet+"."+metrics.Throttled, 1)
+		clientThrottled(queue+"."+group+"."+metrics.CmdGet+".", client)
+		return nil, err
+	}
+
+	manager := q.metadata.LocalManager()
+	oldest, newest, err := manager.PartitionOffsets(queue, partition)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		if offset, err = q.committedOffset(queue, group, partition, config); err != nil {
+			return nil, err
+		}
+		if offset == sarama.OffsetNewest {
+			offset = newest
+		}
+	}
+	if offset < oldest {
+		offset = oldest
+	}
+
+	fetched, err := manager.FetchMessages(queue, partition, offset, limit)
+	if err != nil {
+		log.Warnf("recv queue %q group %q partition %d offset %d err: %s", queue, group, partition, offset, err)
+		return nil, errors.Trace(err)
+	}
+	page := &MessagePage{
+		Queue:      queue,
+		Partition:  partition,
+		Oldest:     oldest,
+		Newest:     newest,
+		NextOffset: offset,
+		Messages:   make([]*MessageInfo, 0, len(fetched)),
+	}
+	if offset > newest {
+		page.NextOffset = newest
+	}
+	var bytes int64
+	for _, msg := range fetched {
+		info, err := q.messageInfo(queue, group, msg)
+		if err != nil {
+			return nil, err
+		}
+		bytes += int64(info.Size)
+		page.Messages = append(page.Messages, info)
+		page.NextOffset = msg.Offset + 1
+	}
+
+	prefix := queue + "." + group + "." + metrics.CmdGet + "."
+	metrics.AddCounter(prefix+metrics.Ops, 1)
+	metrics.AddMeter(prefix+metrics.Qps, 1)
+	metrics.AddCounter(metrics.BytesRead, bytes)
+	clientMetrics(prefix, client)
+	return page, nil
+}
+
+// group在本机房partition上已提交的offset, 没有提交过时返回group的起始位置(sarama.OffsetNewest或sarama.OffsetOldest)
+func (q *queueImp) committedOffset(queue string, group string, partition int32, config *GroupConfig) (int64, error) {
+	offsets, err := q.metadata.LocalManager().FetchGroupOffsets(queue, group)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	offset, ok := offsets[partition]
+	if !ok {
+		return 0, errors.NotFoundf("queue %q partition %d", queue, partition)
+	}
+	if offset < 0 {
+		offset = q.groupClusterConfig(config).Consumer.Offsets.Initial
+	}
+	return offset, nil
+}
+
+// 提交group在本机房partition上下次接收的位置, 与RecvPartition配合使用.
+// 有客户端通过普通接口接收该group的消息时kafka会拒绝提交
+func (q *queueImp) CommitPartitionOffset(queue string, group string, partition int32, offset int64) error {
+
+	if ok := q.metadata.ExistGroup(queue, group); !ok {
+		return errors.NotFoundf("queue : %q , group: %q", queue, group)
+	}
+	manager := q.metadata.LocalManager()
+	oldest, newest, err := manager.PartitionOffsets(queue, partition)
+	if err != nil {
+		return err
+	}
+	if offset < oldest || offset > newest {
+		return errors.NotValidf("offset %d of partition %d, available [%d, %d]", offset, partition, oldest, newest)
+	}
+	if err := manager.CommitOffset(queue, group, map[int32]int64{partition: offset}); err != nil {
+		return errors.Trace(err)
+	}
+	log.Debugf("commit %s:%s partition %d offset %d", queue, group, partition, offset)
+	return nil
+}
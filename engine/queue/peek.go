@@ -0,0 +1,214 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"sort"
+
+	"github.com/weibocom/wqs/log"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+const (
+	// 一次最多peek或浏览的消息数
+	maxPeekCount = 100
+	// 浏览消息时默认只返回消息体的前1KB
+	defaultPreviewSize = 1024
+)
+
+// group为空时不生成消息id, 这样的消息只能查看不能ACK
+func newMessageInfo(queue string, group string, idc string, msg *sarama.ConsumerMessage) *MessageInfo {
+	sequence, flag := parseMessageKey(msg.Key)
+	info := &MessageInfo{
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Flag:      flag,
+		Time:      sequenceTime(sequence),
+		Size:      len(msg.Value),
+		Data:      string(msg.Value),
+	}
+	if group != "" {
+		id := messageId{
+			queue:     queue,
+			group:     group,
+			idc:       idc,
+			partition: msg.Partition,
+			offset:    msg.Offset,
+			sequence:  sequence,
+		}
+		info.ID = id.String()
+	}
+	if len(msg.Headers) > 0 {
+		info.Headers = make(map[string]string, len(msg.Headers))
+		for _, h := range msg.Headers {
+			if h != nil {
+				info.Headers[string(h.Key)] = string(h.Value)
+			}
+		}
+	}
+	return info
+}
+
+// 生成MessageInfo并解密消息体, 与接收消息时一致
+func (q *queueImp) messageInfo(queue string, group string, msg *sarama.ConsumerMessage) (*MessageInfo, error) {
+	info := newMessageInfo(queue, group, q.metadata.local, msg)
+	if err := decryptMessageInfo(q.crypto, info, msg); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// 分片在合并前不能解密, 保持原样
+func decryptMessageInfo(crypto *payloadCrypto, info *MessageInfo, msg *sarama.ConsumerMessage) error {
+	if _, _, chunk := parseChunk(msg.Headers); chunk {
+		return nil
+	}
+	data, err := crypto.decrypt(msg.Headers, msg.Value)
+	if err != nil {
+		return err
+	}
+	info.Data, info.Size = string(data), len(data)
+	return nil
+}
+
+// 从group在本机房已提交的offset开始读取最多count条消息, 按partition顺序返回.
+// 不提交offset, 也不经过group的consumer, 不会影响正在消费的客户端
+func (q *queueImp) PeekMessage(queue string, group string, count int) ([]*MessageInfo, error) {
+
+	if ok := q.metadata.ExistGroup(queue, group); !ok {
+		return nil, errors.NotFoundf("queue : %q , group: %q", queue, group)
+	}
+	if count <= 0 || count > maxPeekCount {
+		return nil, errors.NotValidf("count : %d", count)
+	}
+
+	config, _ := q.metadata.GetGroupConfig(group, queue)
+	clusterConfig := q.groupClusterConfig(config)
+	manager := q.metadata.LocalManager()
+	offsets, err := manager.FetchGroupOffsets(queue, group)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	partitions := make([]int, 0, len(offsets))
+	for partition := range offsets {
+		partitions = append(partitions, int(partition))
+	}
+	sort.Ints(partitions)
+
+	msgs := make([]*MessageInfo, 0, count)
+	for _, p := range partitions {
+		partition := int32(p)
+		// 没有提交过offset时与consumer一致, 从最新位置开始时没有可以查看的消息
+		offset := offsets[partition]
+		if offset < 0 {
+			offset = clusterConfig.Consumer.Offsets.Initial
+		}
+		if offset == sarama.OffsetNewest {
+			_, newest, err := manager.PartitionOffsets(queue, partition)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			offset = newest
+		}
+		fetched, err := manager.FetchMessages(queue, partition, offset, count-len(msgs))
+		if err != nil {
+			log.Warnf("peek queue %q group %q partition %d offset %d err: %s", queue, group, partition, offset, err)
+			return nil, errors.Trace(err)
+		}
+		for _, msg := range fetched {
+			info, err := q.messageInfo(queue, group, msg)
+			if err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, info)
+		}
+		if len(msgs) >= count {
+			break
+		}
+	}
+	return msgs, nil
+}
+
+// 只读浏览本机房partition中从offset开始的最多limit条消息, 与group无关.
+// offset小于0时从最早的消息开始, preview为返回的消息体的最大字节数, 0表示使用默认值
+func (q *queueImp) BrowseMessages(queue string, partition int32, offset int64, limit int, preview int) (*MessagePage, error) {
+
+	if !q.metadata.ExistQueue(queue) {
+		return nil, errors.NotFoundf("queue : %q", queue)
+	}
+	if limit <= 0 || limit > maxPeekCount {
+		return nil, errors.NotValidf("limit : %d", limit)
+	}
+	if preview < 0 {
+		return nil, errors.NotValidf("preview : %d", preview)
+	}
+	if preview == 0 {
+		preview = defaultPreviewSize
+	}
+
+	manager := q.metadata.LocalManager()
+	oldest, newest, err := manager.PartitionOffsets(queue, partition)
+	if err != nil {
+		return nil, err
+	}
+	if offset < oldest {
+		offset = oldest
+	}
+
+	fetched, err := manager.FetchMessages(queue, partition, offset, limit)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	page := &MessagePage{
+		Queue:      queue,
+		Partition:  partition,
+		Oldest:     oldest,
+		Newest:     newest,
+		NextOffset: offset,
+		Messages:   make([]*MessageInfo, 0, len(fetched)),
+	}
+	if offset > newest {
+		page.NextOffset = newest
+	}
+	for _, msg := range fetched {
+		info, err := q.messageInfo(queue, "", msg)
+		if err != nil {
+			return nil, err
+		}
+		if len(info.Data) > preview {
+			info.Data = info.Data[:preview]
+		}
+		page.Messages = append(page.Messages, info)
+		page.NextOffset = msg.Offset + 1
+	}
+	return page, nil
+}
+
+// 读取本机房指定位置的一条消息, 返回完整的消息体, 用于根据客户端日志中的offset排查问题
+func (q *queueImp) GetMessageAt(queue string, partition int32, offset int64) (*MessageInfo, error) {
+
+	if !q.metadata.ExistQueue(queue) {
+		return nil, errors.NotFoundf("queue : %q", queue)
+	}
+	msg, err := q.metadata.LocalManager().FetchMessage(queue, partition, offset)
+	if err != nil {
+		return nil, err
+	}
+	return q.messageInfo(queue, "", msg)
+}
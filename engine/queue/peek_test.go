@@ -0,0 +1,84 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestNewMessageInfo(t *testing.T) {
+	sequence := newIDGenerator(0xEE).Get()
+	msg := &sarama.ConsumerMessage{
+		Key:       []byte(fmt.Sprintf("%x:%x", sequence, 2)),
+		Value:     []byte("hello"),
+		Partition: 3,
+		Offset:    100,
+		Headers:   []*sarama.RecordHeader{{Key: []byte("traceparent"), Value: []byte("00-abc")}},
+	}
+
+	info := newMessageInfo("q1", "g1", "idc1", msg)
+	id := &messageId{}
+	if err := id.Parse(info.ID); err != nil || id.partition != 3 || id.offset != 100 || id.group != "g1" {
+		t.Errorf("message id error: %q %+v %v", info.ID, id, err)
+	}
+	if info.Flag != 2 || info.Size != 5 || info.Data != "hello" || info.Time != sequenceTime(sequence) {
+		t.Errorf("message info error: %+v", info)
+	}
+	if info.Headers["traceparent"] != "00-abc" {
+		t.Errorf("message headers error: %v", info.Headers)
+	}
+
+	// 浏览消息时不属于任何group, 不生成id
+	if info = newMessageInfo("q1", "", "idc1", msg); info.ID != "" {
+		t.Errorf("expect empty id without group, got %q", info.ID)
+	}
+}
+
+func TestDecryptMessageInfo(t *testing.T) {
+	crypto := &payloadCrypto{
+		keys:  map[string][]byte{"k1": bytes.Repeat([]byte("k"), 16)},
+		aeads: make(map[string]cipher.AEAD),
+	}
+	data, err := crypto.encrypt("k1", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := &sarama.RecordHeader{Key: []byte(encryptHeader), Value: []byte("k1")}
+	msg := &sarama.ConsumerMessage{Value: data, Headers: []*sarama.RecordHeader{encrypted}}
+	info := newMessageInfo("q1", "", "idc1", msg)
+	if err = decryptMessageInfo(crypto, info, msg); err != nil || info.Data != "hello" || info.Size != 5 {
+		t.Errorf("decrypted message info %+v err %v", info, err)
+	}
+
+	// 分片保持原样
+	chunk := &sarama.RecordHeader{Key: []byte(chunkHeader), Value: []byte("0/2")}
+	msg = &sarama.ConsumerMessage{Value: data, Headers: []*sarama.RecordHeader{encrypted, chunk}}
+	info = newMessageInfo("q1", "", "idc1", msg)
+	if err = decryptMessageInfo(crypto, info, msg); err != nil || info.Data != string(data) {
+		t.Errorf("chunk should not be decrypted: %+v err %v", info, err)
+	}
+
+	msg = &sarama.ConsumerMessage{Value: []byte("broken"), Headers: []*sarama.RecordHeader{encrypted}}
+	if err = decryptMessageInfo(crypto, newMessageInfo("q1", "", "idc1", msg), msg); err == nil {
+		t.Error("expect decrypt error")
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/weibocom/wqs/metrics"
+)
+
+const (
+	// 超过该容量的buffer不放回池中, 避免个别大消息长期占用内存
+	maxPooledBuffer = 64 << 10
+	// 缓存的key超过该数量时整体清空, queue和group被删除后不会无限增长
+	maxCachedKeys = 1 << 16
+)
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 128)
+		return &b
+	},
+}
+
+func getBuffer() *[]byte {
+	b := bufferPool.Get().(*[]byte)
+	*b = (*b)[:0]
+	return b
+}
+
+func putBuffer(b *[]byte) {
+	if cap(*b) > maxPooledBuffer {
+		return
+	}
+	bufferPool.Put(b)
+}
+
+// kafka消息的key, 格式为sequence:flag(十六进制)
+func messageKey(sequence uint64, flag uint64) []byte {
+	key := make([]byte, 0, 33)
+	key = strconv.AppendUint(key, sequence, 16)
+	key = append(key, ':')
+	return strconv.AppendUint(key, flag, 16)
+}
+
+type keyKind uint8
+
+const (
+	keyOwner     keyKind = iota // queue@group
+	keyPrefix                   // queue.group.op.
+	keyMetric                   // queue.group.op.name
+	keyMetricQps                // queue.group.op.name.qps
+	keyLimit                    // queue.group.op@client.name, group和client可以为空
+)
+
+type cacheKey struct {
+	kind   keyKind
+	queue  string
+	group  string
+	op     string
+	client string
+	name   string
+}
+
+func (k cacheKey) String() string {
+	switch k.kind {
+	case keyOwner:
+		return k.queue + "@" + k.group
+	case keyPrefix:
+		return k.queue + "." + k.group + "." + k.op + "."
+	case keyMetric:
+		return k.queue + "." + k.group + "." + k.op + "." + k.name
+	case keyMetricQps:
+		return k.queue + "." + k.group + "." + k.op + "." + k.name + "." + metrics.Qps
+	case keyLimit:
+		s := k.queue
+		if k.group != "" {
+			s += "." + k.group
+		}
+		s += "." + k.op
+		if k.client != "" {
+			s += "@" + k.client
+		}
+		return s + "." + k.name
+	}
+	return ""
+}
+
+// 缓存收发消息时按queue和group拼接的key, 避免每条消息都分配新的字符串.
+// nil的keyCache每次直接拼接
+type keyCache struct {
+	mu   sync.RWMutex
+	keys map[cacheKey]string
+}
+
+func newKeyCache() *keyCache {
+	return &keyCache{keys: make(map[cacheKey]string)}
+}
+
+func (c *keyCache) get(k cacheKey) string {
+	if c == nil {
+		return k.String()
+	}
+	c.mu.RLock()
+	s, ok := c.keys[k]
+	c.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	s = k.String()
+	c.mu.Lock()
+	if len(c.keys) >= maxCachedKeys {
+		c.keys = make(map[cacheKey]string)
+	}
+	c.keys[k] = s
+	c.mu.Unlock()
+	return s
+}
+
+func (c *keyCache) owner(queue string, group string) string {
+	return c.get(cacheKey{kind: keyOwner, queue: queue, group: group})
+}
+
+func (c *keyCache) prefix(queue string, group string, op string) string {
+	return c.get(cacheKey{kind: keyPrefix, queue: queue, group: group, op: op})
+}
+
+func (c *keyCache) metric(queue string, group string, op string, name string) string {
+	return c.get(cacheKey{kind: keyMetric, queue: queue, group: group, op: op, name: name})
+}
+
+func (c *keyCache) metricQps(queue string, group string, op string, name string) string {
+	return c.get(cacheKey{kind: keyMetricQps, queue: queue, group: group, op: op, name: name})
+}
+
+func (c *keyCache) limit(queue string, group string, op string, client string, name string) string {
+	return c.get(cacheKey{kind: keyLimit, queue: queue, group: group, op: op, client: client, name: name})
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/weibocom/wqs/metrics"
+)
+
+func TestMessageKey(t *testing.T) {
+	for _, c := range []struct{ sequence, flag uint64 }{{0, 0}, {1, 3}, {0xdeadbeef12, 0xff}, {^uint64(0), ^uint64(0)}} {
+		if key, expect := string(messageKey(c.sequence, c.flag)), fmt.Sprintf("%x:%x", c.sequence, c.flag); key != expect {
+			t.Errorf("message key expect %s, got %s", expect, key)
+		}
+	}
+}
+
+func TestMessageIdString(t *testing.T) {
+	m := &messageId{queue: "q1", group: "g1", idc: "local", partition: 10, offset: 0x1234, sequence: 0xabcdef}
+	expect := fmt.Sprintf("%x:%s:%s:%x:%x:%s", m.sequence, m.queue, m.group, m.partition, m.offset, m.idc)
+	for i := 0; i < 3; i++ {
+		if id := m.String(); id != expect {
+			t.Fatalf("message id expect %s, got %s", expect, id)
+		}
+	}
+
+	parsed := &messageId{}
+	if err := parsed.Parse(m.String()); err != nil || *parsed != *m {
+		t.Errorf("parse message id error: %+v %v", parsed, err)
+	}
+}
+
+func TestKeyCache(t *testing.T) {
+	cache := newKeyCache()
+	for _, c := range []*keyCache{cache, nil} {
+		if key := c.owner("q1", "g1"); key != "q1@g1" {
+			t.Errorf("owner key: %s", key)
+		}
+		if key := c.prefix("q1", "g1", metrics.CmdSet); key != "q1.g1."+metrics.CmdSet+"." {
+			t.Errorf("prefix key: %s", key)
+		}
+		if key := c.metric("q1", "g1", metrics.CmdGet, metrics.Ops); key != "q1.g1."+metrics.CmdGet+"."+metrics.Ops {
+			t.Errorf("metric key: %s", key)
+		}
+		if key := c.metricQps("q1", "g1", metrics.CmdAck, metrics.ElapseLess10ms); key != "q1.g1."+metrics.CmdAck+"."+metrics.ElapseLess10ms+"."+metrics.Qps {
+			t.Errorf("metric qps key: %s", key)
+		}
+		if key := c.limit("q1", "", metrics.CmdSet, "", "msg"); key != "q1."+metrics.CmdSet+".msg" {
+			t.Errorf("queue limit key: %s", key)
+		}
+		if key := c.limit("q1", "g1", metrics.CmdGet, "c1", "byte"); key != "q1.g1."+metrics.CmdGet+"@c1.byte" {
+			t.Errorf("client limit key: %s", key)
+		}
+	}
+	if len(cache.keys) != 6 {
+		t.Errorf("expect 6 cached keys, got %d", len(cache.keys))
+	}
+
+	for i := 0; i < maxCachedKeys+1; i++ {
+		cache.owner("q1", fmt.Sprint(i))
+	}
+	if len(cache.keys) > maxCachedKeys {
+		t.Errorf("cached keys %d exceed %d", len(cache.keys), maxCachedKeys)
+	}
+}
+
+func BenchmarkMessageIdString(b *testing.B) {
+	m := &messageId{queue: "q1", group: "g1", idc: "local", partition: 10, offset: 0x1234, sequence: 0xabcdef}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = m.String()
+	}
+}
+
+func BenchmarkKeyCache(b *testing.B) {
+	cache := newKeyCache()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = cache.metricQps("q1", "g1", metrics.CmdSet, metrics.ElapseLess10ms)
+		}
+	})
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/weibocom/wqs/engine/kafka"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+const (
+	minPriorityLevels = 2
+	maxPriorityLevels = 4
+	// 默认每高一级的权重为低一级的4倍
+	priorityWeightBase = 4
+	// 优先级子topic的后缀, queue名字中不能包含'.', 不会与其他queue冲突
+	prioritySuffix = ".p"
+)
+
+// queue的优先级, 0为默认的最低优先级, 写入queue本身的topic, 其他优先级写入子topic <queue>.p<level>.
+// 接收时按权重交替从各个优先级接收, 高优先级的消息不会排在大量低优先级消息之后, 低优先级也不会被饿死
+type PriorityConfig struct {
+	Levels  int   `json:"levels"`
+	Weights []int `json:"weights,omitempty"`
+}
+
+// 各个优先级的权重, 没有设置时为1, 4, 16, 64
+func (p *PriorityConfig) weights() []int {
+	if len(p.Weights) == p.Levels {
+		return p.Weights
+	}
+	weights := make([]int, p.Levels)
+	for i, w := 0, 1; i < p.Levels; i, w = i+1, w*priorityWeightBase {
+		weights[i] = w
+	}
+	return weights
+}
+
+func (p *PriorityConfig) validate() error {
+	if p.Levels < minPriorityLevels || p.Levels > maxPriorityLevels {
+		return errors.NotValidf("priority levels %d, expect %d~%d", p.Levels, minPriorityLevels, maxPriorityLevels)
+	}
+	if len(p.Weights) == 0 {
+		return nil
+	}
+	if len(p.Weights) != p.Levels {
+		return errors.NotValidf("priority weights %v for %d levels", p.Weights, p.Levels)
+	}
+	for _, w := range p.Weights {
+		if w <= 0 {
+			return errors.NotValidf("priority weights %v", p.Weights)
+		}
+	}
+	return nil
+}
+
+// queue的优先级数, 没有设置时为1
+func priorityLevels(config *QueueConfig) int {
+	if config == nil || config.Priority == nil {
+		return 1
+	}
+	return config.Priority.Levels
+}
+
+func priorityTopic(queue string, level int) string {
+	if level == 0 {
+		return queue
+	}
+	return queue + prioritySuffix + strconv.Itoa(level)
+}
+
+// 消息id中的topic对应的优先级
+func topicPriority(queue string, topic string) (int, bool) {
+	if topic == queue {
+		return 0, true
+	}
+	if !strings.HasPrefix(topic, queue+prioritySuffix) {
+		return 0, false
+	}
+	level, err := strconv.Atoi(topic[len(queue)+len(prioritySuffix):])
+	if err != nil || level <= 0 || level >= maxPriorityLevels {
+		return 0, false
+	}
+	return level, true
+}
+
+// 每个优先级使用单独的consumer
+func priorityOwner(owner string, level int) string {
+	if level == 0 {
+		return owner
+	}
+	return fmt.Sprintf("%s#p%d", owner, level)
+}
+
+// owner及其所有优先级子topic的consumer
+func priorityOwners(owner string) []string {
+	owners := make([]string, 0, maxPriorityLevels)
+	for level := 0; level < maxPriorityLevels; level++ {
+		owners = append(owners, priorityOwner(owner, level))
+	}
+	return owners
+}
+
+type priorityKey struct{}
+
+// 发送消息的优先级, 不设置时为0
+func WithPriority(ctx context.Context, level int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, level)
+}
+
+func priorityFrom(ctx context.Context) int {
+	level, _ := ctx.Value(priorityKey{}).(int)
+	return level
+}
+
+// 发送时写入的topic
+func sendTopic(queue string, config *QueueConfig, level int) (string, error) {
+	if level < 0 || level >= priorityLevels(config) {
+		return "", errors.NotValidf("priority %d of queue %q", level, queue)
+	}
+	return priorityTopic(queue, level), nil
+}
+
+// 平滑加权轮询, 决定每次接收时各个优先级的顺序. 状态按consumer的owner保存
+type prioritySelector struct {
+	mu      sync.Mutex
+	current map[string][]int
+}
+
+func newPrioritySelector() *prioritySelector {
+	return &prioritySelector{current: make(map[string][]int)}
+}
+
+// 返回本次尝试接收的优先级顺序: 按权重选出的优先级在前, 其余的从高到低
+func (s *prioritySelector) order(owner string, weights []int) []int {
+	s.mu.Lock()
+	current, ok := s.current[owner]
+	if !ok || len(current) != len(weights) {
+		current = make([]int, len(weights))
+		s.current[owner] = current
+	}
+	total := 0
+	for i, w := range weights {
+		current[i] += w
+		total += w
+	}
+	// 相同时优先选择高优先级
+	best := len(weights) - 1
+	for i := best - 1; i >= 0; i-- {
+		if current[i] > current[best] {
+			best = i
+		}
+	}
+	current[best] -= total
+	s.mu.Unlock()
+
+	levels := make([]int, 0, len(weights))
+	levels = append(levels, best)
+	for level := len(weights) - 1; level >= 0; level-- {
+		if level != best {
+			levels = append(levels, level)
+		}
+	}
+	return levels
+}
+
+// 设置queue的优先级数, 在queue所在的各个机房创建缺少的子topic, 分区数与queue相同.
+// levels为0时取消优先级, 减少优先级后多出的子topic不再投递, 删除queue时一并删除
+func (q *queueImp) SetQueuePriority(queue string, priority PriorityConfig) error {
+
+	if priority.Levels != 0 {
+		if err := priority.validate(); err != nil {
+			return err
+		}
+	}
+	config := q.metadata.GetQueueConfig(queue)
+	if config == nil {
+		return errors.NotFoundf("queue : %q", queue)
+	}
+	if (config.Ordered || config.Compact) && priority.Levels != 0 {
+		return errors.NotValidf("priority of ordered or compact queue %q", queue)
+	}
+
+	for level := 1; level < priority.Levels; level++ {
+		if err := q.metadata.createPriorityTopic(config, level); err != nil {
+			return err
+		}
+	}
+	return q.metadata.ModifyQueueConfig(queue, func(config *QueueConfig) error {
+		if priority.Levels == 0 {
+			config.Priority = nil
+		} else {
+			config.Priority = &priority
+		}
+		return nil
+	})
+}
+
+// 子topic使用与queue相同的profile
+func (m *Metadata) createPriorityTopic(config *QueueConfig, level int) error {
+	queue := config.Queue
+	topic := priorityTopic(queue, level)
+	replications, topicConfig := m.replications, map[string]string(nil)
+	if p, ok := m.getProfile(config.Profile); ok && config.Profile != "" {
+		replications, topicConfig = p.Replications, p.topicConfig()
+	}
+	idcs := config.Idcs
+	if len(idcs) == 0 {
+		idcs = []string{m.local}
+	}
+	for _, idc := range idcs {
+		manager, ok := m.managers[idc]
+		if !ok {
+			return errors.NotFoundf("idc: %q", idc)
+		}
+		if exist, _ := manager.ExistTopic(topic); exist {
+			continue
+		}
+		partitions, err := manager.Partitions(queue)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = manager.CreateTopicWithConfig(topic, replications, int32(len(partitions)), topicConfig); err != nil {
+			return errors.Annotatef(err, "create topic %s at idc %q", topic, idc)
+		}
+	}
+	return nil
+}
+
+// 删除queue时删除本机房的全部子topic
+func (m *Metadata) deletePriorityTopics(queue string) error {
+	manager := m.LocalManager()
+	for level := 1; level < maxPriorityLevels; level++ {
+		topic := priorityTopic(queue, level)
+		if exist, _ := manager.ExistTopic(topic); !exist {
+			continue
+		}
+		if err := manager.DeleteTopic(topic); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// 按权重决定本次接收各个优先级的顺序, 依次尝试直到收到消息, 每个优先级未命中时只等待很短的时间
+func (q *queueImp) recvPriority(ctx context.Context, owner string, consumerGroup string, clusterConfig *sarama.Config,
+	config *QueueConfig, group string, opts kafka.ConsumerOptions) (*sarama.ConsumerMessage, *Message, error) {
+
+	queue := config.Queue
+	miss := kafka.ErrNoPartition
+	for _, level := range q.priorities.order(owner, config.Priority.weights()) {
+		topic := priorityTopic(queue, level)
+		levelOwner := priorityOwner(owner, level)
+		consumer, err := q.getConsumer(levelOwner, queue, topic, consumerGroup, clusterConfig, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		msg, message, err := q.recvAvailable(ctx, consumer, levelOwner, queue, topic, group)
+		switch err {
+		case nil:
+			return msg, message, nil
+		case kafka.ErrTimeout:
+			miss = err
+		case kafka.ErrNoPartition:
+		default:
+			return nil, nil, err
+		}
+	}
+	return nil, nil, miss
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+func TestPriorityConfig(t *testing.T) {
+	p := &PriorityConfig{Levels: 3}
+	if err := p.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if weights := p.weights(); !reflect.DeepEqual(weights, []int{1, 4, 16}) {
+		t.Errorf("default weights %v", weights)
+	}
+	p.Weights = []int{1, 2, 3}
+	if weights := p.weights(); !reflect.DeepEqual(weights, []int{1, 2, 3}) {
+		t.Errorf("weights %v", weights)
+	}
+
+	for _, p := range []*PriorityConfig{
+		{Levels: 1},
+		{Levels: 5},
+		{Levels: 2, Weights: []int{1}},
+		{Levels: 2, Weights: []int{1, 0}},
+	} {
+		if err := p.validate(); !errors.IsNotValid(err) {
+			t.Errorf("validate %+v err: %v, want not valid", p, err)
+		}
+	}
+}
+
+func TestTopicPriority(t *testing.T) {
+	for level := 0; level < maxPriorityLevels; level++ {
+		topic := priorityTopic("q1", level)
+		if got, ok := topicPriority("q1", topic); !ok || got != level {
+			t.Errorf("topic %s priority %d %v, want %d", topic, got, ok, level)
+		}
+	}
+	if priorityTopic("q1", 2) != "q1.p2" {
+		t.Errorf("priority topic %s", priorityTopic("q1", 2))
+	}
+	for _, topic := range []string{"q2", "q1.p0", "q1.p4", "q1.px", "q1x"} {
+		if _, ok := topicPriority("q1", topic); ok {
+			t.Errorf("topic %s should not be a priority of q1", topic)
+		}
+	}
+}
+
+func TestSendTopic(t *testing.T) {
+	config := &QueueConfig{Queue: "q1"}
+	if topic, err := sendTopic("q1", config, 0); err != nil || topic != "q1" {
+		t.Errorf("send topic %s err: %v", topic, err)
+	}
+	if _, err := sendTopic("q1", config, 1); !errors.IsNotValid(err) {
+		t.Errorf("send priority 1 without levels err: %v", err)
+	}
+
+	config.Priority = &PriorityConfig{Levels: 3}
+	if topic, err := sendTopic("q1", config, priorityFrom(WithPriority(context.Background(), 2))); err != nil || topic != "q1.p2" {
+		t.Errorf("send topic %s err: %v", topic, err)
+	}
+	for _, level := range []int{-1, 3} {
+		if _, err := sendTopic("q1", config, level); !errors.IsNotValid(err) {
+			t.Errorf("send priority %d err: %v", level, err)
+		}
+	}
+}
+
+func TestPrioritySelectorOrder(t *testing.T) {
+	s := newPrioritySelector()
+	weights := []int{1, 4, 16}
+	counts := make([]int, len(weights))
+	for i := 0; i < 210; i++ {
+		levels := s.order("q1@g1", weights)
+		if len(levels) != len(weights) {
+			t.Fatalf("order %v", levels)
+		}
+		counts[levels[0]]++
+	}
+	if !reflect.DeepEqual(counts, []int{10, 40, 160}) {
+		t.Errorf("first level counts %v, want [10 40 160]", counts)
+	}
+	// 未选中的优先级从高到低排列
+	if levels := s.order("q2@g1", []int{1, 1, 1}); !reflect.DeepEqual(levels, []int{2, 1, 0}) {
+		t.Errorf("order %v", levels)
+	}
+}
+
+func TestSetQueuePriorityInvalid(t *testing.T) {
+	q := &queueImp{}
+	for _, levels := range []int{1, 5, -1} {
+		if err := q.SetQueuePriority("q1", PriorityConfig{Levels: levels}); !errors.IsNotValid(err) {
+			t.Errorf("set priority levels %d err: %v, want not valid", levels, err)
+		}
+	}
+}
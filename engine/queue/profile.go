@@ -0,0 +1,197 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/juju/errors"
+)
+
+var validProfile = regexp.MustCompile(`^[a-zA-Z0-9_]{1,32}$`)
+
+// topic级别支持的压缩方式, producer表示保持producer的压缩方式
+var profileCompressions = map[string]bool{
+	"producer":     true,
+	"uncompressed": true,
+	"gzip":         true,
+	"snappy":       true,
+	"lz4":          true,
+	"zstd":         true,
+}
+
+// 创建queue的模板, 在配置文件中以profile.<name>.<key>定义.
+// 未配置的partitions和replications使用kafka.topic.*的默认值
+type Profile struct {
+	Name         string `json:"name"`
+	Partitions   int32  `json:"partitions"`
+	Replications int32  `json:"replications"`
+	// 消息在kafka中保留的小时数, 0表示使用broker的配置
+	Retention int64 `json:"retention,omitempty"`
+	// topic的compression.type
+	Compression string `json:"compression,omitempty"`
+	// topic的min.insync.replicas, producer使用acks=all, 写入成功至少需要的副本数
+	MinInsync int32      `json:"min_insync,omitempty"`
+	TTL       int64      `json:"ttl,omitempty"`
+	Quota     *Quota     `json:"quota,omitempty"`
+	Limit     *RateLimit `json:"limit,omitempty"`
+}
+
+// 写入kafka的topic配置
+func (p *Profile) topicConfig() map[string]string {
+	configs := make(map[string]string)
+	if p.Retention > 0 {
+		configs["retention.ms"] = strconv.FormatInt(p.Retention*3600*1000, 10)
+	}
+	if p.Compression != "" {
+		configs["compression.type"] = p.Compression
+	}
+	if p.MinInsync > 0 {
+		configs["min.insync.replicas"] = strconv.FormatInt(int64(p.MinInsync), 10)
+	}
+	return configs
+}
+
+// 生效到queue配置上的部分
+func (p *Profile) apply(config *QueueConfig) {
+	config.Profile = p.Name
+	config.TTL = p.TTL
+	if p.Quota != nil {
+		quota := *p.Quota
+		config.Quota = &quota
+	}
+	if p.Limit != nil {
+		limit := *p.Limit
+		config.Limit = &limit
+	}
+}
+
+func (p *Profile) validate() error {
+	if p.Partitions < 1 || p.Replications < 1 {
+		return errors.NotValidf("profile %q partitions %d replications %d", p.Name, p.Partitions, p.Replications)
+	}
+	if p.Retention < 0 || p.TTL < 0 || p.MinInsync < 0 {
+		return errors.NotValidf("profile %q retention, ttl or min.insync", p.Name)
+	}
+	if p.MinInsync > p.Replications {
+		return errors.NotValidf("profile %q min.insync %d great than replications %d", p.Name, p.MinInsync, p.Replications)
+	}
+	if p.Compression != "" && !profileCompressions[p.Compression] {
+		return errors.NotValidf("profile %q compression %q", p.Name, p.Compression)
+	}
+	if p.Quota != nil && !validQuota(p.Quota) {
+		return errors.NotValidf("profile %q quota %+v", p.Name, *p.Quota)
+	}
+	if p.Limit != nil && (p.Limit.MsgRate < 0 || p.Limit.ByteRate < 0) {
+		return errors.NotValidf("profile %q limit %+v", p.Name, *p.Limit)
+	}
+	return nil
+}
+
+// profile段是可选的, 配置错误时返回错误, 避免按错误的模板创建queue
+func loadProfiles(conf *config.Config, partitions int32, replications int32) (map[string]*Profile, error) {
+
+	profiles := make(map[string]*Profile)
+	section, err := conf.GetSection("profile")
+	if err != nil {
+		return profiles, nil
+	}
+
+	// 按profile名字分组
+	keys := make(map[string]config.Section)
+	for key, value := range section {
+		tokens := strings.SplitN(key, ".", 2)
+		if len(tokens) != 2 || !validProfile.MatchString(tokens[0]) {
+			return nil, errors.NotValidf("profile.%s", key)
+		}
+		if _, ok := keys[tokens[0]]; !ok {
+			keys[tokens[0]] = make(config.Section)
+		}
+		keys[tokens[0]][tokens[1]] = value
+	}
+
+	for name, s := range keys {
+		p := &Profile{
+			Name:         name,
+			Partitions:   int32(s.GetInt64Must("partitions", int64(partitions))),
+			Replications: int32(s.GetInt64Must("replications", int64(replications))),
+			Retention:    s.GetInt64Must("retention.hours", 0),
+			Compression:  s.GetStringMust("compression", ""),
+			MinInsync:    int32(s.GetInt64Must("min.insync", 0)),
+			TTL:          s.GetInt64Must("ttl", 0),
+		}
+		quota := Quota{
+			MaxDepth: s.GetInt64Must("quota.max.depth", 0),
+			MaxDaily: s.GetInt64Must("quota.max.daily", 0),
+			Policy:   s.GetStringMust("quota.policy", ""),
+		}
+		if quota.MaxDepth != 0 || quota.MaxDaily != 0 {
+			p.Quota = &quota
+		}
+		limit := RateLimit{
+			MsgRate:  s.GetInt64Must("limit.msg.rate", 0),
+			ByteRate: s.GetInt64Must("limit.byte.rate", 0),
+		}
+		if limit.MsgRate != 0 || limit.ByteRate != 0 {
+			p.Limit = &limit
+		}
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+		profiles[name] = p
+	}
+	return profiles, nil
+}
+
+func (m *Metadata) getProfile(name string) (*Profile, bool) {
+	m.rw.RLock()
+	defer m.rw.RUnlock()
+	p, ok := m.profiles[name]
+	return p, ok
+}
+
+// queue的模板设置的min.insync, 没有设置时为0
+func (m *Metadata) minInsync(profile string) int {
+	if p, ok := m.getProfile(profile); ok {
+		return int(p.MinInsync)
+	}
+	return 0
+}
+
+// 重新加载配置时替换所有模板, 只影响之后创建的queue
+func (m *Metadata) setProfiles(profiles map[string]*Profile) {
+	m.rw.Lock()
+	m.profiles = profiles
+	m.rw.Unlock()
+}
+
+// 返回配置的所有模板, 按名字排序
+func (q *queueImp) Profiles() []*Profile {
+	q.metadata.rw.RLock()
+	profiles := make([]*Profile, 0, len(q.metadata.profiles))
+	for _, p := range q.metadata.profiles {
+		profiles = append(profiles, p)
+	}
+	q.metadata.rw.RUnlock()
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/juju/errors"
+)
+
+func TestLoadProfiles(t *testing.T) {
+	conf, err := config.NewConfigFromBytes([]byte(testAlertBaseConfig +
+		"profile.bulk.partitions=32\n" +
+		"profile.bulk.retention.hours=24\n" +
+		"profile.bulk.compression=lz4\n" +
+		"profile.bulk.quota.max.depth=1000\n" +
+		"profile.small.replications=3\n" +
+		"profile.small.min.insync=2\n" +
+		"profile.small.ttl=60\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	profiles, err := loadProfiles(conf, 8, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 {
+		t.Fatalf("expect 2 profiles, got %v", profiles)
+	}
+
+	bulk := profiles["bulk"]
+	if bulk.Partitions != 32 || bulk.Replications != 3 || bulk.Quota == nil || bulk.Quota.MaxDepth != 1000 || bulk.Limit != nil {
+		t.Errorf("unexpected profile %+v", bulk)
+	}
+	configs := bulk.topicConfig()
+	if configs["retention.ms"] != "86400000" || configs["compression.type"] != "lz4" || len(configs) != 2 {
+		t.Errorf("unexpected topic config %v", configs)
+	}
+
+	small := profiles["small"]
+	if small.Partitions != 8 || small.topicConfig()["min.insync.replicas"] != "2" {
+		t.Errorf("unexpected profile %+v", small)
+	}
+	queueConfig := &QueueConfig{}
+	small.apply(queueConfig)
+	if queueConfig.Profile != "small" || queueConfig.TTL != 60 || queueConfig.Quota != nil {
+		t.Errorf("unexpected queue config %+v", queueConfig)
+	}
+
+	for _, line := range []string{
+		"profile.bad.compression=brotli\n",
+		"profile.bad.partitions=0\n",
+		"profile.bad.min.insync=4\n",
+		"profile.bad.quota.max.depth=10\nprofile.bad.quota.policy=unknown\n",
+		"profile.bad-name.ttl=1\n",
+	} {
+		conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig + line))
+		if _, err = loadProfiles(conf, 8, 3); !errors.IsNotValid(err) {
+			t.Errorf("expect %q rejected, got %v", line, err)
+		}
+	}
+
+	conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig))
+	if profiles, err = loadProfiles(conf, 8, 3); err != nil || len(profiles) != 0 {
+		t.Errorf("expect no profile without profile section, got %v %v", profiles, err)
+	}
+}
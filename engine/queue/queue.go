@@ -16,24 +16,106 @@ limitations under the License.
 
 package queue
 
-import "github.com/weibocom/wqs/config"
+import (
+	"context"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+)
 
 type Queue interface {
 	Create(queue string, idcs []string) error
+	CreateQueue(queue string, opts QueueOptions) error
+	Profiles() []*Profile
 	Update(queue string) error
 	Delete(queue string) error
 	Lookup(queue string, group string) ([]*QueueInfo, error)
-	AddGroup(group string, queue string, write bool, read bool, url string, ips []string) error
-	UpdateGroup(group string, queue string, write bool, read bool, url string, ips []string) error
+	LookupQueues(filter QueueFilter) ([]*QueueInfo, int, error)
+	AddGroup(group string, queue string, write bool, read bool, url string, ips []string, start string) error
+	UpdateGroup(group string, queue string, write bool, read bool, url string, ips []string, start string) error
 	DeleteGroup(group string, queue string) error
+	ApplyGroups(configs []GroupConfig) ([]GroupChange, error)
+	ApplyGroupToQueues(config GroupConfig, queues []string) ([]GroupChange, error)
+	VerifyGroupURL(group string, queue string) error
 	LookupGroup(group string) ([]*GroupInfo, error)
+	LookupGroups(filter GroupFilter) ([]*GroupInfo, int, error)
 	GetSingleGroup(group string, queue string) (*GroupConfig, error)
-	SendMessage(queue string, group string, data []byte, flag uint64) (id string, err error)
-	RecvMessage(queue string, group string) (id string, data []byte, flag uint64, err error)
-	AckMessage(queue string, group string, id string) error
+	DescribeGroup(queue string, group string) (*GroupDescription, error)
+	StuckMembers(queue string, group string, stale time.Duration) ([]*StuckMember, error)
+	RemoveGroupMember(queue string, group string, member string) error
+	ResetOffset(queue string, group string, time int64) error
+	SnapshotOffsets(queue string, group string, name string) (*OffsetSnapshot, error)
+	OffsetSnapshots(queue string, group string) ([]*OffsetSnapshot, error)
+	GetOffsetSnapshot(queue string, group string, name string) (*OffsetSnapshot, error)
+	DeleteOffsetSnapshot(queue string, group string, name string) error
+	RestoreOffsets(queue string, group string, name string) error
+	PurgeQueue(queue string) error
+	GetReassignment(queue string, idc string) (*Reassignment, error)
+	ReassignQueue(queue string, req ReassignRequest) (*Reassignment, error)
+	ElectPreferredLeaders(queue string, idc string, partitions []int32) error
+	SetGroupPaused(group string, queue string, paused bool) error
+	SetGroupLimit(group string, queue string, limit RateLimit) error
+	SetClientLimit(group string, queue string, client string, limit RateLimit) error
+	SetGroupFilter(group string, queue string, filter string) error
+	SetGroupBroadcast(group string, queue string, broadcast bool) error
+	SetGroupWorkers(group string, queue string, workers int) error
+	SetGroupCommit(group string, queue string, policy CommitPolicy) error
+	SetGroupCanary(group string, queue string, percent int) error
+	SetSchedule(s *ScheduledMessage) error
+	GetSchedule(name string) (*ScheduledMessage, error)
+	Schedules() ([]*ScheduledMessage, error)
+	DeleteSchedule(name string) error
+	FindOrphans() (*OrphanReport, error)
+	Faults() (*FaultStatus, error)
+	SetFaults(config FaultConfig) error
+	ExpireSession() error
+	SetQueueLimit(queue string, limit RateLimit) error
+	SetQueueQuota(queue string, quota Quota) error
+	SetQueueTTL(queue string, ttl int64) error
+	SetQueueIdempotent(queue string, idempotent bool) error
+	SetQueueOverflow(queue string, policy string) error
+	SetQueueTags(queue string, tags map[string]string) error
+	SetQueueConsumer(queue string, tuning ConsumerTuning) error
+	SetQueueSize(queue string, size MessageSize) error
+	SetQueueEncryption(queue string, key string) error
+	SetQueueMessageTrace(queue string, enable bool) error
+	MessageTrace(id string) ([]*TraceEvent, error)
+	SetQueuePriority(queue string, priority PriorityConfig) error
+	GetValue(queue string, key string) (*KeyValue, error)
+	GetValues(queue string) ([]*KeyValue, error)
+	DeleteValue(ctx context.Context, queue string, group string, key string) error
+	SetQueueSchema(queue string, schema *Schema) error
+	SetQueueMirror(queue string, mirror *MirrorConfig) error
+	SetMirrorPaused(queue string, paused bool) error
+	MirrorStatus(queue string) (*MirrorStatus, error)
+	StartTransfer(queue string, req TransferRequest) (*TransferStatus, error)
+	GetTransfer(id string) (*TransferStatus, error)
+	Transfers() []*TransferStatus
+	CancelTransfer(id string) error
+	SendMessage(ctx context.Context, queue string, group string, data []byte, flag uint64) (id string, err error)
+	SendMessageWithID(ctx context.Context, queue string, group string, data []byte, flag uint64, msgID string) (id string, err error)
+	SendMessages(ctx context.Context, msgs []BatchMessage) (ids []string, err error)
+	RecvMessage(ctx context.Context, queue string, group string) (id string, data []byte, flag uint64, err error)
+	AckMessage(ctx context.Context, queue string, group string, id string) error
+	PeekMessage(queue string, group string, count int) ([]*MessageInfo, error)
+	RecvPartition(ctx context.Context, queue string, group string, partition int32, offset int64, limit int) (*MessagePage, error)
+	CommitPartitionOffset(queue string, group string, partition int32, offset int64) error
+	BrowseMessages(queue string, partition int32, offset int64, limit int, preview int) (*MessagePage, error)
+	GetMessageAt(queue string, partition int32, offset int64) (*MessageInfo, error)
+	SampleMessages(queue string, rate int, duration time.Duration, limit int, preview int) (*MessageSample, error)
 	AccumulationStatus() ([]AccumulationInfo, error)
+	Consumers() []string
 	Proxys() (map[string]string, error)
+	Instances() ([]*ProxyInstance, error)
+	Reload(conf *config.Config) ([]string, error)
 	GetProxyConfigByID(id int) (string, error)
+	Authorize(token string, role string) error
+	CreateToken(role string) (*TokenInfo, error)
+	DeleteToken(token string) error
+	Tokens() ([]*TokenInfo, error)
+	HealthCheck() []HealthStatus
+	KafkaStatus() []*KafkaStatus
+	ProducerPending() int64
 	UpTime() int64
 	Version() string
 	Close()
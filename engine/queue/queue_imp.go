@@ -17,76 +17,125 @@ limitations under the License.
 package queue
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"regexp"
 	"runtime"
-	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/weibocom/wqs/config"
 	"github.com/weibocom/wqs/engine/kafka"
 	"github.com/weibocom/wqs/log"
 	"github.com/weibocom/wqs/metrics"
+	"github.com/weibocom/wqs/tracing"
 
 	"github.com/Shopify/sarama"
-	"github.com/bsm/sarama-cluster"
 	"github.com/juju/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type queueImp struct {
+	// 原子操作的int64放在第一个字段, 保证在32位平台上8字节对齐
+	pending       int64
 	conf          *config.Config
-	clusterConfig *cluster.Config
+	clusterConfig *sarama.Config
+	startConfigs  map[string]*sarama.Config
 	metadata      *Metadata
+	alerter       *alerter
+	limiter       *rateLimiter
+	sendBreaker   *circuitBreaker
+	recvBreaker   *circuitBreaker
+	failover      *producerFailover
+	buffer        *producerBuffer
+	mirrorer      *mirrorer
+	schemas       *schemaChecker
+	interceptors  *interceptorChain
+	transfers     *transferKeeper
+	broadcasts    *broadcastKeeper
+	priorities    *prioritySelector
+	janitor       *janitor
+	urls          *urlVerifier
+	orphans       *orphanCollector
+	faults        *faultInjector
+	quotas        *quotaKeeper
+	isrGuard      *isrGuard
+	durableGuard  *isrGuard
+	msgTracer     *messageTracer
+	dedup         *deduper
+	chunks        *chunkAssembler
+	keys          *keyCache
+	crypto        *payloadCrypto
 	producer      *kafka.Producer
+	idempotent    *kafka.Producer
+	durable       *kafka.Producer
+	txn           *kafka.TxnProducer
+	txnTimeout    time.Duration
+	producerMu    sync.Mutex
 	idGenerator   *idGenerator
-	consumerMap   map[string]*kafka.Consumer
+	consumers     *consumerMap
+	tasks         chan func()
 	dying         chan struct{}
-	vaildName     *regexp.Regexp
-	rw            sync.RWMutex
+	adminToken    string
 	uptime        time.Time
 	version       string
 	numGc         uint32
 	gcPause       uint64
 }
 
-const clockTime = 30 * time.Second
+const (
+	clockTime      = 30 * time.Second
+	maxDropPerRecv = 1000
+)
+
+var (
+	ErrGroupPaused = errors.New("group paused")
+)
+
+func IsPaused(err error) bool {
+	return errors.Cause(err) == ErrGroupPaused
+}
+
+// 本proxy的consumer没有分配到partition, 消息由group中其他proxy投递
+func IsUnassigned(err error) bool {
+	return errors.Cause(err) == kafka.ErrNoPartition
+}
 
-// return a custom cluster config
-func genClusterConfig(hostname string) *cluster.Config {
+// return a custom cluster config, 可以通过kafka段的配置覆盖, 见applySaramaSettings
+func genClusterConfig(hostname string) *sarama.Config {
 
-	config := cluster.NewConfig()
+	config := sarama.NewConfig()
 	// Network
-	config.Config.Net.KeepAlive = 30 * time.Second
-	config.Config.Net.MaxOpenRequests = 20
-	config.Config.Net.DialTimeout = 10 * time.Second
-	config.Config.Net.ReadTimeout = 10 * time.Second
-	config.Config.Net.WriteTimeout = 10 * time.Second
+	config.Net.KeepAlive = 30 * time.Second
+	config.Net.MaxOpenRequests = 20
+	config.Net.DialTimeout = 10 * time.Second
+	config.Net.ReadTimeout = 10 * time.Second
+	config.Net.WriteTimeout = 10 * time.Second
 	// Metadata
-	config.Config.Metadata.Retry.Backoff = 100 * time.Millisecond
-	config.Config.Metadata.Retry.Max = 5
-	config.Config.Metadata.RefreshFrequency = 1 * time.Minute
+	config.Metadata.Retry.Backoff = 100 * time.Millisecond
+	config.Metadata.Retry.Max = 5
+	config.Metadata.RefreshFrequency = 1 * time.Minute
 	// Producer
-	config.Config.Producer.RequiredAcks = sarama.WaitForLocal
+	config.Producer.RequiredAcks = sarama.WaitForLocal
 	//conf.Producer.RequiredAcks = sarama.NoResponse //this one high performance than WaitForLocal
-	config.Config.Producer.Partitioner = sarama.NewRandomPartitioner
-	config.Config.Producer.Flush.Frequency = time.Millisecond
-	config.Config.Producer.Flush.MaxMessages = 200
-	config.Config.ChannelBufferSize = 1024
+	// key中包含唯一的sequence, 按key hash与随机分布相同, 同一条消息的分片使用相同的key, 写入同一个partition
+	config.Producer.Partitioner = sarama.NewHashPartitioner
+	config.Producer.Flush.Frequency = time.Millisecond
+	config.Producer.Flush.MaxMessages = 200
+	config.ChannelBufferSize = 1024
 	// Common
-	config.Config.ClientID = fmt.Sprintf("%d..%s", os.Getpid(), hostname)
-	config.Group.Heartbeat.Interval = 50 * time.Millisecond
+	config.ClientID = fmt.Sprintf("%d..%s", os.Getpid(), hostname)
+	config.Consumer.Group.Heartbeat.Interval = 50 * time.Millisecond
 	// Consumer
-	config.Config.Consumer.Retry.Backoff = 500 * time.Millisecond
-	config.Config.Consumer.Offsets.CommitInterval = 100 * time.Millisecond
-	config.Config.Consumer.Offsets.Initial = sarama.OffsetOldest
-	config.Config.Consumer.Offsets.Retention = 7 * 24 * time.Hour
-	config.Config.Consumer.Return.Errors = true
-	config.Group.Offsets.Retry.Max = 3
-	config.Group.Return.Notifications = true
-	config.Group.Session.Timeout = 10 * time.Second
+	config.Consumer.Retry.Backoff = 500 * time.Millisecond
+	config.Consumer.Offsets.CommitInterval = 100 * time.Millisecond
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	config.Consumer.Offsets.Retention = 7 * 24 * time.Hour
+	config.Consumer.Return.Errors = true
+	config.Consumer.Offsets.Retry.Max = 3
+	config.Consumer.Group.Session.Timeout = 10 * time.Second
 	return config
 }
 
@@ -99,34 +148,136 @@ func newQueue(config *config.Config, version string) (*queueImp, error) {
 	}
 
 	clusterConfig := genClusterConfig(hostname)
-	metadata, err := NewMetadata(config, &clusterConfig.Config)
+	if err = applySaramaSettings(config, clusterConfig); err != nil {
+		return nil, errors.Trace(err)
+	}
+	metadata, err := NewMetadata(config, clusterConfig)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
 	info := &proxyInfo{
-		Host:   hostname,
-		config: config,
+		Host:         advertiseHost(config, hostname),
+		Idc:          metadata.local,
+		Version:      version,
+		HTTPPort:     config.HttpPort,
+		McPort:       config.McPort,
+		Capabilities: proxyCapabilities(config),
+		Start:        time.Now().Unix(),
+		config:       config,
 	}
 
 	if err = metadata.RegisterService(config.ProxyId, info.String()); err != nil {
 		metadata.Close()
 		return nil, errors.Trace(err)
 	}
+	if isLeaderCandidate(config) {
+		metadata.startElection()
+	}
+
+	producer, err := kafka.NewProducer(metadata.LocalManager().BrokerAddrs(), clusterConfig)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	alerter, err := newAlerter(config, metadata, hostname)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	sendBreaker, recvBreaker := newCircuitBreakers(config)
+
+	failover, err := newProducerFailover(config, clusterConfig, metadata.LocalManager().RefreshMetadata)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	buffer, err := newProducerBuffer(config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	interceptors, err := newInterceptorChain(config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	crypto, err := newPayloadCrypto(config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	isrGuard, err := newISRGuard(config, clusterConfig, metadata.LocalManager())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	durableGuard, err := newDurableGuard(config, metadata.LocalManager())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	msgTracer, err := newMessageTracer(config, metadata, producer)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	orphans, err := newOrphanCollector(config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 
-	producer, err := kafka.NewProducer(metadata.LocalManager().BrokerAddrs(), &clusterConfig.Config)
+	faults, err := newFaultInjector(config)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
+	txnTimeout, err := newTxnTimeout(config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// auth段是可选的
+	var adminToken string
+	if authSection, err := config.GetSection("auth"); err == nil {
+		adminToken = authSection.GetStringMust("admin.token", "")
+	}
+
 	qs := &queueImp{
 		conf:          config,
 		clusterConfig: clusterConfig,
+		startConfigs:  newStartConfigs(clusterConfig),
 		metadata:      metadata,
+		alerter:       alerter,
+		limiter:       newRateLimiter(),
+		sendBreaker:   sendBreaker,
+		recvBreaker:   recvBreaker,
+		failover:      failover,
+		buffer:        buffer,
+		mirrorer:      newMirrorer(config, metadata, clusterConfig),
+		schemas:       newSchemaChecker(config),
+		interceptors:  interceptors,
+		transfers:     newTransferKeeper(),
+		broadcasts:    newBroadcastKeeper(clusterConfig),
+		priorities:    newPrioritySelector(),
+		janitor:       newJanitor(),
+		urls:          newURLVerifier(config),
+		orphans:       orphans,
+		faults:        faults,
+		txnTimeout:    txnTimeout,
+		quotas:        newQuotaKeeper(),
+		isrGuard:      isrGuard,
+		durableGuard:  durableGuard,
+		msgTracer:     msgTracer,
+		dedup:         newDeduper(config),
+		chunks:        newChunkAssembler(),
+		keys:          newKeyCache(),
+		crypto:        crypto,
 		producer:      producer,
 		idGenerator:   newIDGenerator(uint64(config.ProxyId)),
-		vaildName:     regexp.MustCompile(`^[a-zA-Z0-9_]{1,20}$`),
-		consumerMap:   make(map[string]*kafka.Consumer),
+		adminToken:    adminToken,
+		consumers:     newConsumerMap(),
+		tasks:         make(chan func()),
 		dying:         make(chan struct{}),
 		uptime:        time.Now(),
 		version:       version,
@@ -136,17 +287,30 @@ func newQueue(config *config.Config, version string) (*queueImp, error) {
 		log.Errorf("queue load metrics error %v", err)
 	}
 	go qs.clocked()
+	go qs.scheduling()
+	go qs.failover.run(qs.dying)
 	return qs, nil
 }
 
 //Create a queue by name.
 func (q *queueImp) Create(queue string, idcs []string) error {
-	// 1. check queue name valid
-	if !q.vaildName.MatchString(queue) {
-		return errors.NotValidf("queue : %q", queue)
+	return q.CreateQueue(queue, QueueOptions{Idcs: idcs})
+}
+
+// 按选项创建queue
+func (q *queueImp) CreateQueue(queue string, opts QueueOptions) error {
+	// 1. check queue name valid, 保留前缀和大小写冲突在metadata中检查
+	if err := checkName("queue", queue); err != nil {
+		return err
+	}
+	if opts.Expire < 0 {
+		return errors.NotValidf("expire : %d", opts.Expire)
+	}
+	if err := validTags(opts.Tags); err != nil {
+		return err
 	}
 	// 2. add metadata of queue
-	if err := q.metadata.AddQueue(queue, idcs); err != nil {
+	if err := q.metadata.AddQueue(queue, opts); err != nil {
 		log.Errorf("create queue %q error %s", queue, errors.ErrorStack(err))
 		return err
 	}
@@ -156,8 +320,8 @@ func (q *queueImp) Create(queue string, idcs []string) error {
 //Updata queue information by name. Nothing to be update so far.
 func (q *queueImp) Update(queue string) error {
 
-	if !q.vaildName.MatchString(queue) {
-		return errors.NotValidf("queue : %q", queue)
+	if err := checkName("queue", queue); err != nil {
+		return err
 	}
 	//TODO
 
@@ -175,8 +339,8 @@ func (q *queueImp) Update(queue string) error {
 //Delete queue by name
 func (q *queueImp) Delete(queue string) error {
 	// 1. check queue name valid
-	if !q.vaildName.MatchString(queue) {
-		return errors.NotValidf("queue : %q", queue)
+	if err := checkName("queue", queue); err != nil {
+		return err
 	}
 	// 2. delete metadata of queue
 	if err := q.metadata.DelQueue(queue); err != nil {
@@ -207,7 +371,7 @@ func (q *queueImp) Lookup(queue string, group string) (queueInfos []*QueueInfo,
 		//Get group's information by queue and group's name
 		exist := q.metadata.ExistGroup(queue, group)
 		if !exist {
-			err = errors.NotFoundf("queue: %q, group : %q")
+			err = errors.NotFoundf("queue: %q, group : %q", queue, group)
 			return
 		}
 		queueInfos, err = q.metadata.GetQueueInfo(queue)
@@ -227,30 +391,51 @@ func (q *queueImp) Lookup(queue string, group string) (queueInfos []*QueueInfo,
 }
 
 func (q *queueImp) AddGroup(group string, queue string,
-	write bool, read bool, url string, ips []string) error {
+	write bool, read bool, url string, ips []string, start string) error {
 
-	if !q.vaildName.MatchString(group) || !q.vaildName.MatchString(queue) {
-		return errors.NotValidf("group : %q , queue : %q", group, queue)
+	if err := checkGroupName(group, queue); err != nil {
+		return err
+	}
+	if err := validGroupStart(start); err != nil {
+		return err
+	}
+	verified, err := q.urls.check(group, queue, url)
+	if err != nil {
+		return err
 	}
 
-	if err := q.metadata.AddGroup(group, queue, write, read, url, ips); err != nil {
+	if err := q.metadata.AddGroup(group, queue, write, read, url, ips, start, verified); err != nil {
 		return errors.Trace(err)
 	}
 
-	if err := q.metadata.ResetOffset(queue, group, sarama.OffsetNewest); err != nil {
+	if err := q.metadata.ResetOffset(queue, group, groupStartOffset(start)); err != nil {
 		return errors.Trace(err)
 	}
 	return nil
 }
 
+// 修改start只影响之后新建的consumer, url没有变化时保留之前的验证结果
 func (q *queueImp) UpdateGroup(group string, queue string,
-	write bool, read bool, url string, ips []string) error {
+	write bool, read bool, url string, ips []string, start string) error {
 
-	if !q.vaildName.MatchString(group) || !q.vaildName.MatchString(queue) {
-		return errors.NotValidf("group : %q , queue : %q", group, queue)
+	if err := checkGroupName(group, queue); err != nil {
+		return err
+	}
+	if err := validGroupStart(start); err != nil {
+		return err
+	}
+	config, err := q.metadata.GetGroupConfig(group, queue)
+	if err != nil {
+		return errors.NotFoundf("queue : %q , group: %q", queue, group)
+	}
+	verified := config.Verified && config.Url == url
+	if !verified {
+		if verified, err = q.urls.check(group, queue, url); err != nil {
+			return err
+		}
 	}
 
-	if err := q.metadata.UpdateGroupConfig(group, queue, write, read, url, ips); err != nil {
+	if err := q.metadata.UpdateGroupConfig(group, queue, write, read, url, ips, start, verified); err != nil {
 		return errors.Trace(err)
 	}
 	return nil
@@ -258,8 +443,8 @@ func (q *queueImp) UpdateGroup(group string, queue string,
 
 func (q *queueImp) DeleteGroup(group string, queue string) error {
 
-	if !q.vaildName.MatchString(group) || !q.vaildName.MatchString(queue) {
-		return errors.NotValidf("group : %q , queue : %q", group, queue)
+	if err := checkGroupName(group, queue); err != nil {
+		return err
 	}
 
 	if err := q.metadata.DeleteGroup(group, queue); err != nil {
@@ -272,62 +457,65 @@ func (q *queueImp) DeleteGroup(group string, queue string) error {
 //Get group's information
 func (q *queueImp) LookupGroup(group string) ([]*GroupInfo, error) {
 
+	if group == "" {
+		//GET all groups' information
+		groupInfos, _, err := q.LookupGroups(GroupFilter{})
+		return groupInfos, err
+	}
+
 	groupInfos := make([]*GroupInfo, 0)
 	if err := q.metadata.RefreshMetadata(); err != nil {
 		return groupInfos, errors.Trace(err)
 	}
 
-	if group == "" {
-		//GET all groups' information
-		groupMap := q.metadata.GetGroupMap()
-		for group, queues := range groupMap {
+	//GET one group's information
+	queues, ok := q.metadata.GetGroupMap()[group]
+	if !ok {
+		return groupInfos, errors.NotFoundf("group : %q", group)
+	}
+	groupInfos = append(groupInfos, q.newGroupInfo(group, queues))
+	return groupInfos, nil
+}
 
-			groupInfo := GroupInfo{
-				Group:  group,
-				Queues: make([]*GroupConfig, 0),
-			}
+func (q *queueImp) GetSingleGroup(group string, queue string) (*GroupConfig, error) {
+	return q.metadata.GetGroupConfig(group, queue)
+}
 
-			for _, queue := range queues {
-				groupConfig, err := q.metadata.GetGroupConfig(group, queue)
-				if err != nil {
-					continue
-				}
-				groupInfo.Queues = append(groupInfo.Queues, groupConfig)
-			}
-			groupInfos = append(groupInfos, &groupInfo)
-		}
-	} else {
-		//GET one group's information
-		groupMap := q.metadata.GetGroupMap()
-		queues, ok := groupMap[group]
-		if !ok {
-			return groupInfos, errors.NotFoundf("group : %q", group)
-		}
+// 将group的消费位置重置到指定时间(毫秒), sarama.OffsetNewest和sarama.OffsetOldest分别表示最新和最早
+func (q *queueImp) ResetOffset(queue string, group string, time int64) error {
 
-		groupInfo := GroupInfo{
-			Group:  group,
-			Queues: make([]*GroupConfig, 0),
-		}
+	if exist := q.metadata.ExistGroup(queue, group); !exist {
+		return errors.NotFoundf("queue : %q , group: %q", queue, group)
+	}
 
-		for _, queue := range queues {
-			groupConfig, err := q.metadata.GetGroupConfig(group, queue)
-			if err != nil {
-				continue
-			}
-			groupInfo.Queues = append(groupInfo.Queues, groupConfig)
-		}
-		groupInfos = append(groupInfos, &groupInfo)
+	if err := q.metadata.ResetOffset(queue, group, time); err != nil {
+		log.Errorf("reset offset queue %q group %q error %s", queue, group, errors.ErrorStack(err))
+		return errors.Trace(err)
 	}
-	return groupInfos, nil
+	return nil
 }
 
-func (q *queueImp) GetSingleGroup(group string, queue string) (*GroupConfig, error) {
-	return q.metadata.GetGroupConfig(group, queue)
+// 清空queue中堆积的消息, 正在消费的客户端会从清空后的位置继续
+func (q *queueImp) PurgeQueue(queue string) error {
+
+	if exist := q.metadata.ExistQueue(queue); !exist {
+		return errors.NotFoundf("queue : %q", queue)
+	}
+
+	if err := q.metadata.PurgeQueue(queue); err != nil {
+		log.Errorf("purge queue %q error %s", queue, errors.ErrorStack(err))
+		return errors.Trace(err)
+	}
+	log.Infof("purge queue %q", queue)
+	return nil
 }
 
-func (q *queueImp) SendMessage(queue string, group string, data []byte, flag uint64) (string, error) {
+func (q *queueImp) SendMessage(ctx context.Context, queue string, group string, data []byte, flag uint64) (id string, err error) {
 
 	start := time.Now()
+	ctx, span := tracing.Start(ctx, "wqs.send", trace.SpanKindProducer, spanAttrs(queue, group)...)
+	defer func() { tracing.End(span, err) }()
+	q.faults.delay(ctx)
 
 	if ok := q.metadata.ExistGroup(queue, group); !ok {
 		metrics.AddCounter(metrics.CmdSetError, 1)
@@ -336,42 +524,369 @@ func (q *queueImp) SendMessage(queue string, group string, data []byte, flag uin
 		return "", errors.NotFoundf("queue : %q , group: %q", queue, group)
 	}
 
+	if err := q.sendBreaker.allow(); err != nil {
+		metrics.AddCounter(metrics.CmdSetError, 1)
+		metrics.AddMeter(metrics.CmdSetError+"."+metrics.Qps, 1)
+		log.Debugf("SendMessage: queue %q group %q %s", queue, group, err)
+		return "", err
+	}
+
+	message := &Message{Queue: queue, Group: group, Data: data, Flag: flag, Headers: headersFrom(ctx)}
+	if err := q.interceptors.beforeSend(ctx, message); err != nil {
+		metrics.AddCounter(queue+"."+group+"."+metrics.CmdSet+"."+metrics.Intercepted, 1)
+		log.Debugf("SendMessage: queue %q group %q intercepted %s", queue, group, err)
+		return "", err
+	}
+	data, flag = message.Data, message.Flag
+
+	durability, err := durabilityFrom(ctx)
+	if err != nil {
+		log.Debugf("SendMessage: queue %q group %q %s", queue, group, err)
+		return "", err
+	}
+
+	var encryptKey, compactKey, overflow string
+	topic := queue
+	if config := q.metadata.GetQueueConfig(queue); config != nil {
+		if topic, err = sendTopic(queue, config, priorityFrom(ctx)); err != nil {
+			log.Debugf("SendMessage: queue %q group %q %s", queue, group, err)
+			return "", err
+		}
+		if compactKey, err = sendKey(queue, config, keyFrom(ctx)); err != nil {
+			log.Debugf("SendMessage: queue %q group %q %s", queue, group, err)
+			return "", err
+		}
+		if err := q.quotas.check(queue, config.Quota); err != nil {
+			metrics.AddCounter(queue+"."+group+"."+metrics.CmdSet+"."+metrics.OverQuota, 1)
+			log.Debugf("SendMessage: queue %q group %q %s", queue, group, err)
+			return "", err
+		}
+		if err := q.isrGuard.check(queue, q.metadata.minInsync(config.Profile), time.Now()); err != nil {
+			metrics.AddCounter(queue+"."+group+"."+metrics.CmdSet+"."+metrics.UnderISR, 1)
+			if q.isrGuard.rejects() {
+				log.Debugf("SendMessage: queue %q group %q %s", queue, group, err)
+				return "", err
+			}
+		}
+		if durability == DurabilityAll {
+			if err := q.durableGuard.check(queue, q.metadata.minInsync(config.Profile), time.Now()); err != nil {
+				metrics.AddCounter(queue+"."+group+"."+metrics.CmdSet+"."+metrics.UnderISR, 1)
+				log.Debugf("SendMessage: queue %q group %q %s", queue, group, err)
+				return "", err
+			}
+		}
+		if err := q.schemas.validate(queue, config.Schema, data); err != nil {
+			metrics.AddCounter(queue+"."+group+"."+metrics.CmdSet+"."+metrics.Invalid, 1)
+			log.Debugf("SendMessage: queue %q group %q %s", queue, group, err)
+			return "", err
+		}
+		encryptKey = config.Encryption
+		overflow = config.Overflow
+	}
+
+	// 校验schema之后加密, 大小限制和分片按加密后的内容计算
+	if encryptKey != "" {
+		if data, err = q.crypto.encrypt(encryptKey, data); err != nil {
+			metrics.AddCounter(metrics.CmdSetError, 1)
+			metrics.AddMeter(metrics.CmdSetError+"."+metrics.Qps, 1)
+			log.Errorf("SendMessage: queue %q group %q encrypt error %s", queue, group, err)
+			return "", err
+		}
+	}
+
+	maxSize, chunkSize := q.messageSize(q.metadata.GetQueueConfig(queue))
+	if len(data) > maxSize {
+		metrics.AddCounter(queue+"."+group+"."+metrics.CmdSet+"."+metrics.TooLarge, 1)
+		log.Debugf("SendMessage: queue %q group %q message size %d exceeds %d", queue, group, len(data), maxSize)
+		return "", &MessageTooLargeError{Queue: queue, Size: len(data), Max: maxSize}
+	}
+
+	client := clientFrom(ctx)
+	if err := q.limiter.acquire(queue+"."+group,
+		q.limitRequests(queue, group, client, metrics.CmdSet, 1, int64(len(data)))); err != nil {
+		metrics.AddCounter(metrics.Throttled, 1)
+		metrics.AddCounter(queue+"."+group+"."+metrics.CmdSet+"."+metrics.Throttled, 1)
+		clientThrottled(queue+"."+group+"."+metrics.CmdSet+".", client)
+		log.Debugf("SendMessage: queue %q group %q %s", queue, group, err)
+		return "", err
+	}
+
 	sequence := q.idGenerator.Get()
-	key := fmt.Sprintf("%x:%x", sequence, flag)
+	key := messageKey(sequence, flag)
+	if compactKey != "" {
+		key = []byte(compactKey)
+	}
 
-	partition, offset, err := q.producer.Send(queue, []byte(key), data)
+	producer, err := q.getProducer(queue)
 	if err != nil {
+		metrics.AddCounter(metrics.CmdSetError, 1)
+		metrics.AddMeter(metrics.CmdSetError+"."+metrics.Qps, 1)
+		log.Errorf("SendMessage: queue %q group %q get producer error %s", queue, group, err)
+		return "", err
+	}
+	if durability == DurabilityAll {
+		if producer, err = q.getDurableProducer(producer); err != nil {
+			metrics.AddCounter(metrics.CmdSetError, 1)
+			metrics.AddMeter(metrics.CmdSetError+"."+metrics.Qps, 1)
+			log.Errorf("SendMessage: queue %q group %q get durable producer error %s", queue, group, err)
+			return "", err
+		}
+	}
+	overflow = q.overflowPolicy(overflow, durability, producer)
+	// 顺序和幂等queue不切换到standby集群, 切换后无法保证顺序; standby集群不保证durability=all
+	idc := q.metadata.local
+	if producer == q.producer && durability != DurabilityAll {
+		producer, idc = q.failover.pick(producer, idc)
+	}
+
+	if err = q.buffer.acquire(ctx, overflow); err != nil {
+		if err == ErrDropped {
+			metrics.AddCounter(queue+"."+group+"."+metrics.CmdSet+"."+metrics.Dropped, 1)
+			log.Debugf("SendMessage: queue %q group %q producer buffer full, message dropped", queue, group)
+			return "", err
+		}
+		metrics.AddCounter(queue+"."+group+"."+metrics.CmdSet+"."+metrics.Overload, 1)
+		log.Debugf("SendMessage: queue %q group %q %s", queue, group, err)
+		return "", err
+	}
+
+	var partition int32
+	var offset int64
+	atomic.AddInt64(&q.pending, 1)
+	headers := append(tracing.InjectHeaders(ctx), recordHeaders(message.Headers)...)
+	if encryptKey != "" {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(encryptHeader), Value: []byte(encryptKey)})
+	}
+	// compact queue的同一个key只保留最后一条消息, 不能分片
+	switch {
+	case q.faults.failProduce():
+		// 注入的失败与kafka的失败一样计入熔断
+		err = ErrFaultInjected
+	case chunkSize > 0 && len(data) > chunkSize && compactKey == "":
+		// 分片写入同一个partition, 消息id为最后一个分片的位置
+		chunks := splitChunks(data, chunkSize)
+		partition, offset, err = producer.SendChunks(topic, key, chunks, chunkHeaders(headers, len(chunks)))
+		metrics.AddCounter(queue+"."+group+"."+metrics.CmdSet+"."+metrics.Chunked, 1)
+	default:
+		partition, offset, err = producer.Send(topic, key, data, headers)
+	}
+	atomic.AddInt64(&q.pending, -1)
+	q.buffer.release()
+	if err != nil {
+		// 消息过大是客户端的问题, 不计入熔断
+		if err != sarama.ErrMessageSizeTooLarge {
+			q.sendBreaker.failure(err)
+			q.failover.failure(producer, err)
+		}
 		metrics.AddCounter(metrics.CmdSetError, 1)
 		metrics.AddMeter(metrics.CmdSetError+"."+metrics.Qps, 1)
 		log.Errorf("SendMessage: queue %q group %q error %s", queue, group, err)
 		return "", err
 	}
+	q.sendBreaker.success()
+	q.failover.success(producer)
+	if idc != q.metadata.local {
+		metrics.AddCounter(metrics.Failover+"."+metrics.CmdSet, 1)
+	}
 
+	// 优先级不为0时id中为子topic, ACK时据此找到对应的consumer
 	msgId := messageId{
-		queue:     queue,
+		queue:     topic,
 		group:     group,
-		idc:       q.metadata.local,
+		idc:       idc,
 		partition: partition,
 		offset:    offset,
 		sequence:  sequence,
 	}
 	messageID := msgId.String()
+	span.SetAttributes(attribute.String("wqs.message_id", messageID))
 	cost := time.Now().Sub(start).Nanoseconds() / 1e6
 
-	prefix := queue + "." + group + "." + metrics.CmdSet + "."
+	prefix := q.keys.prefix(queue, group, metrics.CmdSet)
+	elapse := metrics.ElapseTimeString(cost)
 	metrics.AddCounter(metrics.CmdSet, 1)
-	metrics.AddCounter(prefix+metrics.Ops, 1)
-	metrics.AddCounter(prefix+metrics.ElapseTimeString(cost), 1)
-	metrics.AddMeter(prefix+metrics.Qps, 1)
-	metrics.AddMeter(prefix+metrics.ElapseTimeString(cost)+"."+metrics.Qps, 1)
+	metrics.AddCounter(q.keys.metric(queue, group, metrics.CmdSet, metrics.Ops), 1)
+	metrics.AddCounter(q.keys.metric(queue, group, metrics.CmdSet, elapse), 1)
+	metrics.AddMeter(q.keys.metric(queue, group, metrics.CmdSet, metrics.Qps), 1)
+	metrics.AddMeter(q.keys.metricQps(queue, group, metrics.CmdSet, elapse), 1)
 	metrics.AddCounter(metrics.BytesWriten, int64(len(data)))
+	clientMetrics(prefix, client)
+	q.msgTracer.record(TraceSend, queue, group, messageID, client)
 	log.Debugf("send %s:%s key %s id %s cost %d", queue, group, key, messageID, cost)
 	return messageID, nil
 }
 
-func (q *queueImp) RecvMessage(queue string, group string) (string, []byte, uint64, error) {
+// 发送带有客户端消息id的消息, 去重窗口内重复的id不会再次发送, 直接返回第一次发送的消息id
+func (q *queueImp) SendMessageWithID(ctx context.Context, queue string, group string, data []byte, flag uint64, msgID string) (string, error) {
+
+	if msgID == "" {
+		return q.SendMessage(ctx, queue, group, data, flag)
+	}
+	if err := validDedupID(msgID); err != nil {
+		return "", err
+	}
+
+	key := queue + ":" + group + ":" + msgID
+	id, duplicated, err := q.dedup.do(key, func() (string, error) {
+		return q.SendMessage(ctx, queue, group, data, flag)
+	})
+	if duplicated {
+		metrics.AddCounter(queue+"."+group+"."+metrics.CmdSet+"."+metrics.Duplicated, 1)
+		log.Debugf("send %s:%s duplicated message id %s, original %s", queue, group, msgID, id)
+	}
+	return id, err
+}
+
+func spanAttrs(queue string, group string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("messaging.system", "kafka"),
+		attribute.String("messaging.destination", queue),
+		attribute.String("wqs.group", group),
+	}
+}
+
+// 开启幂等写入的queue和顺序queue使用单独的producer, 第一次使用时创建
+func (q *queueImp) getProducer(queue string) (*kafka.Producer, error) {
+
+	// 顺序queue同样需要保证重试时不乱序
+	config := q.metadata.GetQueueConfig(queue)
+	if config == nil || !(config.Idempotent || config.Ordered) {
+		return q.producer, nil
+	}
+
+	q.producerMu.Lock()
+	defer q.producerMu.Unlock()
+	if q.idempotent == nil {
+		producer, err := kafka.NewProducer(q.metadata.LocalManager().BrokerAddrs(),
+			kafka.IdempotentConfig(q.clusterConfig))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		q.idempotent = producer
+	}
+	return q.idempotent, nil
+}
+
+// 设置queue的schema, 之后发送的消息需要通过校验, nil表示不校验
+func (q *queueImp) SetQueueSchema(queue string, schema *Schema) error {
+
+	if schema != nil {
+		if _, err := q.schemas.compile(schema); err != nil {
+			return err
+		}
+	}
+
+	return q.metadata.ModifyQueueConfig(queue, func(config *QueueConfig) error {
+		config.Schema = schema
+		return nil
+	})
+}
+
+// 设置queue的跨机房复制, nil表示取消. 目标机房没有对应topic时自动创建
+func (q *queueImp) SetQueueMirror(queue string, mirror *MirrorConfig) error {
+
+	config := q.metadata.GetQueueConfig(queue)
+	if config == nil {
+		return errors.NotFoundf("queue : %q", queue)
+	}
+
+	if mirror != nil {
+		if mirror.From == "" || mirror.To == "" || mirror.From == mirror.To {
+			return errors.NotValidf("mirror : %+v", *mirror)
+		}
+		// 两个机房的消息已经都会被消费, 复制会导致重复消费
+		if contains(config.Idcs, mirror.From) && contains(config.Idcs, mirror.To) {
+			return errors.NotValidf("queue %q already consumed from idc %q and %q", queue, mirror.From, mirror.To)
+		}
+		if _, ok := q.metadata.managers[mirror.From]; !ok {
+			return errors.NotFoundf("idc : %q", mirror.From)
+		}
+		to, ok := q.metadata.managers[mirror.To]
+		if !ok {
+			return errors.NotFoundf("idc : %q", mirror.To)
+		}
+		exist, err := to.ExistTopic(queue)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !exist {
+			if err = to.CreateTopic(queue, q.metadata.replications, q.metadata.partitions); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+
+	err := q.metadata.ModifyQueueConfig(queue, func(config *QueueConfig) error {
+		config.Mirror = mirror
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// 其他proxy在下一次监控周期生效
+	q.mirrorer.sync()
+	return nil
+}
+
+// 暂停或恢复queue的复制, 恢复后从暂停的位置继续
+func (q *queueImp) SetMirrorPaused(queue string, paused bool) error {
+	err := q.metadata.ModifyQueueConfig(queue, func(config *QueueConfig) error {
+		if config.Mirror == nil {
+			return errors.NotFoundf("mirror of queue : %q", queue)
+		}
+		config.Mirror.Paused = paused
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	q.mirrorer.sync()
+	return nil
+}
+
+func (q *queueImp) MirrorStatus(queue string) (*MirrorStatus, error) {
+
+	config := q.metadata.GetQueueConfig(queue)
+	if config == nil {
+		return nil, errors.NotFoundf("queue : %q", queue)
+	}
+	if config.Mirror == nil {
+		return nil, errors.NotFoundf("mirror of queue : %q", queue)
+	}
+
+	lag, err := q.mirrorer.lag(queue, *config.Mirror)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &MirrorStatus{
+		Queue:        queue,
+		MirrorConfig: *config.Mirror,
+		Lag:          lag,
+		Running:      q.mirrorer.running(queue),
+	}, nil
+}
+
+// 开启后该queue的消息通过幂等producer写入, broker故障切换时也不会产生重复和乱序
+func (q *queueImp) SetQueueIdempotent(queue string, idempotent bool) error {
+	return q.metadata.ModifyQueueConfig(queue, func(config *QueueConfig) error {
+		config.Idempotent = idempotent
+		return nil
+	})
+}
+
+func (q *queueImp) RecvMessage(ctx context.Context, queue string, group string) (id string, data []byte, flag uint64, err error) {
 
 	start := time.Now()
+	ctx, span := tracing.Start(ctx, "wqs.receive", trace.SpanKindConsumer, spanAttrs(queue, group)...)
+	defer func() {
+		// 未命中不算错误
+		if err == kafka.ErrTimeout || err == kafka.ErrNoPartition {
+			tracing.End(span, nil)
+			return
+		}
+		tracing.End(span, err)
+	}()
+	q.faults.delay(ctx)
 
 	if ok := q.metadata.ExistGroup(queue, group); !ok {
 		metrics.AddMeter(metrics.CmdGetError+"."+metrics.Qps, 1)
@@ -379,88 +894,332 @@ func (q *queueImp) RecvMessage(queue string, group string) (string, []byte, uint
 		return "", nil, 0, errors.NotFoundf("queue : %q , group: %q", queue, group)
 	}
 
-	owner := queue + "@" + group
-	q.rw.RLock()
-	consumer, ok := q.consumerMap[owner]
-	q.rw.RUnlock()
-	if !ok {
-		q.rw.Lock()
-		consumer, ok = q.consumerMap[owner]
-		if !ok {
-			// 此处获取config跟之前ExistGroup并不是原子操作，存在并发风险
-			var err error
-			queueConfig := q.metadata.GetQueueConfig(queue)
-			brokerAddrs := q.metadata.GetBrokerAddrsByIdc(queueConfig.Idcs...)
-			consumer, err = kafka.NewConsumer(brokerAddrs, q.clusterConfig, queue, group)
-			if err != nil {
-				q.rw.Unlock()
-				metrics.AddMeter(metrics.CmdGetError+"."+metrics.Qps, 1)
-				log.Errorf("RecvMessage: new consumer error %v", err)
-				return "", nil, 0, err
-			}
-			q.consumerMap[owner] = consumer
-		}
-		q.rw.Unlock()
+	if config, err := q.metadata.GetGroupConfig(group, queue); err == nil && config.Paused {
+		metrics.AddCounter(queue+"."+group+"."+metrics.CmdGet+"."+metrics.Paused, 1)
+		return "", nil, 0, errors.Annotatef(ErrGroupPaused, "queue %q group %q", queue, group)
+	}
+
+	if err := q.recvBreaker.allow(); err != nil {
+		metrics.AddMeter(metrics.CmdGetError+"."+metrics.Qps, 1)
+		log.Debugf("RecvMessage: queue %q group %q %s", queue, group, err)
+		return "", nil, 0, err
+	}
+
+	// 接收前不知道消息大小, 只要字节令牌没有透支就允许接收
+	client := clientFrom(ctx)
+	if err := q.limiter.acquire(queue+"."+group,
+		q.limitRequests(queue, group, client, metrics.CmdGet, 1, 0)); err != nil {
+		metrics.AddCounter(metrics.Throttled, 1)
+		metrics.AddCounter(queue+"."+group+"."+metrics.CmdGet+"."+metrics.Throttled, 1)
+		clientThrottled(queue+"."+group+"."+metrics.CmdGet+".", client)
+		log.Debugf("RecvMessage: queue %q group %q %s", queue, group, err)
+		return "", nil, 0, err
 	}
 
-	msg, idc, err := consumer.Recv()
+	owner, consumerGroup, clusterConfig, err := q.consumerOwner(ctx, queue, group)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	var msg *sarama.ConsumerMessage
+	var message *Message
+	opts := q.consumerOptions(queue, group)
+	if queueConfig := q.metadata.GetQueueConfig(queue); priorityLevels(queueConfig) > 1 {
+		msg, message, err = q.recvPriority(ctx, owner, consumerGroup, clusterConfig, queueConfig, group, opts)
+	} else {
+		var consumer *kafka.Consumer
+		if consumer, err = q.getConsumer(owner, queue, queue, consumerGroup, clusterConfig, opts); err != nil {
+			return "", nil, 0, err
+		}
+		msg, message, err = q.recvAvailable(ctx, consumer, owner, queue, queue, group)
+	}
 	if err != nil {
 		metrics.AddCounter(metrics.CmdGetMiss, 1)
 		return "", nil, 0, err
 	}
+	q.recvBreaker.success()
+	q.limiter.charge(q.limitRequests(queue, group, client, metrics.CmdGet, 0, int64(len(message.Data))))
+
+	// 通过link关联发送方的trace, 并交给调用方返回给客户端
+	if sc := tracing.ExtractHeaders(msg.Headers); sc.IsValid() {
+		span.AddLink(trace.Link{SpanContext: sc})
+		tracing.SetMessage(ctx, sc)
+	}
+
+	sequence, _ := parseMessageKey(msg.Key)
+	span.SetAttributes(attribute.String("wqs.message_id", message.ID))
+
+	end := time.Now()
+	cost := end.Sub(start).Nanoseconds() / 1e6
+	delay := end.UnixNano()/1e6 - sequenceTime(sequence)
+
+	prefix := q.keys.prefix(queue, group, metrics.CmdGet)
+	elapse := metrics.ElapseTimeString(cost)
+	metrics.AddCounter(metrics.CmdGet, 1)
+	metrics.AddCounter(q.keys.metric(queue, group, metrics.CmdGet, metrics.Ops), 1)
+	metrics.AddCounter(q.keys.metric(queue, group, metrics.CmdGet, elapse), 1)
+	metrics.AddMeter(q.keys.metricQps(queue, group, metrics.CmdGet, elapse), 1)
+	metrics.AddMeter(q.keys.metric(queue, group, metrics.CmdGet, metrics.Qps), 1)
+	metrics.AddTimer(q.keys.metric(queue, group, metrics.CmdGet, metrics.Latency), delay)
+	metrics.AddCounter(metrics.BytesRead, int64(len(message.Data)))
+	clientMetrics(prefix, client)
+	q.msgTracer.record(TraceRecv, queue, group, message.ID, client)
+
+	log.Debugf("recv %s:%s key %s id %s cost %d delay %d", queue, group, string(msg.Key), message.ID, cost, delay)
+	return message.ID, message.Data, message.Flag, nil
+}
+
+// 返回owner的consumer, 不存在时创建, 消费queue的topic或其优先级子topic.
+// group的worker数或提交策略修改后关闭原来的consumer重新创建, 未ACK的消息从已提交的offset重新投递
+func (q *queueImp) getConsumer(owner string, queue string, topic string, consumerGroup string, clusterConfig *sarama.Config, opts kafka.ConsumerOptions) (*kafka.Consumer, error) {
+	consumer, ok := q.consumers.get(owner)
+	if ok && consumer.Options() == opts {
+		return consumer, nil
+	}
+	if ok && q.consumers.removeIf(owner, consumer) {
+		consumer.Close()
+		log.Infof("close consumer %s, options changed to %+v", owner, opts)
+	}
+
+	return q.consumers.getOrCreate(owner, func() (*kafka.Consumer, error) {
+		// 此处获取config跟之前ExistGroup并不是原子操作，存在并发风险
+		queueConfig := q.metadata.GetQueueConfig(queue)
+		brokerAddrs := q.metadata.GetBrokerAddrsByIdc(queueConfig.Idcs...)
+		consumer, err := kafka.NewConsumerWithOptions(brokerAddrs, queueConfig.Consumer.apply(clusterConfig), topic, consumerGroup, opts)
+		if err != nil {
+			q.recvBreaker.failure(err)
+			metrics.AddMeter(metrics.CmdGetError+"."+metrics.Qps, 1)
+			log.Errorf("RecvMessage: new consumer error %v", err)
+			return nil, err
+		}
+		// fetch错误在后台返回, 同样计入熔断
+		consumer.OnError(q.recvBreaker.failure)
+		return consumer, nil
+	})
+}
+
+// 设置group的限流, 0表示不限制
+func (q *queueImp) SetGroupLimit(group string, queue string, limit RateLimit) error {
+
+	if limit.MsgRate < 0 || limit.ByteRate < 0 {
+		return errors.NotValidf("limit : %+v", limit)
+	}
+
+	return q.metadata.ModifyGroupConfig(group, queue, func(config *GroupConfig) error {
+		if limit.MsgRate == 0 && limit.ByteRate == 0 {
+			config.Limit = nil
+		} else {
+			config.Limit = &limit
+		}
+		return nil
+	})
+}
+
+// 设置group的过滤表达式, 不满足的消息在接收时跳过并ACK, 空字符串表示不过滤
+func (q *queueImp) SetGroupFilter(group string, queue string, filter string) error {
+
+	if filter != "" {
+		if _, err := parseHeaderFilter(filter); err != nil {
+			return err
+		}
+	}
+
+	return q.metadata.ModifyGroupConfig(group, queue, func(config *GroupConfig) error {
+		config.Filter = filter
+		return nil
+	})
+}
+
+// 暂停或恢复group的消费, 状态保存在zookeeper中, proxy重启后仍然有效
+func (q *queueImp) SetGroupPaused(group string, queue string, paused bool) error {
+	return q.metadata.ModifyGroupConfig(group, queue, func(config *GroupConfig) error {
+		config.Paused = paused
+		return nil
+	})
+}
+
+// 设置queue的限流, 所有group共享
+func (q *queueImp) SetQueueLimit(queue string, limit RateLimit) error {
+
+	if limit.MsgRate < 0 || limit.ByteRate < 0 {
+		return errors.NotValidf("limit : %+v", limit)
+	}
+
+	return q.metadata.ModifyQueueConfig(queue, func(config *QueueConfig) error {
+		if limit.MsgRate == 0 && limit.ByteRate == 0 {
+			config.Limit = nil
+		} else {
+			config.Limit = &limit
+		}
+		return nil
+	})
+}
+
+// 生成queue、group和客户端三级的限流请求, op区分发送和接收
+func (q *queueImp) limitRequests(queue string, group string, client string, op string, msgs int64, bytes int64) []limitRequest {
+
+	// 没有配置限流时不分配
+	var reqs []limitRequest
+	add := func(group string, client string, limit *RateLimit) {
+		if limit == nil {
+			return
+		}
+		reqs = append(reqs,
+			limitRequest{key: q.keys.limit(queue, group, op, client, "msg"), rate: limit.MsgRate, n: msgs},
+			limitRequest{key: q.keys.limit(queue, group, op, client, "byte"), rate: limit.ByteRate, n: bytes})
+	}
+
+	if config := q.metadata.GetQueueConfig(queue); config != nil {
+		add("", "", config.Limit)
+	}
+	if config, err := q.metadata.GetGroupConfig(group, queue); err == nil {
+		add(group, "", config.Limit)
+		add(group, client, clientLimit(config, client))
+	}
+	return reqs
+}
+
+// 接收一条可以投递的消息, 需要丢弃, 已经过期, 不满足过滤条件, 灰度未选中和拦截器跳过的消息直接ACK
+func (q *queueImp) recvAvailable(ctx context.Context, consumer *kafka.Consumer, owner string, queue string, topic string, group string) (*sarama.ConsumerMessage, *Message, error) {
+
+	var ttl int64
+	recv := consumer.Recv
+	if config := q.metadata.GetQueueConfig(queue); config != nil {
+		// compact queue的key由客户端指定, 不包含发送时间
+		if !config.Compact {
+			ttl = config.TTL * 1e3
+		}
+		if config.Ordered {
+			recv = consumer.RecvOrdered
+		}
+	}
+
+	var filter headerFilter
+	var canary int
+	if config, err := q.metadata.GetGroupConfig(group, queue); err == nil {
+		canary = config.Canary
+		if config.Filter != "" {
+			if filter, err = parseHeaderFilter(config.Filter); err != nil {
+				log.Warnf("ignore filter of queue %q group %q: %s", queue, group, err)
+			}
+		}
+	}
+
+	quotaOwner := q.keys.owner(queue, group)
+	prefix := q.keys.prefix(queue, group, metrics.CmdGet)
+	for i := 0; i < maxDropPerRecv; i++ {
+		msg, idc, err := recv()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var reason string
+		if idc == q.metadata.local && q.quotas.dropped(quotaOwner, msg.Partition, msg.Offset) {
+			reason = metrics.Dropped
+		} else if ttl > 0 && expired(msg.Key, ttl) {
+			reason = metrics.Expired
+		} else if filter != nil && !filter.match(messageHeaders(msg.Headers)) {
+			reason = metrics.Filtered
+		} else if !canarySelected(canary, msg) {
+			reason = metrics.Unsampled
+		} else if assembled, state := q.chunks.receive(owner, idc, msg); state == chunkPending {
+			// 等待其余分片, 收到的分片暂不ACK
+			continue
+		} else if state == chunkIncomplete {
+			reason = metrics.Incomplete
+		} else {
+			message := newRecvMessage(queue, topic, group, idc, assembled)
+			// 解密失败的消息不ACK, 配置好密钥后重新投递
+			if message.Data, err = q.crypto.decrypt(assembled.Headers, assembled.Value); err != nil {
+				log.Errorf("RecvMessage: queue %q group %q partition %d offset %d %s",
+					queue, group, assembled.Partition, assembled.Offset, err)
+				return nil, nil, err
+			}
+			if !q.interceptors.hasReceive() {
+				return assembled, message, nil
+			}
+			message.Headers = messageHeaders(assembled.Headers)
+			err = q.interceptors.afterReceive(ctx, message)
+			if err == nil {
+				return assembled, message, nil
+			}
+			if errors.Cause(err) != ErrSkipMessage {
+				log.Debugf("RecvMessage: queue %q group %q intercepted %s", queue, group, err)
+				return nil, nil, err
+			}
+			reason = metrics.Intercepted
+		}
 
-	var sequence, flag uint64
-	tokens := strings.Split(string(msg.Key), ":")
-	sequence, _ = strconv.ParseUint(tokens[0], 16, 64)
-	if len(tokens) > 1 {
-		flag, _ = strconv.ParseUint(tokens[1], 16, 32)
+		if err = consumer.Ack(idc, msg.Partition, msg.Offset); err != nil {
+			log.Warnf("skip message queue:%q group:%q partition:%d offset:%d err:%s",
+				queue, group, msg.Partition, msg.Offset, err)
+		}
+		q.ackChunks(consumer, owner, idc, msg.Partition, msg.Offset)
+		metrics.AddCounter(prefix+reason, 1)
+		log.Debugf("skip %s:%s partition %d offset %d %s", queue, group, msg.Partition, msg.Offset, reason)
 	}
+	// 一次接收跳过的消息过多时按未命中处理, 避免请求耗时过长
+	return nil, nil, kafka.ErrTimeout
+}
 
+// topic为消息所在的topic, 优先级不为0时是queue的子topic
+func newRecvMessage(queue string, topic string, group string, idc string, msg *sarama.ConsumerMessage) *Message {
+	sequence, flag := parseMessageKey(msg.Key)
 	msgId := messageId{
-		queue:     queue,
+		queue:     topic,
 		group:     group,
 		idc:       idc,
 		partition: msg.Partition,
 		offset:    msg.Offset,
 		sequence:  sequence,
 	}
-	messageID := msgId.String()
+	return &Message{
+		Queue: queue,
+		Group: group,
+		ID:    msgId.String(),
+		Data:  msg.Value,
+		Flag:  flag,
+	}
+}
 
-	end := time.Now()
-	cost := end.Sub(start).Nanoseconds() / 1e6
-	delay := end.UnixNano()/1e6 - baseTime - int64((sequence>>24)&0xFFFFFFFFFF)
+// 消息生成的时间早于ttl(毫秒)之前则已过期, 无法解析key的消息不过期
+func expired(key []byte, ttl int64) bool {
+	sequence, _ := parseMessageKey(key)
+	if sequence == 0 {
+		return false
+	}
+	return time.Now().UnixNano()/1e6-sequenceTime(sequence) > ttl
+}
 
-	prefix := queue + "." + group + "." + metrics.CmdGet + "."
-	metrics.AddCounter(metrics.CmdGet, 1)
-	metrics.AddCounter(prefix+metrics.Ops, 1)
-	metrics.AddCounter(prefix+metrics.ElapseTimeString(cost), 1)
-	metrics.AddMeter(prefix+metrics.ElapseTimeString(cost)+"."+metrics.Qps, 1)
-	metrics.AddMeter(prefix+metrics.Qps, 1)
-	metrics.AddTimer(prefix+metrics.Latency, delay)
-	metrics.AddCounter(metrics.BytesRead, int64(len(msg.Value)))
+// 设置queue中消息的有效期(秒), 0表示不过期
+func (q *queueImp) SetQueueTTL(queue string, ttl int64) error {
 
-	log.Debugf("recv %s:%s key %s id %s cost %d delay %d", queue, group, string(msg.Key), messageID, cost, delay)
-	return messageID, msg.Value, flag, nil
+	if ttl < 0 {
+		return errors.NotValidf("ttl : %d", ttl)
+	}
+
+	return q.metadata.ModifyQueueConfig(queue, func(config *QueueConfig) error {
+		config.TTL = ttl
+		return nil
+	})
 }
 
 // ACK 一条消息，ACK表明该ID的消息已经被client获取到，可以从清除
-func (q *queueImp) AckMessage(queue string, group string, id string) error {
+func (q *queueImp) AckMessage(ctx context.Context, queue string, group string, id string) (err error) {
 
 	start := time.Now()
+	_, span := tracing.Start(ctx, "wqs.ack", trace.SpanKindInternal,
+		append(spanAttrs(queue, group), attribute.String("wqs.message_id", id))...)
+	defer func() { tracing.End(span, err) }()
 	if exist := q.metadata.ExistGroup(queue, group); !exist {
 		metrics.AddMeter(metrics.CmdAckError+"."+metrics.Qps, 1)
 		log.Errorf("AckMessage: queue %q group %q not found", queue, group)
 		return errors.NotFoundf("queue : %q , group: %q", queue, group)
 	}
 
-	owner := queue + "@" + group
-	q.rw.RLock()
-	consumer, ok := q.consumerMap[owner]
-	q.rw.RUnlock()
-	if !ok {
+	owner, _, _, err := q.consumerOwner(ctx, queue, group)
+	if err != nil {
 		metrics.AddMeter(metrics.CmdAckError+"."+metrics.Qps, 1)
-		log.Errorf("AckMessage: queue %q group %q not found consumer", queue, group)
-		return errors.NotFoundf("group consumer")
+		return err
 	}
 
 	msgId := &messageId{}
@@ -468,18 +1227,35 @@ func (q *queueImp) AckMessage(queue string, group string, id string) error {
 		metrics.AddMeter(metrics.CmdAckError+"."+metrics.Qps, 1)
 		return errors.NotValidf("message id: %q", id)
 	}
+	// 优先级子topic中的消息由对应的consumer ACK
+	if level, ok := topicPriority(queue, msgId.queue); ok {
+		owner = priorityOwner(owner, level)
+	}
 
+	consumer, ok := q.consumers.get(owner)
+	if !ok {
+		metrics.AddMeter(metrics.CmdAckError+"."+metrics.Qps, 1)
+		log.Errorf("AckMessage: queue %q group %q not found consumer", queue, group)
+		return errors.NotFoundf("group consumer")
+	}
+
+	if q.faults.dropAck() {
+		log.Debugf("ack %s:%s id %s dropped by fault injection", queue, group, id)
+		return nil
+	}
 	if err := consumer.Ack(msgId.idc, msgId.partition, msgId.offset); err != nil {
 		metrics.AddMeter(metrics.CmdAckError+"."+metrics.Qps, 1)
 		return err
 	}
+	q.ackChunks(consumer, owner, msgId.idc, msgId.partition, msgId.offset)
 
 	cost := time.Now().Sub(start).Nanoseconds() / 1e6
-	prefix := queue + "." + group + "." + metrics.CmdAck + "."
-	metrics.AddCounter(prefix+metrics.Ops, 1)
-	metrics.AddCounter(prefix+metrics.ElapseTimeString(cost), 1)
-	metrics.AddMeter(prefix+metrics.ElapseTimeString(cost)+"."+metrics.Qps, 1)
-	metrics.AddMeter(prefix+metrics.Qps, 1)
+	elapse := metrics.ElapseTimeString(cost)
+	metrics.AddCounter(q.keys.metric(queue, group, metrics.CmdAck, metrics.Ops), 1)
+	metrics.AddCounter(q.keys.metric(queue, group, metrics.CmdAck, elapse), 1)
+	metrics.AddMeter(q.keys.metricQps(queue, group, metrics.CmdAck, elapse), 1)
+	metrics.AddMeter(q.keys.metric(queue, group, metrics.CmdAck, metrics.Qps), 1)
+	q.msgTracer.record(TraceAck, queue, group, id, clientFrom(ctx))
 	log.Debugf("ack %s:%s key nil id %s cost %d", queue, group, id, cost)
 	return nil
 }
@@ -510,6 +1286,11 @@ func (q *queueImp) AccumulationStatus() ([]AccumulationInfo, error) {
 	return accumulationInfos, nil
 }
 
+// return "queue@group" of consumers running on this proxy
+func (q *queueImp) Consumers() []string {
+	return q.consumers.owners()
+}
+
 // return online proxys
 func (q *queueImp) Proxys() (map[string]string, error) {
 	return q.metadata.Proxys()
@@ -520,6 +1301,20 @@ func (q *queueImp) GetProxyConfigByID(id int) (string, error) {
 	return q.metadata.GetProxyConfigByID(id)
 }
 
+// 检查依赖的zookeeper, kafka和redis是否可用
+func (q *queueImp) HealthCheck() []HealthStatus {
+	status := q.metadata.Health()
+	if q.dedup.remote != nil {
+		status = append(status, newHealthStatus("redis", q.dedup.ping()))
+	}
+	return status
+}
+
+// 正在等待kafka响应的发送请求数
+func (q *queueImp) ProducerPending() int64 {
+	return atomic.LoadInt64(&q.pending)
+}
+
 // UpTime return queue running time(seconde) during queue start
 func (q *queueImp) UpTime() int64 {
 	return time.Since(q.uptime).Nanoseconds() / 1e9
@@ -538,6 +1333,10 @@ func (q *queueImp) clocked() {
 		select {
 		case <-ticker.C:
 			q.monitoring()
+			q.chunks.expire(time.Now())
+			q.injectFaults(time.Now())
+		case task := <-q.tasks:
+			task()
 		case <-q.dying:
 			return
 		}
@@ -576,6 +1375,9 @@ func (q *queueImp) monitoring() {
 		metrics.AddGauge(metrics.GcPauseMax, int64(max/1e3))
 	}
 
+	q.mirrorer.sync()
+	q.closeIdleBroadcasts()
+
 	// monitor for accumulations of all queues
 	accInfos, err := q.AccumulationStatus()
 	if err != nil {
@@ -586,6 +1388,17 @@ func (q *queueImp) monitoring() {
 	for _, i := range accInfos {
 		metrics.AddGauge(i.Queue+"."+i.Group+"."+metrics.Accum, i.Total-i.Consumed)
 	}
+
+	q.checkQuotas(accInfos)
+
+	leader := q.metadata.IsLeader()
+	if leader {
+		q.cleanExpiredQueues(accInfos)
+		q.collectOrphans()
+	}
+	if q.alerter != nil {
+		q.alerter.evaluate(accInfos, leader)
+	}
 }
 
 // load metrics data from zookeeper
@@ -604,23 +1417,39 @@ func (q *queueImp) saveMetrics() error {
 
 // close the queue
 func (q *queueImp) Close() {
-	q.rw.RLock()
-	defer q.rw.RUnlock()
-
 	close(q.dying)
 
 	if err := q.saveMetrics(); err != nil {
 		log.Errorf("queue save metrics: %v", err)
 	}
 
+	q.msgTracer.close()
 	if err := q.producer.Close(); err != nil {
 		log.Errorf("close producer err: %s", err)
 	}
+	q.producerMu.Lock()
+	if q.idempotent != nil {
+		if err := q.idempotent.Close(); err != nil {
+			log.Errorf("close idempotent producer err: %s", err)
+		}
+	}
+	if q.durable != nil {
+		if err := q.durable.Close(); err != nil {
+			log.Errorf("close durable producer err: %s", err)
+		}
+	}
+	if q.txn != nil {
+		if err := q.txn.Close(); err != nil {
+			log.Errorf("close transactional producer err: %s", err)
+		}
+	}
+	q.producerMu.Unlock()
+	q.failover.close()
 
-	for name, consumer := range q.consumerMap {
+	for _, consumer := range q.consumers.removePrefix("") {
 		consumer.Close()
-		delete(q.consumerMap, name)
 	}
+	q.mirrorer.close()
 
 	q.metadata.Close()
 }
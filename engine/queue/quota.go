@@ -0,0 +1,224 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/juju/errors"
+)
+
+const (
+	// 超过配额时拒绝发送
+	QuotaPolicyReject = "reject"
+	// 超过深度配额时继续发送, 消费时跳过最老的消息
+	QuotaPolicyDropOldest = "drop_oldest"
+
+	quotaDepth = "depth"
+	quotaDaily = "daily"
+)
+
+type QuotaExceededError struct {
+	Queue   string
+	Quota   string
+	Limit   int64
+	Current int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("queue %q exceeds %s quota %d, current %d", e.Queue, e.Quota, e.Limit, e.Current)
+}
+
+func IsQuotaExceeded(err error) bool {
+	_, ok := errors.Cause(err).(*QuotaExceededError)
+	return ok
+}
+
+func validQuota(quota *Quota) bool {
+	if quota.MaxDepth < 0 || quota.MaxDaily < 0 {
+		return false
+	}
+	switch quota.Policy {
+	case "", QuotaPolicyReject, QuotaPolicyDropOldest:
+		return true
+	}
+	return false
+}
+
+type quotaState struct {
+	depth int64
+	daily int64
+}
+
+// 缓存monitor统计的配额使用情况, 发送时只读取缓存, 不访问kafka
+type quotaKeeper struct {
+	mu     sync.RWMutex
+	states map[string]quotaState
+	// queue@group -> partition -> 允许消费的最小offset
+	floors map[string]map[int32]int64
+}
+
+func newQuotaKeeper() *quotaKeeper {
+	return &quotaKeeper{
+		states: make(map[string]quotaState),
+		floors: make(map[string]map[int32]int64),
+	}
+}
+
+func (k *quotaKeeper) check(queue string, quota *Quota) error {
+	if quota == nil {
+		return nil
+	}
+
+	k.mu.RLock()
+	state, ok := k.states[queue]
+	k.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	if quota.MaxDaily > 0 && state.daily >= quota.MaxDaily {
+		return &QuotaExceededError{Queue: queue, Quota: quotaDaily, Limit: quota.MaxDaily, Current: state.daily}
+	}
+	if quota.MaxDepth > 0 && state.depth >= quota.MaxDepth && quota.Policy != QuotaPolicyDropOldest {
+		return &QuotaExceededError{Queue: queue, Quota: quotaDepth, Limit: quota.MaxDepth, Current: state.depth}
+	}
+	return nil
+}
+
+func (k *quotaKeeper) update(queue string, state quotaState) {
+	k.mu.Lock()
+	k.states[queue] = state
+	k.mu.Unlock()
+}
+
+func (k *quotaKeeper) setFloors(owner string, floors map[int32]int64) {
+	k.mu.Lock()
+	if floors == nil {
+		delete(k.floors, owner)
+	} else {
+		k.floors[owner] = floors
+	}
+	k.mu.Unlock()
+}
+
+// 消息的offset小于floor时应当丢弃
+func (k *quotaKeeper) dropped(owner string, partition int32, offset int64) bool {
+	k.mu.RLock()
+	floor, ok := k.floors[owner][partition]
+	k.mu.RUnlock()
+	return ok && offset < floor
+}
+
+// 更新各个queue的配额使用情况, 由monitoring定期调用
+func (q *queueImp) checkQuotas(accInfos []AccumulationInfo) {
+
+	depths := make(map[string]int64)
+	for _, info := range accInfos {
+		if lag := info.Total - info.Consumed; lag > depths[info.Queue] {
+			depths[info.Queue] = lag
+		}
+	}
+
+	day := time.Now().Format("2006-01-02")
+	for _, queue := range q.metadata.GetQueues() {
+		config := q.metadata.GetQueueConfig(queue)
+		if config == nil {
+			continue
+		}
+		if config.Quota == nil {
+			for group := range config.Groups {
+				q.quotas.setFloors(queue+"@"+group, nil)
+			}
+			continue
+		}
+		quota := config.Quota
+
+		volume, err := q.metadata.Volume(queue)
+		if err != nil {
+			log.Errorf("quota: get volume of queue %q error %v", queue, err)
+			continue
+		}
+		base, err := q.metadata.DailyBase(queue, day, volume)
+		if err != nil {
+			log.Errorf("quota: get daily base of queue %q error %v", queue, err)
+			continue
+		}
+
+		state := quotaState{depth: depths[queue], daily: volume - base}
+		q.quotas.update(queue, state)
+		metrics.AddGauge(queue+".QuotaDepth", state.depth)
+		metrics.AddGauge(queue+".QuotaDaily", state.daily)
+
+		if err := q.quotas.check(queue, quota); err != nil {
+			metrics.AddCounter(queue+"."+metrics.OverQuota, 1)
+			log.Warnf("quota: %s", err)
+		}
+
+		if quota.Policy != QuotaPolicyDropOldest || quota.MaxDepth <= 0 {
+			for group := range config.Groups {
+				q.quotas.setFloors(queue+"@"+group, nil)
+			}
+			continue
+		}
+
+		// 只有堆积超过配额的group需要丢弃消息
+		var floors map[int32]int64
+		for _, info := range accInfos {
+			if info.Queue != queue {
+				continue
+			}
+			owner := queue + "@" + info.Group
+			if info.Total-info.Consumed <= quota.MaxDepth {
+				q.quotas.setFloors(owner, nil)
+				continue
+			}
+			if floors == nil {
+				if floors, err = q.metadata.DropFloors(queue, quota.MaxDepth); err != nil {
+					log.Errorf("quota: get drop floors of queue %q error %v", queue, err)
+					break
+				}
+			}
+			log.Warnf("quota: queue %q group %q depth %d exceeds %d, drop oldest messages",
+				queue, info.Group, info.Total-info.Consumed, quota.MaxDepth)
+			metrics.AddCounter(queue+"."+metrics.OverQuota, 1)
+			q.quotas.setFloors(owner, floors)
+		}
+	}
+}
+
+// 设置queue的配额, 配额为空时取消限制
+func (q *queueImp) SetQueueQuota(queue string, quota Quota) error {
+
+	if !validQuota(&quota) {
+		return errors.NotValidf("quota : %+v", quota)
+	}
+
+	return q.metadata.ModifyQueueConfig(queue, func(config *QueueConfig) error {
+		if quota.MaxDepth == 0 && quota.MaxDaily == 0 {
+			config.Quota = nil
+		} else {
+			config.Quota = &quota
+		}
+		return nil
+	})
+}
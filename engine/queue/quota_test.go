@@ -0,0 +1,93 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+)
+
+func TestQuotaKeeperCheck(t *testing.T) {
+	k := newQuotaKeeper()
+	quota := &Quota{MaxDepth: 100, MaxDaily: 1000}
+
+	if err := k.check("q", quota); err != nil {
+		t.Errorf("queue without state should pass: %v", err)
+	}
+
+	k.update("q", quotaState{depth: 50, daily: 500})
+	if err := k.check("q", quota); err != nil {
+		t.Errorf("queue under quota should pass: %v", err)
+	}
+	if err := k.check("q", nil); err != nil {
+		t.Errorf("queue without quota should pass: %v", err)
+	}
+
+	k.update("q", quotaState{depth: 100, daily: 500})
+	err := k.check("q", quota)
+	if !IsQuotaExceeded(err) || err.(*QuotaExceededError).Quota != quotaDepth {
+		t.Errorf("expect depth quota exceeded, got %v", err)
+	}
+
+	quota.Policy = QuotaPolicyDropOldest
+	if err := k.check("q", quota); err != nil {
+		t.Errorf("drop_oldest should not reject on depth: %v", err)
+	}
+
+	k.update("q", quotaState{depth: 100, daily: 1000})
+	err = k.check("q", quota)
+	if !IsQuotaExceeded(err) || err.(*QuotaExceededError).Quota != quotaDaily {
+		t.Errorf("expect daily quota exceeded, got %v", err)
+	}
+}
+
+func TestQuotaKeeperDropped(t *testing.T) {
+	k := newQuotaKeeper()
+	if k.dropped("q@g", 0, 1) {
+		t.Errorf("nothing should be dropped without floors")
+	}
+
+	k.setFloors("q@g", map[int32]int64{0: 10})
+	if !k.dropped("q@g", 0, 9) || k.dropped("q@g", 0, 10) {
+		t.Errorf("only offsets below floor should be dropped")
+	}
+	if k.dropped("q@g", 1, 0) || k.dropped("q@g2", 0, 0) {
+		t.Errorf("floors should only apply to given partition and group")
+	}
+
+	k.setFloors("q@g", nil)
+	if k.dropped("q@g", 0, 9) {
+		t.Errorf("floors should be cleared")
+	}
+}
+
+func TestValidQuota(t *testing.T) {
+	tests := []struct {
+		quota Quota
+		valid bool
+	}{
+		{Quota{}, true},
+		{Quota{MaxDepth: 10, Policy: QuotaPolicyReject}, true},
+		{Quota{MaxDepth: 10, Policy: QuotaPolicyDropOldest}, true},
+		{Quota{MaxDepth: -1}, false},
+		{Quota{MaxDaily: 10, Policy: "unknown"}, false},
+	}
+	for _, test := range tests {
+		if validQuota(&test.quota) != test.valid {
+			t.Errorf("validQuota(%+v) expect %v", test.quota, test.valid)
+		}
+	}
+}
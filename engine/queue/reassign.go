@@ -0,0 +1,125 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/log"
+
+	"github.com/juju/errors"
+)
+
+// 副本重新分布请求, Idc为空时为本机房. Partitions为空时按当前的broker列表生成方案,
+// 此时Replications<=0表示保持原来的副本数. DryRun只返回方案不执行
+type ReassignRequest struct {
+	Idc          string            `json:"idc"`
+	Replications int32             `json:"replications"`
+	Partitions   map[int32][]int32 `json:"partitions"`
+	DryRun       bool              `json:"dry_run"`
+}
+
+// queue在一个idc中的副本分布, Reassigning为kafka正在执行的重新分布
+type Reassignment struct {
+	Idc         string            `json:"idc"`
+	Current     map[int32][]int32 `json:"current"`
+	Reassigning map[int32][]int32 `json:"reassigning"`
+	Proposed    map[int32][]int32 `json:"proposed,omitempty"`
+	Executed    bool              `json:"executed"`
+}
+
+// 查询queue在idc中的副本分布和正在执行的重新分布
+func (q *queueImp) GetReassignment(queue string, idc string) (*Reassignment, error) {
+	manager, idc, err := q.metadata.queueManager(queue, idc)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return newReassignment(manager, queue, idc)
+}
+
+// 生成或者使用请求中的方案重新分布queue的副本, 数据迁移由kafka异步完成, 通过GetReassignment查看进度
+func (q *queueImp) ReassignQueue(queue string, req ReassignRequest) (*Reassignment, error) {
+	manager, idc, err := q.metadata.queueManager(queue, req.Idc)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	proposed := req.Partitions
+	if len(proposed) == 0 {
+		if proposed, err = manager.ReassignPlan(queue, req.Replications); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if !req.DryRun {
+		if err = manager.ReassignPartitions(queue, proposed); err != nil {
+			log.Errorf("reassign queue %q idc %s error %s", queue, idc, errors.ErrorStack(err))
+			return nil, errors.Trace(err)
+		}
+		log.Infof("reassign queue %q idc %s: %v", queue, idc, proposed)
+	}
+
+	result, err := newReassignment(manager, queue, idc)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	result.Proposed = proposed
+	result.Executed = !req.DryRun
+	return result, nil
+}
+
+// 把queue的partition leader切回首选副本, partitions为空时为全部partition
+func (q *queueImp) ElectPreferredLeaders(queue string, idc string, partitions []int32) error {
+	manager, idc, err := q.metadata.queueManager(queue, idc)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = manager.PreferredLeaderElection(queue, partitions); err != nil {
+		log.Errorf("elect preferred leaders of queue %q idc %s error %s", queue, idc, errors.ErrorStack(err))
+		return errors.Trace(err)
+	}
+	log.Infof("elect preferred leaders of queue %q idc %s partitions %v", queue, idc, partitions)
+	return nil
+}
+
+func newReassignment(manager *kafka.Manager, queue string, idc string) (*Reassignment, error) {
+	current, err := manager.Assignment(queue)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	reassigning, err := manager.Reassigning(queue)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &Reassignment{Idc: idc, Current: current, Reassigning: reassigning}, nil
+}
+
+// queue部署在idc中的kafka, idc为空时为本机房
+func (m *Metadata) queueManager(queue string, idc string) (*kafka.Manager, string, error) {
+	config := m.GetQueueConfig(queue)
+	if config == nil {
+		return nil, "", errors.NotFoundf("queue : %q", queue)
+	}
+	if idc == "" {
+		idc = m.local
+	}
+	if !contains(config.Idcs, idc) {
+		return nil, "", errors.NotValidf("queue %q not deployed in idc %q", queue, idc)
+	}
+	manager, ok := m.managers[idc]
+	if !ok {
+		return nil, "", errors.NotFoundf("idc : %q", idc)
+	}
+	return manager, idc, nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"os"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/log"
+
+	"github.com/juju/errors"
+)
+
+// 重新加载配置中不需要重启的部分: 报警、熔断参数和queue模板.
+// 先校验所有配置再生效, 出错时保持原来的配置. 返回其中仍然需要重启才能生效的配置项
+func (q *queueImp) Reload(conf *config.Config) ([]string, error) {
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	alerter, err := newAlerter(conf, q.metadata, hostname)
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := loadProfiles(conf, q.metadata.partitions, q.metadata.replications)
+	if err != nil {
+		return nil, err
+	}
+
+	var restart []string
+	// 熔断的开关需要重启, 阈值和熔断时间可以直接修改
+	failures, timeout := breakerConfig(conf)
+	if (failures > 0) != (q.sendBreaker != nil) {
+		restart = append(restart, "breaker.failures")
+	} else {
+		q.sendBreaker.configure(failures, timeout)
+		q.recvBreaker.configure(failures, timeout)
+	}
+
+	q.metadata.setProfiles(profiles)
+
+	// alerter只在monitoring的goroutine中使用, 同样在该goroutine中替换, 保留正在触发的报警.
+	// 关闭报警或zookeeper记录时删除已经写入的记录
+	q.runInClock(func() {
+		if q.alerter != nil && (alerter == nil || !alerter.record) {
+			q.alerter.deleteRecords()
+		}
+		if alerter != nil && q.alerter != nil {
+			alerter.firing = q.alerter.firing
+		}
+		q.alerter = alerter
+	})
+	log.Infof("queue config reloaded, %d profiles, alert enabled %v", len(profiles), alerter != nil)
+	return restart, nil
+}
+
+// 在monitoring的goroutine中执行task, queue关闭后直接丢弃
+func (q *queueImp) runInClock(task func()) {
+	select {
+	case q.tasks <- task:
+	case <-q.dying:
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+)
+
+func TestReload(t *testing.T) {
+	q := &queueImp{
+		metadata:    &Metadata{partitions: 8, replications: 2, profiles: make(map[string]*Profile)},
+		sendBreaker: newCircuitBreaker("SET", 20, 10*time.Second),
+		recvBreaker: newCircuitBreaker("GET", 20, 10*time.Second),
+		tasks:       make(chan func()),
+		dying:       make(chan struct{}),
+	}
+	go func() {
+		for task := range q.tasks {
+			task()
+		}
+	}()
+	defer close(q.tasks)
+
+	conf, err := config.NewConfigFromBytes([]byte(testAlertBaseConfig +
+		"breaker.failures=5\nbreaker.timeout=3\nprofile.bulk.partitions=32\n" +
+		"alert.enable=true\nalert.zookeeper.record=true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restart, err := q.Reload(conf)
+	if err != nil || len(restart) != 0 {
+		t.Fatalf("reload error %v, restart %v", err, restart)
+	}
+	if q.sendBreaker.threshold != 5 || q.recvBreaker.timeout != 3*time.Second {
+		t.Errorf("breaker not reconfigured: %d %v", q.sendBreaker.threshold, q.recvBreaker.timeout)
+	}
+	if profiles := q.Profiles(); len(profiles) != 1 || profiles[0].Partitions != 32 {
+		t.Errorf("unexpected profiles %v", profiles)
+	}
+	done := make(chan struct{})
+	q.runInClock(func() { close(done) })
+	<-done
+	if q.alerter == nil {
+		t.Error("alerter should be enabled")
+	}
+
+	// 关闭熔断需要重启, 无效的报警配置不生效
+	conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig + "breaker.failures=0\n"))
+	if restart, err = q.Reload(conf); err != nil || fmt.Sprint(restart) != "[breaker.failures]" {
+		t.Errorf("expect breaker.failures require restart, got %v %v", restart, err)
+	}
+	conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig + "alert.enable=true\n"))
+	if _, err = q.Reload(conf); err == nil {
+		t.Error("expect invalid alert config")
+	}
+}
+
+func TestReloadDisableAlert(t *testing.T) {
+	meta := &fakeAlertMetadata{records: map[string]string{"lag.q1.g1": "{}", "send_error_rate.1": "{}", "lag.q2.g1": "{}"}}
+	q := &queueImp{
+		metadata: &Metadata{partitions: 8, replications: 2, profiles: make(map[string]*Profile)},
+		alerter: &alerter{
+			record:   true,
+			metadata: meta,
+			firing:   map[string]bool{"lag.q1.g1": true, "send_error_rate.1": true},
+		},
+		tasks: make(chan func()),
+		dying: make(chan struct{}),
+	}
+	go func() {
+		for task := range q.tasks {
+			task()
+		}
+	}()
+	defer close(q.tasks)
+
+	// 只删除本proxy维护的记录
+	conf, _ := config.NewConfigFromBytes([]byte(testAlertBaseConfig + "alert.enable=false\n"))
+	if _, err := q.Reload(conf); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	q.runInClock(func() { close(done) })
+	<-done
+	if q.alerter != nil || len(meta.records) != 1 || meta.records["lag.q2.g1"] == "" {
+		t.Errorf("expect firing records deleted, got %v %v", q.alerter, meta.records)
+	}
+}
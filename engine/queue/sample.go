@@ -0,0 +1,112 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"time"
+
+	"github.com/weibocom/wqs/log"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+const (
+	// 默认每1000条消息采样1条
+	defaultSampleRate = 1000
+	// 采样期间HTTP请求一直等待, 不能超过http.write.timeout
+	maxSampleDuration = 30 * time.Second
+	maxSampleCount    = 1000
+)
+
+// 一段时间内新写入queue的消息的采样结果, seen为期间写入的消息总数
+type MessageSample struct {
+	Queue    string         `json:"queue"`
+	Rate     int            `json:"rate"`
+	Duration int64          `json:"duration"`
+	Seen     int64          `json:"seen"`
+	Messages []*MessageInfo `json:"messages"`
+}
+
+// 按到达顺序每rate条取1条, 取够limit条后停止
+type sampler struct {
+	idc     string
+	crypto  *payloadCrypto
+	rate    int
+	limit   int
+	preview int
+	sample  *MessageSample
+}
+
+func (s *sampler) offer(msg *sarama.ConsumerMessage) bool {
+	s.sample.Seen++
+	if (s.sample.Seen-1)%int64(s.rate) == 0 {
+		info := newMessageInfo(s.sample.Queue, "", s.idc, msg)
+		if err := decryptMessageInfo(s.crypto, info, msg); err != nil {
+			log.Warnf("sample queue %q partition %d offset %d decrypt err: %s", s.sample.Queue, msg.Partition, msg.Offset, err)
+		}
+		if len(info.Data) > s.preview {
+			info.Data = info.Data[:s.preview]
+		}
+		s.sample.Messages = append(s.sample.Messages, info)
+	}
+	return len(s.sample.Messages) < s.limit
+}
+
+// 在duration内从本机房读取新写入queue的消息, 按rate采样最多limit条, 用于排查线上消息内容.
+// 不属于任何group, 不提交offset, 返回的消息没有id, 不能ACK
+func (q *queueImp) SampleMessages(queue string, rate int, duration time.Duration, limit int, preview int) (*MessageSample, error) {
+
+	if !q.metadata.ExistQueue(queue) {
+		return nil, errors.NotFoundf("queue : %q", queue)
+	}
+	if rate <= 0 {
+		return nil, errors.NotValidf("rate : %d", rate)
+	}
+	if duration <= 0 || duration > maxSampleDuration {
+		return nil, errors.NotValidf("duration : %s", duration)
+	}
+	if limit <= 0 || limit > maxSampleCount {
+		return nil, errors.NotValidf("limit : %d", limit)
+	}
+	if preview < 0 {
+		return nil, errors.NotValidf("preview : %d", preview)
+	}
+	if preview == 0 {
+		preview = defaultPreviewSize
+	}
+
+	s := &sampler{
+		idc:     q.metadata.local,
+		crypto:  q.crypto,
+		rate:    rate,
+		limit:   limit,
+		preview: preview,
+		sample: &MessageSample{
+			Queue:    queue,
+			Rate:     rate,
+			Messages: make([]*MessageInfo, 0),
+		},
+	}
+	start := time.Now()
+	err := q.metadata.LocalManager().TailMessages(queue, duration, s.offer)
+	s.sample.Duration = int64(time.Since(start) / time.Millisecond)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return s.sample, nil
+}
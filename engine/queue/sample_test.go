@@ -0,0 +1,77 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+func TestSampler(t *testing.T) {
+	s := &sampler{
+		idc:     "idc1",
+		rate:    3,
+		limit:   3,
+		preview: 4,
+		sample:  &MessageSample{Queue: "q1", Rate: 3},
+	}
+	for i := 0; i < 6; i++ {
+		msg := &sarama.ConsumerMessage{Value: []byte(strings.Repeat("x", i+1)), Partition: int32(i % 2), Offset: int64(i)}
+		if !s.offer(msg) {
+			t.Fatalf("sampler stopped at message %d", i)
+		}
+	}
+	if s.sample.Seen != 6 || len(s.sample.Messages) != 2 {
+		t.Fatalf("unexpected sample %+v", s.sample)
+	}
+	if m := s.sample.Messages[1]; m.Offset != 3 || m.Size != 4 || m.Data != "xxxx" || m.ID != "" {
+		t.Errorf("unexpected sampled message %+v", m)
+	}
+	if s.offer(&sarama.ConsumerMessage{Value: []byte("hello world"), Offset: 6}) {
+		t.Error("sampler should stop after limit")
+	}
+	if m := s.sample.Messages[2]; m.Size != 11 || m.Data != "hell" {
+		t.Errorf("message should be truncated to preview: %+v", m)
+	}
+}
+
+func TestSampleMessagesInvalid(t *testing.T) {
+	q := &queueImp{metadata: &Metadata{queueConfigs: map[string]QueueConfig{"q1": {}}}}
+	if _, err := q.SampleMessages("q2", 1, time.Second, 1, 0); !errors.IsNotFound(err) {
+		t.Errorf("sample unknown queue err: %v", err)
+	}
+	for _, c := range []struct {
+		rate     int
+		duration time.Duration
+		limit    int
+		preview  int
+	}{
+		{0, time.Second, 1, 0},
+		{1, 0, 1, 0},
+		{1, time.Minute, 1, 0},
+		{1, time.Second, maxSampleCount + 1, 0},
+		{1, time.Second, 1, -1},
+	} {
+		if _, err := q.SampleMessages("q1", c.rate, c.duration, c.limit, c.preview); !errors.IsNotValid(err) {
+			t.Errorf("sample %+v err: %v, want not valid", c, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// 从kafka段读取sarama的参数, 未配置的使用genClusterConfig中的默认值.
+// 时间使用time.ParseDuration的格式, eg: kafka.producer.flush.frequency=1ms
+func applySaramaSettings(conf *config.Config, cc *sarama.Config) error {
+	section, err := conf.GetSection("kafka")
+	if err != nil {
+		return nil
+	}
+
+	s := &saramaSettings{section: section}
+	s.version("version", &cc.Version)
+	s.duration("net.keepalive", &cc.Net.KeepAlive)
+	s.integer("net.max.open.requests", &cc.Net.MaxOpenRequests)
+	s.duration("net.dial.timeout", &cc.Net.DialTimeout)
+	s.duration("net.read.timeout", &cc.Net.ReadTimeout)
+	s.duration("net.write.timeout", &cc.Net.WriteTimeout)
+
+	s.duration("metadata.retry.backoff", &cc.Metadata.Retry.Backoff)
+	s.integer("metadata.retry.max", &cc.Metadata.Retry.Max)
+	s.duration("metadata.refresh.frequency", &cc.Metadata.RefreshFrequency)
+
+	acks := int(cc.Producer.RequiredAcks)
+	s.integer("producer.required.acks", &acks)
+	cc.Producer.RequiredAcks = sarama.RequiredAcks(acks)
+	s.duration("producer.flush.frequency", &cc.Producer.Flush.Frequency)
+	s.integer("producer.flush.max.messages", &cc.Producer.Flush.MaxMessages)
+	s.integer("producer.retry.max", &cc.Producer.Retry.Max)
+	s.duration("producer.retry.backoff", &cc.Producer.Retry.Backoff)
+	s.integer("producer.max.message.bytes", &cc.Producer.MaxMessageBytes)
+	s.integer("channel.buffer.size", &cc.ChannelBufferSize)
+
+	s.duration("consumer.retry.backoff", &cc.Consumer.Retry.Backoff)
+	s.int32("consumer.fetch.min", &cc.Consumer.Fetch.Min)
+	s.int32("consumer.fetch.default", &cc.Consumer.Fetch.Default)
+	s.int32("consumer.fetch.max", &cc.Consumer.Fetch.Max)
+	s.duration("consumer.max.wait", &cc.Consumer.MaxWaitTime)
+	s.duration("consumer.offsets.commit.interval", &cc.Consumer.Offsets.CommitInterval)
+	s.duration("consumer.offsets.retention", &cc.Consumer.Offsets.Retention)
+
+	s.duration("group.heartbeat.interval", &cc.Consumer.Group.Heartbeat.Interval)
+	s.duration("group.session.timeout", &cc.Consumer.Group.Session.Timeout)
+	s.integer("group.offsets.retry.max", &cc.Consumer.Offsets.Retry.Max)
+
+	if len(s.invalid) > 0 {
+		return errors.NotValidf("kafka.%s", strings.Join(s.invalid, ", kafka."))
+	}
+	if acks != int(sarama.NoResponse) && acks != int(sarama.WaitForLocal) && acks != int(sarama.WaitForAll) {
+		return errors.NotValidf("kafka.producer.required.acks %d, use 0, 1 or -1", acks)
+	}
+	if err = applySASLSettings(section, "kafka", "", cc); err != nil {
+		return err
+	}
+	if err = applyTxnSettings(section, cc); err != nil {
+		return err
+	}
+	if err = cc.Validate(); err != nil {
+		return errors.NewNotValid(err, "kafka sarama config")
+	}
+	return nil
+}
+
+type saramaSettings struct {
+	section config.Section
+	invalid []string
+}
+
+func (s *saramaSettings) duration(key string, value *time.Duration) {
+	v := s.section.GetStringMust(key, "")
+	if v == "" {
+		return
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		s.invalid = append(s.invalid, key)
+		return
+	}
+	*value = d
+}
+
+// 版本决定使用的协议, 不能高于broker的版本. 消息header、delete-records等需要0.11.0.0以上
+func (s *saramaSettings) version(key string, value *sarama.KafkaVersion) {
+	v := s.section.GetStringMust(key, "")
+	if v == "" {
+		return
+	}
+	version, err := sarama.ParseKafkaVersion(v)
+	if err != nil || !sarama.MaxVersion.IsAtLeast(version) {
+		s.invalid = append(s.invalid, key)
+		return
+	}
+	*value = version
+}
+
+func (s *saramaSettings) int32(key string, value *int32) {
+	n := int(*value)
+	s.integer(key, &n)
+	if n > math.MaxInt32 || n < math.MinInt32 {
+		s.invalid = append(s.invalid, key)
+		return
+	}
+	*value = int32(n)
+}
+
+func (s *saramaSettings) integer(key string, value *int) {
+	v := s.section.GetStringMust(key, "")
+	if v == "" {
+		return
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		s.invalid = append(s.invalid, key)
+		return
+	}
+	*value = n
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+func TestSaramaSettings(t *testing.T) {
+	conf, err := config.NewConfigFromBytes([]byte(testAlertBaseConfig + "kafka.idc=bj\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := genClusterConfig("localhost")
+	if err = applySaramaSettings(conf, cc); err != nil {
+		t.Fatalf("apply default settings err: %s", err)
+	}
+	if cc.Producer.Flush.Frequency != time.Millisecond || cc.ChannelBufferSize != 1024 {
+		t.Errorf("default settings changed: %v, %d", cc.Producer.Flush.Frequency, cc.ChannelBufferSize)
+	}
+
+	conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig +
+		"kafka.producer.flush.frequency=5ms\n" +
+		"kafka.producer.required.acks=-1\n" +
+		"kafka.channel.buffer.size=4096\n" +
+		"kafka.metadata.refresh.frequency=30s\n" +
+		"kafka.version=0.11.0.0\n"))
+	cc = genClusterConfig("localhost")
+	if err = applySaramaSettings(conf, cc); err != nil {
+		t.Fatalf("apply settings err: %s", err)
+	}
+	if cc.Producer.Flush.Frequency != 5*time.Millisecond {
+		t.Errorf("flush frequency %v, want 5ms", cc.Producer.Flush.Frequency)
+	}
+	if cc.Producer.RequiredAcks != sarama.WaitForAll {
+		t.Errorf("required acks %d, want %d", cc.Producer.RequiredAcks, sarama.WaitForAll)
+	}
+	if cc.Version != sarama.V0_11_0_0 {
+		t.Errorf("version %s, want %s", cc.Version, sarama.V0_11_0_0)
+	}
+	if cc.ChannelBufferSize != 4096 || cc.Metadata.RefreshFrequency != 30*time.Second {
+		t.Errorf("settings not applied: %d, %v", cc.ChannelBufferSize, cc.Metadata.RefreshFrequency)
+	}
+
+	conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig +
+		"kafka.net.dial.timeout=10\n" +
+		"kafka.metadata.retry.max=many\n" +
+		"kafka.version=latest\n"))
+	err = applySaramaSettings(conf, genClusterConfig("localhost"))
+	if !errors.IsNotValid(err) {
+		t.Fatalf("apply invalid settings err: %v, want not valid", err)
+	}
+
+	conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig + "kafka.producer.required.acks=2\n"))
+	if err = applySaramaSettings(conf, genClusterConfig("localhost")); !errors.IsNotValid(err) {
+		t.Fatalf("apply invalid acks err: %v, want not valid", err)
+	}
+}
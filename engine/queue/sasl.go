@@ -0,0 +1,151 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"os"
+	"strings"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// 一个kafka集群的SASL配置, 在section中的key为prefix+sasl.*
+type saslSettings struct {
+	section config.Section
+	name    string
+	prefix  string
+}
+
+func (s *saslSettings) get(key, defaultVal string) string {
+	return s.section.GetStringMust(s.prefix+key, defaultVal)
+}
+
+func (s *saslSettings) invalid(key, format string, args ...interface{}) error {
+	return errors.NotValidf("%s.%s%s "+format, append([]interface{}{s.name, s.prefix, key}, args...)...)
+}
+
+// sasl.mechanism为空时不认证. GSSAPI使用keytab登录kerberos,
+// principal的格式为user/host@REALM, keytab和krb5.conf在启动时检查是否可读.
+// SCRAM-SHA-256/512使用sasl.username和sasl.password, 需要kafka 1.0以上
+func applySASLSettings(section config.Section, name, prefix string, cc *sarama.Config) error {
+	s := &saslSettings{section: section, name: name, prefix: prefix}
+	mechanism := s.get("sasl.mechanism", "")
+	switch mechanism {
+	case "":
+		return nil
+	case sarama.SASLTypeGSSAPI:
+		return s.kerberos(cc, "kafka")
+	case sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+		return s.scram(mechanism, cc)
+	}
+	return s.invalid("sasl.mechanism", "%q, expect %s, %s or %s",
+		mechanism, sarama.SASLTypeGSSAPI, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512)
+}
+
+// 配置了prefix+sasl.mechanism的集群使用单独的认证, 其他参数与base相同; 没有配置时使用base
+func clusterSASLConfig(section config.Section, name, prefix string, base *sarama.Config) (*sarama.Config, error) {
+	if section.GetStringMust(prefix+"sasl.mechanism", "") == "" {
+		return base, nil
+	}
+	cc := *base
+	cc.Net.SASL = sarama.NewConfig().Net.SASL
+	if err := applySASLSettings(section, name, prefix, &cc); err != nil {
+		return nil, err
+	}
+	if err := cc.Validate(); err != nil {
+		return nil, errors.NewNotValid(err, name+"."+prefix+"sasl")
+	}
+	return &cc, nil
+}
+
+// zookeeper的kerberos认证, key为prefix+zookeeper.sasl.*, 格式同kafka的GSSAPI配置,
+// 服务名默认为zookeeper. 没有配置时返回nil
+func zookeeperKerberos(section config.Section, name, prefix string) (*sarama.GSSAPIConfig, error) {
+	s := &saslSettings{section: section, name: name, prefix: prefix + "zookeeper."}
+	switch mechanism := s.get("sasl.mechanism", ""); mechanism {
+	case "":
+		return nil, nil
+	case sarama.SASLTypeGSSAPI:
+		cc := sarama.NewConfig()
+		if err := s.kerberos(cc, "zookeeper"); err != nil {
+			return nil, err
+		}
+		return &cc.Net.SASL.GSSAPI, nil
+	default:
+		return nil, s.invalid("sasl.mechanism", "%q, expect %s", mechanism, sarama.SASLTypeGSSAPI)
+	}
+}
+
+// 使用cluster的认证, 其他参数与base相同
+func withSASL(base, cluster *sarama.Config) *sarama.Config {
+	cc := *base
+	cc.Net.SASL = cluster.Net.SASL
+	return &cc
+}
+
+func (s *saslSettings) kerberos(cc *sarama.Config, service string) error {
+	principal := s.get("sasl.kerberos.principal", "")
+	i := strings.LastIndex(principal, "@")
+	if i <= 0 || i == len(principal)-1 {
+		return s.invalid("sasl.kerberos.principal", "%q, expect user/host@REALM", principal)
+	}
+	keytab := s.get("sasl.kerberos.keytab", "")
+	krb5 := s.get("sasl.kerberos.config", "/etc/krb5.conf")
+	if _, err := os.Stat(keytab); err != nil {
+		return s.invalid("sasl.kerberos.keytab", "%s", err)
+	}
+	if _, err := os.Stat(krb5); err != nil {
+		return s.invalid("sasl.kerberos.config", "%s", err)
+	}
+
+	cc.Net.SASL.Enable = true
+	cc.Net.SASL.Handshake = true
+	cc.Net.SASL.Mechanism = sarama.SASLTypeGSSAPI
+	cc.Net.SASL.GSSAPI = sarama.GSSAPIConfig{
+		AuthType:           sarama.KRB5_KEYTAB_AUTH,
+		KerberosConfigPath: krb5,
+		KeyTabPath:         keytab,
+		ServiceName:        s.get("sasl.kerberos.service.name", service),
+		Username:           principal[:i],
+		Realm:              principal[i+1:],
+		DisablePAFXFAST:    s.section.GetBoolMust(s.prefix+"sasl.kerberos.disable.pafxfast", false),
+	}
+	return nil
+}
+
+// SCRAM通过SaslAuthenticate请求认证, sarama总是使用v1的handshake
+func (s *saslSettings) scram(mechanism string, cc *sarama.Config) error {
+	if !cc.Version.IsAtLeast(sarama.V1_0_0_0) {
+		return s.invalid("sasl.mechanism", "%s requires kafka.version 1.0.0.0 or later, got %s", mechanism, cc.Version)
+	}
+	user, password := s.get("sasl.username", ""), s.get("sasl.password", "")
+	if user == "" || password == "" {
+		return s.invalid("sasl.username", "and sasl.password of %s", mechanism)
+	}
+
+	cc.Net.SASL.Enable = true
+	cc.Net.SASL.Handshake = true
+	cc.Net.SASL.Version = sarama.SASLHandshakeV1
+	cc.Net.SASL.Mechanism = sarama.SASLMechanism(mechanism)
+	cc.Net.SASL.User = user
+	cc.Net.SASL.Password = password
+	cc.Net.SASL.SCRAMClientGeneratorFunc = newSCRAMClientGenerator(mechanism)
+	return nil
+}
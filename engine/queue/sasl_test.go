@@ -0,0 +1,166 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+func TestKerberosSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wqs-sasl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keytab, krb5 := filepath.Join(dir, "wqs.keytab"), filepath.Join(dir, "krb5.conf")
+	ioutil.WriteFile(keytab, nil, 0600)
+	ioutil.WriteFile(krb5, nil, 0644)
+
+	kerberos := "kafka.sasl.mechanism=GSSAPI\n" +
+		"kafka.sasl.kerberos.keytab=" + keytab + "\n" +
+		"kafka.sasl.kerberos.config=" + krb5 + "\n"
+	conf, _ := config.NewConfigFromBytes([]byte(testAlertBaseConfig + kerberos +
+		"kafka.sasl.kerberos.principal=wqs/host1@EXAMPLE.COM\n"))
+	cc := genClusterConfig("localhost")
+	if err = applySaramaSettings(conf, cc); err != nil {
+		t.Fatalf("apply kerberos settings err: %s", err)
+	}
+	gssapi := cc.Net.SASL.GSSAPI
+	if !cc.Net.SASL.Enable || cc.Net.SASL.Mechanism != sarama.SASLTypeGSSAPI || gssapi.AuthType != sarama.KRB5_KEYTAB_AUTH {
+		t.Errorf("sasl not enabled: %+v", cc.Net.SASL)
+	}
+	if gssapi.Username != "wqs/host1" || gssapi.Realm != "EXAMPLE.COM" || gssapi.ServiceName != "kafka" || gssapi.KeyTabPath != keytab {
+		t.Errorf("gssapi config %+v", gssapi)
+	}
+
+	for _, c := range []string{
+		"kafka.sasl.mechanism=NTLM\n",
+		kerberos + "kafka.sasl.kerberos.principal=wqs\n",
+		kerberos + "kafka.sasl.kerberos.principal=wqs@\n",
+		"kafka.sasl.mechanism=GSSAPI\nkafka.sasl.kerberos.principal=wqs@EXAMPLE.COM\nkafka.sasl.kerberos.keytab=" + filepath.Join(dir, "missing") + "\n",
+	} {
+		conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig + c))
+		if err = applySaramaSettings(conf, genClusterConfig("localhost")); !errors.IsNotValid(err) {
+			t.Errorf("%q: err %v, want not valid", c, err)
+		}
+	}
+
+	conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig))
+	cc = genClusterConfig("localhost")
+	if err = applySaramaSettings(conf, cc); err != nil || cc.Net.SASL.Enable {
+		t.Errorf("sasl should be disabled by default: %v", err)
+	}
+}
+
+func TestSCRAMSettings(t *testing.T) {
+	scram := "kafka.version=1.0.0\nkafka.sasl.username=wqs\nkafka.sasl.password=secret\n"
+	conf, _ := config.NewConfigFromBytes([]byte(testAlertBaseConfig + scram + "kafka.sasl.mechanism=SCRAM-SHA-512\n"))
+	cc := genClusterConfig("localhost")
+	if err := applySaramaSettings(conf, cc); err != nil {
+		t.Fatalf("apply scram settings err: %s", err)
+	}
+	sasl := cc.Net.SASL
+	if !sasl.Enable || sasl.Mechanism != sarama.SASLTypeSCRAMSHA512 || sasl.Version != sarama.SASLHandshakeV1 || sasl.User != "wqs" {
+		t.Errorf("scram config %+v", sasl)
+	}
+	if c, ok := sasl.SCRAMClientGeneratorFunc().(*scramClient); !ok || c.hash().Size() != 64 {
+		t.Errorf("scram client %v", c)
+	}
+
+	for _, c := range []string{
+		"kafka.version=0.10.2.0\nkafka.sasl.mechanism=SCRAM-SHA-256\nkafka.sasl.username=wqs\nkafka.sasl.password=secret\n",
+		"kafka.version=1.0.0\nkafka.sasl.mechanism=SCRAM-SHA-256\nkafka.sasl.username=wqs\n",
+	} {
+		conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig + c))
+		if err := applySaramaSettings(conf, genClusterConfig("localhost")); !errors.IsNotValid(err) {
+			t.Errorf("%q: err %v, want not valid", c, err)
+		}
+	}
+}
+
+func TestClusterSASLConfig(t *testing.T) {
+	conf, _ := config.NewConfigFromBytes([]byte(testAlertBaseConfig +
+		"kafka.version=1.0.0\n" +
+		"kafka.remote.th.sasl.mechanism=SCRAM-SHA-256\n" +
+		"kafka.remote.th.sasl.username=th\n" +
+		"kafka.remote.th.sasl.password=secret\n" +
+		"kafka.remote.yf.sasl.mechanism=PLAIN\n"))
+	base := genClusterConfig("localhost")
+	if err := applySaramaSettings(conf, base); err != nil {
+		t.Fatal(err)
+	}
+	section, _ := conf.GetSection("kafka")
+
+	if cc, err := clusterSASLConfig(section, "kafka", "remote.bj.", base); err != nil || cc != base {
+		t.Errorf("cluster without sasl should use base config: %v", err)
+	}
+	cc, err := clusterSASLConfig(section, "kafka", "remote.th.", base)
+	if err != nil || cc == base || cc.Net.SASL.User != "th" || cc.Version != base.Version || base.Net.SASL.Enable {
+		t.Errorf("remote sasl config %+v err %v", cc, err)
+	}
+	if _, err = clusterSASLConfig(section, "kafka", "remote.yf.", base); !errors.IsNotValid(err) {
+		t.Errorf("remote with unknown mechanism: %v", err)
+	}
+
+	merged := withSASL(base, cc)
+	if merged == base || merged.Net.SASL.User != "th" || merged.ChannelBufferSize != base.ChannelBufferSize {
+		t.Errorf("withSASL %+v", merged.Net.SASL)
+	}
+}
+
+func TestZookeeperKerberos(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wqs-sasl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keytab, krb5 := filepath.Join(dir, "wqs.keytab"), filepath.Join(dir, "krb5.conf")
+	ioutil.WriteFile(keytab, nil, 0600)
+	ioutil.WriteFile(krb5, nil, 0644)
+
+	conf, _ := config.NewConfigFromBytes([]byte(testAlertBaseConfig +
+		"kafka.zookeeper.sasl.mechanism=GSSAPI\n" +
+		"kafka.zookeeper.sasl.kerberos.principal=wqs/host1@EXAMPLE.COM\n" +
+		"kafka.zookeeper.sasl.kerberos.keytab=" + keytab + "\n" +
+		"kafka.zookeeper.sasl.kerberos.config=" + krb5 + "\n"))
+	section, _ := conf.GetSection("kafka")
+	gssapi, err := zookeeperKerberos(section, "kafka", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gssapi.Username != "wqs/host1" || gssapi.Realm != "EXAMPLE.COM" || gssapi.ServiceName != "zookeeper" || gssapi.KeyTabPath != keytab {
+		t.Errorf("gssapi config %+v", gssapi)
+	}
+	// 没有配置时不认证
+	if gssapi, err = zookeeperKerberos(section, "kafka", "remote.th."); err != nil || gssapi != nil {
+		t.Errorf("remote zookeeper kerberos %+v %v, want nil", gssapi, err)
+	}
+
+	conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig + "kafka.zookeeper.sasl.mechanism=SCRAM-SHA-256\n"))
+	section, _ = conf.GetSection("kafka")
+	if _, err = zookeeperKerberos(section, "kafka", ""); !errors.IsNotValid(err) {
+		t.Errorf("err %v, want not valid", err)
+	}
+}
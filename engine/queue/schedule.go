@@ -0,0 +1,270 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/weibocom/wqs/engine/zookeeper"
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/juju/errors"
+)
+
+const (
+	// 定时消息的精度为分钟, 每秒检查一次是否进入了新的一分钟
+	scheduleCheckInterval = time.Second
+	// 定时发送的消息使用的客户端标识
+	scheduleClient = "wqs-scheduler"
+	// 并发修改定时消息时的重试次数
+	scheduleRetries = 3
+)
+
+// 按cron表达式定时发送到queue的消息, 保存在zookeeper中, 由leader按本机时区发送.
+// leader切换或没有leader期间错过的发送不会补发
+type ScheduledMessage struct {
+	Name   string `json:"name"`
+	Queue  string `json:"queue"`
+	Group  string `json:"group"`
+	Cron   string `json:"cron"`
+	Data   string `json:"data"`
+	Flag   uint64 `json:"flag"`
+	Paused bool   `json:"paused,omitempty"`
+	Ctime  int64  `json:"ctime"`
+	// 最后一次发送的时间(秒)和结果
+	LastRun   int64  `json:"last_run,omitempty"`
+	LastID    string `json:"last_id,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+func (s *ScheduledMessage) String() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// minute所在的分钟是否需要发送
+func (s *ScheduledMessage) due(minute time.Time) bool {
+	if s.Paused || s.LastRun >= minute.Unix() {
+		return false
+	}
+	cron, err := parseCron(s.Cron)
+	if err != nil {
+		return false
+	}
+	return cron.match(minute)
+}
+
+func (m *Metadata) loadSchedule(name string) (*ScheduledMessage, int32, error) {
+	path := fmt.Sprintf("%s/%s", m.schedulePath, name)
+	data, stat, err := m.zkConn.Get(path)
+	if err != nil {
+		if zookeeper.IsNoNode(err) {
+			return nil, 0, errors.NotFoundf("schedule : %q", name)
+		}
+		return nil, 0, errors.Trace(err)
+	}
+
+	s := &ScheduledMessage{}
+	if err = json.Unmarshal(data, s); err != nil {
+		return nil, 0, errors.Annotatef(err, "unmarshal %s", path)
+	}
+	s.Name = name
+	return s, stat.Version, nil
+}
+
+func (m *Metadata) GetSchedule(name string) (*ScheduledMessage, error) {
+	s, _, err := m.loadSchedule(name)
+	return s, err
+}
+
+// 直接从zookeeper中读取, 按名字排序
+func (m *Metadata) GetSchedules() ([]*ScheduledMessage, error) {
+	names, _, err := m.zkConn.Children(m.schedulePath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	sort.Strings(names)
+
+	schedules := make([]*ScheduledMessage, 0, len(names))
+	for _, name := range names {
+		s, _, err := m.loadSchedule(name)
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				log.Warnf("load schedule %q error: %s", name, err)
+			}
+			continue
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, nil
+}
+
+// 按版本号修改定时消息, 与其他proxy并发修改时重新读取后重试. modify返回false时不修改
+func (m *Metadata) ModifySchedule(name string, modify func(*ScheduledMessage) bool) (bool, error) {
+	path := fmt.Sprintf("%s/%s", m.schedulePath, name)
+	for i := 0; i < scheduleRetries; i++ {
+		s, version, err := m.loadSchedule(name)
+		if err != nil {
+			return false, err
+		}
+		if !modify(s) {
+			return false, nil
+		}
+		err = m.zkConn.CompareAndSet(path, s.String(), version)
+		if zookeeper.IsBadVersion(err) {
+			continue
+		}
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		return true, nil
+	}
+	return false, errors.Errorf("modify schedule %q conflict", name)
+}
+
+// 创建或修改定时消息, 修改时保留创建时间和最后一次发送的结果
+func (m *Metadata) SaveSchedule(s *ScheduledMessage) error {
+	path := fmt.Sprintf("%s/%s", m.schedulePath, s.Name)
+	err := m.zkConn.Create(path, s.String(), 0)
+	if err == nil {
+		return nil
+	}
+	if !zookeeper.IsExistError(err) {
+		return errors.Trace(err)
+	}
+
+	_, err = m.ModifySchedule(s.Name, func(old *ScheduledMessage) bool {
+		old.Queue, old.Group, old.Cron = s.Queue, s.Group, s.Cron
+		old.Data, old.Flag, old.Paused = s.Data, s.Flag, s.Paused
+		return true
+	})
+	return err
+}
+
+func (m *Metadata) DeleteSchedule(name string) error {
+	path := fmt.Sprintf("%s/%s", m.schedulePath, name)
+	if err := m.zkConn.Delete(path); err != nil {
+		if zookeeper.IsNoNode(err) {
+			return errors.NotFoundf("schedule : %q", name)
+		}
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// 创建或修改定时消息, 名字与queue和group的规则相同
+func (q *queueImp) SetSchedule(s *ScheduledMessage) error {
+
+	if err := checkName("schedule", s.Name); err != nil {
+		return err
+	}
+	if _, err := parseCron(s.Cron); err != nil {
+		return err
+	}
+	if ok := q.metadata.ExistGroup(s.Queue, s.Group); !ok {
+		return errors.NotFoundf("queue : %q , group: %q", s.Queue, s.Group)
+	}
+
+	s.Ctime = time.Now().Unix()
+	s.LastRun, s.LastID, s.LastError = 0, "", ""
+	return q.metadata.SaveSchedule(s)
+}
+
+func (q *queueImp) GetSchedule(name string) (*ScheduledMessage, error) {
+	return q.metadata.GetSchedule(name)
+}
+
+func (q *queueImp) Schedules() ([]*ScheduledMessage, error) {
+	return q.metadata.GetSchedules()
+}
+
+func (q *queueImp) DeleteSchedule(name string) error {
+	return q.metadata.DeleteSchedule(name)
+}
+
+// 只有leader发送定时消息, 每进入新的一分钟检查一次
+func (q *queueImp) scheduling() {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+
+	var last time.Time
+	for {
+		select {
+		case now := <-ticker.C:
+			minute := now.Truncate(time.Minute)
+			if minute.Equal(last) || !q.metadata.IsLeader() {
+				continue
+			}
+			last = minute
+			q.publishSchedules(minute)
+		case <-q.dying:
+			return
+		}
+	}
+}
+
+func (q *queueImp) publishSchedules(minute time.Time) {
+	schedules, err := q.metadata.GetSchedules()
+	if err != nil {
+		log.Errorf("get schedules error: %s", errors.ErrorStack(err))
+		return
+	}
+
+	for _, s := range schedules {
+		if !s.due(minute) {
+			continue
+		}
+		// 先记录发送时间再发送, leader切换时同一分钟最多发送一次
+		claimed, err := q.metadata.ModifySchedule(s.Name, func(s *ScheduledMessage) bool {
+			if !s.due(minute) {
+				return false
+			}
+			s.LastRun = minute.Unix()
+			return true
+		})
+		if err != nil {
+			log.Warnf("claim schedule %q error: %s", s.Name, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		var lastError string
+		ctx := WithClient(context.Background(), scheduleClient)
+		id, err := q.SendMessage(ctx, s.Queue, s.Group, []byte(s.Data), s.Flag)
+		if err != nil {
+			lastError = err.Error()
+			metrics.AddCounter(metrics.Schedule+"."+metrics.CmdSetError, 1)
+			log.Errorf("send schedule %q to queue %q error: %s", s.Name, s.Queue, err)
+		}
+		if _, err = q.metadata.ModifySchedule(s.Name, func(s *ScheduledMessage) bool {
+			if s.LastRun != minute.Unix() {
+				return false
+			}
+			s.LastID, s.LastError = id, lastError
+			return true
+		}); err != nil {
+			log.Warnf("save schedule %q result error: %s", s.Name, err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+func TestScheduleDue(t *testing.T) {
+	minute := time.Now().Truncate(time.Minute)
+	s := &ScheduledMessage{Cron: "* * * * *"}
+	if !s.due(minute) {
+		t.Error("schedule should be due")
+	}
+	s.LastRun = minute.Unix()
+	if s.due(minute) || !s.due(minute.Add(time.Minute)) {
+		t.Error("schedule should be sent once a minute")
+	}
+	s.Paused = true
+	if s.due(minute.Add(time.Minute)) {
+		t.Error("paused schedule should not be due")
+	}
+	if (&ScheduledMessage{Cron: "bad"}).due(minute) {
+		t.Error("invalid cron should not be due")
+	}
+}
+
+func TestSetScheduleInvalid(t *testing.T) {
+	q := &queueImp{
+		metadata: &Metadata{queueConfigs: map[string]QueueConfig{
+			"q1": {Groups: map[string]GroupConfig{"g1": {}}},
+		}},
+	}
+	for _, s := range []*ScheduledMessage{
+		{Name: "bad name", Queue: "q1", Group: "g1", Cron: "* * * * *"},
+		{Name: "s1", Queue: "q1", Group: "g1", Cron: "* * *"},
+	} {
+		if err := q.SetSchedule(s); !errors.IsNotValid(err) {
+			t.Errorf("set schedule %+v err: %v, want not valid", s, err)
+		}
+	}
+	if err := q.SetSchedule(&ScheduledMessage{Name: "s1", Queue: "q1", Group: "g2", Cron: "@daily"}); !errors.IsNotFound(err) {
+		t.Errorf("set schedule of unknown group err: %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/juju/errors"
+	"github.com/linkedin/goavro/v2"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+const (
+	SchemaJSON = "json"
+	SchemaAvro = "avro"
+
+	registryTimeout = 5 * time.Second
+	// confluent wire format: magic byte(0) + 4字节schema id + avro数据
+	registryMagic      = 0
+	registryHeaderSize = 5
+)
+
+type schemaValidator interface {
+	Validate(data []byte) error
+}
+
+type jsonValidator struct {
+	schema *gojsonschema.Schema
+}
+
+func (v *jsonValidator) Validate(data []byte) error {
+	result, err := v.schema.Validate(gojsonschema.NewBytesLoader(data))
+	if err != nil {
+		return errors.NotValidf("message is not json (%v)", err)
+	}
+	if !result.Valid() {
+		details := make([]string, 0, len(result.Errors()))
+		for _, e := range result.Errors() {
+			details = append(details, e.String())
+		}
+		return errors.NotValidf("message does not match schema (%s)", strings.Join(details, "; "))
+	}
+	return nil
+}
+
+// 消息为schema对应的avro二进制编码
+type avroValidator struct {
+	codec *goavro.Codec
+}
+
+func (v *avroValidator) Validate(data []byte) error {
+	return decodeAvro(v.codec, data)
+}
+
+func decodeAvro(codec *goavro.Codec, data []byte) error {
+	_, remain, err := codec.NativeFromBinary(data)
+	if err != nil {
+		return errors.NotValidf("message does not match avro schema (%v)", err)
+	}
+	if len(remain) != 0 {
+		return errors.NotValidf("message has %d trailing bytes after avro record", len(remain))
+	}
+	return nil
+}
+
+// 消息使用schema registry的格式, 按消息中的schema id取得schema后校验
+type registryValidator struct {
+	registry *schemaRegistry
+}
+
+func (v *registryValidator) Validate(data []byte) error {
+	if len(data) < registryHeaderSize || data[0] != registryMagic {
+		return errors.NotValidf("message is not in schema registry format")
+	}
+	id := int32(binary.BigEndian.Uint32(data[1:registryHeaderSize]))
+	codec, err := v.registry.codec(id)
+	if err != nil {
+		return err
+	}
+	return decodeAvro(codec, data[registryHeaderSize:])
+}
+
+// schema registry的客户端, 缓存已经取得的schema
+type schemaRegistry struct {
+	url    string
+	client *http.Client
+
+	mu     sync.RWMutex
+	codecs map[int32]*goavro.Codec
+}
+
+func newSchemaRegistry(url string) *schemaRegistry {
+	return &schemaRegistry{
+		url:    strings.TrimRight(url, "/"),
+		client: &http.Client{Timeout: registryTimeout},
+		codecs: make(map[int32]*goavro.Codec),
+	}
+}
+
+func (r *schemaRegistry) codec(id int32) (*goavro.Codec, error) {
+	r.mu.RLock()
+	codec, ok := r.codecs[id]
+	r.mu.RUnlock()
+	if ok {
+		return codec, nil
+	}
+
+	resp, err := r.client.Get(fmt.Sprintf("%s/schemas/ids/%d", r.url, id))
+	if err != nil {
+		return nil, errors.Annotatef(err, "schema registry")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.NotValidf("schema id %d not registered", id)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("schema registry returns %s for schema id %d", resp.Status, id)
+	}
+
+	result := struct {
+		Schema string `json:"schema"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, errors.Annotatef(err, "schema registry")
+	}
+	if codec, err = goavro.NewCodec(result.Schema); err != nil {
+		return nil, errors.Annotatef(err, "schema id %d", id)
+	}
+
+	r.mu.Lock()
+	r.codecs[id] = codec
+	r.mu.Unlock()
+	return codec, nil
+}
+
+type cachedValidator struct {
+	schema    Schema
+	validator schemaValidator
+}
+
+// 缓存每个queue编译后的schema, schema修改后重新编译
+type schemaChecker struct {
+	registry *schemaRegistry
+
+	mu         sync.RWMutex
+	validators map[string]cachedValidator
+}
+
+// schema段是可选的, 配置registry.url后avro的queue可以不指定schema, 通过registry校验
+func newSchemaChecker(conf *config.Config) *schemaChecker {
+	var registry *schemaRegistry
+	if section, err := conf.GetSection("schema"); err == nil {
+		if url := section.GetStringMust("registry.url", ""); url != "" {
+			registry = newSchemaRegistry(url)
+		}
+	}
+	return &schemaChecker{
+		registry:   registry,
+		validators: make(map[string]cachedValidator),
+	}
+}
+
+func (c *schemaChecker) compile(schema *Schema) (schemaValidator, error) {
+	switch schema.Type {
+	case SchemaJSON:
+		s, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(schema.Definition))
+		if err != nil {
+			return nil, errors.NotValidf("json schema (%v)", err)
+		}
+		return &jsonValidator{schema: s}, nil
+	case SchemaAvro:
+		if schema.Definition == "" {
+			if c.registry == nil {
+				return nil, errors.NotValidf("avro schema without definition requires schema.registry.url")
+			}
+			return &registryValidator{registry: c.registry}, nil
+		}
+		codec, err := goavro.NewCodec(schema.Definition)
+		if err != nil {
+			return nil, errors.NotValidf("avro schema (%v)", err)
+		}
+		return &avroValidator{codec: codec}, nil
+	default:
+		return nil, errors.NotValidf("schema type %q", schema.Type)
+	}
+}
+
+// schema为nil时不校验
+func (c *schemaChecker) validate(queue string, schema *Schema, data []byte) error {
+	if schema == nil {
+		return nil
+	}
+
+	c.mu.RLock()
+	cached, ok := c.validators[queue]
+	c.mu.RUnlock()
+	if !ok || cached.schema != *schema {
+		validator, err := c.compile(schema)
+		if err != nil {
+			return errors.Annotatef(err, "queue %q", queue)
+		}
+		cached = cachedValidator{schema: *schema, validator: validator}
+		c.mu.Lock()
+		c.validators[queue] = cached
+		c.mu.Unlock()
+	}
+	return cached.validator.Validate(data)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/linkedin/goavro/v2"
+)
+
+const testAvroSchema = `{"type":"record","name":"order","fields":[{"name":"id","type":"long"},{"name":"item","type":"string"}]}`
+
+func TestJSONSchema(t *testing.T) {
+	c := &schemaChecker{validators: make(map[string]cachedValidator)}
+	schema := &Schema{
+		Type:       SchemaJSON,
+		Definition: `{"type":"object","required":["id"],"properties":{"id":{"type":"integer"}}}`,
+	}
+
+	if err := c.validate("q", schema, []byte(`{"id":1}`)); err != nil {
+		t.Errorf("valid message rejected: %v", err)
+	}
+	err := c.validate("q", schema, []byte(`{"id":"1"}`))
+	if !errors.IsNotValid(err) || !strings.Contains(err.Error(), "id") {
+		t.Errorf("expect descriptive not valid error, got %v", err)
+	}
+	if err := c.validate("q", schema, []byte(`hello`)); !errors.IsNotValid(err) {
+		t.Errorf("expect not valid for non json message, got %v", err)
+	}
+	if err := c.validate("q", nil, []byte(`hello`)); err != nil {
+		t.Errorf("queue without schema should not be validated, got %v", err)
+	}
+
+	// schema修改后重新编译
+	schema = &Schema{Type: SchemaJSON, Definition: `{"type":"string"}`}
+	if err := c.validate("q", schema, []byte(`{"id":1}`)); !errors.IsNotValid(err) {
+		t.Errorf("expect new schema to be used, got %v", err)
+	}
+
+	if _, err := c.compile(&Schema{Type: SchemaJSON, Definition: `{`}); !errors.IsNotValid(err) {
+		t.Errorf("expect bad json schema rejected, got %v", err)
+	}
+	if _, err := c.compile(&Schema{Type: "xml"}); !errors.IsNotValid(err) {
+		t.Errorf("expect unknown schema type rejected, got %v", err)
+	}
+}
+
+func encodeAvro(t *testing.T, id int64, item string) []byte {
+	codec, err := goavro.NewCodec(testAvroSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := codec.BinaryFromNative(nil, map[string]interface{}{"id": id, "item": item})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestAvroSchema(t *testing.T) {
+	c := &schemaChecker{validators: make(map[string]cachedValidator)}
+	schema := &Schema{Type: SchemaAvro, Definition: testAvroSchema}
+
+	data := encodeAvro(t, 1, "book")
+	if err := c.validate("q", schema, data); err != nil {
+		t.Errorf("valid message rejected: %v", err)
+	}
+	if err := c.validate("q", schema, data[:len(data)-1]); !errors.IsNotValid(err) {
+		t.Errorf("expect truncated message rejected, got %v", err)
+	}
+	if err := c.validate("q", schema, append(data, 0)); !errors.IsNotValid(err) {
+		t.Errorf("expect trailing bytes rejected, got %v", err)
+	}
+
+	if _, err := c.compile(&Schema{Type: SchemaAvro}); !errors.IsNotValid(err) {
+		t.Errorf("expect avro without registry rejected, got %v", err)
+	}
+}
+
+func TestSchemaRegistry(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/schemas/ids/7" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"schema":%q}`, testAvroSchema)
+	}))
+	defer server.Close()
+
+	c := &schemaChecker{registry: newSchemaRegistry(server.URL + "/"), validators: make(map[string]cachedValidator)}
+	schema := &Schema{Type: SchemaAvro}
+
+	frame := func(id uint32, data []byte) []byte {
+		header := make([]byte, registryHeaderSize)
+		binary.BigEndian.PutUint32(header[1:], id)
+		return append(header, data...)
+	}
+
+	data := encodeAvro(t, 2, "pen")
+	for i := 0; i < 2; i++ {
+		if err := c.validate("q", schema, frame(7, data)); err != nil {
+			t.Fatalf("valid message rejected: %v", err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("schema should be cached, registry requested %d times", requests)
+	}
+	if err := c.validate("q", schema, frame(8, data)); !errors.IsNotValid(err) {
+		t.Errorf("expect unknown schema id rejected, got %v", err)
+	}
+	if err := c.validate("q", schema, data); !errors.IsNotValid(err) {
+		t.Errorf("expect message without header rejected, got %v", err)
+	}
+}
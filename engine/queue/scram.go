@@ -0,0 +1,165 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// SCRAM(RFC 5802)的客户端, 不支持channel binding, 用户名和密码不做SASLprep
+type scramClient struct {
+	hash      func() hash.Hash
+	user      string
+	password  string
+	gs2Header string
+	nonce     string
+	firstBare string
+	signature []byte
+	step      int
+}
+
+func newSCRAMClientGenerator(mechanism string) func() sarama.SCRAMClient {
+	h := sha256.New
+	if mechanism == sarama.SASLTypeSCRAMSHA512 {
+		h = sha512.New
+	}
+	return func() sarama.SCRAMClient {
+		return &scramClient{hash: h}
+	}
+}
+
+func (c *scramClient) Begin(user, password, authzID string) error {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return errors.Trace(err)
+	}
+	c.user, c.password = user, password
+	c.gs2Header = "n,,"
+	if authzID != "" {
+		c.gs2Header = "n,a=" + scramEscape(authzID) + ","
+	}
+	c.nonce = base64.RawStdEncoding.EncodeToString(b)
+	c.step = 0
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	c.step++
+	switch c.step {
+	case 1:
+		c.firstBare = "n=" + scramEscape(c.user) + ",r=" + c.nonce
+		return c.gs2Header + c.firstBare, nil
+	case 2:
+		return c.final(challenge)
+	case 3:
+		attrs := scramAttrs(challenge)
+		if e, ok := attrs["e"]; ok {
+			return "", errors.Errorf("scram server error: %s", e)
+		}
+		signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+		if err != nil || !hmac.Equal(signature, c.signature) {
+			return "", errors.New("scram server signature mismatch")
+		}
+		return "", nil
+	}
+	return "", errors.Errorf("scram step %d after done", c.step)
+}
+
+func (c *scramClient) Done() bool {
+	return c.step >= 3
+}
+
+// 根据server-first-message计算client-final-message, 同时保存用于校验服务端的签名
+func (c *scramClient) final(serverFirst string) (string, error) {
+	attrs := scramAttrs(serverFirst)
+	nonce := attrs["r"]
+	if !strings.HasPrefix(nonce, c.nonce) || len(nonce) == len(c.nonce) {
+		return "", errors.Errorf("scram server nonce %q", nonce)
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil {
+		return "", errors.Annotate(err, "scram salt")
+	}
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil || iterations < 1 {
+		return "", errors.Errorf("scram iterations %q", attrs["i"])
+	}
+
+	salted := pbkdf2(c.hash, []byte(c.password), salt, iterations)
+	clientKey := c.hmac(salted, "Client Key")
+	h := c.hash()
+	h.Write(clientKey)
+	storedKey := h.Sum(nil)
+
+	withoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte(c.gs2Header)) + ",r=" + nonce
+	authMessage := c.firstBare + "," + serverFirst + "," + withoutProof
+	proof := c.hmac(storedKey, authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	c.signature = c.hmac(c.hmac(salted, "Server Key"), authMessage)
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func (c *scramClient) hmac(key []byte, message string) []byte {
+	mac := hmac.New(c.hash, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// 用户名中的=和,需要转义
+func scramEscape(s string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(s)
+}
+
+func scramAttrs(message string) map[string]string {
+	attrs := make(map[string]string)
+	for _, field := range strings.Split(message, ",") {
+		if len(field) > 2 && field[1] == '=' {
+			attrs[field[:1]] = field[2:]
+		}
+	}
+	return attrs
+}
+
+// RFC 2898, 输出长度与hash相同, 只需要计算第一个block
+func pbkdf2(h func() hash.Hash, password, salt []byte, iterations int) []byte {
+	mac := hmac.New(h, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	result := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
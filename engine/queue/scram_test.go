@@ -0,0 +1,87 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// RFC 7677中SCRAM-SHA-256的示例
+func TestSCRAMClient(t *testing.T) {
+	c := newSCRAMClientGenerator(sarama.SASLTypeSCRAMSHA256)().(*scramClient)
+	if err := c.Begin("user", "pencil", ""); err != nil {
+		t.Fatal(err)
+	}
+	c.nonce = "rOprNGfwEbeRWgbNEkqO"
+
+	first, err := c.Step("")
+	if err != nil || first != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Fatalf("client first %q err %v", first, err)
+	}
+	final, err := c.Step("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	if err != nil || final != want || c.Done() {
+		t.Fatalf("client final %q err %v", final, err)
+	}
+	if _, err = c.Step("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err != nil || !c.Done() {
+		t.Fatalf("server final err %v", err)
+	}
+}
+
+func TestSCRAMClientErrors(t *testing.T) {
+	begin := func(user string) *scramClient {
+		c := newSCRAMClientGenerator(sarama.SASLTypeSCRAMSHA512)().(*scramClient)
+		if err := c.Begin(user, "pencil", ""); err != nil {
+			t.Fatal(err)
+		}
+		c.Step("")
+		return c
+	}
+
+	if c := begin("a=b,c"); c.firstBare != "n=a=3Db=2Cc,r="+c.nonce {
+		t.Errorf("user not escaped: %q", c.firstBare)
+	}
+	for _, serverFirst := range []string{
+		"r=other,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+		"r=%s,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+		"r=%sserver,s=!,i=4096",
+		"r=%sserver,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=0",
+	} {
+		c := begin("user")
+		if len(serverFirst) > 4 && serverFirst[2:4] == "%s" {
+			serverFirst = "r=" + c.nonce + serverFirst[4:]
+		}
+		if _, err := c.Step(serverFirst); err == nil {
+			t.Errorf("server first %q should fail", serverFirst)
+		}
+	}
+
+	c := begin("user")
+	if _, err := c.Step("r=" + c.nonce + "server,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=16"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Step("e=invalid-proof"); err == nil {
+		t.Errorf("server error should fail")
+	}
+	c = begin("user")
+	c.Step("r=" + c.nonce + "server,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=16")
+	if _, err := c.Step("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err == nil {
+		t.Errorf("wrong server signature should fail")
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// group没有提交过offset时开始消费的位置
+const (
+	GroupStartEarliest = "earliest"
+	GroupStartLatest   = "latest"
+)
+
+func validGroupStart(start string) error {
+	switch start {
+	case "", GroupStartEarliest, GroupStartLatest:
+		return nil
+	}
+	return errors.NotValidf("group start %q, use %s or %s", start, GroupStartEarliest, GroupStartLatest)
+}
+
+// 新建group时提交的offset, 未设置时从最新的消息开始
+func groupStartOffset(start string) int64 {
+	if start == GroupStartEarliest {
+		return sarama.OffsetOldest
+	}
+	return sarama.OffsetNewest
+}
+
+// 每种开始位置使用一份cluster配置, 与默认配置只有Consumer.Offsets.Initial不同
+func newStartConfigs(clusterConfig *sarama.Config) map[string]*sarama.Config {
+	configs := make(map[string]*sarama.Config)
+	for _, start := range []string{GroupStartEarliest, GroupStartLatest} {
+		config := *clusterConfig
+		config.Consumer.Offsets.Initial = groupStartOffset(start)
+		configs[start] = &config
+	}
+	return configs
+}
+
+// 返回group的consumer使用的配置, 没有设置开始位置时使用默认配置
+func (q *queueImp) groupClusterConfig(config *GroupConfig) *sarama.Config {
+	if config != nil {
+		if c, ok := q.startConfigs[config.Start]; ok {
+			return c
+		}
+	}
+	return q.clusterConfig
+}
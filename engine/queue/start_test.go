@@ -0,0 +1,59 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+func TestGroupStart(t *testing.T) {
+	for _, start := range []string{"", GroupStartEarliest, GroupStartLatest} {
+		if err := validGroupStart(start); err != nil {
+			t.Errorf("valid start %q err: %s", start, err)
+		}
+	}
+	if err := validGroupStart("oldest"); !errors.IsNotValid(err) {
+		t.Errorf("invalid start err: %v, want not valid", err)
+	}
+	if offset := groupStartOffset(""); offset != sarama.OffsetNewest {
+		t.Errorf("default start offset %d, want newest", offset)
+	}
+	if offset := groupStartOffset(GroupStartEarliest); offset != sarama.OffsetOldest {
+		t.Errorf("earliest start offset %d, want oldest", offset)
+	}
+
+	clusterConfig := genClusterConfig("localhost")
+	q := &queueImp{clusterConfig: clusterConfig, startConfigs: newStartConfigs(clusterConfig)}
+	if c := q.groupClusterConfig(nil); c != clusterConfig {
+		t.Error("group without config should use default config")
+	}
+	if c := q.groupClusterConfig(&GroupConfig{}); c != clusterConfig {
+		t.Error("group without start should use default config")
+	}
+	if c := q.groupClusterConfig(&GroupConfig{Start: GroupStartLatest}); c.Consumer.Offsets.Initial != sarama.OffsetNewest {
+		t.Errorf("latest group initial offset %d", c.Consumer.Offsets.Initial)
+	}
+	if c := q.groupClusterConfig(&GroupConfig{Start: GroupStartEarliest}); c.Consumer.Offsets.Initial != sarama.OffsetOldest {
+		t.Errorf("earliest group initial offset %d", c.Consumer.Offsets.Initial)
+	}
+	if clusterConfig.Consumer.Offsets.Initial != sarama.OffsetOldest {
+		t.Error("default config modified")
+	}
+}
@@ -24,10 +24,27 @@ import (
 )
 
 type QueueInfo struct {
-	Queue  string        `json:"queue"`
-	Ctime  int64         `json:"ctime"`
-	Length int64         `json:"length"`
-	Groups []GroupConfig `json:"groups,omitempty"`
+	Queue      string            `json:"queue"`
+	Ctime      int64             `json:"ctime"`
+	Length     int64             `json:"length"`
+	Groups     []GroupConfig     `json:"groups,omitempty"`
+	Limit      *RateLimit        `json:"limit,omitempty"`
+	Quota      *Quota            `json:"quota,omitempty"`
+	TTL        int64             `json:"ttl,omitempty"`
+	Idempotent bool              `json:"idempotent,omitempty"`
+	Mirror     *MirrorConfig     `json:"mirror,omitempty"`
+	Schema     *Schema           `json:"schema,omitempty"`
+	Ordered    bool              `json:"ordered,omitempty"`
+	Expire     int64             `json:"expire,omitempty"`
+	Profile    string            `json:"profile,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Consumer   *ConsumerTuning   `json:"consumer,omitempty"`
+	Size       *MessageSize      `json:"size,omitempty"`
+	Encryption string            `json:"encryption,omitempty"`
+	Trace      bool              `json:"trace,omitempty"`
+	Priority   *PriorityConfig   `json:"priority,omitempty"`
+	Compact    bool              `json:"compact,omitempty"`
+	Overflow   string            `json:"overflow,omitempty"`
 }
 
 type queueInfoSlice []*QueueInfo
@@ -59,11 +76,65 @@ func (q groupSlice) Swap(i, j int) {
 }
 
 type QueueConfig struct {
-	Queue  string                 `json:"queue"`
-	Ctime  int64                  `json:"ctime"`
-	Length int64                  `json:"length"`
-	Groups map[string]GroupConfig `json:"groups,omitempty"`
-	Idcs   []string               `json:"idcs,omitempty"`
+	Queue      string                 `json:"queue"`
+	Ctime      int64                  `json:"ctime"`
+	Length     int64                  `json:"length"`
+	Groups     map[string]GroupConfig `json:"groups,omitempty"`
+	Idcs       []string               `json:"idcs,omitempty"`
+	Limit      *RateLimit             `json:"limit,omitempty"`
+	Quota      *Quota                 `json:"quota,omitempty"`
+	TTL        int64                  `json:"ttl,omitempty"`
+	Idempotent bool                   `json:"idempotent,omitempty"`
+	Mirror     *MirrorConfig          `json:"mirror,omitempty"`
+	Schema     *Schema                `json:"schema,omitempty"`
+	Ordered    bool                   `json:"ordered,omitempty"`
+	Expire     int64                  `json:"expire,omitempty"`
+	Profile    string                 `json:"profile,omitempty"`
+	Tags       map[string]string      `json:"tags,omitempty"`
+	Consumer   *ConsumerTuning        `json:"consumer,omitempty"`
+	Size       *MessageSize           `json:"size,omitempty"`
+	Encryption string                 `json:"encryption,omitempty"`
+	Trace      bool                   `json:"trace,omitempty"`
+	Priority   *PriorityConfig        `json:"priority,omitempty"`
+	Compact    bool                   `json:"compact,omitempty"`
+	Overflow   string                 `json:"overflow,omitempty"`
+}
+
+// 创建queue时的选项, Idcs为空时只在本机房创建.
+// 顺序queue的topic只有一个partition, 每个group同时只投递一条未ACK的消息.
+// Expire大于0时为临时queue, 超过Expire秒没有写入和消费时自动删除.
+// 指定Profile时按配置的模板创建topic并设置queue的ttl、配额和限流.
+// Compact为true时topic使用kafka的log compaction, 同一个key只保留最新的值
+type QueueOptions struct {
+	Idcs    []string          `json:"idcs,omitempty"`
+	Ordered bool              `json:"ordered,omitempty"`
+	Compact bool              `json:"compact,omitempty"`
+	Expire  int64             `json:"expire,omitempty"`
+	Profile string            `json:"profile,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty"`
+}
+
+// 发送的消息需要符合的schema, type为json或avro.
+// avro不指定definition时消息使用schema registry的格式, 按消息中的schema id校验
+type Schema struct {
+	Type       string `json:"type"`
+	Definition string `json:"definition,omitempty"`
+}
+
+// 将queue在from机房的消息复制到to机房的同名topic
+type MirrorConfig struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Paused bool   `json:"paused,omitempty"`
+}
+
+type MirrorStatus struct {
+	Queue string `json:"queue"`
+	MirrorConfig
+	// 尚未复制的消息数
+	Lag int64 `json:"lag"`
+	// 当前proxy是否参与复制
+	Running bool `json:"running"`
 }
 
 func (q *QueueConfig) String() string {
@@ -98,12 +169,38 @@ func (i *GroupInfo) String() string {
 }
 
 type GroupConfig struct {
-	Group string   `json:"group,omitempty"`
-	Queue string   `json:"queue,omitempty"`
-	Write bool     `json:"write"`
-	Read  bool     `json:"read"`
-	Url   string   `json:"url"`
-	Ips   []string `json:"ips"`
+	Group string     `json:"group,omitempty"`
+	Queue string     `json:"queue,omitempty"`
+	Write bool       `json:"write"`
+	Read  bool       `json:"read"`
+	Url   string     `json:"url"`
+	Ips   []string   `json:"ips"`
+	Limit *RateLimit `json:"limit,omitempty"`
+	// 每个客户端的限流, key为客户端标识, AllClients对其他客户端生效
+	ClientLimits map[string]*RateLimit `json:"client_limits,omitempty"`
+	// 暂停消费, 接收消息时返回ErrGroupPaused
+	Paused bool `json:"paused,omitempty"`
+	// 按消息header过滤的表达式, 为空时不过滤
+	Filter string `json:"filter,omitempty"`
+	// 广播模式, 每个客户端实例都收到全部消息
+	Broadcast bool `json:"broadcast,omitempty"`
+	// 灰度group只接收百分之Canary的消息, 0表示接收全部
+	Canary int `json:"canary,omitempty"`
+	// 每个proxy在每个机房并行fetch的worker数, 0表示1个
+	Workers int `json:"workers,omitempty"`
+	// offset的提交策略, 为空时定时提交
+	Commit *CommitPolicy `json:"commit,omitempty"`
+	// 没有提交过offset时开始消费的位置, earliest或latest. 为空时新建的group从最新的消息开始,
+	// 之后没有offset的partition(如新增的partition)从最早的消息开始
+	Start string `json:"start,omitempty"`
+	// url为http(s)推送地址并且通过了验证请求, 推送消息只使用验证过的地址
+	Verified bool `json:"verified,omitempty"`
+}
+
+// 每秒允许的消息数和字节数, 0表示不限制
+type RateLimit struct {
+	MsgRate  int64 `json:"msg_rate,omitempty"`
+	ByteRate int64 `json:"byte_rate,omitempty"`
 }
 
 func (c *GroupConfig) Load(data []byte) error {
@@ -115,10 +212,116 @@ func (c *GroupConfig) String() string {
 	return string(data)
 }
 
+// queue的配额, 0表示不限制
+type Quota struct {
+	MaxDepth int64  `json:"max_depth,omitempty"`
+	MaxDaily int64  `json:"max_daily,omitempty"`
+	Policy   string `json:"policy,omitempty"`
+}
+
+// queue的消息大小限制(字节), Max为0时使用kafka.producer.max.message.bytes.
+// Chunk大于0时超过Chunk的消息拆成多个分片发送, 接收时由proxy合并
+type MessageSize struct {
+	Max   int `json:"max,omitempty"`
+	Chunk int `json:"chunk,omitempty"`
+}
+
+// 每天开始时queue的消息总数, 用于计算当天的消息量
+type dailyBase struct {
+	Day    string `json:"day"`
+	Volume int64  `json:"volume"`
+}
+
+func (b *dailyBase) Load(data []byte) error {
+	return json.Unmarshal(data, b)
+}
+
+func (b *dailyBase) String() string {
+	data, _ := json.Marshal(b)
+	return string(data)
+}
+
+type HealthStatus struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+func newHealthStatus(name string, err error) HealthStatus {
+	status := HealthStatus{Name: name, Healthy: err == nil}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// 只读方式获取的消息, 不影响group的消费位置. Time为消息发送的时间(毫秒),
+// Size为消息体的实际大小, 浏览消息时Data可能只包含前一部分
+type MessageInfo struct {
+	ID        string            `json:"id,omitempty"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Flag      uint64            `json:"flag"`
+	Time      int64             `json:"time"`
+	Size      int               `json:"size"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Data      string            `json:"data"`
+}
+
+// 按offset分页浏览partition中的消息, NextOffset为下一页的起始位置
+type MessagePage struct {
+	Queue      string         `json:"queue"`
+	Partition  int32          `json:"partition"`
+	Oldest     int64          `json:"oldest"`
+	Newest     int64          `json:"newest"`
+	NextOffset int64          `json:"next_offset"`
+	Messages   []*MessageInfo `json:"messages"`
+}
+
+// 把queue中一段消息复制或移动到另一个queue. 范围按offset或时间(毫秒)指定, 对每个partition生效,
+// 开始为0时从最早的消息开始, 结束为0时到开始复制时最新的消息为止(不包含结束位置)
+type TransferRequest struct {
+	To          string  `json:"to"`
+	Move        bool    `json:"move,omitempty"`
+	Partitions  []int32 `json:"partitions,omitempty"`
+	StartOffset int64   `json:"start_offset,omitempty"`
+	EndOffset   int64   `json:"end_offset,omitempty"`
+	StartTime   int64   `json:"start_time,omitempty"`
+	EndTime     int64   `json:"end_time,omitempty"`
+}
+
+const (
+	TransferRunning  = "running"
+	TransferDone     = "done"
+	TransferFailed   = "failed"
+	TransferCanceled = "canceled"
+)
+
+// 复制任务的进度, 只保存在执行该任务的proxy上
+type TransferStatus struct {
+	ID     string `json:"id"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Move   bool   `json:"move,omitempty"`
+	State  string `json:"state"`
+	Total  int64  `json:"total"`
+	Copied int64  `json:"copied"`
+	Error  string `json:"error,omitempty"`
+	Ctime  int64  `json:"ctime"`
+	Mtime  int64  `json:"mtime"`
+}
+
+// 注册在zookeeper上的proxy信息, 旧版本的proxy只有Host和Config. Config中的密码、token和密钥不发布
 type proxyInfo struct {
-	Host   string `json:"host"`
-	Config string `json:"config"`
-	config *config.Config
+	Host         string   `json:"host"`
+	Idc          string   `json:"idc,omitempty"`
+	Version      string   `json:"version,omitempty"`
+	HTTPPort     string   `json:"http_port,omitempty"`
+	McPort       string   `json:"mc_port,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	Start        int64    `json:"start,omitempty"`
+	Config       string   `json:"config"`
+	config       *config.Config
 }
 
 func (i *proxyInfo) Load(data []byte) error {
@@ -126,7 +329,7 @@ func (i *proxyInfo) Load(data []byte) error {
 }
 
 func (i *proxyInfo) String() string {
-	i.Config = i.config.String()
+	i.Config = i.config.RedactedString()
 	buff := &bytes.Buffer{}
 	json.NewEncoder(buff).Encode(i)
 	return buff.String()
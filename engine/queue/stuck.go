@@ -0,0 +1,158 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"sort"
+	"time"
+
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/log"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// 查询卡住的成员时默认的提交间隔
+const DefaultStaleCommit = 10 * time.Minute
+
+// 分配到的partition有堆积但长时间没有提交offset的成员, 可能已经卡住.
+// LastCommit为这些partition中最近一次提交的毫秒时间戳
+type StuckMember struct {
+	GroupMember
+	Stale      []int32 `json:"stale"`
+	LastCommit int64   `json:"last_commit"`
+}
+
+func isStale(lag int64, commitTime int64, stale time.Duration, now time.Time) bool {
+	// 提交时间未知时无法判断
+	if lag <= 0 || commitTime <= 0 {
+		return false
+	}
+	return now.Sub(time.Unix(0, commitTime*1e6)) > stale
+}
+
+// 有堆积但超过stale没有提交的partition
+func stalePartitions(commits []kafka.PartitionCommit, newest map[int32]int64, stale time.Duration, now time.Time) []int32 {
+	partitions := make([]int32, 0)
+	for _, c := range commits {
+		if c.Offset >= 0 && isStale(newest[c.Partition]-c.Offset, c.CommitTime, stale, now) {
+			partitions = append(partitions, c.Partition)
+		}
+	}
+	return partitions
+}
+
+func stuckMembers(desc *GroupDescription, stale time.Duration, now time.Time) []*StuckMember {
+	members := make(map[string]*StuckMember)
+	for _, p := range desc.Partitions {
+		if p.Owner == "" || !isStale(p.Lag, p.CommitTime, stale, now) {
+			continue
+		}
+		m, ok := members[p.Owner]
+		if !ok {
+			for _, member := range desc.Members {
+				if member.MemberID == p.Owner {
+					m = &StuckMember{GroupMember: *member, Stale: make([]int32, 0)}
+					break
+				}
+			}
+			if m == nil {
+				continue
+			}
+			members[p.Owner] = m
+		}
+		m.Stale = append(m.Stale, p.Partition)
+		if p.CommitTime > m.LastCommit {
+			m.LastCommit = p.CommitTime
+		}
+	}
+
+	result := make([]*StuckMember, 0, len(members))
+	for _, m := range members {
+		result = append(result, m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].MemberID < result[j].MemberID })
+	return result
+}
+
+// 返回group中超过stale没有提交offset并且有堆积的成员
+func (q *queueImp) StuckMembers(queue string, group string, stale time.Duration) ([]*StuckMember, error) {
+	if stale <= 0 {
+		return nil, errors.NotValidf("stale : %v", stale)
+	}
+	desc, err := q.DescribeGroup(queue, group)
+	if err != nil {
+		return nil, err
+	}
+	return stuckMembers(desc, stale, time.Now()), nil
+}
+
+// 强制将成员移出group, 成员是本proxy上的consumer时同时关闭该consumer, 下次接收时重新创建
+func (q *queueImp) RemoveGroupMember(queue string, group string, member string) error {
+
+	if !q.metadata.ExistGroup(queue, group) {
+		return errors.NotFoundf("queue : %q, group: %q", queue, group)
+	}
+
+	manager := q.metadata.LocalManager()
+	desc, err := manager.DescribeGroup(group)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var target *kafka.GroupMember
+	for _, m := range desc.Members {
+		if m.MemberID == member {
+			target = m
+			break
+		}
+	}
+	if target == nil {
+		return errors.NotFoundf("member %q of group %q", member, group)
+	}
+
+	if err = manager.RemoveGroupMember(group, member); err != nil {
+		return errors.Trace(err)
+	}
+	log.Warnf("remove member %s(%s) from queue %q group %q", member, target.ClientHost, queue, group)
+
+	if target.ClientID != q.clusterConfig.ClientID {
+		return nil
+	}
+	for _, owner := range priorityOwners(queue + "@" + group) {
+		consumer, ok := q.consumers.remove(owner)
+		if ok {
+			consumer.Close()
+			log.Infof("close removed consumer %s", owner)
+		}
+	}
+	return nil
+}
+
+// 有堆积但超过stale没有提交的partition, 用于报警
+func (m *Metadata) StalePartitions(queue string, group string, stale time.Duration) ([]int32, error) {
+	manager := m.LocalManager()
+	commits, err := manager.FetchGroupCommits(queue, group)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	newest, err := manager.FetchTopicOffsets(queue, sarama.OffsetNewest)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return stalePartitions(commits, newest, stale, time.Now()), nil
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/engine/kafka"
+)
+
+func TestStalePartitions(t *testing.T) {
+	now := time.Unix(1470024000, 0)
+	old := now.Add(-20*time.Minute).UnixNano() / 1e6
+	recent := now.Add(-time.Minute).UnixNano() / 1e6
+
+	commits := []kafka.PartitionCommit{
+		{Partition: 0, Offset: 10, CommitTime: old},
+		{Partition: 1, Offset: 10, CommitTime: recent},
+		{Partition: 2, Offset: 20, CommitTime: old},
+		{Partition: 3, Offset: 10},
+		{Partition: 4, Offset: -1},
+	}
+	newest := map[int32]int64{0: 15, 1: 15, 2: 20, 3: 15, 4: 15}
+	if got := fmt.Sprint(stalePartitions(commits, newest, 10*time.Minute, now)); got != "[0]" {
+		t.Errorf("expect stale partitions [0], got %s", got)
+	}
+}
+
+func TestStuckMembers(t *testing.T) {
+	now := time.Unix(1470024000, 0)
+	old := now.Add(-20*time.Minute).UnixNano() / 1e6
+	older := now.Add(-30*time.Minute).UnixNano() / 1e6
+
+	desc := &GroupDescription{
+		Members: []*GroupMember{
+			{MemberID: "m1", ClientID: "1..host1", Partitions: []int32{0, 1}},
+			{MemberID: "m2", ClientID: "2..host2", Partitions: []int32{2}},
+		},
+		Partitions: []*PartitionInfo{
+			{Partition: 0, Owner: "m1", Lag: 5, CommitTime: older},
+			{Partition: 1, Owner: "m1", Lag: 5, CommitTime: old},
+			{Partition: 2, Owner: "m2", Lag: 0, CommitTime: older},
+			{Partition: 3, Lag: 5, CommitTime: older},
+		},
+	}
+	members := stuckMembers(desc, 10*time.Minute, now)
+	if len(members) != 1 || members[0].MemberID != "m1" {
+		t.Fatalf("expect stuck member m1, got %v", members)
+	}
+	if fmt.Sprint(members[0].Stale) != "[0 1]" || members[0].LastCommit != old {
+		t.Errorf("unexpected stuck member %+v", members[0])
+	}
+}
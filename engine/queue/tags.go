@@ -0,0 +1,59 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"regexp"
+
+	"github.com/juju/errors"
+)
+
+const maxQueueTags = 16
+
+var (
+	validTagKey   = regexp.MustCompile(`^[a-zA-Z0-9_.\-]{1,32}$`)
+	validTagValue = regexp.MustCompile(`^[a-zA-Z0-9_.\-]{0,64}$`)
+)
+
+func validTags(tags map[string]string) error {
+	if len(tags) > maxQueueTags {
+		return errors.NotValidf("%d tags, max %d", len(tags), maxQueueTags)
+	}
+	for key, value := range tags {
+		if !validTagKey.MatchString(key) || !validTagValue.MatchString(value) {
+			return errors.NotValidf("tag %q:%q", key, value)
+		}
+	}
+	return nil
+}
+
+// 设置queue的标签, 替换原有的所有标签, 为空时删除标签
+func (q *queueImp) SetQueueTags(queue string, tags map[string]string) error {
+
+	if err := validTags(tags); err != nil {
+		return err
+	}
+
+	return q.metadata.ModifyQueueConfig(queue, func(config *QueueConfig) error {
+		if len(tags) == 0 {
+			config.Tags = nil
+		} else {
+			config.Tags = tags
+		}
+		return nil
+	})
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+func TestValidTags(t *testing.T) {
+	if err := validTags(map[string]string{"team": "feed", "env": "", "k8s.ns": "a-b_c"}); err != nil {
+		t.Errorf("expect valid tags, got %v", err)
+	}
+	for _, tags := range []map[string]string{
+		{"": "feed"},
+		{"team": "feed back"},
+		{"team:x": "feed"},
+	} {
+		if err := validTags(tags); !errors.IsNotValid(err) {
+			t.Errorf("expect %v rejected, got %v", tags, err)
+		}
+	}
+	tags := make(map[string]string)
+	for i := 0; i <= maxQueueTags; i++ {
+		tags[fmt.Sprintf("k%d", i)] = "v"
+	}
+	if err := validTags(tags); !errors.IsNotValid(err) {
+		t.Errorf("expect too many tags rejected, got %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/weibocom/wqs/engine/zookeeper"
+
+	"github.com/juju/errors"
+)
+
+const (
+	// 管理队列和业务方
+	RoleAdmin = "admin"
+	// 发送和接收消息
+	RoleClient = "client"
+
+	tokenBytes = 16
+	// 两次刷新之间最多缓存的不存在的token数, 超过后不再读取zookeeper
+	maxTokenMisses = 10000
+)
+
+var (
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// zookeeper中只保存token的hash, Token只在创建时返回
+type TokenInfo struct {
+	Token string `json:"token,omitempty"`
+	Hash  string `json:"hash"`
+	Role  string `json:"role"`
+	Ctime int64  `json:"ctime"`
+}
+
+func (t *TokenInfo) String() string {
+	data, _ := json.Marshal(t)
+	return string(data)
+}
+
+// admin拥有client的所有权限
+func (t *TokenInfo) HasRole(role string) bool {
+	return t.Role == RoleAdmin || t.Role == role
+}
+
+type tokenSlice []*TokenInfo
+
+func (s tokenSlice) Len() int {
+	return len(s)
+}
+
+func (s tokenSlice) Less(i, j int) bool {
+	return s[i].Ctime < s[j].Ctime
+}
+
+func (s tokenSlice) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func validRole(role string) bool {
+	return role == RoleAdmin || role == RoleClient
+}
+
+func genToken() (string, error) {
+	buf := make([]byte, tokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Trace(err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// genToken生成的token和hashToken的结果都是小写的hex
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func validToken(token string) bool {
+	return len(token) == tokenBytes*2 && isLowerHex(token)
+}
+
+func validTokenHash(hash string) bool {
+	return len(hash) == sha256.Size*2 && isLowerHex(hash)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// load all tokens from zookeeper
+func (m *Metadata) RefreshTokens() error {
+	names, _, err := m.zkConn.Children(m.tokenPath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	tokens := make(map[string]TokenInfo, len(names))
+	for _, name := range names {
+		// 旧版本以token明文作为节点名, 迁移为hash
+		if validToken(name) {
+			if err = m.migrateToken(name); err != nil {
+				continue
+			}
+			name = hashToken(name)
+		}
+		info, err := m.loadToken(name)
+		if err != nil {
+			continue
+		}
+		tokens[name] = *info
+	}
+
+	m.rw.Lock()
+	m.tokens = tokens
+	m.tokenMisses = make(map[string]struct{})
+	m.rw.Unlock()
+	return nil
+}
+
+func (m *Metadata) migrateToken(token string) error {
+	path := fmt.Sprintf("%s/%s", m.tokenPath, token)
+	info, err := m.loadToken(token)
+	if err != nil {
+		return err
+	}
+	info.Token = token
+	if err = m.AddToken(info); err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+	if err = m.zkConn.Delete(path); err != nil && !zookeeper.IsNoNode(err) {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func (m *Metadata) loadToken(hash string) (*TokenInfo, error) {
+	path := fmt.Sprintf("%s/%s", m.tokenPath, hash)
+	data, _, err := m.zkConn.Get(path)
+	if err != nil {
+		if zookeeper.IsNoNode(err) {
+			return nil, errors.NotFoundf("token")
+		}
+		return nil, errors.Trace(err)
+	}
+
+	info := &TokenInfo{}
+	if err = json.Unmarshal(data, info); err != nil {
+		return nil, errors.Annotatef(err, "unmarshal %s", path)
+	}
+	info.Token = ""
+	info.Hash = hash
+	return info, nil
+}
+
+// 缓存中不存在时从zookeeper中读取, 保证其他proxy上新创建的token立即可用.
+// 格式不对的token直接返回不存在, 不存在的token缓存到下次刷新, 避免无效的请求访问zookeeper
+func (m *Metadata) GetToken(token string) (*TokenInfo, error) {
+	if !validToken(token) {
+		return nil, errors.NotFoundf("token")
+	}
+	hash := hashToken(token)
+	m.rw.RLock()
+	info, ok := m.tokens[hash]
+	_, missed := m.tokenMisses[hash]
+	full := len(m.tokenMisses) >= maxTokenMisses
+	m.rw.RUnlock()
+	if ok {
+		return &info, nil
+	}
+	if missed || full {
+		return nil, errors.NotFoundf("token")
+	}
+
+	loaded, err := m.loadToken(hash)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			m.rw.Lock()
+			m.tokenMisses[hash] = struct{}{}
+			m.rw.Unlock()
+		}
+		return nil, err
+	}
+	m.rw.Lock()
+	m.tokens[hash] = *loaded
+	m.rw.Unlock()
+	return loaded, nil
+}
+
+func (m *Metadata) GetTokens() []*TokenInfo {
+	m.rw.RLock()
+	tokens := make([]*TokenInfo, 0, len(m.tokens))
+	for _, info := range m.tokens {
+		t := info
+		tokens = append(tokens, &t)
+	}
+	m.rw.RUnlock()
+	sort.Sort(tokenSlice(tokens))
+	return tokens
+}
+
+// 节点名和内容中都只有token的hash
+func (m *Metadata) AddToken(info *TokenInfo) error {
+	info.Hash = hashToken(info.Token)
+	stored := *info
+	stored.Token = ""
+	path := fmt.Sprintf("%s/%s", m.tokenPath, stored.Hash)
+	if err := m.zkConn.CreatePrivate(path, stored.String(), 0); err != nil {
+		if zookeeper.IsExistError(err) {
+			return errors.AlreadyExistsf("token")
+		}
+		return errors.Trace(err)
+	}
+
+	m.rw.Lock()
+	m.tokens[stored.Hash] = stored
+	delete(m.tokenMisses, stored.Hash)
+	m.rw.Unlock()
+	return nil
+}
+
+// token为创建时返回的token或者列表中的hash, 其他proxy上的缓存会在下次刷新时失效
+func (m *Metadata) DeleteToken(token string) error {
+	hash := token
+	if validToken(token) {
+		hash = hashToken(token)
+	} else if !validTokenHash(token) {
+		return errors.NotFoundf("token")
+	}
+	path := fmt.Sprintf("%s/%s", m.tokenPath, hash)
+	if err := m.zkConn.Delete(path); err != nil {
+		if zookeeper.IsNoNode(err) {
+			return errors.NotFoundf("token")
+		}
+		return errors.Trace(err)
+	}
+
+	m.rw.Lock()
+	delete(m.tokens, hash)
+	m.rw.Unlock()
+	return nil
+}
+
+// 检查token是否拥有role的权限, 配置文件中的auth.admin.token拥有所有权限
+func (q *queueImp) Authorize(token string, role string) error {
+	if token == "" {
+		return errors.Unauthorizedf("empty token")
+	}
+	if q.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(q.adminToken)) == 1 {
+		return nil
+	}
+
+	info, err := q.metadata.GetToken(token)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return errors.Unauthorizedf("invalid token")
+		}
+		return errors.Trace(err)
+	}
+	if !info.HasRole(role) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+func (q *queueImp) CreateToken(role string) (*TokenInfo, error) {
+	if !validRole(role) {
+		return nil, errors.NotValidf("role : %q", role)
+	}
+
+	token, err := genToken()
+	if err != nil {
+		return nil, err
+	}
+	info := &TokenInfo{Token: token, Role: role, Ctime: time.Now().Unix()}
+	if err = q.metadata.AddToken(info); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return info, nil
+}
+
+func (q *queueImp) DeleteToken(token string) error {
+	return q.metadata.DeleteToken(token)
+}
+
+func (q *queueImp) Tokens() ([]*TokenInfo, error) {
+	if err := q.metadata.RefreshTokens(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return q.metadata.GetTokens(), nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+func TestTokenInfoHasRole(t *testing.T) {
+	admin := &TokenInfo{Token: "a", Role: RoleAdmin}
+	client := &TokenInfo{Token: "c", Role: RoleClient}
+	if !admin.HasRole(RoleAdmin) || !admin.HasRole(RoleClient) {
+		t.Error("admin should have all roles")
+	}
+	if client.HasRole(RoleAdmin) || !client.HasRole(RoleClient) {
+		t.Error("client should only have client role")
+	}
+	if validRole("guest") || !validRole(RoleClient) {
+		t.Error("validRole error")
+	}
+}
+
+func TestValidToken(t *testing.T) {
+	token, err := genToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !validToken(token) {
+		t.Errorf("generated token %q should be valid", token)
+	}
+	for _, bad := range []string{"", "a", "../../service", token[:31] + "/", strings.ToUpper(token), token + "0"} {
+		if validToken(bad) {
+			t.Errorf("%q should not be valid", bad)
+		}
+	}
+	hash := hashToken(token)
+	if !validTokenHash(hash) || validTokenHash(token) || hash == hashToken(token[:31]+"0") {
+		t.Errorf("hash %q of %q", hash, token)
+	}
+}
+
+func TestGetTokenRejectsInvalidToken(t *testing.T) {
+	// 格式不对的token和缓存的不存在的token不访问zookeeper(zkConn为nil)
+	m := &Metadata{tokens: make(map[string]TokenInfo), tokenMisses: make(map[string]struct{})}
+	if _, err := m.GetToken("a/b"); !errors.IsNotFound(err) {
+		t.Errorf("err %v, want not found", err)
+	}
+	token, _ := genToken()
+	m.tokenMisses[hashToken(token)] = struct{}{}
+	if _, err := m.GetToken(token); !errors.IsNotFound(err) {
+		t.Errorf("err %v, want not found", err)
+	}
+	other, _ := genToken()
+	m.tokens[hashToken(other)] = TokenInfo{Hash: hashToken(other), Role: RoleClient}
+	if info, err := m.GetToken(other); err != nil || info.Role != RoleClient {
+		t.Errorf("cached token %v %v", info, err)
+	}
+	if err := m.DeleteToken("../queue"); !errors.IsNotFound(err) {
+		t.Errorf("delete err %v, want not found", err)
+	}
+}
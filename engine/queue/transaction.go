@@ -0,0 +1,274 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/metrics"
+	"github.com/weibocom/wqs/tracing"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultTxnTimeout = 10 * time.Second
+	// 一个事务最多写入的消息数
+	maxTxnMessages = 500
+)
+
+// 事务中的一条消息
+type BatchMessage struct {
+	Queue string
+	Group string
+	Data  []byte
+}
+
+// 开启kafka.transaction.enable后consumer以read_committed隔离级别消费, 不会读到未提交或abort的消息
+func applyTxnSettings(section config.Section, cc *sarama.Config) error {
+	if !section.GetBoolMust("transaction.enable", false) {
+		return nil
+	}
+	if !cc.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return errors.NotValidf("kafka.transaction.enable with kafka.version %s, need 0.11.0.0 or later", cc.Version)
+	}
+	cc.Consumer.IsolationLevel = sarama.ReadCommitted
+	return nil
+}
+
+// 返回事务的超时时间, 0表示没有开启事务
+func newTxnTimeout(conf *config.Config) (time.Duration, error) {
+	section, err := conf.GetSection("kafka")
+	if err != nil || !section.GetBoolMust("transaction.enable", false) {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(section.GetStringMust("transaction.timeout", defaultTxnTimeout.String()))
+	if err != nil || timeout <= 0 {
+		return 0, errors.NotValidf("kafka.transaction.timeout")
+	}
+	return timeout, nil
+}
+
+// transactional.id为wqs-<proxy id>, proxy重启后fence掉旧进程未完成的事务
+func (q *queueImp) getTxnProducer() (*kafka.TxnProducer, error) {
+	q.producerMu.Lock()
+	defer q.producerMu.Unlock()
+	if q.txn == nil {
+		producer, err := kafka.NewTxnProducer(q.metadata.LocalManager().BrokerAddrs(), q.clusterConfig,
+			"wqs-"+strconv.Itoa(q.conf.ProxyId), q.txnTimeout)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		q.txn = producer
+	}
+	return q.txn, nil
+}
+
+// 在一个kafka事务中写入一批消息, 可以跨queue. 全部成功时返回各消息的id, 否则一条都不会被read_committed的consumer读到.
+// 不支持compact queue和分片, 只写入优先级0, 不切换到standby集群
+func (q *queueImp) SendMessages(ctx context.Context, msgs []BatchMessage) (ids []string, err error) {
+
+	if q.txnTimeout == 0 {
+		return nil, errors.NotSupportedf("transaction with kafka.transaction.enable=false")
+	}
+	if len(msgs) == 0 || len(msgs) > maxTxnMessages {
+		return nil, errors.NotValidf("%d messages in transaction, expect 1 to %d", len(msgs), maxTxnMessages)
+	}
+
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "wqs.send.transaction", trace.SpanKindProducer,
+		attribute.String("messaging.system", "kafka"), attribute.Int("wqs.messages", len(msgs)))
+	defer func() { tracing.End(span, err) }()
+	q.faults.delay(ctx)
+
+	if err := q.sendBreaker.allow(); err != nil {
+		metrics.AddCounter(metrics.CmdSetError, 1)
+		metrics.AddMeter(metrics.CmdSetError+"."+metrics.Qps, 1)
+		log.Debugf("SendMessages: %s", err)
+		return nil, err
+	}
+
+	records := make([]kafka.TxnMessage, len(msgs))
+	sequences := make([]uint64, len(msgs))
+	for i := range msgs {
+		if records[i], err = q.txnMessage(ctx, &msgs[i]); err != nil {
+			log.Debugf("SendMessages: queue %q group %q %s", msgs[i].Queue, msgs[i].Group, err)
+			return nil, err
+		}
+		sequences[i] = q.idGenerator.Get()
+		records[i].Key = messageKey(sequences[i], 0)
+	}
+	if err = q.txnAcquire(msgs, records, clientFrom(ctx)); err != nil {
+		return nil, err
+	}
+
+	producer, err := q.getTxnProducer()
+	if err != nil {
+		metrics.AddCounter(metrics.CmdSetError, 1)
+		metrics.AddMeter(metrics.CmdSetError+"."+metrics.Qps, 1)
+		log.Errorf("SendMessages: get transactional producer error %s", err)
+		return nil, err
+	}
+	var offsets []kafka.TxnOffset
+	if q.faults.failProduce() {
+		err = ErrFaultInjected
+	} else {
+		offsets, err = producer.Send(records)
+	}
+	if err != nil {
+		q.sendBreaker.failure(err)
+		metrics.AddCounter(metrics.CmdSetError, 1)
+		metrics.AddMeter(metrics.CmdSetError+"."+metrics.Qps, 1)
+		log.Errorf("SendMessages: %d messages error %s", len(msgs), err)
+		return nil, err
+	}
+	q.sendBreaker.success()
+
+	cost := time.Now().Sub(start).Nanoseconds() / 1e6
+	elapse := metrics.ElapseTimeString(cost)
+	ids = make([]string, len(msgs))
+	for i, msg := range msgs {
+		msgId := messageId{
+			queue:     records[i].Topic,
+			group:     msg.Group,
+			idc:       q.metadata.local,
+			partition: offsets[i].Partition,
+			offset:    offsets[i].Offset,
+			sequence:  sequences[i],
+		}
+		ids[i] = msgId.String()
+		metrics.AddCounter(metrics.CmdSet, 1)
+		metrics.AddCounter(q.keys.metric(msg.Queue, msg.Group, metrics.CmdSet, metrics.Ops), 1)
+		metrics.AddCounter(q.keys.metric(msg.Queue, msg.Group, metrics.CmdSet, elapse), 1)
+		metrics.AddMeter(q.keys.metric(msg.Queue, msg.Group, metrics.CmdSet, metrics.Qps), 1)
+		metrics.AddCounter(metrics.BytesWriten, int64(len(records[i].Value)))
+		q.msgTracer.record(TraceSend, msg.Queue, msg.Group, ids[i], clientFrom(ctx))
+	}
+	log.Debugf("send %d messages in transaction cost %d", len(msgs), cost)
+	return ids, nil
+}
+
+// 与SendMessage相同的检查, 返回待写入的消息, key由调用方设置
+func (q *queueImp) txnMessage(ctx context.Context, msg *BatchMessage) (kafka.TxnMessage, error) {
+	record := kafka.TxnMessage{Topic: msg.Queue}
+	queue, group := msg.Queue, msg.Group
+	if ok := q.metadata.ExistGroup(queue, group); !ok {
+		return record, errors.NotFoundf("queue : %q , group: %q", queue, group)
+	}
+
+	message := &Message{Queue: queue, Group: group, Data: msg.Data, Headers: headersFrom(ctx)}
+	if err := q.interceptors.beforeSend(ctx, message); err != nil {
+		metrics.AddCounter(queue+"."+group+"."+metrics.CmdSet+"."+metrics.Intercepted, 1)
+		return record, err
+	}
+	data := message.Data
+
+	var err error
+	var encryptKey string
+	config := q.metadata.GetQueueConfig(queue)
+	if config != nil {
+		if record.Topic, err = sendTopic(queue, config, 0); err != nil {
+			return record, err
+		}
+		if _, err = sendKey(queue, config, ""); err != nil {
+			return record, err
+		}
+		if err = q.quotas.check(queue, config.Quota); err != nil {
+			metrics.AddCounter(queue+"."+group+"."+metrics.CmdSet+"."+metrics.OverQuota, 1)
+			return record, err
+		}
+		if err = q.isrGuard.check(queue, q.metadata.minInsync(config.Profile), time.Now()); err != nil {
+			metrics.AddCounter(queue+"."+group+"."+metrics.CmdSet+"."+metrics.UnderISR, 1)
+			if q.isrGuard.rejects() {
+				return record, err
+			}
+		}
+		if err = q.schemas.validate(queue, config.Schema, data); err != nil {
+			metrics.AddCounter(queue+"."+group+"."+metrics.CmdSet+"."+metrics.Invalid, 1)
+			return record, err
+		}
+		encryptKey = config.Encryption
+	}
+	if encryptKey != "" {
+		if data, err = q.crypto.encrypt(encryptKey, data); err != nil {
+			return record, err
+		}
+	}
+
+	// 事务中不分片, 单条消息不能超过producer的限制
+	maxSize, _ := q.messageSize(config)
+	if maxSize > q.clusterConfig.Producer.MaxMessageBytes {
+		maxSize = q.clusterConfig.Producer.MaxMessageBytes
+	}
+	if len(data) > maxSize {
+		metrics.AddCounter(queue+"."+group+"."+metrics.CmdSet+"."+metrics.TooLarge, 1)
+		return record, &MessageTooLargeError{Queue: queue, Size: len(data), Max: maxSize}
+	}
+
+	record.Value = data
+	record.Headers = append(tracing.InjectHeaders(ctx), recordHeaders(message.Headers)...)
+	if encryptKey != "" {
+		record.Headers = append(record.Headers, sarama.RecordHeader{Key: []byte(encryptHeader), Value: []byte(encryptKey)})
+	}
+	return record, nil
+}
+
+// 与SendMessage相同的限流, 每个queue.group按消息数和加密后的总字节数申请一次
+func (q *queueImp) txnAcquire(msgs []BatchMessage, records []kafka.TxnMessage, client string) error {
+	type usage struct {
+		queue, group string
+		msgs, bytes  int64
+	}
+	usages := make(map[string]*usage)
+	for i, msg := range msgs {
+		target := msg.Queue + "." + msg.Group
+		u, ok := usages[target]
+		if !ok {
+			u = &usage{queue: msg.Queue, group: msg.Group}
+			usages[target] = u
+		}
+		u.msgs++
+		u.bytes += int64(len(records[i].Value))
+	}
+	targets := make([]string, 0, len(usages))
+	for target := range usages {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	for _, target := range targets {
+		u := usages[target]
+		if err := q.limiter.acquire(target,
+			q.limitRequests(u.queue, u.group, client, metrics.CmdSet, u.msgs, u.bytes)); err != nil {
+			metrics.AddCounter(metrics.Throttled, 1)
+			metrics.AddCounter(target+"."+metrics.CmdSet+"."+metrics.Throttled, 1)
+			clientThrottled(target+"."+metrics.CmdSet+".", client)
+			log.Debugf("SendMessages: queue %q group %q %s", u.queue, u.group, err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/engine/kafka"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+func TestTxnSettings(t *testing.T) {
+	conf, _ := config.NewConfigFromBytes([]byte(testAlertBaseConfig + "kafka.idc=bj\n"))
+	cc := genClusterConfig("localhost")
+	if err := applySaramaSettings(conf, cc); err != nil || cc.Consumer.IsolationLevel != sarama.ReadUncommitted {
+		t.Errorf("transaction should be disabled by default: %v %d", err, cc.Consumer.IsolationLevel)
+	}
+	if timeout, err := newTxnTimeout(conf); err != nil || timeout != 0 {
+		t.Errorf("expect no transaction timeout, got %v %v", timeout, err)
+	}
+
+	conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig +
+		"kafka.transaction.enable=true\n" +
+		"kafka.version=0.11.0.0\n"))
+	cc = genClusterConfig("localhost")
+	if err := applySaramaSettings(conf, cc); err != nil || cc.Consumer.IsolationLevel != sarama.ReadCommitted {
+		t.Errorf("expect read_committed consumers: %v %d", err, cc.Consumer.IsolationLevel)
+	}
+	if timeout, err := newTxnTimeout(conf); err != nil || timeout != defaultTxnTimeout {
+		t.Errorf("expect default transaction timeout, got %v %v", timeout, err)
+	}
+
+	conf, _ = config.NewConfigFromBytes([]byte(testAlertBaseConfig +
+		"kafka.transaction.enable=true\n" +
+		"kafka.transaction.timeout=-1s\n" +
+		"kafka.version=0.10.2.0\n"))
+	if err := applySaramaSettings(conf, genClusterConfig("localhost")); !errors.IsNotValid(err) {
+		t.Errorf("expect not valid for kafka 0.10, got %v", err)
+	}
+	if _, err := newTxnTimeout(conf); !errors.IsNotValid(err) {
+		t.Errorf("expect not valid timeout, got %v", err)
+	}
+}
+
+func TestSendMessagesDisabled(t *testing.T) {
+	q := &queueImp{}
+	if _, err := q.SendMessages(context.Background(), []BatchMessage{{Queue: "q", Group: "g"}}); !errors.IsNotSupported(err) {
+		t.Errorf("expect not supported without kafka.transaction.enable, got %v", err)
+	}
+	q.txnTimeout = time.Second
+	if _, err := q.SendMessages(context.Background(), nil); !errors.IsNotValid(err) {
+		t.Errorf("expect not valid for empty transaction, got %v", err)
+	}
+}
+
+func TestTxnAcquire(t *testing.T) {
+	q := &queueImp{
+		metadata: &Metadata{queueConfigs: map[string]QueueConfig{
+			"q1": {Queue: "q1", Limit: &RateLimit{MsgRate: 3}, Groups: map[string]GroupConfig{"g1": {}, "g2": {}}},
+			"q2": {Queue: "q2", Groups: map[string]GroupConfig{"g1": {}}},
+		}},
+		limiter: newRateLimiter(),
+		keys:    newKeyCache(),
+	}
+	msgs := []BatchMessage{{Queue: "q1", Group: "g1"}, {Queue: "q1", Group: "g2"}, {Queue: "q2", Group: "g1"}}
+	records := []kafka.TxnMessage{{Value: []byte("a")}, {Value: []byte("b")}, {Value: []byte("c")}}
+	if err := q.txnAcquire(msgs, records, ""); err != nil {
+		t.Fatalf("first transaction should pass: %v", err)
+	}
+	// q1每秒3条, 已经用掉2条, 再写入2条时限流
+	msgs = []BatchMessage{{Queue: "q1", Group: "g1"}, {Queue: "q1", Group: "g1"}}
+	records = records[:2]
+	if _, ok := q.txnAcquire(msgs, records, "").(*ThrottledError); !ok {
+		t.Errorf("expect throttled")
+	}
+}
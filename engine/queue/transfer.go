@@ -0,0 +1,359 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+const (
+	// 每次从源partition读取的消息数
+	transferBatch = 500
+	// 最多保留的已经结束的任务数
+	maxFinishedTransfers = 100
+)
+
+// 读取和删除源queue的消息, kafka.Manager实现了该接口
+type transferSource interface {
+	FetchTopicOffsets(topic string, time int64) (map[int32]int64, error)
+	FetchMessages(topic string, partition int32, offset int64, count int) ([]*sarama.ConsumerMessage, error)
+	DeleteRecords(topic string, offsets map[int32]int64) (map[int32]int64, error)
+}
+
+type transferSink func(topic string, key, data []byte, headers []sarama.RecordHeader) (int32, int64, error)
+
+// partition中[start, end)范围的消息
+type transferRange struct {
+	partition int32
+	start     int64
+	end       int64
+}
+
+// 根据请求计算每个partition需要复制的范围, 移动只支持从最早的消息开始的范围,
+// 因为kafka只能删除partition头部的消息
+func planTransfer(src transferSource, queue string, req TransferRequest) ([]transferRange, error) {
+
+	if (req.StartOffset != 0 || req.EndOffset != 0) && (req.StartTime != 0 || req.EndTime != 0) {
+		return nil, errors.NotValidf("transfer range by both offset and time")
+	}
+	if req.StartOffset < 0 || req.EndOffset < 0 || req.StartTime < 0 || req.EndTime < 0 {
+		return nil, errors.NotValidf("negative transfer range")
+	}
+
+	oldest, err := src.FetchTopicOffsets(queue, sarama.OffsetOldest)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	newest, err := src.FetchTopicOffsets(queue, sarama.OffsetNewest)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	starts, ends := oldest, newest
+	if req.StartTime != 0 {
+		if starts, err = src.FetchTopicOffsets(queue, req.StartTime); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if req.EndTime != 0 {
+		if ends, err = src.FetchTopicOffsets(queue, req.EndTime); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	partitions := req.Partitions
+	if len(partitions) == 0 {
+		for partition := range newest {
+			partitions = append(partitions, partition)
+		}
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+	ranges := make([]transferRange, 0, len(partitions))
+	for _, partition := range partitions {
+		if _, ok := newest[partition]; !ok {
+			return nil, errors.NotFoundf("queue %q partition %d", queue, partition)
+		}
+		r := transferRange{partition: partition, start: starts[partition], end: ends[partition]}
+		// 没有晚于指定时间的消息时返回的offset为-1
+		if r.start < 0 {
+			r.start = newest[partition]
+		}
+		if r.end < 0 {
+			r.end = newest[partition]
+		}
+		if req.StartOffset > r.start {
+			r.start = req.StartOffset
+		}
+		if req.EndOffset != 0 && req.EndOffset < r.end {
+			r.end = req.EndOffset
+		}
+		if r.start < oldest[partition] {
+			r.start = oldest[partition]
+		}
+		if req.Move && r.start > oldest[partition] && r.start < r.end {
+			return nil, errors.NotValidf("move partition %d from offset %d, oldest is %d", partition, r.start, oldest[partition])
+		}
+		if r.start < r.end {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges, nil
+}
+
+type transferJob struct {
+	src    transferSource
+	send   transferSink
+	ranges []transferRange
+	dying  chan struct{}
+
+	mu     sync.Mutex
+	status TransferStatus
+}
+
+func newTransferJob(id string, from string, req TransferRequest, src transferSource,
+	send transferSink, ranges []transferRange) *transferJob {
+
+	now := time.Now().Unix()
+	job := &transferJob{
+		src:    src,
+		send:   send,
+		ranges: ranges,
+		dying:  make(chan struct{}),
+		status: TransferStatus{
+			ID:    id,
+			From:  from,
+			To:    req.To,
+			Move:  req.Move,
+			State: TransferRunning,
+			Ctime: now,
+			Mtime: now,
+		},
+	}
+	for _, r := range ranges {
+		job.status.Total += r.end - r.start
+	}
+	return job
+}
+
+func (j *transferJob) run() {
+	err := j.copy()
+	if err == nil && j.status.Move {
+		offsets := make(map[int32]int64, len(j.ranges))
+		for _, r := range j.ranges {
+			offsets[r.partition] = r.end
+		}
+		if _, err = j.src.DeleteRecords(j.status.From, offsets); err != nil {
+			err = errors.Annotatef(err, "delete moved messages")
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch {
+	case err == errTransferCanceled:
+		j.status.State = TransferCanceled
+	case err != nil:
+		j.status.State = TransferFailed
+		j.status.Error = err.Error()
+	default:
+		j.status.State = TransferDone
+	}
+	j.status.Mtime = time.Now().Unix()
+	log.Infof("transfer %s from %q to %q %s, copied %d/%d %s",
+		j.status.ID, j.status.From, j.status.To, j.status.State, j.status.Copied, j.status.Total, j.status.Error)
+}
+
+var errTransferCanceled = errors.New("transfer canceled")
+
+// 按partition依次复制, 消息的key和header保持不变
+func (j *transferJob) copy() error {
+	from, to := j.status.From, j.status.To
+	for _, r := range j.ranges {
+		for offset := r.start; offset < r.end; {
+			select {
+			case <-j.dying:
+				return errTransferCanceled
+			default:
+			}
+
+			msgs, err := j.src.FetchMessages(from, r.partition, offset, transferBatch)
+			if err != nil {
+				return errors.Annotatef(err, "fetch partition %d offset %d", r.partition, offset)
+			}
+			if len(msgs) == 0 {
+				return errors.Errorf("no message fetched at partition %d offset %d", r.partition, offset)
+			}
+			for _, msg := range msgs {
+				if msg.Offset >= r.end {
+					break
+				}
+				headers := make([]sarama.RecordHeader, 0, len(msg.Headers))
+				for _, h := range msg.Headers {
+					headers = append(headers, *h)
+				}
+				if _, _, err = j.send(to, msg.Key, msg.Value, headers); err != nil {
+					return errors.Annotatef(err, "send partition %d offset %d", r.partition, msg.Offset)
+				}
+				metrics.AddCounter(from+"."+metrics.Transfer+"."+metrics.Ops, 1)
+				j.progress(1)
+			}
+			offset = msgs[len(msgs)-1].Offset + 1
+		}
+	}
+	return nil
+}
+
+func (j *transferJob) progress(n int64) {
+	j.mu.Lock()
+	j.status.Copied += n
+	j.status.Mtime = time.Now().Unix()
+	j.mu.Unlock()
+}
+
+func (j *transferJob) snapshot() *TransferStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := j.status
+	return &status
+}
+
+// 保存本proxy上启动的复制任务, proxy重启后丢失
+type transferKeeper struct {
+	mu   sync.Mutex
+	jobs map[string]*transferJob
+}
+
+func newTransferKeeper() *transferKeeper {
+	return &transferKeeper{jobs: make(map[string]*transferJob)}
+}
+
+func (k *transferKeeper) start(job *transferJob) {
+	k.mu.Lock()
+	k.jobs[job.status.ID] = job
+	k.evict()
+	k.mu.Unlock()
+	go job.run()
+}
+
+// 已经结束的任务过多时删除最早的
+func (k *transferKeeper) evict() {
+	finished := make([]*TransferStatus, 0)
+	for _, job := range k.jobs {
+		if status := job.snapshot(); status.State != TransferRunning {
+			finished = append(finished, status)
+		}
+	}
+	if len(finished) <= maxFinishedTransfers {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].Mtime < finished[j].Mtime })
+	for _, status := range finished[:len(finished)-maxFinishedTransfers] {
+		delete(k.jobs, status.ID)
+	}
+}
+
+func (k *transferKeeper) get(id string) (*transferJob, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	job, ok := k.jobs[id]
+	if !ok {
+		return nil, errors.NotFoundf("transfer %q", id)
+	}
+	return job, nil
+}
+
+func (k *transferKeeper) list() []*TransferStatus {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	statuses := make([]*TransferStatus, 0, len(k.jobs))
+	for _, job := range k.jobs {
+		statuses = append(statuses, job.snapshot())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Ctime < statuses[j].Ctime })
+	return statuses
+}
+
+func (k *transferKeeper) cancel(id string) error {
+	job, err := k.get(id)
+	if err != nil {
+		return err
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.status.State != TransferRunning {
+		return errors.NotValidf("transfer %q is %s", id, job.status.State)
+	}
+	select {
+	case <-job.dying:
+	default:
+		close(job.dying)
+	}
+	return nil
+}
+
+// 在本机房把queue中的一段消息复制或移动到另一个queue, 任务在后台执行, 返回任务的初始状态
+func (q *queueImp) StartTransfer(queue string, req TransferRequest) (*TransferStatus, error) {
+
+	if !q.metadata.ExistQueue(queue) {
+		return nil, errors.NotFoundf("queue : %q", queue)
+	}
+	if !q.metadata.ExistQueue(req.To) {
+		return nil, errors.NotFoundf("queue : %q", req.To)
+	}
+	if req.To == queue {
+		return nil, errors.NotValidf("transfer queue %q to itself", queue)
+	}
+
+	manager := q.metadata.LocalManager()
+	ranges, err := planTransfer(manager, queue, req)
+	if err != nil {
+		return nil, err
+	}
+
+	id := fmt.Sprintf("%x", q.idGenerator.Get())
+	job := newTransferJob(id, queue, req, manager, q.producer.Send, ranges)
+	status := job.snapshot()
+	q.transfers.start(job)
+	log.Infof("transfer %s from %q to %q started, move %v, %d messages", id, queue, req.To, req.Move, status.Total)
+	return status, nil
+}
+
+func (q *queueImp) GetTransfer(id string) (*TransferStatus, error) {
+	job, err := q.transfers.get(id)
+	if err != nil {
+		return nil, err
+	}
+	return job.snapshot(), nil
+}
+
+func (q *queueImp) Transfers() []*TransferStatus {
+	return q.transfers.list()
+}
+
+// 取消正在执行的任务, 已经复制的消息不会回滚, 移动的任务不会删除源消息
+func (q *queueImp) CancelTransfer(id string) error {
+	return q.transfers.cancel(id)
+}
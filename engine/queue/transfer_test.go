@@ -0,0 +1,139 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// 每个partition的offset为[oldest, newest), 消息的时间为offset*1000
+type fakeTransferSource struct {
+	oldest  map[int32]int64
+	newest  map[int32]int64
+	deleted map[int32]int64
+}
+
+func (f *fakeTransferSource) FetchTopicOffsets(topic string, t int64) (map[int32]int64, error) {
+	switch t {
+	case sarama.OffsetOldest:
+		return f.oldest, nil
+	case sarama.OffsetNewest:
+		return f.newest, nil
+	}
+	offsets := make(map[int32]int64)
+	for p, newest := range f.newest {
+		offsets[p] = t / 1000
+		if offsets[p] >= newest {
+			offsets[p] = -1
+		}
+	}
+	return offsets, nil
+}
+
+func (f *fakeTransferSource) FetchMessages(topic string, partition int32, offset int64, count int) ([]*sarama.ConsumerMessage, error) {
+	msgs := make([]*sarama.ConsumerMessage, 0, count)
+	for o := offset; o < f.newest[partition] && len(msgs) < count; o++ {
+		msgs = append(msgs, &sarama.ConsumerMessage{Partition: partition, Offset: o, Key: []byte("k"), Value: []byte("v")})
+	}
+	return msgs, nil
+}
+
+func (f *fakeTransferSource) DeleteRecords(topic string, offsets map[int32]int64) (map[int32]int64, error) {
+	f.deleted = offsets
+	return offsets, nil
+}
+
+func TestPlanTransfer(t *testing.T) {
+	src := &fakeTransferSource{
+		oldest: map[int32]int64{0: 10, 1: 0},
+		newest: map[int32]int64{0: 100, 1: 50},
+	}
+
+	ranges, err := planTransfer(src, "q", TransferRequest{To: "r"})
+	if err != nil || len(ranges) != 2 || ranges[0] != (transferRange{0, 10, 100}) || ranges[1] != (transferRange{1, 0, 50}) {
+		t.Errorf("expect whole partitions, got %v %v", ranges, err)
+	}
+
+	ranges, err = planTransfer(src, "q", TransferRequest{To: "r", StartOffset: 20, EndOffset: 60})
+	if err != nil || len(ranges) != 2 || ranges[0] != (transferRange{0, 20, 60}) || ranges[1] != (transferRange{1, 20, 50}) {
+		t.Errorf("expect offset range, got %v %v", ranges, err)
+	}
+
+	ranges, err = planTransfer(src, "q", TransferRequest{To: "r", Partitions: []int32{1}, StartTime: 5000, EndTime: 60000})
+	if err != nil || len(ranges) != 1 || ranges[0] != (transferRange{1, 5, 50}) {
+		t.Errorf("expect time range, got %v %v", ranges, err)
+	}
+
+	if _, err = planTransfer(src, "q", TransferRequest{To: "r", StartOffset: 1, EndTime: 1}); !errors.IsNotValid(err) {
+		t.Errorf("expect offset and time rejected, got %v", err)
+	}
+	if _, err = planTransfer(src, "q", TransferRequest{To: "r", Partitions: []int32{2}}); !errors.IsNotFound(err) {
+		t.Errorf("expect unknown partition rejected, got %v", err)
+	}
+	if _, err = planTransfer(src, "q", TransferRequest{To: "r", Move: true, StartOffset: 20}); !errors.IsNotValid(err) {
+		t.Errorf("expect move from middle rejected, got %v", err)
+	}
+}
+
+func TestTransferJob(t *testing.T) {
+	src := &fakeTransferSource{
+		oldest: map[int32]int64{0: 0, 1: 0},
+		newest: map[int32]int64{0: transferBatch + 10, 1: 5},
+	}
+	sent := 0
+	send := func(topic string, key, data []byte, headers []sarama.RecordHeader) (int32, int64, error) {
+		if topic != "r" {
+			t.Errorf("expect send to r, got %s", topic)
+		}
+		sent++
+		return 0, 0, nil
+	}
+
+	req := TransferRequest{To: "r", Move: true, EndOffset: transferBatch + 5}
+	ranges, err := planTransfer(src, "q", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keeper := newTransferKeeper()
+	job := newTransferJob("1", "q", req, src, send, ranges)
+	keeper.start(job)
+
+	deadline := time.Now().Add(time.Second)
+	for job.snapshot().State == TransferRunning && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	status := job.snapshot()
+	if status.State != TransferDone || status.Copied != transferBatch+10 || status.Total != status.Copied || sent != int(status.Copied) {
+		t.Errorf("unexpected status %+v, sent %d", status, sent)
+	}
+	if src.deleted[0] != transferBatch+5 || src.deleted[1] != 5 {
+		t.Errorf("expect moved messages deleted, got %v", src.deleted)
+	}
+	if err := keeper.cancel("1"); !errors.IsNotValid(err) {
+		t.Errorf("expect cancel finished transfer rejected, got %v", err)
+	}
+	if err := keeper.cancel("2"); !errors.IsNotFound(err) {
+		t.Errorf("expect unknown transfer not found, got %v", err)
+	}
+	if list := keeper.list(); len(list) != 1 || list[0].ID != "1" {
+		t.Errorf("unexpected transfers %v", list)
+	}
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"github.com/weibocom/wqs/engine/kafka"
+
+	"github.com/juju/errors"
+)
+
+const maxGroupWorkers = 16
+
+// 接收group的消息时consumer的worker数和offset提交策略, worker数没有设置时为1
+func (q *queueImp) consumerOptions(queue string, group string) kafka.ConsumerOptions {
+	opts := kafka.ConsumerOptions{Workers: 1}
+	if config, err := q.metadata.GetGroupConfig(group, queue); err == nil {
+		if config.Workers > 1 {
+			opts.Workers = config.Workers
+		}
+		opts.Commit = config.Commit.policy()
+	}
+	return opts
+}
+
+// 设置每个proxy在每个机房并行fetch的worker数, 每个worker是kafka group的一个成员,
+// 不能超过本机房topic的partition数. 各个proxy在下次接收时按新的worker数重建consumer, 0表示恢复为1个
+func (q *queueImp) SetGroupWorkers(group string, queue string, workers int) error {
+
+	if workers < 0 || workers > maxGroupWorkers {
+		return errors.NotValidf("workers : %d, expect 0~%d", workers, maxGroupWorkers)
+	}
+	if ok := q.metadata.ExistGroup(queue, group); !ok {
+		return errors.NotFoundf("queue : %q , group: %q", queue, group)
+	}
+	partitions, err := q.metadata.LocalManager().Partitions(queue)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if workers > len(partitions) {
+		return errors.NotValidf("workers %d more than %d partitions of queue %q", workers, len(partitions), queue)
+	}
+
+	return q.metadata.ModifyGroupConfig(group, queue, func(config *GroupConfig) error {
+		config.Workers = workers
+		return nil
+	})
+}
@@ -0,0 +1,32 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+func TestSetGroupWorkersInvalid(t *testing.T) {
+	q := &queueImp{}
+	for _, workers := range []int{-1, maxGroupWorkers + 1} {
+		if err := q.SetGroupWorkers("g1", "q1", workers); !errors.IsNotValid(err) {
+			t.Errorf("set workers %d err: %v, want not valid", workers, err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zookeeper
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/log"
+)
+
+// 读取配置节点的超时时间, 超时后使用本地配置文件
+const configTimeout = 5 * time.Second
+
+// 保存在zookeeper节点中的配置, 格式与配置文件相同, 所有proxy共用同一个节点.
+// 本地配置文件中的proxy段(proxy.id等每个实例不同的配置)覆盖节点中的配置,
+// 启动时读取节点失败则完全使用本地配置文件
+type ConfigSource struct {
+	addrs     []string
+	path      string
+	local     string
+	connector Connector
+	conn      *Conn
+	version   int32
+}
+
+// 按本地配置文件中的认证配置连接zookeeper
+type Connector func(addrs []string, local *config.Config) (*Conn, error)
+
+// uri为zookeeper地址加节点路径, eg: host1:2181,host2:2181/wqs/config.
+// connector为nil时不使用认证
+func NewConfigSource(uri string, local string, connector Connector) (*ConfigSource, error) {
+	i := strings.Index(uri, "/")
+	if i <= 0 || i == len(uri)-1 {
+		return nil, errors.NotValidf("config uri : %q", uri)
+	}
+	return &ConfigSource{
+		addrs:     strings.Split(uri[:i], ","),
+		path:      uri[i:],
+		local:     local,
+		connector: connector,
+		version:   -1,
+	}, nil
+}
+
+func (s *ConfigSource) String() string {
+	return fmt.Sprintf("zookeeper %s%s", strings.Join(s.addrs, ","), s.path)
+}
+
+func (s *ConfigSource) Load() (*config.Config, error) {
+	local, err := config.NewConfigFromFile(s.local)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	conf, err := s.load(local)
+	if err != nil {
+		log.Warnf("load config from %s error: %v, use local file %s", s, err, s.local)
+		return local, nil
+	}
+	return conf, nil
+}
+
+// 节点版本变化时重新读取, 本地配置文件只在节点变化时读取
+func (s *ConfigSource) Changed() (*config.Config, error) {
+	data, version, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	if version == s.version {
+		return nil, nil
+	}
+	local, err := config.NewConfigFromFile(s.local)
+	if err != nil {
+		return nil, errors.NewNotValid(err, s.local)
+	}
+	return s.overlay(data, version, local)
+}
+
+func (s *ConfigSource) load(local *config.Config) (*config.Config, error) {
+	data, version, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	return s.overlay(data, version, local)
+}
+
+func (s *ConfigSource) overlay(data []byte, version int32, local *config.Config) (*config.Config, error) {
+	conf, err := config.Overlay(data, local, "proxy")
+	if err != nil {
+		return nil, errors.NewNotValid(err, s.path)
+	}
+	s.version = version
+	return conf, nil
+}
+
+// zookeeper不可用时请求会一直重试, 超过configTimeout后放弃
+func (s *ConfigSource) get() ([]byte, int32, error) {
+	conn, err := s.connect()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	type result struct {
+		data    []byte
+		version int32
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		data, stat, err := conn.Get(s.path)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		done <- result{data: data, version: stat.Version}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, 0, errors.Annotatef(r.err, "get %s", s.path)
+		}
+		return r.data, r.version, nil
+	case <-time.After(configTimeout):
+		return nil, 0, errors.Timeoutf("get %s", s.path)
+	}
+}
+
+func (s *ConfigSource) connect() (*Conn, error) {
+	if s.conn != nil {
+		return s.conn, nil
+	}
+	var conn *Conn
+	var err error
+	if s.connector == nil {
+		conn, err = NewConnect(s.addrs)
+	} else {
+		var local *config.Config
+		if local, err = config.NewConfigFromFile(s.local); err != nil {
+			return nil, errors.NewNotValid(err, s.local)
+		}
+		conn, err = s.connector(s.addrs, local)
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s.conn = conn
+	return conn, nil
+}
+
+func (s *ConfigSource) Close() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
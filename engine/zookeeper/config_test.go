@@ -0,0 +1,124 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zookeeper
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/weibocom/wqs/config"
+)
+
+const testCentralConfig = `proxy.id=1
+ui.dir=./central
+protocol.http.port=8080
+protocol.mc.port=11211
+protocol.motan.port=8881
+metadata.zookeeper.connect=localhost:2181
+metadata.zookeeper.root=/
+log.info=info.log
+log.debug=debug.log
+log.profile=profile.log
+`
+
+func TestNewConfigSource(t *testing.T) {
+	s, err := NewConfigSource("host1:2181,host2:2181/wqs/config", "config.properties", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.addrs) != 2 || s.path != "/wqs/config" {
+		t.Errorf("unexpected source %v %s", s.addrs, s.path)
+	}
+	for _, uri := range []string{"host1:2181", "/wqs/config", "host1:2181/"} {
+		if _, err = NewConfigSource(uri, "config.properties", nil); err == nil {
+			t.Errorf("expect invalid uri %q", uri)
+		}
+	}
+}
+
+// connector使用本地配置文件连接, 连接失败时使用本地配置
+func TestConfigSourceConnector(t *testing.T) {
+	f, err := ioutil.TempFile("", "wqs-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(testCentralConfig + "metadata.zookeeper.auth=wqs:secret\n")
+	f.Close()
+
+	var auth string
+	s, err := NewConfigSource("host1:2181/wqs/config", f.Name(), func(addrs []string, local *config.Config) (*Conn, error) {
+		section, _ := local.GetSection("metadata")
+		auth = section.GetStringMust("zookeeper.auth", "")
+		return nil, errors.New("refused")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := s.Load()
+	if err != nil || conf.UiDir != "./central" {
+		t.Fatalf("expect local config, got %v %v", conf, err)
+	}
+	if auth != "wqs:secret" {
+		t.Errorf("connector got auth %q", auth)
+	}
+}
+
+func TestConfigSource(t *testing.T) {
+	conn, err := testNewConnection()
+	if err != nil {
+		t.Fatalf("NewConnect error %v", err)
+	}
+	defer conn.Close()
+	defer conn.DeleteRecursive("/fortest")
+
+	if err = conn.CreateRecursive("/fortest/config", testCentralConfig, 0); err != nil {
+		t.Fatalf("create config node error %v", err)
+	}
+
+	f, err := ioutil.TempFile("", "wqs-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(testCentralConfig + "proxy.id=2\nui.dir=./local\n")
+	f.Close()
+
+	s := &ConfigSource{addrs: testAddrs(), path: "/fortest/config", local: f.Name(), version: -1}
+	defer s.Close()
+	conf, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// proxy段使用本地配置, 其他使用zookeeper中的配置
+	if conf.ProxyId != 2 || conf.UiDir != "./central" {
+		t.Errorf("unexpected config proxy.id %d ui.dir %s", conf.ProxyId, conf.UiDir)
+	}
+	if conf, err = s.Changed(); conf != nil || err != nil {
+		t.Errorf("expect no change, got %v %v", conf, err)
+	}
+
+	conn.Set("/fortest/config", testCentralConfig+"alert.enable=true\n")
+	if conf, err = s.Changed(); err != nil || conf == nil {
+		t.Fatalf("expect config changed, got %v", err)
+	}
+	if section, _ := conf.GetSection("alert"); !section.GetBoolMust("enable", false) {
+		t.Error("expect alert enabled")
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zookeeper
+
+import (
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/weibocom/wqs/log"
+)
+
+// 没有收到watch事件时重新检查leader节点的间隔
+const electionRetry = 5 * time.Second
+
+// 基于临时节点的选主: 创建节点成功的实例成为leader, 节点数据为其id.
+// leader会话失效或主动退出后节点被删除, 其他实例收到watch事件后重新竞选
+type Election struct {
+	conn   *Conn
+	path   string
+	id     string
+	leader int32
+	dying  chan struct{}
+	done   chan struct{}
+}
+
+func (c *Conn) NewElection(path string, id string) *Election {
+	return &Election{
+		conn:  c,
+		path:  path,
+		id:    id,
+		dying: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// 在后台持续参与竞选, 直到Close
+func (e *Election) Start() {
+	go e.run()
+}
+
+func (e *Election) run() {
+	defer close(e.done)
+	for {
+		watch, err := e.campaign()
+		if err != nil {
+			log.Warnf("election %s campaign error: %v", e.path, err)
+		}
+		select {
+		case <-watch:
+		case <-time.After(electionRetry):
+		case <-e.dying:
+			return
+		}
+	}
+}
+
+// 尝试创建leader节点, 并watch该节点的变化. 出错时返回的channel为nil
+func (e *Election) campaign() (<-chan zk.Event, error) {
+	if err := e.conn.CreateRecursiveIgnoreExist(path.Dir(e.path), "", 0); err != nil {
+		e.setLeader(false)
+		return nil, err
+	}
+	if err := e.conn.Create(e.path, e.id, Ephemeral); err != nil && err != zk.ErrNodeExists {
+		e.setLeader(false)
+		return nil, err
+	}
+	data, _, watch, err := e.conn.GetW(e.path)
+	if err != nil {
+		e.setLeader(false)
+		return nil, err
+	}
+	e.setLeader(string(data) == e.id)
+	return watch, nil
+}
+
+func (e *Election) setLeader(leader bool) {
+	var v int32
+	if leader {
+		v = 1
+	}
+	if old := atomic.SwapInt32(&e.leader, v); old != v {
+		if leader {
+			log.Infof("election %s: %s becomes leader", e.path, e.id)
+		} else {
+			log.Infof("election %s: %s is no longer leader", e.path, e.id)
+		}
+	}
+}
+
+// 与zookeeper断开时不再认为自己是leader, 避免会话失效前后两个实例同时执行任务
+func (e *Election) IsLeader() bool {
+	return atomic.LoadInt32(&e.leader) == 1 && e.conn.Connected()
+}
+
+// 返回当前leader的id, 没有leader时返回空字符串
+func (e *Election) Leader() (string, error) {
+	data, _, err := e.conn.Get(e.path)
+	if err == zk.ErrNoNode {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// 退出竞选, 是leader时删除节点让其他实例尽快接管
+func (e *Election) Close() {
+	close(e.dying)
+	<-e.done
+	if atomic.LoadInt32(&e.leader) == 1 {
+		if err := e.conn.Delete(e.path); err != nil && err != zk.ErrNoNode {
+			log.Warnf("election %s resign error: %v", e.path, err)
+		}
+		e.setLeader(false)
+	}
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zookeeper
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/juju/errors"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	// zookeeper的SASL请求类型
+	opSASL = 102
+	// connect响应和SASL响应的最大长度
+	maxSASLPacket = 1 << 20
+	// GSSAPI token的类型, 见RFC 4121
+	tokenIDKrbAPReq  = 0x0100
+	gssAPIGenericTag = 0x60
+)
+
+// 一次SASL认证过程, next根据服务端的token生成下一个token, done为true时该token是最后一个
+type saslSession interface {
+	next(challenge []byte) (token []byte, done bool, err error)
+}
+
+// 建立连接后, 在客户端读取connect响应之前完成SASL认证.
+// 客户端(samuel/go-zookeeper)先写connect请求再读响应, 之后才会发送其他请求,
+// 所以第一次Read时读出完整的connect响应, 认证成功后再交给客户端
+type saslConn struct {
+	net.Conn
+	host       string
+	newSession func(host string) (saslSession, error)
+	started    bool
+	response   []byte
+	err        error
+}
+
+func saslDialer(newSession func(host string) (saslSession, error)) zk.Dialer {
+	return func(network, address string, timeout time.Duration) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := net.DialTimeout(network, address, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return &saslConn{Conn: conn, host: host, newSession: newSession}, nil
+	}
+}
+
+func (c *saslConn) Read(b []byte) (int, error) {
+	if !c.started {
+		c.started = true
+		c.err = c.authenticate()
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	if len(c.response) > 0 {
+		n := copy(b, c.response)
+		c.response = c.response[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *saslConn) authenticate() error {
+	frame, err := readPacket(c.Conn)
+	if err != nil {
+		return err
+	}
+	c.response = frame
+	// connect响应: protocolVersion(int32) timeOut(int32) sessionID(int64) passwd(buffer)
+	if len(frame) < 20 {
+		return errors.Errorf("zookeeper connect response too short: %d", len(frame))
+	}
+	// 会话已经过期, 客户端会重新建立会话, 不需要认证
+	if binary.BigEndian.Uint64(frame[12:20]) == 0 {
+		return nil
+	}
+	session, err := c.newSession(c.host)
+	if err != nil {
+		return errors.Annotate(err, "zookeeper sasl")
+	}
+	return errors.Annotate(saslExchange(c.Conn, session), "zookeeper sasl")
+}
+
+// SASL请求: xid(int32) type(int32) token(buffer);
+// 响应: xid(int32) zxid(int64) err(int32) token(buffer), err不为0时认证失败
+func saslExchange(conn net.Conn, session saslSession) error {
+	token, done, err := session.next(nil)
+	if err != nil {
+		return err
+	}
+	for xid := int32(1); ; xid++ {
+		request := make([]byte, 16+len(token))
+		binary.BigEndian.PutUint32(request[0:], uint32(12+len(token)))
+		binary.BigEndian.PutUint32(request[4:], uint32(xid))
+		binary.BigEndian.PutUint32(request[8:], opSASL)
+		binary.BigEndian.PutUint32(request[12:], uint32(len(token)))
+		copy(request[16:], token)
+		if _, err = conn.Write(request); err != nil {
+			return err
+		}
+
+		reply, err := readPacket(conn)
+		if err != nil {
+			return err
+		}
+		if len(reply) < 20 {
+			return errors.Errorf("sasl response too short: %d", len(reply))
+		}
+		if code := int32(binary.BigEndian.Uint32(reply[16:20])); code != 0 {
+			return errors.Errorf("authentication failed, code: %d", code)
+		}
+		if done {
+			return nil
+		}
+		var challenge []byte
+		if len(reply) >= 24 {
+			size := int32(binary.BigEndian.Uint32(reply[20:24]))
+			if size > 0 && int(size) <= len(reply)-24 {
+				challenge = reply[24 : 24+size]
+			}
+		}
+		if token, done, err = session.next(challenge); err != nil {
+			return err
+		}
+	}
+}
+
+// 返回包括4字节长度在内的整个包
+func readPacket(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > maxSASLPacket {
+		return nil, errors.Errorf("zookeeper packet too large: %d", size)
+	}
+	frame := make([]byte, 4+size)
+	copy(frame, header)
+	if _, err := io.ReadFull(r, frame[4:]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// GSSAPI认证: 发送AP-REQ, 验证服务端的wrap token后原样返回其中的安全层协商结果(不使用安全层).
+// 与sarama的GSSAPI认证相同, 不同的是zookeeper使用自己的SASL请求而不是kafka的SaslAuthenticate
+type gssapiSession struct {
+	key     types.EncryptionKey
+	apReq   []byte
+	started bool
+}
+
+// 每次建立连接时重新登录并获取<service.name>/<host>的票据. host为服务端地址反向解析得到的主机名,
+// 与java客户端一致, 解析失败时使用IP
+func newGSSAPISession(conf *sarama.GSSAPIConfig) func(host string) (saslSession, error) {
+	return func(host string) (saslSession, error) {
+		client, err := sarama.NewKerberosClient(conf)
+		if err != nil {
+			return nil, err
+		}
+		defer client.Destroy()
+		if err = client.Login(); err != nil {
+			return nil, err
+		}
+		spn := fmt.Sprintf("%s/%s", conf.ServiceName, canonicalHost(host))
+		ticket, key, err := client.GetServiceTicket(spn)
+		if err != nil {
+			return nil, errors.Annotatef(err, "service ticket of %s", spn)
+		}
+		apReq, err := newAPReq(client.Domain(), client.CName(), ticket, key)
+		if err != nil {
+			return nil, err
+		}
+		return &gssapiSession{key: key, apReq: apReq}, nil
+	}
+}
+
+func canonicalHost(host string) string {
+	names, err := net.LookupAddr(host)
+	if err != nil || len(names) == 0 {
+		return host
+	}
+	return strings.ToLower(strings.TrimSuffix(names[0], "."))
+}
+
+func (s *gssapiSession) next(challenge []byte) ([]byte, bool, error) {
+	if !s.started {
+		s.started = true
+		return s.apReq, false, nil
+	}
+	var wrap gssapi.WrapToken
+	if err := wrap.Unmarshal(challenge, true); err != nil {
+		return nil, false, err
+	}
+	if ok, err := wrap.Verify(s.key, keyusage.GSSAPI_ACCEPTOR_SEAL); !ok {
+		return nil, false, err
+	}
+	reply, err := gssapi.NewInitiatorWrapToken(wrap.Payload, s.key)
+	if err != nil {
+		return nil, false, err
+	}
+	token, err := reply.Marshal()
+	return token, true, err
+}
+
+// 带GSS-API头(RFC 2743 3.1)的AP-REQ, 校验和中请求完整性和机密性保护
+func newAPReq(domain string, cname types.PrincipalName, ticket messages.Ticket, key types.EncryptionKey) ([]byte, error) {
+	auth, err := types.NewAuthenticator(domain, cname)
+	if err != nil {
+		return nil, err
+	}
+	checksum := make([]byte, 24)
+	binary.LittleEndian.PutUint32(checksum[:4], 16)
+	binary.LittleEndian.PutUint32(checksum[20:], uint32(gssapi.ContextFlagInteg|gssapi.ContextFlagConf))
+	auth.Cksum = types.Checksum{CksumType: chksumtype.GSSAPI, Checksum: checksum}
+	req, err := messages.NewAPReq(ticket, key, auth)
+	if err != nil {
+		return nil, err
+	}
+	reqBytes, err := req.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	oid, err := asn1.Marshal(gssapi.OIDKRB5.OID())
+	if err != nil {
+		return nil, err
+	}
+	token := append([]byte{gssAPIGenericTag}, asn1tools.MarshalLengthBytes(len(oid)+2+len(reqBytes))...)
+	token = append(token, oid...)
+	token = append(token, byte(tokenIDKrbAPReq>>8), byte(tokenIDKrbAPReq&0xff))
+	return append(token, reqBytes...), nil
+}
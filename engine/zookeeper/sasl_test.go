@@ -0,0 +1,162 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zookeeper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// 依次返回tokens中的token, 并记录收到的challenge
+type testSASLSession struct {
+	tokens     []string
+	challenges []string
+}
+
+func (s *testSASLSession) next(challenge []byte) ([]byte, bool, error) {
+	if challenge != nil {
+		s.challenges = append(s.challenges, string(challenge))
+	}
+	token := s.tokens[0]
+	s.tokens = s.tokens[1:]
+	return []byte(token), len(s.tokens) == 0, nil
+}
+
+func testPacket(fields ...[]byte) []byte {
+	body := bytes.Join(fields, nil)
+	frame := make([]byte, 4, 4+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+	return append(frame, body...)
+}
+
+func testInt32(v int32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(v))
+	return b
+}
+
+func testInt64(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func testConnectResponse(sessionID int64) []byte {
+	return testPacket(testInt32(0), testInt32(4000), testInt64(sessionID), testInt32(2), []byte("pw"))
+}
+
+// 模拟服务端: 发送connect响应后依次读取SASL请求, 用replies中的token应答, code不为0时认证失败
+func testSASLServer(t *testing.T, conn net.Conn, sessionID int64, code int32, replies ...string) <-chan []string {
+	received := make(chan []string, 1)
+	go func() {
+		var tokens []string
+		defer func() { received <- tokens }()
+		if _, err := conn.Write(testConnectResponse(sessionID)); err != nil {
+			return
+		}
+		for _, reply := range replies {
+			request, err := readPacket(conn)
+			if err != nil {
+				return
+			}
+			if op := int32(binary.BigEndian.Uint32(request[8:12])); op != opSASL {
+				t.Errorf("op = %d, want %d", op, opSASL)
+			}
+			tokens = append(tokens, string(request[16:]))
+			response := testPacket(request[4:8], testInt64(0), testInt32(code), testInt32(int32(len(reply))), []byte(reply))
+			if _, err = conn.Write(response); err != nil {
+				return
+			}
+		}
+	}()
+	return received
+}
+
+func TestSASLConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	session := &testSASLSession{tokens: []string{"token1", "token2"}}
+	conn := &saslConn{Conn: client, host: "zk1", newSession: func(host string) (saslSession, error) {
+		if host != "zk1" {
+			t.Errorf("host = %q", host)
+		}
+		return session, nil
+	}}
+	received := testSASLServer(t, server, 1, 0, "challenge1", "")
+
+	// 客户端先读4字节长度再读响应内容, 应该得到原始的connect响应
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := append(header, body...), testConnectResponse(1); !bytes.Equal(got, want) {
+		t.Errorf("connect response = %v, want %v", got, want)
+	}
+	if tokens := <-received; len(tokens) != 2 || tokens[0] != "token1" || tokens[1] != "token2" {
+		t.Errorf("tokens = %q", tokens)
+	}
+	if len(session.challenges) != 1 || session.challenges[0] != "challenge1" {
+		t.Errorf("challenges = %q", session.challenges)
+	}
+
+	// 认证之后的数据直接透传
+	go server.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("read after sasl: %q %v", buf, err)
+	}
+}
+
+func TestSASLConnAuthFailed(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	session := &testSASLSession{tokens: []string{"token1", "token2"}}
+	conn := &saslConn{Conn: client, newSession: func(string) (saslSession, error) { return session, nil }}
+	testSASLServer(t, server, 1, -115, "")
+
+	if _, err := conn.Read(make([]byte, 4)); err == nil {
+		t.Fatal("expect auth failed")
+	}
+	if _, err := conn.Read(make([]byte, 4)); err == nil {
+		t.Fatal("expect auth failed on later reads")
+	}
+}
+
+func TestSASLConnSessionExpired(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := &saslConn{Conn: client, newSession: func(string) (saslSession, error) {
+		t.Fatal("should not authenticate an expired session")
+		return nil, nil
+	}}
+	testSASLServer(t, server, 0, 0)
+
+	frame := make([]byte, len(testConnectResponse(0)))
+	if _, err := io.ReadFull(conn, frame); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(frame, testConnectResponse(0)) {
+		t.Errorf("connect response = %v", frame)
+	}
+}
@@ -20,8 +20,11 @@ import (
 	"fmt"
 	"path"
 	"regexp"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/juju/errors"
 	"github.com/samuel/go-zookeeper/zk"
 	"github.com/weibocom/wqs/log"
@@ -54,6 +57,12 @@ func (l *logger) Printf(format string, a ...interface{}) {
 
 type Conn struct {
 	*zk.Conn
+	// 创建节点时使用的ACL
+	acl []zk.ACL
+	// 创建私有节点时使用的ACL, 不包括所有客户端可读
+	private []zk.ACL
+	// 认证的用户, 拥有创建的节点的全部权限
+	owners []zk.ACL
 }
 
 func connInit(c *zk.Conn) {
@@ -67,12 +76,100 @@ func NewConnect(addrs []string) (*Conn, error) {
 		return nil, errors.Trace(err)
 	}
 
-	return &Conn{Conn: conn}, nil
+	return &Conn{Conn: conn, acl: zk.WorldACL(zk.PermAll), private: zk.WorldACL(zk.PermAll)}, nil
+}
+
+// 使用digest认证连接, auth的格式为user:password. 之后创建的节点只有该用户可以修改,
+// worldRead为true时其他客户端仍然可以读取. 断线重连后客户端会重新认证
+func NewDigestConnect(addrs []string, auth string, worldRead bool) (*Conn, error) {
+	if _, err := DigestACL(auth, worldRead); err != nil {
+		return nil, err
+	}
+	conn, err := NewConnect(addrs)
+	if err != nil {
+		return nil, err
+	}
+	if err = conn.DigestAuth(auth, worldRead); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// 在已有的连接上使用digest认证, 与NewDigestConnect相同, 用于同时使用kerberos和digest的场景
+func (c *Conn) DigestAuth(auth string, worldRead bool) error {
+	acl, err := DigestACL(auth, worldRead)
+	if err != nil {
+		return err
+	}
+	if err = c.AddAuth("digest", []byte(auth)); err != nil {
+		return errors.Annotate(err, "zookeeper digest auth")
+	}
+	c.addOwner(acl[0], worldRead)
+	return nil
+}
+
+// 之后创建的节点只有kerberos认证的principal(user/host@REALM)可以修改, worldRead为true时其他客户端仍然可以读取.
+// zookeeper服务端配置了去掉principal中的host或realm时, 需要传入去掉后的名字
+func (c *Conn) SASLOwner(principal string, worldRead bool) {
+	c.addOwner(zk.ACL{Perms: zk.PermAll, Scheme: "sasl", ID: principal}, worldRead)
+}
+
+func (c *Conn) addOwner(owner zk.ACL, worldRead bool) {
+	c.owners = append(c.owners, owner)
+	c.private = append([]zk.ACL(nil), c.owners...)
+	c.acl = append([]zk.ACL(nil), c.owners...)
+	if worldRead {
+		c.acl = append(c.acl, zk.WorldACL(zk.PermRead)...)
+	}
+}
+
+// 使用kerberos(SASL/GSSAPI)认证连接, conf为nil时不认证. 每次建立连接时都会重新登录kerberos,
+// 服务端的principal为<conf.ServiceName>/<host>. 启动时先登录一次, keytab或KDC有问题时直接返回错误.
+// 创建的节点仍然所有客户端可写, 需要限制时调用SASLOwner
+func NewKerberosConnect(addrs []string, conf *sarama.GSSAPIConfig) (*Conn, error) {
+	if conf == nil {
+		return NewConnect(addrs)
+	}
+	client, err := sarama.NewKerberosClient(conf)
+	if err != nil {
+		return nil, errors.Annotate(err, "zookeeper kerberos")
+	}
+	err = client.Login()
+	client.Destroy()
+	if err != nil {
+		return nil, errors.Annotate(err, "zookeeper kerberos login")
+	}
+
+	conn, _, err := zk.Connect(addrs, sessionTimeout, connInit, zk.WithDialer(saslDialer(newGSSAPISession(conf))))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &Conn{Conn: conn, acl: zk.WorldACL(zk.PermAll), private: zk.WorldACL(zk.PermAll)}, nil
+}
+
+// auth用户拥有全部权限, worldRead为true时所有客户端可读
+func DigestACL(auth string, worldRead bool) ([]zk.ACL, error) {
+	i := strings.Index(auth, ":")
+	if i <= 0 {
+		return nil, errors.NotValidf("zookeeper digest auth, expect user:password")
+	}
+	acl := zk.DigestACL(zk.PermAll, auth[:i], auth[i+1:])
+	if worldRead {
+		acl = append(acl, zk.WorldACL(zk.PermRead)...)
+	}
+	return acl, nil
 }
 
 //Create a node by path with data.
 func (c *Conn) Create(path string, data string, flags int32) error {
-	_, err := c.Conn.Create(path, []byte(data), flags, zk.WorldACL(zk.PermAll))
+	_, err := c.Conn.Create(path, []byte(data), flags, c.acl)
+	return err
+}
+
+// 创建只有认证用户可以读写的节点, 用于保存token、配置等敏感数据. 没有认证时与Create相同
+func (c *Conn) CreatePrivate(path string, data string, flags int32) error {
+	_, err := c.Conn.Create(path, []byte(data), flags, c.private)
 	return err
 }
 
@@ -140,6 +237,12 @@ func (c *Conn) Set(path string, data string) error {
 	return err
 }
 
+// 节点的版本与version相同时才修改, 否则返回zk.ErrBadVersion
+func (c *Conn) CompareAndSet(path string, data string, version int32) error {
+	_, err := c.Conn.Set(path, []byte(data), version)
+	return err
+}
+
 // test given path whether has sub-node
 func (c *Conn) HasChildren(path string) (bool, error) {
 	children, _, err := c.Conn.Children(path)
@@ -152,8 +255,88 @@ func (c *Conn) HasChildren(path string) (bool, error) {
 	return true, nil
 }
 
+type Node struct {
+	Data []byte
+	Stat *zk.Stat
+	Err  error
+}
+
+// 并发读取多个节点的数据, 返回的结果与paths一一对应, concurrency为同时发出的请求数
+func (c *Conn) GetMulti(paths []string, concurrency int) []Node {
+	nodes := make([]Node, len(paths))
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > len(paths) {
+		concurrency = len(paths)
+	}
+
+	indexes := make(chan int, len(paths))
+	for i := range paths {
+		indexes <- i
+	}
+	close(indexes)
+
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				nodes[i].Data, nodes[i].Stat, nodes[i].Err = c.Get(paths[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return nodes
+}
+
+// whether the connection has a valid session
+func (c *Conn) Connected() bool {
+	return c.State() == zk.StateHasSession
+}
+
+const (
+	TxnCreate = iota
+	TxnSet
+	TxnDelete
+)
+
+// 事务中的一个操作, TxnSet和TxnDelete按Version检查节点的版本, -1表示不检查
+type TxnOp struct {
+	Type    int
+	Path    string
+	Data    string
+	Version int32
+}
+
+// 在一个事务中执行多个操作, 全部成功或全部不执行, 失败时返回第一个失败的操作的错误
+func (c *Conn) Txn(ops []TxnOp) error {
+	requests := make([]interface{}, 0, len(ops))
+	for _, op := range ops {
+		switch op.Type {
+		case TxnCreate:
+			requests = append(requests, &zk.CreateRequest{Path: op.Path, Data: []byte(op.Data), Acl: c.acl})
+		case TxnSet:
+			requests = append(requests, &zk.SetDataRequest{Path: op.Path, Data: []byte(op.Data), Version: op.Version})
+		case TxnDelete:
+			requests = append(requests, &zk.DeleteRequest{Path: op.Path, Version: op.Version})
+		default:
+			return errors.NotValidf("txn op type %d", op.Type)
+		}
+	}
+
+	responses, err := c.Multi(requests...)
+	for _, response := range responses {
+		if response.Error != nil {
+			return response.Error
+		}
+	}
+	return err
+}
+
 func (c *Conn) NewMutex(path string) *Mutex {
-	return &Mutex{zk.NewLock(c.Conn, path, zk.WorldACL(zk.PermAll))}
+	return &Mutex{zk.NewLock(c.Conn, path, c.acl)}
 }
 
 type Mutex struct {
@@ -175,3 +358,7 @@ func IsExistError(err error) bool {
 func IsNoNode(err error) bool {
 	return err == zk.ErrNoNode
 }
+
+func IsBadVersion(err error) bool {
+	return err == zk.ErrBadVersion
+}
@@ -21,6 +21,10 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/samuel/go-zookeeper/zk"
 )
 
 const (
@@ -29,15 +33,20 @@ const (
 	testCreatePath          = "/fortest"
 	testCreateOrUpdatePath  = "/fortest/1/2"
 	testCreateRecursivePath = "/fortest/1/2/3"
+	testElectionPath        = "/fortest/election/leader"
 )
 
-func testNewConnection() (*Conn, error) {
+func testAddrs() []string {
 	addrs := os.Getenv("ZOOKEEPER_ADDR")
 	if len(addrs) == 0 {
 		addrs = "localhost:2181"
 	}
 	fmt.Printf("addr %s", addrs)
-	return NewConnect(strings.Split(addrs, ","))
+	return strings.Split(addrs, ",")
+}
+
+func testNewConnection() (*Conn, error) {
+	return NewConnect(testAddrs())
 }
 
 func TestConnGetSetDelete(t *testing.T) {
@@ -135,3 +144,82 @@ func TestRecursive(t *testing.T) {
 		t.Fatalf("Delete %s error %v", testCreatePath, err)
 	}
 }
+
+func TestElection(t *testing.T) {
+	conn, err := testNewConnection()
+	if err != nil {
+		t.Fatalf("NewConnect error %v", err)
+	}
+	defer conn.Close()
+	defer conn.DeleteRecursive("/fortest")
+
+	first := conn.NewElection(testElectionPath, "1")
+	first.Start()
+	waitLeader(t, first, true)
+
+	second := conn.NewElection(testElectionPath, "2")
+	second.Start()
+	defer second.Close()
+	time.Sleep(100 * time.Millisecond)
+	if second.IsLeader() {
+		t.Fatal("only one instance can be leader")
+	}
+
+	// leader退出后其他实例接管
+	first.Close()
+	if first.IsLeader() {
+		t.Error("closed election should not be leader")
+	}
+	waitLeader(t, second, true)
+}
+
+func waitLeader(t *testing.T, e *Election, leader bool) {
+	for i := 0; i < 50; i++ {
+		if e.IsLeader() == leader {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("election %s expect leader %v", e.id, leader)
+}
+
+func TestDigestACL(t *testing.T) {
+	acl, err := DigestACL("wqs:pass:word", true)
+	if err != nil || len(acl) != 2 {
+		t.Fatalf("acl %v err %v", acl, err)
+	}
+	if want := zk.DigestACL(zk.PermAll, "wqs", "pass:word")[0]; acl[0] != want {
+		t.Errorf("digest acl %v, want %v", acl[0], want)
+	}
+	if acl[1] != zk.WorldACL(zk.PermRead)[0] {
+		t.Errorf("world acl %v", acl[1])
+	}
+
+	if acl, err = DigestACL("wqs:secret", false); err != nil || len(acl) != 1 || acl[0].Scheme != "digest" {
+		t.Errorf("acl without world read %v err %v", acl, err)
+	}
+	for _, auth := range []string{"", "wqs", ":secret"} {
+		if _, err = DigestACL(auth, true); !errors.IsNotValid(err) {
+			t.Errorf("auth %q err %v, want not valid", auth, err)
+		}
+	}
+}
+
+func TestSASLOwner(t *testing.T) {
+	c := &Conn{acl: zk.WorldACL(zk.PermAll), private: zk.WorldACL(zk.PermAll)}
+	c.SASLOwner("wqs/host1@EXAMPLE.COM", true)
+	owner := zk.ACL{Perms: zk.PermAll, Scheme: "sasl", ID: "wqs/host1@EXAMPLE.COM"}
+	if len(c.acl) != 2 || c.acl[0] != owner || c.acl[1] != zk.WorldACL(zk.PermRead)[0] {
+		t.Errorf("acl %v", c.acl)
+	}
+	if len(c.private) != 1 || c.private[0] != owner {
+		t.Errorf("private acl %v", c.private)
+	}
+
+	// 同时使用digest认证时两个用户都拥有全部权限
+	digest := zk.DigestACL(zk.PermAll, "wqs", "secret")[0]
+	c.addOwner(digest, false)
+	if len(c.acl) != 2 || c.acl[0] != owner || c.acl[1] != digest || len(c.private) != 2 {
+		t.Errorf("acl %v private %v", c.acl, c.private)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package log
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	RollingNoneS = "none"
+	RollingHourS = "hour"
+	RollingDayS  = "day"
+)
+
+var accessRWLock sync.RWMutex
+var accessLogger *Logger
+
+// access log每行是一个完整的JSON对象, 不添加时间和级别前缀
+func RestAccessLogger(logger *Logger) {
+	accessRWLock.Lock()
+	logger.SetFlags(0)
+	logger.SetLogLevel(LogInfo)
+	accessLogger = logger
+	accessRWLock.Unlock()
+}
+
+func AccessGetLogger() *Logger {
+	accessRWLock.RLock()
+	defer accessRWLock.RUnlock()
+	return accessLogger
+}
+
+// 未设置单独的access logger时写入info日志
+func Access(line string) {
+	accessRWLock.RLock()
+	defer accessRWLock.RUnlock()
+	if accessLogger == nil {
+		Info(line)
+		return
+	}
+	accessLogger.Output(2, LogInfo, line)
+}
+
+func ParseRolling(s string) (RollingType, error) {
+	switch s {
+	case RollingNoneS:
+		return RollingDeny, nil
+	case RollingHourS:
+		return RollingByHour, nil
+	case RollingDayS:
+		return RollingByDay, nil
+	}
+	return RollingDeny, fmt.Errorf("unknown rolling type %q", s)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package log
+
+import (
+	"fmt"
+	stdlog "log"
+	"sync/atomic"
+)
+
+// 嵌入engine/queue等模块的程序可以通过SetBackend使用自己的日志库,
+// zap的SugaredLogger, logrus.Logger等都实现了该接口
+type Backend interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+type backendBox struct {
+	backend Backend
+}
+
+var (
+	backend atomic.Value
+	// 全局日志级别, 高于该级别的日志直接丢弃, 对内置的Logger和Backend都生效
+	level uint32 = LogDebug
+)
+
+func init() {
+	backend.Store(backendBox{})
+}
+
+// 设置为nil时恢复使用内置的Logger
+func SetBackend(b Backend) {
+	backend.Store(backendBox{backend: b})
+}
+
+func getBackend() Backend {
+	return backend.Load().(backendBox).backend
+}
+
+// 运行时修改全局日志级别, 不需要重启
+func SetLevel(l uint32) {
+	if l >= logLevelMax {
+		l = LogDebug
+	}
+	atomic.StoreUint32(&level, l)
+}
+
+func GetLevel() uint32 {
+	return atomic.LoadUint32(&level)
+}
+
+// 设置了模块级别时根据调用方所在的模块判断, 只在排查问题时有额外开销
+func enabled(l uint32) bool {
+	if atomic.LoadInt32(&moduleEnabled) == 0 {
+		return l <= atomic.LoadUint32(&level)
+	}
+	// callerLevel <- enabled <- Debugf等 <- 调用方
+	return l <= callerLevel(3)
+}
+
+func ParseLevel(s string) (uint32, error) {
+	switch s {
+	case LogFatalS:
+		return LogFatal, nil
+	case LogErrorS:
+		return LogError, nil
+	case LogWarningS:
+		return LogWarning, nil
+	case LogInfoS:
+		return LogInfo, nil
+	case LogDebugS:
+		return LogDebug, nil
+	}
+	return LogDebug, fmt.Errorf("unknown log level %q", s)
+}
+
+type stdBackend struct {
+	logger *stdlog.Logger
+}
+
+// 使用标准库的log.Logger作为Backend, 每行以级别开头
+func NewStdBackend(logger *stdlog.Logger) Backend {
+	return &stdBackend{logger: logger}
+}
+
+func (b *stdBackend) output(l uint32, format string, args ...interface{}) {
+	b.logger.Output(4, logLevel2String(l)+" "+fmt.Sprintf(format, args...))
+}
+
+func (b *stdBackend) Debugf(format string, args ...interface{}) {
+	b.output(LogDebug, format, args...)
+}
+
+func (b *stdBackend) Infof(format string, args ...interface{}) {
+	b.output(LogInfo, format, args...)
+}
+
+func (b *stdBackend) Warnf(format string, args ...interface{}) {
+	b.output(LogWarning, format, args...)
+}
+
+func (b *stdBackend) Errorf(format string, args ...interface{}) {
+	b.output(LogError, format, args...)
+}
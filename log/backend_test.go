@@ -0,0 +1,93 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+	stdlog "log"
+	"strings"
+	"testing"
+)
+
+type recordBackend struct {
+	lines []string
+}
+
+func (b *recordBackend) record(level string, format string, args ...interface{}) {
+	b.lines = append(b.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (b *recordBackend) Debugf(format string, args ...interface{}) {
+	b.record("debug", format, args...)
+}
+
+func (b *recordBackend) Infof(format string, args ...interface{}) {
+	b.record("info", format, args...)
+}
+
+func (b *recordBackend) Warnf(format string, args ...interface{}) {
+	b.record("warn", format, args...)
+}
+
+func (b *recordBackend) Errorf(format string, args ...interface{}) {
+	b.record("error", format, args...)
+}
+
+func TestBackend(t *testing.T) {
+	b := &recordBackend{}
+	SetBackend(b)
+	defer SetBackend(nil)
+	defer SetLevel(LogDebug)
+
+	Debugf("d %d", 1)
+	Info("i ", 2)
+	Warnf("w %d", 3)
+	Error("e")
+	SetLevel(LogWarning)
+	Infof("filtered")
+	Debug("filtered")
+	Errorf("e %d", 4)
+
+	want := []string{"debug d 1", "info i 2", "warn w 3", "error e", "error e 4"}
+	if strings.Join(b.lines, "|") != strings.Join(want, "|") {
+		t.Errorf("backend lines error: want %q, get %q", want, b.lines)
+	}
+}
+
+func TestStdBackend(t *testing.T) {
+	buf := &bytes.Buffer{}
+	SetBackend(NewStdBackend(stdlog.New(buf, "", 0)))
+	defer SetBackend(nil)
+
+	Warnf("disk %d%%", 90)
+	if got := buf.String(); got != "[WARNING] disk 90%\n" {
+		t.Errorf("std backend output error: %q", got)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, s := range []string{LogFatalS, LogErrorS, LogWarningS, LogInfoS, LogDebugS} {
+		level, err := ParseLevel(s)
+		if err != nil || LogLevel2String(level) != s {
+			t.Errorf("parse level %q error: %d %v", s, level, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("expect error for unknown level")
+	}
+}
@@ -19,7 +19,7 @@ package log
 import (
 	"io/ioutil"
 	"os"
-	"path"
+	"path/filepath"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -52,7 +52,7 @@ func NewCleaner(expire string, files ...string) *Cleaner {
 	}
 
 	for _, file := range files {
-		dir := path.Dir(file)
+		dir := filepath.Dir(file)
 		cleaner.dirs[dir] = none{}
 	}
 	return cleaner
@@ -103,7 +103,7 @@ func (c *Cleaner) check(now time.Time) {
 			if strings.Contains(file.Name(), ".log.") &&
 				now.Sub(file.ModTime()) > c.expire && !file.IsDir() {
 				// remove expired files
-				os.Remove(path.Join(dir, file.Name()))
+				os.Remove(filepath.Join(dir, file.Name()))
 			}
 		}
 	}
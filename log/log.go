@@ -19,7 +19,7 @@ package log
 import (
 	"fmt"
 	"os"
-	"path"
+	"path/filepath"
 	"runtime"
 	"sync"
 	"sync/atomic"
@@ -105,7 +105,7 @@ func (l *Logger) Close() error {
 func (l *Logger) Open() (*Logger, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	err := os.MkdirAll(path.Dir(l.fileName), 0755)
+	err := os.MkdirAll(filepath.Dir(l.fileName), 0755)
 	if err != nil && !os.IsExist(err) {
 		return l, err
 	}
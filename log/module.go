@@ -0,0 +1,146 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package log
+
+import (
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	ModuleQueue   = "queue"
+	ModuleKafka   = "kafka"
+	ModuleService = "service"
+)
+
+// 按调用方所在的包区分模块, 子包属于同一个模块
+var modulePackages = map[string]string{
+	"github.com/weibocom/wqs/engine/queue": ModuleQueue,
+	"github.com/weibocom/wqs/engine/kafka": ModuleKafka,
+	"github.com/weibocom/wqs/service":      ModuleService,
+}
+
+var (
+	moduleMu sync.Mutex
+	// map[string]uint32, 写时复制. 为空时不需要获取调用方信息
+	moduleLevels  atomic.Value
+	moduleEnabled int32
+)
+
+func init() {
+	moduleLevels.Store(map[string]uint32{})
+}
+
+func Modules() []string {
+	modules := make([]string, 0, len(modulePackages))
+	for _, module := range modulePackages {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	return modules
+}
+
+func validModule(module string) bool {
+	for _, m := range modulePackages {
+		if m == module {
+			return true
+		}
+	}
+	return false
+}
+
+// 单独设置某个模块的日志级别, 优先于全局级别
+func SetModuleLevel(module string, l uint32) bool {
+	if !validModule(module) || l >= logLevelMax {
+		return false
+	}
+	updateModuleLevels(func(levels map[string]uint32) {
+		levels[module] = l
+	})
+	return true
+}
+
+// 恢复使用全局级别
+func ResetModuleLevel(module string) bool {
+	if !validModule(module) {
+		return false
+	}
+	updateModuleLevels(func(levels map[string]uint32) {
+		delete(levels, module)
+	})
+	return true
+}
+
+func updateModuleLevels(update func(map[string]uint32)) {
+	moduleMu.Lock()
+	defer moduleMu.Unlock()
+	old := moduleLevels.Load().(map[string]uint32)
+	levels := make(map[string]uint32, len(old)+1)
+	for k, v := range old {
+		levels[k] = v
+	}
+	update(levels)
+	moduleLevels.Store(levels)
+	if len(levels) > 0 {
+		atomic.StoreInt32(&moduleEnabled, 1)
+	} else {
+		atomic.StoreInt32(&moduleEnabled, 0)
+	}
+}
+
+// 返回模块当前生效的日志级别, ok表示是否单独设置过
+func GetModuleLevel(module string) (l uint32, ok bool) {
+	levels := moduleLevels.Load().(map[string]uint32)
+	if l, ok = levels[module]; ok {
+		return l, true
+	}
+	return GetLevel(), false
+}
+
+// skip为从callerLevel起到打日志的调用方的栈深度
+func callerLevel(skip int) uint32 {
+	pc := make([]uintptr, 1)
+	if runtime.Callers(skip+1, pc) == 0 {
+		return GetLevel()
+	}
+	frame, _ := runtime.CallersFrames(pc).Next()
+	l, _ := GetModuleLevel(moduleOf(frame.Function))
+	return l
+}
+
+// fn形如 github.com/weibocom/wqs/engine/queue.(*queueImp).SendMessage
+func moduleOf(fn string) string {
+	pkg := fn
+	slash := strings.LastIndex(fn, "/")
+	if dot := strings.Index(fn[slash+1:], "."); dot >= 0 {
+		pkg = fn[:slash+1+dot]
+	}
+	for pkg != "" {
+		if module, ok := modulePackages[pkg]; ok {
+			return module
+		}
+		i := strings.LastIndex(pkg, "/")
+		if i < 0 {
+			break
+		}
+		pkg = pkg[:i]
+	}
+	return ""
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package log
+
+import "testing"
+
+func TestModuleOf(t *testing.T) {
+	cases := map[string]string{
+		"github.com/weibocom/wqs/engine/queue.(*queueImp).SendMessage": ModuleQueue,
+		"github.com/weibocom/wqs/engine/kafka.NewConsumer":             ModuleKafka,
+		"github.com/weibocom/wqs/service/mc.commandGet":                ModuleService,
+		"github.com/weibocom/wqs/service.(*Server).Start.func1":        ModuleService,
+		"github.com/weibocom/wqs/engine/queuex.Foo":                    "",
+		"main.main": "",
+	}
+	for fn, want := range cases {
+		if got := moduleOf(fn); got != want {
+			t.Errorf("moduleOf(%q) want %q, get %q", fn, want, got)
+		}
+	}
+}
+
+func TestModuleLevel(t *testing.T) {
+	defer SetLevel(LogDebug)
+	SetLevel(LogInfo)
+
+	if SetModuleLevel("noexist", LogDebug) {
+		t.Errorf("expect unknown module fail")
+	}
+	if !SetModuleLevel(ModuleQueue, LogDebug) {
+		t.Fatalf("set module level failed")
+	}
+	if l, ok := GetModuleLevel(ModuleQueue); !ok || l != LogDebug {
+		t.Errorf("module level error: %d %v", l, ok)
+	}
+	// 测试代码不属于任何模块, 使用全局级别
+	if enabled(LogDebug) || !enabled(LogInfo) {
+		t.Errorf("caller outside modules should use global level")
+	}
+
+	ResetModuleLevel(ModuleQueue)
+	if l, ok := GetModuleLevel(ModuleQueue); ok || l != LogInfo {
+		t.Errorf("reset module level error: %d %v", l, ok)
+	}
+	if moduleEnabled != 0 {
+		t.Errorf("expect module levels disabled after reset")
+	}
+}
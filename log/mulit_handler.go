@@ -77,63 +77,127 @@ func GetLogger(level uint32) *Logger {
 }
 
 func Fatal(args ...interface{}) {
-	opsRWLock.RLock()
-	opsLogger[LogFatal].log(LogFatal, args...)
-	opsRWLock.RUnlock()
+	if b := getBackend(); b != nil {
+		b.Errorf("%s", fmt.Sprint(args...))
+	} else {
+		opsRWLock.RLock()
+		opsLogger[LogFatal].log(LogFatal, args...)
+		opsRWLock.RUnlock()
+	}
 	os.Exit(-1)
 }
 
 func Fatalf(format string, args ...interface{}) {
-	opsRWLock.RLock()
-	opsLogger[LogFatal].logformat(LogFatal, format, args...)
-	opsRWLock.RUnlock()
+	if b := getBackend(); b != nil {
+		b.Errorf(format, args...)
+	} else {
+		opsRWLock.RLock()
+		opsLogger[LogFatal].logformat(LogFatal, format, args...)
+		opsRWLock.RUnlock()
+	}
 	os.Exit(-1)
 }
 
 func Error(args ...interface{}) {
-	opsRWLock.RLock()
-	opsLogger[LogError].log(LogError, args...)
-	opsRWLock.RUnlock()
+	if !enabled(LogError) {
+		return
+	}
+	if b := getBackend(); b != nil {
+		b.Errorf("%s", fmt.Sprint(args...))
+	} else {
+		opsRWLock.RLock()
+		opsLogger[LogError].log(LogError, args...)
+		opsRWLock.RUnlock()
+	}
 }
 
 func Errorf(format string, args ...interface{}) {
-	opsRWLock.RLock()
-	opsLogger[LogError].logformat(LogError, format, args...)
-	opsRWLock.RUnlock()
+	if !enabled(LogError) {
+		return
+	}
+	if b := getBackend(); b != nil {
+		b.Errorf(format, args...)
+	} else {
+		opsRWLock.RLock()
+		opsLogger[LogError].logformat(LogError, format, args...)
+		opsRWLock.RUnlock()
+	}
 }
 
 func Debug(args ...interface{}) {
-	opsRWLock.RLock()
-	opsLogger[LogDebug].log(LogDebug, args...)
-	opsRWLock.RUnlock()
+	if !enabled(LogDebug) {
+		return
+	}
+	if b := getBackend(); b != nil {
+		b.Debugf("%s", fmt.Sprint(args...))
+	} else {
+		opsRWLock.RLock()
+		opsLogger[LogDebug].log(LogDebug, args...)
+		opsRWLock.RUnlock()
+	}
 }
 
 func Debugf(format string, args ...interface{}) {
-	opsRWLock.RLock()
-	opsLogger[LogDebug].logformat(LogDebug, format, args...)
-	opsRWLock.RUnlock()
+	if !enabled(LogDebug) {
+		return
+	}
+	if b := getBackend(); b != nil {
+		b.Debugf(format, args...)
+	} else {
+		opsRWLock.RLock()
+		opsLogger[LogDebug].logformat(LogDebug, format, args...)
+		opsRWLock.RUnlock()
+	}
 }
 
 func Warn(args ...interface{}) {
-	opsRWLock.RLock()
-	opsLogger[LogWarning].log(LogWarning, args...)
-	opsRWLock.RUnlock()
+	if !enabled(LogWarning) {
+		return
+	}
+	if b := getBackend(); b != nil {
+		b.Warnf("%s", fmt.Sprint(args...))
+	} else {
+		opsRWLock.RLock()
+		opsLogger[LogWarning].log(LogWarning, args...)
+		opsRWLock.RUnlock()
+	}
 }
 
 func Warnf(format string, args ...interface{}) {
-	opsRWLock.RLock()
-	opsLogger[LogWarning].logformat(LogWarning, format, args...)
-	opsRWLock.RUnlock()
+	if !enabled(LogWarning) {
+		return
+	}
+	if b := getBackend(); b != nil {
+		b.Warnf(format, args...)
+	} else {
+		opsRWLock.RLock()
+		opsLogger[LogWarning].logformat(LogWarning, format, args...)
+		opsRWLock.RUnlock()
+	}
 }
 
 func Info(args ...interface{}) {
-	opsRWLock.RLock()
-	opsLogger[LogInfo].log(LogInfo, args...)
-	opsRWLock.RUnlock()
+	if !enabled(LogInfo) {
+		return
+	}
+	if b := getBackend(); b != nil {
+		b.Infof("%s", fmt.Sprint(args...))
+	} else {
+		opsRWLock.RLock()
+		opsLogger[LogInfo].log(LogInfo, args...)
+		opsRWLock.RUnlock()
+	}
 }
 
 func Infof(format string, args ...interface{}) {
-	opsRWLock.RLock()
-	opsLogger[LogInfo].logformat(LogInfo, format, args...)
-	opsRWLock.RUnlock()
+	if !enabled(LogInfo) {
+		return
+	}
+	if b := getBackend(); b != nil {
+		b.Infof(format, args...)
+	} else {
+		opsRWLock.RLock()
+		opsLogger[LogInfo].logformat(LogInfo, format, args...)
+		opsRWLock.RUnlock()
+	}
 }
@@ -25,18 +25,53 @@ import (
 
 	"github.com/juju/errors"
 	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/engine/queue"
+	"github.com/weibocom/wqs/engine/zookeeper"
 	"github.com/weibocom/wqs/log"
 	"github.com/weibocom/wqs/metrics"
 	"github.com/weibocom/wqs/service"
+	"github.com/weibocom/wqs/tracing"
 )
 
 var (
 	configFile  = flag.String("config", "config.properties", "qservice's configure file")
+	configZK    = flag.String("config.zk", "", "load config from zookeeper node, eg: localhost:2181/wqs/config")
 	flagVersion = flag.Bool("version", false, "Show version information")
 	version     = "unknown"
 )
 
+// 每个日志文件的切分方式可以单独配置, eg: log.info.rolling=day
+func loggerRolling(conf *config.Config, name string, defaultVal log.RollingType) (log.RollingType, error) {
+	section, err := conf.GetSection("log")
+	if err != nil {
+		return defaultVal, nil
+	}
+	value := section.GetStringMust(name+".rolling", "")
+	if value == "" {
+		return defaultVal, nil
+	}
+	rolling, err := log.ParseRolling(value)
+	if err != nil {
+		return defaultVal, errors.NotValidf("log.%s.rolling : %q", name, value)
+	}
+	return rolling, nil
+}
+
 func initLogger(conf *config.Config) error {
+	rollings := make(map[string]log.RollingType)
+	for name, defaultVal := range map[string]log.RollingType{
+		"info":    log.RollingByDay,
+		"debug":   log.RollingByHour,
+		"profile": log.RollingByHour,
+		"access":  log.RollingByHour,
+	} {
+		rolling, err := loggerRolling(conf, name, defaultVal)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		rollings[name] = rolling
+	}
+
 	loggerInfo, err := log.NewLogger(conf.LogInfo).Open()
 	if err != nil {
 		return errors.Trace(err)
@@ -55,23 +90,46 @@ func initLogger(conf *config.Config) error {
 		return errors.Trace(err)
 	}
 
+	files := []string{conf.LogInfo, conf.LogDebug, conf.LogProfile}
+	if conf.LogAccess != "" {
+		loggerAccess, err := log.NewLogger(conf.LogAccess).Open()
+		if err != nil {
+			loggerInfo.Close()
+			loggerDebug.Close()
+			loggerProfile.Close()
+			return errors.Trace(err)
+		}
+		loggerAccess.SetRolling(rollings["access"])
+		log.RestAccessLogger(loggerAccess)
+		files = append(files, conf.LogAccess)
+	}
+
 	loggerInfo.SetFlags(log.LstdFlags | log.Llevel)
 	loggerInfo.SetLogLevel(log.LogInfo)
-	loggerInfo.SetRolling(log.RollingByDay)
+	loggerInfo.SetRolling(rollings["info"])
 	log.RestLogger(loggerInfo, log.LogFatal, log.LogError, log.LogWarning, log.LogInfo)
 
 	loggerDebug.SetFlags(log.LstdFlags)
 	loggerDebug.SetLogLevel(log.LogDebug)
-	loggerDebug.SetRolling(log.RollingByHour)
+	loggerDebug.SetRolling(rollings["debug"])
 	log.RestLogger(loggerDebug, log.LogDebug)
 
-	loggerProfile.SetRolling(log.RollingByHour)
+	loggerProfile.SetRolling(rollings["profile"])
 	log.RestProfileLogger(loggerProfile)
 
-	log.NewCleaner(conf.LogExpire, conf.LogInfo, conf.LogDebug, conf.LogProfile).Start()
+	log.NewCleaner(conf.LogExpire, files...).Start()
 	return nil
 }
 
+// 指定config.zk时所有proxy共用zookeeper节点中的配置, 本地配置文件提供proxy段并在读取失败时使用,
+// 连接时使用本地配置文件中metadata.zookeeper.*的认证配置
+func configSource() (config.Source, error) {
+	if *configZK != "" {
+		return zookeeper.NewConfigSource(*configZK, *configFile, queue.ConnectZK)
+	}
+	return config.NewWatcher(*configFile)
+}
+
 func main() {
 
 	flag.Parse()
@@ -82,12 +140,23 @@ func main() {
 	}
 
 	waitExist := make(chan os.Signal, 1)
-	signal.Notify(waitExist, syscall.SIGTERM, os.Interrupt, os.Kill, syscall.SIGSTOP)
+	// SIGKILL和SIGSTOP无法被捕获, windows下SIGTERM对应关闭控制台和系统关机事件
+	signal.Notify(waitExist, syscall.SIGTERM, os.Interrupt)
+	// SIGHUP重新加载配置文件
+	waitReload := make(chan os.Signal, 1)
+	signal.Notify(waitReload, syscall.SIGHUP)
 
-	conf, err := config.NewConfigFromFile(*configFile)
+	source, err := configSource()
+	if err != nil {
+		log.Fatal(errors.ErrorStack(err))
+	}
+	conf, err := source.Load()
 	if err != nil {
 		log.Fatal(errors.ErrorStack(err))
 	}
+	if err = conf.Check(); err != nil {
+		log.Fatalf("check config from %s err: %s", source, err)
+	}
 
 	if err = initLogger(conf); err != nil {
 		log.Fatal(errors.ErrorStack(err))
@@ -98,6 +167,10 @@ func main() {
 		log.Fatalf("init metrics err: %v", err)
 	}
 
+	if err := tracing.Init(conf); err != nil {
+		log.Fatal(errors.ErrorStack(err))
+	}
+
 	server, err := service.NewServer(conf, version)
 	if err != nil {
 		log.Fatal(errors.ErrorStack(err))
@@ -107,10 +180,31 @@ func main() {
 		log.Fatal(errors.ErrorStack(err))
 	}
 
+	server.WatchConfig(source)
+
 	log.Info("<======= process start =======>")
-	log.Infof("<======= receive signal %s to exist... =======>", <-waitExist)
+	for running := true; running; {
+		select {
+		case <-waitReload:
+			if _, err = server.ReloadConfig(); err != nil {
+				log.Errorf("reload config err: %s", errors.ErrorStack(err))
+			}
+		case sig := <-waitExist:
+			log.Infof("<======= receive signal %s to exist... =======>", sig)
+			running = false
+		}
+	}
 
+	// 等待请求处理完成时再次收到信号则直接退出
+	go func() {
+		sig := <-waitExist
+		log.Warnf("receive signal %s again, exit without draining", sig)
+		os.Exit(1)
+	}()
 	server.Stop()
 	metrics.Stop()
+	if err := tracing.Close(); err != nil {
+		log.Warnf("close tracing err: %v", err)
+	}
 	log.Info("<======= process stop =======>")
 }
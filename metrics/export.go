@@ -0,0 +1,124 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 统计信息的导出格式
+const (
+	FormatJSON        = "json"
+	FormatCSV         = "csv"
+	FormatOpenMetrics = "openmetrics"
+)
+
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatOpenMetrics:
+		return "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	}
+	return "application/json; charset=utf-8"
+}
+
+// 查询多个queue/group的数据并按format导出. json为对齐后的数据, csv每行一个时间点, 每列一个queue/group;
+// openmetrics每个数据点带时间戳, 可以用来导入一段历史数据
+func ExportMetricsSeries(params []*QueryParam, average bool, format string) (string, error) {
+	switch format {
+	case FormatJSON, FormatCSV, FormatOpenMetrics:
+	default:
+		return "", errUnknownFormat
+	}
+	aligned, err := readSeries(params, average)
+	if err != nil {
+		return "", err
+	}
+	switch format {
+	case FormatCSV:
+		return exportCSV(aligned)
+	case FormatOpenMetrics:
+		return exportOpenMetrics(aligned, params[0]), nil
+	}
+	return aligned.String(), nil
+}
+
+func formatValue(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+// 多个queue/group时最后一列为合计
+func exportCSV(aligned *alignedData) (string, error) {
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	header := []string{"timestamp"}
+	for _, series := range aligned.Series {
+		header = append(header, series.Queue+"."+series.Group)
+	}
+	withTotal := len(aligned.Series) > 1
+	if withTotal {
+		header = append(header, "total")
+	}
+	w.Write(header)
+	for i, t := range aligned.TimeStamps {
+		row := []string{strconv.FormatInt(t, 10)}
+		for _, series := range aligned.Series {
+			row = append(row, formatValue(series.Values[i]))
+		}
+		if withTotal {
+			row = append(row, formatValue(aligned.Total[i]))
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return buf.String(), w.Error()
+}
+
+// 指标名为wqs_[action]_[type], 按客户端查询时带client标签. 查询失败的queue/group没有数据点
+func exportOpenMetrics(aligned *alignedData, param *QueryParam) string {
+	typ, client := param.MetricsKey, ""
+	if strings.HasPrefix(typ, Client+".") {
+		if i := strings.LastIndex(typ, "."); i > len(Client) {
+			client, typ = typ[len(Client)+1:i], typ[i+1:]
+		}
+	}
+	name := "wqs_" + strings.ToLower(param.ActionKey) + "_" + strings.ToLower(typ)
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "# TYPE %s gauge\n", name)
+	for _, series := range aligned.Series {
+		labels := fmt.Sprintf("queue=%q,group=%q", series.Queue, series.Group)
+		if client != "" {
+			labels += fmt.Sprintf(",client=%q", client)
+		}
+		for i, t := range aligned.TimeStamps {
+			if v := series.Values[i]; v != nil {
+				fmt.Fprintf(buf, "%s{%s} %s %d\n", name, labels, formatValue(v), t)
+			}
+		}
+	}
+	buf.WriteString("# EOF\n")
+	return buf.String()
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import "testing"
+
+func testAlignedData() *alignedData {
+	return alignSeries([]*metricsData{
+		{Points: []metricsDataPoint{{10, 1}, {20, 2.5}}},
+		{Points: []metricsDataPoint{{20, 3}}},
+	}, 10, 20, 10, false)
+}
+
+func TestExportCSV(t *testing.T) {
+	aligned := testAlignedData()
+	aligned.Series[0].Queue, aligned.Series[0].Group = "q1", "g1"
+	aligned.Series[1].Queue, aligned.Series[1].Group = "q2", "g2"
+	data, err := exportCSV(aligned)
+	if err != nil {
+		t.Fatalf("export csv error: %v", err)
+	}
+	if want := "timestamp,q1.g1,q2.g2,total\n10,1,,1\n20,2.5,3,5.5\n"; data != want {
+		t.Errorf("got csv %q, want %q", data, want)
+	}
+
+	aligned.Series = aligned.Series[:1]
+	if data, _ = exportCSV(aligned); data != "timestamp,q1.g1\n10,1\n20,2.5\n" {
+		t.Errorf("unexpected single series csv %q", data)
+	}
+}
+
+func TestExportOpenMetrics(t *testing.T) {
+	aligned := testAlignedData()
+	aligned.Series[0].Queue, aligned.Series[0].Group = "q1", "g1"
+	aligned.Series[1].Queue, aligned.Series[1].Group = "q2", "g2"
+	data := exportOpenMetrics(aligned, &QueryParam{ActionKey: CmdSet, MetricsKey: Qps})
+	want := "# TYPE wqs_set_qps gauge\n" +
+		"wqs_set_qps{queue=\"q1\",group=\"g1\"} 1 10\n" +
+		"wqs_set_qps{queue=\"q1\",group=\"g1\"} 2.5 20\n" +
+		"wqs_set_qps{queue=\"q2\",group=\"g2\"} 3 20\n" +
+		"# EOF\n"
+	if data != want {
+		t.Errorf("got openmetrics %q, want %q", data, want)
+	}
+
+	aligned.Series = aligned.Series[1:]
+	data = exportOpenMetrics(aligned, &QueryParam{ActionKey: CmdGet, MetricsKey: Client + ".order-service." + Qps})
+	want = "# TYPE wqs_get_qps gauge\n" +
+		"wqs_get_qps{queue=\"q2\",group=\"g2\",client=\"order-service\"} 3 20\n" +
+		"# EOF\n"
+	if data != want {
+		t.Errorf("got client openmetrics %q, want %q", data, want)
+	}
+
+	if _, err := ExportMetricsSeries(nil, false, "xml"); err != errUnknownFormat {
+		t.Errorf("expect unknown format error, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	kafkaWriter       = "kafka"
+	defaultKafkaTopic = "wqs_metrics"
+)
+
+// 每个统计周期内的每一项指标都会被序列化为一条changeEvent写入kafka,
+// 便于外部的分析系统直接使用标准的kafka消费工具获取wqs的监控数据.
+type changeEvent struct {
+	Host      string  `json:"host"`
+	Timestamp int64   `json:"timestamp"`
+	Queue     string  `json:"queue,omitempty"`
+	Group     string  `json:"group,omitempty"`
+	Action    string  `json:"action,omitempty"`
+	Metric    string  `json:"metric"`
+	Type      string  `json:"type"`
+	Value     float64 `json:"value"`
+}
+
+type kafkaChangefeed struct {
+	host     string
+	topic    string
+	producer sarama.SyncProducer
+}
+
+func newKafkaChangefeed(brokerAddrs []string, topic string) (*kafkaChangefeed, error) {
+
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	conf := sarama.NewConfig()
+	conf.Producer.RequiredAcks = sarama.WaitForLocal
+	conf.Producer.Return.Successes = true
+	conf.Producer.Partitioner = sarama.NewHashPartitioner
+
+	producer, err := sarama.NewSyncProducer(brokerAddrs, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafkaChangefeed{
+		host:     host,
+		topic:    topic,
+		producer: producer,
+	}, nil
+}
+
+func (k *kafkaChangefeed) Write(snap metrics.Registry) error {
+
+	events := genChangeEvents(k.host, time.Now().Unix(), snap)
+	if len(events) == 0 {
+		return nil
+	}
+
+	messages := make([]*sarama.ProducerMessage, 0, len(events))
+	for _, evt := range events {
+		data, err := json.Marshal(evt)
+		if err != nil {
+			return err
+		}
+		// 以queue作为key, 保证同一个queue的数据落在同一个partition上, 消费时有序
+		messages = append(messages, &sarama.ProducerMessage{
+			Topic: k.topic,
+			Key:   sarama.StringEncoder(evt.Queue),
+			Value: sarama.ByteEncoder(data),
+		})
+	}
+	return k.producer.SendMessages(messages)
+}
+
+func (k *kafkaChangefeed) Close() error {
+	return k.producer.Close()
+}
+
+func genChangeEvents(host string, timestamp int64, snap metrics.Registry) []*changeEvent {
+	events := make([]*changeEvent, 0)
+
+	snap.Each(func(key string, i interface{}) {
+		evt := &changeEvent{Host: host, Timestamp: timestamp}
+		switch m := i.(type) {
+		case metrics.Counter:
+			evt.Type, evt.Value = "counter", float64(m.Count())
+		case metrics.Meter:
+			evt.Type, evt.Value = "meter", m.Rate1()
+		case metrics.Timer:
+			evt.Type, evt.Value = "timer", m.RateMean()
+		case metrics.Gauge:
+			evt.Type, evt.Value = "gauge", float64(m.Value())
+		case metrics.GaugeFloat64:
+			evt.Type, evt.Value = "gauge", m.Value()
+		default:
+			return
+		}
+		evt.Queue, evt.Group, evt.Action, evt.Metric = parseMetricsKey(key)
+		events = append(events, evt)
+	})
+	return events
+}
+
+// 将"queue.group.SET.Less10ms.qps"这类指标key拆分为结构化的字段,
+// 不属于某个queue/group的全局指标只填充metric.
+func parseMetricsKey(key string) (queue, group, action, metric string) {
+	tokens := strings.Split(key, ".")
+	if len(tokens) < 3 {
+		return "", "", "", key
+	}
+
+	switch tokens[2] {
+	case CmdSet, CmdGet, CmdAck:
+		if len(tokens) == 3 {
+			return tokens[0], tokens[1], tokens[2], ""
+		}
+		return tokens[0], tokens[1], tokens[2], strings.Join(tokens[3:], ".")
+	case Accum, Rebalance, RecvError:
+		return tokens[0], tokens[1], "", strings.Join(tokens[2:], ".")
+	}
+	return "", "", "", key
+}
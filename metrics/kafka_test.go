@@ -0,0 +1,42 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import "testing"
+
+func TestParseMetricsKey(t *testing.T) {
+	cases := []struct {
+		key                          string
+		queue, group, action, metric string
+	}{
+		{"q.g.SET.ops", "q", "g", CmdSet, "ops"},
+		{"q.g.GET.Less10ms.qps", "q", "g", CmdGet, "Less10ms.qps"},
+		{"q.g.ACK", "q", "g", CmdAck, ""},
+		{"q.g.Accum", "q", "g", "", Accum},
+		{"q.g.Rebalance.qps", "q", "g", "", "Rebalance.qps"},
+		{"SetError.qps", "", "", "", "SetError.qps"},
+		{Goroutine, "", "", "", Goroutine},
+	}
+
+	for _, c := range cases {
+		queue, group, action, metric := parseMetricsKey(c.key)
+		if queue != c.queue || group != c.group || action != c.action || metric != c.metric {
+			t.Errorf("parseMetricsKey(%q) = %q %q %q %q, want %q %q %q %q", c.key,
+				queue, group, action, metric, c.queue, c.group, c.action, c.metric)
+		}
+	}
+}
@@ -18,7 +18,9 @@ package metrics
 
 import (
 	"errors"
+	"io"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -32,6 +34,7 @@ var (
 	errInvalidParam     = errors.New("Invalid params")
 	errInvalidReader    = errors.New("Invalid reader")
 	errUnknownTransport = errors.New("Unknown transport")
+	errUnknownFormat    = errors.New("Unknown format")
 )
 
 type eventType int32
@@ -67,9 +70,41 @@ const (
 	ReConn      = "ReConn"
 	Elapsed     = "elapsed"
 	Rebalance   = "Rebalance"
+	Worker      = "Worker"
+	CommitError = "CommitError"
 	RecvError   = "RecvError"
 	BytesRead   = "BytesRead"
 	BytesWriten = "BytesWriten"
+	Throttled   = "Throttled"
+	OverQuota   = "OverQuota"
+	UnderISR    = "UnderISR"
+	MsgTrace    = "MsgTrace"
+	Schedule    = "Schedule"
+	Dropped     = "Dropped"
+	Expired     = "Expired"
+	Duplicated  = "Duplicated"
+	Paused      = "Paused"
+	Breaker     = "Breaker"
+	Tripped     = "Tripped"
+	Rejected    = "Rejected"
+	Shed        = "Shed"
+	Overload    = "Overload"
+	Client      = "Client"
+	Mirror      = "Mirror"
+	Transfer    = "Transfer"
+	QueueExpire = "QueueExpired"
+	Orphan      = "Orphan"
+	Cleaned     = "Cleaned"
+	Fault       = "Fault"
+	Consumer    = "Consumer"
+	Failover    = "Failover"
+	Invalid     = "Invalid"
+	Intercepted = "Intercepted"
+	Filtered    = "Filtered"
+	Unsampled   = "Unsampled"
+	TooLarge    = "TooLarge"
+	Chunked     = "Chunked"
+	Incomplete  = "Incomplete"
 	Goroutine   = "Goroutine"
 	Gc          = "Gc"
 	GcPauseAvg  = "GcPauseAvg"
@@ -84,6 +119,8 @@ const (
 	defaultReader   = graphiteWriter
 	localhost       = "localhost"
 	sinkDuration    = time.Second * 5
+	// 内存中累加的计数合并到registry的周期, 需要小于meter的tick周期(5秒)
+	flushDuration = time.Second
 )
 
 type event struct {
@@ -95,6 +132,12 @@ type event struct {
 type registry struct {
 	eventBus chan *event
 	registry metrics.Registry
+	// 计数器和meter的增量按key在内存中原子累加, 由eventLoop定时合并,
+	// 避免高并发时每条消息都经过eventBus
+	counters sync.Map
+	meters   sync.Map
+	// 保护writers和reader, 重新加载配置时替换
+	mu       sync.RWMutex
 	writers  map[string]statWriter
 	reader   statReader
 	stopCh   chan struct{}
@@ -111,30 +154,63 @@ var reg = &registry{
 
 func Start(cfg *config.Config) (err error) {
 
-	section, err := cfg.GetSection("metrics")
+	writers, reader, err := newTransports(cfg)
+	if err != nil {
+		return err
+	}
+	reg.writers, reg.reader = writers, reader
+
+	go reg.eventLoop()
+	return nil
+}
+
+// 按新的配置替换reader和writer, 已经统计的数据不受影响. 创建失败时保留原来的配置
+func Reload(cfg *config.Config) error {
+	writers, reader, err := newTransports(cfg)
 	if err != nil {
 		return err
 	}
 
-	// 初始化states的reader和writer
-	writers := section.GetStringMust("transport.writers", defaultWriter)
-	names := strings.Split(writers, ",")
+	reg.mu.Lock()
+	old, oldReader := reg.writers, reg.reader
+	reg.writers, reg.reader = writers, reader
+	reg.mu.Unlock()
+
+	for name, w := range old {
+		if c, ok := w.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Warnf("close metrics writer %s error : %v", name, err)
+			}
+		}
+	}
+	if c, ok := oldReader.(io.Closer); ok {
+		c.Close()
+	}
+	return nil
+}
+
+// 初始化states的reader和writer
+func newTransports(cfg *config.Config) (map[string]statWriter, statReader, error) {
+	section, err := cfg.GetSection("metrics")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	writers := make(map[string]statWriter)
+	names := strings.Split(section.GetStringMust("transport.writers", defaultWriter), ",")
 	for _, name := range names {
-		w, err := getWriter(name, section)
+		w, err := getWriter(name, section, cfg.ProxyId)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
-		reg.writers[name] = w
+		writers[name] = w
 	}
 
-	reader := section.GetStringMust("transport.reader", defaultReader)
-	reg.reader, err = getReader(reader, section)
+	reader, err := getReader(section.GetStringMust("transport.reader", defaultReader), section, cfg.ProxyId)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-
-	go reg.eventLoop()
-	return nil
+	return writers, reader, nil
 }
 
 func Stop() {
@@ -154,20 +230,48 @@ func (r *registry) eventLoop() {
 	}
 
 	ticker := time.NewTicker(sinkDuration)
+	flushTicker := time.NewTicker(flushDuration)
 
 	for {
 		select {
 		case evt := <-r.eventBus:
 			r.processEvent(evt)
+		case <-flushTicker.C:
+			r.flush()
 		case <-ticker.C:
+			r.flush()
 			r.sink()
 		case <-r.stopCh:
 			ticker.Stop()
+			flushTicker.Stop()
 			return
 		}
 	}
 }
 
+// 累加一个增量, key第一次出现时才需要分配
+func (r *registry) add(m *sync.Map, key string, value int64) {
+	v, ok := m.Load(key)
+	if !ok {
+		v, _ = m.LoadOrStore(key, new(int64))
+	}
+	atomic.AddInt64(v.(*int64), value)
+}
+
+// 把累加的增量合并到registry, 只在eventLoop中调用
+func (r *registry) flush() {
+	merge := func(m *sync.Map, typ eventType) {
+		m.Range(func(key, v interface{}) bool {
+			if n := atomic.SwapInt64(v.(*int64), 0); n != 0 {
+				r.processEvent(&event{event: typ, key: key.(string), value: n})
+			}
+			return true
+		})
+	}
+	merge(&r.counters, eventCounter)
+	merge(&r.meters, eventMeter)
+}
+
 func (r *registry) processEvent(evt *event) {
 	switch evt.event {
 	case eventCounter:
@@ -216,6 +320,8 @@ func (r *registry) sink() {
 		return
 	}
 
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	for name, writer := range r.writers {
 		if err := writer.Write(snap); err != nil {
 			log.Errorf("metrics writer %s error : %v", name, err)
@@ -224,12 +330,7 @@ func (r *registry) sink() {
 }
 
 func AddCounter(key string, value int64) {
-	evt := &event{event: eventCounter, key: key, value: value}
-	select {
-	case reg.eventBus <- evt:
-	default:
-		log.Error("metrics eventBus is full.")
-	}
+	reg.add(&reg.counters, key, value)
 }
 
 func GetCounter(key string) int64 {
@@ -237,12 +338,7 @@ func GetCounter(key string) int64 {
 }
 
 func AddMeter(key string, value int64) {
-	evt := &event{event: eventMeter, key: key, value: value}
-	select {
-	case reg.eventBus <- evt:
-	default:
-		log.Error("metrics eventBus is full.")
-	}
+	reg.add(&reg.meters, key, value)
 }
 
 func GetMeterRate(key string) float64 {
@@ -272,7 +368,10 @@ func AddGauge(key string, value int64) {
 
 func GetMetrics(param *QueryParam) (stat string, err error) {
 
-	if reg.reader == nil {
+	reg.mu.RLock()
+	reader := reg.reader
+	reg.mu.RUnlock()
+	if reader == nil {
 		return "", errInvalidReader
 	}
 
@@ -280,7 +379,7 @@ func GetMetrics(param *QueryParam) (stat string, err error) {
 		return "", err
 	}
 
-	return reg.reader.Read(param)
+	return reader.Read(param)
 }
 
 func ElapseTimeString(t int64) string {
@@ -302,7 +401,7 @@ func ElapseTimeString(t int64) string {
 	}
 }
 
-func getWriter(name string, section config.Section) (statWriter, error) {
+func getWriter(name string, section config.Section, proxyID int) (statWriter, error) {
 	switch name {
 	case graphiteWriter:
 		graphiteAddr, err := section.GetString("graphite.report.addr.udp")
@@ -317,13 +416,27 @@ func getWriter(name string, section config.Section) (statWriter, error) {
 		return newGraphite(graphiteRoot, graphiteAddr, graphiteServicePool), nil
 	case profileWriter:
 		return newProfileWriter(), nil
+	case kafkaWriter:
+		brokerAddrs, err := section.GetString("kafka.brokers")
+		if err != nil {
+			return nil, err
+		}
+		topic := section.GetStringMust("kafka.topic", defaultKafkaTopic)
+		return newKafkaChangefeed(strings.Split(brokerAddrs, ","), topic)
+	case redisWriter:
+		w, err := newRedisMetrics(section, proxyID)
+		if err != nil {
+			return nil, err
+		}
+		w.startRollup()
+		return w, nil
 	default:
 		log.Errorf("unknown metrics writer: %s", name)
 	}
 	return nil, errUnknownTransport
 }
 
-func getReader(name string, section config.Section) (statReader, error) {
+func getReader(name string, section config.Section, proxyID int) (statReader, error) {
 	switch name {
 	case graphiteWriter:
 		graphiteAddr, err := section.GetString("graphite.report.addr.udp")
@@ -336,6 +449,8 @@ func getReader(name string, section config.Section) (statReader, error) {
 		}
 		graphiteRoot := section.GetStringMust("graphite.root", localhost)
 		return newGraphite(graphiteRoot, graphiteAddr, graphiteServicePool), nil
+	case redisWriter:
+		return newRedisMetrics(section, proxyID)
 	default:
 		log.Errorf("unknown metrics writer: %s", name)
 	}
@@ -15,3 +15,463 @@ limitations under the License.
 */
 
 package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	redisWriter        = "redis"
+	defaultRedisPrefix = "wqs:metrics"
+	redisTimeout       = time.Second
+	// 数据点的时间间隔, 与写入周期相同
+	redisStep = int64(sinkDuration / time.Second)
+
+	typeCounter = "counter"
+	typeMeter   = "meter"
+	typeTimer   = "timer"
+	typeGauge   = "gauge"
+)
+
+// 每个proxy把自己的指标写入以实例名区分的key, 查询时合并所有实例的数据.
+// [prefix]:instances为实例名及最后一次写入时间的zset, [prefix]:types为指标类型的hash,
+// [prefix]:[instance]:[key]为score是时间, member是"时间:值"的zset
+type redisMetrics struct {
+	instance    string
+	prefix      string
+	client      *redisClient
+	resolutions []resolution
+	// 每个精度已经汇总到的时间, 只在汇总的goroutine中访问
+	rolled    []int64
+	stopCh    chan struct{}
+	closeOnce sync.Once
+
+	mu    sync.Mutex
+	typed map[string]bool
+	// 本实例写入过的指标及类型
+	keys map[string]string
+}
+
+func newRedisMetrics(section config.Section, proxyID int) (*redisMetrics, error) {
+	addr, err := section.GetString("redis.addr")
+	if err != nil {
+		return nil, err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	resolutions, err := loadResolutions(section)
+	if err != nil {
+		return nil, err
+	}
+	return &redisMetrics{
+		// 同一台机器上可能部署多个proxy
+		instance: fmt.Sprintf("%s-%d", host, proxyID),
+		prefix:   section.GetStringMust("redis.prefix", defaultRedisPrefix),
+		client: &redisClient{
+			addr:     addr,
+			password: section.GetStringMust("redis.password", ""),
+			db:       section.GetInt64Must("redis.db", 0),
+		},
+		resolutions: resolutions,
+		rolled:      make([]int64, len(resolutions)),
+		stopCh:      make(chan struct{}),
+		typed:       make(map[string]bool),
+		keys:        make(map[string]string),
+	}, nil
+}
+
+func (r *redisMetrics) Write(snap metrics.Registry) error {
+
+	now := time.Now().Unix()
+	ts := now / redisStep * redisStep
+	raw := r.resolutions[0]
+	expire := strconv.FormatInt(int64(raw.retention/time.Second), 10)
+
+	r.mu.Lock()
+	cmds := [][]string{{"ZADD", r.prefix + ":instances", strconv.FormatInt(now, 10), r.instance}}
+	snap.Each(func(key string, i interface{}) {
+		typ, value, ok := metricValue(i)
+		if !ok {
+			return
+		}
+		if !r.typed[key] {
+			cmds = append(cmds, []string{"HSET", r.prefix + ":types", key, typ})
+		}
+		r.keys[key] = typ
+		series := r.seriesKey(raw, r.instance, key)
+		cmds = append(cmds,
+			[]string{"ZADD", series, strconv.FormatInt(ts, 10), formatPoint(ts, value)},
+			[]string{"EXPIRE", series, expire})
+	})
+	r.mu.Unlock()
+
+	replies, err := r.client.pipeline(cmds)
+	if err != nil {
+		return err
+	}
+	// 类型写入成功后不再重复写
+	var firstErr error
+	r.mu.Lock()
+	for i, cmd := range cmds {
+		err, failed := replies[i].(error)
+		if failed && firstErr == nil {
+			firstErr = err
+		}
+		if cmd[0] == "HSET" && !failed {
+			r.typed[cmd[2]] = true
+		}
+	}
+	r.mu.Unlock()
+	return firstErr
+}
+
+func (r *redisMetrics) Read(param *QueryParam) (string, error) {
+
+	key := strings.Join([]string{param.Queue, param.Group, param.ActionKey, param.MetricsKey}, ".")
+	res := r.resolutionFor(param.StartTime, time.Now().Unix())
+	step := param.Step / res.step * res.step
+	if step < res.step {
+		step = res.step
+	}
+	start := param.StartTime / step * step
+
+	instances := []string{param.Host}
+	if param.Host == AllHost {
+		reply, err := r.client.do("ZRANGEBYSCORE", r.prefix+":instances", strconv.FormatInt(start, 10), "+inf")
+		if err != nil {
+			return "", err
+		}
+		instances = toStrings(reply)
+	}
+
+	reply, err := r.client.do("HGET", r.prefix+":types", key)
+	if err != nil {
+		return "", err
+	}
+	typ, _ := reply.(string)
+	if typ == "" || len(instances) == 0 {
+		return (&metricsData{Points: make([]metricsDataPoint, 0)}).String(), nil
+	}
+
+	// 计数器需要前一个点计算第一个窗口的增量
+	from := strconv.FormatInt(start-step, 10)
+	cmds := make([][]string, 0, len(instances))
+	for _, instance := range instances {
+		cmds = append(cmds, []string{"ZRANGEBYSCORE", r.seriesKey(res, instance, key), from, strconv.FormatInt(param.EndTime, 10)})
+	}
+	replies, err := r.client.pipeline(cmds)
+	if err != nil {
+		return "", err
+	}
+	series := make([][]redisPoint, 0, len(replies))
+	for _, reply := range replies {
+		if err, ok := reply.(error); ok {
+			return "", err
+		}
+		series = append(series, parsePoints(toStrings(reply)))
+	}
+	return aggregateSeries(typ, series, start, param.EndTime, step).String(), nil
+}
+
+func (r *redisMetrics) Close() error {
+	r.closeOnce.Do(func() { close(r.stopCh) })
+	r.client.close()
+	return nil
+}
+
+func metricValue(i interface{}) (string, float64, bool) {
+	switch m := i.(type) {
+	case metrics.Counter:
+		return typeCounter, float64(m.Count()), true
+	case metrics.Meter:
+		return typeMeter, m.Rate1(), true
+	case metrics.Timer:
+		return typeTimer, m.RateMean(), true
+	case metrics.Gauge:
+		return typeGauge, float64(m.Value()), true
+	case metrics.GaugeFloat64:
+		return typeGauge, m.Value(), true
+	}
+	return "", 0, false
+}
+
+type redisPoint struct {
+	ts    int64
+	value float64
+}
+
+func formatPoint(ts int64, value float64) string {
+	return strconv.FormatInt(ts, 10) + ":" + strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func parsePoints(members []string) []redisPoint {
+	points := make([]redisPoint, 0, len(members))
+	for _, member := range members {
+		kv := strings.SplitN(member, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		ts, err1 := strconv.ParseInt(kv[0], 10, 64)
+		value, err2 := strconv.ParseFloat(kv[1], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		points = append(points, redisPoint{ts: ts, value: value})
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].ts < points[j].ts })
+	return points
+}
+
+// 先在每个实例内按step聚合, 再合并所有实例:
+// counter为窗口内的增量之和, meter和gauge为各实例之和, timer为各实例的平均值
+func aggregateSeries(typ string, series [][]redisPoint, start int64, end int64, step int64) *metricsData {
+
+	type window struct {
+		sum   float64
+		count int
+	}
+	windows := make(map[int64]*window)
+	add := func(t int64, value float64) {
+		w, ok := windows[t]
+		if !ok {
+			w = &window{}
+			windows[t] = w
+		}
+		w.sum += value
+		w.count++
+	}
+
+	for _, points := range series {
+		values := make(map[int64]float64)
+		counts := make(map[int64]int)
+		var prev *redisPoint
+		for i := range points {
+			p := &points[i]
+			t := p.ts / step * step
+			switch typ {
+			case typeCounter:
+				if prev != nil && t >= start {
+					delta := p.value - prev.value
+					// proxy重启后计数从0开始
+					if delta < 0 {
+						delta = p.value
+					}
+					values[t] += delta
+					counts[t] = 1
+				}
+				prev = p
+				continue
+			case typeGauge:
+				values[t] = p.value
+				counts[t] = 1
+			default:
+				values[t] += p.value
+				counts[t]++
+			}
+		}
+		for t, value := range values {
+			if t < start || t > end {
+				continue
+			}
+			add(t, value/float64(counts[t]))
+		}
+	}
+
+	data := &metricsData{Points: make([]metricsDataPoint, 0, len(windows))}
+	for t, w := range windows {
+		value := w.sum
+		if typ == typeTimer {
+			value /= float64(w.count)
+		}
+		data.Points = append(data.Points, metricsDataPoint{TimeStamp: t, Value: value})
+	}
+	sort.Slice(data.Points, func(i, j int) bool {
+		return data.Points[i].TimeStamp < data.Points[j].TimeStamp
+	})
+	return data
+}
+
+func toStrings(reply interface{}) []string {
+	values, _ := reply.([]interface{})
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
+
+// 简单的redis客户端, 所有请求共用一个连接, 出错后下次请求时重连
+type redisClient struct {
+	addr     string
+	password string
+	db       int64
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func (c *redisClient) do(args ...string) (interface{}, error) {
+	replies, err := c.pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(error); ok {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// 一次发送所有命令后再读取结果, 命令本身的错误作为error类型的结果返回
+func (c *redisClient) pipeline(cmds [][]string) ([]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+	replies, err := c.roundTrip(cmds)
+	if err != nil {
+		c.close()
+	}
+	return replies, err
+}
+
+func (c *redisClient) connect() error {
+	conn, err := net.DialTimeout("tcp", c.addr, redisTimeout)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.r = bufio.NewReader(conn)
+	c.w = bufio.NewWriter(conn)
+
+	var cmds [][]string
+	if c.password != "" {
+		cmds = append(cmds, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		cmds = append(cmds, []string{"SELECT", strconv.FormatInt(c.db, 10)})
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+	replies, err := c.roundTrip(cmds)
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *redisClient) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+func (c *redisClient) roundTrip(cmds [][]string) ([]interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(redisTimeout))
+	for _, args := range cmds {
+		fmt.Fprintf(c.w, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, 0, len(cmds))
+	for range cmds {
+		reply, err := readReply(c.r)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// 返回string, int64, []interface{}, redisError或nil
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: bad reply %q", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := readReply(r)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("redis: unsupported reply %q", line)
+}
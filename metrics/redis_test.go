@@ -0,0 +1,89 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadReply(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*3\r\n$4\r\n10:1\r\n$-1\r\n:5\r\n-ERR wrong type\r\n*0\r\n"))
+	reply, err := readReply(r)
+	if err != nil {
+		t.Fatalf("read array error: %v", err)
+	}
+	if !reflect.DeepEqual(reply, []interface{}{"10:1", nil, int64(5)}) {
+		t.Errorf("unexpected array %#v", reply)
+	}
+	reply, err = readReply(r)
+	if _, ok := reply.(error); err != nil || !ok {
+		t.Errorf("expect redis error as reply, got %#v %v", reply, err)
+	}
+	if reply, err = readReply(r); err != nil || len(reply.([]interface{})) != 0 {
+		t.Errorf("expect empty array, got %#v %v", reply, err)
+	}
+}
+
+func TestParsePoints(t *testing.T) {
+	points := parsePoints([]string{"20:2.5", "bad", "10:1", "x:1"})
+	if !reflect.DeepEqual(points, []redisPoint{{10, 1}, {20, 2.5}}) {
+		t.Errorf("unexpected points %+v", points)
+	}
+	if member := formatPoint(10, 1.5); member != "10:1.5" {
+		t.Errorf("unexpected member %q", member)
+	}
+}
+
+func TestAggregateSeries(t *testing.T) {
+	values := func(data *metricsData) map[int64]float64 {
+		m := make(map[int64]float64)
+		for _, p := range data.Points {
+			m[p.TimeStamp] = p.Value
+		}
+		return m
+	}
+
+	// 两个proxy的计数器, 第二个在窗口内重启
+	series := [][]redisPoint{
+		{{90, 100}, {100, 110}, {105, 120}, {110, 130}},
+		{{95, 50}, {100, 60}, {110, 5}},
+	}
+	got := values(aggregateSeries(typeCounter, series, 100, 119, 10))
+	if want := map[int64]float64{100: 20 + 10, 110: 10 + 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("counter: got %v, want %v", got, want)
+	}
+
+	series = [][]redisPoint{
+		{{100, 10}, {105, 20}},
+		{{100, 5}},
+	}
+	if got, want := values(aggregateSeries(typeMeter, series, 100, 109, 10)), map[int64]float64{100: 15 + 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("meter: got %v, want %v", got, want)
+	}
+	if got, want := values(aggregateSeries(typeTimer, series, 100, 109, 10)), map[int64]float64{100: (15 + 5) / 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("timer: got %v, want %v", got, want)
+	}
+	if got, want := values(aggregateSeries(typeGauge, series, 100, 109, 10)), map[int64]float64{100: 20 + 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("gauge: got %v, want %v", got, want)
+	}
+	if data := aggregateSeries(typeMeter, series, 200, 300, 10); len(data.Points) != 0 {
+		t.Errorf("expect no points out of range, got %+v", data.Points)
+	}
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/log"
+)
+
+const (
+	defaultRawRetention    = 24 * time.Hour
+	defaultMinuteRetention = 7 * 24 * time.Hour
+	defaultHourRetention   = 90 * 24 * time.Hour
+	rollupInterval         = time.Minute
+)
+
+// 数据点的精度: 原始数据每个统计周期一个点, proxy每分钟把自己的数据汇总为分钟和小时精度.
+// 超过保留时间的数据点被删除, 查询时选择保留了起始时间数据的最高精度
+type resolution struct {
+	name      string
+	step      int64
+	retention time.Duration
+}
+
+func loadResolutions(section config.Section) ([]resolution, error) {
+	resolutions := []resolution{
+		{name: "raw", step: redisStep, retention: defaultRawRetention},
+		{name: "minute", step: 60, retention: defaultMinuteRetention},
+		{name: "hour", step: 3600, retention: defaultHourRetention},
+	}
+	for i := range resolutions {
+		res := &resolutions[i]
+		value := section.GetStringMust("redis.retention."+res.name, "")
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid metrics.redis.retention.%s: %s", res.name, value)
+		}
+		res.retention = d
+	}
+	// 低精度的数据由上一级汇总而来, 上一级至少要保留一个汇总窗口
+	for i := 1; i < len(resolutions); i++ {
+		prev, res := resolutions[i-1], resolutions[i]
+		if prev.retention < time.Duration(res.step)*time.Second || prev.retention > res.retention {
+			return nil, fmt.Errorf("metrics.redis.retention.%s %v conflicts with retention.%s %v",
+				prev.name, prev.retention, res.name, res.retention)
+		}
+	}
+	return resolutions, nil
+}
+
+// 原始数据的key保持不变, 汇总数据为[prefix]:[精度]:[instance]:[key]
+func (r *redisMetrics) seriesKey(res resolution, instance string, key string) string {
+	if res.step == redisStep {
+		return r.prefix + ":" + instance + ":" + key
+	}
+	return r.prefix + ":" + res.name + ":" + instance + ":" + key
+}
+
+// 查询起始时间已经超出所有精度的保留时间时使用最低精度
+func (r *redisMetrics) resolutionFor(start int64, now int64) resolution {
+	for _, res := range r.resolutions {
+		if start >= now-int64(res.retention/time.Second) {
+			return res
+		}
+	}
+	return r.resolutions[len(r.resolutions)-1]
+}
+
+func (r *redisMetrics) startRollup() {
+	go func() {
+		ticker := time.NewTicker(rollupInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				if err := r.rollup(now.Unix()); err != nil {
+					log.Warnf("metrics redis rollup error : %v", err)
+				}
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// 汇总上一个已经结束的窗口并删除过期的数据点, 只处理本实例写入过的指标
+func (r *redisMetrics) rollup(now int64) error {
+	r.mu.Lock()
+	keys := make(map[string]string, len(r.keys))
+	for key, typ := range r.keys {
+		keys[key] = typ
+	}
+	r.mu.Unlock()
+
+	for i := 1; i < len(r.resolutions); i++ {
+		res := r.resolutions[i]
+		end := now / res.step * res.step
+		if end <= r.rolled[i] {
+			continue
+		}
+		if err := r.rollupWindow(keys, r.resolutions[i-1], res, end-res.step, end); err != nil {
+			return err
+		}
+		r.rolled[i] = end
+	}
+
+	cmds := make([][]string, 0, len(keys)*len(r.resolutions)+1)
+	longest := r.resolutions[len(r.resolutions)-1].retention
+	cmds = append(cmds, []string{"ZREMRANGEBYSCORE", r.prefix + ":instances", "-inf", "(" + strconv.FormatInt(now-int64(longest/time.Second), 10)})
+	for key := range keys {
+		for _, res := range r.resolutions {
+			cmds = append(cmds, []string{"ZREMRANGEBYSCORE", r.seriesKey(res, r.instance, key),
+				"-inf", "(" + strconv.FormatInt(now-int64(res.retention/time.Second), 10)})
+		}
+	}
+	return r.pipeline(cmds)
+}
+
+// 把src精度在[start, end)内的数据点汇总为dst精度的一个点, 重复汇总时覆盖原来的点
+func (r *redisMetrics) rollupWindow(keys map[string]string, src, dst resolution, start, end int64) error {
+	names := make([]string, 0, len(keys))
+	cmds := make([][]string, 0, len(keys))
+	for key := range keys {
+		names = append(names, key)
+		cmds = append(cmds, []string{"ZRANGEBYSCORE", r.seriesKey(src, r.instance, key),
+			strconv.FormatInt(start, 10), "(" + strconv.FormatInt(end, 10)})
+	}
+	replies, err := r.client.pipeline(cmds)
+	if err != nil {
+		return err
+	}
+
+	ts := strconv.FormatInt(start, 10)
+	expire := strconv.FormatInt(int64(dst.retention/time.Second), 10)
+	cmds = cmds[:0]
+	for i, reply := range replies {
+		if err, ok := reply.(error); ok {
+			return err
+		}
+		value, ok := rollupPoints(keys[names[i]], parsePoints(toStrings(reply)))
+		if !ok {
+			continue
+		}
+		series := r.seriesKey(dst, r.instance, names[i])
+		cmds = append(cmds,
+			[]string{"ZREMRANGEBYSCORE", series, ts, ts},
+			[]string{"ZADD", series, ts, formatPoint(start, value)},
+			[]string{"EXPIRE", series, expire})
+	}
+	return r.pipeline(cmds)
+}
+
+// 执行多个命令, 返回第一个错误
+func (r *redisMetrics) pipeline(cmds [][]string) error {
+	if len(cmds) == 0 {
+		return nil
+	}
+	replies, err := r.client.pipeline(cmds)
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// 把一个窗口内的数据点汇总为一个: counter是累计值, 和gauge一样取最后一个值, meter和timer取平均值
+func rollupPoints(typ string, points []redisPoint) (float64, bool) {
+	if len(points) == 0 {
+		return 0, false
+	}
+	switch typ {
+	case typeCounter, typeGauge:
+		return points[len(points)-1].value, true
+	}
+	var sum float64
+	for _, p := range points {
+		sum += p.value
+	}
+	return sum / float64(len(points)), true
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+)
+
+func TestLoadResolutions(t *testing.T) {
+	resolutions, err := loadResolutions(config.Section{"redis.retention.minute": "30d"})
+	if err == nil {
+		t.Errorf("expect error for invalid duration, got %+v", resolutions)
+	}
+	if _, err = loadResolutions(config.Section{"redis.retention.raw": "30s"}); err == nil {
+		t.Errorf("expect error when raw retention is shorter than a minute")
+	}
+	if _, err = loadResolutions(config.Section{"redis.retention.hour": "24h"}); err == nil {
+		t.Errorf("expect error when hour retention is shorter than minute retention")
+	}
+
+	resolutions, err = loadResolutions(config.Section{"redis.retention.raw": "2h"})
+	if err != nil {
+		t.Fatalf("load resolutions error: %v", err)
+	}
+	if resolutions[0].retention != 2*time.Hour || resolutions[1].retention != defaultMinuteRetention {
+		t.Errorf("unexpected resolutions %+v", resolutions)
+	}
+
+	r := &redisMetrics{prefix: "p", resolutions: resolutions}
+	now := int64(100000000)
+	if res := r.resolutionFor(now-3600, now); res.name != "raw" {
+		t.Errorf("expect raw resolution, got %s", res.name)
+	}
+	if res := r.resolutionFor(now-3*3600, now); res.name != "minute" {
+		t.Errorf("expect minute resolution, got %s", res.name)
+	}
+	if res := r.resolutionFor(0, now); res.name != "hour" {
+		t.Errorf("expect hour resolution, got %s", res.name)
+	}
+	if key := r.seriesKey(resolutions[0], "h-1", "q.g.SET.qps"); key != "p:h-1:q.g.SET.qps" {
+		t.Errorf("unexpected raw key %s", key)
+	}
+	if key := r.seriesKey(resolutions[2], "h-1", "q.g.SET.qps"); key != "p:hour:h-1:q.g.SET.qps" {
+		t.Errorf("unexpected hour key %s", key)
+	}
+}
+
+func TestRollupPoints(t *testing.T) {
+	points := []redisPoint{{0, 10}, {5, 20}, {10, 30}}
+	cases := map[string]float64{
+		typeCounter: 30,
+		typeGauge:   30,
+		typeMeter:   20,
+		typeTimer:   20,
+	}
+	for typ, want := range cases {
+		if got, ok := rollupPoints(typ, points); !ok || got != want {
+			t.Errorf("%s: got %v, want %v", typ, got, want)
+		}
+	}
+	if _, ok := rollupPoints(typeMeter, nil); ok {
+		t.Errorf("expect no point for empty window")
+	}
+}
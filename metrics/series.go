@@ -0,0 +1,177 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 同时查询的数据源请求数
+const maxConcurrentReads = 8
+
+// 多个queue/group的数据按相同的时间点对齐, 没有数据的时间点为null
+type metricsSeries struct {
+	Queue  string     `json:"queue"`
+	Group  string     `json:"group"`
+	Values []*float64 `json:"values"`
+	Error  string     `json:"error,omitempty"`
+}
+
+type alignedData struct {
+	Step       int64            `json:"step"`
+	TimeStamps []int64          `json:"timestamps"`
+	Series     []*metricsSeries `json:"series"`
+	Total      []*float64       `json:"total"`
+}
+
+func (a *alignedData) String() string {
+	data, _ := json.Marshal(a)
+	return string(data)
+}
+
+// 一次查询多个queue/group的同一个指标, 各参数除Queue和Group外应该相同.
+// average为true时合计为平均值(耗时), 否则为总和(qps). 单个queue/group查询失败不影响其他的结果
+func readSeries(params []*QueryParam, average bool) (*alignedData, error) {
+
+	reg.mu.RLock()
+	reader := reg.reader
+	reg.mu.RUnlock()
+	if reader == nil {
+		return nil, errInvalidReader
+	}
+
+	if len(params) == 0 {
+		return nil, errInvalidParam
+	}
+	for _, param := range params {
+		if err := param.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	datas := make([]*metricsData, len(params))
+	errs := make([]error, len(params))
+	sem := make(chan struct{}, maxConcurrentReads)
+	var wg sync.WaitGroup
+	for i, param := range params {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, param *QueryParam) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			data, err := reader.Read(param)
+			if err == nil {
+				datas[i], err = parseMetricsData(data)
+			}
+			errs[i] = err
+		}(i, param)
+	}
+	wg.Wait()
+
+	first := params[0]
+	aligned := alignSeries(datas, first.StartTime, first.EndTime, first.Step, average)
+	for i, param := range params {
+		aligned.Series[i].Queue, aligned.Series[i].Group = param.Queue, param.Group
+		if errs[i] != nil {
+			aligned.Series[i].Error = errs[i].Error()
+		}
+	}
+	return aligned, nil
+}
+
+// 按host查询graphite时返回的是每个实例的数据, 合并为一个
+func parseMetricsData(data string) (*metricsData, error) {
+	if strings.HasPrefix(strings.TrimSpace(data), "[") {
+		var datasets dataSets
+		if err := json.Unmarshal([]byte(data), &datasets); err != nil {
+			return nil, err
+		}
+		return mergeDataSet(datasets), nil
+	}
+	m := new(metricsData)
+	if err := json.Unmarshal([]byte(data), m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// 把每个数据点归入所在的step窗口, 同一窗口的多个点取平均值. datas中为nil的表示查询失败
+func alignSeries(datas []*metricsData, start, end, step int64, average bool) *alignedData {
+	if step <= 0 {
+		step = 1
+	}
+	windows := make([]map[int64]float64, len(datas))
+	seen := make(map[int64]bool)
+	for i, data := range datas {
+		if data == nil {
+			continue
+		}
+		sums := make(map[int64]float64)
+		counts := make(map[int64]int)
+		for _, p := range data.Points {
+			if p.TimeStamp < start || p.TimeStamp > end {
+				continue
+			}
+			t := p.TimeStamp / step * step
+			sums[t] += p.Value
+			counts[t]++
+			seen[t] = true
+		}
+		for t := range sums {
+			sums[t] /= float64(counts[t])
+		}
+		windows[i] = sums
+	}
+
+	aligned := &alignedData{Step: step, TimeStamps: make([]int64, 0, len(seen))}
+	for t := range seen {
+		aligned.TimeStamps = append(aligned.TimeStamps, t)
+	}
+	sort.Slice(aligned.TimeStamps, func(i, j int) bool { return aligned.TimeStamps[i] < aligned.TimeStamps[j] })
+
+	totals := make([]float64, len(aligned.TimeStamps))
+	counts := make([]int, len(aligned.TimeStamps))
+	for _, w := range windows {
+		series := &metricsSeries{Values: make([]*float64, len(aligned.TimeStamps))}
+		for j, t := range aligned.TimeStamps {
+			if v, ok := w[t]; ok {
+				value := v
+				series.Values[j] = &value
+				totals[j] += v
+				counts[j]++
+			}
+		}
+		aligned.Series = append(aligned.Series, series)
+	}
+	aligned.Total = make([]*float64, len(aligned.TimeStamps))
+	for j := range totals {
+		if counts[j] == 0 {
+			continue
+		}
+		total := totals[j]
+		if average {
+			total /= float64(counts[j])
+		}
+		aligned.Total[j] = &total
+	}
+	return aligned
+}
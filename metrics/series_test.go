@@ -0,0 +1,75 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMetricsData(t *testing.T) {
+	data, err := parseMetricsData(`{"points":[{"t":10,"v":1}]}`)
+	if err != nil || len(data.Points) != 1 {
+		t.Errorf("parse data error: %+v %v", data, err)
+	}
+	data, err = parseMetricsData(`[{"points":[{"t":10,"v":1}]},{"points":[{"t":10,"v":2}]}]`)
+	if err != nil || len(data.Points) != 1 || data.Points[0].Value != 3 {
+		t.Errorf("parse datasets error: %+v %v", data, err)
+	}
+	if _, err = parseMetricsData("bad"); err == nil {
+		t.Errorf("expect error for invalid data")
+	}
+}
+
+func TestAlignSeries(t *testing.T) {
+	values := func(vs []*float64) []interface{} {
+		result := make([]interface{}, len(vs))
+		for i, v := range vs {
+			if v != nil {
+				result[i] = *v
+			}
+		}
+		return result
+	}
+
+	datas := []*metricsData{
+		{Points: []metricsDataPoint{{5, 99}, {10, 1}, {15, 3}, {20, 4}}},
+		{Points: []metricsDataPoint{{20, 6}, {30, 8}}},
+		nil,
+	}
+	aligned := alignSeries(datas, 10, 30, 10, false)
+	if !reflect.DeepEqual(aligned.TimeStamps, []int64{10, 20, 30}) {
+		t.Fatalf("unexpected timestamps %v", aligned.TimeStamps)
+	}
+	if len(aligned.Series) != 3 {
+		t.Fatalf("expect 3 series, got %d", len(aligned.Series))
+	}
+	want := [][]interface{}{{2.0, 4.0, nil}, {nil, 6.0, 8.0}, {nil, nil, nil}}
+	for i, series := range aligned.Series {
+		if got := values(series.Values); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("series %d: got %v, want %v", i, got, want[i])
+		}
+	}
+	if got := values(aligned.Total); !reflect.DeepEqual(got, []interface{}{2.0, 10.0, 8.0}) {
+		t.Errorf("unexpected total %v", got)
+	}
+
+	aligned = alignSeries(datas, 10, 30, 10, true)
+	if got := values(aligned.Total); !reflect.DeepEqual(got, []interface{}{2.0, 5.0, 8.0}) {
+		t.Errorf("unexpected average total %v", got)
+	}
+}
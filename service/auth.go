@@ -0,0 +1,132 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/weibocom/wqs/engine/queue"
+	"github.com/weibocom/wqs/log"
+
+	"github.com/juju/errors"
+)
+
+const (
+	tokenHeader  = "X-Wqs-Token"
+	bearerPrefix = "Bearer "
+)
+
+// 优先使用X-Wqs-Token, 其次是Authorization: Bearer <token>
+func requestToken(r *http.Request) string {
+	if token := r.Header.Get(tokenHeader); token != "" {
+		return token
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, bearerPrefix) {
+		return strings.TrimSpace(auth[len(bearerPrefix):])
+	}
+	// 浏览器建立websocket时不能设置header, 只能通过参数传递token
+	if isWebSocket(r) {
+		return r.URL.Query().Get("token")
+	}
+	return ""
+}
+
+func TokenAuthWarp(h httprouter.Handle, q queue.Queue, role string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+		err := q.Authorize(requestToken(r), role)
+		switch {
+		case err == nil:
+			h(w, r, ps)
+		case errors.IsUnauthorized(err):
+			w.Header().Set("WWW-Authenticate", "Bearer realm=WQS")
+			response(w, 401, err.Error())
+		case errors.Cause(err) == queue.ErrPermissionDenied:
+			log.Warnf("%s %s from %s: need role %s", r.Method, r.URL.Path, r.RemoteAddr, role)
+			response(w, 403, err.Error())
+		default:
+			errorResponse(w, err)
+		}
+	}
+}
+
+// 未开启auth.enable时不做任何检查
+func (s *Server) auth(role string, h httprouter.Handle) httprouter.Handle {
+	if !s.authEnable {
+		return h
+	}
+	return TokenAuthWarp(h, s.queue, role)
+}
+
+// Create a token with given role
+// path "/tokens"
+func (s *Server) createTokenHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	attr := &TokenAttr{}
+	if err := json.NewDecoder(r.Body).Decode(attr); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	info, err := s.queue.CreateToken(attr.Role)
+	if err != nil {
+		if errors.IsNotValid(err) {
+			response(w, 400, err.Error())
+			return
+		}
+		errorResponse(w, err)
+		return
+	}
+	response(w, 201, info.String())
+}
+
+// Get all tokens
+// path "/tokens"
+func (s *Server) getTokensHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	tokens, err := s.queue.Tokens()
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+
+	buff := &bytes.Buffer{}
+	if err := json.NewEncoder(buff).Encode(tokens); err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, buff.String())
+}
+
+// Delete a token
+// path "/tokens/:token"
+func (s *Server) deleteTokenHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	if err := s.queue.DeleteToken(ps.ByName("token")); err != nil {
+		if errors.IsNotFound(err) {
+			response(w, 404, err.Error())
+			return
+		}
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, "OK")
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/julienschmidt/httprouter"
+	"github.com/weibocom/wqs/engine/queue"
+)
+
+type authQueue struct {
+	queue.Queue
+	roles map[string]string
+}
+
+func (q *authQueue) Authorize(token string, role string) error {
+	r, ok := q.roles[token]
+	if !ok {
+		return errors.Unauthorizedf("invalid token")
+	}
+	info := &queue.TokenInfo{Token: token, Role: r}
+	if !info.HasRole(role) {
+		return queue.ErrPermissionDenied
+	}
+	return nil
+}
+
+func TestTokenAuthWarp(t *testing.T) {
+
+	q := &authQueue{roles: map[string]string{"a": queue.RoleAdmin, "c": queue.RoleClient}}
+	ok := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		response(w, 200, "OK")
+	}
+	router := NewRouter()
+	router.POST("/queue", TokenAuthWarp(ok, q, queue.RoleAdmin))
+	router.POST("/msg", TokenAuthWarp(ok, q, queue.RoleClient))
+
+	cases := []struct {
+		path   string
+		header string
+		value  string
+		code   int
+	}{
+		{"/queue", "", "", 401},
+		{"/queue", tokenHeader, "x", 401},
+		{"/queue", tokenHeader, "c", 403},
+		{"/queue", tokenHeader, "a", 200},
+		{"/msg", "Authorization", "Bearer c", 200},
+		{"/msg", "Authorization", "Bearer a", 200},
+		{"/msg", "Authorization", "Basic c", 401},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "http://example.com"+c.path, nil)
+		if err != nil {
+			t.Fatalf("unexpect error : %v", err)
+		}
+		if c.header != "" {
+			req.Header.Set(c.header, c.value)
+		}
+		router.ServeHTTP(w, req)
+		if w.Code != c.code {
+			t.Errorf("%s with %s %q: want %d, now %d", c.path, c.header, c.value, c.code, w.Code)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/weibocom/wqs/engine/queue"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/juju/errors"
+)
+
+const (
+	headerRetryAfter   = "Retry-After"
+	headerRetryAfterMs = "X-Retry-After-Ms"
+	// 连接数超限时建议的重试间隔
+	overloadRetryAfter = time.Second
+)
+
+// 限流和熔断的错误中带有根据当前令牌桶和熔断器状态计算的重试间隔
+func retryAfter(err error) (time.Duration, bool) {
+	switch e := errors.Cause(err).(type) {
+	case *queue.ThrottledError:
+		return e.RetryAfter, true
+	case *queue.CircuitOpenError:
+		return e.RetryAfter, true
+	}
+	return 0, false
+}
+
+// Retry-After只能精确到秒, 向上取整, 毫秒数通过X-Retry-After-Ms返回
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	ms := int64((d + time.Millisecond - 1) / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	w.Header().Set(headerRetryAfter, strconv.FormatInt((ms+999)/1000, 10))
+	w.Header().Set(headerRetryAfterMs, strconv.FormatInt(ms, 10))
+}
+
+// 请求因限流或熔断被拒绝时设置Retry-After并计数, 返回对应的状态码(429或503), 其他错误返回0.
+// 必须在写出状态码之前调用
+func backpressure(w http.ResponseWriter, err error) int {
+	d, ok := retryAfter(err)
+	if !ok {
+		return 0
+	}
+	setRetryAfter(w, d)
+	reason := metrics.Throttled
+	if isCircuitOpen(err) {
+		reason = metrics.Breaker
+	}
+	metrics.AddCounter(metrics.Shed+"."+reason, 1)
+	return queue.ErrorCodeOf(err).Status()
+}
+
+// 连接数超过http.max.conns时拒绝的请求
+func overload(w http.ResponseWriter) {
+	setRetryAfter(w, overloadRetryAfter)
+	metrics.AddCounter(metrics.Shed+"."+metrics.Overload, 1)
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/engine/queue"
+
+	"github.com/juju/errors"
+)
+
+func TestBackpressure(t *testing.T) {
+	for _, c := range []struct {
+		err    error
+		status int
+		after  string
+		ms     string
+	}{
+		{errors.Trace(&queue.ThrottledError{Target: "q1", RetryAfter: 250 * time.Millisecond}), 429, "1", "250"},
+		{&queue.ThrottledError{Target: "q1"}, 429, "1", "1"},
+		{&queue.CircuitOpenError{Target: "SET", RetryAfter: 2500 * time.Millisecond}, 503, "3", "2500"},
+		{&queue.QuotaExceededError{Queue: "q1"}, 0, "", ""},
+		{errors.New("unknown"), 0, "", ""},
+	} {
+		w := httptest.NewRecorder()
+		status := backpressure(w, c.err)
+		if status != c.status || w.Header().Get(headerRetryAfter) != c.after || w.Header().Get(headerRetryAfterMs) != c.ms {
+			t.Errorf("%v: expect %d %q %q, got %d %v", c.err, c.status, c.after, c.ms, status, w.Header())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	errorResponse(w, &queue.ThrottledError{Target: "q1", RetryAfter: 1500 * time.Millisecond})
+	if w.Code != 429 || w.Header().Get(headerRetryAfter) != "2" {
+		t.Errorf("expect 429 with Retry-After, got %d %v", w.Code, w.Header())
+	}
+}
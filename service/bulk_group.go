@@ -0,0 +1,72 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/weibocom/wqs/engine/queue"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// 请求体为group配置的列表, 全部成功或全部失败, 返回每个group是新建还是修改
+// router.POST("/groups", s.auth(admin, s.applyGroupsHandler))
+func (s *Server) applyGroupsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	configs := make([]queue.GroupConfig, 0)
+	if err := json.NewDecoder(r.Body).Decode(&configs); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+	changes, err := s.queue.ApplyGroups(configs)
+	groupChangesResponse(w, changes, err)
+}
+
+// router.POST("/groups/:group/queues", s.auth(admin, s.applyGroupToQueuesHandler))
+func (s *Server) applyGroupToQueuesHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	attr := &GroupQueuesAttr{}
+	if err := json.NewDecoder(r.Body).Decode(attr); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+	config := queue.GroupConfig{
+		Group: ps.ByName("group"),
+		Write: attr.Write,
+		Read:  attr.Read,
+		Url:   attr.Url,
+		Ips:   attr.Ips,
+		Start: attr.Start,
+	}
+	changes, err := s.queue.ApplyGroupToQueues(config, attr.Queues)
+	groupChangesResponse(w, changes, err)
+}
+
+func groupChangesResponse(w http.ResponseWriter, changes []queue.GroupChange, err error) {
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(changes)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
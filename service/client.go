@@ -0,0 +1,87 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/weibocom/wqs/engine/queue"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/juju/errors"
+)
+
+const clientHeader = "X-Wqs-Client"
+
+// 优先使用X-Wqs-Client, 浏览器和旧客户端可以通过参数client传递
+func requestClient(r *http.Request) string {
+	if client := r.Header.Get(clientHeader); client != "" {
+		return client
+	}
+	return r.URL.Query().Get("client")
+}
+
+func withClient(ctx context.Context, client string) context.Context {
+	if client == "" {
+		return ctx
+	}
+	return queue.WithClient(ctx, client)
+}
+
+// 校验客户端标识并放入请求的context, 开启client.required时拒绝没有标识的请求
+func (s *Server) identify(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		client := requestClient(r)
+		switch {
+		case client != "" && !queue.ValidClient(client):
+			errorResponse(w, errors.NotValidf("client %q", client))
+		case client == "" && s.clientRequired:
+			errorResponse(w, errors.NewNotValid(nil, "client is required, set header "+clientHeader))
+		default:
+			h(w, r.WithContext(withClient(r.Context(), client)), ps)
+		}
+	}
+}
+
+// 查询某个客户端的监控数据, 按客户端只统计了qps
+func clientMetricsKey(client string, typ string) (string, error) {
+	if client == "" {
+		return typ, nil
+	}
+	if !queue.ValidClient(client) {
+		return "", errors.NotValidf("client %q", client)
+	}
+	if typ != metrics.Qps {
+		return "", errors.NotSupportedf("type %s of client", typ)
+	}
+	return metrics.Client + "." + client + "." + typ, nil
+}
+
+// router.PUT("/queue/:queue/:group/clients/:client/limit", s.setClientLimitHandler)
+func (s *Server) setClientLimitHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	limit := queue.RateLimit{}
+	if err := json.NewDecoder(r.Body).Decode(&limit); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	configResponse(w, s.queue.SetClientLimit(ps.ByName("group"), ps.ByName("queue"), ps.ByName("client"), limit))
+}
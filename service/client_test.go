@@ -0,0 +1,77 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestIdentify(t *testing.T) {
+	s := &Server{}
+	var called bool
+	h := s.identify(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		called = true
+		response(w, http.StatusOK, "ok")
+	})
+
+	do := func(url string, client string) int {
+		called = false
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", url, nil)
+		if client != "" {
+			req.Header.Set(clientHeader, client)
+		}
+		h(w, req, nil)
+		return w.Code
+	}
+
+	if code := do("http://example.com/msg", ""); code != http.StatusOK || !called {
+		t.Errorf("request without client should pass, got %d", code)
+	}
+	if code := do("http://example.com/msg", "order-service"); code != http.StatusOK || !called {
+		t.Errorf("request with client should pass, got %d", code)
+	}
+	if code := do("http://example.com/msg?client=a.b", ""); code != http.StatusBadRequest || called {
+		t.Errorf("invalid client should be rejected, got %d", code)
+	}
+
+	s.clientRequired = true
+	if code := do("http://example.com/msg", ""); code != http.StatusBadRequest || called {
+		t.Errorf("expect 400 without client, got %d", code)
+	}
+	if code := do("http://example.com/msg?client=order-service", ""); code != http.StatusOK || !called {
+		t.Errorf("client in query should pass, got %d", code)
+	}
+}
+
+func TestClientMetricsKey(t *testing.T) {
+	if key, err := clientMetricsKey("", "elapsed"); err != nil || key != "elapsed" {
+		t.Errorf("expect elapsed, got %q %v", key, err)
+	}
+	if key, err := clientMetricsKey("order-service", "qps"); err != nil || key != "Client.order-service.qps" {
+		t.Errorf("expect client key, got %q %v", key, err)
+	}
+	for _, c := range [][2]string{{"a.b", "qps"}, {"order-service", "elapsed"}} {
+		if _, err := clientMetricsKey(c[0], c[1]); err == nil {
+			t.Errorf("expect error for %v", c)
+		}
+	}
+}
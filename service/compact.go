@@ -0,0 +1,73 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// compact queue中各个key的最新值, 需要读取本机房topic的全部消息
+// router.GET("/queues/:queue/values", s.auth(client, s.getValuesHandler))
+func (s *Server) getValuesHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	values, err := s.queue.GetValues(ps.ByName("queue"))
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
+// router.GET("/queues/:queue/values/:key", s.auth(client, s.getValueHandler))
+func (s *Server) getValueHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	value, err := s.queue.GetValue(ps.ByName("queue"), ps.ByName("key"))
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
+// 删除标记通过group发送, 需要group参数
+// router.DELETE("/queues/:queue/values/:key", s.auth(client, s.deleteValueHandler))
+func (s *Server) deleteValueHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	group := r.URL.Query().Get("group")
+	if group == "" {
+		response(w, 400, "group is required")
+		return
+	}
+	if err := s.queue.DeleteValue(r.Context(), ps.ByName("queue"), group, ps.ByName("key")); err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, "OK")
+}
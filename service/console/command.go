@@ -0,0 +1,207 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package console
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/weibocom/wqs/engine/queue"
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/metrics"
+)
+
+var (
+	errBadArgs     = errors.New("bad arguments")
+	errUnknownFlag = errors.New("unknown flag")
+)
+
+type consoleCommand struct {
+	usage string
+	exec  func(q queue.Queue, args []string, w io.Writer) error
+}
+
+var commands = make(map[string]*consoleCommand)
+
+func registerCommand(name string, usage string, exec func(q queue.Queue, args []string, w io.Writer) error) {
+	if _, exists := commands[name]; exists {
+		panic(fmt.Errorf("command duplicate %q", name))
+	}
+	commands[name] = &consoleCommand{usage: usage, exec: exec}
+}
+
+func init() {
+	registerCommand("help", "help", commandHelp)
+	registerCommand("lag", "lag [queue [group]]", commandLag)
+	registerCommand("consumers", "consumers", commandConsumers)
+	registerCommand("peek", "peek <queue> <group> [count]", commandPeek)
+	registerCommand("sample", "sample <queue> <group>", commandSample)
+	registerCommand("kafka", "kafka", commandKafka)
+	registerCommand("toggle-flag", "toggle-flag <debug|profile>", commandToggleFlag)
+}
+
+func commandHelp(q queue.Queue, args []string, w io.Writer) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintf(w, "  %s <password>\n  %s\n", cmdAuth, cmdQuit)
+	return nil
+}
+
+// 输出各个group的堆积情况, 格式与mc的stats queue一致
+func commandLag(q queue.Queue, args []string, w io.Writer) error {
+	if len(args) > 2 {
+		return errBadArgs
+	}
+	infos, err := q.AccumulationStatus()
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if len(args) > 0 && args[0] != info.Queue {
+			continue
+		}
+		if len(args) > 1 && args[1] != info.Group {
+			continue
+		}
+		fmt.Fprintf(w, "%s.%s total %d consumed %d lag %d\n",
+			info.Queue, info.Group, info.Total, info.Consumed, info.Total-info.Consumed)
+	}
+	return nil
+}
+
+func commandConsumers(q queue.Queue, args []string, w io.Writer) error {
+	for _, owner := range q.Consumers() {
+		fmt.Fprintln(w, owner)
+	}
+	return nil
+}
+
+// peek不会改变group的消费位置, 默认读取一条
+func commandPeek(q queue.Queue, args []string, w io.Writer) error {
+	if len(args) != 2 && len(args) != 3 {
+		return errBadArgs
+	}
+	count := 1
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil {
+			return errBadArgs
+		}
+		count = n
+	}
+	msgs, err := q.PeekMessage(args[0], args[1], count)
+	if err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		fmt.Fprintf(w, "id %s flag %d len %d\n%s\n", msg.ID, msg.Flag, len(msg.Data), msg.Data)
+	}
+	return nil
+}
+
+// 输出本proxy上该group当前的统计数据
+func commandSample(q queue.Queue, args []string, w io.Writer) error {
+	if len(args) != 2 {
+		return errBadArgs
+	}
+	if _, err := q.GetSingleGroup(args[1], args[0]); err != nil {
+		return err
+	}
+	prefix := args[0] + "." + args[1] + "."
+	for _, action := range []string{metrics.CmdSet, metrics.CmdGet, metrics.CmdAck} {
+		key := prefix + action + "."
+		fmt.Fprintf(w, "%s ops %d qps %.2f\n", action,
+			metrics.GetCounter(key+metrics.Ops), metrics.GetMeterRate(key+metrics.Qps))
+	}
+	fmt.Fprintf(w, "%s latency %.2f\n", metrics.CmdGet,
+		metrics.GetTimerMean(prefix+metrics.CmdGet+"."+metrics.Latency))
+	return nil
+}
+
+// 输出各个idc的kafka集群状态, 只列出有问题的broker和partition
+func commandKafka(q queue.Queue, args []string, w io.Writer) error {
+	if len(args) != 0 {
+		return errBadArgs
+	}
+	for _, status := range q.KafkaStatus() {
+		if status.ClusterStatus == nil {
+			fmt.Fprintf(w, "idc %s error %s\n", status.Idc, status.Error)
+			continue
+		}
+		alive := 0
+		for _, b := range status.Brokers {
+			if b.Alive {
+				alive++
+			}
+		}
+		fmt.Fprintf(w, "idc %s controller %d brokers %d alive %d partitions %d under-replicated %d offline %d\n",
+			status.Idc, status.Controller, len(status.Brokers), alive, status.Partitions,
+			len(status.UnderReplicated), len(status.Offline))
+		for _, b := range status.Brokers {
+			if !b.Alive {
+				fmt.Fprintf(w, "  broker %d %s down\n", b.ID, b.Addr)
+			}
+		}
+		for _, p := range status.UnderReplicated {
+			fmt.Fprintf(w, "  under-replicated %s-%d leader %d replicas %v isr %v\n",
+				p.Topic, p.Partition, p.Leader, p.Replicas, p.Isr)
+		}
+		for _, p := range status.Offline {
+			fmt.Fprintf(w, "  offline %s-%d replicas %v isr %v\n", p.Topic, p.Partition, p.Replicas, p.Isr)
+		}
+		for _, topic := range status.Missing {
+			fmt.Fprintf(w, "  missing %s\n", topic)
+		}
+	}
+	return nil
+}
+
+// 与POST /loggers/:name作用相同, 在打开与关闭之间切换
+func commandToggleFlag(q queue.Queue, args []string, w io.Writer) error {
+	if len(args) != 1 {
+		return errBadArgs
+	}
+
+	var logger *log.Logger
+	var open, close uint32
+	switch args[0] {
+	case "debug":
+		logger, open, close = log.GetLogger(log.LogDebug), log.LogDebug, log.LogError
+	case "profile":
+		logger, open, close = log.ProfileGetLogger(), log.LogInfo, log.LogError
+	default:
+		return errUnknownFlag
+	}
+
+	if logger.GetLevel() < open {
+		logger.SetLogLevel(open)
+		fmt.Fprintf(w, "%s on\n", args[0])
+	} else {
+		logger.SetLogLevel(close)
+		fmt.Fprintf(w, "%s off\n", args[0])
+	}
+	return nil
+}
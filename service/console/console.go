@@ -0,0 +1,195 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// 提供一个基于文本行的调试控制台, 可以直接使用nc/telnet连接, 用于排查线上问题.
+package console
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/juju/errors"
+	"github.com/weibocom/wqs/engine/queue"
+	"github.com/weibocom/wqs/log"
+)
+
+const (
+	prompt     = "wqs> "
+	cmdAuth    = "auth"
+	cmdQuit    = "quit"
+	respOK     = "OK"
+	respDenied = "ERROR authentication required"
+)
+
+type Server struct {
+	addr     string
+	password string
+	queue    queue.Queue
+	listener net.Listener
+	stopping int32
+	connPool map[net.Conn]net.Conn
+	mu       sync.Mutex
+}
+
+// password为空时不进行认证, 此时只允许监听本机地址
+func NewServer(q queue.Queue, addr string, password string) *Server {
+	return &Server{
+		addr:     addr,
+		password: password,
+		queue:    q,
+		connPool: make(map[net.Conn]net.Conn),
+	}
+}
+
+func (s *Server) Start() error {
+	if s.password == "" && !isLoopback(s.addr) {
+		return errors.NotValidf("console.addr %q without console.password, listen on a loopback address or set a password", s.addr)
+	}
+
+	var err error
+	// 不使用utils.Listener, 避免控制台连接计入memcached的连接统计
+	s.listener, err = net.Listen("tcp", s.addr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	log.Infof("debug console start on %s", s.addr)
+	go s.mainLoop()
+	return nil
+}
+
+func (s *Server) mainLoop() {
+	for atomic.LoadInt32(&s.stopping) == 0 {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			log.Errorf("console accept error: %s", err)
+			continue
+		}
+		if atomic.LoadInt32(&s.stopping) != 0 {
+			conn.Close()
+			return
+		}
+		log.Infof("console new client: %s", conn.RemoteAddr())
+		s.mu.Lock()
+		s.connPool[conn] = conn
+		s.mu.Unlock()
+		go s.connLoop(conn)
+	}
+}
+
+func (s *Server) connLoop(conn net.Conn) {
+	defer func(conn net.Conn) {
+		log.Infof("console client closed: %s", conn.RemoteAddr())
+		s.mu.Lock()
+		delete(s.connPool, conn)
+		s.mu.Unlock()
+		conn.Close()
+		if err := recover(); err != nil {
+			log.Errorf("console connLoop panic error: %s", err)
+		}
+	}(conn)
+
+	s.serve(bufio.NewReader(conn), bufio.NewWriter(conn), conn.RemoteAddr().String())
+}
+
+func (s *Server) serve(r *bufio.Reader, w *bufio.Writer, client string) {
+
+	authed := s.password == ""
+	for atomic.LoadInt32(&s.stopping) == 0 {
+		w.WriteString(prompt)
+		w.Flush()
+
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				log.Warnf("console read line err: %s", err)
+			}
+			return
+		}
+
+		tokens := strings.Fields(line)
+		if len(tokens) == 0 {
+			continue
+		}
+
+		switch {
+		case tokens[0] == cmdQuit:
+			return
+		case tokens[0] == cmdAuth:
+			if len(tokens) == 2 && subtle.ConstantTimeCompare([]byte(tokens[1]), []byte(s.password)) == 1 {
+				authed = true
+				fmt.Fprintln(w, respOK)
+			} else {
+				log.Warnf("console client %s auth failed", client)
+				fmt.Fprintln(w, respDenied)
+			}
+			continue
+		case !authed:
+			fmt.Fprintln(w, respDenied)
+			continue
+		}
+
+		log.Infof("console client %s exec: %s", client, strings.Join(tokens, " "))
+		command, ok := commands[tokens[0]]
+		if !ok {
+			fmt.Fprintf(w, "ERROR unknown command %q, try \"help\"\n", tokens[0])
+			continue
+		}
+		if err := command.exec(s.queue, tokens[1:], w); err != nil {
+			fmt.Fprintf(w, "ERROR %s\n", err)
+		}
+	}
+}
+
+// 监听地址是否只能从本机访问, host为空时监听所有地址
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// close all connections of console.
+func (s *Server) DrainConn() {
+	s.mu.Lock()
+	for _, conn := range s.connPool {
+		conn.Close()
+	}
+	s.mu.Unlock()
+}
+
+func (s *Server) Stop() {
+	if !atomic.CompareAndSwapInt32(&s.stopping, 0, 1) {
+		return
+	}
+	if err := s.listener.Close(); err != nil {
+		log.Errorf("console listener close failed: %s", err)
+		return
+	}
+	s.DrainConn()
+	log.Info("debug console stop.")
+}
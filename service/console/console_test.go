@@ -0,0 +1,157 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package console
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/engine/queue"
+)
+
+type fakeQueue struct {
+	queue.Queue
+}
+
+func (q *fakeQueue) AccumulationStatus() ([]queue.AccumulationInfo, error) {
+	return []queue.AccumulationInfo{
+		{Queue: "q1", Group: "g1", Total: 100, Consumed: 60},
+		{Queue: "q2", Group: "g2", Total: 10, Consumed: 10},
+	}, nil
+}
+
+func (q *fakeQueue) Consumers() []string {
+	return []string{"q1@g1"}
+}
+
+func (q *fakeQueue) PeekMessage(name string, group string, count int) ([]*queue.MessageInfo, error) {
+	msgs := []*queue.MessageInfo{
+		{ID: "id-1", Flag: 0, Data: "hello"},
+		{ID: "id-2", Flag: 1, Data: "world"},
+	}
+	if count < len(msgs) {
+		msgs = msgs[:count]
+	}
+	return msgs, nil
+}
+
+func (q *fakeQueue) KafkaStatus() []*queue.KafkaStatus {
+	return []*queue.KafkaStatus{
+		{Idc: "bj", ClusterStatus: &kafka.ClusterStatus{
+			Controller: 1,
+			Brokers: []*kafka.BrokerStatus{
+				{ID: 1, Addr: "k1:9092", Registered: true, Alive: true, Controller: true},
+				{ID: 2, Addr: "k2:9092", Registered: true},
+			},
+			Partitions: 4,
+			UnderReplicated: []*kafka.PartitionStatus{
+				{Topic: "q1", Partition: 1, Leader: 1, Replicas: []int32{1, 2}, Isr: []int32{1}},
+			},
+		}},
+		{Idc: "tc", Error: "zk: connection closed"},
+	}
+}
+
+func runConsole(password string, input string) string {
+	s := NewServer(&fakeQueue{}, "", password)
+	out := &bytes.Buffer{}
+	w := bufio.NewWriter(out)
+	s.serve(bufio.NewReader(strings.NewReader(input)), w, "test")
+	w.Flush()
+	return out.String()
+}
+
+func TestConsoleAuth(t *testing.T) {
+	out := runConsole("secret", "lag\nauth wrong\nauth secret\nconsumers\n")
+	if strings.Count(out, respDenied) != 2 {
+		t.Errorf("expect 2 denied responses, got %q", out)
+	}
+	if !strings.Contains(out, "q1@g1") {
+		t.Errorf("expect consumers after auth, got %q", out)
+	}
+}
+
+func TestConsoleStartWithoutPassword(t *testing.T) {
+	for addr, loopback := range map[string]bool{
+		"127.0.0.1:0": true,
+		"[::1]:8090":  true,
+		"localhost:0": true,
+		":8090":       false,
+		"0.0.0.0:0":   false,
+		"10.0.0.1:0":  false,
+	} {
+		if isLoopback(addr) != loopback {
+			t.Errorf("isLoopback(%q) should be %v", addr, loopback)
+		}
+	}
+
+	if err := NewServer(&fakeQueue{}, ":0", "").Start(); !errors.IsNotValid(err) {
+		t.Errorf("expect not valid without password on all addresses, got %v", err)
+	}
+	s := NewServer(&fakeQueue{}, "127.0.0.1:0", "")
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+}
+
+func TestConsoleCommands(t *testing.T) {
+	out := runConsole("", "lag q1\nnoexist\npeek q1\nquit\nconsumers\n")
+	if !strings.Contains(out, "q1.g1 total 100 consumed 60 lag 40") {
+		t.Errorf("lag output error: %q", out)
+	}
+	if strings.Contains(out, "q2.g2") {
+		t.Errorf("lag should filter by queue: %q", out)
+	}
+	if !strings.Contains(out, `unknown command "noexist"`) {
+		t.Errorf("unknown command output error: %q", out)
+	}
+	if !strings.Contains(out, errBadArgs.Error()) {
+		t.Errorf("peek without group should fail: %q", out)
+	}
+	if strings.Contains(out, "q1@g1") {
+		t.Errorf("commands after quit should not run: %q", out)
+	}
+}
+
+func TestConsolePeek(t *testing.T) {
+	out := runConsole("", "peek q1 g1\npeek q1 g1 2\npeek q1 g1 x\n")
+	if strings.Count(out, "id id-1 flag 0 len 5\nhello") != 2 || strings.Count(out, "id id-2 flag 1 len 5\nworld") != 1 {
+		t.Errorf("peek output error: %q", out)
+	}
+	if !strings.Contains(out, errBadArgs.Error()) {
+		t.Errorf("peek with bad count should fail: %q", out)
+	}
+}
+
+func TestConsoleKafka(t *testing.T) {
+	out := runConsole("", "kafka\n")
+	for _, line := range []string{
+		"idc bj controller 1 brokers 2 alive 1 partitions 4 under-replicated 1 offline 0\n",
+		"  broker 2 k2:9092 down\n",
+		"  under-replicated q1-1 leader 1 replicas [1 2] isr [1]\n",
+		"idc tc error zk: connection closed\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("kafka output missing %q: %q", line, out)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/weibocom/wqs/config"
+)
+
+const (
+	defaultCORSMethods = "GET,POST,PUT,DELETE"
+	defaultCORSHeaders = "Content-Type,Authorization," + tokenHeader + "," + clientHeader + "," + HeaderRequestID
+	defaultCORSMaxAge  = 600
+)
+
+// 允许浏览器中的页面跨域调用HTTP接口
+type corsPolicy struct {
+	// *允许所有来源, *.example.com允许example.com的所有子域名
+	origins     []string
+	methods     string
+	headers     string
+	maxAge      string
+	credentials bool
+}
+
+// 未配置cors.origins时返回nil, 不处理跨域请求
+func loadCORSPolicy(conf *config.Config) *corsPolicy {
+	section, err := conf.GetSection("cors")
+	if err != nil {
+		return nil
+	}
+	origins := splitList(section.GetStringMust("origins", ""))
+	if len(origins) == 0 {
+		return nil
+	}
+	return &corsPolicy{
+		origins:     origins,
+		methods:     strings.Join(splitList(section.GetStringMust("methods", defaultCORSMethods)), ", "),
+		headers:     strings.Join(splitList(section.GetStringMust("headers", defaultCORSHeaders)), ", "),
+		maxAge:      strconv.FormatInt(section.GetInt64Must("max.age", defaultCORSMaxAge), 10),
+		credentials: section.GetBoolMust("credentials", false),
+	}
+}
+
+func splitList(value string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func (p *corsPolicy) allow(origin string) bool {
+	for _, allowed := range p.origins {
+		switch {
+		case allowed == "*", strings.EqualFold(allowed, origin):
+			return true
+		case strings.HasPrefix(allowed, "*."):
+			// 只匹配子域名, scheme和端口不限
+			host := origin
+			if i := strings.Index(host, "://"); i >= 0 {
+				host = host[i+3:]
+			}
+			if i := strings.LastIndex(host, ":"); i >= 0 {
+				host = host[:i]
+			}
+			if strings.HasSuffix(strings.ToLower(host), strings.ToLower(allowed[1:])) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 设置跨域响应头, 预检请求直接返回204并返回true. 来源不允许时不设置响应头, 由浏览器拒绝
+func (p *corsPolicy) handle(w http.ResponseWriter, req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	preflight := req.Method == "OPTIONS" && req.Header.Get("Access-Control-Request-Method") != ""
+	if !p.allow(origin) {
+		if preflight {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		return preflight
+	}
+
+	header.Set("Access-Control-Allow-Origin", origin)
+	if p.credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		header.Set("Access-Control-Expose-Headers", HeaderRequestID+", "+totalCountHeader)
+		return false
+	}
+	header.Set("Access-Control-Allow-Methods", p.methods)
+	header.Set("Access-Control-Allow-Headers", p.headers)
+	header.Set("Access-Control-Max-Age", p.maxAge)
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
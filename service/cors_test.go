@@ -0,0 +1,90 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestLoadCORSPolicy(t *testing.T) {
+	conf, _ := config.NewConfigFromBytes([]byte(testReloadConfig))
+	if p := loadCORSPolicy(conf); p != nil {
+		t.Errorf("expect nil policy without cors.origins, got %+v", p)
+	}
+	conf, _ = config.NewConfigFromBytes([]byte(testReloadConfig + "cors.origins=http://a.com, *.example.com\ncors.methods=GET\n"))
+	p := loadCORSPolicy(conf)
+	if p == nil || len(p.origins) != 2 || p.methods != "GET" || p.maxAge != "600" {
+		t.Fatalf("unexpected policy %+v", p)
+	}
+	for origin, allowed := range map[string]bool{
+		"http://a.com":                  true,
+		"http://A.com":                  true,
+		"https://a.com":                 false,
+		"https://web.example.com:8443":  true,
+		"http://a.b.example.com":        true,
+		"http://example.com.attack.com": false,
+		"http://notexample.com":         false,
+	} {
+		if p.allow(origin) != allowed {
+			t.Errorf("origin %s expect allowed %v", origin, allowed)
+		}
+	}
+}
+
+func TestCORSHandle(t *testing.T) {
+	router := NewRouter()
+	router.cors = &corsPolicy{origins: []string{"http://dashboard"}, methods: "GET, POST", headers: "X-Wqs-Token", maxAge: "60"}
+	router.GET("/queue", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		response(w, http.StatusOK, "ok")
+	})
+
+	do := func(method string, origin string, preflight bool) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "http://example.com/queue", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if preflight {
+			req.Header.Set("Access-Control-Request-Method", "GET")
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("OPTIONS", "http://dashboard", true)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "http://dashboard" ||
+		w.Header().Get("Access-Control-Allow-Methods") != "GET, POST" || w.Header().Get("Access-Control-Max-Age") != "60" {
+		t.Errorf("unexpected preflight response %d %v", w.Code, w.Header())
+	}
+	if w = do("OPTIONS", "http://other", true); w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expect 403 for preflight from other origin, got %d %v", w.Code, w.Header())
+	}
+	w = do("GET", "http://dashboard", false)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "http://dashboard" ||
+		w.Header().Get("Access-Control-Expose-Headers") == "" {
+		t.Errorf("unexpected response %d %v", w.Code, w.Header())
+	}
+	if w = do("GET", "", false); w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expect no cors header for same origin request, got %v", w.Header())
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/weibocom/wqs/engine/queue"
+)
+
+type gcStats struct {
+	NumGC        uint32 `json:"num_gc"`
+	PauseTotalNs uint64 `json:"pause_total_ns"`
+	LastPauseNs  uint64 `json:"last_pause_ns"`
+	NextGC       uint64 `json:"next_gc"`
+}
+
+type heapStats struct {
+	Alloc    uint64 `json:"alloc"`
+	Sys      uint64 `json:"sys"`
+	Inuse    uint64 `json:"inuse"`
+	Idle     uint64 `json:"idle"`
+	Objects  uint64 `json:"objects"`
+	Released uint64 `json:"released"`
+}
+
+type runtimeStats struct {
+	Uptime     int64     `json:"uptime"`
+	Goroutines int       `json:"goroutines"`
+	NumCPU     int       `json:"num_cpu"`
+	GC         gcStats   `json:"gc"`
+	Heap       heapStats `json:"heap"`
+	// 本proxy上正在运行的consumer, 即consumerMap的大小
+	Consumers int `json:"consumers"`
+	// 已经交给producer但还没有收到kafka响应的消息数
+	ProducerPending int64 `json:"producer_pending"`
+}
+
+func (s *Server) debugRoutes(router *Router) {
+	admin := queue.RoleAdmin
+	router.GET("/debug/pprof/", s.auth(admin, CompatibleWarp(pprof.Index)))
+	router.GET("/debug/pprof/cmdline", s.auth(admin, CompatibleWarp(pprof.Cmdline)))
+	router.GET("/debug/pprof/profile", s.auth(admin, CompatibleWarp(pprof.Profile)))
+	router.GET("/debug/pprof/symbol", s.auth(admin, CompatibleWarp(pprof.Symbol)))
+	router.POST("/debug/pprof/symbol", s.auth(admin, CompatibleWarp(pprof.Symbol)))
+	router.GET("/debug/pprof/trace", s.auth(admin, CompatibleWarp(pprof.Trace)))
+	router.GET("/debug/stats", s.auth(admin, s.getRuntimeStatsHandler))
+}
+
+// router.GET("/debug/stats", s.getRuntimeStatsHandler)
+func (s *Server) getRuntimeStatsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := &runtimeStats{
+		Uptime:     s.queue.UpTime(),
+		Goroutines: runtime.NumGoroutine(),
+		NumCPU:     runtime.NumCPU(),
+		GC: gcStats{
+			NumGC:        mem.NumGC,
+			PauseTotalNs: mem.PauseTotalNs,
+			LastPauseNs:  mem.PauseNs[(mem.NumGC+255)%256],
+			NextGC:       mem.NextGC,
+		},
+		Heap: heapStats{
+			Alloc:    mem.HeapAlloc,
+			Sys:      mem.HeapSys,
+			Inuse:    mem.HeapInuse,
+			Idle:     mem.HeapIdle,
+			Objects:  mem.HeapObjects,
+			Released: mem.HeapReleased,
+		},
+		Consumers:       len(s.queue.Consumers()),
+		ProducerPending: s.queue.ProducerPending(),
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
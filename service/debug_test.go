@@ -0,0 +1,64 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/weibocom/wqs/engine/queue"
+)
+
+type statsQueue struct {
+	queue.Queue
+}
+
+func (q *statsQueue) UpTime() int64 {
+	return 10
+}
+
+func (q *statsQueue) Consumers() []string {
+	return []string{"q1@g1", "q2@g2"}
+}
+
+func (q *statsQueue) ProducerPending() int64 {
+	return 3
+}
+
+func TestRuntimeStatsHandler(t *testing.T) {
+	s := &Server{queue: &statsQueue{}}
+	router := NewRouter()
+	s.debugRoutes(router)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/debug/stats", nil)
+	router.ServeHTTP(w, req)
+
+	msg := &ResponseMessage{}
+	if err := json.Unmarshal(w.Body.Bytes(), msg); err != nil || msg.Code != 200 {
+		t.Fatalf("bad response %s: %v", w.Body, err)
+	}
+	stats := &runtimeStats{}
+	if err := json.Unmarshal([]byte(msg.Message), stats); err != nil {
+		t.Fatalf("bad stats %s: %v", msg.Message, err)
+	}
+	if stats.Consumers != 2 || stats.ProducerPending != 3 || stats.Goroutines <= 0 || stats.Heap.Alloc == 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
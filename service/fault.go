@@ -0,0 +1,60 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/weibocom/wqs/engine/queue"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// 需要配置fault.enable=true, 否则返回501
+// router.GET("/faults", s.auth(admin, s.getFaultsHandler))
+func (s *Server) getFaultsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	status, err := s.queue.Faults()
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
+// 修改故障注入的配置, 重启后恢复为配置文件中的值
+// router.PUT("/faults", s.auth(admin, s.setFaultsHandler))
+func (s *Server) setFaultsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	config := queue.FaultConfig{}
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+	configResponse(w, s.queue.SetFaults(config))
+}
+
+// router.POST("/faults/zk/expire", s.auth(admin, s.expireSessionHandler))
+func (s *Server) expireSessionHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	configResponse(w, s.queue.ExpireSession())
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/weibocom/wqs/log"
+)
+
+// 进程能够处理HTTP请求即认为存活
+// router.GET("/health/live", s.liveHandler)
+func (s *Server) liveHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	response(w, 200, "OK")
+}
+
+// 所有依赖都可用时返回200, 否则返回503, 负载均衡应当停止向该proxy转发请求
+// router.GET("/health/ready", s.readyHandler)
+func (s *Server) readyHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	status := s.queue.HealthCheck()
+	code := http.StatusOK
+	for _, item := range status {
+		if !item.Healthy {
+			code = http.StatusServiceUnavailable
+			log.Warnf("health check %s failed: %s", item.Name, item.Error)
+		}
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, code, string(data))
+}
+
+// 各个idc的kafka集群中broker、controller和queue的副本状态, 集群有问题时仍然返回200
+// router.GET("/kafka/status", s.auth(admin, s.kafkaStatusHandler))
+func (s *Server) kafkaStatusHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	data, err := json.Marshal(s.queue.KafkaStatus())
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/weibocom/wqs/engine/queue"
+)
+
+type healthQueue struct {
+	queue.Queue
+	status []queue.HealthStatus
+}
+
+func (q *healthQueue) HealthCheck() []queue.HealthStatus {
+	return q.status
+}
+
+func (q *healthQueue) KafkaStatus() []*queue.KafkaStatus {
+	return []*queue.KafkaStatus{{Idc: "local", Error: "no broker"}}
+}
+
+func TestReadyHandler(t *testing.T) {
+	q := &healthQueue{status: []queue.HealthStatus{
+		{Name: "zookeeper", Healthy: true},
+		{Name: "kafka.local", Healthy: true},
+	}}
+	s := &Server{queue: q}
+	router := NewRouter()
+	router.GET("/health/live", s.liveHandler)
+	router.GET("/health/ready", s.readyHandler)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://example.com"+path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get("/health/ready"); w.Code != 200 {
+		t.Errorf("expect 200, got %d %s", w.Code, w.Body)
+	}
+
+	q.status[1] = queue.HealthStatus{Name: "kafka.local", Error: "no broker"}
+	w := get("/health/ready")
+	if w.Code != 503 || !strings.Contains(w.Body.String(), "no broker") {
+		t.Errorf("expect 503 with error, got %d %s", w.Code, w.Body)
+	}
+
+	if w := get("/health/live"); w.Code != 200 {
+		t.Errorf("live should always be 200, got %d", w.Code)
+	}
+}
+
+func TestKafkaStatusHandler(t *testing.T) {
+	s := &Server{queue: &healthQueue{}}
+	w := httptest.NewRecorder()
+	s.kafkaStatusHandler(w, httptest.NewRequest("GET", "/kafka/status", nil), nil)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `\"idc\":\"local\",\"error\":\"no broker\"`) {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body)
+	}
+}
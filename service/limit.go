@@ -0,0 +1,121 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/log"
+
+	"github.com/juju/errors"
+)
+
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	// 需要大于SQS和REST接口长轮询的等待时间
+	defaultWriteTimeout = 60 * time.Second
+	defaultIdleTimeout  = 120 * time.Second
+	defaultMaxBodyBytes = 10 << 20
+)
+
+// HTTP接口的超时、请求体大小和连接数限制, 为0时不限制
+type httpLimits struct {
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxBodyBytes      int64
+	maxConns          int64
+	// 连接数超过maxConns时返回的状态码, 429或503
+	shedStatus int
+	// 当前打开的连接数
+	conns func() int64
+}
+
+func loadHTTPLimits(conf *config.Config) (*httpLimits, error) {
+	l := &httpLimits{
+		readHeaderTimeout: defaultReadHeaderTimeout,
+		writeTimeout:      defaultWriteTimeout,
+		idleTimeout:       defaultIdleTimeout,
+		maxBodyBytes:      defaultMaxBodyBytes,
+		shedStatus:        http.StatusServiceUnavailable,
+	}
+	section, err := conf.GetSection("http")
+	if err != nil {
+		return l, nil
+	}
+
+	for key, value := range map[string]*time.Duration{
+		"read.header.timeout": &l.readHeaderTimeout,
+		"read.timeout":        &l.readTimeout,
+		"write.timeout":       &l.writeTimeout,
+		"idle.timeout":        &l.idleTimeout,
+	} {
+		v := section.GetStringMust(key, "")
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, errors.NotValidf("http.%s %s", key, v)
+		}
+		*value = d
+	}
+
+	l.maxBodyBytes = section.GetInt64Must("max.body.bytes", l.maxBodyBytes)
+	l.maxConns = section.GetInt64Must("max.conns", 0)
+	if l.maxBodyBytes < 0 || l.maxConns < 0 {
+		return nil, errors.NotValidf("http.max.body.bytes %d or http.max.conns %d", l.maxBodyBytes, l.maxConns)
+	}
+	l.shedStatus = int(section.GetInt64Must("shed.status", int64(l.shedStatus)))
+	if l.shedStatus != http.StatusTooManyRequests && l.shedStatus != http.StatusServiceUnavailable {
+		return nil, errors.NotValidf("http.shed.status %d, use 429 or 503", l.shedStatus)
+	}
+	return l, nil
+}
+
+func (l *httpLimits) apply(server *http.Server) {
+	server.ReadHeaderTimeout = l.readHeaderTimeout
+	server.ReadTimeout = l.readTimeout
+	server.WriteTimeout = l.writeTimeout
+	server.IdleTimeout = l.idleTimeout
+}
+
+// 连接数超限或请求体过大时直接返回错误, 否则限制请求体的读取长度
+func (l *httpLimits) admit(w http.ResponseWriter, req *http.Request) bool {
+	if l.maxConns > 0 && l.conns != nil {
+		if conns := l.conns(); conns > l.maxConns {
+			log.Warnf("shed request from %s, %d connections exceed %d", req.RemoteAddr, conns, l.maxConns)
+			// 让客户端断开当前连接, 释放文件描述符
+			w.Header().Set("Connection", "close")
+			overload(w)
+			response(w, l.shedStatus, "too many connections")
+			return false
+		}
+	}
+	if l.maxBodyBytes > 0 {
+		if req.ContentLength > l.maxBodyBytes {
+			w.Header().Set("Connection", "close")
+			response(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return false
+		}
+		req.Body = http.MaxBytesReader(w, req.Body, l.maxBodyBytes)
+	}
+	return true
+}
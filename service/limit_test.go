@@ -0,0 +1,98 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestLoadHTTPLimits(t *testing.T) {
+	conf, _ := config.NewConfigFromBytes([]byte(testReloadConfig))
+	limits, err := loadHTTPLimits(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.writeTimeout != defaultWriteTimeout || limits.maxBodyBytes != defaultMaxBodyBytes ||
+		limits.maxConns != 0 || limits.shedStatus != http.StatusServiceUnavailable {
+		t.Errorf("unexpected default limits %+v", limits)
+	}
+
+	conf, _ = config.NewConfigFromBytes([]byte(testReloadConfig +
+		"http.read.timeout=5s\nhttp.write.timeout=0\nhttp.max.conns=100\nhttp.shed.status=429\n"))
+	if limits, err = loadHTTPLimits(conf); err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{}
+	limits.apply(server)
+	if server.ReadTimeout != 5*time.Second || server.WriteTimeout != 0 || server.ReadHeaderTimeout != defaultReadHeaderTimeout ||
+		limits.maxConns != 100 || limits.shedStatus != http.StatusTooManyRequests {
+		t.Errorf("unexpected limits %+v", limits)
+	}
+
+	for _, extra := range []string{"http.idle.timeout=10\n", "http.max.conns=-1\n", "http.shed.status=500\n"} {
+		conf, _ = config.NewConfigFromBytes([]byte(testReloadConfig + extra))
+		if _, err = loadHTTPLimits(conf); err == nil {
+			t.Errorf("expect error for %s", extra)
+		}
+	}
+}
+
+func TestHTTPLimitsAdmit(t *testing.T) {
+	var conns int64 = 1
+	router := NewRouter()
+	router.limits = &httpLimits{maxBodyBytes: 8, maxConns: 2, shedStatus: http.StatusTooManyRequests,
+		conns: func() int64 { return conns }}
+	router.POST("/msg", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		if _, err := ioutil.ReadAll(r.Body); err != nil {
+			response(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		response(w, http.StatusOK, "ok")
+	})
+
+	do := func(body string, length int64) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "http://example.com/msg", strings.NewReader(body))
+		req.ContentLength = length
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("12345678", 8); w.Code != http.StatusOK {
+		t.Errorf("expect 200, got %d", w.Code)
+	}
+	if w := do("123456789", 9); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expect 413, got %d", w.Code)
+	}
+	// chunked请求在读取时截断
+	if w := do("123456789", -1); w.Code != http.StatusBadRequest {
+		t.Errorf("expect 400 for chunked body over limit, got %d", w.Code)
+	}
+	conns = 3
+	if w := do("1", 1); w.Code != http.StatusTooManyRequests || w.Header().Get("Connection") != "close" {
+		t.Errorf("expect 429 and close, got %d %v", w.Code, w.Header())
+	}
+}
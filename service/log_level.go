@@ -0,0 +1,149 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/log"
+
+	"github.com/juju/errors"
+)
+
+// 设置全局级别时使用的模块名
+const globalLogModule = "global"
+
+// router.GET("/log/levels", getLogLevelsHandler)
+func getLogLevelsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	levels := &LogLevels{
+		Global:  log.LogLevel2String(log.GetLevel()),
+		Modules: make(map[string]string),
+	}
+	for _, module := range log.Modules() {
+		if level, ok := log.GetModuleLevel(module); ok {
+			levels.Modules[module] = log.LogLevel2String(level)
+		}
+	}
+
+	data, err := json.Marshal(levels)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
+// 排查问题时可以只打开某个模块(queue, kafka, service)的debug日志, 不需要重启
+// router.PUT("/log/levels/:module", setLogLevelHandler)
+func setLogLevelHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	attr := &LogLevelAttr{}
+	if err := json.NewDecoder(r.Body).Decode(attr); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+	level, err := log.ParseLevel(attr.Level)
+	if err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	module := ps.ByName("module")
+	if module == globalLogModule {
+		log.SetLevel(level)
+	} else if !log.SetModuleLevel(module, level) {
+		response(w, 404, fmt.Sprintf("not found module %s", module))
+		return
+	}
+	log.Infof("set log level of %s to %s", module, attr.Level)
+	response(w, 200, "OK")
+}
+
+// 恢复使用全局级别
+// router.DELETE("/log/levels/:module", resetLogLevelHandler)
+func resetLogLevelHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	module := ps.ByName("module")
+	if !log.ResetModuleLevel(module) {
+		response(w, 404, fmt.Sprintf("not found module %s", module))
+		return
+	}
+	log.Infof("reset log level of %s", module)
+	response(w, 200, "OK")
+}
+
+// 配置文件中的日志级别: log.level为全局级别, log.level.<module>为模块级别, 未配置的模块使用全局级别
+type configLogLevels struct {
+	global    uint32
+	hasGlobal bool
+	modules   map[string]uint32
+}
+
+func parseLogLevels(conf *config.Config) (*configLogLevels, error) {
+	levels := &configLogLevels{modules: make(map[string]uint32)}
+	section, err := conf.GetSection("log")
+	if err != nil {
+		return levels, nil
+	}
+	for key, value := range section {
+		if key != "level" && !strings.HasPrefix(key, "level.") {
+			continue
+		}
+		level, err := log.ParseLevel(value)
+		if err != nil {
+			return nil, errors.NotValidf("log.%s : %q", key, value)
+		}
+		if key == "level" {
+			levels.global, levels.hasGlobal = level, true
+			continue
+		}
+		module := strings.TrimPrefix(key, "level.")
+		if !isLogModule(module) {
+			return nil, errors.NotValidf("log module : %q", module)
+		}
+		levels.modules[module] = level
+	}
+	return levels, nil
+}
+
+func isLogModule(module string) bool {
+	for _, m := range log.Modules() {
+		if m == module {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *configLogLevels) apply() {
+	if l.hasGlobal {
+		log.SetLevel(l.global)
+	}
+	for _, module := range log.Modules() {
+		if level, ok := l.modules[module]; ok {
+			log.SetModuleLevel(module, level)
+		} else {
+			log.ResetModuleLevel(module)
+		}
+	}
+}
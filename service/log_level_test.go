@@ -0,0 +1,75 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/weibocom/wqs/log"
+)
+
+func TestLogLevelHandlers(t *testing.T) {
+	defer log.SetLevel(log.LogDebug)
+	defer log.ResetModuleLevel(log.ModuleKafka)
+
+	router := NewRouter()
+	router.GET("/log/levels", getLogLevelsHandler)
+	router.PUT("/log/levels/:module", setLogLevelHandler)
+	router.DELETE("/log/levels/:module", resetLogLevelHandler)
+
+	do := func(method string, path string, body string) (int, string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "http://example.com"+path, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		msg := &ResponseMessage{}
+		json.NewDecoder(w.Body).Decode(msg)
+		return w.Code, msg.Message
+	}
+
+	if code, _ := do("PUT", "/log/levels/global", `{"level":"info"}`); code != 200 {
+		t.Errorf("set global level error: %d", code)
+	}
+	if code, _ := do("PUT", "/log/levels/kafka", `{"level":"debug"}`); code != 200 {
+		t.Errorf("set module level error: %d", code)
+	}
+	if code, _ := do("PUT", "/log/levels/kafka", `{"level":"verbose"}`); code != 400 {
+		t.Errorf("expect 400 for bad level, got %d", code)
+	}
+	if code, _ := do("PUT", "/log/levels/noexist", `{"level":"debug"}`); code != 404 {
+		t.Errorf("expect 404 for unknown module, got %d", code)
+	}
+
+	_, data := do("GET", "/log/levels", "")
+	levels := &LogLevels{}
+	if err := json.Unmarshal([]byte(data), levels); err != nil {
+		t.Fatalf("unmarshal %q error: %v", data, err)
+	}
+	if levels.Global != "info" || len(levels.Modules) != 1 || levels.Modules["kafka"] != "debug" {
+		t.Errorf("get levels error: %+v", levels)
+	}
+
+	if code, _ := do("DELETE", "/log/levels/kafka", ""); code != 200 {
+		t.Errorf("reset module level error: %d", code)
+	}
+	if _, ok := log.GetModuleLevel(log.ModuleKafka); ok {
+		t.Errorf("expect kafka level reset")
+	}
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/juju/errors"
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/engine/queue"
+)
+
+// binary协议见 https://github.com/memcached/memcached/wiki/BinaryProtocolRevamped,
+// 连接的第一个字节为binaryMagicRequest时使用binary协议, key的格式与文本协议相同
+const (
+	binaryMagicRequest  = 0x80
+	binaryMagicResponse = 0x81
+	binaryHeaderLen     = 24
+	binaryMaxBodyLen    = 64 << 20
+)
+
+const (
+	opGet     = 0x00
+	opSet     = 0x01
+	opQuit    = 0x07
+	opGetQ    = 0x09
+	opNoop    = 0x0a
+	opVersion = 0x0b
+	opGetK    = 0x0c
+	opGetKQ   = 0x0d
+	opSetQ    = 0x11
+	opQuitQ   = 0x17
+)
+
+const (
+	statusOK             = 0x0000
+	statusKeyNotFound    = 0x0001
+	statusValueTooLarge  = 0x0003
+	statusInvalidArgs    = 0x0004
+	statusUnknownCommand = 0x0081
+	statusInternalError  = 0x0084
+)
+
+type binaryRequest struct {
+	opcode byte
+	opaque uint32
+	extras []byte
+	key    string
+	value  []byte
+}
+
+var errBadMagic = errors.New("bad binary request magic")
+
+func readBinaryRequest(r *bufio.Reader) (*binaryRequest, error) {
+	header := make([]byte, binaryHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[0] != binaryMagicRequest {
+		return nil, errBadMagic
+	}
+	keyLen := int(binary.BigEndian.Uint16(header[2:4]))
+	extLen := int(header[4])
+	bodyLen := int(binary.BigEndian.Uint32(header[8:12]))
+	if bodyLen > binaryMaxBodyLen || keyLen+extLen > bodyLen {
+		return nil, errors.NotValidf("binary request body length %d, key length %d, extras length %d", bodyLen, keyLen, extLen)
+	}
+
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &binaryRequest{
+		opcode: header[1],
+		opaque: binary.BigEndian.Uint32(header[12:16]),
+		extras: body[:extLen],
+		key:    string(body[extLen : extLen+keyLen]),
+		value:  body[extLen+keyLen:],
+	}, nil
+}
+
+func writeBinaryResponse(w *bufio.Writer, req *binaryRequest, status uint16, extras []byte, key string, value []byte) {
+	header := make([]byte, binaryHeaderLen)
+	header[0] = binaryMagicResponse
+	header[1] = req.opcode
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(key)))
+	header[4] = byte(len(extras))
+	binary.BigEndian.PutUint16(header[6:8], status)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(header[12:16], req.opaque)
+	w.Write(header)
+	w.Write(extras)
+	w.WriteString(key)
+	w.Write(value)
+}
+
+// 错误信息作为value返回
+func writeBinaryError(w *bufio.Writer, req *binaryRequest, status uint16, msg string) {
+	writeBinaryResponse(w, req, status, nil, "", []byte(msg))
+}
+
+// 没有更多pipeline的请求时才flush, quiet命令成功时没有回复, 客户端通过noop获取之前的结果
+func (s *Server) serveBinary(r *bufio.Reader, w *bufio.Writer) error {
+	for atomic.LoadInt32(&s.stopping) == 0 {
+		req, err := readBinaryRequest(r)
+		if err != nil {
+			w.Flush()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		switch req.opcode {
+		case opGet, opGetQ, opGetK, opGetKQ:
+			binaryGet(s.queue, req, w)
+		case opSet, opSetQ:
+			binarySet(s.queue, req, w)
+		case opNoop:
+			writeBinaryResponse(w, req, statusOK, nil, "", nil)
+		case opVersion:
+			writeBinaryResponse(w, req, statusOK, nil, "", []byte(s.queue.Version()))
+		case opQuit:
+			writeBinaryResponse(w, req, statusOK, nil, "", nil)
+			return w.Flush()
+		case opQuitQ:
+			return w.Flush()
+		default:
+			writeBinaryError(w, req, statusUnknownCommand, "Unknown command")
+		}
+
+		if r.Buffered() == 0 {
+			if err = w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// 与文本协议的get一样读取后直接ack, quiet命令没有消息时不回复
+func binaryGet(q queue.Queue, req *binaryRequest, w *bufio.Writer) {
+	quiet := req.opcode == opGetQ || req.opcode == opGetKQ
+	withKey := req.opcode == opGetK || req.opcode == opGetKQ
+	if len(req.extras) != 0 || len(req.value) != 0 || req.key == "" {
+		writeBinaryError(w, req, statusInvalidArgs, "Invalid arguments")
+		return
+	}
+
+	keys := strings.SplitN(req.key, ".", 2)
+	group := defaultGroup
+	queue := keys[0]
+	if len(keys) == 2 {
+		group = keys[0]
+		queue = keys[1]
+	}
+
+	id, data, flag, err := q.RecvMessage(context.Background(), queue, group)
+	if err != nil {
+		if err == kafka.ErrTimeout || err == kafka.ErrNoPartition {
+			if !quiet {
+				writeBinaryError(w, req, statusKeyNotFound, "Not found")
+			}
+			return
+		}
+		writeBinaryError(w, req, statusInternalError, err.Error())
+		return
+	}
+
+	extras := make([]byte, 4)
+	binary.BigEndian.PutUint32(extras, uint32(flag))
+	key := ""
+	if withKey {
+		key = req.key
+	}
+	writeBinaryResponse(w, req, statusOK, extras, key, data)
+	q.AckMessage(context.Background(), queue, group, id)
+}
+
+// extras为flags和expiration, expiration不使用. quiet命令成功时不回复
+func binarySet(q queue.Queue, req *binaryRequest, w *bufio.Writer) {
+	if len(req.extras) != 8 || req.key == "" {
+		writeBinaryError(w, req, statusInvalidArgs, "Invalid arguments")
+		return
+	}
+	flag := binary.BigEndian.Uint32(req.extras[:4])
+
+	keys := strings.SplitN(req.key, ".", 3)
+	group := defaultGroup
+	name := keys[0]
+	msgID := ""
+	if len(keys) >= 2 {
+		group = keys[0]
+		name = keys[1]
+	}
+	if len(keys) == 3 {
+		msgID = keys[2]
+	}
+
+	if _, err := q.SendMessageWithID(context.Background(), name, group, req.value, uint64(flag), msgID); err != nil {
+		if queue.IsMessageTooLarge(err) {
+			writeBinaryError(w, req, statusValueTooLarge, err.Error())
+			return
+		}
+		writeBinaryError(w, req, statusInternalError, err.Error())
+		return
+	}
+	if req.opcode == opSet {
+		writeBinaryResponse(w, req, statusOK, nil, "", nil)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/engine/queue"
+)
+
+type fakeQueue struct {
+	queue.Queue
+	sent  []string
+	msgs  []string
+	acked []string
+}
+
+func (q *fakeQueue) SendMessageWithID(ctx context.Context, name string, group string, data []byte, flag uint64, msgID string) (string, error) {
+	q.sent = append(q.sent, group+"."+name+" "+string(data))
+	return "id", nil
+}
+
+func (q *fakeQueue) RecvMessage(ctx context.Context, name string, group string) (string, []byte, uint64, error) {
+	if len(q.msgs) == 0 {
+		return "", nil, 0, kafka.ErrTimeout
+	}
+	data := q.msgs[0]
+	q.msgs = q.msgs[1:]
+	return data, []byte(data), 7, nil
+}
+
+func (q *fakeQueue) AckMessage(ctx context.Context, name string, group string, id string) error {
+	q.acked = append(q.acked, id)
+	return nil
+}
+
+func (q *fakeQueue) Version() string {
+	return "test"
+}
+
+func binaryPacket(opcode byte, opaque uint32, extras []byte, key string, value []byte) []byte {
+	header := make([]byte, binaryHeaderLen)
+	header[0] = binaryMagicRequest
+	header[1] = opcode
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(key)))
+	header[4] = byte(len(extras))
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(header[12:16], opaque)
+	packet := append(header, extras...)
+	packet = append(packet, key...)
+	return append(packet, value...)
+}
+
+type binaryResponse struct {
+	opcode byte
+	status uint16
+	opaque uint32
+	extras []byte
+	key    string
+	value  string
+}
+
+func readBinaryResponses(t *testing.T, data []byte) []binaryResponse {
+	var responses []binaryResponse
+	for len(data) > 0 {
+		if len(data) < binaryHeaderLen || data[0] != binaryMagicResponse {
+			t.Fatalf("bad response: %q", data)
+		}
+		keyLen := int(binary.BigEndian.Uint16(data[2:4]))
+		extLen := int(data[4])
+		bodyLen := int(binary.BigEndian.Uint32(data[8:12]))
+		body := data[binaryHeaderLen : binaryHeaderLen+bodyLen]
+		responses = append(responses, binaryResponse{
+			opcode: data[1],
+			status: binary.BigEndian.Uint16(data[6:8]),
+			opaque: binary.BigEndian.Uint32(data[12:16]),
+			extras: body[:extLen],
+			key:    string(body[extLen : extLen+keyLen]),
+			value:  string(body[extLen+keyLen:]),
+		})
+		data = data[binaryHeaderLen+bodyLen:]
+	}
+	return responses
+}
+
+func TestServeBinary(t *testing.T) {
+	q := &fakeQueue{msgs: []string{"m1", "m2"}}
+	s := NewServer(q, "", 0, 0)
+
+	setExtras := []byte{0, 0, 0, 7, 0, 0, 0, 0}
+	input := &bytes.Buffer{}
+	input.Write(binaryPacket(opSet, 1, setExtras, "g1.q1", []byte("a")))
+	input.Write(binaryPacket(opSetQ, 2, setExtras, "q1", []byte("b")))
+	input.Write(binaryPacket(opSetQ, 3, nil, "q1", []byte("c")))
+	input.Write(binaryPacket(opGetK, 4, nil, "g1.q1", nil))
+	input.Write(binaryPacket(opGetQ, 5, nil, "g1.q1", nil))
+	input.Write(binaryPacket(opGetKQ, 6, nil, "g1.q1", nil))
+	input.Write(binaryPacket(opGet, 7, nil, "g1.q1", nil))
+	input.Write(binaryPacket(0x30, 8, nil, "", nil))
+	input.Write(binaryPacket(opNoop, 9, nil, "", nil))
+	input.Write(binaryPacket(opVersion, 10, nil, "", nil))
+	input.Write(binaryPacket(opQuit, 11, nil, "", nil))
+	input.Write(binaryPacket(opNoop, 12, nil, "", nil))
+
+	out := &bytes.Buffer{}
+	if err := s.serveBinary(bufio.NewReader(input), bufio.NewWriter(out)); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []binaryResponse{
+		{opcode: opSet, status: statusOK, opaque: 1},
+		{opcode: opSetQ, status: statusInvalidArgs, opaque: 3, value: "Invalid arguments"},
+		{opcode: opGetK, status: statusOK, opaque: 4, extras: []byte{0, 0, 0, 7}, key: "g1.q1", value: "m1"},
+		{opcode: opGetQ, status: statusOK, opaque: 5, extras: []byte{0, 0, 0, 7}, value: "m2"},
+		{opcode: opGet, status: statusKeyNotFound, opaque: 7, value: "Not found"},
+		{opcode: 0x30, status: statusUnknownCommand, opaque: 8, value: "Unknown command"},
+		{opcode: opNoop, status: statusOK, opaque: 9},
+		{opcode: opVersion, status: statusOK, opaque: 10, value: "test"},
+		{opcode: opQuit, status: statusOK, opaque: 11},
+	}
+	responses := readBinaryResponses(t, out.Bytes())
+	if len(responses) != len(expect) {
+		t.Fatalf("expect %d responses, got %+v", len(expect), responses)
+	}
+	for i, r := range responses {
+		e := expect[i]
+		if r.opcode != e.opcode || r.status != e.status || r.opaque != e.opaque ||
+			!bytes.Equal(r.extras, e.extras) || r.key != e.key || r.value != e.value {
+			t.Errorf("response %d expect %+v, got %+v", i, e, r)
+		}
+	}
+
+	if len(q.sent) != 2 || q.sent[0] != "g1.q1 a" || q.sent[1] != "default.q1 b" {
+		t.Errorf("sent messages error: %q", q.sent)
+	}
+	if len(q.acked) != 2 || q.acked[0] != "m1" || q.acked[1] != "m2" {
+		t.Errorf("acked messages error: %q", q.acked)
+	}
+}
+
+func TestReadBinaryRequestInvalid(t *testing.T) {
+	packet := binaryPacket(opSet, 1, make([]byte, 8), "key", nil)
+	binary.BigEndian.PutUint32(packet[8:12], 4)
+	if _, err := readBinaryRequest(bufio.NewReader(bytes.NewReader(packet))); err == nil {
+		t.Errorf("key and extras longer than body should fail")
+	}
+	packet[0] = 0x00
+	if _, err := readBinaryRequest(bufio.NewReader(bytes.NewReader(packet))); err != errBadMagic {
+		t.Errorf("expect bad magic, got %v", err)
+	}
+}
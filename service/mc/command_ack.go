@@ -18,6 +18,7 @@ package mc
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"strconv"
@@ -61,7 +62,7 @@ func commandAck(q queue.Queue, tokens []string, r *bufio.Reader, w *bufio.Writer
 		queue = keys[1]
 	}
 
-	if err := q.AckMessage(queue, group, tokens[2]); err != nil {
+	if err := q.AckMessage(context.Background(), queue, group, tokens[2]); err != nil {
 		fmt.Fprintf(w, "%s %s\r\n", respEngineErrorPrefix, err)
 		return false
 	}
@@ -125,7 +126,7 @@ func commandEack(q queue.Queue, tokens []string, r *bufio.Reader, w *bufio.Write
 		queue = keys[1]
 	}
 
-	if err = q.AckMessage(queue, group, string(id)); err != nil {
+	if err = q.AckMessage(context.Background(), queue, group, string(id)); err != nil {
 		fmt.Fprintf(w, "%s %s\r\n", respEngineErrorPrefix, err)
 		return false
 	}
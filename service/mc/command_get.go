@@ -18,6 +18,7 @@ package mc
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"strings"
 
@@ -63,9 +64,9 @@ func commandGet(q queue.Queue, tokens []string, r *bufio.Reader, w *bufio.Writer
 			queue = k[1]
 		}
 
-		id, data, flag, err := q.RecvMessage(queue, group)
+		id, data, flag, err := q.RecvMessage(context.Background(), queue, group)
 		if err != nil {
-			if err == kafka.ErrTimeout {
+			if err == kafka.ErrTimeout || err == kafka.ErrNoPartition {
 				w.WriteString(respEnd)
 			} else {
 				fmt.Fprintf(w, "%s %s\r\n", respEngineErrorPrefix, err)
@@ -96,7 +97,7 @@ func commandGet(q queue.Queue, tokens []string, r *bufio.Reader, w *bufio.Writer
 		w.Write(p.value)
 		w.WriteString("\r\n")
 		if cmd == cmdGet {
-			q.AckMessage(p.queue, p.group, p.id)
+			q.AckMessage(context.Background(), p.queue, p.group, p.id)
 		}
 	}
 	w.WriteString(respEnd)
@@ -18,6 +18,7 @@ package mc
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"strconv"
@@ -78,15 +79,20 @@ func commandSet(q queue.Queue, tokens []string, r *bufio.Reader, w *bufio.Writer
 	}
 
 	data = data[:length]
-	keys := strings.SplitN(key, ".", 2)
+	// key格式为 [group.]queue 或 group.queue.msgid, 携带msgid时在去重窗口内只发送一次
+	keys := strings.SplitN(key, ".", 3)
 	group := defaultGroup
 	queue := keys[0]
-	if len(keys) == 2 {
+	msgID := ""
+	if len(keys) >= 2 {
 		group = keys[0]
 		queue = keys[1]
 	}
+	if len(keys) == 3 {
+		msgID = keys[2]
+	}
 
-	id, err := q.SendMessage(queue, group, data, flag)
+	id, err := q.SendMessageWithID(context.Background(), queue, group, data, flag, msgID)
 	if err != nil {
 		fmt.Fprintf(w, "%s %s\r\n", respEngineErrorPrefix, err)
 		return false
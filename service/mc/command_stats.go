@@ -21,12 +21,12 @@ import (
 	"fmt"
 	"os"
 	"runtime"
-	"syscall"
 	"time"
 	"unsafe"
 
 	"github.com/weibocom/wqs/engine/queue"
 	"github.com/weibocom/wqs/metrics"
+	"github.com/weibocom/wqs/utils"
 )
 
 const (
@@ -46,8 +46,7 @@ func commandStats(q queue.Queue, tokens []string, r *bufio.Reader, w *bufio.Writ
 	}
 
 	if fields == 1 {
-		var rusage syscall.Rusage
-		syscall.Getrusage(0, &rusage)
+		user, system := utils.CPUTime()
 		getHits := metrics.GetCounter(metrics.CmdGet)
 		getMiss := metrics.GetCounter(metrics.CmdGetMiss)
 		setHits := metrics.GetCounter(metrics.CmdSet)
@@ -59,8 +58,8 @@ func commandStats(q queue.Queue, tokens []string, r *bufio.Reader, w *bufio.Writ
 		fmt.Fprintf(w, "STAT time %d\r\n", time.Now().Unix())
 		fmt.Fprintf(w, "STAT version %s\r\n", q.Version())
 		fmt.Fprintf(w, "STAT pointer_size %d\r\n", unsafe.Sizeof(r)*8)
-		fmt.Fprintf(w, "STAT rusage_user %d.%06d\r\n", rusage.Utime.Sec, rusage.Utime.Usec)
-		fmt.Fprintf(w, "STAT rusage_system %d.%06d\r\n", rusage.Stime.Sec, rusage.Stime.Usec)
+		fmt.Fprintf(w, "STAT rusage_user %d.%06d\r\n", user/time.Second, (user%time.Second)/time.Microsecond)
+		fmt.Fprintf(w, "STAT rusage_system %d.%06d\r\n", system/time.Second, (system%time.Second)/time.Microsecond)
 		fmt.Fprintf(w, "STAT curr_connections %d\r\n", conns)
 		fmt.Fprintf(w, "STAT total_connections %d\r\n", metrics.GetCounter(metrics.ToConn))
 		fmt.Fprintf(w, "STAT connection_structures %d\r\n", conns)
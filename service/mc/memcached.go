@@ -98,10 +98,18 @@ func (s *Server) connLoop(conn net.Conn) {
 	br := bufio.NewReaderSize(conn, s.recvBuffSize)
 	bw := bufio.NewWriterSize(conn, s.sendBuffSize)
 
+	// 根据第一个字节区分binary协议和文本协议
+	if magic, err := br.Peek(1); err == nil && magic[0] == binaryMagicRequest {
+		if err = s.serveBinary(br, bw); err != nil && atomic.LoadInt32(&s.stopping) == 0 {
+			log.Warnf("memcached binary client %s error: %s, close connection.", conn.RemoteAddr(), err)
+		}
+		return
+	}
+
 	for atomic.LoadInt32(&s.stopping) == 0 {
 		data, err := br.ReadString('\n')
 		if err != nil {
-			if err == io.EOF {
+			if err == io.EOF || atomic.LoadInt32(&s.stopping) != 0 {
 				return
 			}
 			log.Warnf("mc server ReadLine err:%s", err)
@@ -132,19 +140,28 @@ func (s *Server) DrainConn() {
 	s.mu.Unlock()
 }
 
-func (s *Server) Stop() {
+// 停止接收新连接, 等待正在执行的命令完成后关闭连接, 超过timeout时强制关闭
+func (s *Server) Stop(timeout time.Duration) {
 	if !atomic.CompareAndSwapInt32(&s.stopping, 0, 1) {
 		return
 	}
-	//Make on processing commamd to run over
-	time.Sleep(200 * time.Millisecond)
 	if err := s.listener.Close(); err != nil {
 		log.Errorf("mc server listener close failed:%s", err)
 		return
 	}
-	s.DrainConn()
-	for s.listener.GetRemain() != 0 {
-		time.Sleep(time.Millisecond)
+	// 等待下一个命令的连接立即返回, 执行命令的连接在回复后检查stopping退出
+	s.mu.Lock()
+	for _, conn := range s.connPool {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	for deadline := time.Now().Add(timeout); s.listener.GetRemain() != 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			log.Warnf("mc server drain timeout, close %d connections", s.listener.GetRemain())
+			s.DrainConn()
+			break
+		}
 	}
 	log.Info("memcached protocol server stop.")
 }
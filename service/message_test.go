@@ -0,0 +1,203 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/weibocom/wqs/engine/queue"
+
+	"github.com/juju/errors"
+)
+
+type messageQueue struct {
+	queue.Queue
+	paused bool
+	mirror *queue.MirrorConfig
+}
+
+func (q *messageQueue) GetMessageAt(name string, partition int32, offset int64) (*queue.MessageInfo, error) {
+	if name != "q1" || offset != 100 {
+		return nil, errors.NotFoundf("queue %s partition %d offset %d", name, partition, offset)
+	}
+	return &queue.MessageInfo{Partition: partition, Offset: offset, Size: 5, Data: "hello"}, nil
+}
+
+func (q *messageQueue) SetGroupPaused(group string, name string, paused bool) error {
+	if name != "q1" || group != "g1" {
+		return errors.NotFoundf("queue %s group %s", name, group)
+	}
+	q.paused = paused
+	return nil
+}
+
+func (q *messageQueue) RecvMessage(ctx context.Context, name string, group string) (string, []byte, uint64, error) {
+	if q.paused {
+		return "", nil, 0, errors.Annotatef(queue.ErrGroupPaused, "queue %q group %q", name, group)
+	}
+	return "", nil, 0, errors.NotFoundf("queue %s group %s", name, group)
+}
+
+func (q *messageQueue) SetQueueMirror(name string, mirror *queue.MirrorConfig) error {
+	if name != "q1" {
+		return errors.NotFoundf("queue %s", name)
+	}
+	if mirror != nil && mirror.From == mirror.To {
+		return errors.NotValidf("mirror %+v", *mirror)
+	}
+	q.mirror = mirror
+	return nil
+}
+
+func (q *messageQueue) MirrorStatus(name string) (*queue.MirrorStatus, error) {
+	if name != "q1" || q.mirror == nil {
+		return nil, errors.NotFoundf("mirror of queue %s", name)
+	}
+	return &queue.MirrorStatus{Queue: name, MirrorConfig: *q.mirror, Lag: 10}, nil
+}
+
+func (q *messageQueue) PurgeQueue(name string) error {
+	switch name {
+	case "q1":
+		return nil
+	case "old":
+		return errors.NotSupportedf("purge topic with kafka version 0.10.0.0")
+	}
+	return errors.NotFoundf("queue : %q", name)
+}
+
+func TestPurgeQueueHandler(t *testing.T) {
+	s := &Server{queue: &messageQueue{}}
+	router := NewRouter()
+	router.DELETE("/queues/:queue/messages", s.purgeQueueHandler)
+
+	for name, want := range map[string]int{"q1": 200, "old": 501, "noexist": 404} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "http://example.com/queues/"+name+"/messages", nil)
+		router.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("purge %s expect %d, got %d %s", name, want, w.Code, w.Body)
+		}
+	}
+}
+
+func TestPauseGroupHandler(t *testing.T) {
+	q := &messageQueue{}
+	s := &Server{queue: q}
+	router := NewRouter()
+	router.PUT("/queue/:queue/:group/pause", s.pauseGroupHandler)
+	router.PUT("/queue/:queue/:group/resume", s.resumeGroupHandler)
+
+	put := func(path string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "http://example.com"+path, nil)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := put("/queue/q1/g1/pause"); code != 200 || !q.paused {
+		t.Fatalf("pause expect 200, got %d paused %v", code, q.paused)
+	}
+	if result, _ := s.msgReceive(context.Background(), "q1", "g1"); result != `{"action":"receive","paused":true}` {
+		t.Errorf("receive on paused group: %s", result)
+	}
+	if code := put("/queue/q1/g1/resume"); code != 200 || q.paused {
+		t.Fatalf("resume expect 200, got %d paused %v", code, q.paused)
+	}
+	if code := put("/queue/q1/noexist/pause"); code != 404 {
+		t.Errorf("pause unknown group expect 404, got %d", code)
+	}
+}
+
+func TestQueueMirrorHandler(t *testing.T) {
+	s := &Server{queue: &messageQueue{}}
+	router := NewRouter()
+	router.GET("/queues/:queue/mirror", s.getQueueMirrorHandler)
+	router.PUT("/queues/:queue/mirror", s.setQueueMirrorHandler)
+	router.DELETE("/queues/:queue/mirror", s.deleteQueueMirrorHandler)
+
+	do := func(method string, path string, body string) (int, string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "http://example.com"+path, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		msg := &ResponseMessage{}
+		json.NewDecoder(w.Body).Decode(msg)
+		return w.Code, msg.Message
+	}
+
+	if code, _ := do("GET", "/queues/q1/mirror", ""); code != 404 {
+		t.Errorf("expect 404 before mirror set, got %d", code)
+	}
+	if code, _ := do("PUT", "/queues/q1/mirror", `{"from":"tc","to":"tc"}`); code != 400 {
+		t.Errorf("expect 400 for same idc, got %d", code)
+	}
+	if code, msg := do("PUT", "/queues/q1/mirror", `{"from":"tc","to":"yf"}`); code != 200 {
+		t.Fatalf("set mirror expect 200, got %d %s", code, msg)
+	}
+
+	code, data := do("GET", "/queues/q1/mirror", "")
+	status := &queue.MirrorStatus{}
+	if err := json.Unmarshal([]byte(data), status); err != nil || code != 200 {
+		t.Fatalf("get mirror: %d %q %v", code, data, err)
+	}
+	if status.From != "tc" || status.To != "yf" || status.Lag != 10 {
+		t.Errorf("mirror status error: %+v", status)
+	}
+
+	if code, _ := do("DELETE", "/queues/q1/mirror", ""); code != 200 {
+		t.Errorf("delete mirror expect 200, got %d", code)
+	}
+	if code, _ := do("GET", "/queues/q1/mirror", ""); code != 404 {
+		t.Errorf("expect 404 after mirror deleted, got %d", code)
+	}
+}
+
+func TestGetMessageAtHandler(t *testing.T) {
+	s := &Server{queue: &messageQueue{}}
+	router := NewRouter()
+	router.GET("/queues/:queue/messages/:partition/:offset", s.getMessageAtHandler)
+
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://example.com"+path, nil)
+		router.ServeHTTP(w, req)
+		msg := &ResponseMessage{}
+		json.NewDecoder(w.Body).Decode(msg)
+		return w.Code, msg.Message
+	}
+
+	code, data := get("/queues/q1/messages/2/100")
+	if code != 200 {
+		t.Fatalf("expect 200, got %d %s", code, data)
+	}
+	info := &queue.MessageInfo{}
+	if err := json.Unmarshal([]byte(data), info); err != nil || info.Partition != 2 || info.Data != "hello" {
+		t.Errorf("message error: %q %v", data, err)
+	}
+
+	if code, _ := get("/queues/q1/messages/2/99"); code != 404 {
+		t.Errorf("expect 404 for missing offset, got %d", code)
+	}
+	if code, _ := get("/queues/q1/messages/x/100"); code != 400 {
+		t.Errorf("expect 400 for bad partition, got %d", code)
+	}
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// router.PUT("/queues/:queue/trace", s.auth(admin, s.setQueueTraceHandler))
+func (s *Server) setQueueTraceHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	attr := &TraceAttr{}
+	if err := json.NewDecoder(r.Body).Decode(attr); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+	configResponse(w, s.queue.SetQueueMessageTrace(ps.ByName("queue"), attr.Enable))
+}
+
+// 发送和接收返回的消息id都可以查询, 没有记录时返回空列表
+// router.GET("/messages/:id/trace", s.auth(admin, s.messageTraceHandler))
+func (s *Server) messageTraceHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	events, err := s.queue.MessageTrace(ps.ByName("id"))
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(events)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
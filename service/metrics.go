@@ -0,0 +1,238 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/juju/errors"
+)
+
+// 一次查询最多的queue/group数
+const maxMetricsTargets = 500
+
+type metricsTarget struct {
+	queue string
+	group string
+}
+
+func checkMetricsType(action string, typ string) string {
+	switch action {
+	case metrics.CmdSet, metrics.CmdGet:
+	default:
+		return fmt.Sprintf("not support action: %s", action)
+	}
+
+	switch typ {
+	case metrics.Qps, metrics.Elapsed, metrics.Latency:
+	default:
+		return fmt.Sprintf("not support type: %s", typ)
+	}
+	return ""
+}
+
+// 默认查询最近4小时的数据
+func parseMetricsRange(r *http.Request) (start, end, step int64, err error) {
+	now := time.Now()
+	start, end, step = now.Add(-4*time.Hour).Unix(), now.Unix(), 240
+	if v := r.FormValue("start"); v != "" {
+		if start, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return
+		}
+	}
+	if v := r.FormValue("end"); v != "" {
+		if end, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return
+		}
+	}
+	if v := r.FormValue("step"); v != "" {
+		step, err = strconv.ParseInt(v, 10, 64)
+	}
+	return
+}
+
+// targets为逗号分隔的queue.group, queue和group都支持通配符(path.Match的格式), eg: order.*,*.billing
+func (s *Server) metricsTargets(spec string) ([]metricsTarget, error) {
+	var all []metricsTarget
+	targets := make([]metricsTarget, 0)
+	seen := make(map[metricsTarget]bool)
+	add := func(t metricsTarget) error {
+		if seen[t] {
+			return nil
+		}
+		if len(targets) >= maxMetricsTargets {
+			return errors.NotValidf("more than %d targets", maxMetricsTargets)
+		}
+		seen[t] = true
+		targets = append(targets, t)
+		return nil
+	}
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ".", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.NotValidf("target %q", item)
+		}
+		target := metricsTarget{queue: parts[0], group: parts[1]}
+		if !strings.ContainsAny(item, "*?[") {
+			if _, err := s.queue.GetSingleGroup(target.group, target.queue); err != nil {
+				return nil, err
+			}
+			if err := add(target); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if all == nil {
+			infos, err := s.queue.Lookup("", "")
+			if err != nil {
+				return nil, err
+			}
+			all = make([]metricsTarget, 0)
+			for _, info := range infos {
+				for _, g := range info.Groups {
+					all = append(all, metricsTarget{queue: info.Queue, group: g.Group})
+				}
+			}
+		}
+		for _, t := range all {
+			qok, err := path.Match(target.queue, t.queue)
+			if err != nil {
+				return nil, errors.NotValidf("target %q", item)
+			}
+			gok, err := path.Match(target.group, t.group)
+			if err != nil {
+				return nil, errors.NotValidf("target %q", item)
+			}
+			if !qok || !gok {
+				continue
+			}
+			if err := add(t); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return nil, errors.NotFoundf("queue/group of targets %q", spec)
+	}
+	return targets, nil
+}
+
+// 一次查询多个queue/group的统计信息, 按相同的时间点对齐后返回
+func (s *Server) getMultiMetricsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	action := ps.ByName("action")
+	typ := ps.ByName("type")
+	if msg := checkMetricsType(action, typ); msg != "" {
+		response(w, 400, msg)
+		return
+	}
+
+	format, err := metricsFormat(r)
+	if err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	targets, err := s.metricsTargets(r.FormValue("targets"))
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+
+	start, end, step, err := parseMetricsRange(r)
+	if err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	metricsKey, err := clientMetricsKey(r.FormValue("client"), typ)
+	if err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	host := r.FormValue("host")
+	if host == "" {
+		host = metrics.AllHost
+	}
+
+	params := make([]*metrics.QueryParam, 0, len(targets))
+	for _, t := range targets {
+		params = append(params, &metrics.QueryParam{
+			Host:       host,
+			Queue:      t.queue,
+			Group:      t.group,
+			ActionKey:  action,
+			MetricsKey: metricsKey,
+			StartTime:  start,
+			EndTime:    end,
+			Step:       step,
+		})
+	}
+
+	data, err := metrics.ExportMetricsSeries(params, typ != metrics.Qps, format)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	if format == metrics.FormatJSON {
+		response(w, 200, data)
+		return
+	}
+	exportResponse(w, format, data)
+}
+
+// 导出格式由参数format指定, 没有时根据Accept头选择, 默认为json
+func metricsFormat(r *http.Request) (string, error) {
+	format := r.FormValue("format")
+	if format == "" {
+		accept := r.Header.Get("Accept")
+		switch {
+		case strings.Contains(accept, "text/csv"):
+			format = metrics.FormatCSV
+		case strings.Contains(accept, "application/openmetrics-text"):
+			format = metrics.FormatOpenMetrics
+		default:
+			format = metrics.FormatJSON
+		}
+	}
+	switch format {
+	case metrics.FormatJSON, metrics.FormatCSV, metrics.FormatOpenMetrics:
+		return format, nil
+	}
+	return "", errors.NotValidf("format %q", format)
+}
+
+// csv和openmetrics直接返回数据, 不包装为ResponseMessage
+func exportResponse(w http.ResponseWriter, format string, data string) {
+	w.Header().Set("Content-Type", metrics.ContentType(format))
+	w.WriteHeader(200)
+	w.Write([]byte(data))
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/weibocom/wqs/engine/queue"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/juju/errors"
+)
+
+type metricsQueue struct {
+	queue.Queue
+	infos []*queue.QueueInfo
+}
+
+func (q *metricsQueue) Lookup(name string, group string) ([]*queue.QueueInfo, error) {
+	return q.infos, nil
+}
+
+func (q *metricsQueue) GetSingleGroup(group string, name string) (*queue.GroupConfig, error) {
+	for _, info := range q.infos {
+		for i := range info.Groups {
+			if info.Queue == name && info.Groups[i].Group == group {
+				return &info.Groups[i], nil
+			}
+		}
+	}
+	return nil, errors.NotFoundf("queue: %q, group : %q", name, group)
+}
+
+func TestMetricsTargets(t *testing.T) {
+	s := &Server{queue: &metricsQueue{infos: []*queue.QueueInfo{
+		{Queue: "order", Groups: []queue.GroupConfig{{Group: "billing"}, {Group: "mail"}}},
+		{Queue: "user", Groups: []queue.GroupConfig{{Group: "billing"}}},
+	}}}
+
+	targets, err := s.metricsTargets("order.mail, *.billing,order.*")
+	if err != nil {
+		t.Fatalf("metrics targets error: %v", err)
+	}
+	want := []metricsTarget{{"order", "mail"}, {"order", "billing"}, {"user", "billing"}}
+	if !reflect.DeepEqual(targets, want) {
+		t.Errorf("got targets %v, want %v", targets, want)
+	}
+
+	if _, err = s.metricsTargets("order.none"); !errors.IsNotFound(err) {
+		t.Errorf("expect not found for unknown group, got %v", err)
+	}
+	if _, err = s.metricsTargets("none.*"); !errors.IsNotFound(err) {
+		t.Errorf("expect not found when nothing matches, got %v", err)
+	}
+	for _, spec := range []string{"order", "order.[", ".mail"} {
+		if _, err = s.metricsTargets(spec); !errors.IsNotValid(err) {
+			t.Errorf("expect not valid for %q, got %v", spec, err)
+		}
+	}
+}
+
+func TestParseMetricsRange(t *testing.T) {
+	r := httptest.NewRequest("GET", "/metrics/SET/qps?start=100&end=200&step=10", nil)
+	start, end, step, err := parseMetricsRange(r)
+	if err != nil || start != 100 || end != 200 || step != 10 {
+		t.Errorf("unexpected range %d %d %d %v", start, end, step, err)
+	}
+
+	r = httptest.NewRequest("GET", "/metrics/SET/qps", nil)
+	start, end, step, err = parseMetricsRange(r)
+	if err != nil || end-start != 4*3600 || step != 240 {
+		t.Errorf("unexpected default range %d %d %d %v", start, end, step, err)
+	}
+
+	r = httptest.NewRequest("GET", "/metrics/SET/qps?step=x", nil)
+	if _, _, _, err = parseMetricsRange(r); err == nil {
+		t.Errorf("expect error for invalid step")
+	}
+}
+
+func TestMetricsFormat(t *testing.T) {
+	cases := []struct {
+		query, accept, format string
+	}{
+		{"", "", metrics.FormatJSON},
+		{"", "text/csv", metrics.FormatCSV},
+		{"", "application/openmetrics-text; version=1.0.0", metrics.FormatOpenMetrics},
+		{"?format=csv", "application/openmetrics-text", metrics.FormatCSV},
+		{"?format=json", "text/csv", metrics.FormatJSON},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/metrics/SET/qps"+c.query, nil)
+		r.Header.Set("Accept", c.accept)
+		if format, err := metricsFormat(r); err != nil || format != c.format {
+			t.Errorf("%q %q: got %s %v, want %s", c.query, c.accept, format, err, c.format)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/metrics/SET/qps?format=xml", nil)
+	if _, err := metricsFormat(r); !errors.IsNotValid(err) {
+		t.Errorf("expect not valid for unknown format, got %v", err)
+	}
+}
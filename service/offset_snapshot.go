@@ -0,0 +1,73 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// router.GET("/queue/:queue/:group/snapshots", s.auth(admin, s.getOffsetSnapshotsHandler))
+func (s *Server) getOffsetSnapshotsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	snapshots, err := s.queue.OffsetSnapshots(ps.ByName("queue"), ps.ByName("group"))
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(snapshots)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
+// router.GET("/queue/:queue/:group/snapshots/:name", s.auth(admin, s.getOffsetSnapshotHandler))
+func (s *Server) getOffsetSnapshotHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	snapshot, err := s.queue.GetOffsetSnapshot(ps.ByName("queue"), ps.ByName("group"), ps.ByName("name"))
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, snapshot.String())
+}
+
+// 记录group当前已提交的offset, 同名快照已存在时返回409
+// router.PUT("/queue/:queue/:group/snapshots/:name", s.auth(admin, s.createOffsetSnapshotHandler))
+func (s *Server) createOffsetSnapshotHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	snapshot, err := s.queue.SnapshotOffsets(ps.ByName("queue"), ps.ByName("group"), ps.ByName("name"))
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, snapshot.String())
+}
+
+// router.DELETE("/queue/:queue/:group/snapshots/:name", s.auth(admin, s.deleteOffsetSnapshotHandler))
+func (s *Server) deleteOffsetSnapshotHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	configResponse(w, s.queue.DeleteOffsetSnapshot(ps.ByName("queue"), ps.ByName("group"), ps.ByName("name")))
+}
+
+// router.POST("/queue/:queue/:group/snapshots/:name/restore", s.auth(admin, s.restoreOffsetSnapshotHandler))
+func (s *Server) restoreOffsetSnapshotHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	configResponse(w, s.queue.RestoreOffsets(ps.ByName("queue"), ps.ByName("group"), ps.ByName("name")))
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// 立即检查一次孤立的资源, 不做清理
+// router.GET("/orphans", s.auth(admin, s.getOrphansHandler))
+func (s *Server) getOrphansHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	report, err := s.queue.FindOrphans()
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func partitionParam(ps httprouter.Params) (int32, bool) {
+	partition, err := strconv.ParseInt(ps.ByName("partition"), 10, 32)
+	return int32(partition), err == nil && partition >= 0
+}
+
+// 指定partition接收消息, offset默认从group已提交的位置开始, limit默认为20
+// router.GET("/queue/:queue/:group/partitions/:partition", s.recvPartitionHandler)
+func (s *Server) recvPartitionHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	partition, ok := partitionParam(ps)
+	if !ok {
+		response(w, 400, "invalid partition "+ps.ByName("partition"))
+		return
+	}
+	offset, err := formInt64(r, "offset", -1)
+	if err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+	limit, err := formInt64(r, "limit", 20)
+	if err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	page, err := s.queue.RecvPartition(r.Context(), ps.ByName("queue"), ps.ByName("group"), partition, offset, int(limit))
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(page)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
+// router.PUT("/queue/:queue/:group/partitions/:partition/offset", s.commitPartitionOffsetHandler)
+func (s *Server) commitPartitionOffsetHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	partition, ok := partitionParam(ps)
+	if !ok {
+		response(w, 400, "invalid partition "+ps.ByName("partition"))
+		return
+	}
+	attr := &PartitionOffsetAttr{}
+	if err := json.NewDecoder(r.Body).Decode(attr); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	configResponse(w, s.queue.CommitPartitionOffset(ps.ByName("queue"), ps.ByName("group"), partition, attr.Offset))
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestPartitionParam(t *testing.T) {
+	for value, want := range map[string]bool{"0": true, "12": true, "-1": false, "x": false, "": false, "4294967296": false} {
+		ps := httprouter.Params{{Key: "partition", Value: value}}
+		if _, ok := partitionParam(ps); ok != want {
+			t.Errorf("partition %q valid %v, want %v", value, ok, want)
+		}
+	}
+
+	s := &Server{}
+	router := httprouter.New()
+	router.GET("/queue/:queue/:group/partitions/:partition", s.recvPartitionHandler)
+	router.PUT("/queue/:queue/:group/partitions/:partition/offset", s.commitPartitionOffsetHandler)
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/queue/q1/g1/partitions/x", nil),
+		httptest.NewRequest("GET", "/queue/q1/g1/partitions/1?offset=a", nil),
+		httptest.NewRequest("PUT", "/queue/q1/g1/partitions/1/offset", strings.NewReader("{")),
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if !strings.Contains(w.Body.String(), `"code":400`) {
+			t.Errorf("%s %s: %s", req.Method, req.URL, w.Body.String())
+		}
+	}
+}
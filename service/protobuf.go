@@ -0,0 +1,203 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/weibocom/wqs/engine/queue"
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/tracing"
+
+	"github.com/juju/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const ContentTypeProtobuf = "application/x-protobuf"
+
+// 对应msg.proto中的Request
+type msgRequest struct {
+	Action     string
+	Queue      string
+	Group      string
+	Msg        []byte
+	MsgID      string
+	Instance   string
+	Headers    map[string]string
+	Durability string
+}
+
+// 对应msg.proto中的Response
+type msgResponse struct {
+	Result     bool
+	Msg        []byte
+	ID         string
+	Error      string
+	Paused     bool
+	Unassigned bool
+	ErrorCode  queue.ErrorCode
+	Retryable  bool
+}
+
+func isProtobuf(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), ContentTypeProtobuf)
+}
+
+// 只有length-delimited类型的字段, 其他类型和未知的字段跳过, 兼容新版本的客户端
+func consumeFields(b []byte, f func(num protowire.Number, v []byte)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ == protowire.BytesType {
+			var v []byte
+			if v, n = protowire.ConsumeBytes(b); n >= 0 {
+				f(num, v)
+			}
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func (r *msgRequest) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, v []byte) {
+		switch num {
+		case 1:
+			r.Action = string(v)
+		case 2:
+			r.Queue = string(v)
+		case 3:
+			r.Group = string(v)
+		case 4:
+			r.Msg = append([]byte(nil), v...)
+		case 5:
+			r.MsgID = string(v)
+		case 6:
+			r.Instance = string(v)
+		case 7:
+			var key, value string
+			consumeFields(v, func(num protowire.Number, v []byte) {
+				switch num {
+				case 1:
+					key = string(v)
+				case 2:
+					value = string(v)
+				}
+			})
+			if key != "" {
+				if r.Headers == nil {
+					r.Headers = make(map[string]string)
+				}
+				r.Headers[key] = value
+			}
+		case 8:
+			r.Durability = string(v)
+		}
+	})
+}
+
+// proto3的默认值不编码, 编码结果追加到b之后
+func (r *msgResponse) marshal(b []byte) []byte {
+	appendBool := func(num protowire.Number, v bool) {
+		if v {
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, protowire.EncodeBool(v))
+		}
+	}
+	appendBytes := func(num protowire.Number, v []byte) {
+		if len(v) > 0 {
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendBytes(b, v)
+		}
+	}
+	appendBool(1, r.Result)
+	appendBytes(2, r.Msg)
+	appendBytes(3, []byte(r.ID))
+	appendBytes(4, []byte(r.Error))
+	appendBool(5, r.Paused)
+	appendBool(6, r.Unassigned)
+	appendBytes(7, []byte(r.ErrorCode))
+	appendBool(8, r.Retryable)
+	return b
+}
+
+// 与表单格式的/msg语义相同, 消息内容为二进制, 不需要转义; 发送和接收时额外返回消息id
+func (s *Server) msgProtoHandler(w http.ResponseWriter, r *http.Request) {
+
+	// 解析时会复制请求中的字段, 解析后请求体的buffer即可复用
+	buf := getBuffer()
+	defer putBuffer(buf)
+	_, err := buf.ReadFrom(r.Body)
+	if err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+	req := &msgRequest{}
+	if err = req.unmarshal(buf.Bytes()); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	resp := &msgResponse{}
+	switch req.Action {
+	case "receive":
+		ctx := withInstance(tracing.WithMessage(r.Context()), req.Instance)
+		resp.ID, resp.Msg, _, err = s.queue.RecvMessage(ctx, req.Queue, req.Group)
+		if err == nil {
+			if err = s.queue.AckMessage(ctx, req.Queue, req.Group, resp.ID); err != nil {
+				log.Warnf("ack message queue:%q group:%q id:%q err:%s", req.Queue, req.Group, resp.ID, err)
+				resp.Msg = nil
+			}
+		}
+		resp.Paused, resp.Unassigned = queue.IsPaused(err), queue.IsUnassigned(err)
+		tracing.InjectHTTP(tracing.Message(ctx), w.Header())
+	case "send":
+		ctx := r.Context()
+		if len(req.Headers) > 0 {
+			ctx = queue.WithHeaders(ctx, req.Headers)
+		}
+		ctx = withDurability(ctx, req.Durability)
+		resp.ID, err = s.queue.SendMessageWithID(ctx, req.Queue, req.Group, req.Msg, 0, req.MsgID)
+	case "ack":
+	default:
+		err = errors.NotSupportedf("action %q", req.Action)
+	}
+
+	if err != nil {
+		log.Debugf("msgProtoHandler %s failed: %s", req.Action, errors.ErrorStack(err))
+		resp.Error = err.Error()
+		resp.ErrorCode = queue.ErrorCodeOf(err)
+		resp.Retryable = resp.ErrorCode.Retryable()
+	} else {
+		resp.Result = true
+	}
+	w.Header().Set("Content-Type", ContentTypeProtobuf)
+	if status := backpressure(w, err); status != 0 {
+		w.WriteHeader(status)
+	}
+	buf.Reset()
+	w.Write(resp.marshal(buf.Bytes()))
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/weibocom/wqs/engine/queue"
+
+	"github.com/juju/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type protoQueue struct {
+	queue.Queue
+	sent  []byte
+	acked string
+}
+
+func (q *protoQueue) SendMessageWithID(ctx context.Context, name string, group string, data []byte, flag uint64, msgID string) (string, error) {
+	if name != "q1" {
+		return "", errors.NotFoundf("queue : %q", name)
+	}
+	q.sent = data
+	return "id-" + msgID, nil
+}
+
+func (q *protoQueue) RecvMessage(ctx context.Context, name string, group string) (string, []byte, uint64, error) {
+	if group == "paused" {
+		return "", nil, 0, errors.Annotatef(queue.ErrGroupPaused, "queue %q group %q", name, group)
+	}
+	return "id-1", []byte{0, 1, '"', 2}, 0, nil
+}
+
+func (q *protoQueue) AckMessage(ctx context.Context, name string, group string, id string) error {
+	q.acked = id
+	return nil
+}
+
+func protoField(b []byte, num protowire.Number, v string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func decodeResponse(t *testing.T, data []byte) *msgResponse {
+	resp := &msgResponse{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			t.Fatalf("bad response %q", data)
+		}
+		data = data[n:]
+		if typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(data)
+			switch num {
+			case 1:
+				resp.Result = v != 0
+			case 5:
+				resp.Paused = v != 0
+			case 6:
+				resp.Unassigned = v != 0
+			case 8:
+				resp.Retryable = v != 0
+			}
+			data = data[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(data)
+		switch num {
+		case 2:
+			resp.Msg = v
+		case 3:
+			resp.ID = string(v)
+		case 4:
+			resp.Error = string(v)
+		case 7:
+			resp.ErrorCode = queue.ErrorCode(v)
+		}
+		data = data[n:]
+	}
+	return resp
+}
+
+func TestMsgProtoHandler(t *testing.T) {
+	q := &protoQueue{}
+	s := &Server{queue: q}
+
+	post := func(body []byte) (int, *msgResponse) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "http://example.com/msg", bytes.NewReader(body))
+		req.Header.Set("Content-Type", ContentTypeProtobuf)
+		s.msgHandler(w, req)
+		if w.Code == 200 && w.Header().Get("Content-Type") != ContentTypeProtobuf {
+			t.Errorf("content type %q", w.Header().Get("Content-Type"))
+		}
+		return w.Code, decodeResponse(t, w.Body.Bytes())
+	}
+
+	header := protoField(protoField(nil, 1, "type"), 2, "order")
+	req := protoField(nil, 1, "send")
+	req = protoField(req, 2, "q1")
+	req = protoField(req, 3, "g1")
+	req = protoField(req, 4, "a&b=\n")
+	req = protoField(req, 5, "m1")
+	req = protowire.AppendTag(req, 7, protowire.BytesType)
+	req = protowire.AppendBytes(req, header)
+	req = protoField(req, 8, "all")
+	// 未知字段跳过
+	req = protowire.AppendTag(req, 20, protowire.VarintType)
+	req = protowire.AppendVarint(req, 1)
+
+	code, resp := post(req)
+	if code != 200 || !resp.Result || resp.ID != "id-m1" {
+		t.Fatalf("send: %d %+v", code, resp)
+	}
+	if string(q.sent) != "a&b=\n" {
+		t.Errorf("sent %q", q.sent)
+	}
+	parsed := &msgRequest{}
+	if err := parsed.unmarshal(req); err != nil || parsed.MsgID != "m1" || parsed.Headers["type"] != "order" || parsed.Durability != "all" {
+		t.Errorf("unmarshal %+v %v", parsed, err)
+	}
+
+	req = protoField(protoField(protoField(nil, 1, "receive"), 2, "q1"), 3, "g1")
+	if code, resp = post(req); code != 200 || !bytes.Equal(resp.Msg, []byte{0, 1, '"', 2}) || resp.ID != "id-1" || q.acked != "id-1" {
+		t.Errorf("receive: %d %+v acked %q", code, resp, q.acked)
+	}
+
+	req = protoField(protoField(protoField(nil, 1, "receive"), 2, "q1"), 3, "paused")
+	if _, resp = post(req); resp.Result || !resp.Paused || resp.Error == "" || resp.ErrorCode != queue.ErrCodeGroupPaused || !resp.Retryable {
+		t.Errorf("receive paused: %+v", resp)
+	}
+
+	req = protoField(protoField(nil, 1, "send"), 2, "noexist")
+	if _, resp = post(req); resp.Result || resp.Error == "" || resp.ErrorCode != queue.ErrCodeQueueNotFound || resp.Retryable {
+		t.Errorf("send to unknown queue: %+v", resp)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://example.com/msg", bytes.NewReader([]byte{0x0a, 0x10}))
+	r.Header.Set("Content-Type", ContentTypeProtobuf)
+	s.msgHandler(w, r)
+	if w.Code != 400 {
+		t.Errorf("truncated request expect 400, got %d", w.Code)
+	}
+}
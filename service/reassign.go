@@ -0,0 +1,76 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/weibocom/wqs/engine/queue"
+)
+
+// router.GET("/queues/:queue/reassignment", s.auth(admin, s.getReassignmentHandler))
+func (s *Server) getReassignmentHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	result, err := s.queue.GetReassignment(ps.ByName("queue"), r.FormValue("idc"))
+	reassignmentResponse(w, result, err)
+}
+
+// router.POST("/queues/:queue/reassignment", s.auth(admin, s.reassignQueueHandler))
+func (s *Server) reassignQueueHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	req := queue.ReassignRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+	result, err := s.queue.ReassignQueue(ps.ByName("queue"), req)
+	reassignmentResponse(w, result, err)
+}
+
+// partitions为逗号分隔的partition id, 为空时选举全部partition
+// router.POST("/queues/:queue/leaders", s.auth(admin, s.electLeadersHandler))
+func (s *Server) electLeadersHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	var partitions []int32
+	if value := r.FormValue("partitions"); value != "" {
+		for _, item := range strings.Split(value, ",") {
+			partition, err := strconv.ParseInt(strings.TrimSpace(item), 10, 32)
+			if err != nil || partition < 0 {
+				response(w, 400, "invalid partition "+item)
+				return
+			}
+			partitions = append(partitions, int32(partition))
+		}
+	}
+	configResponse(w, s.queue.ElectPreferredLeaders(ps.ByName("queue"), r.FormValue("idc"), partitions))
+}
+
+func reassignmentResponse(w http.ResponseWriter, result *queue.Reassignment, err error) {
+	if err != nil {
+		configResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
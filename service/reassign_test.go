@@ -0,0 +1,101 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/julienschmidt/httprouter"
+	"github.com/weibocom/wqs/engine/queue"
+)
+
+type reassignQueue struct {
+	queue.Queue
+	req        queue.ReassignRequest
+	idc        string
+	partitions []int32
+}
+
+func (q *reassignQueue) GetReassignment(name string, idc string) (*queue.Reassignment, error) {
+	if name != "q1" {
+		return nil, errors.NotFoundf("queue : %q", name)
+	}
+	return &queue.Reassignment{Idc: idc, Current: map[int32][]int32{0: {1, 2}}}, nil
+}
+
+func (q *reassignQueue) ReassignQueue(name string, req queue.ReassignRequest) (*queue.Reassignment, error) {
+	q.req = req
+	return &queue.Reassignment{Idc: req.Idc, Proposed: req.Partitions, Executed: !req.DryRun}, nil
+}
+
+func (q *reassignQueue) ElectPreferredLeaders(name string, idc string, partitions []int32) error {
+	q.idc, q.partitions = idc, partitions
+	return nil
+}
+
+func TestReassignHandlers(t *testing.T) {
+	q := &reassignQueue{}
+	s := &Server{queue: q}
+	handlers := map[string]httprouter.Handle{
+		"GET reassignment":  s.getReassignmentHandler,
+		"POST reassignment": s.reassignQueueHandler,
+		"POST leaders":      s.electLeadersHandler,
+	}
+
+	// path为/queues/[queue]/[action]?[query]
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "http://example.com"+path, strings.NewReader(body))
+		parts := strings.Split(req.URL.Path, "/")
+		ps := httprouter.Params{{Key: "queue", Value: parts[2]}}
+		handlers[method+" "+parts[3]](w, req, ps)
+		return w
+	}
+
+	if w := do("GET", "/queues/q1/reassignment?idc=bj", ""); w.Code != 200 ||
+		!strings.Contains(w.Body.String(), `\"idc\":\"bj\",\"current\":{\"0\":[1,2]}`) {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body)
+	}
+	if w := do("GET", "/queues/q2/reassignment", ""); w.Code != 404 {
+		t.Errorf("expect 404, got %d %s", w.Code, w.Body)
+	}
+
+	w := do("POST", "/queues/q1/reassignment", `{"idc":"bj","partitions":{"0":[2,3]},"dry_run":true}`)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `\"executed\":false`) {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body)
+	}
+	if !q.req.DryRun || !reflect.DeepEqual(q.req.Partitions, map[int32][]int32{0: {2, 3}}) {
+		t.Errorf("unexpected request %+v", q.req)
+	}
+	if w := do("POST", "/queues/q1/reassignment", `{"partitions":`); w.Code != 400 {
+		t.Errorf("expect 400, got %d %s", w.Code, w.Body)
+	}
+
+	if w := do("POST", "/queues/q1/leaders?idc=bj&partitions=0,2", ""); w.Code != 200 {
+		t.Errorf("expect 200, got %d %s", w.Code, w.Body)
+	}
+	if q.idc != "bj" || !reflect.DeepEqual(q.partitions, []int32{0, 2}) {
+		t.Errorf("unexpected election %s %v", q.idc, q.partitions)
+	}
+	if w := do("POST", "/queues/q1/leaders?partitions=a", ""); w.Code != 400 {
+		t.Errorf("expect 400, got %d %s", w.Code, w.Body)
+	}
+}
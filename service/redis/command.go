@@ -0,0 +1,269 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/engine/queue"
+	"github.com/weibocom/wqs/log"
+)
+
+const (
+	defaultGroup = "default"
+	// 阻塞读取时没有消息的重试间隔
+	blockInterval = 10 * time.Millisecond
+)
+
+type client struct {
+	r      *bufio.Reader
+	w      *bufio.Writer
+	authed bool
+}
+
+// 返回true时关闭连接
+type commandFunc func(s *Server, c *client, args [][]byte) bool
+
+// minArgs和maxArgs不包括命令名, maxArgs小于0时不限制
+type command struct {
+	minArgs int
+	maxArgs int
+	exec    commandFunc
+}
+
+var commands = map[string]command{
+	"ping":    {0, 1, commandPing},
+	"echo":    {1, 1, commandEcho},
+	"auth":    {1, 2, commandAuth},
+	"select":  {1, 1, commandOK},
+	"client":  {1, -1, commandOK},
+	"command": {0, -1, commandCommand},
+	"quit":    {0, 0, commandQuit},
+	"lpush":   {2, -1, commandPush},
+	"rpush":   {2, -1, commandPush},
+	"rpop":    {1, 1, commandPop},
+	"lpop":    {1, 1, commandPop},
+	"brpop":   {2, -1, commandBlockingPop},
+	"blpop":   {2, -1, commandBlockingPop},
+	"llen":    {1, 1, commandLen},
+}
+
+func (s *Server) exec(c *client, args [][]byte) bool {
+	name := strings.ToLower(string(args[0]))
+	cmd, ok := commands[name]
+	if !ok {
+		writeError(c.w, fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return false
+	}
+	if len(args)-1 < cmd.minArgs || (cmd.maxArgs >= 0 && len(args)-1 > cmd.maxArgs) {
+		writeError(c.w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return false
+	}
+	if !c.authed && name != "auth" && name != "quit" {
+		writeError(c.w, "NOAUTH Authentication required.")
+		return false
+	}
+	return cmd.exec(s, c, args[1:])
+}
+
+// key的格式为queue:group
+func parseKey(key []byte) (queue string, group string) {
+	k := strings.SplitN(string(key), ":", 2)
+	if len(k) == 2 && k[1] != "" {
+		return k[0], k[1]
+	}
+	return k[0], defaultGroup
+}
+
+func commandPing(s *Server, c *client, args [][]byte) bool {
+	if len(args) == 1 {
+		writeBulk(c.w, args[0])
+		return false
+	}
+	writeStatus(c.w, "PONG")
+	return false
+}
+
+func commandEcho(s *Server, c *client, args [][]byte) bool {
+	writeBulk(c.w, args[0])
+	return false
+}
+
+// 兼容AUTH <password>和AUTH <username> <password>, password为client权限的token.
+// 没有开启认证时直接返回OK, 已经配置了密码的应用不需要修改
+func commandAuth(s *Server, c *client, args [][]byte) bool {
+	if !s.authEnable {
+		writeStatus(c.w, "OK")
+		return false
+	}
+	if err := s.queue.Authorize(string(args[len(args)-1]), queue.RoleClient); err != nil {
+		c.authed = false
+		writeError(c.w, "WRONGPASS invalid token")
+		return false
+	}
+	c.authed = true
+	writeStatus(c.w, "OK")
+	return false
+}
+
+// SELECT、CLIENT SETNAME等客户端连接时发送的命令, 直接返回OK
+func commandOK(s *Server, c *client, args [][]byte) bool {
+	writeStatus(c.w, "OK")
+	return false
+}
+
+// redis-cli连接时会执行COMMAND DOCS, 返回空列表
+func commandCommand(s *Server, c *client, args [][]byte) bool {
+	writeArray(c.w, 0)
+	return false
+}
+
+func commandQuit(s *Server, c *client, args [][]byte) bool {
+	writeStatus(c.w, "OK")
+	return true
+}
+
+// 每个value作为一条消息发送, 返回发送成功的条数
+func commandPush(s *Server, c *client, args [][]byte) bool {
+	queue, group := parseKey(args[0])
+	for _, value := range args[1:] {
+		if _, err := s.queue.SendMessage(context.Background(), queue, group, value, 0); err != nil {
+			writeError(c.w, "ERR "+err.Error())
+			return false
+		}
+	}
+	writeInteger(c.w, int64(len(args)-1))
+	return false
+}
+
+// 没有消息时返回nil
+func commandPop(s *Server, c *client, args [][]byte) bool {
+	msg, err := s.receive(args[0])
+	if err != nil {
+		writeError(c.w, "ERR "+err.Error())
+		return false
+	}
+	if msg == nil {
+		writeBulk(c.w, nil)
+		return false
+	}
+	writeBulk(c.w, msg.data)
+	s.ackFlushed(c, msg)
+	return false
+}
+
+// BRPOP key [key ...] timeout, timeout单位为秒, 为0时一直等待到有消息或proxy停止.
+// 依次读取各个key, 返回[key, value], 超时返回nil array
+func commandBlockingPop(s *Server, c *client, args [][]byte) bool {
+	keys := args[:len(args)-1]
+	seconds, err := strconv.ParseFloat(string(args[len(args)-1]), 64)
+	if err != nil {
+		writeError(c.w, "ERR timeout is not a float or out of range")
+		return false
+	}
+	if seconds < 0 {
+		writeError(c.w, "ERR timeout is negative")
+		return false
+	}
+	var deadline time.Time
+	if seconds > 0 {
+		deadline = time.Now().Add(time.Duration(seconds * float64(time.Second)))
+	}
+
+	for {
+		for _, key := range keys {
+			msg, err := s.receive(key)
+			if err != nil {
+				writeError(c.w, "ERR "+err.Error())
+				return false
+			}
+			if msg != nil {
+				writeArray(c.w, 2)
+				writeBulk(c.w, key)
+				writeBulk(c.w, msg.data)
+				s.ackFlushed(c, msg)
+				return false
+			}
+		}
+		if atomic.LoadInt32(&s.stopping) != 0 || (!deadline.IsZero() && time.Now().After(deadline)) {
+			writeArray(c.w, -1)
+			return false
+		}
+		time.Sleep(blockInterval)
+	}
+}
+
+// 返回group未消费的消息数
+func commandLen(s *Server, c *client, args [][]byte) bool {
+	queue, group := parseKey(args[0])
+	infos, err := s.queue.AccumulationStatus()
+	if err != nil {
+		writeError(c.w, "ERR "+err.Error())
+		return false
+	}
+	var lag int64
+	for _, info := range infos {
+		if info.Queue == queue && info.Group == group {
+			lag = info.Total - info.Consumed
+			break
+		}
+	}
+	writeInteger(c.w, lag)
+	return false
+}
+
+type message struct {
+	queue string
+	group string
+	id    string
+	data  []byte
+}
+
+// 没有消息、queue暂停或者没有分配到partition时返回nil
+func (s *Server) receive(key []byte) (*message, error) {
+	name, group := parseKey(key)
+	id, data, _, err := s.queue.RecvMessage(context.Background(), name, group)
+	if err != nil {
+		if errors.Cause(err) == kafka.ErrTimeout || queue.IsPaused(err) || queue.IsUnassigned(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	return &message{queue: name, group: group, id: id, data: data}, nil
+}
+
+// 回复成功写入连接后才ack, 客户端断开时消息在超时后重新投递
+func (s *Server) ackFlushed(c *client, msg *message) {
+	if err := c.w.Flush(); err != nil {
+		log.Warnf("redis reply %s@%s message %s failed: %s", msg.queue, msg.group, msg.id, err)
+		return
+	}
+	if err := s.queue.AckMessage(context.Background(), msg.queue, msg.group, msg.id); err != nil {
+		log.Warnf("redis ack %s@%s message %s failed: %s", msg.queue, msg.group, msg.id, err)
+	}
+}
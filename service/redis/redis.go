@@ -0,0 +1,158 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// 提供redis协议的入口, 把redis的list命令映射为队列的收发, 使用redis list作为队列的应用只需要修改地址.
+// key的格式为queue:group, 没有group时使用default
+package redis
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/weibocom/wqs/engine/queue"
+	"github.com/weibocom/wqs/log"
+)
+
+type Server struct {
+	addr       string
+	authEnable bool
+	queue      queue.Queue
+	listener   net.Listener
+	stopping   int32
+	connPool   map[net.Conn]net.Conn
+	mu         sync.Mutex
+}
+
+// authEnable时客户端需要先执行AUTH <token>, token需要client权限
+func NewServer(q queue.Queue, addr string, authEnable bool) *Server {
+	return &Server{
+		addr:       addr,
+		authEnable: authEnable,
+		queue:      q,
+		connPool:   make(map[net.Conn]net.Conn),
+	}
+}
+
+func (s *Server) Start() error {
+	var err error
+	s.listener, err = net.Listen("tcp", s.addr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	log.Infof("redis protocol server start on %s", s.addr)
+	go s.mainLoop()
+	return nil
+}
+
+func (s *Server) mainLoop() {
+	for atomic.LoadInt32(&s.stopping) == 0 {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			log.Errorf("redis accept error: %s", err)
+			continue
+		}
+		if atomic.LoadInt32(&s.stopping) != 0 {
+			conn.Close()
+			return
+		}
+		s.mu.Lock()
+		s.connPool[conn] = conn
+		s.mu.Unlock()
+		go s.connLoop(conn)
+	}
+}
+
+func (s *Server) connLoop(conn net.Conn) {
+	defer func(conn net.Conn) {
+		s.mu.Lock()
+		delete(s.connPool, conn)
+		s.mu.Unlock()
+		conn.Close()
+		if err := recover(); err != nil {
+			log.Errorf("redis connLoop panic error: %s", err)
+		}
+	}(conn)
+
+	s.serve(bufio.NewReader(conn), bufio.NewWriter(conn))
+}
+
+func (s *Server) serve(r *bufio.Reader, w *bufio.Writer) {
+
+	c := &client{r: r, w: w, authed: !s.authEnable}
+	for atomic.LoadInt32(&s.stopping) == 0 {
+		args, err := readCommand(r)
+		if err != nil {
+			if errors.Cause(err) == errProtocol {
+				writeError(w, "ERR Protocol error: "+err.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		closing := s.exec(c, args)
+		if err = w.Flush(); err != nil || closing {
+			return
+		}
+	}
+}
+
+// close all connections of redis protocol server.
+func (s *Server) DrainConn() {
+	s.mu.Lock()
+	for _, conn := range s.connPool {
+		conn.Close()
+	}
+	s.mu.Unlock()
+}
+
+// 停止接收新连接, 等待正在执行的命令完成后关闭连接, 超过timeout时强制关闭.
+// 阻塞中的BRPOP/BLPOP返回nil
+func (s *Server) Stop(timeout time.Duration) {
+	if !atomic.CompareAndSwapInt32(&s.stopping, 0, 1) {
+		return
+	}
+	if err := s.listener.Close(); err != nil {
+		log.Errorf("redis listener close failed: %s", err)
+		return
+	}
+	s.mu.Lock()
+	for _, conn := range s.connPool {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	for deadline := time.Now().Add(timeout); s.conns() != 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			log.Warnf("redis drain timeout, close %d connections", s.conns())
+			s.DrainConn()
+			break
+		}
+	}
+	log.Info("redis protocol server stop.")
+}
+
+func (s *Server) conns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.connPool)
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/engine/queue"
+)
+
+type fakeQueue struct {
+	queue.Queue
+	sent  []string
+	msgs  []string
+	acked []string
+}
+
+func (q *fakeQueue) SendMessage(ctx context.Context, name string, group string, data []byte, flag uint64) (string, error) {
+	q.sent = append(q.sent, name+"@"+group+" "+string(data))
+	return "id", nil
+}
+
+func (q *fakeQueue) RecvMessage(ctx context.Context, name string, group string) (string, []byte, uint64, error) {
+	if name == "paused" {
+		return "", nil, 0, errors.Trace(queue.ErrGroupPaused)
+	}
+	if name != "q1" || len(q.msgs) == 0 {
+		return "", nil, 0, kafka.ErrTimeout
+	}
+	data := q.msgs[0]
+	q.msgs = q.msgs[1:]
+	return name + "@" + group + "-" + data, []byte(data), 0, nil
+}
+
+func (q *fakeQueue) AckMessage(ctx context.Context, name string, group string, id string) error {
+	q.acked = append(q.acked, id)
+	return nil
+}
+
+func (q *fakeQueue) Authorize(token string, role string) error {
+	if token != "secret" || role != queue.RoleClient {
+		return errors.Unauthorizedf("token")
+	}
+	return nil
+}
+
+func (q *fakeQueue) AccumulationStatus() ([]queue.AccumulationInfo, error) {
+	return []queue.AccumulationInfo{{Queue: "q1", Group: "g1", Total: 100, Consumed: 60}}, nil
+}
+
+func runRedis(q *fakeQueue, authEnable bool, input string) string {
+	s := NewServer(q, "", authEnable)
+	out := &bytes.Buffer{}
+	s.serve(bufio.NewReader(strings.NewReader(input)), bufio.NewWriter(out))
+	return out.String()
+}
+
+func TestReadCommand(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*3\r\n$5\r\nLPUSH\r\n$5\r\nq1:g1\r\n$7\r\nhel\r\nlo\r\nPING  hi\r\n*1\r\n$3\r\nabcde\r\n"))
+	args, err := readCommand(r)
+	if err != nil || len(args) != 3 || string(args[2]) != "hel\r\nlo" {
+		t.Fatalf("read multibulk error: %q %v", args, err)
+	}
+	args, err = readCommand(r)
+	if err != nil || len(args) != 2 || string(args[1]) != "hi" {
+		t.Fatalf("read inline error: %q %v", args, err)
+	}
+	if _, err = readCommand(r); errors.Cause(err) != errProtocol {
+		t.Errorf("bulk without CRLF should be protocol error, got %v", err)
+	}
+}
+
+func TestPushPop(t *testing.T) {
+	q := &fakeQueue{msgs: []string{"m1", "m2"}}
+	out := runRedis(q, false, "LPUSH q1:g1 a b\r\nRPUSH q1 c\r\nRPOP q1:g1\r\nBRPOP q2 q1:g1 1\r\nRPOP q1:g1\r\nBLPOP q1:g1 0.01\r\nRPOP paused:g1\r\nLLEN q1:g1\r\nrpop\r\n")
+	expect := ":2\r\n:1\r\n$2\r\nm1\r\n*2\r\n$5\r\nq1:g1\r\n$2\r\nm2\r\n$-1\r\n*-1\r\n$-1\r\n:40\r\n" +
+		"-ERR wrong number of arguments for 'rpop' command\r\n"
+	if out != expect {
+		t.Errorf("expect %q, got %q", expect, out)
+	}
+	if strings.Join(q.sent, ",") != "q1@g1 a,q1@g1 b,q1@default c" {
+		t.Errorf("sent messages error: %q", q.sent)
+	}
+	if strings.Join(q.acked, ",") != "q1@g1-m1,q1@g1-m2" {
+		t.Errorf("acked messages error: %q", q.acked)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	q := &fakeQueue{}
+	out := runRedis(q, true, "PING\r\nAUTH wrong\r\nAUTH default secret\r\nPING\r\nQUIT\r\nPING\r\n")
+	expect := "-NOAUTH Authentication required.\r\n-WRONGPASS invalid token\r\n+OK\r\n+PONG\r\n+OK\r\n"
+	if out != expect {
+		t.Errorf("expect %q, got %q", expect, out)
+	}
+
+	out = runRedis(q, false, "AUTH any\r\nSELECT 0\r\nnoexist\r\n")
+	expect = "+OK\r\n+OK\r\n-ERR unknown command 'noexist'\r\n"
+	if out != expect {
+		t.Errorf("expect %q, got %q", expect, out)
+	}
+}
+
+func TestStop(t *testing.T) {
+	s := NewServer(&fakeQueue{}, "127.0.0.1:0", false)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	idle, err := net.Dial("tcp", s.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	blocking, err := net.Dial("tcp", s.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocking.Close()
+	blocking.Write([]byte("BRPOP q3 0\r\n"))
+	for s.conns() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	start := time.Now()
+	s.Stop(5 * time.Second)
+	if cost := time.Since(start); cost > time.Second {
+		t.Errorf("stop should not wait for idle connections, cost %s", cost)
+	}
+	if data, _ := ioutil.ReadAll(blocking); string(data) != "*-1\r\n" {
+		t.Errorf("expect nil reply for blocking pop, got %q", data)
+	}
+	if data, _ := ioutil.ReadAll(idle); len(data) != 0 {
+		t.Errorf("expect idle connection closed, got %q", data)
+	}
+	if _, err = net.Dial("tcp", s.listener.Addr().String()); err == nil {
+		t.Error("expect new connection refused")
+	}
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/metrics"
+
+	"github.com/juju/errors"
+)
+
+// 不重启即可生效的配置项, 以.结尾的表示该前缀下的所有配置项
+var reloadableKeys = []string{"log.level", "metrics.", "alert.", "breaker.", "profile."}
+
+func reloadable(key string) bool {
+	for _, k := range reloadableKeys {
+		if key == k || strings.HasPrefix(key, k+".") || strings.HasSuffix(k, ".") && strings.HasPrefix(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// Applied为本次生效的配置项, Restart为与启动时不同但需要重启才能生效的配置项
+type ReloadResult struct {
+	Applied []string `json:"applied"`
+	Restart []string `json:"restart"`
+}
+
+// current为最后一次生效的配置, 为nil时表示启动后还没有重新加载过
+type reloader struct {
+	mu      sync.Mutex
+	source  config.Source
+	current *config.Config
+	dying   chan struct{}
+}
+
+// 每隔reload.interval秒检查一次配置是否变化, 为0时只在调用/config/reload接口或收到SIGHUP时加载
+func (s *Server) WatchConfig(source config.Source) {
+	s.reloader.mu.Lock()
+	s.reloader.source = source
+	s.reloader.mu.Unlock()
+
+	var interval int64
+	if section, err := s.config.GetSection("reload"); err == nil {
+		interval = section.GetInt64Must("interval", 0)
+	}
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := s.ReloadConfig(); err != nil {
+					log.Errorf("reload config from %s error: %s", source, errors.ErrorStack(err))
+				}
+			case <-s.reloader.dying:
+				return
+			}
+		}
+	}()
+}
+
+// 检查配置是否变化, 变化并且校验通过时立即生效
+func (s *Server) ReloadConfig() (*ReloadResult, error) {
+	s.reloader.mu.Lock()
+	defer s.reloader.mu.Unlock()
+
+	if s.reloader.source == nil {
+		return nil, errors.NotSupportedf("config reload without source")
+	}
+	conf, err := s.reloader.source.Changed()
+	if err != nil {
+		return nil, err
+	}
+	if conf != nil {
+		if err = conf.Check(); err != nil {
+			return nil, err
+		}
+	} else {
+		conf = s.reloader.current
+	}
+	if conf == nil {
+		conf = s.config
+	}
+	return s.applyConfig(conf)
+}
+
+// 与上一次加载的配置比较, 只重新加载变化的部分. 出错时已经生效的部分不会回滚.
+// 调用方持有s.reloader.mu
+func (s *Server) applyConfig(conf *config.Config) (*ReloadResult, error) {
+
+	result := &ReloadResult{Applied: make([]string, 0), Restart: make([]string, 0)}
+	for _, key := range config.Diff(s.config, conf) {
+		if !reloadable(key) {
+			result.Restart = append(result.Restart, key)
+		}
+	}
+
+	current := s.reloader.current
+	if current == nil {
+		current = s.config
+	}
+	var changed []string
+	for _, key := range config.Diff(current, conf) {
+		if reloadable(key) {
+			changed = append(changed, key)
+		}
+	}
+	if len(changed) == 0 {
+		s.reloader.current = conf
+		return result, nil
+	}
+
+	levels, err := parseLogLevels(conf)
+	if err != nil {
+		return nil, err
+	}
+	if hasKeyPrefix(changed, "metrics.") {
+		if err = metrics.Reload(conf); err != nil {
+			return nil, errors.Annotatef(err, "reload metrics")
+		}
+	}
+	restart, err := s.queue.Reload(conf)
+	if err != nil {
+		return nil, err
+	}
+	if hasKeyPrefix(changed, "log.level") {
+		levels.apply()
+	}
+
+	for _, key := range changed {
+		if containsKey(restart, key) {
+			result.Restart = append(result.Restart, key)
+		} else {
+			result.Applied = append(result.Applied, key)
+		}
+	}
+	s.reloader.current = conf
+	log.Infof("config reloaded, applied %v, restart required %v", result.Applied, result.Restart)
+	return result, nil
+}
+
+func hasKeyPrefix(keys []string, prefix string) bool {
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// router.POST("/config/reload", s.reloadConfigHandler)
+func (s *Server) reloadConfigHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	result, err := s.ReloadConfig()
+	if err != nil {
+		configResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/engine/queue"
+	"github.com/weibocom/wqs/log"
+)
+
+const testReloadConfig = `proxy.id=1
+ui.dir=./ui
+protocol.http.port=8080
+protocol.mc.port=11211
+protocol.motan.port=8881
+metadata.zookeeper.connect=localhost:2181
+metadata.zookeeper.root=/
+log.info=info.log
+log.debug=debug.log
+log.profile=profile.log
+`
+
+type reloadQueue struct {
+	queue.Queue
+	restart []string
+	reloads int
+}
+
+func (q *reloadQueue) Reload(conf *config.Config) ([]string, error) {
+	q.reloads++
+	return q.restart, nil
+}
+
+func TestReloadable(t *testing.T) {
+	for key, expect := range map[string]bool{
+		"log.level":           true,
+		"log.level.queue":     true,
+		"log.info":            false,
+		"alert.lag.threshold": true,
+		"breaker.failures":    true,
+		"protocol.http.port":  false,
+		"metrics.kafka.topic": true,
+	} {
+		if reloadable(key) != expect {
+			t.Errorf("key %s expect reloadable %v", key, expect)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	defer log.ResetModuleLevel(log.ModuleQueue)
+
+	startup, err := config.NewConfigFromBytes([]byte(testReloadConfig + "breaker.failures=20\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := &reloadQueue{restart: []string{"breaker.failures"}}
+	s := &Server{config: startup, queue: q}
+
+	conf, _ := config.NewConfigFromBytes([]byte(testReloadConfig +
+		"breaker.failures=0\nalert.lag.threshold=10\nlog.level.queue=debug\n"))
+	conf.GetSections()["protocol"]["http.port"] = "8081"
+	result, err := s.applyConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(result.Applied); got != "[alert.lag.threshold log.level.queue]" {
+		t.Errorf("unexpected applied keys %s", got)
+	}
+	if got := fmt.Sprint(result.Restart); got != "[protocol.http.port breaker.failures]" {
+		t.Errorf("unexpected restart keys %s", got)
+	}
+	if level, ok := log.GetModuleLevel(log.ModuleQueue); !ok || level != log.LogDebug {
+		t.Errorf("expect queue log level debug, got %d %v", level, ok)
+	}
+
+	// 没有变化时不重新加载, 但仍然报告需要重启的配置
+	if result, err = s.applyConfig(conf); err != nil || len(result.Applied) != 0 || len(result.Restart) != 1 {
+		t.Errorf("unexpected result %+v %v", result, err)
+	}
+	if q.reloads != 1 {
+		t.Errorf("expect queue reloaded once, got %d", q.reloads)
+	}
+
+	conf, _ = config.NewConfigFromBytes([]byte(testReloadConfig + "log.level=verbose\n"))
+	if _, err = s.applyConfig(conf); err == nil {
+		t.Error("expect invalid log level")
+	}
+}
@@ -0,0 +1,511 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/engine/queue"
+
+	"github.com/juju/errors"
+)
+
+// 兼容Confluent REST Proxy v2的produce和consume接口, topic对应queue, consumer group对应group.
+// 发送时使用default group, consumer实例只保存在创建它的proxy上
+const (
+	restContentType   = "application/vnd.kafka.v2+json"
+	restFormatJSON    = "json"
+	restFormatBinary  = "binary"
+	restProduceGroup  = "default"
+	restMaxRecords    = 100
+	restMaxBytes      = 64 << 20
+	restDefaultWait   = time.Second
+	restPollInterval  = 20 * time.Millisecond
+	restInstanceIdle  = 5 * time.Minute
+	restErrTopic      = 40401
+	restErrInstance   = 40403
+	restErrMediaType  = 41501
+	restErrInvalid    = 42201
+	restErrKafka      = 50001
+	restErrRetriable  = 50002
+	restMsgMissingArg = "missing or invalid argument"
+)
+
+type restError struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+func restResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", restContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// http状态码为错误码的前三位
+func restFail(w http.ResponseWriter, code int, message string) {
+	restResponse(w, code/100, &restError{ErrorCode: code, Message: message})
+}
+
+func restErrorCode(err error) int {
+	switch {
+	case errors.IsNotFound(err):
+		return restErrTopic
+	case errors.IsNotValid(err), queue.IsMessageTooLarge(err):
+		return restErrInvalid
+	case queue.IsThrottled(err), queue.IsQuotaExceeded(err), isCircuitOpen(err):
+		return restErrRetriable
+	}
+	return restErrKafka
+}
+
+// 请求体的格式由Content-Type决定, application/json按json处理
+func restFormat(contentType string) (string, bool) {
+	switch strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]) {
+	case "application/vnd.kafka.json.v2+json", "application/json":
+		return restFormatJSON, true
+	case "application/vnd.kafka.binary.v2+json":
+		return restFormatBinary, true
+	}
+	return "", false
+}
+
+type restRecord struct {
+	Key       json.RawMessage `json:"key,omitempty"`
+	Value     json.RawMessage `json:"value"`
+	Partition *int32          `json:"partition,omitempty"`
+}
+
+type restOffset struct {
+	Partition *int32  `json:"partition"`
+	Offset    *int64  `json:"offset"`
+	ErrorCode *int    `json:"error_code"`
+	Error     *string `json:"error"`
+}
+
+// json格式的value原样写入, binary格式为base64编码的字符串
+func restDecodeValue(format string, value json.RawMessage) ([]byte, error) {
+	if len(value) == 0 || string(value) == "null" {
+		return nil, errors.NotValidf("empty value")
+	}
+	if format == restFormatJSON {
+		return []byte(value), nil
+	}
+	var encoded string
+	if err := json.Unmarshal(value, &encoded); err != nil {
+		return nil, errors.NewNotValid(err, "binary value")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.NewNotValid(err, "binary value")
+	}
+	return data, nil
+}
+
+func restEncodeValue(format string, data []byte) json.RawMessage {
+	var v interface{} = base64.StdEncoding.EncodeToString(data)
+	if format == restFormatJSON {
+		if json.Valid(data) {
+			return json.RawMessage(data)
+		}
+		v = string(data)
+	}
+	encoded, _ := json.Marshal(v)
+	return encoded
+}
+
+// 不支持key和指定partition, 每条消息单独返回写入的位置或者错误
+// router.POST("/topics/:queue", s.auth(client, s.restProduceHandler))
+func (s *Server) restProduceHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	format, ok := restFormat(r.Header.Get("Content-Type"))
+	if !ok {
+		restFail(w, restErrMediaType, "unsupported media type "+r.Header.Get("Content-Type"))
+		return
+	}
+	body := &struct {
+		Records []restRecord `json:"records"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil || len(body.Records) == 0 {
+		restFail(w, restErrInvalid, restMsgMissingArg)
+		return
+	}
+
+	name := ps.ByName("queue")
+	offsets := make([]restOffset, 0, len(body.Records))
+	for i, record := range body.Records {
+		data, err := restDecodeValue(format, record.Value)
+		var id string
+		if err == nil {
+			id, err = s.queue.SendMessage(r.Context(), name, restProduceGroup, data, 0)
+		}
+		if err != nil {
+			// 第一条就失败且queue不存在时整个请求返回404
+			if i == 0 && errors.IsNotFound(err) {
+				restFail(w, restErrTopic, err.Error())
+				return
+			}
+			code, message := restErrorCode(err), err.Error()
+			offsets = append(offsets, restOffset{ErrorCode: &code, Error: &message})
+			continue
+		}
+		partition, offset, _ := queue.MessagePosition(id)
+		offsets = append(offsets, restOffset{Partition: &partition, Offset: &offset})
+	}
+	restResponse(w, 200, map[string]interface{}{
+		"key_schema_id":   nil,
+		"value_schema_id": nil,
+		"offsets":         offsets,
+	})
+}
+
+type restPartition struct {
+	topic     string
+	partition int32
+}
+
+type restFetched struct {
+	offset int64
+	id     string
+}
+
+// consumer实例, autoCommit时返回消息后直接ack, 否则提交offset时ack该位置及之前返回的消息
+type restConsumer struct {
+	group      string
+	format     string
+	autoCommit bool
+	topics     []string
+	next       int
+	pending    map[restPartition][]restFetched
+	lastUsed   time.Time
+	mu         sync.Mutex
+}
+
+// 超过restInstanceIdle没有使用的实例在创建新实例时清理, 未ack的消息在超时后重新投递
+type restConsumers struct {
+	mu        sync.Mutex
+	instances map[string]*restConsumer
+}
+
+func newRESTConsumers() *restConsumers {
+	return &restConsumers{instances: make(map[string]*restConsumer)}
+}
+
+func (c *restConsumers) add(group string, name string, consumer *restConsumer) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for key, instance := range c.instances {
+		instance.mu.Lock()
+		idle := now.Sub(instance.lastUsed)
+		instance.mu.Unlock()
+		if idle > restInstanceIdle {
+			delete(c.instances, key)
+		}
+	}
+	if _, ok := c.instances[group+"/"+name]; ok {
+		return false
+	}
+	consumer.lastUsed = now
+	c.instances[group+"/"+name] = consumer
+	return true
+}
+
+func (c *restConsumers) get(group string, name string) *restConsumer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.instances[group+"/"+name]
+}
+
+func (c *restConsumers) remove(group string, name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.instances[group+"/"+name]; !ok {
+		return false
+	}
+	delete(c.instances, group+"/"+name)
+	return true
+}
+
+// router.POST("/consumers/:group", s.auth(client, s.restCreateConsumerHandler))
+func (s *Server) restCreateConsumerHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	attr := &struct {
+		Name       string `json:"name"`
+		Format     string `json:"format"`
+		AutoCommit string `json:"auto.commit.enable"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(attr); err != nil {
+		restFail(w, restErrInvalid, restMsgMissingArg)
+		return
+	}
+	if attr.Format == "" {
+		attr.Format = restFormatBinary
+	}
+	if attr.Format != restFormatJSON && attr.Format != restFormatBinary {
+		restFail(w, restErrInvalid, "unsupported format "+attr.Format)
+		return
+	}
+	if attr.Name == "" {
+		attr.Name = "rest-consumer-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	group := ps.ByName("group")
+	consumer := &restConsumer{
+		group:      group,
+		format:     attr.Format,
+		autoCommit: attr.AutoCommit != "false",
+		pending:    make(map[restPartition][]restFetched),
+	}
+	if !s.rest.add(group, attr.Name, consumer) {
+		restFail(w, 40902, "consumer instance with the specified name already exists")
+		return
+	}
+	restResponse(w, 200, map[string]string{
+		"instance_id": attr.Name,
+		"base_uri":    "http://" + r.Host + "/consumers/" + group + "/instances/" + attr.Name,
+	})
+}
+
+func (s *Server) restInstance(w http.ResponseWriter, ps httprouter.Params) *restConsumer {
+	consumer := s.rest.get(ps.ByName("group"), ps.ByName("instance"))
+	if consumer == nil {
+		restFail(w, restErrInstance, "consumer instance not found")
+	}
+	return consumer
+}
+
+// router.DELETE("/consumers/:group/instances/:instance", s.auth(client, s.restDeleteConsumerHandler))
+func (s *Server) restDeleteConsumerHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !s.rest.remove(ps.ByName("group"), ps.ByName("instance")) {
+		restFail(w, restErrInstance, "consumer instance not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 订阅时检查queue和group, 不支持topic_pattern
+// router.POST("/consumers/:group/instances/:instance/subscription", s.auth(client, s.restSubscribeHandler))
+func (s *Server) restSubscribeHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	consumer := s.restInstance(w, ps)
+	if consumer == nil {
+		return
+	}
+	attr := &struct {
+		Topics []string `json:"topics"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(attr); err != nil || len(attr.Topics) == 0 {
+		restFail(w, restErrInvalid, restMsgMissingArg)
+		return
+	}
+	for _, topic := range attr.Topics {
+		if _, err := s.queue.GetSingleGroup(consumer.group, topic); err != nil {
+			restFail(w, restErrTopic, err.Error())
+			return
+		}
+	}
+
+	consumer.mu.Lock()
+	consumer.topics = attr.Topics
+	consumer.lastUsed = time.Now()
+	consumer.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// router.DELETE("/consumers/:group/instances/:instance/subscription", s.auth(client, s.restUnsubscribeHandler))
+func (s *Server) restUnsubscribeHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	consumer := s.restInstance(w, ps)
+	if consumer == nil {
+		return
+	}
+	consumer.mu.Lock()
+	consumer.topics = nil
+	consumer.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type restConsumerRecord struct {
+	Topic     string          `json:"topic"`
+	Key       json.RawMessage `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	Partition int32           `json:"partition"`
+	Offset    int64           `json:"offset"`
+}
+
+// 依次从订阅的queue接收, 没有消息时等待timeout(毫秒), 返回的消息总大小不超过max_bytes
+// router.GET("/consumers/:group/instances/:instance/records", s.auth(client, s.restRecordsHandler))
+func (s *Server) restRecordsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	consumer := s.restInstance(w, ps)
+	if consumer == nil {
+		return
+	}
+	wait, maxBytes := restDefaultWait, restMaxBytes
+	if v := r.FormValue("timeout"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			restFail(w, restErrInvalid, "invalid timeout "+v)
+			return
+		}
+		wait = time.Duration(n) * time.Millisecond
+	}
+	if v := r.FormValue("max_bytes"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			restFail(w, restErrInvalid, "invalid max_bytes "+v)
+			return
+		}
+		maxBytes = n
+	}
+
+	// 同一实例的请求串行处理
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	consumer.lastUsed = time.Now()
+	records, err := s.restFetch(r.Context(), consumer, time.Now().Add(wait), maxBytes)
+	if err != nil {
+		backpressure(w, err)
+		restFail(w, restErrorCode(err), err.Error())
+		return
+	}
+	restResponse(w, 200, records)
+}
+
+func (s *Server) restFetch(ctx context.Context, consumer *restConsumer, deadline time.Time, maxBytes int) ([]restConsumerRecord, error) {
+	records := make([]restConsumerRecord, 0)
+	if len(consumer.topics) == 0 {
+		return records, nil
+	}
+	bytes := 0
+	for {
+		misses := 0
+		for misses < len(consumer.topics) && len(records) < restMaxRecords && bytes < maxBytes {
+			topic := consumer.topics[consumer.next%len(consumer.topics)]
+			consumer.next++
+			id, data, _, err := s.queue.RecvMessage(ctx, topic, consumer.group)
+			if errors.Cause(err) == kafka.ErrTimeout || queue.IsPaused(err) || queue.IsUnassigned(err) {
+				misses++
+				continue
+			}
+			if err != nil {
+				if len(records) > 0 {
+					return records, nil
+				}
+				return nil, err
+			}
+			misses = 0
+
+			partition, offset, _ := queue.MessagePosition(id)
+			if consumer.autoCommit {
+				s.queue.AckMessage(ctx, topic, consumer.group, id)
+			} else {
+				key := restPartition{topic: topic, partition: partition}
+				consumer.pending[key] = append(consumer.pending[key], restFetched{offset: offset, id: id})
+			}
+			bytes += len(data)
+			records = append(records, restConsumerRecord{
+				Topic:     topic,
+				Key:       json.RawMessage("null"),
+				Value:     restEncodeValue(consumer.format, data),
+				Partition: partition,
+				Offset:    offset,
+			})
+		}
+		if len(records) > 0 || !time.Now().Before(deadline) {
+			return records, nil
+		}
+		select {
+		case <-time.After(restPollInterval):
+		case <-ctx.Done():
+			return records, nil
+		}
+	}
+}
+
+// 与REST Proxy一致, 提交的offset为已经消费的消息, ack该partition上不大于offset的已返回消息.
+// 没有请求体时ack所有已返回的消息
+// router.POST("/consumers/:group/instances/:instance/offsets", s.auth(client, s.restCommitHandler))
+func (s *Server) restCommitHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	consumer := s.restInstance(w, ps)
+	if consumer == nil {
+		return
+	}
+	attr := &struct {
+		Offsets []struct {
+			Topic     string `json:"topic"`
+			Partition int32  `json:"partition"`
+			Offset    int64  `json:"offset"`
+		} `json:"offsets"`
+	}{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(attr); err != nil {
+			restFail(w, restErrInvalid, restMsgMissingArg)
+			return
+		}
+	}
+
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	consumer.lastUsed = time.Now()
+	commits := make(map[restPartition]int64)
+	if len(attr.Offsets) == 0 {
+		for key, fetched := range consumer.pending {
+			commits[key] = fetched[0].offset
+			for _, f := range fetched {
+				if f.offset > commits[key] {
+					commits[key] = f.offset
+				}
+			}
+		}
+	}
+	for _, o := range attr.Offsets {
+		commits[restPartition{topic: o.Topic, partition: o.Partition}] = o.Offset
+	}
+
+	// 重新投递的消息可能排在更大的offset之后, 需要检查所有已返回的消息
+	for key, offset := range commits {
+		fetched := consumer.pending[key]
+		var remain []restFetched
+		for i, f := range fetched {
+			if f.offset > offset {
+				remain = append(remain, f)
+				continue
+			}
+			if err := s.queue.AckMessage(r.Context(), key.topic, consumer.group, f.id); err != nil {
+				consumer.pending[key] = append(remain, fetched[i:]...)
+				backpressure(w, err)
+				restFail(w, restErrorCode(err), err.Error())
+				return
+			}
+		}
+		if len(remain) == 0 {
+			delete(consumer.pending, key)
+		} else {
+			consumer.pending[key] = remain
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/engine/queue"
+
+	"github.com/juju/errors"
+)
+
+type restQueue struct {
+	queue.Queue
+	sent   []string
+	msgs   []string
+	offset int64
+	acked  []string
+}
+
+func (q *restQueue) GetSingleGroup(group string, name string) (*queue.GroupConfig, error) {
+	if name != "q1" {
+		return nil, errors.NotFoundf("queue %s", name)
+	}
+	return &queue.GroupConfig{}, nil
+}
+
+func (q *restQueue) SendMessage(ctx context.Context, name string, group string, data []byte, flag uint64) (string, error) {
+	if name != "q1" {
+		return "", errors.NotFoundf("queue %s", name)
+	}
+	q.sent = append(q.sent, group+" "+string(data))
+	q.offset++
+	return fmt.Sprintf("1:%s:%s:2:%x:local", name, group, q.offset), nil
+}
+
+func (q *restQueue) RecvMessage(ctx context.Context, name string, group string) (string, []byte, uint64, error) {
+	if len(q.msgs) == 0 {
+		return "", nil, 0, kafka.ErrTimeout
+	}
+	data := q.msgs[0]
+	q.msgs = q.msgs[1:]
+	q.offset++
+	return fmt.Sprintf("1:%s:%s:0:%x:local", name, group, q.offset), []byte(data), 0, nil
+}
+
+func (q *restQueue) AckMessage(ctx context.Context, name string, group string, id string) error {
+	q.acked = append(q.acked, id)
+	return nil
+}
+
+func restRequest(h httprouter.Handle, method string, url string, contentType string, body string, ps httprouter.Params) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest(method, url, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	h(w, r, ps)
+	return w
+}
+
+func TestRESTProduce(t *testing.T) {
+	q := &restQueue{}
+	s := &Server{queue: q, rest: newRESTConsumers()}
+	ps := httprouter.Params{{Key: "queue", Value: "q1"}}
+
+	w := restRequest(s.restProduceHandler, "POST", "http://wqs/topics/q1", "application/vnd.kafka.json.v2+json",
+		`{"records":[{"value":{"a":1}},{"key":"k","value":"s"},{}]}`, ps)
+	resp := &struct {
+		Offsets []restOffset `json:"offsets"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil || w.Code != 200 || len(resp.Offsets) != 3 {
+		t.Fatalf("produce response error: %d %s %v", w.Code, w.Body, err)
+	}
+	if *resp.Offsets[0].Partition != 2 || *resp.Offsets[1].Offset != 2 || *resp.Offsets[2].ErrorCode != restErrInvalid {
+		t.Errorf("produce offsets error: %s", w.Body)
+	}
+	if len(q.sent) != 2 || q.sent[0] != `default {"a":1}` || q.sent[1] != `default "s"` {
+		t.Errorf("sent messages error: %q", q.sent)
+	}
+
+	w = restRequest(s.restProduceHandler, "POST", "http://wqs/topics/q1", "application/vnd.kafka.binary.v2+json",
+		`{"records":[{"value":"aGVsbG8="}]}`, ps)
+	if w.Code != 200 || q.sent[2] != "default hello" {
+		t.Errorf("binary produce error: %d %s %q", w.Code, w.Body, q.sent)
+	}
+
+	w = restRequest(s.restProduceHandler, "POST", "http://wqs/topics/q2", "application/vnd.kafka.json.v2+json",
+		`{"records":[{"value":1}]}`, httprouter.Params{{Key: "queue", Value: "q2"}})
+	if w.Code != 404 || !strings.Contains(w.Body.String(), `"error_code":40401`) {
+		t.Errorf("expect topic not found, got %d %s", w.Code, w.Body)
+	}
+	w = restRequest(s.restProduceHandler, "POST", "http://wqs/topics/q1", "text/plain", `{}`, ps)
+	if w.Code != 415 {
+		t.Errorf("expect unsupported media type, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRESTConsume(t *testing.T) {
+	q := &restQueue{msgs: []string{`{"a":1}`, "plain", "m3"}}
+	s := &Server{queue: q, rest: newRESTConsumers()}
+	group := httprouter.Params{{Key: "group", Value: "g1"}}
+	instance := httprouter.Params{{Key: "group", Value: "g1"}, {Key: "instance", Value: "c1"}}
+
+	w := restRequest(s.restCreateConsumerHandler, "POST", "http://wqs/consumers/g1", restContentType,
+		`{"name":"c1","format":"json","auto.commit.enable":"false"}`, group)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"base_uri":"http://wqs/consumers/g1/instances/c1"`) {
+		t.Fatalf("create consumer error: %d %s", w.Code, w.Body)
+	}
+	w = restRequest(s.restCreateConsumerHandler, "POST", "http://wqs/consumers/g1", restContentType, `{"name":"c1"}`, group)
+	if w.Code != 409 {
+		t.Errorf("expect conflict for duplicate instance, got %d", w.Code)
+	}
+
+	w = restRequest(s.restSubscribeHandler, "POST", "", restContentType, `{"topics":["q1"]}`, instance)
+	if w.Code != 204 {
+		t.Fatalf("subscribe error: %d %s", w.Code, w.Body)
+	}
+
+	w = restRequest(s.restRecordsHandler, "GET", "http://wqs/records?max_bytes=8", "", "", instance)
+	records := []restConsumerRecord{}
+	if err := json.Unmarshal(w.Body.Bytes(), &records); err != nil || len(records) != 2 {
+		t.Fatalf("records response error: %d %s %v", w.Code, w.Body, err)
+	}
+	if string(records[0].Value) != `{"a":1}` || string(records[1].Value) != `"plain"` || records[1].Offset != 2 || records[0].Topic != "q1" {
+		t.Errorf("records error: %s", w.Body)
+	}
+	if len(q.acked) != 0 {
+		t.Errorf("records should not be acked before commit: %q", q.acked)
+	}
+
+	w = restRequest(s.restCommitHandler, "POST", "", restContentType,
+		`{"offsets":[{"topic":"q1","partition":0,"offset":1}]}`, instance)
+	if w.Code != 204 || len(q.acked) != 1 || q.acked[0] != "1:q1:g1:0:1:local" {
+		t.Errorf("commit offset error: %d %q", w.Code, q.acked)
+	}
+	w = restRequest(s.restCommitHandler, "POST", "", "", "", instance)
+	if w.Code != 204 || len(q.acked) != 2 {
+		t.Errorf("commit all error: %d %q", w.Code, q.acked)
+	}
+
+	w = restRequest(s.restDeleteConsumerHandler, "DELETE", "", "", "", instance)
+	if w.Code != 204 {
+		t.Errorf("delete consumer error: %d", w.Code)
+	}
+	w = restRequest(s.restRecordsHandler, "GET", "http://wqs/records", "", "", instance)
+	if w.Code != 404 || !strings.Contains(w.Body.String(), `"error_code":40403`) {
+		t.Errorf("expect instance not found, got %d %s", w.Code, w.Body)
+	}
+}
@@ -17,18 +17,94 @@ limitations under the License.
 package service
 
 import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
 	"net/http"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/weibocom/wqs/log"
+	"github.com/weibocom/wqs/tracing"
 
 	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	HeaderRequestID = "X-Request-Id"
+
+	maxRequestIDLen  = 128
+	requestIDBytes   = 8
+	accessTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+)
+
+type accessEntry struct {
+	Time      string `json:"time"`
+	RequestID string `json:"request_id"`
+	ClientIP  string `json:"client_ip"`
+	User      string `json:"user,omitempty"`
+	Client    string `json:"client,omitempty"`
+	Method    string `json:"method"`
+	URI       string `json:"uri"`
+	Action    string `json:"action,omitempty"`
+	Queue     string `json:"queue,omitempty"`
+	Group     string `json:"group,omitempty"`
+	Status    int    `json:"status"`
+	BytesIn   int64  `json:"bytes_in"`
+	BytesOut  int64  `json:"bytes_out"`
+	Latency   int64  `json:"latency"`
+}
+
+// 记录响应的状态码和写出的字节数(压缩后)
+type accessResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *accessResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessResponseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// websocket升级后连接由handler接管, 访问日志记录为101
+func (w *accessResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	w.status = http.StatusSwitchingProtocols
+	w.wroteHeader = true
+	return hijacker.Hijack()
+}
+
 type Router struct {
 	accessLog int32
+	limits    *httpLimits
+	cors      *corsPolicy
+	slow      *slowLog
 	*httprouter.Router
 }
 
@@ -39,37 +115,113 @@ func NewRouter() *Router {
 	}
 }
 
-func (r *Router) buildAccessLog(w http.ResponseWriter, req *http.Request, cost int64) {
-	host := strings.SplitN(req.RemoteAddr, ":", 2)[0]
-	username := "-"
+func (r *Router) buildAccessEntry(w *accessResponseWriter, req *http.Request, requestID string, cost int64) *accessEntry {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	entry := &accessEntry{
+		Time:      time.Now().Format(accessTimeFormat),
+		RequestID: requestID,
+		ClientIP:  host,
+		Client:    requestClient(req),
+		Method:    req.Method,
+		URI:       req.URL.Path,
+		Status:    w.status,
+		BytesIn:   req.ContentLength,
+		BytesOut:  w.bytes,
+		Latency:   cost,
+	}
 	if req.URL.User != nil {
-		if name := req.URL.User.Username(); name != "" {
-			username = name
+		entry.User = req.URL.User.Username()
+	}
+	if entry.BytesIn < 0 {
+		entry.BytesIn = 0
+	}
+
+	// 兼容接口的参数在表单中, REST接口的参数在路径中
+	if req.Form != nil {
+		entry.Action = req.Form.Get("action")
+		entry.Queue = req.Form.Get("queue")
+		entry.Group = req.Form.Get("group")
+	}
+	if _, ps, _ := r.Router.Lookup(req.Method, req.URL.Path); ps != nil {
+		if queue := ps.ByName("queue"); queue != "" {
+			entry.Queue = queue
+		}
+		if group := ps.ByName("group"); group != "" {
+			entry.Group = group
 		}
 	}
 
-	log.Infof("%s %s %s %s cost %d", host, username, req.Method, req.RequestURI, cost)
+	return entry
+}
+
+func writeAccessLog(entry *accessEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Warnf("marshal access log error: %s", err)
+		return
+	}
+	log.Access(string(data))
+}
+
+// 优先使用客户端或上游代理传入的request id, 便于串联整个调用链
+func requestID(req *http.Request) string {
+	if id := req.Header.Get(HeaderRequestID); id != "" && len(id) <= maxRequestIDLen {
+		return id
+	}
+	buf := make([]byte, requestIDBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "-"
+	}
+	return hex.EncodeToString(buf)
 }
 
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	var startTime time.Time
 	accessLog := atomic.LoadInt32(&r.accessLog) == 1
-	if accessLog {
+	if accessLog || r.slow != nil {
 		startTime = time.Now()
 	}
 
-	if strings.Contains(req.Header.Get(HeaderAcceptEncoding), "gzip") {
-		grp := newGzipResponseWriter(w)
+	id := requestID(req)
+	w.Header().Set(HeaderRequestID, id)
+	aw := &accessResponseWriter{ResponseWriter: w, status: http.StatusOK}
+
+	// 继续上游传入的trace, 请求的处理过程作为一个server span
+	ctx, span := tracing.Start(tracing.ExtractHTTP(req.Context(), req.Header), "HTTP "+req.Method,
+		trace.SpanKindServer,
+		attribute.String("http.method", req.Method),
+		attribute.String("http.target", req.URL.Path),
+		attribute.String("http.request_id", id))
+	defer span.End()
+	req = req.WithContext(ctx)
+
+	switch {
+	case r.cors != nil && r.cors.handle(aw, req):
+	case r.limits != nil && !r.limits.admit(aw, req):
+	case !r.validate(aw, req):
+	case strings.Contains(req.Header.Get(HeaderAcceptEncoding), "gzip") && !isWebSocket(req):
+		grp := newGzipResponseWriter(aw)
 		r.Router.ServeHTTP(grp, req)
 		grp.Close()
-	} else {
-		r.Router.ServeHTTP(w, req)
+	default:
+		r.Router.ServeHTTP(aw, req)
 	}
-
-	if accessLog {
-		cost := time.Now().Sub(startTime) / time.Millisecond
-		r.buildAccessLog(w, req, int64(cost))
+	span.SetAttributes(attribute.Int("http.status_code", aw.status))
+
+	if accessLog || r.slow != nil {
+		now := time.Now()
+		cost := now.Sub(startTime)
+		entry := r.buildAccessEntry(aw, req, id, int64(cost/time.Millisecond))
+		if accessLog {
+			writeAccessLog(entry)
+		}
+		if r.slow != nil {
+			r.slow.observe(entry, cost, now)
+		}
 	}
 }
 
@@ -0,0 +1,96 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/weibocom/wqs/log"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestAccessLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "access.log")
+	logger, err := log.NewLogger(file).Open()
+	if err != nil {
+		t.Fatalf("open access log error: %v", err)
+	}
+	defer logger.Close()
+	log.RestAccessLogger(logger)
+
+	router := NewRouter()
+	router.PUT("/queues/:queue/ttl", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		response(w, 400, "bad ttl")
+	})
+	router.POST("/msg", CompatibleWarp(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Write([]byte("ok"))
+	}))
+
+	req, _ := http.NewRequest("PUT", "http://example.com/queues/q1/ttl", strings.NewReader("{}"))
+	req.RemoteAddr = "10.0.0.1:5678"
+	req.Header.Set(HeaderRequestID, "req-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if id := w.Header().Get(HeaderRequestID); id != "req-1" {
+		t.Errorf("expect request id req-1, got %q", id)
+	}
+
+	req, _ = http.NewRequest("POST", "http://example.com/msg", strings.NewReader("action=send&queue=q2&group=g2"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if id := w.Header().Get(HeaderRequestID); len(id) != requestIDBytes*2 {
+		t.Errorf("expect generated request id, got %q", id)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("open %s error: %v", file, err)
+	}
+	defer f.Close()
+	entries := make([]accessEntry, 0, 2)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := accessEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("access log is not json: %q", scanner.Text())
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expect 2 access logs, got %d", len(entries))
+	}
+
+	e := entries[0]
+	if e.RequestID != "req-1" || e.ClientIP != "10.0.0.1" || e.Queue != "q1" ||
+		e.Status != 400 || e.BytesIn != 2 || e.BytesOut == 0 {
+		t.Errorf("rest access log error: %+v", e)
+	}
+	e = entries[1]
+	if e.Action != "send" || e.Queue != "q2" || e.Group != "g2" || e.Status != 200 || e.BytesOut != 2 {
+		t.Errorf("compatible access log error: %+v", e)
+	}
+}
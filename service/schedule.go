@@ -0,0 +1,71 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/weibocom/wqs/engine/queue"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// router.GET("/schedules", s.auth(admin, s.getSchedulesHandler))
+func (s *Server) getSchedulesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	schedules, err := s.queue.Schedules()
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(schedules)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
+// router.GET("/schedules/:name", s.auth(admin, s.getScheduleHandler))
+func (s *Server) getScheduleHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	schedule, err := s.queue.GetSchedule(ps.ByName("name"))
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, schedule.String())
+}
+
+// 创建或修改定时消息, 修改时保留最后一次发送的结果
+// router.PUT("/schedules/:name", s.auth(admin, s.setScheduleHandler))
+func (s *Server) setScheduleHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	schedule := &queue.ScheduledMessage{}
+	if err := json.NewDecoder(r.Body).Decode(schedule); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+	schedule.Name = ps.ByName("name")
+	configResponse(w, s.queue.SetSchedule(schedule))
+}
+
+// router.DELETE("/schedules/:name", s.auth(admin, s.deleteScheduleHandler))
+func (s *Server) deleteScheduleHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	configResponse(w, s.queue.DeleteSchedule(ps.ByName("name")))
+}
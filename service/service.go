@@ -18,13 +18,15 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
-	"net/http/pprof"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
@@ -32,29 +34,80 @@ import (
 	"github.com/weibocom/wqs/engine/queue"
 	"github.com/weibocom/wqs/log"
 	"github.com/weibocom/wqs/metrics"
+	"github.com/weibocom/wqs/service/console"
 	"github.com/weibocom/wqs/service/mc"
+	"github.com/weibocom/wqs/service/redis"
+	"github.com/weibocom/wqs/tracing"
 	"github.com/weibocom/wqs/utils"
 
 	"github.com/juju/errors"
 )
 
+const (
+	// 分页查询时返回符合条件的总数
+	totalCountHeader = "X-Total-Count"
+	// 分页查询每页最多返回的个数
+	maxLookupLimit = 1000
+	// 停止时等待正在处理的请求完成的时间
+	defaultShutdownTimeout = 30 * time.Second
+)
+
 type Server struct {
-	config   *config.Config
-	queue    queue.Queue
-	mc       *mc.Server
-	listener *utils.Listener
+	config      *config.Config
+	queue       queue.Queue
+	authEnable  bool
+	debugEnable bool
+	mc          *mc.Server
+	console     *console.Server
+	redis       *redis.Server
+	listener    *utils.Listener
+	httpServer  *http.Server
+	reloader    reloader
+	sqs         *sqsVisibility
+	rest        *restConsumers
+	slow        *slowLog
+	// 发送和接收消息时必须携带客户端标识
+	clientRequired bool
 }
 
 func NewServer(conf *config.Config, version string) (*Server, error) {
 
+	levels, err := parseLogLevels(conf)
+	if err != nil {
+		return nil, err
+	}
+	levels.apply()
+
 	queue, err := queue.NewQueue(conf, version)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
+	var authEnable bool
+	if section, err := conf.GetSection("auth"); err == nil {
+		authEnable = section.GetBoolMust("enable", false)
+	}
+
+	var clientRequired bool
+	if section, err := conf.GetSection("client"); err == nil {
+		clientRequired = section.GetBoolMust("required", false)
+	}
+
+	// 默认不开启pprof和运行时统计接口
+	var debugEnable bool
+	if section, err := conf.GetSection("debug"); err == nil {
+		debugEnable = section.GetBoolMust("enable", false)
+	}
+
 	return &Server{
-		config: conf,
-		queue:  queue,
+		config:         conf,
+		queue:          queue,
+		authEnable:     authEnable,
+		debugEnable:    debugEnable,
+		clientRequired: clientRequired,
+		reloader:       reloader{dying: make(chan struct{})},
+		sqs:            newSQSVisibility(),
+		rest:           newRESTConsumers(),
 	}, nil
 }
 
@@ -67,40 +120,153 @@ func (s *Server) Start() error {
 		}
 	}
 
-	router.GET("/queue", CompatibleWarp(s.queueHandler))
-	router.POST("/queue", CompatibleWarp(s.queueHandler))
-	router.GET("/group", CompatibleWarp(s.groupHandler))
-	router.POST("/group", CompatibleWarp(s.groupHandler))
-	router.GET("/msg", CompatibleWarp(s.msgHandler))
-	router.POST("/msg", CompatibleWarp(s.msgHandler))
-
-	router.GET("/idcs/info", s.idcsInformation)
+	admin, client := queue.RoleAdmin, queue.RoleClient
+	router.GET("/queue", s.auth(admin, CompatibleWarp(s.queueHandler)))
+	router.POST("/queue", s.auth(admin, CompatibleWarp(s.queueHandler)))
+	router.GET("/group", s.auth(admin, CompatibleWarp(s.groupHandler)))
+	router.POST("/group", s.auth(admin, CompatibleWarp(s.groupHandler)))
+	router.GET("/msg", s.auth(client, s.identify(CompatibleWarp(s.msgHandler))))
+	router.POST("/msg", s.auth(client, s.identify(CompatibleWarp(s.msgHandler))))
+	router.GET("/ws/msg", s.auth(client, s.identify(s.wsMsgHandler)))
+	// SQS兼容接口自行处理认证
+	router.POST("/sqs", s.identify(s.sqsHandler))
+	router.POST("/sqs/:queue", s.identify(s.sqsHandler))
+	// Confluent REST Proxy v2兼容接口
+	router.POST("/transactions", s.auth(client, s.identify(s.transactionHandler)))
+	router.POST("/topics/:queue", s.auth(client, s.identify(s.restProduceHandler)))
+	router.POST("/consumers/:group", s.auth(client, s.restCreateConsumerHandler))
+	router.DELETE("/consumers/:group/instances/:instance", s.auth(client, s.restDeleteConsumerHandler))
+	router.POST("/consumers/:group/instances/:instance/subscription", s.auth(client, s.restSubscribeHandler))
+	router.DELETE("/consumers/:group/instances/:instance/subscription", s.auth(client, s.restUnsubscribeHandler))
+	router.GET("/consumers/:group/instances/:instance/records", s.auth(client, s.identify(s.restRecordsHandler)))
+	router.POST("/consumers/:group/instances/:instance/offsets", s.auth(client, s.identify(s.restCommitHandler)))
+
+	router.GET("/idcs/info", s.auth(client, s.idcsInformation))
 	//queue's api
-	router.PUT("/queues/:queue", s.createQueueHandler)
-	router.GET("/queue/:queue/:group/metrics/:action/:type", s.getMetricsHandler)
+	router.PUT("/queues/:queue", s.auth(admin, s.createQueueHandler))
+	router.POST("/groups", s.auth(admin, s.applyGroupsHandler))
+	router.POST("/groups/:group/queues", s.auth(admin, s.applyGroupToQueuesHandler))
+	router.GET("/profiles", s.auth(admin, s.getProfilesHandler))
+	router.GET("/queue/:queue/:group/metrics/:action/:type", s.auth(client, s.getMetricsHandler))
+	router.GET("/metrics/:action/:type", s.auth(client, s.getMultiMetricsHandler))
+	router.POST("/queue/:queue/:group/offset", s.auth(admin, s.resetOffsetHandler))
+	router.GET("/queue/:queue/:group/snapshots", s.auth(admin, s.getOffsetSnapshotsHandler))
+	router.GET("/queue/:queue/:group/snapshots/:name", s.auth(admin, s.getOffsetSnapshotHandler))
+	router.PUT("/queue/:queue/:group/snapshots/:name", s.auth(admin, s.createOffsetSnapshotHandler))
+	router.DELETE("/queue/:queue/:group/snapshots/:name", s.auth(admin, s.deleteOffsetSnapshotHandler))
+	router.POST("/queue/:queue/:group/snapshots/:name/restore", s.auth(admin, s.restoreOffsetSnapshotHandler))
+	router.GET("/queue/:queue/:group/peek", s.auth(client, s.peekMessageHandler))
+	router.GET("/queue/:queue/:group/partitions/:partition", s.auth(client, s.identify(s.recvPartitionHandler)))
+	router.PUT("/queue/:queue/:group/partitions/:partition/offset", s.auth(client, s.commitPartitionOffsetHandler))
+	router.GET("/queue/:queue/:group/describe", s.auth(admin, s.describeGroupHandler))
+	router.GET("/queue/:queue/:group/stuck", s.auth(admin, s.stuckMembersHandler))
+	router.DELETE("/queue/:queue/:group/members/:member", s.auth(admin, s.removeGroupMemberHandler))
+	router.PUT("/queue/:queue/:group/limit", s.auth(admin, s.setGroupLimitHandler))
+	router.PUT("/queue/:queue/:group/clients/:client/limit", s.auth(admin, s.setClientLimitHandler))
+	router.PUT("/queue/:queue/:group/filter", s.auth(admin, s.setGroupFilterHandler))
+	router.DELETE("/queue/:queue/:group/filter", s.auth(admin, s.deleteGroupFilterHandler))
+	router.PUT("/queue/:queue/:group/broadcast", s.auth(admin, s.setGroupBroadcastHandler))
+	router.PUT("/queue/:queue/:group/canary", s.auth(admin, s.setGroupCanaryHandler))
+	router.DELETE("/queue/:queue/:group/canary", s.auth(admin, s.deleteGroupCanaryHandler))
+	router.PUT("/queue/:queue/:group/workers", s.auth(admin, s.setGroupWorkersHandler))
+	router.POST("/queue/:queue/:group/verify", s.auth(admin, s.verifyGroupURLHandler))
+	router.PUT("/queue/:queue/:group/commit", s.auth(admin, s.setGroupCommitHandler))
+	router.DELETE("/queue/:queue/:group/commit", s.auth(admin, s.deleteGroupCommitHandler))
+	router.PUT("/queue/:queue/:group/pause", s.auth(admin, s.pauseGroupHandler))
+	router.PUT("/queue/:queue/:group/resume", s.auth(admin, s.resumeGroupHandler))
+	router.PUT("/queues/:queue/limit", s.auth(admin, s.setQueueLimitHandler))
+	router.PUT("/queues/:queue/quota", s.auth(admin, s.setQueueQuotaHandler))
+	router.PUT("/queues/:queue/ttl", s.auth(admin, s.setQueueTTLHandler))
+	router.PUT("/queues/:queue/idempotent", s.auth(admin, s.setQueueIdempotentHandler))
+	router.PUT("/queues/:queue/overflow", s.auth(admin, s.setQueueOverflowHandler))
+	router.PUT("/queues/:queue/tags", s.auth(admin, s.setQueueTagsHandler))
+	router.PUT("/queues/:queue/consumer", s.auth(admin, s.setQueueConsumerHandler))
+	router.PUT("/queues/:queue/size", s.auth(admin, s.setQueueSizeHandler))
+	router.PUT("/queues/:queue/encryption", s.auth(admin, s.setQueueEncryptionHandler))
+	router.DELETE("/queues/:queue/encryption", s.auth(admin, s.deleteQueueEncryptionHandler))
+	router.PUT("/queues/:queue/trace", s.auth(admin, s.setQueueTraceHandler))
+	router.GET("/messages/:id/trace", s.auth(admin, s.messageTraceHandler))
+	router.PUT("/queues/:queue/priority", s.auth(admin, s.setQueuePriorityHandler))
+	router.GET("/queues/:queue/values", s.auth(client, s.getValuesHandler))
+	router.GET("/queues/:queue/values/:key", s.auth(client, s.getValueHandler))
+	router.DELETE("/queues/:queue/values/:key", s.auth(client, s.deleteValueHandler))
+	router.PUT("/queues/:queue/schema", s.auth(admin, s.setQueueSchemaHandler))
+	router.DELETE("/queues/:queue/schema", s.auth(admin, s.deleteQueueSchemaHandler))
+	router.GET("/queues/:queue/mirror", s.auth(admin, s.getQueueMirrorHandler))
+	router.PUT("/queues/:queue/mirror", s.auth(admin, s.setQueueMirrorHandler))
+	router.DELETE("/queues/:queue/mirror", s.auth(admin, s.deleteQueueMirrorHandler))
+	router.PUT("/queues/:queue/mirror/pause", s.auth(admin, s.pauseQueueMirrorHandler))
+	router.PUT("/queues/:queue/mirror/resume", s.auth(admin, s.resumeQueueMirrorHandler))
+	router.GET("/queues/:queue/messages", s.auth(admin, s.browseMessagesHandler))
+	router.GET("/queues/:queue/messages/:partition/:offset", s.auth(admin, s.getMessageAtHandler))
+	router.GET("/queues/:queue/sample", s.auth(admin, s.sampleMessagesHandler))
+	router.DELETE("/queues/:queue/messages", s.auth(admin, s.purgeQueueHandler))
+	router.GET("/queues/:queue/reassignment", s.auth(admin, s.getReassignmentHandler))
+	router.POST("/queues/:queue/reassignment", s.auth(admin, s.reassignQueueHandler))
+	router.POST("/queues/:queue/leaders", s.auth(admin, s.electLeadersHandler))
+	router.POST("/queues/:queue/transfers", s.auth(admin, s.startTransferHandler))
+	router.GET("/transfers", s.auth(admin, s.getTransfersHandler))
+	router.GET("/transfers/:id", s.auth(admin, s.getTransferHandler))
+	router.DELETE("/transfers/:id", s.auth(admin, s.cancelTransferHandler))
+	router.GET("/accumulation", s.auth(client, s.getAccumulationHandler))
 	//loggers
-	router.GET("/loggers", getLoggerHandler)
-	router.POST("/loggers/:name", changeLoggerHandler)
+	router.GET("/loggers", s.auth(client, getLoggerHandler))
+	router.POST("/loggers/:name", s.auth(admin, changeLoggerHandler))
+	router.GET("/log/levels", s.auth(admin, getLogLevelsHandler))
+	router.PUT("/log/levels/:module", s.auth(admin, setLogLevelHandler))
+	router.DELETE("/log/levels/:module", s.auth(admin, resetLogLevelHandler))
 	//proxy
-	router.GET("/proxies/", s.getProxiesHandler)
-	router.GET("/proxies/:id/config", s.getProxyConfigByIDHandler)
+	router.GET("/proxies/", s.auth(admin, s.getProxiesHandler))
+	router.GET("/proxies/:id/config", s.auth(admin, s.getProxyConfigByIDHandler))
+	router.GET("/discovery", s.auth(client, s.discoveryHandler))
+	//config
+	router.POST("/config/reload", s.auth(admin, s.reloadConfigHandler))
+	//tokens
+	router.GET("/tokens", s.auth(admin, s.getTokensHandler))
+	router.POST("/tokens", s.auth(admin, s.createTokenHandler))
+	router.DELETE("/tokens/:token", s.auth(admin, s.deleteTokenHandler))
+	//schedules
+	router.GET("/schedules", s.auth(admin, s.getSchedulesHandler))
+	router.GET("/schedules/:name", s.auth(admin, s.getScheduleHandler))
+	router.PUT("/schedules/:name", s.auth(admin, s.setScheduleHandler))
+	router.DELETE("/schedules/:name", s.auth(admin, s.deleteScheduleHandler))
+	router.GET("/orphans", s.auth(admin, s.getOrphansHandler))
+	router.GET("/faults", s.auth(admin, s.getFaultsHandler))
+	router.PUT("/faults", s.auth(admin, s.setFaultsHandler))
+	router.POST("/faults/zk/expire", s.auth(admin, s.expireSessionHandler))
+	//health, 不需要认证
+	router.GET("/health/live", s.liveHandler)
+	router.GET("/health/ready", s.readyHandler)
+	router.GET("/kafka/status", s.auth(admin, s.kafkaStatusHandler))
 	//version
-	router.GET("/version", s.getVersion)
-	//pprof
-	router.GET("/debug/pprof/", CompatibleWarp(pprof.Index))
-	router.GET("/debug/pprof/cmdline", CompatibleWarp(pprof.Cmdline))
-	router.GET("/debug/pprof/profile", CompatibleWarp(pprof.Profile))
-	router.GET("/debug/pprof/symbol", CompatibleWarp(pprof.Symbol))
-	router.POST("/debug/pprof/symbol", CompatibleWarp(pprof.Symbol))
-	router.GET("/debug/pprof/trace", CompatibleWarp(pprof.Trace))
+	router.GET("/version", s.auth(client, s.getVersion))
+	//slow requests
+	router.GET("/slow/requests", s.auth(admin, s.getSlowRequestsHandler))
+	//pprof and runtime stats
+	if s.debugEnable {
+		s.debugRoutes(router)
+	}
 
-	var err error
+	server, err := newHTTPServer(s.config, router)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	limits, err := loadHTTPLimits(s.config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if s.slow, err = loadSlowLog(s.config); err != nil {
+		return errors.Trace(err)
+	}
 	s.listener, err = utils.Listen("tcp", fmt.Sprintf(":%s", s.config.HttpPort))
 	if err != nil {
 		return errors.Trace(err)
 	}
-
-	server := &http.Server{Handler: router}
+	limits.conns = s.listener.GetRemain
+	limits.apply(server)
+	router.limits = limits
+	router.cors = loadCORSPolicy(s.config)
+	router.slow = s.slow
 	server.SetKeepAlivesEnabled(true)
 
 	s.mc = mc.NewServer(s.queue, ":"+s.config.McPort, s.config.McSocketRecvBuffer, s.config.McSocketSendBuffer)
@@ -108,21 +274,87 @@ func (s *Server) Start() error {
 		return errors.Trace(err)
 	}
 
-	go server.Serve(s.listener)
+	// 未配置console.addr时不开启调试控制台
+	if section, err := s.config.GetSection("console"); err == nil {
+		if addr := section.GetStringMust("addr", ""); addr != "" {
+			s.console = console.NewServer(s.queue, addr, section.GetStringMust("password", ""))
+			if err = s.console.Start(); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+
+	// 未配置redis.addr时不开启redis协议入口
+	if section, err := s.config.GetSection("redis"); err == nil {
+		if addr := section.GetStringMust("addr", ""); addr != "" {
+			s.redis = redis.NewServer(s.queue, addr, s.authEnable)
+			if err = s.redis.Start(); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+
+	s.httpServer = server
+	if server.TLSConfig != nil {
+		go server.ServeTLS(s.listener, "", "")
+	} else {
+		go server.Serve(s.listener)
+	}
 	return nil
 }
 
+// 停止接收新连接, 等待正在处理的请求完成(最多shutdown.timeout)后关闭queue,
+// 关闭producer时会写出缓冲中的消息
 func (s *Server) Stop() (err error) {
+	close(s.reloader.dying)
+	timeout := shutdownTimeout(s.config)
+	log.Infof("draining connections in %s", timeout)
+
+	var wg sync.WaitGroup
+	drain := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f()
+		}()
+	}
 	if s.mc != nil {
-		s.mc.Stop()
+		drain(func() { s.mc.Stop(timeout) })
+	}
+	if s.redis != nil {
+		drain(func() { s.redis.Stop(timeout) })
 	}
-	if s.listener != nil {
+	if s.console != nil {
+		s.console.Stop()
+	}
+	// Shutdown不等待websocket等被接管的连接, 它们在dying关闭后退出
+	if s.httpServer != nil {
+		drain(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err = s.httpServer.Shutdown(ctx); err != nil {
+				log.Warnf("http server drain err: %s, close %d connections", err, s.listener.GetRemain())
+				s.httpServer.Close()
+			}
+		})
+	} else if s.listener != nil {
 		err = s.listener.Close()
 	}
+	wg.Wait()
+
 	s.queue.Close()
 	return
 }
 
+func shutdownTimeout(conf *config.Config) time.Duration {
+	if section, err := conf.GetSection("shutdown"); err == nil {
+		if d, err := time.ParseDuration(section.GetStringMust("timeout", "")); err == nil && d >= 0 {
+			return d
+		}
+	}
+	return defaultShutdownTimeout
+}
+
 //队列操作handler
 func (s *Server) queueHandler(w http.ResponseWriter, r *http.Request) {
 
@@ -130,6 +362,10 @@ func (s *Server) queueHandler(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	action := r.FormValue("action")
 	queue := r.FormValue("queue")
+	if err := validateForm(r.Form, queueActionParams[action]...); err != nil {
+		fmt.Fprint(w, compatError(action, err))
+		return
+	}
 
 	switch action {
 	case "create":
@@ -140,6 +376,10 @@ func (s *Server) queueHandler(w http.ResponseWriter, r *http.Request) {
 		result = s.queueUpdate(queue)
 	case "lookup":
 		biz := r.FormValue("biz")
+		if queue == "" {
+			result = s.queueFind(w, queueFilter(r))
+			break
+		}
 		result = s.queueLookup(queue, biz)
 	default:
 		result = "error, param action=" + action + " not support!"
@@ -151,7 +391,7 @@ func (s *Server) queueCreate(queue string) string {
 	err := s.queue.Create(queue, []string{})
 	if err != nil {
 		log.Debugf("CreateQueue err:%s", errors.ErrorStack(err))
-		return `{"action":"create","result":false}`
+		return compatError("create", err)
 	}
 	return `{"action":"create","result":true}`
 }
@@ -160,7 +400,7 @@ func (s *Server) queueRemove(queue string) string {
 	err := s.queue.Delete(queue)
 	if err != nil {
 		log.Debugf("DeleteQueue err:%s", errors.ErrorStack(err))
-		return `{"action":"remove","result":false}`
+		return compatError("remove", err)
 	}
 	return `{"action":"remove","result":true}`
 }
@@ -169,7 +409,7 @@ func (s *Server) queueUpdate(queue string) string {
 	err := s.queue.Update(queue)
 	if err != nil {
 		log.Debugf("UpdateQueue err:%s", errors.ErrorStack(err))
-		return `{"action":"update","result":false}`
+		return compatError("update", err)
 	}
 	return `{"action":"update","result":true}`
 }
@@ -188,6 +428,58 @@ func (s *Server) queueLookup(queue string, biz string) string {
 	return string(result)
 }
 
+// 按名字前缀和标签分页查询queue, 符合条件的总数通过X-Total-Count返回
+func (s *Server) queueFind(w http.ResponseWriter, filter queue.QueueFilter) string {
+	r, total, err := s.queue.LookupQueues(filter)
+	if err != nil {
+		log.Debugf("LookupQueues err:%s", errors.ErrorStack(err))
+		return "[]"
+	}
+	result, err := json.Marshal(r)
+	if err != nil {
+		log.Debugf("queueFind Marshal err:%s", err)
+		return "[]"
+	}
+	w.Header().Set(totalCountHeader, strconv.Itoa(total))
+	return string(result)
+}
+
+// tag的格式为key:value, 可以重复
+func queueFilter(r *http.Request) queue.QueueFilter {
+	offset, limit := pageParams(r)
+	filter := queue.QueueFilter{Prefix: r.FormValue("prefix"), Offset: offset, Limit: limit}
+	for _, v := range r.Form["tag"] {
+		if kv := strings.SplitN(v, ":", 2); len(kv) == 2 && kv[0] != "" {
+			if filter.Tags == nil {
+				filter.Tags = make(map[string]string)
+			}
+			filter.Tags[kv[0]] = kv[1]
+		}
+	}
+	return filter
+}
+
+func groupFilter(r *http.Request) queue.GroupFilter {
+	offset, limit := pageParams(r)
+	return queue.GroupFilter{Prefix: r.FormValue("prefix"), Queue: r.FormValue("queue"), Offset: offset, Limit: limit}
+}
+
+// 分页参数, 不合法或者没有时为0, limit为0表示不分页
+func pageParams(r *http.Request) (int, int) {
+	offset, _ := strconv.Atoi(r.FormValue("offset"))
+	limit, _ := strconv.Atoi(r.FormValue("limit"))
+	if offset < 0 {
+		offset = 0
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	if limit > maxLookupLimit {
+		limit = maxLookupLimit
+	}
+	return offset, limit
+}
+
 //业务操作handler
 func (s *Server) groupHandler(w http.ResponseWriter, r *http.Request) {
 
@@ -200,15 +492,24 @@ func (s *Server) groupHandler(w http.ResponseWriter, r *http.Request) {
 	read := r.FormValue("read")
 	url := r.FormValue("url")
 	ips := r.FormValue("ips")
+	start := r.FormValue("start")
+	if err := validateForm(r.Form, groupActionParams[action]...); err != nil {
+		fmt.Fprint(w, compatError(action, err))
+		return
+	}
 
 	switch action {
 	case "add":
-		result = s.groupAdd(group, queue, write, read, url, ips)
+		result = s.groupAdd(group, queue, write, read, url, ips, start)
 	case "remove":
 		result = s.groupRemove(group, queue)
 	case "update":
-		result = s.groupUpdate(group, queue, write, read, url, ips)
+		result = s.groupUpdate(group, queue, write, read, url, ips, start)
 	case "lookup":
+		if group == "" {
+			result = s.groupFind(w, groupFilter(r))
+			break
+		}
 		result = s.groupLookup(group)
 	default:
 		result = "error, param action=" + action + " not support!"
@@ -216,7 +517,7 @@ func (s *Server) groupHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, result)
 }
 
-func (s *Server) groupAdd(group string, queue string, write string, read string, url string, ips string) string {
+func (s *Server) groupAdd(group string, queue string, write string, read string, url string, ips string, start string) string {
 
 	w, _ := strconv.ParseBool(write)
 	r, _ := strconv.ParseBool(read)
@@ -226,10 +527,10 @@ func (s *Server) groupAdd(group string, queue string, write string, read string,
 		url = fmt.Sprintf("%s.%s.intra.weibo.com", group, queue)
 	}
 
-	err := s.queue.AddGroup(group, queue, w, r, url, ips_array)
+	err := s.queue.AddGroup(group, queue, w, r, url, ips_array, start)
 	if err != nil {
 		log.Debugf("AddGroup failed: %s", errors.ErrorStack(err))
-		return `{"action":"add","result":false}`
+		return compatError("add", err)
 	}
 	return `{"action":"add","result":true}`
 }
@@ -238,18 +539,18 @@ func (s *Server) groupRemove(group string, queue string) string {
 	err := s.queue.DeleteGroup(group, queue)
 	if err != nil {
 		log.Debugf("groupRemove failed: %s", errors.ErrorStack(err))
-		return `{"action":"remove","result":false}`
+		return compatError("remove", err)
 	}
 	return `{"action":"remove","result":true}`
 }
 
 func (s *Server) groupUpdate(group string, queue string,
-	write string, read string, url string, ips string) string {
+	write string, read string, url string, ips string, start string) string {
 
 	config, err := s.queue.GetSingleGroup(group, queue)
 	if err != nil {
 		log.Debugf("GetSingleGroup err:%s", errors.ErrorStack(err))
-		return `{"action":"update","result":false}`
+		return compatError("update", err)
 	}
 	if write != "" {
 		w, err := strconv.ParseBool(write)
@@ -269,11 +570,14 @@ func (s *Server) groupUpdate(group string, queue string,
 	if ips != "" {
 		config.Ips = strings.Split(ips, ",")
 	}
+	if start != "" {
+		config.Start = start
+	}
 
-	err = s.queue.UpdateGroup(group, queue, config.Write, config.Read, config.Url, config.Ips)
+	err = s.queue.UpdateGroup(group, queue, config.Write, config.Read, config.Url, config.Ips, config.Start)
 	if err != nil {
 		log.Debugf("groupUpdate failed: %s", errors.ErrorStack(err))
-		return `{"action":"update","result":false}`
+		return compatError("update", err)
 	}
 	return `{"action":"update","result":true}`
 }
@@ -292,57 +596,171 @@ func (s *Server) groupLookup(group string) string {
 	return string(result)
 }
 
+// 按名字前缀和订阅的queue分页查询group, 符合条件的总数通过X-Total-Count返回
+func (s *Server) groupFind(w http.ResponseWriter, filter queue.GroupFilter) string {
+	r, total, err := s.queue.LookupGroups(filter)
+	if err != nil {
+		log.Debugf("LookupGroups err: %s", errors.ErrorStack(err))
+		return "[]"
+	}
+	result, err := json.Marshal(r)
+	if err != nil {
+		log.Debugf("groupFind Marshal err: %s", err)
+		return "[]"
+	}
+	w.Header().Set(totalCountHeader, strconv.Itoa(total))
+	return string(result)
+}
+
 //消息操作handler
 func (s *Server) msgHandler(w http.ResponseWriter, r *http.Request) {
+	if isProtobuf(r) {
+		s.msgProtoHandler(w, r)
+		return
+	}
 	r.ParseForm()
 
 	action := r.FormValue("action")
 	queue := r.FormValue("queue")
 	group := r.FormValue("group")
 	msg := r.FormValue("msg")
+	if err := validateForm(r.Form, msgActionParams[action]...); err != nil {
+		fmt.Fprint(w, compatError(action, err))
+		return
+	}
 
 	var result string
+	var err error
 	switch action {
 	case "receive":
-		result = s.msgReceive(queue, group)
+		ctx := withInstance(tracing.WithMessage(r.Context()), r.FormValue("instance"))
+		result, err = s.msgReceive(ctx, queue, group)
+		// 返回消息发送时的trace context, 客户端可以继续该trace
+		tracing.InjectHTTP(tracing.Message(ctx), w.Header())
 	case "send":
-		result = s.msgSend(queue, group, msg)
+		ctx := withPriority(withHeaders(r.Context(), r.Form["header"]), r.FormValue("priority"))
+		ctx = withDurability(withKey(ctx, r.FormValue("key")), r.FormValue("durability"))
+		result, err = s.msgSend(ctx, queue, group, msg, r.FormValue("msgid"))
 	case "ack":
 		result = s.msgAck(queue, group)
 	default:
 		result = "error, param action=" + action + " not support!"
 	}
-	fmt.Fprintf(w, result)
+	// 兼容旧客户端, 只有限流和熔断时返回非200的状态码(429和503)
+	if status := backpressure(w, err); status != 0 {
+		w.WriteHeader(status)
+	}
+	io.WriteString(w, result)
+}
+
+// 消息header以header=key:value的形式传递, 可以重复
+func withHeaders(ctx context.Context, values []string) context.Context {
+	headers := make(map[string]string, len(values))
+	for _, v := range values {
+		if kv := strings.SplitN(v, ":", 2); len(kv) == 2 && kv[0] != "" {
+			headers[kv[0]] = kv[1]
+		}
+	}
+	if len(headers) == 0 {
+		return ctx
+	}
+	return queue.WithHeaders(ctx, headers)
 }
 
-func (s *Server) msgSend(queue string, group string, msg string) string {
+// 设置了优先级的queue可以指定priority参数, 不能解析时按无效的优先级拒绝发送
+func withPriority(ctx context.Context, value string) context.Context {
+	if value == "" {
+		return ctx
+	}
+	level, err := strconv.Atoi(value)
+	if err != nil {
+		level = -1
+	}
+	return queue.WithPriority(ctx, level)
+}
+
+// 发送到compact queue的消息需要key参数
+func withKey(ctx context.Context, key string) context.Context {
+	if key == "" {
+		return ctx
+	}
+	return queue.WithKey(ctx, key)
+}
+
+// durability=all的消息通过acks=all的producer写入, 其他取值在发送时拒绝
+func withDurability(ctx context.Context, level string) context.Context {
+	if level == "" {
+		return ctx
+	}
+	return queue.WithDurability(ctx, level)
+}
+
+// 广播模式的group需要instance参数区分客户端实例
+func withInstance(ctx context.Context, instance string) context.Context {
+	if instance == "" {
+		return ctx
+	}
+	return queue.WithInstance(ctx, instance)
+}
+
+func isCircuitOpen(err error) bool {
+	return queue.IsCircuitOpen(err)
+}
+
+func (s *Server) msgSend(ctx context.Context, queue string, group string, msg string, msgID string) (string, error) {
 	var result string
-	_, err := s.queue.SendMessage(queue, group, []byte(msg), 0)
+	_, err := s.queue.SendMessageWithID(ctx, queue, group, []byte(msg), 0, msgID)
 	if err != nil {
 		log.Debugf("msgSend failed: %s", errors.ErrorStack(err))
-		result = err.Error()
+		result = compatError("send", err)
 	} else {
 		result = `{"action":"send","result":true}`
 	}
-	return result
+	return result, err
 }
 
-func (s *Server) msgReceive(queue string, group string) string {
+func (s *Server) msgReceive(ctx context.Context, name string, group string) (string, error) {
 	var result string
-	id, data, _, err := s.queue.RecvMessage(queue, group)
+	id, data, _, err := s.queue.RecvMessage(ctx, name, group)
+	if queue.IsPaused(err) {
+		// 暂停的group返回明确的状态, 客户端据此停止轮询
+		return `{"action":"receive","paused":true}`, err
+	}
+	if queue.IsUnassigned(err) {
+		// 同一group在多个proxy上消费时, 本proxy可能没有分配到partition, 客户端应该换到其他proxy
+		return `{"action":"receive","assigned":false}`, err
+	}
 	if err != nil {
 		log.Debugf("msgReceive failed: %s", errors.ErrorStack(err))
-		result = err.Error()
+		result = compatError("receive", err)
 	} else {
-		err = s.queue.AckMessage(queue, group, id)
+		err = s.queue.AckMessage(ctx, name, group, id)
 		if err != nil {
-			log.Warnf("ack message queue:%q group:%q id:%q err:%s", queue, group, id, err)
-			result = err.Error()
+			log.Warnf("ack message queue:%q group:%q id:%q err:%s", name, group, id, err)
+			result = compatError("receive", err)
 		} else {
 			result = `{"action":"receive","msg":"` + string(data) + `"}`
 		}
 	}
-	return result
+	return result, err
+}
+
+// 兼容接口出错时仍返回200(限流和熔断除外), 错误码和错误信息在响应体中
+func compatError(action string, err error) string {
+	code := queue.ErrorCodeOf(err)
+	var fields []FieldError
+	if v, ok := err.(*ValidationError); ok {
+		fields = v.Errors
+	}
+	data, _ := json.Marshal(&struct {
+		Action    string          `json:"action"`
+		Result    bool            `json:"result"`
+		ErrorCode queue.ErrorCode `json:"error_code"`
+		Error     string          `json:"error"`
+		Retryable bool            `json:"retryable,omitempty"`
+		Errors    []FieldError    `json:"errors,omitempty"`
+	}{action, false, code, err.Error(), code.Retryable(), fields})
+	return string(data)
 }
 
 func (s *Server) msgAck(queue string, group string) string {
@@ -378,32 +796,647 @@ func (s *Server) createQueueHandler(w http.ResponseWriter, r *http.Request, ps h
 		}
 	}
 
-	if err := s.queue.Create(queue, attr.Idcs); err != nil {
+	if err := s.queue.CreateQueue(queue, queueOptions(attr)); err != nil {
 		log.Errorf("create queue: %s", errors.ErrorStack(err))
-		response(w, 500, err.Error())
+		errorResponse(w, err)
 		return
 	}
 
 	response(w, 201, "created")
 }
 
+func queueOptions(attr *QueueAttr) queue.QueueOptions {
+	return queue.QueueOptions{
+		Idcs:    attr.Idcs,
+		Ordered: attr.Ordered,
+		Compact: attr.Compact,
+		Expire:  attr.Expire,
+		Profile: attr.Profile,
+		Tags:    attr.Tags,
+	}
+}
+
+// router.GET("/profiles", s.getProfilesHandler)
+func (s *Server) getProfilesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	data, err := json.Marshal(s.queue.Profiles())
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
+// Reset a group's offset to given time
+// path "/queue/:queue/:group/offset"
+func (s *Server) resetOffsetHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	attr := &OffsetAttr{}
+	if err := json.NewDecoder(r.Body).Decode(attr); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	if err := s.queue.ResetOffset(ps.ByName("queue"), ps.ByName("group"), attr.Time); err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, "OK")
+}
+
+// 读取group当前位置之后的消息, 不改变group的消费位置, count默认为1
+// router.GET("/queue/:queue/:group/peek", s.peekMessageHandler)
+func (s *Server) peekMessageHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	count, err := formInt64(r, "count", 1)
+	if err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	msgs, err := s.queue.PeekMessage(ps.ByName("queue"), ps.ByName("group"), int(count))
+	if err != nil {
+		configResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(msgs)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
+// 查看group在kafka中的成员、partition分配和提交情况
+// router.GET("/queue/:queue/:group/describe", s.describeGroupHandler)
+func (s *Server) describeGroupHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	desc, err := s.queue.DescribeGroup(ps.ByName("queue"), ps.ByName("group"))
+	if err != nil {
+		configResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(desc)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
+// 查看有堆积但超过minutes分钟没有提交offset的成员, minutes默认为10
+// router.GET("/queue/:queue/:group/stuck", s.stuckMembersHandler)
+func (s *Server) stuckMembersHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	minutes, err := formInt64(r, "minutes", int64(queue.DefaultStaleCommit/time.Minute))
+	if err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	members, err := s.queue.StuckMembers(ps.ByName("queue"), ps.ByName("group"), time.Duration(minutes)*time.Minute)
+	if err != nil {
+		configResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
+// router.DELETE("/queue/:queue/:group/members/:member", s.removeGroupMemberHandler)
+func (s *Server) removeGroupMemberHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	configResponse(w, s.queue.RemoveGroupMember(ps.ByName("queue"), ps.ByName("group"), ps.ByName("member")))
+}
+
+// 参数为空时返回defaultVal
+func formInt64(r *http.Request, key string, defaultVal int64) (int64, error) {
+	v := r.FormValue(key)
+	if v == "" {
+		return defaultVal, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, errors.NotValidf("%s : %q", key, v)
+	}
+	return n, nil
+}
+
+// 按partition和offset分页浏览消息, 只读, 不属于任何group
+// router.GET("/queues/:queue/messages", s.browseMessagesHandler)
+func (s *Server) browseMessagesHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	params := make(map[string]int64)
+	for key, defaultVal := range map[string]int64{"partition": 0, "offset": -1, "limit": 20, "preview": 0} {
+		n, err := formInt64(r, key, defaultVal)
+		if err != nil {
+			response(w, 400, err.Error())
+			return
+		}
+		params[key] = n
+	}
+
+	page, err := s.queue.BrowseMessages(ps.ByName("queue"), int32(params["partition"]),
+		params["offset"], int(params["limit"]), int(params["preview"]))
+	if err != nil {
+		configResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(page)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
+// 在seconds秒内按rate采样新写入的消息, 只读, 不属于任何group
+// router.GET("/queues/:queue/sample", s.sampleMessagesHandler)
+func (s *Server) sampleMessagesHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	params := make(map[string]int64)
+	for key, defaultVal := range map[string]int64{"rate": 1000, "seconds": 10, "limit": 100, "preview": 0} {
+		n, err := formInt64(r, key, defaultVal)
+		if err != nil {
+			response(w, 400, err.Error())
+			return
+		}
+		params[key] = n
+	}
+
+	sample, err := s.queue.SampleMessages(ps.ByName("queue"), int(params["rate"]),
+		time.Duration(params["seconds"])*time.Second, int(params["limit"]), int(params["preview"]))
+	if err != nil {
+		configResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(sample)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
+// router.GET("/queues/:queue/messages/:partition/:offset", s.getMessageAtHandler)
+func (s *Server) getMessageAtHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	partition, err := strconv.ParseInt(ps.ByName("partition"), 10, 32)
+	if err != nil {
+		response(w, 400, fmt.Sprintf("bad partition %q", ps.ByName("partition")))
+		return
+	}
+	offset, err := strconv.ParseInt(ps.ByName("offset"), 10, 64)
+	if err != nil {
+		response(w, 400, fmt.Sprintf("bad offset %q", ps.ByName("offset")))
+		return
+	}
+
+	msg, err := s.queue.GetMessageAt(ps.ByName("queue"), int32(partition), offset)
+	if err != nil {
+		configResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
+// router.DELETE("/queues/:queue/messages", s.purgeQueueHandler)
+func (s *Server) purgeQueueHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	err := s.queue.PurgeQueue(ps.ByName("queue"))
+	if errors.IsNotSupported(err) {
+		response(w, 501, err.Error())
+		return
+	}
+	configResponse(w, err)
+}
+
+// router.POST("/queues/:queue/transfers", s.startTransferHandler)
+func (s *Server) startTransferHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	req := queue.TransferRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	status, err := s.queue.StartTransfer(ps.ByName("queue"), req)
+	if errors.IsNotSupported(err) {
+		response(w, 501, err.Error())
+		return
+	}
+	transferResponse(w, status, err)
+}
+
+// router.GET("/transfers", s.getTransfersHandler)
+func (s *Server) getTransfersHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	data, err := json.Marshal(s.queue.Transfers())
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
+// router.GET("/transfers/:id", s.getTransferHandler)
+func (s *Server) getTransferHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	status, err := s.queue.GetTransfer(ps.ByName("id"))
+	transferResponse(w, status, err)
+}
+
+// router.DELETE("/transfers/:id", s.cancelTransferHandler)
+func (s *Server) cancelTransferHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	configResponse(w, s.queue.CancelTransfer(ps.ByName("id")))
+}
+
+func transferResponse(w http.ResponseWriter, status *queue.TransferStatus, err error) {
+	if err != nil {
+		configResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
+// router.PUT("/queue/:queue/:group/pause", s.pauseGroupHandler)
+func (s *Server) pauseGroupHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	configResponse(w, s.queue.SetGroupPaused(ps.ByName("group"), ps.ByName("queue"), true))
+}
+
+// router.PUT("/queue/:queue/:group/resume", s.resumeGroupHandler)
+func (s *Server) resumeGroupHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	configResponse(w, s.queue.SetGroupPaused(ps.ByName("group"), ps.ByName("queue"), false))
+}
+
+// router.PUT("/queue/:queue/:group/limit", s.setGroupLimitHandler)
+func (s *Server) setGroupLimitHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	limit := queue.RateLimit{}
+	if err := json.NewDecoder(r.Body).Decode(&limit); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	configResponse(w, s.queue.SetGroupLimit(ps.ByName("group"), ps.ByName("queue"), limit))
+}
+
+// router.PUT("/queue/:queue/:group/filter", s.setGroupFilterHandler)
+func (s *Server) setGroupFilterHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	attr := struct {
+		Filter string `json:"filter"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&attr); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+	if attr.Filter == "" {
+		response(w, 400, "filter is required")
+		return
+	}
+
+	configResponse(w, s.queue.SetGroupFilter(ps.ByName("group"), ps.ByName("queue"), attr.Filter))
+}
+
+// router.DELETE("/queue/:queue/:group/filter", s.deleteGroupFilterHandler)
+func (s *Server) deleteGroupFilterHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	configResponse(w, s.queue.SetGroupFilter(ps.ByName("group"), ps.ByName("queue"), ""))
+}
+
+// router.PUT("/queue/:queue/:group/broadcast", s.setGroupBroadcastHandler)
+func (s *Server) setGroupBroadcastHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	attr := &BroadcastAttr{}
+	if err := json.NewDecoder(r.Body).Decode(attr); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	configResponse(w, s.queue.SetGroupBroadcast(ps.ByName("group"), ps.ByName("queue"), attr.Broadcast))
+}
+
+// router.PUT("/queue/:queue/:group/canary", s.setGroupCanaryHandler)
+func (s *Server) setGroupCanaryHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	attr := &CanaryAttr{}
+	if err := json.NewDecoder(r.Body).Decode(attr); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+	if attr.Percent <= 0 {
+		response(w, 400, "percent is required")
+		return
+	}
+
+	configResponse(w, s.queue.SetGroupCanary(ps.ByName("group"), ps.ByName("queue"), attr.Percent))
+}
+
+// router.DELETE("/queue/:queue/:group/canary", s.deleteGroupCanaryHandler)
+func (s *Server) deleteGroupCanaryHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	configResponse(w, s.queue.SetGroupCanary(ps.ByName("group"), ps.ByName("queue"), 0))
+}
+
+// workers为0时恢复为1个worker
+// router.PUT("/queue/:queue/:group/workers", s.setGroupWorkersHandler)
+func (s *Server) setGroupWorkersHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	attr := &WorkersAttr{}
+	if err := json.NewDecoder(r.Body).Decode(attr); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	configResponse(w, s.queue.SetGroupWorkers(ps.ByName("group"), ps.ByName("queue"), attr.Workers))
+}
+
+// 重新向group的推送地址发送验证请求, 通过后才会用于推送
+// router.POST("/queue/:queue/:group/verify", s.verifyGroupURLHandler)
+func (s *Server) verifyGroupURLHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	configResponse(w, s.queue.VerifyGroupURL(ps.ByName("group"), ps.ByName("queue")))
+}
+
+// router.PUT("/queue/:queue/:group/commit", s.setGroupCommitHandler)
+func (s *Server) setGroupCommitHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	policy := queue.CommitPolicy{}
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	configResponse(w, s.queue.SetGroupCommit(ps.ByName("group"), ps.ByName("queue"), policy))
+}
+
+// 恢复为默认的定时提交
+// router.DELETE("/queue/:queue/:group/commit", s.deleteGroupCommitHandler)
+func (s *Server) deleteGroupCommitHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	configResponse(w, s.queue.SetGroupCommit(ps.ByName("group"), ps.ByName("queue"), queue.CommitPolicy{}))
+}
+
+// router.PUT("/queues/:queue/limit", s.setQueueLimitHandler)
+func (s *Server) setQueueLimitHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	limit := queue.RateLimit{}
+	if err := json.NewDecoder(r.Body).Decode(&limit); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	configResponse(w, s.queue.SetQueueLimit(ps.ByName("queue"), limit))
+}
+
+// router.PUT("/queues/:queue/quota", s.setQueueQuotaHandler)
+func (s *Server) setQueueQuotaHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	quota := queue.Quota{}
+	if err := json.NewDecoder(r.Body).Decode(&quota); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	configResponse(w, s.queue.SetQueueQuota(ps.ByName("queue"), quota))
+}
+
+// router.PUT("/queues/:queue/ttl", s.setQueueTTLHandler)
+func (s *Server) setQueueTTLHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	attr := &TTLAttr{}
+	if err := json.NewDecoder(r.Body).Decode(attr); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	configResponse(w, s.queue.SetQueueTTL(ps.ByName("queue"), attr.TTL))
+}
+
+// router.PUT("/queues/:queue/idempotent", s.setQueueIdempotentHandler)
+func (s *Server) setQueueIdempotentHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	attr := &IdempotentAttr{}
+	if err := json.NewDecoder(r.Body).Decode(attr); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	configResponse(w, s.queue.SetQueueIdempotent(ps.ByName("queue"), attr.Idempotent))
+}
+
+// router.PUT("/queues/:queue/overflow", s.setQueueOverflowHandler)
+func (s *Server) setQueueOverflowHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	attr := &OverflowAttr{}
+	if err := json.NewDecoder(r.Body).Decode(attr); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	configResponse(w, s.queue.SetQueueOverflow(ps.ByName("queue"), attr.Overflow))
+}
+
+// router.PUT("/queues/:queue/tags", s.setQueueTagsHandler)
+func (s *Server) setQueueTagsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	attr := &TagsAttr{}
+	if err := json.NewDecoder(r.Body).Decode(attr); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	configResponse(w, s.queue.SetQueueTags(ps.ByName("queue"), attr.Tags))
+}
+
+// router.PUT("/queues/:queue/consumer", s.setQueueConsumerHandler)
+func (s *Server) setQueueConsumerHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	tuning := queue.ConsumerTuning{}
+	if err := json.NewDecoder(r.Body).Decode(&tuning); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	configResponse(w, s.queue.SetQueueConsumer(ps.ByName("queue"), tuning))
+}
+
+// router.PUT("/queues/:queue/size", s.setQueueSizeHandler)
+func (s *Server) setQueueSizeHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	size := queue.MessageSize{}
+	if err := json.NewDecoder(r.Body).Decode(&size); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	configResponse(w, s.queue.SetQueueSize(ps.ByName("queue"), size))
+}
+
+// levels为0时取消优先级
+// router.PUT("/queues/:queue/priority", s.setQueuePriorityHandler)
+func (s *Server) setQueuePriorityHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	priority := queue.PriorityConfig{}
+	if err := json.NewDecoder(r.Body).Decode(&priority); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	configResponse(w, s.queue.SetQueuePriority(ps.ByName("queue"), priority))
+}
+
+// router.PUT("/queues/:queue/encryption", s.setQueueEncryptionHandler)
+func (s *Server) setQueueEncryptionHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	attr := &EncryptionAttr{}
+	if err := json.NewDecoder(r.Body).Decode(attr); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+	if attr.Key == "" {
+		response(w, 400, "empty key name")
+		return
+	}
+
+	configResponse(w, s.queue.SetQueueEncryption(ps.ByName("queue"), attr.Key))
+}
+
+// router.DELETE("/queues/:queue/encryption", s.deleteQueueEncryptionHandler)
+func (s *Server) deleteQueueEncryptionHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	configResponse(w, s.queue.SetQueueEncryption(ps.ByName("queue"), ""))
+}
+
+// router.PUT("/queues/:queue/schema", s.setQueueSchemaHandler)
+func (s *Server) setQueueSchemaHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	schema := &queue.Schema{}
+	if err := json.NewDecoder(r.Body).Decode(schema); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	configResponse(w, s.queue.SetQueueSchema(ps.ByName("queue"), schema))
+}
+
+// router.DELETE("/queues/:queue/schema", s.deleteQueueSchemaHandler)
+func (s *Server) deleteQueueSchemaHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	configResponse(w, s.queue.SetQueueSchema(ps.ByName("queue"), nil))
+}
+
+// router.GET("/queues/:queue/mirror", s.getQueueMirrorHandler)
+func (s *Server) getQueueMirrorHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	status, err := s.queue.MirrorStatus(ps.ByName("queue"))
+	if err != nil {
+		configResponse(w, err)
+		return
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
+// router.PUT("/queues/:queue/mirror", s.setQueueMirrorHandler)
+func (s *Server) setQueueMirrorHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	mirror := &queue.MirrorConfig{}
+	if err := json.NewDecoder(r.Body).Decode(mirror); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+
+	configResponse(w, s.queue.SetQueueMirror(ps.ByName("queue"), mirror))
+}
+
+// router.DELETE("/queues/:queue/mirror", s.deleteQueueMirrorHandler)
+func (s *Server) deleteQueueMirrorHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	configResponse(w, s.queue.SetQueueMirror(ps.ByName("queue"), nil))
+}
+
+// router.PUT("/queues/:queue/mirror/pause", s.pauseQueueMirrorHandler)
+func (s *Server) pauseQueueMirrorHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	configResponse(w, s.queue.SetMirrorPaused(ps.ByName("queue"), true))
+}
+
+// router.PUT("/queues/:queue/mirror/resume", s.resumeQueueMirrorHandler)
+func (s *Server) resumeQueueMirrorHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	configResponse(w, s.queue.SetMirrorPaused(ps.ByName("queue"), false))
+}
+
+func configResponse(w http.ResponseWriter, err error) {
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, "OK")
+}
+
+// Get accumulation of all groups
+// path "/accumulation"
+func (s *Server) getAccumulationHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	infos, err := s.queue.AccumulationStatus()
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+
+	buff := &bytes.Buffer{}
+	if err := json.NewEncoder(buff).Encode(infos); err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, buff.String())
+}
+
 // Get all online proxies, return id and hostname
 func (s *Server) getProxiesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 
 	proxys, err := s.queue.Proxys()
 	if err != nil {
-		response(w, 500, err.Error())
+		errorResponse(w, err)
 		return
 	}
 
 	buff := &bytes.Buffer{}
 	if err := json.NewEncoder(buff).Encode(proxys); err != nil {
-		response(w, 500, err.Error())
+		errorResponse(w, err)
 		return
 	}
 	response(w, 200, buff.String())
 }
 
+// 返回在线的proxy实例, 供客户端做负载均衡
+// router.GET("/discovery", s.discoveryHandler)
+func (s *Server) discoveryHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	instances, err := s.queue.Instances()
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(instances)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
+
 // Get an online proxy's config
 func (s *Server) getProxyConfigByIDHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
@@ -421,11 +1454,7 @@ func (s *Server) getProxyConfigByIDHandler(w http.ResponseWriter, r *http.Reques
 
 	config, err := s.queue.GetProxyConfigByID(proxyID)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			response(w, 404, err.Error())
-			return
-		}
-		response(w, 500, err.Error())
+		errorResponse(w, err)
 		return
 	}
 	response(w, 200, config)
@@ -434,24 +1463,19 @@ func (s *Server) getProxyConfigByIDHandler(w http.ResponseWriter, r *http.Reques
 // Get a group's metrics
 // path "/queue/:queue/:group/metrics/:action/:type"
 func (s *Server) getMetricsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	var start, end, step int64
-	var err error
 	queue := ps.ByName("queue")
 	group := ps.ByName("group")
 	action := ps.ByName("action")
 	typ := ps.ByName("type")
 
-	switch action {
-	case metrics.CmdSet, metrics.CmdGet:
-	default:
-		response(w, 400, fmt.Sprintf("not support action: %s", action))
+	if msg := checkMetricsType(action, typ); msg != "" {
+		response(w, 400, msg)
 		return
 	}
 
-	switch typ {
-	case metrics.Qps, metrics.Elapsed, metrics.Latency:
-	default:
-		response(w, 400, fmt.Sprintf("not support type: %s", typ))
+	format, err := metricsFormat(r)
+	if err != nil {
+		response(w, 400, err.Error())
 		return
 	}
 
@@ -460,54 +1484,48 @@ func (s *Server) getMetricsHandler(w http.ResponseWriter, r *http.Request, ps ht
 		return
 	}
 
-	qStart := r.FormValue("start")
-	qEnd := r.FormValue("end")
-	qStep := r.FormValue("step")
-
-	if qStart == "" {
-		start = time.Now().Add(-4 * time.Hour).Unix()
-	} else {
-		start, err = strconv.ParseInt(qStart, 10, 64)
-		if err != nil {
-			response(w, 400, err.Error())
-			return
-		}
+	start, end, step, err := parseMetricsRange(r)
+	if err != nil {
+		response(w, 400, err.Error())
+		return
 	}
 
-	if qEnd == "" {
-		end = time.Now().Unix()
-	} else {
-		end, err = strconv.ParseInt(qEnd, 10, 64)
-		if err != nil {
-			response(w, 400, err.Error())
-			return
-		}
+	metricsKey, err := clientMetricsKey(r.FormValue("client"), typ)
+	if err != nil {
+		response(w, 400, err.Error())
+		return
 	}
 
-	if qStep == "" {
-		step = 240
-	} else {
-		step, err = strconv.ParseInt(qStep, 10, 64)
-		if err != nil {
-			response(w, 400, err.Error())
-			return
-		}
+	// 默认合并所有proxy的数据
+	host := r.FormValue("host")
+	if host == "" {
+		host = metrics.AllHost
 	}
 
 	queryParam := &metrics.QueryParam{
-		Host:       metrics.AllHost,
+		Host:       host,
 		Queue:      queue,
 		Group:      group,
 		ActionKey:  action,
-		MetricsKey: typ,
+		MetricsKey: metricsKey,
 		StartTime:  start,
 		EndTime:    end,
 		Step:       step,
 	}
 
+	if format != metrics.FormatJSON {
+		data, err := metrics.ExportMetricsSeries([]*metrics.QueryParam{queryParam}, typ != metrics.Qps, format)
+		if err != nil {
+			errorResponse(w, err)
+			return
+		}
+		exportResponse(w, format, data)
+		return
+	}
+
 	data, err := metrics.GetMetrics(queryParam)
 	if err != nil {
-		response(w, 500, err.Error())
+		errorResponse(w, err)
 		return
 	}
 	response(w, 200, data)
@@ -619,3 +1637,15 @@ func response(w http.ResponseWriter, code int, message string) {
 	w.WriteHeader(msg.Code)
 	w.Write(msg.Bytes())
 }
+
+// 根据错误的类型返回状态码和错误码
+func errorResponse(w http.ResponseWriter, err error) {
+	code := queue.ErrorCodeOf(err)
+	msg := &ResponseMessage{Code: code.Status(), Message: err.Error(), ErrorCode: code, Retryable: code.Retryable()}
+	if v, ok := err.(*ValidationError); ok {
+		msg.Errors = v.Errors
+	}
+	backpressure(w, err)
+	w.WriteHeader(msg.Code)
+	w.Write(msg.Bytes())
+}
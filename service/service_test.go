@@ -23,6 +23,10 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/weibocom/wqs/engine/queue"
+
+	"github.com/juju/errors"
 )
 
 func TestGetLoggerHandler(t *testing.T) {
@@ -81,6 +85,9 @@ func TestChangeLoggerHandlerNotFound(t *testing.T) {
 	if errMessage.Code != 404 {
 		t.Errorf("response message code error, want %d, now %d", 404, errMessage.Code)
 	}
+	if errMessage.ErrorCode != queue.ErrCodeNotFound || errMessage.Retryable {
+		t.Errorf("response error code error, want %s, now %s", queue.ErrCodeNotFound, errMessage.ErrorCode)
+	}
 }
 
 func TestChangeLoggerHandlerFound(t *testing.T) {
@@ -146,3 +153,33 @@ func TestChangeLoggerHandlerFound(t *testing.T) {
 	}
 	t.Logf("set info logger to %s", loggers["info"])
 }
+
+func TestErrorResponse(t *testing.T) {
+	for _, c := range []struct {
+		err       error
+		status    int
+		code      queue.ErrorCode
+		retryable bool
+	}{
+		{errors.NotFoundf("queue : %q", "q1"), 404, queue.ErrCodeQueueNotFound, false},
+		{errors.Annotatef(queue.ErrGroupPaused, "group %q", "g1"), 409, queue.ErrCodeGroupPaused, true},
+		{errors.New("unknown"), 500, queue.ErrCodeInternal, false},
+	} {
+		w := httptest.NewRecorder()
+		errorResponse(w, c.err)
+		msg := &ResponseMessage{}
+		if err := json.NewDecoder(w.Body).Decode(msg); err != nil {
+			t.Fatalf("unexpect error : %v", err)
+		}
+		if w.Code != c.status || msg.Code != c.status || msg.ErrorCode != c.code || msg.Retryable != c.retryable || msg.Message == "" {
+			t.Errorf("%v: unexpected response %d %+v", c.err, w.Code, msg)
+		}
+	}
+
+	// 成功的响应不返回错误码
+	w := httptest.NewRecorder()
+	response(w, 200, "OK")
+	if body := w.Body.String(); strings.Contains(body, "error_code") || strings.Contains(body, "retryable") {
+		t.Errorf("unexpected error code in %s", body)
+	}
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/weibocom/wqs/config"
+	"github.com/weibocom/wqs/log"
+
+	"github.com/juju/errors"
+)
+
+const (
+	defaultSlowTop    = 10
+	defaultSlowWindow = 10 * time.Minute
+	// 统计窗口按时间分成的桶数, 过期的桶整体丢弃
+	slowBuckets = 10
+	// 每个桶中每个维度最多记录的key数, 避免大量不同的client ip占用内存
+	maxSlowKeys = 1000
+)
+
+// 慢请求的统计维度
+var slowDimensions = []string{"queue", "group", "client_ip"}
+
+type slowStat struct {
+	count int64
+	total int64
+	max   int64
+}
+
+type slowBucket struct {
+	start int64
+	count int64
+	stats map[string]map[string]*slowStat
+}
+
+// 记录耗时超过threshold的请求, 并按queue、group和client ip统计最近window内的慢请求
+type slowLog struct {
+	threshold time.Duration
+	window    time.Duration
+	top       int
+
+	mu      sync.Mutex
+	buckets [slowBuckets]slowBucket
+}
+
+type slowItem struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+	Avg   int64  `json:"avg"`
+	Max   int64  `json:"max"`
+}
+
+type slowReport struct {
+	Threshold string                `json:"threshold"`
+	Window    string                `json:"window"`
+	Total     int64                 `json:"total"`
+	Top       map[string][]slowItem `json:"top"`
+}
+
+// 未配置slow.threshold时不记录慢请求
+func loadSlowLog(conf *config.Config) (*slowLog, error) {
+	section, err := conf.GetSection("slow")
+	if err != nil {
+		return nil, nil
+	}
+	value := section.GetStringMust("threshold", "")
+	if value == "" {
+		return nil, nil
+	}
+	threshold, err := time.ParseDuration(value)
+	if err != nil || threshold <= 0 {
+		return nil, errors.NotValidf("slow.threshold %s", value)
+	}
+	window := defaultSlowWindow
+	if value = section.GetStringMust("window", ""); value != "" {
+		window, err = time.ParseDuration(value)
+		if err != nil || window < slowBuckets*time.Second {
+			return nil, errors.NotValidf("slow.window %s", value)
+		}
+	}
+	top := int(section.GetInt64Must("top", defaultSlowTop))
+	if top <= 0 {
+		return nil, errors.NotValidf("slow.top %d", top)
+	}
+	return newSlowLog(threshold, window, top), nil
+}
+
+func newSlowLog(threshold, window time.Duration, top int) *slowLog {
+	return &slowLog{threshold: threshold, window: window, top: top}
+}
+
+// 记录一次请求, 没有超过阈值时直接返回
+func (l *slowLog) observe(entry *accessEntry, cost time.Duration, now time.Time) {
+	// websocket连接的耗时是整个会话的时间
+	if cost < l.threshold || entry.Status == http.StatusSwitchingProtocols {
+		return
+	}
+	if data, err := json.Marshal(entry); err == nil {
+		log.Warnf("slow request: %s", data)
+	}
+
+	span := int64(l.window / slowBuckets)
+	start := now.UnixNano() / span * span
+	latency := int64(cost / time.Millisecond)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := &l.buckets[start/span%slowBuckets]
+	if b.start > start {
+		return
+	}
+	if b.start != start {
+		b.start, b.count = start, 0
+		b.stats = make(map[string]map[string]*slowStat, len(slowDimensions))
+		for _, dim := range slowDimensions {
+			b.stats[dim] = make(map[string]*slowStat)
+		}
+	}
+	b.count++
+	for i, name := range []string{entry.Queue, entry.Group, entry.ClientIP} {
+		if name == "" {
+			continue
+		}
+		stats := b.stats[slowDimensions[i]]
+		stat, ok := stats[name]
+		if !ok {
+			if len(stats) >= maxSlowKeys {
+				continue
+			}
+			stat = &slowStat{}
+			stats[name] = stat
+		}
+		stat.count++
+		stat.total += latency
+		if latency > stat.max {
+			stat.max = latency
+		}
+	}
+}
+
+// 合并窗口内的桶, 每个维度按慢请求数从多到少返回前top个
+func (l *slowLog) report(top int, now time.Time) *slowReport {
+	if top <= 0 {
+		top = l.top
+	}
+	span := int64(l.window / slowBuckets)
+	oldest := now.UnixNano()/span*span - span*(slowBuckets-1)
+
+	r := &slowReport{
+		Threshold: l.threshold.String(),
+		Window:    l.window.String(),
+		Top:       make(map[string][]slowItem, len(slowDimensions)),
+	}
+	merged := make(map[string]map[string]*slowStat, len(slowDimensions))
+	for _, dim := range slowDimensions {
+		merged[dim] = make(map[string]*slowStat)
+	}
+
+	l.mu.Lock()
+	for i := range l.buckets {
+		b := &l.buckets[i]
+		if b.stats == nil || b.start < oldest {
+			continue
+		}
+		r.Total += b.count
+		for dim, stats := range b.stats {
+			for name, stat := range stats {
+				m, ok := merged[dim][name]
+				if !ok {
+					m = &slowStat{}
+					merged[dim][name] = m
+				}
+				m.count += stat.count
+				m.total += stat.total
+				if stat.max > m.max {
+					m.max = stat.max
+				}
+			}
+		}
+	}
+	l.mu.Unlock()
+
+	for dim, stats := range merged {
+		items := make([]slowItem, 0, len(stats))
+		for name, stat := range stats {
+			items = append(items, slowItem{Name: name, Count: stat.count, Avg: stat.total / stat.count, Max: stat.max})
+		}
+		sort.Slice(items, func(i, j int) bool {
+			if items[i].Count != items[j].Count {
+				return items[i].Count > items[j].Count
+			}
+			return items[i].Max > items[j].Max
+		})
+		if len(items) > top {
+			items = items[:top]
+		}
+		r.Top[dim] = items
+	}
+	return r
+}
+
+// router.GET("/slow/requests", s.getSlowRequestsHandler)
+func (s *Server) getSlowRequestsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if s.slow == nil {
+		errorResponse(w, errors.NotSupportedf("slow request log without slow.threshold"))
+		return
+	}
+	var top int
+	if value := r.FormValue("top"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > maxLookupLimit {
+			errorResponse(w, errors.NotValidf("top %s", value))
+			return
+		}
+		top = n
+	}
+	data, err := json.Marshal(s.slow.report(top, time.Now()))
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
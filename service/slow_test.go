@@ -0,0 +1,98 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestLoadSlowLog(t *testing.T) {
+	conf, _ := config.NewConfigFromBytes([]byte(testReloadConfig))
+	if l, err := loadSlowLog(conf); l != nil || err != nil {
+		t.Errorf("expect nil slow log without slow.threshold, got %+v %v", l, err)
+	}
+	conf, _ = config.NewConfigFromBytes([]byte(testReloadConfig + "slow.threshold=200ms\nslow.top=5\n"))
+	l, err := loadSlowLog(conf)
+	if err != nil || l.threshold != 200*time.Millisecond || l.window != defaultSlowWindow || l.top != 5 {
+		t.Errorf("unexpected slow log %+v %v", l, err)
+	}
+	for _, value := range []string{"slow.threshold=0", "slow.threshold=abc", "slow.threshold=1s\nslow.window=1s", "slow.threshold=1s\nslow.top=0"} {
+		conf, _ = config.NewConfigFromBytes([]byte(testReloadConfig + value + "\n"))
+		if _, err := loadSlowLog(conf); err == nil {
+			t.Errorf("expect error for %q", value)
+		}
+	}
+}
+
+func TestSlowLogReport(t *testing.T) {
+	l := newSlowLog(100*time.Millisecond, time.Minute, 2)
+	now := time.Now()
+	l.observe(&accessEntry{Queue: "q1", Group: "g1", ClientIP: "10.0.0.1"}, 50*time.Millisecond, now)
+	l.observe(&accessEntry{Queue: "q1", Group: "g1", ClientIP: "10.0.0.1"}, 300*time.Millisecond, now)
+	l.observe(&accessEntry{Queue: "q1", Group: "g2", ClientIP: "10.0.0.2"}, 100*time.Millisecond, now)
+	l.observe(&accessEntry{Queue: "q2", ClientIP: "10.0.0.3"}, 200*time.Millisecond, now)
+	l.observe(&accessEntry{Queue: "q3", ClientIP: "10.0.0.3", Status: http.StatusSwitchingProtocols}, time.Hour, now)
+	// 早于当前桶的请求不再统计
+	l.observe(&accessEntry{Queue: "q4", ClientIP: "10.0.0.4"}, time.Second, now.Add(-2*time.Minute))
+
+	r := l.report(0, now)
+	if r.Total != 3 || r.Threshold != "100ms" || r.Window != "1m0s" {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	queues := r.Top["queue"]
+	if len(queues) != 2 || queues[0] != (slowItem{Name: "q1", Count: 2, Avg: 200, Max: 300}) || queues[1].Name != "q2" {
+		t.Errorf("unexpected queue top %+v", queues)
+	}
+	if groups := r.Top["group"]; len(groups) != 2 || groups[0].Name != "g1" || groups[1].Name != "g2" {
+		t.Errorf("unexpected group top %+v", groups)
+	}
+	if ips := r.Top["client_ip"]; len(ips) != 2 || ips[0].Name != "10.0.0.1" || ips[1].Name != "10.0.0.3" {
+		t.Errorf("unexpected client ip top %+v", ips)
+	}
+	if r = l.report(1, now); len(r.Top["queue"]) != 1 {
+		t.Errorf("expect 1 queue, got %+v", r.Top["queue"])
+	}
+	if r = l.report(0, now.Add(2*time.Minute)); r.Total != 0 || len(r.Top["queue"]) != 0 {
+		t.Errorf("expect expired report, got %+v", r)
+	}
+}
+
+func TestSlowRouter(t *testing.T) {
+	router := NewRouter()
+	router.accessLog = 0
+	router.slow = newSlowLog(time.Nanosecond, time.Minute, 10)
+	router.GET("/queue/:queue/:group/peek", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		response(w, http.StatusOK, "ok")
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/queue/q1/g1/peek", nil)
+	req.RemoteAddr = "10.0.0.1:5000"
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	r := router.slow.report(0, time.Now())
+	if r.Total != 1 || len(r.Top["queue"]) != 1 || r.Top["queue"][0].Name != "q1" ||
+		r.Top["group"][0].Name != "g1" || r.Top["client_ip"][0].Name != "10.0.0.1" {
+		t.Errorf("unexpected report %+v", r)
+	}
+}
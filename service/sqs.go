@@ -0,0 +1,531 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/engine/queue"
+
+	"github.com/juju/errors"
+)
+
+// 兼容SQS的query协议(表单+XML)和json协议(X-Amz-Target), queue名为queue:group, 没有group时使用default.
+// SDK的endpoint配置为http://ip:port/sqs
+const (
+	sqsTargetPrefix   = "AmazonSQS."
+	sqsJSONType       = "application/x-amz-json-1.0"
+	sqsXMLNamespace   = "http://queue.amazonaws.com/doc/2012-11-05/"
+	sqsDefaultGroup   = "default"
+	sqsMaxMessages    = 10
+	sqsMaxWaitSeconds = 20
+	sqsMaxVisibility  = 43200
+	// 未设置VisibilityTimeout时的默认值, 单位秒
+	sqsDefaultVisibility = 30
+	sqsPollInterval      = 100 * time.Millisecond
+	sqsSweepInterval     = time.Minute
+)
+
+type sqsError struct {
+	status    int
+	code      string
+	queryCode string
+	message   string
+}
+
+func (e *sqsError) Error() string {
+	return e.code + ": " + e.message
+}
+
+func newSQSError(status int, code string, format string, args ...interface{}) *sqsError {
+	return &sqsError{status: status, code: code, queryCode: code, message: fmt.Sprintf(format, args...)}
+}
+
+// 转换为SQS的错误码, 限流和熔断返回503使SDK重试
+func sqsErrorOf(err error) *sqsError {
+	if e, ok := errors.Cause(err).(*sqsError); ok {
+		return e
+	}
+	switch {
+	case errors.IsNotFound(err):
+		return &sqsError{status: 400, code: "QueueDoesNotExist",
+			queryCode: "AWS.SimpleQueueService.NonExistentQueue", message: err.Error()}
+	case errors.IsNotValid(err), queue.IsMessageTooLarge(err):
+		return newSQSError(400, "InvalidParameterValue", "%s", err)
+	case queue.IsThrottled(err), queue.IsQuotaExceeded(err), isCircuitOpen(err):
+		return newSQSError(503, "RequestThrottled", "%s", err)
+	}
+	return newSQSError(500, "InternalError", "%s", err)
+}
+
+// 两种协议的参数统一转换为json协议的字段
+type sqsRequest struct {
+	QueueName              string
+	QueueUrl               string
+	MessageBody            string
+	MessageDeduplicationId string
+	DelaySeconds           int
+	MaxNumberOfMessages    int
+	VisibilityTimeout      *int
+	WaitTimeSeconds        int
+	ReceiptHandle          string
+	AttributeNames         []string
+}
+
+func parseSQSForm(r *http.Request) (string, *sqsRequest, error) {
+	if err := r.ParseForm(); err != nil {
+		return "", nil, newSQSError(400, "MalformedQueryString", "%s", err)
+	}
+	req := &sqsRequest{
+		QueueName:              r.FormValue("QueueName"),
+		QueueUrl:               r.FormValue("QueueUrl"),
+		MessageBody:            r.FormValue("MessageBody"),
+		MessageDeduplicationId: r.FormValue("MessageDeduplicationId"),
+		ReceiptHandle:          r.FormValue("ReceiptHandle"),
+	}
+	for i := 1; r.FormValue("AttributeName."+strconv.Itoa(i)) != ""; i++ {
+		req.AttributeNames = append(req.AttributeNames, r.FormValue("AttributeName."+strconv.Itoa(i)))
+	}
+	for key, value := range map[string]*int{
+		"DelaySeconds":        &req.DelaySeconds,
+		"MaxNumberOfMessages": &req.MaxNumberOfMessages,
+		"WaitTimeSeconds":     &req.WaitTimeSeconds,
+	} {
+		if v := r.FormValue(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return "", nil, newSQSError(400, "InvalidParameterValue", "%s %q", key, v)
+			}
+			*value = n
+		}
+	}
+	if v := r.FormValue("VisibilityTimeout"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return "", nil, newSQSError(400, "InvalidParameterValue", "VisibilityTimeout %q", v)
+		}
+		req.VisibilityTimeout = &n
+	}
+	// 旧版SDK把请求发到queue url上, 没有QueueUrl参数
+	if req.QueueUrl == "" && req.QueueName == "" && path.Base(r.URL.Path) != "sqs" {
+		req.QueueUrl = r.URL.Path
+	}
+	return r.FormValue("Action"), req, nil
+}
+
+// queue url的最后一段为queue:group
+func sqsQueue(queueURL string) (string, string, error) {
+	if queueURL == "" {
+		return "", "", newSQSError(400, "MissingParameter", "QueueUrl is required")
+	}
+	if i := strings.Index(queueURL, "?"); i >= 0 {
+		queueURL = queueURL[:i]
+	}
+	return sqsQueueName(path.Base(queueURL))
+}
+
+func sqsQueueName(name string) (string, string, error) {
+	names := strings.SplitN(name, ":", 2)
+	if names[0] == "" || names[0] == "." || names[0] == "/" {
+		return "", "", newSQSError(400, "InvalidParameterValue", "queue name %q", name)
+	}
+	if len(names) == 2 && names[1] != "" {
+		return names[0], names[1], nil
+	}
+	return names[0], sqsDefaultGroup, nil
+}
+
+type sqsGetQueueURLResult struct {
+	XMLName  xml.Name `xml:"GetQueueUrlResult" json:"-"`
+	QueueUrl string
+}
+
+type sqsSendResult struct {
+	XMLName          xml.Name `xml:"SendMessageResult" json:"-"`
+	MessageId        string
+	MD5OfMessageBody string
+}
+
+type sqsMessage struct {
+	MessageId     string
+	ReceiptHandle string
+	MD5OfBody     string
+	Body          string
+}
+
+type sqsReceiveResult struct {
+	XMLName  xml.Name     `xml:"ReceiveMessageResult" json:"-"`
+	Messages []sqsMessage `xml:"Message" json:"Messages,omitempty"`
+}
+
+type sqsAttribute struct {
+	Name  string
+	Value string
+}
+
+// xml中为Attribute列表, json中为map
+type sqsAttributesResult struct {
+	XMLName    xml.Name          `xml:"GetQueueAttributesResult" json:"-"`
+	Attributes []sqsAttribute    `xml:"Attribute" json:"-"`
+	Map        map[string]string `xml:"-" json:"Attributes"`
+}
+
+type sqsXMLResponse struct {
+	XMLName   xml.Name
+	Xmlns     string      `xml:"xmlns,attr"`
+	Result    interface{} `xml:",omitempty"`
+	RequestID string      `xml:"ResponseMetadata>RequestId"`
+}
+
+type sqsXMLError struct {
+	XMLName   xml.Name `xml:"ErrorResponse"`
+	Type      string   `xml:"Error>Type"`
+	Code      string   `xml:"Error>Code"`
+	Message   string   `xml:"Error>Message"`
+	RequestID string   `xml:"RequestId"`
+}
+
+// router.POST("/sqs", s.sqsHandler)
+// router.POST("/sqs/:queue", s.sqsHandler)
+func (s *Server) sqsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	var action string
+	req := &sqsRequest{}
+	var err error
+	jsonProtocol := strings.HasPrefix(r.Header.Get("X-Amz-Target"), sqsTargetPrefix)
+	if jsonProtocol {
+		action = strings.TrimPrefix(r.Header.Get("X-Amz-Target"), sqsTargetPrefix)
+		if err = json.NewDecoder(r.Body).Decode(req); err != nil {
+			err = newSQSError(400, "InvalidParameterValue", "%s", err)
+		}
+	} else {
+		action, req, err = parseSQSForm(r)
+	}
+
+	var result interface{}
+	if err == nil {
+		if err = s.sqsAuthorize(r); err == nil {
+			result, err = s.sqsAction(r.Context(), r.Host, action, req)
+		}
+	}
+
+	requestID := w.Header().Get(HeaderRequestID)
+	if err != nil {
+		e := sqsErrorOf(err)
+		backpressure(w, err)
+		errType := "Sender"
+		if e.status >= 500 {
+			errType = "Receiver"
+		}
+		w.Header().Set("x-amzn-query-error", e.queryCode+";"+errType)
+		if jsonProtocol {
+			w.Header().Set("Content-Type", sqsJSONType)
+			w.WriteHeader(e.status)
+			json.NewEncoder(w).Encode(map[string]string{"__type": "com.amazonaws.sqs#" + e.code, "message": e.message})
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		w.WriteHeader(e.status)
+		xml.NewEncoder(w).Encode(&sqsXMLError{Type: errType, Code: e.queryCode, Message: e.message, RequestID: requestID})
+		return
+	}
+
+	if jsonProtocol {
+		w.Header().Set("Content-Type", sqsJSONType)
+		if result == nil {
+			result = struct{}{}
+		}
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	xml.NewEncoder(w).Encode(&sqsXMLResponse{
+		XMLName:   xml.Name{Local: action + "Response"},
+		Xmlns:     sqsXMLNamespace,
+		Result:    result,
+		RequestID: requestID,
+	})
+}
+
+// 不校验SigV4签名, 也不使用其中的access key id, token只能通过X-Wqs-Token或Authorization: Bearer传递
+func (s *Server) sqsAuthorize(r *http.Request) error {
+	if !s.authEnable {
+		return nil
+	}
+	if err := s.queue.Authorize(requestToken(r), queue.RoleClient); err != nil {
+		if errors.IsUnauthorized(err) {
+			return newSQSError(403, "InvalidClientTokenId", "%s", err)
+		}
+		return newSQSError(403, "AccessDenied", "%s", err)
+	}
+	return nil
+}
+
+func (s *Server) sqsAction(ctx context.Context, host string, action string, req *sqsRequest) (interface{}, error) {
+	switch action {
+	case "GetQueueUrl":
+		name, group, err := sqsQueueName(req.QueueName)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = s.queue.GetSingleGroup(group, name); err != nil {
+			return nil, err
+		}
+		return &sqsGetQueueURLResult{QueueUrl: "http://" + host + "/sqs/" + name + ":" + group}, nil
+	case "SendMessage":
+		return s.sqsSend(ctx, req)
+	case "ReceiveMessage":
+		return s.sqsReceive(ctx, req)
+	case "DeleteMessage":
+		return nil, s.sqsDelete(ctx, req)
+	case "GetQueueAttributes":
+		return s.sqsAttributes(req)
+	}
+	return nil, newSQSError(400, "InvalidAction", "action %q is not supported", action)
+}
+
+func (s *Server) sqsSend(ctx context.Context, req *sqsRequest) (*sqsSendResult, error) {
+	name, group, err := sqsQueue(req.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if req.MessageBody == "" {
+		return nil, newSQSError(400, "MissingParameter", "MessageBody is required")
+	}
+	if req.DelaySeconds != 0 {
+		return nil, newSQSError(400, "InvalidParameterValue", "DelaySeconds is not supported")
+	}
+	// 去重id与HTTP接口的msgid相同, 去重窗口内只写入一次
+	id, err := s.queue.SendMessageWithID(ctx, name, group, []byte(req.MessageBody), 0, req.MessageDeduplicationId)
+	if err != nil {
+		return nil, err
+	}
+	return &sqsSendResult{MessageId: id, MD5OfMessageBody: sqsMD5(req.MessageBody)}, nil
+}
+
+// 没有消息时等待WaitTimeSeconds, 至少收到一条消息后返回
+func (s *Server) sqsReceive(ctx context.Context, req *sqsRequest) (*sqsReceiveResult, error) {
+	name, group, err := sqsQueue(req.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	max := req.MaxNumberOfMessages
+	if max == 0 {
+		max = 1
+	}
+	visibility := sqsDefaultVisibility
+	if req.VisibilityTimeout != nil {
+		visibility = *req.VisibilityTimeout
+	}
+	if max < 1 || max > sqsMaxMessages || req.WaitTimeSeconds < 0 || req.WaitTimeSeconds > sqsMaxWaitSeconds ||
+		visibility < 0 || visibility > sqsMaxVisibility {
+		return nil, newSQSError(400, "InvalidParameterValue",
+			"MaxNumberOfMessages %d, WaitTimeSeconds %d, VisibilityTimeout %d", max, req.WaitTimeSeconds, visibility)
+	}
+
+	owner := name + "@" + group
+	deadline := time.Now().Add(time.Duration(req.WaitTimeSeconds) * time.Second)
+	result := &sqsReceiveResult{}
+	for {
+		// 不可见时间内被重新投递的消息跳过, 限制次数避免请求耗时过长
+		for i := 0; len(result.Messages) < max && i < 2*sqsMaxMessages; i++ {
+			id, data, _, err := s.queue.RecvMessage(ctx, name, group)
+			if errors.Cause(err) == kafka.ErrTimeout || queue.IsPaused(err) || queue.IsUnassigned(err) {
+				break
+			}
+			if err != nil {
+				if len(result.Messages) > 0 {
+					break
+				}
+				return nil, err
+			}
+			if !s.sqs.hide(owner, id, time.Duration(visibility)*time.Second, time.Now()) {
+				continue
+			}
+			body := string(data)
+			result.Messages = append(result.Messages, sqsMessage{
+				MessageId:     id,
+				ReceiptHandle: id,
+				MD5OfBody:     sqsMD5(body),
+				Body:          body,
+			})
+		}
+		if len(result.Messages) > 0 || !time.Now().Before(deadline) {
+			return result, nil
+		}
+		select {
+		case <-time.After(sqsPollInterval):
+		case <-ctx.Done():
+			return result, nil
+		}
+	}
+}
+
+func (s *Server) sqsDelete(ctx context.Context, req *sqsRequest) error {
+	name, group, err := sqsQueue(req.QueueUrl)
+	if err != nil {
+		return err
+	}
+	if req.ReceiptHandle == "" {
+		return newSQSError(400, "MissingParameter", "ReceiptHandle is required")
+	}
+	if err = s.queue.AckMessage(ctx, name, group, req.ReceiptHandle); err != nil {
+		if errors.IsNotValid(err) {
+			return &sqsError{status: 400, code: "ReceiptHandleIsInvalid", queryCode: "ReceiptHandleIsInvalid", message: err.Error()}
+		}
+		return err
+	}
+	s.sqs.remove(req.ReceiptHandle)
+	return nil
+}
+
+// 堆积数来自group的消费进度, 不可见的消息数只统计本proxy
+func (s *Server) sqsAttributes(req *sqsRequest) (*sqsAttributesResult, error) {
+	name, group, err := sqsQueue(req.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = s.queue.GetSingleGroup(group, name); err != nil {
+		return nil, err
+	}
+	infos, err := s.queue.AccumulationStatus()
+	if err != nil {
+		return nil, err
+	}
+	var lag int64
+	for _, info := range infos {
+		if info.Queue == name && info.Group == group {
+			lag = info.Total - info.Consumed
+			break
+		}
+	}
+	hidden := int64(s.sqs.count(name+"@"+group, time.Now()))
+	visible := lag - hidden
+	if visible < 0 {
+		visible = 0
+	}
+
+	all := map[string]string{
+		"ApproximateNumberOfMessages":           strconv.FormatInt(visible, 10),
+		"ApproximateNumberOfMessagesNotVisible": strconv.FormatInt(hidden, 10),
+		"ApproximateNumberOfMessagesDelayed":    "0",
+		"VisibilityTimeout":                     strconv.Itoa(sqsDefaultVisibility),
+		"ReceiveMessageWaitTimeSeconds":         "0",
+		"DelaySeconds":                          "0",
+		"QueueArn":                              "arn:aws:sqs:wqs:000000000000:" + name + ":" + group,
+	}
+	result := &sqsAttributesResult{Map: make(map[string]string)}
+	for key, value := range all {
+		if sqsWanted(req.AttributeNames, key) {
+			result.Map[key] = value
+		}
+	}
+	keys := make([]string, 0, len(result.Map))
+	for key := range result.Map {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		result.Attributes = append(result.Attributes, sqsAttribute{Name: key, Value: result.Map[key]})
+	}
+	return result, nil
+}
+
+func sqsWanted(names []string, key string) bool {
+	for _, name := range names {
+		if name == "All" || name == key {
+			return true
+		}
+	}
+	return false
+}
+
+func sqsMD5(body string) string {
+	sum := md5.Sum([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// 引擎按固定的间隔重新投递未ack的消息, 记录每条消息的不可见截止时间, 截止前重新投递的消息跳过.
+// 实际的重新投递时间为不可见时间之后引擎的下一次重新投递
+type sqsVisibility struct {
+	mu        sync.Mutex
+	hidden    map[string]sqsHidden
+	lastSweep time.Time
+}
+
+type sqsHidden struct {
+	owner string
+	until time.Time
+}
+
+func newSQSVisibility() *sqsVisibility {
+	return &sqsVisibility{hidden: make(map[string]sqsHidden)}
+}
+
+// 返回false表示消息还在不可见时间内
+func (v *sqsVisibility) hide(owner string, id string, d time.Duration, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if h, ok := v.hidden[id]; ok && now.Before(h.until) {
+		return false
+	}
+	v.hidden[id] = sqsHidden{owner: owner, until: now.Add(d)}
+
+	// 被其他proxy消费或者没有删除的消息不会再调用remove, 定期清理
+	if now.Sub(v.lastSweep) > sqsSweepInterval {
+		for key, h := range v.hidden {
+			if !now.Before(h.until) {
+				delete(v.hidden, key)
+			}
+		}
+		v.lastSweep = now
+	}
+	return true
+}
+
+func (v *sqsVisibility) remove(id string) {
+	v.mu.Lock()
+	delete(v.hidden, id)
+	v.mu.Unlock()
+}
+
+func (v *sqsVisibility) count(owner string, now time.Time) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	n := 0
+	for _, h := range v.hidden {
+		if h.owner == owner && now.Before(h.until) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/engine/queue"
+
+	"github.com/juju/errors"
+)
+
+type sqsFakeQueue struct {
+	queue.Queue
+	sent  []string
+	msgs  []string
+	acked []string
+}
+
+func (q *sqsFakeQueue) GetSingleGroup(group string, name string) (*queue.GroupConfig, error) {
+	if name != "q1" {
+		return nil, errors.NotFoundf("queue %s", name)
+	}
+	return &queue.GroupConfig{}, nil
+}
+
+func (q *sqsFakeQueue) SendMessageWithID(ctx context.Context, name string, group string, data []byte, flag uint64, msgID string) (string, error) {
+	if name != "q1" {
+		return "", errors.NotFoundf("queue %s", name)
+	}
+	q.sent = append(q.sent, name+"@"+group+" "+string(data)+" "+msgID)
+	return "id-1", nil
+}
+
+func (q *sqsFakeQueue) RecvMessage(ctx context.Context, name string, group string) (string, []byte, uint64, error) {
+	if len(q.msgs) == 0 {
+		return "", nil, 0, kafka.ErrTimeout
+	}
+	data := q.msgs[0]
+	q.msgs = q.msgs[1:]
+	return "id-" + data, []byte(data), 0, nil
+}
+
+func (q *sqsFakeQueue) AckMessage(ctx context.Context, name string, group string, id string) error {
+	q.acked = append(q.acked, id)
+	return nil
+}
+
+func (q *sqsFakeQueue) AccumulationStatus() ([]queue.AccumulationInfo, error) {
+	return []queue.AccumulationInfo{{Queue: "q1", Group: "g1", Total: 10, Consumed: 4}}, nil
+}
+
+func sqsQuery(s *Server, body string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest("POST", "http://wqs/sqs", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.sqsHandler(w, r, nil)
+	return w
+}
+
+func sqsJSON(s *Server, action string, body string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest("POST", "http://wqs/sqs", strings.NewReader(body))
+	r.Header.Set("Content-Type", sqsJSONType)
+	r.Header.Set("X-Amz-Target", sqsTargetPrefix+action)
+	w := httptest.NewRecorder()
+	s.sqsHandler(w, r, nil)
+	return w
+}
+
+func TestSQSQueryProtocol(t *testing.T) {
+	q := &sqsFakeQueue{msgs: []string{"hello"}}
+	s := &Server{queue: q, sqs: newSQSVisibility()}
+
+	w := sqsQuery(s, "Action=GetQueueUrl&QueueName=q1:g1")
+	if !strings.Contains(w.Body.String(), "<QueueUrl>http://wqs/sqs/q1:g1</QueueUrl>") {
+		t.Errorf("GetQueueUrl response error: %s", w.Body)
+	}
+
+	w = sqsQuery(s, "Action=SendMessage&QueueUrl=http://wqs/sqs/q1:g1&MessageBody=hi&MessageDeduplicationId=d1")
+	result := &struct {
+		MessageId        string `xml:"SendMessageResult>MessageId"`
+		MD5OfMessageBody string `xml:"SendMessageResult>MD5OfMessageBody"`
+	}{}
+	if err := xml.Unmarshal(w.Body.Bytes(), result); err != nil || result.MessageId != "id-1" ||
+		result.MD5OfMessageBody != "49f68a5c8493ec2c0bf489821c21fc3b" {
+		t.Errorf("SendMessage response error: %s %v", w.Body, err)
+	}
+	if len(q.sent) != 1 || q.sent[0] != "q1@g1 hi d1" {
+		t.Errorf("sent messages error: %q", q.sent)
+	}
+
+	w = sqsQuery(s, "Action=ReceiveMessage&QueueUrl=http://wqs/sqs/q1:g1&MaxNumberOfMessages=5")
+	if !strings.Contains(w.Body.String(), "<Body>hello</Body>") || !strings.Contains(w.Body.String(), "<ReceiptHandle>id-hello</ReceiptHandle>") {
+		t.Errorf("ReceiveMessage response error: %s", w.Body)
+	}
+
+	w = sqsQuery(s, "Action=DeleteMessage&QueueUrl=http://wqs/sqs/q1:g1&ReceiptHandle=id-hello")
+	if w.Code != 200 || len(q.acked) != 1 || q.acked[0] != "id-hello" {
+		t.Errorf("DeleteMessage error: %d %s %q", w.Code, w.Body, q.acked)
+	}
+
+	w = sqsQuery(s, "Action=SendMessage&QueueUrl=http://wqs/sqs/q2&MessageBody=hi")
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "<Code>AWS.SimpleQueueService.NonExistentQueue</Code>") {
+		t.Errorf("expect non-existent queue error, got %d %s", w.Code, w.Body)
+	}
+	w = sqsQuery(s, "Action=CreateQueue&QueueName=q3")
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "<Code>InvalidAction</Code>") {
+		t.Errorf("expect invalid action error, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestSQSJSONProtocol(t *testing.T) {
+	q := &sqsFakeQueue{msgs: []string{"m1", "m1", "m2"}}
+	s := &Server{queue: q, sqs: newSQSVisibility()}
+
+	// 不可见时间内重新投递的m1被跳过
+	w := sqsJSON(s, "ReceiveMessage", `{"QueueUrl":"http://wqs/sqs/q1:g1","MaxNumberOfMessages":10,"VisibilityTimeout":60}`)
+	result := &sqsReceiveResult{}
+	if err := json.Unmarshal(w.Body.Bytes(), result); err != nil || len(result.Messages) != 2 ||
+		result.Messages[0].Body != "m1" || result.Messages[1].Body != "m2" {
+		t.Fatalf("ReceiveMessage response error: %s %v", w.Body, err)
+	}
+
+	w = sqsJSON(s, "GetQueueAttributes", `{"QueueUrl":"http://wqs/sqs/q1:g1","AttributeNames":["All"]}`)
+	attrs := &sqsAttributesResult{}
+	if err := json.Unmarshal(w.Body.Bytes(), attrs); err != nil ||
+		attrs.Map["ApproximateNumberOfMessages"] != "4" || attrs.Map["ApproximateNumberOfMessagesNotVisible"] != "2" {
+		t.Errorf("GetQueueAttributes response error: %s %v", w.Body, err)
+	}
+
+	w = sqsJSON(s, "SendMessage", `{"QueueUrl":"http://wqs/sqs/q1:g1"}`)
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "com.amazonaws.sqs#MissingParameter") {
+		t.Errorf("expect missing parameter error, got %d %s", w.Code, w.Body)
+	}
+
+	start := time.Now()
+	w = sqsJSON(s, "ReceiveMessage", `{"QueueUrl":"http://wqs/sqs/q1:g1","WaitTimeSeconds":1}`)
+	if time.Since(start) < time.Second || w.Body.String() != "{}\n" {
+		t.Errorf("long polling error: %v %s", time.Since(start), w.Body)
+	}
+}
+
+func TestSQSVisibility(t *testing.T) {
+	v := newSQSVisibility()
+	now := time.Now()
+	if !v.hide("q1@g1", "id-1", time.Minute, now) || v.hide("q1@g1", "id-1", time.Minute, now.Add(time.Second)) {
+		t.Errorf("message should be hidden within visibility timeout")
+	}
+	if !v.hide("q1@g1", "id-1", time.Minute, now.Add(2*time.Minute)) {
+		t.Errorf("message should be visible after visibility timeout")
+	}
+	if n := v.count("q1@g1", now.Add(2*time.Minute)); n != 1 {
+		t.Errorf("expect 1 hidden message, got %d", n)
+	}
+	v.remove("id-1")
+	if n := v.count("q1@g1", now.Add(2*time.Minute)); n != 0 {
+		t.Errorf("expect 0 hidden message after remove, got %d", n)
+	}
+}
+
+func TestSQSAuthorize(t *testing.T) {
+	q := &authQueue{roles: map[string]string{"c": queue.RoleClient}}
+	s := &Server{queue: q, authEnable: true}
+
+	// SigV4签名中的access key id不作为token
+	r, _ := http.NewRequest("POST", "http://wqs/sqs", nil)
+	r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=c/20260101/us-east-1/sqs/aws4_request, SignedHeaders=host, Signature=00")
+	if err, ok := s.sqsAuthorize(r).(*sqsError); !ok || err.code != "InvalidClientTokenId" {
+		t.Errorf("expect InvalidClientTokenId for SigV4 credential, got %v", err)
+	}
+	r, _ = http.NewRequest("POST", "http://wqs/sqs?X-Amz-Credential=c/20260101", nil)
+	if err := s.sqsAuthorize(r); err == nil {
+		t.Error("expect presigned credential rejected")
+	}
+
+	r.Header.Set("X-Wqs-Token", "c")
+	if err := s.sqsAuthorize(r); err != nil {
+		t.Errorf("expect X-Wqs-Token accepted, got %v", err)
+	}
+}
@@ -19,6 +19,8 @@ package service
 import (
 	"bytes"
 	"encoding/json"
+
+	"github.com/weibocom/wqs/engine/queue"
 )
 
 const (
@@ -26,23 +28,117 @@ const (
 	LoggerClose = "close"
 )
 
+// 出错时(code >= 400)返回机器可读的错误码, 以及相同的请求是否可以重试
+// 参数校验失败时errors包含每个不合法参数的原因
 type ResponseMessage struct {
-	Code    int    `json:"code"`
-	Message string `json:"msg,omitempty"`
+	Code      int             `json:"code"`
+	Message   string          `json:"msg,omitempty"`
+	ErrorCode queue.ErrorCode `json:"error_code,omitempty"`
+	Retryable bool            `json:"retryable,omitempty"`
+	Errors    []FieldError    `json:"errors,omitempty"`
+}
+
+// 没有指定错误码时按状态码补充
+func (m *ResponseMessage) fillErrorCode() {
+	if m.Code >= 400 && m.ErrorCode == "" {
+		m.ErrorCode = queue.ErrorCodeOfStatus(m.Code)
+		m.Retryable = m.ErrorCode.Retryable()
+	}
 }
 
 func (m *ResponseMessage) String() string {
+	m.fillErrorCode()
 	data := &bytes.Buffer{}
 	json.NewEncoder(data).Encode(m)
 	return data.String()
 }
 
 func (m *ResponseMessage) Bytes() []byte {
+	m.fillErrorCode()
 	data := &bytes.Buffer{}
 	json.NewEncoder(data).Encode(m)
 	return data.Bytes()
 }
 
 type QueueAttr struct {
-	Idcs []string `json:"idcs,omitempty"`
+	Idcs    []string          `json:"idcs,omitempty"`
+	Ordered bool              `json:"ordered,omitempty"`
+	Compact bool              `json:"compact,omitempty"`
+	Expire  int64             `json:"expire,omitempty"`
+	Profile string            `json:"profile,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty"`
+}
+
+// 将同一个group配置应用到多个queue
+type GroupQueuesAttr struct {
+	Queues []string `json:"queues"`
+	Write  bool     `json:"write"`
+	Read   bool     `json:"read"`
+	Url    string   `json:"url"`
+	Ips    []string `json:"ips"`
+	Start  string   `json:"start,omitempty"`
+}
+
+// sarama.OffsetNewest(-1)表示最新, sarama.OffsetOldest(-2)表示最早, 其他值为毫秒时间戳
+type OffsetAttr struct {
+	Time int64 `json:"time"`
+}
+
+// 指定partition接收时客户端提交的下次接收的位置
+type PartitionOffsetAttr struct {
+	Offset int64 `json:"offset"`
+}
+
+// 消息有效期, 单位秒, 0表示不过期
+type TTLAttr struct {
+	TTL int64 `json:"ttl"`
+}
+
+type IdempotentAttr struct {
+	Idempotent bool `json:"idempotent"`
+}
+
+// producer缓冲区满时的策略, 为空时使用配置文件中的默认策略
+type OverflowAttr struct {
+	Overflow string `json:"overflow"`
+}
+
+// 是否记录消息轨迹
+type TraceAttr struct {
+	Enable bool `json:"enable"`
+}
+
+// 加密使用的密钥名字
+type EncryptionAttr struct {
+	Key string `json:"key"`
+}
+
+type TagsAttr struct {
+	Tags map[string]string `json:"tags"`
+}
+
+type BroadcastAttr struct {
+	Broadcast bool `json:"broadcast"`
+}
+
+type CanaryAttr struct {
+	Percent int `json:"percent"`
+}
+
+type WorkersAttr struct {
+	Workers int `json:"workers"`
+}
+
+type LogLevelAttr struct {
+	Level string `json:"level"`
+}
+
+// 全局日志级别和单独设置过级别的模块
+type LogLevels struct {
+	Global  string            `json:"global"`
+	Modules map[string]string `json:"modules"`
+}
+
+type TokenAttr struct {
+	Role string `json:"role"`
 }
@@ -0,0 +1,102 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/juju/errors"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// 配置了client.ca.file时默认要求客户端证书
+var tlsClientAuths = map[string]tls.ClientAuthType{
+	"none":    tls.NoClientCert,
+	"request": tls.VerifyClientCertIfGiven,
+	"require": tls.RequireAndVerifyClientCert,
+}
+
+// 读取tls配置, 未配置tls.cert.file时返回nil, HTTP接口仍使用明文
+func loadTLSConfig(conf *config.Config) (*tls.Config, error) {
+	section, err := conf.GetSection("tls")
+	if err != nil {
+		return nil, nil
+	}
+	certFile := section.GetStringMust("cert.file", "")
+	if certFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, section.GetStringMust("key.file", ""))
+	if err != nil {
+		return nil, errors.Annotatef(err, "load tls certificate %s", certFile)
+	}
+
+	version, ok := tlsVersions[section.GetStringMust("min.version", "1.2")]
+	if !ok {
+		return nil, errors.NotValidf("tls.min.version %s", section.GetStringMust("min.version", ""))
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   version,
+	}
+
+	caFile := section.GetStringMust("client.ca.file", "")
+	auth := "none"
+	if caFile != "" {
+		auth = "require"
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.Annotatef(err, "load tls client ca %s", caFile)
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(data) {
+			return nil, errors.NotValidf("tls client ca %s", caFile)
+		}
+	}
+	auth = section.GetStringMust("client.auth", auth)
+	if tlsConfig.ClientAuth, ok = tlsClientAuths[auth]; !ok {
+		return nil, errors.NotValidf("tls.client.auth %s", auth)
+	}
+	if tlsConfig.ClientAuth != tls.NoClientCert && tlsConfig.ClientCAs == nil {
+		return nil, errors.NotValidf("tls.client.auth %s without tls.client.ca.file", auth)
+	}
+	return tlsConfig, nil
+}
+
+// 开启tls时由net/http在ALPN协商到h2时使用HTTP/2, tls.http2=false时只支持HTTP/1.1
+func newHTTPServer(conf *config.Config, handler http.Handler) (*http.Server, error) {
+	tlsConfig, err := loadTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: handler, TLSConfig: tlsConfig}
+	if section, err := conf.GetSection("tls"); err == nil && tlsConfig != nil && !section.GetBoolMust("http2", true) {
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	return server, nil
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// 生成parent签发的证书, parent为nil时生成自签名的CA
+func newTestCert(t *testing.T, dir, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer := &testCert{cert: template, key: key}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer = parent
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer.cert, &key.PublicKey, signer.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return &testCert{cert: cert, key: key}
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wqs-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCert(t, dir, "ca", nil)
+	newTestCert(t, dir, "server", ca)
+	newTestCert(t, dir, "client", ca)
+
+	load := func(extra string) (*http.Server, error) {
+		conf, err := config.NewConfigFromBytes([]byte(testReloadConfig + extra))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newHTTPServer(conf, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}))
+	}
+
+	if server, err := load(""); err != nil || server.TLSConfig != nil {
+		t.Fatalf("expect plain http without tls config, got %v %v", server, err)
+	}
+	if _, err := load("tls.cert.file=" + filepath.Join(dir, "noexist.crt") + "\n"); err == nil {
+		t.Error("expect error for missing certificate")
+	}
+	serverConf := "tls.cert.file=" + filepath.Join(dir, "server.crt") + "\n" +
+		"tls.key.file=" + filepath.Join(dir, "server.key") + "\n"
+	if _, err := load(serverConf + "tls.client.auth=require\n"); err == nil {
+		t.Error("expect error for client auth without ca")
+	}
+	server, err := load(serverConf + "tls.client.ca.file=" + filepath.Join(dir, "ca.crt") + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.TLSConfig.ClientAuth != tls.RequireAndVerifyClientCert || server.TLSConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("unexpected tls config %+v", server.TLSConfig)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go server.ServeTLS(listener, "", "")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientCert, _ := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	get := func(certs []tls.Certificate) (string, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs},
+			ForceAttemptHTTP2: true,
+		}}
+		resp, err := client.Get("https://" + listener.Addr().String() + "/")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return string(data), nil
+	}
+	if proto, err := get([]tls.Certificate{clientCert}); err != nil || proto != "HTTP/2.0" {
+		t.Errorf("expect HTTP/2.0 with client certificate, got %q %v", proto, err)
+	}
+	if _, err := get(nil); err == nil {
+		t.Error("expect handshake error without client certificate")
+	}
+}
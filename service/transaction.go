@@ -0,0 +1,66 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/weibocom/wqs/engine/queue"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type TransactionMessage struct {
+	Queue string `json:"queue"`
+	Group string `json:"group"`
+	Msg   string `json:"msg"`
+}
+
+type TransactionRequest struct {
+	Messages []TransactionMessage `json:"messages"`
+}
+
+type TransactionResponse struct {
+	Ids []string `json:"ids"`
+}
+
+// 在一个kafka事务中写入一批消息, 可以跨queue, 全部成功或全部失败. 需要开启kafka.transaction.enable
+// router.POST("/transactions", s.auth(client, s.identify(s.transactionHandler)))
+func (s *Server) transactionHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	req := TransactionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, 400, err.Error())
+		return
+	}
+	msgs := make([]queue.BatchMessage, len(req.Messages))
+	for i, m := range req.Messages {
+		msgs[i] = queue.BatchMessage{Queue: m.Queue, Group: m.Group, Data: []byte(m.Msg)}
+	}
+	ids, err := s.queue.SendMessages(r.Context(), msgs)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	data, err := json.Marshal(TransactionResponse{Ids: ids})
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	response(w, 200, string(data))
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/weibocom/wqs/engine/queue"
+)
+
+type txnQueue struct {
+	queue.Queue
+	msgs []queue.BatchMessage
+}
+
+func (q *txnQueue) SendMessages(ctx context.Context, msgs []queue.BatchMessage) ([]string, error) {
+	if len(msgs) == 0 {
+		return nil, errors.NotValidf("0 messages in transaction")
+	}
+	q.msgs = msgs
+	ids := make([]string, len(msgs))
+	for i := range msgs {
+		ids[i] = "id" + string(rune('0'+i))
+	}
+	return ids, nil
+}
+
+func TestTransactionHandler(t *testing.T) {
+	q := &txnQueue{}
+	s := &Server{queue: q}
+	do := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.transactionHandler(w, httptest.NewRequest("POST", "http://example.com/transactions", strings.NewReader(body)), nil)
+		return w
+	}
+
+	w := do(`{"messages":[{"queue":"q1","group":"g1","msg":"a"},{"queue":"q2","group":"g1","msg":"b"}]}`)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `{\"ids\":[\"id0\",\"id1\"]}`) {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body)
+	}
+	expect := []queue.BatchMessage{{Queue: "q1", Group: "g1", Data: []byte("a")}, {Queue: "q2", Group: "g1", Data: []byte("b")}}
+	if !reflect.DeepEqual(q.msgs, expect) {
+		t.Errorf("unexpected messages %+v", q.msgs)
+	}
+	if w := do(`{"messages":[]}`); w.Code != 400 {
+		t.Errorf("expect 400, got %d %s", w.Code, w.Body)
+	}
+	if w := do(`{"messages":`); w.Code != 400 {
+		t.Errorf("expect 400, got %d %s", w.Code, w.Body)
+	}
+}
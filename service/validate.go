@@ -0,0 +1,189 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/weibocom/wqs/engine/queue"
+
+	"github.com/juju/errors"
+	"github.com/julienschmidt/httprouter"
+)
+
+// 单个参数不合法的原因
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// 参数校验失败, 包含所有不合法的参数, 错误码为INVALID_REQUEST
+type ValidationError struct {
+	Errors []FieldError
+	cause  error
+}
+
+func (e *ValidationError) Error() string {
+	return e.cause.Error()
+}
+
+// 使errors.IsNotValid对校验错误成立
+func (e *ValidationError) Cause() error {
+	return e.cause
+}
+
+// 收集所有参数的错误, 不在第一个错误处停止
+type validator struct {
+	errs []FieldError
+}
+
+func (v *validator) fail(field, format string, args ...interface{}) {
+	v.errs = append(v.errs, FieldError{Field: field, Reason: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) required(field, value string) {
+	if value == "" {
+		v.fail(field, "is required")
+	}
+}
+
+// 空值由required检查, 下同. 名字的规则与engine相同
+func (v *validator) name(field, value string) {
+	if value == "" {
+		return
+	}
+	if reason := queue.InvalidNameReason(value); reason != "" {
+		v.fail(field, reason)
+	}
+}
+
+func (v *validator) integer(field, value string, min, max int64) {
+	if value == "" {
+		return
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	switch {
+	case err != nil:
+		v.fail(field, "%q is not an integer", value)
+	case n < min || n > max:
+		v.fail(field, "should be in [%d, %d]", min, max)
+	}
+}
+
+func (v *validator) boolean(field, value string) {
+	if value == "" {
+		return
+	}
+	if _, err := strconv.ParseBool(value); err != nil {
+		v.fail(field, "%q is not a boolean", value)
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	reasons := make([]string, len(v.errs))
+	for i, e := range v.errs {
+		reasons[i] = e.Field + " " + e.Reason
+	}
+	return &ValidationError{Errors: v.errs, cause: errors.NewNotValid(nil, strings.Join(reasons, "; "))}
+}
+
+// 路径参数的校验规则, 没有规则的参数由handler自己检查
+var pathRules = map[string]func(v *validator, field, value string){
+	"queue":     (*validator).name,
+	"group":     (*validator).name,
+	"partition": func(v *validator, field, value string) { v.integer(field, value, 0, math.MaxInt32) },
+	"offset":    func(v *validator, field, value string) { v.integer(field, value, 0, math.MaxInt64) },
+}
+
+// 查询参数和兼容接口表单参数的校验规则, 各接口offset的含义不同, 只检查是否为整数
+var formRules = map[string]func(v *validator, field, value string){
+	"queue":     (*validator).name,
+	"group":     (*validator).name,
+	"partition": func(v *validator, field, value string) { v.integer(field, value, 0, math.MaxInt32) },
+	"offset":    func(v *validator, field, value string) { v.integer(field, value, math.MinInt64, math.MaxInt64) },
+	"limit":     func(v *validator, field, value string) { v.integer(field, value, 0, maxLookupLimit) },
+	"priority":  func(v *validator, field, value string) { v.integer(field, value, 0, math.MaxInt32) },
+	"write":     (*validator).boolean,
+	"read":      (*validator).boolean,
+}
+
+// 兼容接口出错时返回200, 在handler中按action校验
+var compatPaths = map[string]bool{"/queue": true, "/group": true, "/msg": true}
+
+// 兼容接口每个action必须的参数
+var (
+	queueActionParams = map[string][]string{"create": {"queue"}, "remove": {"queue"}, "update": {"queue"}}
+	groupActionParams = map[string][]string{"add": {"group", "queue"}, "remove": {"group", "queue"}, "update": {"group", "queue"}}
+	msgActionParams   = map[string][]string{"send": {"queue", "group"}, "receive": {"queue", "group"}, "ack": {"queue", "group"}}
+)
+
+func validateParams(ps httprouter.Params, query url.Values) error {
+	v := &validator{}
+	for _, p := range ps {
+		if rule, ok := pathRules[p.Key]; ok {
+			v.required(p.Key, p.Value)
+			rule(v, p.Key, p.Value)
+		}
+	}
+	validateValues(v, query)
+	return v.err()
+}
+
+func validateForm(form url.Values, required ...string) error {
+	v := &validator{}
+	for _, field := range required {
+		v.required(field, form.Get(field))
+	}
+	validateValues(v, form)
+	return v.err()
+}
+
+// 按参数名排序, 错误的顺序固定
+func validateValues(v *validator, values url.Values) {
+	fields := make([]string, 0, len(values))
+	for field := range values {
+		if _, ok := formRules[field]; ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		formRules[field](v, field, values.Get(field))
+	}
+}
+
+// 校验路径参数和查询参数, 不合法时返回400和每个参数的错误
+func (r *Router) validate(w http.ResponseWriter, req *http.Request) bool {
+	if compatPaths[req.URL.Path] {
+		return true
+	}
+	_, ps, _ := r.Router.Lookup(req.Method, req.URL.Path)
+	if err := validateParams(ps, req.URL.Query()); err != nil {
+		errorResponse(w, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/weibocom/wqs/engine/queue"
+
+	"github.com/juju/errors"
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestValidateParams(t *testing.T) {
+	ps := httprouter.Params{{Key: "queue", Value: "q-1"}, {Key: "group", Value: "g1"}, {Key: "partition", Value: "-1"}}
+	query := url.Values{"limit": {"5000"}, "write": {"yes"}, "offset": {"-1"}, "prefix": {"a-b"}}
+	err := validateParams(ps, query)
+	if !errors.IsNotValid(err) || queue.ErrorCodeOf(err) != queue.ErrCodeInvalidRequest {
+		t.Fatalf("expect not valid error, got %v", err)
+	}
+	var fields []string
+	for _, e := range err.(*ValidationError).Errors {
+		fields = append(fields, e.Field)
+	}
+	if want := []string{"queue", "partition", "limit", "write"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields %v, want %v", fields, want)
+	}
+
+	ps = httprouter.Params{{Key: "queue", Value: "q1"}, {Key: "offset", Value: "10"}, {Key: "id", Value: "x-y"}}
+	if err := validateParams(ps, url.Values{"limit": {"10"}}); err != nil {
+		t.Errorf("valid params: %v", err)
+	}
+}
+
+func TestValidateForm(t *testing.T) {
+	err := validateForm(url.Values{"group": {"g1"}, "read": {"true"}}, groupActionParams["add"]...)
+	if v, ok := err.(*ValidationError); !ok || len(v.Errors) != 1 || v.Errors[0].Field != "queue" {
+		t.Fatalf("expect queue required, got %v", err)
+	}
+	if err := validateForm(url.Values{"action": {"lookup"}}, queueActionParams["lookup"]...); err != nil {
+		t.Errorf("lookup without queue: %v", err)
+	}
+}
+
+func TestRouterValidate(t *testing.T) {
+	called := false
+	router := NewRouter()
+	router.GET("/queue/:queue/:group/partitions/:partition", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		called = true
+	})
+	router.GET("/queue", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		called = true
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/queue/q1/g.1/partitions/x?limit=a", nil))
+	msg := &ResponseMessage{}
+	if err := json.Unmarshal(w.Body.Bytes(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if called || w.Code != 400 || msg.ErrorCode != queue.ErrCodeInvalidRequest || len(msg.Errors) != 3 {
+		t.Errorf("invalid request: %d %s", w.Code, w.Body.String())
+	}
+
+	// 兼容接口在handler中校验
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/queue?queue=a-b", nil))
+	if !called {
+		t.Error("compatible request should reach handler")
+	}
+}
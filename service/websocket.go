@@ -0,0 +1,203 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// RFC 6455的最小实现, 只用于服务端: 读取客户端的帧(必须mask), 写出不mask的帧
+const (
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// 客户端只发送ack和控制帧
+	wsMaxMessage = 1 << 20
+
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+
+	wsCloseNormal        = 1000
+	wsCloseGoingAway     = 1001
+	wsCloseProtocolError = 1002
+	wsCloseInternalError = 1011
+	wsCloseTryAgainLater = 1013
+)
+
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+	mu   sync.Mutex
+	// 分片之间可以插入控制帧, 保存未读完的数据消息
+	opcode  byte
+	message []byte
+}
+
+func isWebSocket(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+func websocketAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// 握手失败时返回400, 成功后连接由调用方负责关闭
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != "GET" || !isWebSocket(r) || key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		response(w, 400, "websocket handshake required")
+		return nil, errors.NotValidf("websocket handshake")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		response(w, 500, "websocket not supported")
+		return nil, errors.NotSupportedf("hijack")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// 接管后的连接保留了http.Server设置的读写超时, 长连接需要清除
+	conn.SetDeadline(time.Time{})
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n\r\n")
+	if err = rw.Flush(); err != nil {
+		conn.Close()
+		return nil, errors.Trace(err)
+	}
+	return &wsConn{conn: conn, r: rw.Reader, w: rw.Writer}, nil
+}
+
+// 返回一个完整的数据消息或者一个控制帧, 分片的数据消息合并后返回
+func (c *wsConn) readMessage() (byte, []byte, error) {
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch {
+		case op >= wsClose:
+			return op, payload, nil
+		case op == wsContinuation && c.opcode == 0, op != wsContinuation && c.opcode != 0:
+			return 0, nil, errors.NotValidf("websocket fragment opcode %d", op)
+		case op != wsContinuation:
+			c.opcode = op
+		}
+		if len(c.message)+len(payload) > wsMaxMessage {
+			return 0, nil, errors.NotValidf("websocket message larger than %d", wsMaxMessage)
+		}
+		c.message = append(c.message, payload...)
+		if fin {
+			opcode, message := c.opcode, c.message
+			c.opcode, c.message = 0, nil
+			return opcode, message, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0f
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, errors.NotValidf("unmasked websocket frame")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxMessage || (opcode >= wsClose && (length > 125 || !fin)) {
+		return false, 0, nil, errors.NotValidf("websocket frame opcode %d length %d", opcode, length)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// 可以被多个goroutine调用
+func (c *wsConn) writeMessage(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.w.WriteByte(0x80 | opcode)
+	switch length := len(payload); {
+	case length < 126:
+		c.w.WriteByte(byte(length))
+	case length <= 0xffff:
+		c.w.WriteByte(126)
+		binary.Write(c.w, binary.BigEndian, uint16(length))
+	default:
+		c.w.WriteByte(127)
+		binary.Write(c.w, binary.BigEndian, uint64(length))
+	}
+	c.w.Write(payload)
+	return c.w.Flush()
+}
+
+// 发送close帧后关闭连接, reason超过控制帧的长度时截断
+func (c *wsConn) close(code uint16, reason string) error {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, code)
+	c.writeMessage(wsClose, append(payload, reason...))
+	return c.conn.Close()
+}
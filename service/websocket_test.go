@@ -0,0 +1,190 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/engine/queue"
+)
+
+type wsQueue struct {
+	queue.Queue
+	mu    sync.Mutex
+	msgs  []string
+	acked chan string
+}
+
+func (q *wsQueue) RecvMessage(ctx context.Context, name string, group string) (string, []byte, uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.msgs) == 0 {
+		return "", nil, 0, kafka.ErrTimeout
+	}
+	data := q.msgs[0]
+	q.msgs = q.msgs[1:]
+	return "id-" + data, []byte(data), 1, nil
+}
+
+func (q *wsQueue) AckMessage(ctx context.Context, name string, group string, id string) error {
+	q.acked <- id
+	return nil
+}
+
+// 客户端发送的帧需要mask
+func wsClientFrame(opcode byte, payload []byte) []byte {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func wsReadServerFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	var header [2]byte
+	if _, err := r.Read(header[:1]); err != nil {
+		t.Fatal(err)
+	}
+	header[1], _ = r.ReadByte()
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		var ext [2]byte
+		r.Read(ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	for n := 0; n < length; {
+		m, err := r.Read(payload[n:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += m
+	}
+	return header[0] & 0x0f, payload
+}
+
+func TestWebsocketAccept(t *testing.T) {
+	// RFC 6455 1.3中的例子
+	if accept := websocketAccept("dGhlIHNhbXBsZSBub25jZQ=="); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("websocket accept error: %s", accept)
+	}
+}
+
+func TestWebsocketReadMessage(t *testing.T) {
+	data := wsClientFrame(wsText, []byte("he"))
+	data[0] &^= 0x80
+	data = append(data, wsClientFrame(wsPing, []byte("p"))...)
+	data = append(data, wsClientFrame(wsContinuation, []byte("llo"))...)
+	data = append(data, 0x81, 0x01, 'x')
+
+	conn := &wsConn{r: bufio.NewReader(strings.NewReader(string(data)))}
+	if opcode, payload, err := conn.readMessage(); err != nil || opcode != wsPing || string(payload) != "p" {
+		t.Errorf("expect ping between fragments, got %d %q %v", opcode, payload, err)
+	}
+	if opcode, payload, err := conn.readMessage(); err != nil || opcode != wsText || string(payload) != "hello" {
+		t.Errorf("expect fragmented text, got %d %q %v", opcode, payload, err)
+	}
+	if _, _, err := conn.readMessage(); err == nil {
+		t.Errorf("unmasked frame should fail")
+	}
+}
+
+func TestWsMsgHandler(t *testing.T) {
+	q := &wsQueue{msgs: []string{"m1", "m2", "m3"}, acked: make(chan string, 3)}
+	s := &Server{queue: q, reloader: reloader{dying: make(chan struct{})}}
+	router := NewRouter()
+	router.GET("/ws/msg", s.wsMsgHandler)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/ws/msg?queue=q1&group=g1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("expect 400 without handshake, got %d", resp.StatusCode)
+	}
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("GET /ws/msg?queue=q1&group=g1&window=2 HTTP/1.1\r\nHost: test\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nAccept-Encoding: gzip\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+
+	r := bufio.NewReader(conn)
+	resp, err = http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 101 || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake error: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	// window为2, ack之前只推送两条
+	var msgs []wsMessage
+	for i := 0; i < 2; i++ {
+		opcode, payload := wsReadServerFrame(t, r)
+		msg := wsMessage{}
+		if err = json.Unmarshal(payload, &msg); opcode != wsText || err != nil {
+			t.Fatalf("expect text message, got %d %q", opcode, payload)
+		}
+		msgs = append(msgs, msg)
+	}
+	if msgs[0].ID != "id-m1" || msgs[0].Msg != "m1" || msgs[0].Flag != 1 || msgs[1].ID != "id-m2" {
+		t.Errorf("messages error: %+v", msgs)
+	}
+	select {
+	case id := <-q.acked:
+		t.Fatalf("unexpect ack %s", id)
+	default:
+	}
+
+	conn.Write(wsClientFrame(wsText, []byte(`{"ack":"id-m1"}`)))
+	if id := <-q.acked; id != "id-m1" {
+		t.Errorf("expect ack id-m1, got %s", id)
+	}
+	if _, payload := wsReadServerFrame(t, r); !strings.Contains(string(payload), `"id":"id-m3"`) {
+		t.Errorf("expect m3 after ack, got %q", payload)
+	}
+
+	conn.Write(wsClientFrame(wsPing, []byte("hi")))
+	if opcode, payload := wsReadServerFrame(t, r); opcode != wsPong || string(payload) != "hi" {
+		t.Errorf("expect pong, got %d %q", opcode, payload)
+	}
+	conn.Write(wsClientFrame(wsClose, nil))
+	if opcode, payload := wsReadServerFrame(t, r); opcode != wsClose || binary.BigEndian.Uint16(payload) != wsCloseNormal {
+		t.Errorf("expect close, got %d %q", opcode, payload)
+	}
+}
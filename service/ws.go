@@ -0,0 +1,173 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/weibocom/wqs/engine/kafka"
+	"github.com/weibocom/wqs/engine/queue"
+	"github.com/weibocom/wqs/log"
+
+	"github.com/juju/errors"
+)
+
+const (
+	// 每个连接上已经推送但没有ack的消息数上限
+	wsDefaultWindow = 16
+	wsMaxWindow     = 1024
+	// 没有消息或者group暂停时的重试间隔
+	wsPollInterval = 100 * time.Millisecond
+	wsPingInterval = 30 * time.Second
+)
+
+// 推送给客户端的消息, 客户端处理后回复wsAck
+type wsMessage struct {
+	ID   string `json:"id"`
+	Msg  string `json:"msg"`
+	Flag uint64 `json:"flag"`
+}
+
+type wsAck struct {
+	Ack   string `json:"ack"`
+	Error string `json:"error,omitempty"`
+}
+
+// router.GET("/ws/msg", s.auth(client, s.wsMsgHandler))
+func (s *Server) wsMsgHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	name, group := r.FormValue("queue"), r.FormValue("group")
+	if name == "" || group == "" {
+		response(w, 400, "queue and group are required")
+		return
+	}
+	window := wsDefaultWindow
+	if v := r.FormValue("window"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > wsMaxWindow {
+			response(w, 400, fmt.Sprintf("window should be in [1, %d]", wsMaxWindow))
+			return
+		}
+		window = n
+	}
+
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		log.Warnf("websocket upgrade from %s failed: %s", r.RemoteAddr, err)
+		return
+	}
+	s.streamMessages(withClient(context.Background(), requestClient(r)), conn, name, group, window)
+}
+
+// 读取客户端的ack和控制帧, 连接断开或者出错时通过done返回
+func (s *Server) wsReadLoop(conn *wsConn, acks chan<- string, done chan<- error, stop <-chan struct{}) {
+	for {
+		opcode, payload, err := conn.readMessage()
+		if err != nil {
+			done <- err
+			return
+		}
+		switch opcode {
+		case wsPing:
+			conn.writeMessage(wsPong, payload)
+		case wsClose:
+			done <- nil
+			return
+		case wsText, wsBinary:
+			ack := &wsAck{}
+			if err = json.Unmarshal(payload, ack); err != nil || ack.Ack == "" {
+				done <- errors.NotValidf("ack frame %q", payload)
+				return
+			}
+			select {
+			case acks <- ack.Ack:
+			case <-stop:
+				return
+			}
+		}
+	}
+}
+
+// 未ack的消息达到window时暂停推送, 连接断开时未ack的消息在超时后重新投递
+func (s *Server) streamMessages(ctx context.Context, conn *wsConn, name string, group string, window int) {
+
+	acks := make(chan string, window)
+	done := make(chan error, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.wsReadLoop(conn, acks, done, stop)
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	inflight := make(map[string]struct{}, window)
+	var wait <-chan time.Time
+	for {
+		if len(inflight) < window && wait == nil {
+			id, data, flag, err := s.queue.RecvMessage(ctx, name, group)
+			switch {
+			case err == nil:
+				payload, _ := json.Marshal(&wsMessage{ID: id, Msg: string(data), Flag: flag})
+				if err = conn.writeMessage(wsText, payload); err != nil {
+					conn.conn.Close()
+					return
+				}
+				inflight[id] = struct{}{}
+				continue
+			case queue.IsUnassigned(err):
+				// 与HTTP接口一致, 客户端应该换到其他proxy
+				conn.close(wsCloseTryAgainLater, "unassigned")
+				return
+			case errors.Cause(err) == kafka.ErrTimeout || queue.IsPaused(err):
+				wait = time.After(wsPollInterval)
+			default:
+				conn.close(wsCloseInternalError, err.Error())
+				return
+			}
+		}
+
+		select {
+		case id := <-acks:
+			delete(inflight, id)
+			if err := s.queue.AckMessage(ctx, name, group, id); err != nil {
+				payload, _ := json.Marshal(&wsAck{Ack: id, Error: err.Error()})
+				conn.writeMessage(wsText, payload)
+			}
+		case <-wait:
+			wait = nil
+		case <-ping.C:
+			conn.writeMessage(wsPing, nil)
+		case err := <-done:
+			if err != nil {
+				log.Debugf("websocket %s@%s from %s closed: %s", name, group, conn.conn.RemoteAddr(), err)
+				conn.close(wsCloseProtocolError, err.Error())
+				return
+			}
+			conn.close(wsCloseNormal, "")
+			return
+		case <-s.reloader.dying:
+			conn.close(wsCloseGoingAway, "server stopping")
+			return
+		}
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Shopify/sarama"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 将trace context保存在kafka消息的header中, 需要kafka 0.11以上
+type headerCarrier struct {
+	headers []sarama.RecordHeader
+}
+
+func (c *headerCarrier) Get(key string) string {
+	for _, h := range c.headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c *headerCarrier) Set(key string, value string) {
+	for i, h := range c.headers {
+		if string(h.Key) == key {
+			c.headers[i].Value = []byte(value)
+			return
+		}
+	}
+	c.headers = append(c.headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (c *headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.headers))
+	for _, h := range c.headers {
+		keys = append(keys, string(h.Key))
+	}
+	return keys
+}
+
+// 返回携带ctx中trace context的消息header, ctx中没有有效的span时返回nil
+func InjectHeaders(ctx context.Context) []sarama.RecordHeader {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+	carrier := &headerCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.headers
+}
+
+// 从消息header中取出发送方的span context, 没有时返回无效的SpanContext
+func ExtractHeaders(headers []*sarama.RecordHeader) trace.SpanContext {
+	carrier := &headerCarrier{headers: make([]sarama.RecordHeader, 0, len(headers))}
+	for _, h := range headers {
+		if h != nil {
+			carrier.headers = append(carrier.headers, *h)
+		}
+	}
+	return trace.SpanContextFromContext(propagator.Extract(context.Background(), carrier))
+}
+
+// 从HTTP请求头(traceparent)中取出上游的trace context
+func ExtractHTTP(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// 将sc写入HTTP响应头, 客户端可以以此继续消息的trace
+func InjectHTTP(sc trace.SpanContext, header http.Header) {
+	if !sc.IsValid() {
+		return
+	}
+	propagator.Inject(trace.ContextWithSpanContext(context.Background(), sc), propagation.HeaderCarrier(header))
+}
+
+type messageKey struct{}
+
+type messageHolder struct {
+	sc trace.SpanContext
+}
+
+// 接收消息前调用, RecvMessage会将收到的消息的trace context记录在返回的ctx中
+func WithMessage(ctx context.Context) context.Context {
+	return context.WithValue(ctx, messageKey{}, &messageHolder{})
+}
+
+func SetMessage(ctx context.Context, sc trace.SpanContext) {
+	if holder, ok := ctx.Value(messageKey{}).(*messageHolder); ok {
+		holder.sc = sc
+	}
+}
+
+// 返回最近一次收到的消息的trace context
+func Message(ctx context.Context) trace.SpanContext {
+	if holder, ok := ctx.Value(messageKey{}).(*messageHolder); ok {
+		return holder.sc
+	}
+	return trace.SpanContext{}
+}
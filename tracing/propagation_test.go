@@ -0,0 +1,76 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func testSpanContext() trace.SpanContext {
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x01, 0x02, 0x03},
+		SpanID:     trace.SpanID{0x04, 0x05},
+		TraceFlags: trace.FlagsSampled,
+	})
+}
+
+func TestHeadersPropagation(t *testing.T) {
+	if headers := InjectHeaders(context.Background()); headers != nil {
+		t.Errorf("expect no headers without span, got %v", headers)
+	}
+
+	sc := testSpanContext()
+	headers := InjectHeaders(trace.ContextWithSpanContext(context.Background(), sc))
+	if len(headers) != 1 || string(headers[0].Key) != "traceparent" {
+		t.Fatalf("inject headers error: %v", headers)
+	}
+
+	// 其他业务header不影响解析
+	received := []*sarama.RecordHeader{{Key: []byte("foo"), Value: []byte("bar")}, &headers[0]}
+	got := ExtractHeaders(received)
+	if got.TraceID() != sc.TraceID() || got.SpanID() != sc.SpanID() || !got.IsSampled() {
+		t.Errorf("extract headers error: %v", got)
+	}
+	if ExtractHeaders(nil).IsValid() {
+		t.Errorf("expect invalid span context from empty headers")
+	}
+}
+
+func TestMessageContext(t *testing.T) {
+	sc := testSpanContext()
+
+	// 未调用WithMessage时忽略
+	SetMessage(context.Background(), sc)
+	if Message(context.Background()).IsValid() {
+		t.Errorf("expect invalid message span context")
+	}
+
+	ctx := WithMessage(context.Background())
+	SetMessage(ctx, sc)
+	header := http.Header{}
+	InjectHTTP(Message(ctx), header)
+
+	got := trace.SpanContextFromContext(ExtractHTTP(context.Background(), header))
+	if got.TraceID() != sc.TraceID() || got.SpanID() != sc.SpanID() {
+		t.Errorf("http propagation error: %v, header %v", got, header)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/weibocom/wqs/config"
+
+	"github.com/juju/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	instrumentationName = "github.com/weibocom/wqs"
+	defaultEndpoint     = "127.0.0.1:4318"
+	defaultServiceName  = "wqs"
+	defaultSampleRatio  = 1.0
+	shutdownTimeout     = 5 * time.Second
+)
+
+var (
+	// 未开启时otel的全局provider为noop, 只传递上游的trace context, 不产生span
+	tracer     = otel.Tracer(instrumentationName)
+	propagator = propagation.TraceContext{}
+	provider   *sdktrace.TracerProvider
+)
+
+// 读取可选的trace配置, 开启后通过OTLP/HTTP上报span
+func Init(conf *config.Config) error {
+
+	section, err := conf.GetSection("trace")
+	if err != nil || !section.GetBoolMust("enable", false) {
+		return nil
+	}
+
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(section.GetStringMust("endpoint", defaultEndpoint)),
+	}
+	if section.GetBoolMust("insecure", true) {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	ratio := section.GetFloat64Must("sample.ratio", defaultSampleRatio)
+	if ratio < 0 || ratio > 1 {
+		return errors.NotValidf("trace.sample.ratio : %v", ratio)
+	}
+
+	// 上游已经采样的请求总是记录, 否则按比例采样
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", section.GetStringMust("service.name", defaultServiceName)))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	return nil
+}
+
+// 退出前上报缓存中的span
+func Close() error {
+	if provider == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return errors.Trace(provider.Shutdown(ctx))
+}
+
+func Start(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// 记录错误并结束span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
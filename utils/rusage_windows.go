@@ -0,0 +1,40 @@
+/*
+Copyright 2009-2016 Weibo, Inc.
+
+All files licensed under the Apache License, Version 2.0 (the "License");
+you may not use these files except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"syscall"
+	"time"
+)
+
+// CPUTime return user and system cpu time used by current process.
+func CPUTime() (user time.Duration, system time.Duration) {
+	var creation, exit, kernel, usr syscall.Filetime
+	handle, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return 0, 0
+	}
+	if err = syscall.GetProcessTimes(handle, &creation, &exit, &kernel, &usr); err != nil {
+		return 0, 0
+	}
+	return filetimeDuration(usr), filetimeDuration(kernel)
+}
+
+// Filetime中的时长以100纳秒为单位
+func filetimeDuration(ft syscall.Filetime) time.Duration {
+	return time.Duration((uint64(ft.HighDateTime)<<32 | uint64(ft.LowDateTime)) * 100)
+}
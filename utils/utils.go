@@ -95,31 +95,27 @@ func (slice Int32Slice) Swap(i, j int) {
 	slice[i], slice[j] = slice[j], slice[i]
 }
 
+// pid文件放在系统的临时目录下, 文件名为进程名
+func pidFile() string {
+	_, procName := filepath.Split(os.Args[0])
+	procName = strings.TrimSuffix(procName, ".exe")
+	return filepath.Join(os.TempDir(), procName+".pid")
+}
+
 func WritePid() error {
-	pid := os.Getpid()
-	procName := os.Args[0]
-	_, procName = filepath.Split(procName)
-	return ioutil.WriteFile(
-		fmt.Sprintf("/tmp/%s.pid", procName), []byte(fmt.Sprintf("%d", pid)), 0666)
+	return WritePidWithVal(os.Getpid())
 }
 
 func WritePidWithVal(pid int) error {
-	procName := os.Args[0]
-	_, procName = filepath.Split(procName)
-	return ioutil.WriteFile(
-		fmt.Sprintf("/tmp/%s.pid", procName), []byte(fmt.Sprintf("%d", pid)), 0666)
+	return ioutil.WriteFile(pidFile(), []byte(fmt.Sprintf("%d", pid)), 0666)
 }
 
 func ClearPidFile() error {
-	procName := os.Args[0]
-	_, procName = filepath.Split(procName)
-	return os.Remove(fmt.Sprintf("/tmp/%s.pid", procName))
+	return os.Remove(pidFile())
 }
 
 func GetPid() (int, error) {
-	procName := os.Args[0]
-	_, procName = filepath.Split(procName)
-	data, err := ioutil.ReadFile(fmt.Sprintf("/tmp/%s.pid", procName))
+	data, err := ioutil.ReadFile(pidFile())
 	if err != nil {
 		return -1, err
 	}
@@ -17,9 +17,36 @@ limitations under the License.
 package utils
 
 import (
+	"os"
 	"testing"
 )
 
+func TestPidFile(t *testing.T) {
+	if err := WritePidWithVal(12345); err != nil {
+		t.Fatalf("write pid err: %v", err)
+	}
+	defer ClearPidFile()
+
+	pid, err := GetPid()
+	if err != nil || pid != 12345 {
+		t.Fatalf("get pid want 12345, now %d err %v", pid, err)
+	}
+
+	if err = ClearPidFile(); err != nil {
+		t.Fatalf("clear pid file err: %v", err)
+	}
+	if _, err = os.Stat(pidFile()); !os.IsNotExist(err) {
+		t.Fatalf("pid file should be removed, err %v", err)
+	}
+}
+
+func TestCPUTime(t *testing.T) {
+	user, system := CPUTime()
+	if user < 0 || system < 0 {
+		t.Fatalf("invalid cpu time user %v system %v", user, system)
+	}
+}
+
 func dummy(s string) {
 
 }